@@ -86,18 +86,8 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func processHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse incoming headers
-	parsed := raceway.ParseIncomingHeaders(r.Header, client.ServiceName(), client.InstanceID())
-
-	// Create context with parsed trace info
-	ctx := raceway.NewContext(r.Context(), parsed.TraceID, client.ServiceName(), client.InstanceID())
-	if rctx := raceway.FromContext(ctx); rctx != nil {
-		rctx.SpanID = parsed.SpanID
-		rctx.ParentSpanID = parsed.ParentSpanID
-		rctx.Distributed = parsed.Distributed
-		rctx.ClockVector = parsed.ClockVector
-		rctx.TraceState = parsed.TraceState
-	}
+	// Continue the trace in the incoming headers
+	ctx := client.ContextFromRequest(r)
 
 	// Track HTTP request
 	client.TrackHTTPRequest(ctx, r.Method, r.URL.Path, nil, nil)
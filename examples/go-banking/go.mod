@@ -4,11 +4,14 @@ go 1.21
 
 require (
 	github.com/gin-gonic/gin v1.9.1
-	github.com/google/uuid v1.6.0
-	github.com/mode-7/raceway-go v0.1.0
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mode7labs/raceway/sdks/go v0.0.0-00010101000000-000000000000
+	github.com/mode7labs/raceway/sdks/go/contrib/gin v0.0.0-00010101000000-000000000000
 )
 
-replace github.com/mode-7/raceway-go => ../../sdks/go
+replace github.com/mode7labs/raceway/sdks/go => ../../sdks/go
+
+replace github.com/mode7labs/raceway/sdks/go/contrib/gin => ../../sdks/go/contrib/gin
 
 require (
 	github.com/bytedance/sonic v1.9.1 // indirect
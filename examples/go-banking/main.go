@@ -3,8 +3,8 @@
 // This demonstrates how Raceway can detect race conditions in a Go/Gin banking API.
 //
 // NOTE: This example uses localhost URLs for local development and demonstration.
-//       In production, pass the Raceway server URL to NewClient():
-//       client := raceway.NewClient(os.Getenv("RACEWAY_URL"), "banking-api")
+//       In production, set Config.ServerURL from the environment:
+//       client := raceway.New(raceway.Config{ServerURL: os.Getenv("RACEWAY_URL"), ServiceName: "banking-api"})
 //
 // To run:
 // 1. Start Raceway server: cd ../.. && cargo run --release -- serve
@@ -16,6 +16,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -23,8 +25,8 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	raceway "github.com/mode-7/raceway-go"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+	racewaygin "github.com/mode7labs/raceway/sdks/go/contrib/gin"
 )
 
 // Application models
@@ -56,42 +58,36 @@ var (
 		"bob":     {Balance: 500},
 		"charlie": {Balance: 300},
 	}
-	accountsMu     sync.RWMutex
-	racewayClient  *raceway.Client
+	accountsMu    sync.RWMutex
+	racewayClient *raceway.Client
 )
 
+// Transfer failures, which roll back the transfer's transaction
+var (
+	errAccountNotFound   = errors.New("account not found")
+	errInsufficientFunds = errors.New("insufficient funds")
+)
+
+// balanceOf names the balance of account the same way in every service,
+// "accounts/alice/balance", so accesses from other SDKs are matched with ours.
+func balanceOf(account string) raceway.Resource {
+	return raceway.NewResource("accounts").ID(account).Field("balance")
+}
+
 func main() {
 	// Initialize Raceway client with optional API key from environment
-	var apiKey *string
-	if key := os.Getenv("RACEWAY_KEY"); key != "" {
-		apiKey = &key
-	}
-
-	racewayClient = raceway.NewClient(raceway.Config{
+	racewayClient = raceway.New(raceway.Config{
 		ServerURL:   "http://localhost:8080",
 		ServiceName: "banking-api",
 		Environment: "development",
 		BatchSize:   10, // Lower batch size for faster flushing
 		Debug:       true,
-		APIKey:      apiKey,
+		APIKey:      os.Getenv("RACEWAY_KEY"),
 	})
-	defer racewayClient.Stop()
+	defer racewayClient.Shutdown()
 
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.New()
-
-	// Add Raceway middleware to automatically initialize traces
-	router.Use(ginRacewayMiddleware())
-
-	// API routes
-	router.GET("/health", health)
-	router.GET("/api/accounts", getAccounts)
-	router.GET("/api/balance", getBalance)
-	router.POST("/api/transfer", transfer)
-	router.POST("/api/reset", resetAccounts)
-
-	// Serve static files for all other routes
-	router.NoRoute(gin.WrapH(http.FileServer(http.Dir("./public"))))
+	router := newRouter()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -102,41 +98,28 @@ func main() {
 	fmt.Println("🔍 Raceway integration enabled")
 	fmt.Printf("\n📊 Web UI: http://localhost:%s\n", port)
 	fmt.Println("📊 Raceway Analysis: http://localhost:8080")
-	fmt.Println("\n🚨 Click \"Trigger Race Condition\" in the UI to see the bug!\n")
+	fmt.Print("\n🚨 Click \"Trigger Race Condition\" in the UI to see the bug!\n\n")
 
 	router.Run(":" + port)
 }
 
-// ginRacewayMiddleware wraps the Raceway middleware for Gin
-func ginRacewayMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Extract or generate trace ID
-		traceID := c.GetHeader("X-Trace-ID")
-		if traceID == "" {
-			traceID = uuid.New().String()
-		}
-
-		// Create Raceway context
-		raceCtx := raceway.NewRacewayContext(traceID)
-		ctx := raceway.WithRacewayContext(c.Request.Context(), raceCtx)
-
-		// Track HTTP request
-		racewayClient.TrackFunctionCall(ctx, "http_request", map[string]interface{}{
-			"method": c.Request.Method,
-			"path":   c.Request.URL.Path,
-		})
+// newRouter returns the API, traced by racewayClient.
+func newRouter() *gin.Engine {
+	router := gin.New()
 
-		// Update request context
-		c.Request = c.Request.WithContext(ctx)
+	// Add Raceway middleware to automatically initialize traces
+	router.Use(racewaygin.Middleware(racewayClient))
 
-		// Continue with request
-		start := time.Now()
-		c.Next()
+	// API routes
+	router.GET("/health", health)
+	router.GET("/api/accounts", getAccounts)
+	router.GET("/api/balance", getBalance)
+	router.POST("/api/transfer", transfer)
+	router.POST("/api/reset", resetAccounts)
 
-		// Track HTTP response
-		duration := uint64(time.Since(start).Milliseconds())
-		racewayClient.TrackHTTPResponse(ctx, c.Writer.Status(), duration)
-	}
+	// Serve static files for all other routes
+	router.NoRoute(gin.WrapH(http.FileServer(http.Dir("./public"))))
+	return router
 }
 
 func health(c *gin.Context) {
@@ -170,13 +153,7 @@ func getBalance(c *gin.Context) {
 		return
 	}
 
-	racewayClient.TrackStateChange(
-		ctx,
-		fmt.Sprintf("%s.balance", account),
-		nil,
-		acc.Balance,
-		"Read",
-	)
+	racewayClient.TrackResourceChange(ctx, balanceOf(account), nil, acc.Balance, "Read")
 
 	c.JSON(200, acc)
 }
@@ -197,66 +174,66 @@ func transfer(c *gin.Context) {
 		"amount": req.Amount,
 	})()
 
-	// Simulate processing time (makes race more likely)
-	time.Sleep(10 * time.Millisecond)
+	// The debit and credit run as one transaction, so the server knows which
+	// reads and writes were meant to happen atomically
+	var newBalance, toBalance int64
+	err := racewayClient.WithTransaction(ctx, "transfer", func(ctx context.Context) error {
+		// Simulate processing time (makes race more likely)
+		time.Sleep(10 * time.Millisecond)
 
-	// READ: Get current balance (without holding lock - RACE CONDITION!)
-	accountsMu.RLock()
-	fromAcc, exists := accounts[req.From]
-	accountsMu.RUnlock()
+		// READ: Get current balance (without holding lock - RACE CONDITION!)
+		accountsMu.RLock()
+		fromAcc, exists := accounts[req.From]
+		accountsMu.RUnlock()
 
-	if !exists {
+		if !exists {
+			return errAccountNotFound
+		}
+
+		balance := fromAcc.Balance
+		racewayClient.TrackResourceChange(ctx, balanceOf(req.From), nil, balance, "Read")
+
+		// Check sufficient funds
+		if balance < req.Amount {
+			return errInsufficientFunds
+		}
+
+		// Simulate more processing (window for race condition!)
+		time.Sleep(10 * time.Millisecond)
+
+		// WRITE: Update balance (RACE CONDITION HERE!)
+		// The recorded old value is read under the lock, so it shows the balance
+		// that was actually overwritten rather than the stale one read above
+		newBalance = balance - req.Amount
+		racewayClient.TrackedWrite(ctx, balanceOf(req.From).String(), func() (interface{}, interface{}) {
+			accountsMu.Lock()
+			defer accountsMu.Unlock()
+			old := fromAcc.Balance
+			fromAcc.Balance = newBalance
+			return old, newBalance
+		})
+
+		// Credit the recipient
+		racewayClient.TrackedWrite(ctx, balanceOf(req.To).String(), func() (interface{}, interface{}) {
+			accountsMu.Lock()
+			defer accountsMu.Unlock()
+			toAcc := accounts[req.To]
+			old := toAcc.Balance
+			toAcc.Balance += req.Amount
+			toBalance = toAcc.Balance
+			return old, toBalance
+		})
+		return nil
+	})
+	switch err {
+	case errAccountNotFound:
 		c.JSON(404, gin.H{"error": "Account not found"})
 		return
-	}
-
-	balance := fromAcc.Balance
-	racewayClient.TrackStateChange(
-		ctx,
-		fmt.Sprintf("%s.balance", req.From),
-		nil,
-		balance,
-		"Read",
-	)
-
-	// Check sufficient funds
-	if balance < req.Amount {
+	case errInsufficientFunds:
 		c.JSON(400, gin.H{"error": "Insufficient funds"})
 		return
 	}
 
-	// Simulate more processing (window for race condition!)
-	time.Sleep(10 * time.Millisecond)
-
-	// WRITE: Update balance (RACE CONDITION HERE!)
-	newBalance := balance - req.Amount
-	accountsMu.Lock()
-	fromAcc.Balance = newBalance
-	accountsMu.Unlock()
-
-	racewayClient.TrackStateChange(
-		ctx,
-		fmt.Sprintf("%s.balance", req.From),
-		balance,
-		newBalance,
-		"Write",
-	)
-
-	// Credit the recipient
-	accountsMu.Lock()
-	toAcc := accounts[req.To]
-	oldToBalance := toAcc.Balance
-	toAcc.Balance += req.Amount
-	accountsMu.Unlock()
-
-	racewayClient.TrackStateChange(
-		ctx,
-		fmt.Sprintf("%s.balance", req.To),
-		oldToBalance,
-		toAcc.Balance,
-		"Write",
-	)
-
 	c.JSON(200, TransferResponse{
 		Success: true,
 		From: AccountInfo{
@@ -265,7 +242,7 @@ func transfer(c *gin.Context) {
 		},
 		To: AccountInfo{
 			Account:    req.To,
-			NewBalance: toAcc.Balance,
+			NewBalance: toBalance,
 		},
 	})
 }
@@ -274,7 +251,6 @@ func resetAccounts(c *gin.Context) {
 	ctx := c.Request.Context()
 	defer racewayClient.StartFunction(ctx, "resetAccounts", map[string]interface{}{})()
 
-
 	accountsMu.Lock()
 	accounts["alice"] = &Account{Balance: 1000}
 	accounts["bob"] = &Account{Balance: 500}
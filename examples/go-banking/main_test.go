@@ -0,0 +1,95 @@
+//go:build !raceway_disabled
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+	"github.com/mode7labs/raceway/sdks/go/propagation"
+	"github.com/mode7labs/raceway/sdks/go/racewaytest"
+)
+
+func TestTransferTracksBalanceReadAndWrite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := raceway.NewRecorder()
+	defer rec.Shutdown()
+	racewayClient = rec.Client
+
+	req := httptest.NewRequest("POST", "/api/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":100}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	read := racewaytest.AssertStateChange(t, rec, "accounts/alice/balance", "Read")
+	write := racewaytest.AssertStateChange(t, rec, "accounts/alice/balance", "Write")
+	if read.TraceID != write.TraceID || read.CausalityVector[0].Value() >= write.CausalityVector[0].Value() {
+		t.Error("expected the read to precede the write in the same trace")
+	}
+	credit := racewaytest.AssertStateChange(t, rec, "accounts/bob/balance", "Write")
+
+	transactions := rec.EventsOfKind("Transaction")
+	if len(transactions) != 2 || transactions[1].Kind.Transaction.Phase != raceway.TransactionCommit {
+		t.Fatalf("expected the transfer to begin and commit a transaction, got %d events", len(transactions))
+	}
+	txID := transactions[0].Kind.Transaction.TxID
+	for _, e := range []raceway.Event{read, write, credit} {
+		if e.Metadata.Tags["tx_id"] != txID {
+			t.Errorf("expected %s of %s tagged with the transfer's transaction", e.Kind.StateChange.AccessType, e.Kind.StateChange.Variable)
+		}
+	}
+}
+
+func TestTransferTraceExports(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	racewayClient = raceway.New(raceway.Config{ServiceName: "banking-api", Sink: raceway.NoopSink{}, RetainTraces: true})
+	defer racewayClient.Shutdown()
+
+	req := httptest.NewRequest("POST", "/api/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	traceID, err := propagation.TraceIDToUUID("4bf92f3577b34da6a3ce929d0e0e4736")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := racewayClient.ExportTraceByID(traceID, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var export raceway.TraceExport
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatalf("export is not valid JSON: %v", err)
+	}
+	if export.TraceID != traceID || export.ServiceName != "banking-api" || export.SDKVersion != raceway.SDKVersion {
+		t.Errorf("unexpected export header: trace %s, service %s, SDK %s", export.TraceID, export.ServiceName, export.SDKVersion)
+	}
+
+	kinds := map[string]int{}
+	for i, e := range export.Events {
+		if err := raceway.ValidateEvent(e); err != nil {
+			t.Errorf("event %d is invalid: %v", i, err)
+		}
+		if i > 0 && e.Seq <= export.Events[i-1].Seq {
+			t.Errorf("event %d is out of capture order", i)
+		}
+		kinds[e.Kind.Name()]++
+	}
+	if kinds["HttpRequest"] == 0 || kinds["StateChange"] < 3 || kinds["Transaction"] != 2 {
+		t.Errorf("expected the whole transfer exported, got %v", kinds)
+	}
+}
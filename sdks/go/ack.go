@@ -0,0 +1,119 @@
+package raceway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// maxAckBody caps the response body read for a batch acknowledgement.
+const maxAckBody = 1 << 20
+
+// maxRejectionReasons caps the distinct rejection reasons logged per client.
+const maxRejectionReasons = 64
+
+// ServerResponse summarizes the server's response to a batch.
+type ServerResponse struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Acknowledged reports whether the response body counted the accepted
+	// and rejected events. Without it, a successful response accepted all of
+	// them.
+	Acknowledged bool
+	// Accepted and Rejected are the events of the batch the server kept and
+	// refused
+	Accepted int
+	Rejected int
+}
+
+// batchAck is the optional body of a successful batch response, such as
+// {"accepted": 48, "rejected": 2, "errors": [{"index": 3, "reason": "..."}]}.
+// Indexes refer to the events of the batch.
+type batchAck struct {
+	Accepted *int `json:"accepted"`
+	Rejected *int `json:"rejected"`
+	Errors   []struct {
+		Index  int    `json:"index"`
+		Reason string `json:"reason"`
+	} `json:"errors"`
+}
+
+// parseBatchAck decodes body as a batch acknowledgement, reporting false for
+// an empty body or one that is not an acknowledgement.
+func parseBatchAck(body []byte) (batchAck, bool) {
+	var ack batchAck
+	if len(body) == 0 || json.Unmarshal(body, &ack) != nil {
+		return batchAck{}, false
+	}
+	return ack, ack.Accepted != nil || ack.Rejected != nil || len(ack.Errors) > 0
+}
+
+// rejectionLog remembers the rejection reasons already logged, so that a
+// reason repeated on every batch is logged once.
+type rejectionLog struct {
+	mu     sync.Mutex
+	logged map[string]bool
+}
+
+// first reports whether reason has not been logged before.
+func (l *rejectionLog) first(reason string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.logged[reason] || len(l.logged) >= maxRejectionReasons {
+		return false
+	}
+	if l.logged == nil {
+		l.logged = make(map[string]bool)
+	}
+	l.logged[reason] = true
+	return true
+}
+
+// acknowledge reads the body of a successful response to a batch of events.
+// Events the server reports rejected are counted as dropped and passed to
+// Config.OnEventsDropped with DropServerRejected. A body that is empty or
+// not an acknowledgement means every event was accepted.
+func (c *Client) acknowledge(events []Event, resp *http.Response) {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxAckBody))
+	summary := ServerResponse{StatusCode: resp.StatusCode, Accepted: len(events)}
+	ack, ok := parseBatchAck(body)
+	if !ok {
+		c.stats.lastResponse.Store(summary)
+		return
+	}
+
+	var rejected []Event
+	seen := make(map[int]bool, len(ack.Errors))
+	for _, e := range ack.Errors {
+		if e.Index < 0 || e.Index >= len(events) || seen[e.Index] {
+			continue
+		}
+		seen[e.Index] = true
+		rejected = append(rejected, events[e.Index])
+		if c.rejections.first(e.Reason) {
+			c.logger.Warnf("Server rejected %s event %s: %s", events[e.Index].Kind.Name(), events[e.Index].ID, e.Reason)
+		}
+	}
+
+	summary.Acknowledged = true
+	summary.Rejected = len(rejected)
+	if ack.Rejected != nil && *ack.Rejected > summary.Rejected {
+		summary.Rejected = *ack.Rejected
+		if c.rejections.first("") {
+			c.logger.Warnf("Server rejected %d events without identifying all of them", *ack.Rejected)
+		}
+	}
+	summary.Accepted = len(events) - summary.Rejected
+	if ack.Accepted != nil {
+		summary.Accepted = *ack.Accepted
+	}
+	c.stats.lastResponse.Store(summary)
+
+	if len(rejected) > 0 {
+		// Not reported back to the server as dropped, since it dropped them
+		c.stats.rejected.Add(uint64(len(rejected)))
+		c.stats.dropped.Add(uint64(len(rejected)))
+		c.eventsDropped(DropServerRejected, rejected)
+	}
+}
@@ -0,0 +1,155 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ackServer starts a server that answers every request with status and
+// body, and returns its URL.
+func ackServer(t *testing.T, status int, body string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// trackSteps captures n custom events and returns their IDs in order.
+func trackSteps(c *Client, n int) []string {
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	var ids []string
+	for i := 0; i < n; i++ {
+		ids = append(ids, c.TrackCustom(ctx, "step", map[string]interface{}{"i": i}))
+	}
+	return ids
+}
+
+func TestServerAcknowledgesFullBatch(t *testing.T) {
+	for _, body := range []string{"", `{"accepted":3,"rejected":0}`} {
+		c := newBufferingClient(t, func(cfg *Config) { cfg.ServerURL = ackServer(t, http.StatusOK, body) })
+		trackSteps(c, 3)
+		if err := c.FlushContext(context.Background()); err != nil {
+			t.Fatalf("body %q: %v", body, err)
+		}
+
+		stats := c.Stats()
+		if stats.EventsSent != 3 || stats.EventsDropped != 0 || stats.EventsRejected != 0 {
+			t.Errorf("body %q: expected 3 sent and none dropped, got %+v", body, stats)
+		}
+		want := ServerResponse{StatusCode: http.StatusOK, Acknowledged: body != "", Accepted: 3}
+		if stats.LastServerResponse != want {
+			t.Errorf("body %q: expected %+v, got %+v", body, want, stats.LastServerResponse)
+		}
+	}
+}
+
+func TestServerRejectsEventsInBatch(t *testing.T) {
+	body := `{"accepted":2,"rejected":2,"errors":[` +
+		`{"index":1,"reason":"clock vector too large"},{"index":3,"reason":"clock vector too large"}]}`
+	for _, status := range []int{http.StatusOK, http.StatusPartialContent, http.StatusMultiStatus} {
+		drops := make(dropRecorder, 8)
+		logger := &capturingLogger{}
+		c := newBufferingClient(t, func(cfg *Config) {
+			cfg.ServerURL = ackServer(t, status, body)
+			cfg.OnEventsDropped = drops.hook
+			cfg.Logger = logger
+		})
+		ids := trackSteps(c, 4)
+		if err := c.FlushContext(context.Background()); err != nil {
+			t.Fatalf("status %d: expected the batch accepted, got %v", status, err)
+		}
+
+		events := drops.wait(t, DropServerRejected, 2)
+		if events[0].ID != ids[1] || events[1].ID != ids[3] {
+			t.Errorf("status %d: expected events %s and %s rejected, got %s and %s", status, ids[1], ids[3], events[0].ID, events[1].ID)
+		}
+		stats := c.Stats()
+		if stats.EventsSent != 4 || stats.EventsRejected != 2 || stats.EventsDropped != 2 {
+			t.Errorf("status %d: expected 4 sent, 2 rejected and dropped, got %+v", status, stats)
+		}
+		want := ServerResponse{StatusCode: status, Acknowledged: true, Accepted: 2, Rejected: 2}
+		if stats.LastServerResponse != want {
+			t.Errorf("status %d: expected %+v, got %+v", status, want, stats.LastServerResponse)
+		}
+		if warnings := logger.logged("warn"); len(warnings) != 1 || !strings.Contains(warnings[0], "clock vector too large") {
+			t.Errorf("status %d: expected the reason logged once, got %q", status, warnings)
+		}
+	}
+}
+
+func TestPartialIngestionIsNotResent(t *testing.T) {
+	// The Raceway server answers 206 when it failed to ingest some events
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = ackServer(t, http.StatusPartialContent, `{"success":true,"data":"Ingested 2 events, 1 errors"}`)
+	})
+	trackSteps(c, 3)
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("expected the batch accepted, got %v", err)
+	}
+	if stats := c.Stats(); stats.EventsSent != 3 || stats.EventsBuffered != 0 || stats.EventsRequeued != 0 {
+		t.Errorf("expected the batch delivered and not requeued, got %+v", stats)
+	}
+}
+
+func TestServerRejectionIgnoresBadIndexes(t *testing.T) {
+	drops := make(dropRecorder, 8)
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = ackServer(t, http.StatusOK, `{"errors":[{"index":-1,"reason":"a"},{"index":7,"reason":"b"},`+
+			`{"index":0,"reason":"c"},{"index":0,"reason":"c"}]}`)
+		cfg.OnEventsDropped = drops.hook
+	})
+	ids := trackSteps(c, 2)
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if events := drops.wait(t, DropServerRejected, 1); len(events) != 1 || events[0].ID != ids[0] {
+		t.Errorf("expected only event %s rejected, got %v", ids[0], events)
+	}
+	if stats := c.Stats(); stats.EventsRejected != 1 || stats.LastServerResponse.Accepted != 1 {
+		t.Errorf("expected 1 rejected and 1 accepted, got %+v", stats)
+	}
+}
+
+func TestServerMalformedAcknowledgementAcceptsBatch(t *testing.T) {
+	for _, body := range []string{"not json", `{"status":"ok"}`, `[1,2]`} {
+		drops := make(dropRecorder, 8)
+		c := newBufferingClient(t, func(cfg *Config) {
+			cfg.ServerURL = ackServer(t, http.StatusOK, body)
+			cfg.OnEventsDropped = drops.hook
+		})
+		trackSteps(c, 2)
+		if err := c.FlushContext(context.Background()); err != nil {
+			t.Fatalf("body %q: %v", body, err)
+		}
+
+		stats := c.Stats()
+		if stats.EventsSent != 2 || stats.EventsDropped != 0 {
+			t.Errorf("body %q: expected 2 sent and none dropped, got %+v", body, stats)
+		}
+		if want := (ServerResponse{StatusCode: http.StatusOK, Accepted: 2}); stats.LastServerResponse != want {
+			t.Errorf("body %q: expected %+v, got %+v", body, want, stats.LastServerResponse)
+		}
+		if len(drops) != 0 {
+			t.Errorf("body %q: expected no drops reported", body)
+		}
+	}
+}
+
+func TestServerErrorRecordsLastResponse(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ServerURL = ackServer(t, http.StatusForbidden, `{"rejected":2}`) })
+	trackSteps(c, 2)
+	if err := c.FlushContext(context.Background()); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	if want := (ServerResponse{StatusCode: http.StatusForbidden}); c.Stats().LastServerResponse != want {
+		t.Errorf("expected %+v, got %+v", want, c.Stats().LastServerResponse)
+	}
+}
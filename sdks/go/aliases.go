@@ -0,0 +1,181 @@
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// aliasRule is a compiled pattern alias such as "accounts[%s].balance" -> "account:%s:balance".
+type aliasRule struct {
+	source   string
+	target   string
+	pattern  *regexp.Regexp
+	captures int
+}
+
+// aliasTable rewrites legacy names to their canonical form.
+// It is compiled once in New and is read-only afterwards, so lookups need no locking.
+type aliasTable struct {
+	exact    map[string]string
+	patterns []aliasRule
+}
+
+// compileAliases builds an alias table from an old->new mapping.
+// Sources may contain "%s" (captured segment) and "*" (uncaptured wildcard);
+// targets may reference captures with "%s" in order. Chained aliases, where a
+// target would itself be rewritten by another alias, are rejected.
+func compileAliases(aliases map[string]string) (*aliasTable, error) {
+	if len(aliases) == 0 {
+		return nil, nil
+	}
+
+	table := &aliasTable{exact: make(map[string]string)}
+
+	sources := make([]string, 0, len(aliases))
+	for source := range aliases {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		target := aliases[source]
+		if source == "" || target == "" {
+			return nil, fmt.Errorf("raceway: alias %q -> %q must not be empty", source, target)
+		}
+		if !strings.Contains(source, "%s") && !strings.Contains(source, "*") {
+			table.exact[source] = target
+			continue
+		}
+
+		pattern, captures := aliasPatternToRegexp(source)
+		if strings.Count(target, "%s") > captures {
+			return nil, fmt.Errorf("raceway: alias %q -> %q references more captures than the source defines", source, target)
+		}
+		table.patterns = append(table.patterns, aliasRule{
+			source:   source,
+			target:   target,
+			pattern:  pattern,
+			captures: captures,
+		})
+	}
+
+	for _, source := range sources {
+		target := aliases[source]
+		if strings.Contains(target, "%s") {
+			// Probe pattern targets with a representative capture value.
+			target = strings.ReplaceAll(target, "%s", "x")
+		}
+		if _, chained, ok := table.rewrite(target); ok {
+			return nil, fmt.Errorf("raceway: alias %q -> %q is chained through %q", source, aliases[source], chained)
+		}
+	}
+
+	return table, nil
+}
+
+func aliasPatternToRegexp(source string) (*regexp.Regexp, int) {
+	var b strings.Builder
+	b.WriteString("^")
+	captures := 0
+	for i := 0; i < len(source); i++ {
+		switch {
+		case strings.HasPrefix(source[i:], "%s"):
+			b.WriteString("(.+?)")
+			captures++
+			i++
+		case source[i] == '*':
+			b.WriteString(".*?")
+		default:
+			b.WriteString(regexp.QuoteMeta(source[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String()), captures
+}
+
+// rewrite returns the canonical name for value and the alias source that matched.
+func (t *aliasTable) rewrite(value string) (string, string, bool) {
+	if t == nil {
+		return value, "", false
+	}
+	if target, ok := t.exact[value]; ok {
+		return target, value, true
+	}
+	for _, rule := range t.patterns {
+		match := rule.pattern.FindStringSubmatch(value)
+		if match == nil {
+			continue
+		}
+		result := rule.target
+		for _, capture := range match[1:] {
+			if !strings.Contains(result, "%s") {
+				break
+			}
+			result = strings.Replace(result, "%s", capture, 1)
+		}
+		return result, rule.source, true
+	}
+	return value, "", false
+}
+
+// applyAliases rewrites variable names and lock IDs in kind to their canonical form.
+// It returns the tags to attach to the event, or nil if nothing was rewritten.
+func (c *Client) applyAliases(kind EventKind) map[string]string {
+	var original string
+	switch {
+	case kind.StateChange != nil && c.variableAliases != nil:
+		if canonical, _, ok := c.variableAliases.rewrite(kind.StateChange.Variable); ok {
+			original = kind.StateChange.Variable
+			kind.StateChange.Variable = canonical
+		}
+	case kind.LockAcquire != nil && c.lockAliases != nil:
+		if canonical, _, ok := c.lockAliases.rewrite(kind.LockAcquire.LockID); ok {
+			original = kind.LockAcquire.LockID
+			kind.LockAcquire.LockID = canonical
+		}
+	case kind.LockRelease != nil && c.lockAliases != nil:
+		if canonical, _, ok := c.lockAliases.rewrite(kind.LockRelease.LockID); ok {
+			original = kind.LockRelease.LockID
+			kind.LockRelease.LockID = canonical
+		}
+	case kind.LockContention != nil && c.lockAliases != nil:
+		if canonical, _, ok := c.lockAliases.rewrite(kind.LockContention.LockID); ok {
+			original = kind.LockContention.LockID
+			kind.LockContention.LockID = canonical
+		}
+	}
+
+	if original == "" {
+		return nil
+	}
+	if period := c.config.AliasTransitionPeriod; period > 0 && time.Since(c.startedAt) > period {
+		return nil
+	}
+	return map[string]string{"aliased_from": original}
+}
+
+// emitAliasManifest records the active aliases once at startup so the server can
+// retroactively merge series recorded under the old names.
+func (c *Client) emitAliasManifest() {
+	if len(c.config.VariableAliases) == 0 && len(c.config.LockAliases) == 0 {
+		return
+	}
+
+	ctx := c.newContext(context.Background(), "")
+	c.captureEventWith(ctx, EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "alias_manifest",
+			Module:       "raceway",
+			Args: map[string]interface{}{
+				"variables": c.config.VariableAliases,
+				"locks":     c.config.LockAliases,
+			},
+			File: "raceway",
+			Line: 0,
+		},
+	}, captureOptions{tags: map[string]string{"raceway_event": "alias_manifest"}})
+}
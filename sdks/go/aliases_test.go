@@ -0,0 +1,166 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileAliases(t *testing.T) {
+	t.Run("exact alias", func(t *testing.T) {
+		table, err := compileAliases(map[string]string{"alice.balance": "account:alice:balance"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, source, ok := table.rewrite("alice.balance")
+		if !ok || got != "account:alice:balance" || source != "alice.balance" {
+			t.Errorf("expected exact rewrite, got %q (source %q, ok %v)", got, source, ok)
+		}
+		if _, _, ok := table.rewrite("bob.balance"); ok {
+			t.Error("expected unrelated variable to be left alone")
+		}
+	})
+
+	t.Run("pattern captures", func(t *testing.T) {
+		table, err := compileAliases(map[string]string{
+			"accounts[%s].balance": "account:%s:balance",
+			"ledger/%s/%s":         "ledger:%s:%s",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cases := map[string]string{
+			"accounts[alice].balance": "account:alice:balance",
+			"accounts[a.b].balance":   "account:a.b:balance",
+			"ledger/2024/q1":          "ledger:2024:q1",
+		}
+		for input, want := range cases {
+			if got, _, _ := table.rewrite(input); got != want {
+				t.Errorf("rewrite(%q) = %q, want %q", input, got, want)
+			}
+		}
+		if _, _, ok := table.rewrite("accounts[].balance"); ok {
+			t.Error("expected empty capture not to match")
+		}
+	})
+
+	t.Run("wildcard without capture", func(t *testing.T) {
+		table, err := compileAliases(map[string]string{"cache.*.hits": "cache_hits"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _, _ := table.rewrite("cache.users.hits"); got != "cache_hits" {
+			t.Errorf("expected wildcard rewrite, got %q", got)
+		}
+	})
+
+	t.Run("chained aliases rejected", func(t *testing.T) {
+		_, err := compileAliases(map[string]string{
+			"a": "b",
+			"b": "c",
+		})
+		if err == nil || !strings.Contains(err.Error(), "chained") {
+			t.Errorf("expected chained alias error, got %v", err)
+		}
+
+		_, err = compileAliases(map[string]string{
+			"accounts[%s].balance": "account:%s:balance",
+			"account:*":            "acct",
+		})
+		if err == nil {
+			t.Error("expected pattern chain to be rejected")
+		}
+	})
+
+	t.Run("too many target captures rejected", func(t *testing.T) {
+		if _, err := compileAliases(map[string]string{"x.%s": "%s:%s"}); err == nil {
+			t.Error("expected error for unbalanced captures")
+		}
+	})
+}
+
+func TestAliasesAppliedAtCapture(t *testing.T) {
+	client := newBufferingClient(t, func(c *Config) {
+		c.VariableAliases = map[string]string{"accounts[%s].balance": "account:%s:balance"}
+		c.LockAliases = map[string]string{"account_lock": "lock:accounts"}
+	})
+
+	ctx := NewContext(context.Background(), "trace-1", "test-service", "test-instance")
+	client.TrackStateChange(ctx, "accounts[alice].balance", 100, 50, "aliases_test.go:1", "Write")
+	client.TrackStateChange(ctx, "other", 1, 2, "aliases_test.go:2", "Write")
+	client.TrackLockAcquire(ctx, "account_lock", "Mutex")
+
+	events := bufferedEvents(client)
+	if len(events) != 4 {
+		t.Fatalf("expected manifest + 3 events, got %d", len(events))
+	}
+
+	write := events[1]
+	if write.Kind.StateChange.Variable != "account:alice:balance" {
+		t.Errorf("expected canonical variable, got %s", write.Kind.StateChange.Variable)
+	}
+	if write.Metadata.Tags["aliased_from"] != "accounts[alice].balance" {
+		t.Errorf("expected aliased_from tag, got %v", write.Metadata.Tags)
+	}
+	if _, ok := events[2].Metadata.Tags["aliased_from"]; ok {
+		t.Error("expected no aliased_from tag on unaliased variable")
+	}
+	if events[3].Kind.LockAcquire.LockID != "lock:accounts" {
+		t.Errorf("expected canonical lock ID, got %s", events[3].Kind.LockAcquire.LockID)
+	}
+}
+
+func TestAliasTransitionPeriodExpires(t *testing.T) {
+	client := newBufferingClient(t, func(c *Config) {
+		c.VariableAliases = map[string]string{"old": "new"}
+		c.AliasTransitionPeriod = time.Nanosecond
+	})
+	time.Sleep(time.Millisecond)
+
+	ctx := NewContext(context.Background(), "trace-1", "test-service", "test-instance")
+	client.TrackStateChange(ctx, "old", nil, 1, "aliases_test.go:1", "Write")
+
+	events := bufferedEvents(client)
+	last := events[len(events)-1]
+	if last.Kind.StateChange.Variable != "new" {
+		t.Errorf("expected rewrite to continue after transition, got %s", last.Kind.StateChange.Variable)
+	}
+	if _, ok := last.Metadata.Tags["aliased_from"]; ok {
+		t.Error("expected aliased_from tag to stop after the transition period")
+	}
+}
+
+func TestAliasManifestEmittedOnce(t *testing.T) {
+	client := newBufferingClient(t, func(c *Config) {
+		c.VariableAliases = map[string]string{"old": "new"}
+	})
+
+	ctx := NewContext(context.Background(), "trace-1", "test-service", "test-instance")
+	client.TrackStateChange(ctx, "old", nil, 1, "aliases_test.go:1", "Write")
+
+	manifests := 0
+	for _, event := range bufferedEvents(client) {
+		if event.Metadata.Tags["raceway_event"] == "alias_manifest" {
+			manifests++
+			var args struct {
+				Variables map[string]string `json:"variables"`
+			}
+			json.Unmarshal(event.Kind.FunctionCall.Args.(json.RawMessage), &args)
+			if args.Variables["old"] != "new" {
+				t.Errorf("expected manifest to list aliases, got %v", args)
+			}
+		}
+	}
+	if manifests != 1 {
+		t.Errorf("expected exactly one manifest event, got %d", manifests)
+	}
+
+	plain := newBufferingClient(t, nil)
+	if len(bufferedEvents(plain)) != 0 {
+		t.Error("expected no manifest without aliases")
+	}
+}
@@ -0,0 +1,122 @@
+package raceway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// AnnotationSecretHeader carries Config.AnnotationSecret on AnnotateHandler requests.
+const AnnotationSecretHeader = "X-Raceway-Annotation-Secret"
+
+// maxAnnotationBody bounds AnnotateHandler request bodies.
+const maxAnnotationBody = 64 * 1024
+
+// Annotate records a note at the current position in the trace, for example
+// "switched reads to the replica" during an incident. It returns the event ID.
+func (c *Client) Annotate(ctx context.Context, message string, attrs map[string]string) string {
+	if BuildDisabled || c.disabled {
+		return ""
+	}
+	return c.annotate(ctx, message, attrs, false, c.captureLocation(2))
+}
+
+// Annotate records a note in the trace of ctx using the Client bound to it.
+// It is a no-op if ctx has not been used with a Client.
+func Annotate(ctx context.Context, message string, attrs map[string]string) string {
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return ""
+	}
+	client := rctx.boundClient()
+	if client == nil {
+		return ""
+	}
+	return client.annotate(ctx, message, attrs, false, client.captureLocation(2))
+}
+
+func (c *Client) annotate(ctx context.Context, message string, attrs map[string]string, outOfBand bool, location string) string {
+	var opts captureOptions
+	if outOfBand {
+		opts.tags = map[string]string{"out_of_band": "true"}
+	}
+	return c.captureEventWith(ctx, EventKind{
+		Annotation: &AnnotationData{
+			Message:   message,
+			Attrs:     copyTags(attrs),
+			OutOfBand: outOfBand,
+			Location:  location,
+		},
+	}, opts)
+}
+
+// annotationRequest is the body accepted by AnnotateHandler.
+type annotationRequest struct {
+	TraceID string            `json:"trace_id"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs"`
+}
+
+// AnnotateHandler returns an admin endpoint that adds an annotation to an
+// existing trace. It accepts POST requests with a JSON body of the form
+// {"trace_id": "...", "message": "...", "attrs": {...}} and the shared secret
+// in the X-Raceway-Annotation-Secret header.
+//
+// The annotation is recorded on a synthetic context bound to the trace and is
+// marked out_of_band, since it has no causal position relative to the trace's events.
+func (c *Client) AnnotateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		secret := c.config.AnnotationSecret
+		provided := r.Header.Get(AnnotationSecretHeader)
+		if secret == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var body annotationRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationBody)).Decode(&body); err != nil {
+			http.Error(w, "invalid annotation body", http.StatusBadRequest)
+			return
+		}
+		if _, err := uuid.Parse(body.TraceID); err != nil || body.Message == "" {
+			http.Error(w, "trace_id must be a UUID and message must not be empty", http.StatusBadRequest)
+			return
+		}
+
+		ctx := c.newContext(context.Background(), body.TraceID)
+		eventID := c.annotate(ctx, body.Message, body.Attrs, true, "raceway:annotate")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"event_id": eventID, "trace_id": body.TraceID})
+	})
+}
+
+// downgradeAnnotation re-encodes an Annotation event as a FunctionCall for
+// collectors that do not understand the Annotation kind.
+func downgradeAnnotation(event Event) Event {
+	data := event.Kind.Annotation
+	args := make(map[string]interface{}, len(data.Attrs)+2)
+	for k, v := range data.Attrs {
+		args[k] = v
+	}
+	args["message"] = data.Message
+	args["out_of_band"] = data.OutOfBand
+	event.Kind = EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "annotation",
+			Module:       "raceway.annotation",
+			Args:         args,
+			File:         data.Location,
+		},
+	}
+	return event
+}
@@ -0,0 +1,114 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnnotateRecordsCausalPosition(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "flag", false, true, "annotate_test.go:1", "Write")
+	id := c.Annotate(ctx, "flipped the flag", map[string]string{"flag": "new-checkout"})
+	Annotate(ctx, "package-level note", nil)
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	write, note, pkgNote := events[0], events[1], events[2]
+	if note.ID != id || note.Kind.Annotation == nil || note.Kind.Annotation.Message != "flipped the flag" {
+		t.Fatalf("unexpected annotation event %+v", note.Kind)
+	}
+	if note.Kind.Annotation.OutOfBand || note.Metadata.Tags["out_of_band"] != "" {
+		t.Errorf("in-band annotation must not be marked out of band")
+	}
+	if note.ParentID == nil || *note.ParentID != write.ID || note.Metadata.ThreadID != write.Metadata.ThreadID {
+		t.Errorf("annotation should follow the previous event on the same thread")
+	}
+	if !dominates(note.CausalityVector, write.CausalityVector) {
+		t.Errorf("annotation vector %v should dominate %v", note.CausalityVector, write.CausalityVector)
+	}
+	if !strings.HasPrefix(note.Kind.Annotation.Location, "annotate_test.go:") {
+		t.Errorf("expected caller location, got %q", note.Kind.Annotation.Location)
+	}
+	if pkgNote.Kind.Annotation == nil || !strings.HasPrefix(pkgNote.Kind.Annotation.Location, "annotate_test.go:") {
+		t.Errorf("expected package-level annotation, got %+v", pkgNote.Kind)
+	}
+}
+
+func TestAnnotateWithoutBoundClientIsNoop(t *testing.T) {
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	if id := Annotate(ctx, "note", nil); id != "" {
+		t.Errorf("expected no annotation without a bound client, got %s", id)
+	}
+}
+
+func TestAnnotateHandler(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.AnnotationSecret = "s3cret" })
+	handler := c.AnnotateHandler()
+	traceID := "4bf92f35-77b3-4da6-a3ce-929d0e0e4736"
+
+	post := func(secret, body string) int {
+		req := httptest.NewRequest("POST", "/annotate", strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set(AnnotationSecretHeader, secret)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	body := `{"trace_id":"` + traceID + `","message":"failover started","attrs":{"by":"oncall"}}`
+	if code := post("", body); code != http.StatusUnauthorized {
+		t.Errorf("missing secret: status %d", code)
+	}
+	if code := post("wrong", body); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d", code)
+	}
+	if code := post("s3cret", `{"trace_id":"not-a-uuid","message":"x"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid trace id: status %d", code)
+	}
+	if len(bufferedEvents(c)) != 0 {
+		t.Fatalf("rejected requests must not emit events")
+	}
+	if code := post("s3cret", body); code != http.StatusAccepted {
+		t.Fatalf("valid request: status %d", code)
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 1 {
+		t.Fatalf("expected one annotation, got %d", len(events))
+	}
+	event := events[0]
+	if event.TraceID != traceID || event.Kind.Annotation == nil || !event.Kind.Annotation.OutOfBand {
+		t.Errorf("expected out-of-band annotation on %s, got %+v", traceID, event)
+	}
+	if event.Metadata.Tags["out_of_band"] != "true" || event.Kind.Annotation.Attrs["by"] != "oncall" {
+		t.Errorf("unexpected annotation metadata %+v", event.Metadata.Tags)
+	}
+}
+
+func TestAnnotateHandlerRequiresConfiguredSecret(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	req := httptest.NewRequest("POST", "/annotate", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	c.AnnotateHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected handler to be disabled without a secret, got %d", rec.Code)
+	}
+}
+
+func TestAnnotationDowngradedWithoutCapability(t *testing.T) {
+	event := downgradeAnnotation(Event{Kind: EventKind{Annotation: &AnnotationData{Message: "note", Attrs: map[string]string{"k": "v"}}}})
+	call := event.Kind.FunctionCall
+	if call == nil || call.FunctionName != "annotation" || call.Args.(map[string]interface{})["message"] != "note" {
+		t.Errorf("unexpected downgraded annotation %+v", event.Kind)
+	}
+}
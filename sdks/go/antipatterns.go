@@ -0,0 +1,332 @@
+package raceway
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Anti-pattern identifiers reported in AntiPatternData.Pattern.
+const (
+	// AntiPatternUnprotectedWrite is a write made without any lock to a variable
+	// whose previous writes were all protected by the same lock.
+	AntiPatternUnprotectedWrite = "unprotected_write"
+	// AntiPatternReadReleaseWrite is a read and later write of the same variable
+	// in one context, with the lock that covered the read released in between.
+	AntiPatternReadReleaseWrite = "read_release_write"
+	// AntiPatternLockReacquire is a lock released and immediately re-acquired
+	// between a read and a write of the same variable, splitting one critical
+	// section in two.
+	AntiPatternLockReacquire = "lock_reacquire"
+)
+
+const (
+	// antiPatternMinWrites is how many protected writes establish a variable's lock association.
+	antiPatternMinWrites = 2
+	// antiPatternMaxVariables bounds the process-wide lock association registry.
+	antiPatternMaxVariables = 1024
+	// antiPatternMaxThreads bounds the per-context histories kept between batches.
+	antiPatternMaxThreads = 1024
+	// antiPatternReportInterval rate-limits warnings per (pattern, location) pair.
+	antiPatternReportInterval = time.Minute
+)
+
+// antiPatternDetector recognizes cheap, purely local race shapes in the event
+// stream. It runs on the flush path over each batch, never on the capture path,
+// and reconstructs per-context lock state from LockAcquire/LockRelease events.
+type antiPatternDetector struct {
+	mu       sync.Mutex
+	threads  map[string]*threadHistory
+	order    []string
+	locks    map[string]*lockAssociation
+	lockKeys []string
+	reported map[string]time.Time
+	now      func() time.Time
+	// eventID generates the IDs of warning events, in the client's IDFormat
+	eventID func() string
+}
+
+type accessRecord struct {
+	eventID  string
+	location string
+	locks    map[string]bool
+	// released is the first covering lock released after the access, if any
+	released string
+}
+
+// threadHistory is the recent state of one virtual thread.
+type threadHistory struct {
+	held map[string]int
+	// reads holds the most recent read of each variable
+	reads map[string]accessRecord
+	// section holds variables read while each lock was held
+	section map[string]map[string]bool
+	// lastRelease is the previous event if it was a lock release
+	lastRelease *lockEvent
+	// reacquired maps a lock to the release that immediately preceded its re-acquisition,
+	// together with the variables read before that release
+	reacquired map[string]reacquireRecord
+}
+
+type lockEvent struct {
+	lockID   string
+	eventID  string
+	location string
+	accessed map[string]bool
+}
+
+type reacquireRecord struct {
+	release  lockEvent
+	reported bool
+}
+
+// lockAssociation is the set of locks held by every protected write to a variable.
+type lockAssociation struct {
+	writes int
+	common map[string]bool
+	last   accessRecord
+}
+
+func newAntiPatternDetector(eventID func() string) *antiPatternDetector {
+	return &antiPatternDetector{
+		threads:  make(map[string]*threadHistory),
+		locks:    make(map[string]*lockAssociation),
+		reported: make(map[string]time.Time),
+		now:      time.Now,
+		eventID:  eventID,
+	}
+}
+
+// inspect scans a batch in order and returns warning events for any anti-patterns found.
+func (d *antiPatternDetector) inspect(events []Event) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var warnings []Event
+	for i := range events {
+		event := &events[i]
+		if event.Kind.AntiPattern != nil {
+			continue
+		}
+		thread := d.thread(event.Metadata.ThreadID)
+		previousRelease := thread.lastRelease
+		thread.lastRelease = nil
+
+		switch {
+		case event.Kind.LockAcquire != nil:
+			lockID := event.Kind.LockAcquire.LockID
+			thread.held[lockID]++
+			if thread.held[lockID] == 1 {
+				thread.section[lockID] = make(map[string]bool)
+			}
+			if previousRelease != nil && previousRelease.lockID == lockID {
+				thread.reacquired[lockID] = reacquireRecord{release: *previousRelease}
+			}
+
+		case event.Kind.LockRelease != nil:
+			lockID := event.Kind.LockRelease.LockID
+			release := &lockEvent{
+				lockID:   lockID,
+				eventID:  event.ID,
+				location: event.Kind.LockRelease.Location,
+				accessed: thread.section[lockID],
+			}
+			if thread.held[lockID] > 0 {
+				thread.held[lockID]--
+			}
+			if thread.held[lockID] == 0 {
+				delete(thread.held, lockID)
+				delete(thread.section, lockID)
+				delete(thread.reacquired, lockID)
+				// A read covered by this lock is now stale if it is written later.
+				for variable, read := range thread.reads {
+					if read.locks[lockID] && read.released == "" {
+						read.released = lockID
+						thread.reads[variable] = read
+					}
+				}
+			}
+			thread.lastRelease = release
+
+		default:
+			// Atomic accesses need no lock
+			if access, ok := event.Kind.stateAccess(); ok && !isAtomicAccess(access.accessType) {
+				warnings = append(warnings, d.inspectAccess(event, access, thread)...)
+			}
+		}
+	}
+	return warnings
+}
+
+func (d *antiPatternDetector) inspectAccess(event *Event, access stateAccess, thread *threadHistory) []Event {
+	var warnings []Event
+	variable := access.variable
+	locks := thread.heldSet(event.LockSet)
+	if access.accessType == "Read" {
+		for lockID := range thread.held {
+			thread.section[lockID][variable] = true
+		}
+	}
+
+	reacquireFired := false
+	if access.accessType != "Read" {
+		for lockID, record := range thread.reacquired {
+			if record.reported || !record.release.accessed[variable] {
+				continue
+			}
+			record.reported = true
+			thread.reacquired[lockID] = record
+			reacquireFired = true
+			warnings = d.report(warnings, event, AntiPatternLockReacquire, variable, lockID,
+				fmt.Sprintf("lock %s was released and immediately re-acquired between accesses to %s", lockID, variable),
+				[]string{record.release.eventID, event.ID},
+				[]string{record.release.location, access.location})
+		}
+	}
+
+	if access.accessType == "Read" {
+		thread.reads[variable] = accessRecord{eventID: event.ID, location: access.location, locks: locks}
+		return warnings
+	}
+
+	if read, ok := thread.reads[variable]; ok {
+		delete(thread.reads, variable)
+		if read.released != "" && !reacquireFired {
+			warnings = d.report(warnings, event, AntiPatternReadReleaseWrite, variable, read.released,
+				fmt.Sprintf("%s was read under %s, which was released before the write", variable, read.released),
+				[]string{read.eventID, event.ID},
+				[]string{read.location, access.location})
+		}
+	}
+
+	assoc := d.association(variable)
+	if len(locks) == 0 {
+		if assoc.writes >= antiPatternMinWrites && len(assoc.common) > 0 {
+			lockID := firstKey(assoc.common)
+			warnings = d.report(warnings, event, AntiPatternUnprotectedWrite, variable, lockID,
+				fmt.Sprintf("%s written without a lock; previous writes held %s", variable, lockID),
+				[]string{assoc.last.eventID, event.ID},
+				[]string{assoc.last.location, access.location})
+		}
+		return warnings
+	}
+
+	if assoc.writes == 0 {
+		assoc.common = copyLockSet(locks)
+	} else {
+		for lockID := range assoc.common {
+			if !locks[lockID] {
+				delete(assoc.common, lockID)
+			}
+		}
+	}
+	assoc.writes++
+	assoc.last = accessRecord{eventID: event.ID, location: access.location}
+	return warnings
+}
+
+// report appends a warning event unless the (pattern, location) pair was
+// reported recently. The trigger's location is the last of locations.
+func (d *antiPatternDetector) report(warnings []Event, trigger *Event, pattern, variable, lockID, message string, eventIDs, locations []string) []Event {
+	key := pattern + "|" + locations[len(locations)-1]
+	now := d.now()
+	if last, ok := d.reported[key]; ok && now.Sub(last) < antiPatternReportInterval {
+		return warnings
+	}
+	d.reported[key] = now
+
+	parentID := trigger.ID
+	tags := make(map[string]string, len(trigger.Metadata.Tags)+1)
+	for k, v := range trigger.Metadata.Tags {
+		tags[k] = v
+	}
+	tags["anti_pattern"] = pattern
+	metadata := trigger.Metadata
+	metadata.Tags = tags
+
+	vector := make([]CausalityEntry, len(trigger.CausalityVector))
+	copy(vector, trigger.CausalityVector)
+
+	return append(warnings, Event{
+		ID:        d.eventID(),
+		TraceID:   trigger.TraceID,
+		ParentID:  &parentID,
+		Timestamp: now.UTC().Format(time.RFC3339Nano),
+		Kind: EventKind{
+			AntiPattern: &AntiPatternData{
+				Pattern:   pattern,
+				Variable:  variable,
+				LockID:    lockID,
+				Message:   message,
+				EventIDs:  eventIDs,
+				Locations: locations,
+			},
+		},
+		Metadata:        metadata,
+		CausalityVector: vector,
+		LockSet:         []string{},
+	})
+}
+
+func (d *antiPatternDetector) thread(threadID string) *threadHistory {
+	if thread, ok := d.threads[threadID]; ok {
+		return thread
+	}
+	if len(d.order) >= antiPatternMaxThreads {
+		delete(d.threads, d.order[0])
+		d.order = d.order[1:]
+	}
+	thread := &threadHistory{
+		held:       make(map[string]int),
+		reads:      make(map[string]accessRecord),
+		section:    make(map[string]map[string]bool),
+		reacquired: make(map[string]reacquireRecord),
+	}
+	d.threads[threadID] = thread
+	d.order = append(d.order, threadID)
+	return thread
+}
+
+func (d *antiPatternDetector) association(variable string) *lockAssociation {
+	if assoc, ok := d.locks[variable]; ok {
+		return assoc
+	}
+	if len(d.lockKeys) >= antiPatternMaxVariables {
+		delete(d.locks, d.lockKeys[0])
+		d.lockKeys = d.lockKeys[1:]
+	}
+	assoc := &lockAssociation{}
+	d.locks[variable] = assoc
+	d.lockKeys = append(d.lockKeys, variable)
+	return assoc
+}
+
+// heldSet returns the locks held by the thread, including any the event itself reports.
+func (t *threadHistory) heldSet(lockSet []string) map[string]bool {
+	locks := make(map[string]bool, len(t.held)+len(lockSet))
+	for lockID := range t.held {
+		locks[lockID] = true
+	}
+	for _, lockID := range lockSet {
+		locks[lockID] = true
+	}
+	return locks
+}
+
+func copyLockSet(locks map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(locks))
+	for id := range locks {
+		copied[id] = true
+	}
+	return copied
+}
+
+func firstKey(set map[string]bool) string {
+	first := ""
+	for key := range set {
+		if first == "" || key < first {
+			first = key
+		}
+	}
+	return first
+}
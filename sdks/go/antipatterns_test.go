@@ -0,0 +1,175 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func inspectBuffered(client *Client) []Event {
+	return client.detector.inspect(bufferedEvents(client))
+}
+
+func warningsOf(warnings []Event, pattern string) []*AntiPatternData {
+	var matched []*AntiPatternData
+	for _, w := range warnings {
+		if w.Kind.AntiPattern != nil && w.Kind.AntiPattern.Pattern == pattern {
+			matched = append(matched, w.Kind.AntiPattern)
+		}
+	}
+	return matched
+}
+
+func TestAntiPatternWarningsUseIDFormat(t *testing.T) {
+	client := newBufferingClient(t, func(c *Config) {
+		c.AntiPatternDetection = true
+		c.IDFormat = IDFormatUUID7
+	})
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+
+	for i := 0; i < 2; i++ {
+		client.WithLock(ctx, &noopLocker{}, "accounts", "Mutex", func() {
+			client.TrackStateChange(ctx, "bob.balance", 500+i, 600+i, "main.go:240", "Write")
+		})
+	}
+	client.TrackStateChange(ctx, "bob.balance", 601, 701, "main.go:251", "Write")
+
+	warnings := inspectBuffered(client)
+	if len(warnings) != 1 {
+		t.Fatalf("expected one warning, got %d", len(warnings))
+	}
+	if id, err := uuid.Parse(warnings[0].ID); err != nil || id.Version() != 7 {
+		t.Errorf("expected the warning ID in the client's format, got %q", warnings[0].ID)
+	}
+}
+
+// TestAntiPatternUnprotectedWrite reproduces a credit to bob.balance that skips
+// the accounts lock every other write held.
+func TestAntiPatternUnprotectedWrite(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+
+	for i := 0; i < 2; i++ {
+		client.WithLock(ctx, &noopLocker{}, "accounts", "Mutex", func() {
+			client.TrackStateChange(ctx, "bob.balance", 500+i, 600+i, "main.go:240", "Write")
+		})
+	}
+	client.TrackStateChange(ctx, "bob.balance", 601, 701, "main.go:251", "Write")
+
+	events := bufferedEvents(client)
+	warnings := inspectBuffered(client)
+	matched := warningsOf(warnings, AntiPatternUnprotectedWrite)
+	if len(warnings) != 1 || len(matched) != 1 {
+		t.Fatalf("expected exactly one unprotected_write warning, got %d warnings", len(warnings))
+	}
+	w := matched[0]
+	if w.Variable != "bob.balance" || w.LockID != "accounts" {
+		t.Errorf("unexpected warning %+v", w)
+	}
+	last := events[len(events)-1]
+	if w.EventIDs[1] != last.ID || w.Locations[1] != "main.go:251" {
+		t.Errorf("expected warning to reference the unprotected write, got %v %v", w.EventIDs, w.Locations)
+	}
+	if w.Locations[0] != "main.go:241" && w.Locations[0] != "main.go:240" {
+		t.Errorf("expected warning to reference the last protected write, got %v", w.Locations)
+	}
+	if warnings[0].ParentID == nil || *warnings[0].ParentID != last.ID {
+		t.Error("expected warning to be parented to the triggering event")
+	}
+}
+
+// TestAntiPatternReadReleaseWrite reproduces transfer() reading alice.balance under
+// the read lock, releasing it, doing other work, and writing under a different lock.
+func TestAntiPatternReadReleaseWrite(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+
+	client.TrackLockAcquire(ctx, "accounts", "RWLock-Read")
+	client.TrackStateChange(ctx, "alice.balance", nil, 1000, "main.go:206", "Read")
+	client.TrackLockRelease(ctx, "accounts", "RWLock-Read")
+	client.TrackStateChange(ctx, "request.validated", nil, true, "main.go:215", "Write")
+	client.TrackLockAcquire(ctx, "ledger", "Mutex")
+	client.TrackStateChange(ctx, "alice.balance", 1000, 900, "main.go:228", "Write")
+	client.TrackLockRelease(ctx, "ledger", "Mutex")
+
+	events := bufferedEvents(client)
+	warnings := inspectBuffered(client)
+	matched := warningsOf(warnings, AntiPatternReadReleaseWrite)
+	if len(warnings) != 1 || len(matched) != 1 {
+		t.Fatalf("expected exactly one read_release_write warning, got %d warnings", len(warnings))
+	}
+	w := matched[0]
+	if w.LockID != "accounts" || w.EventIDs[0] != events[1].ID || w.EventIDs[1] != events[5].ID {
+		t.Errorf("unexpected warning references %+v", w)
+	}
+	if w.Locations[0] != "main.go:206" || w.Locations[1] != "main.go:228" {
+		t.Errorf("unexpected warning locations %v", w.Locations)
+	}
+}
+
+// TestAntiPatternLockReacquire reproduces the exact transfer() bug shape: read under
+// the accounts lock, release, immediately re-acquire, write.
+func TestAntiPatternLockReacquire(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+
+	client.TrackLockAcquire(ctx, "accounts", "Mutex")
+	client.TrackStateChange(ctx, "alice.balance", nil, 1000, "main.go:206", "Read")
+	client.TrackLockRelease(ctx, "accounts", "Mutex")
+	client.TrackLockAcquire(ctx, "accounts", "Mutex")
+	client.TrackStateChange(ctx, "alice.balance", 1000, 900, "main.go:228", "Write")
+	client.TrackLockRelease(ctx, "accounts", "Mutex")
+
+	events := bufferedEvents(client)
+	warnings := inspectBuffered(client)
+	matched := warningsOf(warnings, AntiPatternLockReacquire)
+	if len(warnings) != 1 || len(matched) != 1 {
+		t.Fatalf("expected exactly one lock_reacquire warning, got %d warnings", len(warnings))
+	}
+	w := matched[0]
+	if w.EventIDs[0] != events[2].ID || w.EventIDs[1] != events[4].ID {
+		t.Errorf("expected warning to reference the release and the write, got %v", w.EventIDs)
+	}
+}
+
+func TestAntiPatternCleanSequences(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+
+	client.WithLock(ctx, &noopLocker{}, "accounts", "Mutex", func() {
+		client.TrackStateChange(ctx, "alice.balance", nil, 1000, "main.go:206", "Read")
+		client.TrackStateChange(ctx, "alice.balance", 1000, 900, "main.go:228", "Write")
+	})
+	client.WithLock(ctx, &noopLocker{}, "accounts", "Mutex", func() {
+		client.TrackStateChange(ctx, "bob.balance", 500, 600, "main.go:240", "Write")
+	})
+
+	if warnings := inspectBuffered(client); len(warnings) != 0 {
+		t.Errorf("expected no warnings for correctly locked accesses, got %d", len(warnings))
+	}
+}
+
+func TestAntiPatternRateLimitedPerLocation(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+	for i := 0; i < 3; i++ {
+		client.TrackLockAcquire(ctx, "accounts", "Mutex")
+		client.TrackStateChange(ctx, "alice.balance", nil, 1000, "main.go:206", "Read")
+		client.TrackLockRelease(ctx, "accounts", "Mutex")
+		client.TrackLockAcquire(ctx, "accounts", "Mutex")
+		client.TrackStateChange(ctx, "alice.balance", 1000, 900, "main.go:228", "Write")
+		client.TrackLockRelease(ctx, "accounts", "Mutex")
+	}
+
+	if warnings := inspectBuffered(client); len(warnings) != 1 {
+		t.Errorf("expected repeated occurrences at one location to be rate limited, got %d", len(warnings))
+	}
+}
+
+type noopLocker struct{}
+
+func (noopLocker) Lock()   {}
+func (noopLocker) Unlock() {}
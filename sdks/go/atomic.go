@@ -0,0 +1,154 @@
+package raceway
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+)
+
+// Access types of StateChange events recording sync/atomic operations. The
+// server treats them as synchronized, so they are not reported as racing.
+const (
+	AccessAtomicRead  = "AtomicRead"
+	AccessAtomicWrite = "AtomicWrite"
+	AccessAtomicRMW   = "AtomicRMW"
+)
+
+const (
+	// synchronizedTag marks accesses made with sync/atomic.
+	synchronizedTag = "synchronized"
+	// casTag records whether a compare-and-swap swapped or failed; a failed
+	// one also carries the expected value in casExpectedTag.
+	casTag         = "cas"
+	casExpectedTag = "cas_expected"
+)
+
+// isAtomicAccess reports whether accessType is one of the atomic access types.
+func isAtomicAccess(accessType string) bool {
+	switch accessType {
+	case AccessAtomicRead, AccessAtomicWrite, AccessAtomicRMW:
+		return true
+	}
+	return false
+}
+
+// TrackAtomicAdd records an atomic add of delta to variable that produced
+// newValue, such as atomic.AddInt64, as an AtomicRMW.
+//
+//	n := atomic.AddInt64(&inFlight, 1)
+//	client.TrackAtomicAdd(ctx, "inFlight", 1, n)
+func (c *Client) TrackAtomicAdd(ctx context.Context, variable string, delta, newValue int64) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.trackAtomic(ctx, variable, AccessAtomicRMW, newValue-delta, newValue, nil, c.captureLocation(2))
+}
+
+// TrackAtomicLoad records an atomic load of value from variable as an AtomicRead.
+func (c *Client) TrackAtomicLoad(ctx context.Context, variable string, value int64) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.trackAtomic(ctx, variable, AccessAtomicRead, nil, value, nil, c.captureLocation(2))
+}
+
+// TrackAtomicStore records an atomic store of value to variable as an AtomicWrite.
+func (c *Client) TrackAtomicStore(ctx context.Context, variable string, value int64) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.trackAtomic(ctx, variable, AccessAtomicWrite, nil, value, nil, c.captureLocation(2))
+}
+
+// TrackAtomicCAS records a compare-and-swap of variable from old to new. One
+// that swapped is an AtomicRMW tagged cas=swapped; one that failed only read
+// variable, so it is an AtomicRead tagged cas=failed with the expected value
+// in cas_expected.
+func (c *Client) TrackAtomicCAS(ctx context.Context, variable string, old, new int64, swapped bool) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.trackCAS(ctx, variable, old, new, swapped, c.captureLocation(2))
+}
+
+func (c *Client) trackCAS(ctx context.Context, variable string, old, new int64, swapped bool, location string) {
+	if swapped {
+		c.trackAtomic(ctx, variable, AccessAtomicRMW, old, new, map[string]string{casTag: "swapped"}, location)
+		return
+	}
+	c.trackAtomic(ctx, variable, AccessAtomicRead, nil, nil, map[string]string{
+		casTag:         "failed",
+		casExpectedTag: strconv.FormatInt(old, 10),
+	}, location)
+}
+
+func (c *Client) trackAtomic(ctx context.Context, variable, accessType string, oldValue, newValue interface{}, tags map[string]string, location string) {
+	if tags == nil {
+		tags = make(map[string]string, 1)
+	}
+	tags[synchronizedTag] = "atomic"
+	c.captureEventWith(ctx, EventKind{
+		StateChange: &StateChangeData{
+			Variable:   variable,
+			OldValue:   oldValue,
+			NewValue:   newValue,
+			Location:   location,
+			AccessType: accessType,
+		},
+	}, captureOptions{tags: tags})
+}
+
+// AtomicInt64 is an atomic.Int64 whose operations are recorded as atomic
+// accesses of variable. Its zero value is usable and untracked.
+//
+// Example:
+//
+//	inFlight := raceway.NewAtomicInt64(client, "inFlight")
+//	inFlight.Add(ctx, 1)
+//	defer inFlight.Add(ctx, -1)
+type AtomicInt64 struct {
+	client   *Client
+	variable string
+	v        atomic.Int64
+}
+
+// NewAtomicInt64 returns an AtomicInt64 holding 0, tracked under variable.
+func NewAtomicInt64(client *Client, variable string) *AtomicInt64 {
+	return &AtomicInt64{client: client, variable: variable}
+}
+
+// Load atomically loads the value.
+func (a *AtomicInt64) Load(ctx context.Context) int64 {
+	value := a.v.Load()
+	if a.client != nil {
+		a.client.trackAtomic(ctx, a.variable, AccessAtomicRead, nil, value, nil, a.client.captureLocation(2))
+	}
+	return value
+}
+
+// Store atomically stores value.
+func (a *AtomicInt64) Store(ctx context.Context, value int64) {
+	a.v.Store(value)
+	if a.client != nil {
+		a.client.trackAtomic(ctx, a.variable, AccessAtomicWrite, nil, value, nil, a.client.captureLocation(2))
+	}
+}
+
+// Add atomically adds delta and returns the new value.
+func (a *AtomicInt64) Add(ctx context.Context, delta int64) int64 {
+	value := a.v.Add(delta)
+	if a.client != nil {
+		a.client.trackAtomic(ctx, a.variable, AccessAtomicRMW, value-delta, value, nil, a.client.captureLocation(2))
+	}
+	return value
+}
+
+// CompareAndSwap atomically swaps the value for new if it is old, and reports
+// whether it did.
+func (a *AtomicInt64) CompareAndSwap(ctx context.Context, old, new int64) bool {
+	swapped := a.v.CompareAndSwap(old, new)
+	if a.client != nil {
+		a.client.trackCAS(ctx, a.variable, old, new, swapped, a.client.captureLocation(2))
+	}
+	return swapped
+}
@@ -0,0 +1,104 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestTrackAtomicAccessTypes(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackAtomicLoad(ctx, "hits", 4)
+	c.TrackAtomicStore(ctx, "hits", 0)
+	c.TrackAtomicAdd(ctx, "hits", 3, 3)
+	c.TrackAtomicCAS(ctx, "hits", 3, 10, true)
+	c.TrackAtomicCAS(ctx, "hits", 3, 11, false)
+
+	events := bufferedEvents(c)
+	want := []struct {
+		accessType string
+		oldValue   interface{}
+		newValue   interface{}
+		cas        string
+	}{
+		{AccessAtomicRead, nil, 4.0, ""},
+		{AccessAtomicWrite, nil, 0.0, ""},
+		{AccessAtomicRMW, 0.0, 3.0, ""},
+		{AccessAtomicRMW, 3.0, 10.0, "swapped"},
+		{AccessAtomicRead, nil, nil, "failed"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(events))
+	}
+	for i, w := range want {
+		change, tags := events[i].Kind.StateChange, events[i].Metadata.Tags
+		if change.AccessType != w.accessType || decodedValue(t, change.OldValue) != w.oldValue || decodedValue(t, change.NewValue) != w.newValue {
+			t.Errorf("event %d: got %s %v -> %v, want %s %v -> %v", i, change.AccessType,
+				decodedValue(t, change.OldValue), decodedValue(t, change.NewValue), w.accessType, w.oldValue, w.newValue)
+		}
+		if tags[synchronizedTag] != "atomic" || tags[casTag] != w.cas {
+			t.Errorf("event %d: unexpected tags %v", i, tags)
+		}
+	}
+	if expected := events[4].Metadata.Tags[casExpectedTag]; expected != "3" {
+		t.Errorf("expected a failed CAS to record the expected value, got %q", expected)
+	}
+}
+
+func TestAtomicInt64TracksOperations(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	counter := NewAtomicInt64(c, "inFlight")
+
+	_, _, line, _ := runtime.Caller(0)
+	counter.Add(ctx, 2)
+	counter.Store(ctx, 5)
+	if !counter.CompareAndSwap(ctx, 5, 6) || counter.CompareAndSwap(ctx, 5, 7) {
+		t.Fatal("expected the first CompareAndSwap to swap and the second to fail")
+	}
+	if got := counter.Load(ctx); got != 6 {
+		t.Fatalf("expected 6, got %d", got)
+	}
+
+	var got []string
+	for _, event := range bufferedEvents(c) {
+		change := event.Kind.StateChange
+		got = append(got, change.AccessType+" "+event.Metadata.Tags[casTag])
+		if change.Variable != "inFlight" {
+			t.Errorf("expected inFlight, got %s", change.Variable)
+		}
+	}
+	want := []string{"AtomicRMW ", "AtomicWrite ", "AtomicRMW swapped", "AtomicRead failed", "AtomicRead "}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if first := bufferedEvents(c)[0].Kind.StateChange.Location; first != fmt.Sprintf("atomic_test.go:%d", line+1) {
+		t.Errorf("expected the call site as location, got %s", first)
+	}
+
+	var untracked AtomicInt64
+	if untracked.Add(ctx, 1) != 1 || len(bufferedEvents(c)) != 5 {
+		t.Error("expected the zero value to work without recording events")
+	}
+}
+
+func TestAntiPatternIgnoresAtomicWrites(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+
+	for i := 0; i < 2; i++ {
+		client.WithLock(ctx, &noopLocker{}, "stats", "Mutex", func() {
+			client.TrackStateChange(ctx, "hits", i, i+1, "main.go:10", "Write")
+		})
+	}
+	client.TrackAtomicAdd(ctx, "hits", 1, 3)
+
+	if warnings := inspectBuffered(client); len(warnings) != 0 {
+		t.Errorf("expected an atomic add to need no lock, got %d warnings", len(warnings))
+	}
+}
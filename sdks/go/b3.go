@@ -0,0 +1,116 @@
+package raceway
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/mode7labs/raceway/sdks/go/propagation"
+)
+
+// PropagationFormatB3 adds Zipkin B3 multi-header propagation to outbound
+// requests. See Config.PropagationFormats.
+const PropagationFormatB3 = "b3"
+
+const (
+	b3SingleHeader       = "b3"
+	b3TraceIDHeader      = "X-B3-TraceId"
+	b3SpanIDHeader       = "X-B3-SpanId"
+	b3ParentSpanIDHeader = "X-B3-ParentSpanId"
+	b3SampledHeader      = "X-B3-Sampled"
+	b3FlagsHeader        = "X-B3-Flags"
+)
+
+type parsedB3 struct {
+	traceID      string
+	spanID       string
+	parentSpanID *string
+	sampled      *bool
+}
+
+// parseB3 extracts trace context from the single b3 header or, failing that,
+// the X-B3-* headers. 64-bit trace IDs are left-padded with zeros, so every
+// service that sees the same B3 ID derives the same Raceway trace ID.
+func parseB3(headers Carrier) (parsedB3, bool) {
+	if raw := headers.Get(b3SingleHeader); raw != "" {
+		// {TraceId}-{SpanId}[-{SamplingState}[-{ParentSpanId}]]; a bare
+		// sampling state carries no IDs
+		parts := strings.Split(strings.TrimSpace(raw), "-")
+		if len(parts) >= 2 {
+			parent, sampling := "", ""
+			if len(parts) >= 3 {
+				sampling = parts[2]
+			}
+			if len(parts) == 4 {
+				parent = parts[3]
+			}
+			if parsed, ok := newParsedB3(parts[0], parts[1], parent); ok {
+				parsed.sampled = parseB3Sampled(sampling)
+				return parsed, true
+			}
+		}
+	}
+	parsed, ok := newParsedB3(headers.Get(b3TraceIDHeader), headers.Get(b3SpanIDHeader), headers.Get(b3ParentSpanIDHeader))
+	if ok {
+		parsed.sampled = parseB3Sampled(headers.Get(b3SampledHeader))
+		if headers.Get(b3FlagsHeader) == "1" {
+			parsed.sampled = parseB3Sampled("d")
+		}
+	}
+	return parsed, ok
+}
+
+// parseB3Sampled decodes a B3 sampling state; debug ("d") implies sampled.
+func parseB3Sampled(value string) *bool {
+	var sampled bool
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "d", "true":
+		sampled = true
+	case "0", "false":
+		sampled = false
+	default:
+		return nil
+	}
+	return &sampled
+}
+
+func newParsedB3(traceIDHex, spanIDHex, parentSpanIDHex string) (parsedB3, bool) {
+	traceIDHex = strings.ToLower(strings.TrimSpace(traceIDHex))
+	spanIDHex = strings.ToLower(strings.TrimSpace(spanIDHex))
+	if len(traceIDHex) == 16 {
+		traceIDHex = strings.Repeat("0", 16) + traceIDHex
+	}
+	traceID, err := propagation.TraceIDToUUID(traceIDHex)
+	if err != nil || !isSpanID(spanIDHex) {
+		return parsedB3{}, false
+	}
+
+	parsed := parsedB3{traceID: traceID, spanID: spanIDHex}
+	if parent := strings.ToLower(strings.TrimSpace(parentSpanIDHex)); isSpanID(parent) {
+		parsed.parentSpanID = &parent
+	}
+	return parsed, true
+}
+
+// isSpanID reports whether value is a non-zero 64-bit hex ID.
+func isSpanID(value string) bool {
+	if len(value) != 16 || strings.Trim(value, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// addB3Headers adds X-B3-* headers describing the same hop as the
+// traceparent in headers.
+func addB3Headers(headers Carrier, traceID, childSpanID, currentSpanID string, sampled bool) {
+	headers.Set(b3TraceIDHeader, propagation.Normalize(traceID))
+	headers.Set(b3SpanIDHeader, childSpanID)
+	if isSpanID(currentSpanID) {
+		headers.Set(b3ParentSpanIDHeader, currentSpanID)
+	}
+	if sampled {
+		headers.Set(b3SampledHeader, "1")
+	} else {
+		headers.Set(b3SampledHeader, "0")
+	}
+}
@@ -0,0 +1,99 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseIncomingHeadersB3(t *testing.T) {
+	t.Run("multi-header 128-bit", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("X-B3-TraceId", "4BF92F3577B34DA6A3CE929D0E0E4736")
+		headers.Set("X-B3-SpanId", "00f067aa0ba902b7")
+		headers.Set("X-B3-ParentSpanId", "e457b5a2e4d86bd1")
+		headers.Set("X-B3-Sampled", "1")
+
+		result := ParseIncomingHeaders(headers, "test-service", "instance-1")
+		if result.TraceID != "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" || result.SpanID != "00f067aa0ba902b7" || !result.Distributed {
+			t.Errorf("unexpected result %+v", result)
+		}
+		if result.ParentSpanID == nil || *result.ParentSpanID != "e457b5a2e4d86bd1" {
+			t.Errorf("expected parent span ID e457b5a2e4d86bd1, got %v", result.ParentSpanID)
+		}
+	})
+
+	t.Run("single header 64-bit is padded deterministically", func(t *testing.T) {
+		single := http.Header{}
+		single.Set("b3", "a3ce929d0e0e4736-00f067aa0ba902b7-1")
+		multi := http.Header{}
+		multi.Set("X-B3-TraceId", "a3ce929d0e0e4736")
+		multi.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+
+		a := ParseIncomingHeaders(single, "service-a", "a-1")
+		b := ParseIncomingHeaders(multi, "service-b", "b-1")
+		if a.TraceID != "00000000-0000-0000-a3ce-929d0e0e4736" || a.TraceID != b.TraceID {
+			t.Errorf("expected both services to derive the padded trace ID, got %s and %s", a.TraceID, b.TraceID)
+		}
+		if a.SpanID != "00f067aa0ba902b7" || a.ParentSpanID != nil {
+			t.Errorf("unexpected single-header span IDs %+v", a)
+		}
+	})
+
+	t.Run("traceparent takes precedence", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("traceparent", validTraceparent)
+		headers.Set("b3", "a3ce929d0e0e4736-00f067aa0ba902b7-1")
+
+		result := ParseIncomingHeaders(headers, "test-service", "instance-1")
+		if result.TraceID != validTraceID || result.SpanID != validSpanID {
+			t.Errorf("expected the traceparent IDs, got %s/%s", result.TraceID, result.SpanID)
+		}
+	})
+
+	t.Run("sampling-only and malformed headers are ignored", func(t *testing.T) {
+		for _, headers := range []http.Header{
+			{"B3": {"1"}},
+			{"B3": {"zzzz-00f067aa0ba902b7"}},
+			{"X-B3-Traceid": {"a3ce929d0e0e4736"}, "X-B3-Spanid": {"0000000000000000"}},
+		} {
+			if result := ParseIncomingHeaders(headers, "test-service", "instance-1"); result.Distributed {
+				t.Errorf("expected %v to start a new trace, got %+v", headers, result)
+			}
+		}
+	})
+}
+
+func TestPropagationFormatsEmitB3(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.PropagationFormats = []string{PropagationFormatB3}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	rctx := FromContext(ctx)
+
+	headers, err := c.PropagationHeaders(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["X-B3-ParentSpanId"] != rctx.SpanID || headers["X-B3-Sampled"] != "1" || headers["traceparent"] == "" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+
+	// A Zipkin-only hop that forwards just the B3 headers keeps the trace
+	b3Only := http.Header{}
+	for _, name := range []string{"X-B3-TraceId", "X-B3-SpanId", "X-B3-ParentSpanId", "X-B3-Sampled"} {
+		b3Only.Set(name, headers[name])
+	}
+	parsed := ParseIncomingHeaders(b3Only, "downstream", "d-1")
+	if parsed.TraceID != rctx.TraceID || parsed.SpanID != headers["X-B3-SpanId"] {
+		t.Errorf("B3 headers did not round-trip: got %s/%s", parsed.TraceID, parsed.SpanID)
+	}
+
+	plain := newBufferingClient(t, nil)
+	headers, _ = plain.PropagationHeaders(NewContext(context.Background(), "", "test-service", "test-instance"), nil)
+	if _, ok := headers["X-B3-TraceId"]; ok {
+		t.Errorf("expected no B3 headers by default")
+	}
+}
@@ -0,0 +1,121 @@
+package raceway
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	baggageHeader = "baggage"
+
+	// maxBaggageEntries and maxBaggageBytes are the W3C Baggage limits;
+	// entries beyond them are dropped rather than failing the header
+	maxBaggageEntries = 180
+	maxBaggageBytes   = 8192
+
+	// baggageTagPrefix prefixes baggage entries recorded as event tags
+	baggageTagPrefix = "baggage."
+)
+
+// parseBaggage decodes the W3C baggage headers in headers. Malformed entries
+// and entry properties are skipped; it returns nil if no entry is valid.
+func parseBaggage(headers Carrier) map[string]string {
+	values := carrierValues(headers, baggageHeader)
+	if len(values) == 0 {
+		return nil
+	}
+
+	var baggage map[string]string
+	size := 0
+	for _, member := range strings.Split(strings.Join(values, ","), ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		if len(baggage) == maxBaggageEntries || size+len(member) > maxBaggageBytes {
+			break
+		}
+		// Properties after ";" carry no value Raceway records
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+		key, value, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil || !isBaggageKey(key) {
+			continue
+		}
+		if baggage == nil {
+			baggage = make(map[string]string)
+		}
+		baggage[key] = value
+		size += len(member)
+	}
+	return baggage
+}
+
+// encodeBaggage returns the baggage header value for baggage, with entries
+// sorted by key and values percent-encoded. Entries with invalid keys or that
+// would exceed the W3C limits are omitted.
+func encodeBaggage(baggage map[string]string) string {
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		if isBaggageKey(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	count := 0
+	for _, k := range keys {
+		member := k + "=" + escapeBaggageValue(baggage[k])
+		if count == maxBaggageEntries || b.Len()+len(member)+1 > maxBaggageBytes {
+			break
+		}
+		if count > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(member)
+		count++
+	}
+	return b.String()
+}
+
+// isBaggageKey reports whether key is an RFC 7230 token.
+func isBaggageKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeBaggageValue percent-encodes every byte that is not a W3C baggage-octet.
+func escapeBaggageValue(value string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c > ' ' && c < 0x7f && c != '"' && c != ',' && c != ';' && c != '\\' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xf])
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBaggageDecodesAndSkipsMalformedEntries(t *testing.T) {
+	headers := http.Header{}
+	headers.Add("baggage", "tenant=acme%20corp, flag.checkout=on;ttl=30,=missing-key,no-value, bad key=x,pct=%zz")
+	headers.Add("baggage", "region=eu-west")
+
+	got := parseBaggage(HeaderCarrier(headers))
+	want := map[string]string{"tenant": "acme corp", "flag.checkout": "on", "region": "eu-west"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBaggage = %v, want %v", got, want)
+	}
+	if parseBaggage(HeaderCarrier{"Baggage": {"=,;"}}) != nil {
+		t.Errorf("expected nil baggage when no entry is valid")
+	}
+}
+
+func TestBaggageLimits(t *testing.T) {
+	members := make([]string, 0, maxBaggageEntries+20)
+	for i := 0; i < maxBaggageEntries+20; i++ {
+		members = append(members, fmt.Sprintf("k%d=v", i))
+	}
+	headers := http.Header{"Baggage": {strings.Join(members, ",")}}
+	if got := len(parseBaggage(HeaderCarrier(headers))); got != maxBaggageEntries {
+		t.Errorf("parsed %d entries, want %d", got, maxBaggageEntries)
+	}
+
+	large := map[string]string{"a": strings.Repeat("x", 5000), "b": strings.Repeat("y", 5000)}
+	encoded := encodeBaggage(large)
+	if len(encoded) > maxBaggageBytes || !strings.HasPrefix(encoded, "a=") || strings.Contains(encoded, "b=") {
+		t.Errorf("expected only the first entry within %d bytes, got %d bytes", maxBaggageBytes, len(encoded))
+	}
+}
+
+func TestBaggageRoundTripsThroughTwoHops(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	incoming := http.Header{}
+	incoming.Set("baggage", "tenant=acme%2Cinc,flag.new-ui=true,user=J%C3%BCrgen")
+	want := map[string]string{"tenant": "acme,inc", "flag.new-ui": "true", "user": "Jürgen"}
+
+	// Hop 1: the entry service receives the baggage and calls downstream
+	ctx := c.contextFromParsed(context.Background(), ParseIncomingHeaders(incoming, "frontend", "fe-1"))
+	c.TrackStateChange(ctx, "cart", nil, 1, "baggage_test.go:1", "Read")
+	outbound, err := c.PropagationHeaders(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hop 2: the downstream service parses and propagates it again
+	hop2 := http.Header{}
+	for k, v := range outbound {
+		hop2.Set(k, v)
+	}
+	parsed := ParseIncomingHeaders(hop2, "inventory", "inv-1")
+	if !reflect.DeepEqual(parsed.Baggage, want) {
+		t.Fatalf("hop 2 baggage = %v, want %v", parsed.Baggage, want)
+	}
+	result := BuildPropagationHeadersWithBaggage(parsed.TraceID, parsed.SpanID, parsed.TraceState, parsed.Baggage,
+		parsed.ClockVector, "inventory", "inv-1")
+	hop3 := http.Header{"Baggage": {result.Headers["baggage"]}}
+	if got := parseBaggage(HeaderCarrier(hop3)); !reflect.DeepEqual(got, want) {
+		t.Errorf("hop 3 baggage = %v, want %v", got, want)
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	for k, v := range want {
+		if got := events[0].Metadata.Tags["baggage."+k]; got != v {
+			t.Errorf("tag baggage.%s = %q, want %q", k, got, v)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package raceway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncatedBodySuffix marks a captured body cut off at Config.MaxBodyBytes.
+const truncatedBodySuffix = "...(truncated)"
+
+// unredactableBody replaces a truncated JSON body when redaction is
+// configured, since keys cannot be matched in an incomplete document.
+const unredactableBody = "(truncated JSON body not recorded: redaction needs the complete document)"
+
+// capturableMediaType reports whether bodies of contentType are recorded:
+// JSON and text. Multipart, binary, and compressed bodies never are.
+func capturableMediaType(contentType string) (capturable, isJSON bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, false
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return true, true
+	case strings.HasPrefix(mediaType, "text/"):
+		return true, false
+	}
+	return false, false
+}
+
+// bodyCapture keeps the first limit bytes written to it and discards the rest.
+type bodyCapture struct {
+	buf       []byte
+	limit     int
+	truncated bool
+	isJSON    bool
+}
+
+func (b *bodyCapture) Write(p []byte) (int, error) {
+	kept := p
+	if room := b.limit - len(b.buf); len(p) > room {
+		b.truncated = true
+		kept = p[:max(room, 0)]
+	}
+	b.buf = append(b.buf, kept...)
+	return len(p), nil
+}
+
+// value returns the captured body as recorded on an event: a string, cut at
+// a character boundary and suffixed when truncated. JSON bodies are redacted
+// like other recorded values.
+func (b *bodyCapture) value(r *redactor) interface{} {
+	if len(b.buf) == 0 && !b.truncated {
+		return nil
+	}
+	if r != nil && b.isJSON {
+		if b.truncated {
+			return unredactableBody
+		}
+		if raw, ok := r.redact("", json.RawMessage(b.buf)).(json.RawMessage); ok {
+			return string(raw)
+		}
+		return unredactableBody
+	}
+	if !b.truncated {
+		return string(b.buf)
+	}
+	body := b.buf
+	for i := 0; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+		body = body[:len(body)-1]
+	}
+	return string(body) + truncatedBodySuffix
+}
+
+func (c *Client) maxBodyBytes() int {
+	if c.config.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+	return c.config.MaxBodyBytes
+}
+
+// newBodyCapture returns a capture for a body of contentType, or nil if the
+// body is not recorded.
+func (c *Client) newBodyCapture(contentType, contentEncoding string) *bodyCapture {
+	if contentEncoding != "" {
+		return nil
+	}
+	capturable, isJSON := capturableMediaType(contentType)
+	if !capturable {
+		return nil
+	}
+	return &bodyCapture{limit: c.maxBodyBytes(), isJSON: isJSON}
+}
+
+// requestBody is a request body replaced by Middleware to record it.
+type requestBody struct {
+	io.Reader
+	io.Closer
+}
+
+// errorReader fails every Read with err.
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+// captureRequestBody prepares r's body to be recorded on its HTTPRequest
+// event and returns the capture, or nil if the body is not recorded. A body
+// of known length is read up to Config.MaxBodyBytes before the handler runs,
+// so it is recorded with the request event; the handler reads the same bytes
+// again. Streamed bodies, and those the client sends only once the server
+// answers "Expect: 100-continue", are recorded as the handler reads them
+// instead, since reading ahead could wait on the client forever or change
+// the server's answer; pending reports this case.
+func (c *Client) captureRequestBody(r *http.Request) (capture *bodyCapture, pending bool) {
+	if !c.config.CaptureRequestBody || r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+	capture = c.newBodyCapture(r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"))
+	if capture == nil {
+		return nil, false
+	}
+
+	if r.ContentLength < 0 || strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		r.Body = requestBody{Reader: io.TeeReader(r.Body, capture), Closer: r.Body}
+		return capture, true
+	}
+	prefix, err := io.ReadAll(io.LimitReader(r.Body, int64(capture.limit)+1))
+	capture.Write(prefix)
+	var rest io.Reader = r.Body
+	if err != nil {
+		rest = errorReader{err}
+	}
+	r.Body = requestBody{Reader: io.MultiReader(bytes.NewReader(prefix), rest), Closer: r.Body}
+	return capture, false
+}
+
+// setRequestBody records body on the buffered HTTPRequest event eventID of
+// traceID once the handler has read it. The body is lost if the event was
+// already flushed.
+func (c *Client) setRequestBody(traceID, eventID string, body interface{}) {
+	if eventID == "" || body == nil {
+		return
+	}
+	set := c.editBufferedEvent(traceID, eventID, func(event *Event) {
+		if event.Kind.HTTPRequest != nil {
+			// The event's data may be shared with its retained copy
+			data := *event.Kind.HTTPRequest
+			data.Body = body
+			event.Kind.HTTPRequest = &data
+		}
+	})
+	if !set {
+		c.logger.Debugf("Request event %s flushed before its body was read", eventID)
+	}
+}
+
+// editBufferedEvent applies edit to a buffered event of traceID, or one held
+// by CaptureModeOnError, and to its copy retained for ExportTrace. It reports
+// false if the event has already been flushed or discarded.
+func (c *Client) editBufferedEvent(traceID, eventID string, edit func(*Event)) bool {
+	if c.retained != nil {
+		c.retained.edit(traceID, eventID, edit)
+	}
+	if c.held != nil && c.held.edit(traceID, eventID, edit) {
+		return true
+	}
+	c.syncPipeline(context.Background())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.eventBuffer) - 1; i >= 0; i-- {
+		if c.eventBuffer[i].ID == eventID {
+			edit(&c.eventBuffer[i])
+			return true
+		}
+	}
+	return false
+}
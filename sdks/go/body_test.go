@@ -0,0 +1,282 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// httpBodies returns the bodies recorded on the buffered HttpRequest and
+// HttpResponse events.
+func httpBodies(t *testing.T, c *Client) (request, response interface{}) {
+	t.Helper()
+	var sawRequest, sawResponse bool
+	for _, event := range bufferedEvents(c) {
+		switch {
+		case event.Kind.HTTPRequest != nil:
+			request, sawRequest = decodedValue(t, event.Kind.HTTPRequest.Body), true
+		case event.Kind.HTTPResponse != nil:
+			response, sawResponse = decodedValue(t, event.Kind.HTTPResponse.Body), true
+		}
+	}
+	if !sawRequest || !sawResponse {
+		t.Fatalf("expected request and response events")
+	}
+	return request, response
+}
+
+// echo responds with the request body, checking the handler reads it whole.
+func echo(t *testing.T, want string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil || string(body) != want {
+			t.Errorf("handler read %q (%v), want %q", body, err, want)
+		}
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Write(body)
+	})
+}
+
+func TestBodiesNotCapturedByDefault(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	req := httptest.NewRequest("POST", "/transfers", strings.NewReader(`{"amount":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	c.Middleware(echo(t, `{"amount":10}`)).ServeHTTP(httptest.NewRecorder(), req)
+
+	if request, response := httpBodies(t, c); request != nil || response != nil {
+		t.Errorf("expected no bodies, got %v and %v", request, response)
+	}
+}
+
+func TestCaptureJSONBodies(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureRequestBody = true
+		cfg.CaptureResponseBody = true
+	})
+	body := `{"from":"alice","amount":10}`
+	req := httptest.NewRequest("POST", "/transfers", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rec := httptest.NewRecorder()
+	c.Middleware(echo(t, body)).ServeHTTP(rec, req)
+
+	if rec.Body.String() != body {
+		t.Errorf("expected the response to reach the client, got %q", rec.Body.String())
+	}
+	if request, response := httpBodies(t, c); request != body || response != body {
+		t.Errorf("expected both bodies recorded, got %v and %v", request, response)
+	}
+}
+
+func TestCaptureTruncatesLongBodies(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureRequestBody = true
+		cfg.CaptureResponseBody = true
+		cfg.MaxBodyBytes = 16
+	})
+	body := strings.Repeat("0123456789", 10)
+	req := httptest.NewRequest("POST", "/notes", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	c.Middleware(echo(t, body)).ServeHTTP(rec, req)
+
+	if rec.Body.String() != body {
+		t.Errorf("expected the whole response to reach the client, got %d bytes", rec.Body.Len())
+	}
+	want := body[:16] + truncatedBodySuffix
+	if request, response := httpBodies(t, c); request != want || response != want {
+		t.Errorf("expected truncated bodies %q, got %v and %v", want, request, response)
+	}
+}
+
+func TestCaptureRequestBodyHandlerNeverReads(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureRequestBody = true
+		cfg.CaptureResponseBody = true
+	})
+	req := httptest.NewRequest("POST", "/transfers", strings.NewReader(`{"amount":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if request, response := httpBodies(t, c); request != `{"amount":10}` || response != nil {
+		t.Errorf("expected the request body and no response body, got %v and %v", request, response)
+	}
+}
+
+func TestCaptureSkipsMultipartAndBinaryBodies(t *testing.T) {
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	writer.WriteField("note", "hello")
+	writer.Close()
+	formBody := form.String()
+
+	tests := []struct {
+		contentType, body string
+	}{
+		{writer.FormDataContentType(), formBody},
+		{"application/octet-stream", "\x00\x01\x02"},
+		{"image/png", "\x89PNG\r\n\x1a\n"},
+		{"", "no content type"},
+	}
+	for _, tt := range tests {
+		c := newBufferingClient(t, func(cfg *Config) {
+			cfg.CaptureRequestBody = true
+			cfg.CaptureResponseBody = true
+		})
+		req := httptest.NewRequest("POST", "/upload", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+				t.Errorf("%s: handler read %q", tt.contentType, body)
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte(tt.body))
+		})).ServeHTTP(httptest.NewRecorder(), req)
+
+		if request, response := httpBodies(t, c); request != nil || response != nil {
+			t.Errorf("%s: expected no bodies, got %v and %v", tt.contentType, request, response)
+		}
+	}
+}
+
+func TestCaptureStreamedRequestBody(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureRequestBody = true
+		cfg.CaptureResponseBody = true
+		cfg.MaxBodyBytes = 8
+	})
+	server := httptest.NewServer(c.Middleware(echo(t, "first line\nsecond line\n")))
+	defer server.Close()
+
+	// A body of unknown length is sent chunked
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("first line\n"))
+		pw.Write([]byte("second line\n"))
+		pw.Close()
+	}()
+	req, _ := http.NewRequest("POST", server.URL+"/lines", pr)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if request, _ := httpBodies(t, c); request != "first li"+truncatedBodySuffix {
+		t.Errorf("expected the streamed body recorded as read, got %v", request)
+	}
+}
+
+func TestCaptureChunkedResponse(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureRequestBody = true
+		cfg.CaptureResponseBody = true
+		cfg.MaxBodyBytes = 12
+	})
+	server := httptest.NewServer(c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for _, line := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+			io.WriteString(w, line+"\n")
+			w.(http.Flusher).Flush()
+		}
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected a chunked response, got %v", resp.TransferEncoding)
+	}
+	if string(received) != "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n" {
+		t.Errorf("expected the whole stream to reach the client, got %q", received)
+	}
+
+	if _, response := httpBodies(t, c); response != "{\"n\":1}\n{\"n\""+truncatedBodySuffix {
+		t.Errorf("expected the first 12 bytes across chunks, got %q", response)
+	}
+}
+
+func TestCaptureResponseSniffsContentType(t *testing.T) {
+	for _, tt := range []struct {
+		body     string
+		recorded bool
+	}{
+		{"<html><body>ok</body></html>", true},
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", false},
+	} {
+		c := newBufferingClient(t, func(cfg *Config) {
+			cfg.CaptureRequestBody = true
+			cfg.CaptureResponseBody = true
+		})
+		c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tt.body))
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		if _, response := httpBodies(t, c); (response != nil) != tt.recorded {
+			t.Errorf("body %q: expected recorded=%v, got %v", tt.body, tt.recorded, response)
+		}
+	}
+}
+
+func TestCaptureSkipsEncodedResponse(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureRequestBody = true
+		cfg.CaptureResponseBody = true
+	})
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte{0x1f, 0x8b, 0x08})
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if _, response := httpBodies(t, c); response != nil {
+		t.Errorf("expected a compressed body to be skipped, got %v", response)
+	}
+}
+
+func TestCaptureRedactsJSONBodies(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureRequestBody = true
+		cfg.CaptureResponseBody = true
+		cfg.RedactKeys = []string{"password"}
+		cfg.MaxBodyBytes = 64
+	})
+	body := `{"password":"hunter2","user":"alice"}`
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"password":"`+strings.Repeat("x", 64)+`"}`)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	request, response := httpBodies(t, c)
+	if request != `{"password":"[REDACTED]","user":"alice"}` {
+		t.Errorf("expected the password redacted, got %v", request)
+	}
+	if response != unredactableBody {
+		t.Errorf("expected a truncated JSON body to be withheld, got %v", response)
+	}
+}
+
+func TestTruncatedBodyKeepsWholeCharacters(t *testing.T) {
+	capture := &bodyCapture{limit: 2}
+	capture.Write([]byte("héllo"))
+	if got := capture.value(nil); got != "h"+truncatedBodySuffix {
+		t.Errorf("expected the cut character dropped, got %q", got)
+	}
+}
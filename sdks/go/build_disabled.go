@@ -0,0 +1,7 @@
+//go:build raceway_disabled
+
+package raceway
+
+// BuildDisabled reports whether the SDK was built with the raceway_disabled
+// build tag, which makes every client record nothing. See Config.Disabled.
+const BuildDisabled = true
@@ -0,0 +1,118 @@
+package raceway
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// cacheReads and cacheAtomicOps are the cache operations recorded as reads
+// and as atomic read-modify-writes; any other operation is a write.
+var (
+	cacheReads = map[string]bool{
+		"GET": true, "MGET": true, "EXISTS": true, "TTL": true, "PTTL": true, "STRLEN": true,
+		"HGET": true, "HMGET": true, "HGETALL": true, "HEXISTS": true, "HLEN": true,
+		"LRANGE": true, "LLEN": true, "LINDEX": true,
+		"SMEMBERS": true, "SISMEMBER": true, "SCARD": true,
+		"ZRANGE": true, "ZSCORE": true, "ZCARD": true,
+	}
+	cacheAtomicOps = map[string]bool{
+		"INCR": true, "INCRBY": true, "INCRBYFLOAT": true, "DECR": true, "DECRBY": true,
+		"HINCRBY": true, "HINCRBYFLOAT": true, "ZINCRBY": true,
+		"SETNX": true, "GETSET": true, "GETDEL": true, "APPEND": true,
+		"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true,
+	}
+)
+
+// cacheAccessType returns the StateChange access type of a cache operation.
+// Operations the cache server applies atomically, such as INCR, need no
+// lock and are recorded as AtomicRMW.
+func cacheAccessType(operation string) string {
+	operation = strings.ToUpper(operation)
+	switch {
+	case cacheReads[operation]:
+		return "Read"
+	case cacheAtomicOps[operation]:
+		return AccessAtomicRMW
+	}
+	return "Write"
+}
+
+// CacheVariable returns the variable name under which cache operations on
+// key in system are tracked, "system:key", such as "redis:balance:alice".
+func CacheVariable(system, key string) string {
+	return system + ":" + key
+}
+
+// TrackCacheOp tracks an operation on a cache, such as a Redis GET or SET,
+// taking duration. system names the cache, such as "redis", and operation
+// is the command, such as "GET", "SET", "DEL" or "INCR". hit reports whether
+// a read found key, or is nil when unknown. The operation is an access to
+// CacheVariable(system, key): reads and writes of a key are checked for
+// races like StateChange events on that variable, while atomic commands
+// such as INCR are not. The caller's location is recorded.
+//
+// Example:
+//
+//	start := time.Now()
+//	val, err := rdb.Get(ctx, "balance:alice").Result()
+//	hit := err == nil
+//	client.TrackCacheOp(ctx, "redis", "GET", "balance:alice", &hit, time.Since(start))
+func (c *Client) TrackCacheOp(ctx context.Context, system, operation, key string, hit *bool, duration time.Duration) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.captureEvent(ctx, EventKind{
+		CacheOp: &CacheOpData{
+			System:     system,
+			Operation:  operation,
+			Key:        key,
+			AccessType: cacheAccessType(operation),
+			Hit:        hit,
+			DurationNs: duration.Nanoseconds(),
+			Location:   c.captureLocation(2),
+		},
+	})
+}
+
+// stateAccess is an access to a variable, recorded by a StateChange or a
+// CacheOp event.
+type stateAccess struct {
+	variable   string
+	accessType string
+	location   string
+}
+
+// stateAccess returns the variable access k records, if any.
+func (k EventKind) stateAccess() (stateAccess, bool) {
+	switch {
+	case k.StateChange != nil:
+		return stateAccess{k.StateChange.Variable, k.StateChange.AccessType, k.StateChange.Location}, true
+	case k.CacheOp != nil:
+		return stateAccess{CacheVariable(k.CacheOp.System, k.CacheOp.Key), k.CacheOp.AccessType, k.CacheOp.Location}, true
+	}
+	return stateAccess{}, false
+}
+
+// downgradeCacheOp re-encodes a CacheOp as a StateChange on its variable for
+// collectors that do not understand the CacheOp kind, so that their race
+// analysis still sees the access.
+func downgradeCacheOp(event Event) Event {
+	data := event.Kind.CacheOp
+	details := map[string]interface{}{
+		"operation":   data.Operation,
+		"duration_ns": data.DurationNs,
+	}
+	if data.Hit != nil {
+		details["hit"] = *data.Hit
+	}
+	event.Kind = EventKind{
+		StateChange: &StateChangeData{
+			Variable:   CacheVariable(data.System, data.Key),
+			NewValue:   details,
+			Location:   data.Location,
+			AccessType: data.AccessType,
+		},
+	}
+	return event
+}
@@ -0,0 +1,111 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackCacheOp(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := c.newContext(context.Background(), "")
+	hit := false
+	c.TrackCacheOp(ctx, "redis", "GET", "balance:alice", &hit, 2*time.Millisecond)
+
+	events := bufferedEvents(c)
+	op := events[0].Kind.CacheOp
+	if op == nil || events[0].Kind.Name() != "CacheOp" {
+		t.Fatalf("expected a CacheOp event, got %+v", events[0].Kind)
+	}
+	if op.System != "redis" || op.Key != "balance:alice" || op.AccessType != "Read" || op.Hit == nil || *op.Hit {
+		t.Errorf("unexpected cache operation %+v", op)
+	}
+	if op.DurationNs != int64(2*time.Millisecond) || !strings.HasPrefix(op.Location, "cache_test.go:") {
+		t.Errorf("expected the duration and the caller's location, got %d and %s", op.DurationNs, op.Location)
+	}
+	if err := ValidateEvent(events[0]); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCacheOpOmitsUnknownHit(t *testing.T) {
+	data, err := json.Marshal(CacheOpData{System: "redis", Operation: "SET", Key: "k", AccessType: "Write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hit") {
+		t.Errorf("expected no hit field, got %s", data)
+	}
+}
+
+func TestCacheAccessType(t *testing.T) {
+	for operation, want := range map[string]string{
+		"GET": "Read", "hgetall": "Read", "SET": "Write", "DEL": "Write", "EXPIRE": "Write",
+		"INCR": AccessAtomicRMW, "incrby": AccessAtomicRMW, "SETNX": AccessAtomicRMW,
+	} {
+		if got := cacheAccessType(operation); got != want {
+			t.Errorf("%s: expected %s, got %s", operation, want, got)
+		}
+	}
+}
+
+// TestCacheOpUnprotectedWrite is the cache read-modify-write race: a SET that
+// skips the lock every other SET of the key held.
+func TestCacheOpUnprotectedWrite(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+	for i := 0; i < 2; i++ {
+		client.WithLock(ctx, &noopLocker{}, "balance-lock", "Mutex", func() {
+			client.TrackCacheOp(ctx, "redis", "SET", "balance:alice", nil, time.Millisecond)
+		})
+	}
+	client.TrackCacheOp(ctx, "redis", "SET", "balance:alice", nil, time.Millisecond)
+	// INCR is applied atomically by the server and needs no lock
+	client.TrackCacheOp(ctx, "redis", "INCR", "balance:alice", nil, time.Millisecond)
+
+	matched := warningsOf(inspectBuffered(client), AntiPatternUnprotectedWrite)
+	if len(matched) != 1 {
+		t.Fatalf("expected one unprotected_write warning, got %d", len(matched))
+	}
+	if matched[0].Variable != "redis:balance:alice" || matched[0].LockID != "balance-lock" {
+		t.Errorf("unexpected warning %+v", matched[0])
+	}
+}
+
+func TestCacheOpMixesWithStateChanges(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) { cfg.AntiPatternDetection = true })
+	ctx := NewContext(context.Background(), "", "banking-api", "test-instance")
+	client.WithLock(ctx, &noopLocker{}, "accounts", "Mutex", func() {
+		client.TrackCacheOp(ctx, "redis", "GET", "balance:alice", nil, time.Millisecond)
+	})
+	client.TrackStateChange(ctx, CacheVariable("redis", "balance:alice"), 100, 90, "cache_test.go:1", "Write")
+
+	if matched := warningsOf(inspectBuffered(client), AntiPatternReadReleaseWrite); len(matched) != 1 {
+		t.Errorf("expected the cached read and later write to be one variable, got %d warnings", len(matched))
+	}
+}
+
+func TestCacheOpRoutedByVariable(t *testing.T) {
+	event := Event{Kind: EventKind{CacheOp: &CacheOpData{System: "redis", Operation: "GET", Key: "session:1"}}}
+	if subject, ok := routeSubject(&event); !ok || subject != "redis:session:1" {
+		t.Errorf("expected redis:session:1, got %q", subject)
+	}
+}
+
+func TestCacheOpDowngradedWithoutCapability(t *testing.T) {
+	hit := true
+	event := downgradeCacheOp(Event{Kind: EventKind{CacheOp: &CacheOpData{
+		System: "redis", Operation: "GET", Key: "balance:alice", AccessType: "Read", Hit: &hit, Location: "bank.go:3",
+	}}})
+	change := event.Kind.StateChange
+	if change == nil || change.Variable != "redis:balance:alice" || change.AccessType != "Read" || change.Location != "bank.go:3" {
+		t.Fatalf("expected a StateChange on the cache variable, got %+v", event.Kind)
+	}
+	if details := change.NewValue.(map[string]interface{}); details["operation"] != "GET" || details["hit"] != true {
+		t.Errorf("expected the operation details kept, got %v", details)
+	}
+}
@@ -0,0 +1,54 @@
+package raceway
+
+import (
+	"context"
+	"errors"
+)
+
+// cancellationErrorType is the ErrorType of Error events recorded by
+// TrackCancellation.
+const cancellationErrorType = "Cancellation"
+
+const (
+	// ctxCanceledTag marks events captured after their context was canceled
+	// or passed its deadline, with the context's error in ctxErrTag. Nobody
+	// is waiting for such work to be consistent, so its accesses are the most
+	// race-prone of a request.
+	ctxCanceledTag = "ctx_canceled"
+	ctxErrTag      = "ctx_err"
+)
+
+// TrackCancellation records that the work of ctx was abandoned, for example
+// because the client disconnected, as an Error event with reason as its
+// message. Middleware records one when the client of a request goes away
+// before the handler returns.
+func (c *Client) TrackCancellation(ctx context.Context, reason string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.captureEvent(ctx, EventKind{
+		Error: &ErrorData{
+			ErrorType:  cancellationErrorType,
+			Message:    reason,
+			StackTrace: []string{},
+		},
+	})
+}
+
+// watchCancellation records a TrackCancellation if ctx, the context of a
+// request, ends before stop is called. It is recorded on a thread of its own,
+// since the handler keeps running concurrently.
+func (c *Client) watchCancellation(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	watched := DeriveThread(ctx)
+	stopAfter := context.AfterFunc(ctx, func() {
+		reason := "client disconnected"
+		if err := ctx.Err(); !errors.Is(err, context.Canceled) {
+			reason = err.Error()
+		}
+		c.TrackCancellation(watched, reason)
+	})
+	return func() { stopAfter() }
+}
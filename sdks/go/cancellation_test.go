@@ -0,0 +1,118 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventsAfterCancellationAreTagged(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	base := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	ctx, cancel := context.WithCancel(base)
+	c.TrackStateChange(ctx, "before", 0, 1, "cancellation_test.go:1", "Write")
+	cancel()
+	c.TrackStateChange(ctx, "after", 0, 1, "cancellation_test.go:2", "Write")
+
+	expired, cancelDeadline := context.WithDeadline(base, time.Now().Add(-time.Second))
+	defer cancelDeadline()
+	c.TrackStateChange(expired, "late", 0, 1, "cancellation_test.go:3", "Write")
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if _, ok := events[0].Metadata.Tags[ctxCanceledTag]; ok {
+		t.Errorf("expected no %s tag before cancellation, got %v", ctxCanceledTag, events[0].Metadata.Tags)
+	}
+	for i, want := range map[int]string{1: "context canceled", 2: "context deadline exceeded"} {
+		tags := events[i].Metadata.Tags
+		if tags[ctxCanceledTag] != "true" || tags[ctxErrTag] != want {
+			t.Errorf("event %d: expected %s=true and %s=%q, got %v", i, ctxCanceledTag, ctxErrTag, want, tags)
+		}
+	}
+}
+
+func TestMiddlewareRecordsClientDisconnect(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	reqCtx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		c.TrackStateChange(ctx, "order.status", "new", "processing", "cancellation_test.go:1", "Write")
+		disconnect()
+		<-ctx.Done()
+		c.TrackStateChange(ctx, "order.status", "processing", "paid", "cancellation_test.go:2", "Write")
+	}))
+	req := httptest.NewRequest("POST", "/orders", nil).WithContext(reqCtx)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var events []Event
+	var cancellation *Event
+	deadline := time.Now().Add(5 * time.Second)
+	for cancellation == nil && time.Now().Before(deadline) {
+		events = bufferedEvents(c)
+		for i := range events {
+			if events[i].Kind.Error != nil && events[i].Kind.Error.ErrorType == cancellationErrorType {
+				cancellation = &events[i]
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cancellation == nil {
+		t.Fatalf("expected a cancellation event, got %+v", events)
+	}
+	if cancellation.Kind.Error.Message != "client disconnected" {
+		t.Errorf("unexpected cancellation reason %q", cancellation.Kind.Error.Message)
+	}
+	if cancellation.Metadata.ThreadID == events[0].Metadata.ThreadID || cancellation.TraceID != events[0].TraceID {
+		t.Errorf("expected the cancellation on a thread of its own in the request's trace, got %+v", cancellation.Metadata)
+	}
+
+	var writes []Event
+	var response *Event
+	for i := range events {
+		switch {
+		case events[i].Kind.StateChange != nil:
+			writes = append(writes, events[i])
+		case events[i].Kind.HTTPResponse != nil:
+			response = &events[i]
+		}
+	}
+	if len(writes) != 2 || response == nil {
+		t.Fatalf("expected two writes and a response, got %+v", events)
+	}
+	if writes[0].Metadata.Tags[ctxCanceledTag] != "" {
+		t.Errorf("expected the write before the disconnect untagged, got %v", writes[0].Metadata.Tags)
+	}
+	for _, event := range []Event{writes[1], *response} {
+		if event.Metadata.Tags[ctxCanceledTag] != "true" {
+			t.Errorf("expected %s after the disconnect, got %v", event.Kind.Name(), event.Metadata.Tags)
+		}
+	}
+}
+
+func TestMiddlewareIgnoresCancellationAfterResponse(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	reqCtx, cancel := context.WithCancel(context.Background())
+
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(reqCtx))
+	// net/http cancels the request context once the handler returns
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+
+	for _, event := range bufferedEvents(c) {
+		if event.Kind.Error != nil {
+			t.Errorf("expected no cancellation for a completed request, got %+v", event.Kind.Error)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Capability names an optional wire field or behavior the collector may support.
+type Capability string
+
+const (
+	// CapabilityAntiPatternEvents is the AntiPattern event kind. Without it,
+	// anti-pattern warnings are sent as Error events.
+	CapabilityAntiPatternEvents Capability = "anti_pattern_events"
+	// CapabilityScatterFields is the scatter_id/scatter_index raceway-clock payload fields.
+	CapabilityScatterFields Capability = "scatter_fields"
+	// CapabilityOriginTraceID is the origin_trace_id raceway-clock payload field.
+	CapabilityOriginTraceID Capability = "origin_trace_id"
+	// CapabilityFences is the Fence event kind and the fences raceway-clock payload
+	// field. Without it, fences are sent as FunctionCall events and not propagated.
+	CapabilityFences Capability = "fences"
+	// CapabilityAnnotations is the Annotation event kind. Without it, annotations
+	// are sent as FunctionCall events.
+	CapabilityAnnotations Capability = "annotations"
+	// CapabilityClientEnvelope is the "client" block of the batch envelope
+	// carrying the batch sequence number and dropped-event count.
+	CapabilityClientEnvelope Capability = "client_envelope"
+	// CapabilityRegion is the region event metadata and raceway-clock payload field.
+	CapabilityRegion Capability = "region"
+	// CapabilityCustomEvents is the Custom event kind. Without it, custom
+	// events are sent as FunctionCall events.
+	CapabilityCustomEvents Capability = "custom_events"
+	// CapabilityAsyncJoin is the AsyncJoin event kind. Without it, joins are
+	// sent as AsyncAwait events on the task ID.
+	CapabilityAsyncJoin Capability = "async_join"
+	// CapabilityLockContention is the LockContention event kind. Without it,
+	// contention is sent as FunctionCall events.
+	CapabilityLockContention Capability = "lock_contention"
+	// CapabilityCacheOps is the CacheOp event kind. Without it, cache
+	// operations are sent as StateChange events on their variable.
+	CapabilityCacheOps Capability = "cache_ops"
+	// CapabilityExternalCalls is the ExternalCall event kind. Without it,
+	// external calls are sent as FunctionCall events.
+	CapabilityExternalCalls Capability = "external_calls"
+	// CapabilityTransactions is the Transaction event kind. Without it,
+	// transaction boundaries are sent as FunctionCall events.
+	CapabilityTransactions Capability = "transactions"
+	// CapabilityULIDEventIDs is event IDs in IDFormatULID. The Raceway server
+	// stores event IDs as UUIDs, so it is not negotiated: IDFormatULID needs
+	// it in Config.ForceCapabilities and otherwise falls back to IDFormatUUID7.
+	CapabilityULIDEventIDs Capability = "ulid_event_ids"
+)
+
+// defaultCapabilityRefresh is how often negotiated capabilities are refreshed.
+const defaultCapabilityRefresh = 5 * time.Minute
+
+// AllCapabilities lists every capability this SDK can emit, for
+// Config.ForceCapabilities where the events do not go to a collector.
+var AllCapabilities = []Capability{
+	CapabilityAntiPatternEvents,
+	CapabilityScatterFields,
+	CapabilityOriginTraceID,
+	CapabilityFences,
+	CapabilityAnnotations,
+	CapabilityClientEnvelope,
+	CapabilityRegion,
+	CapabilityCustomEvents,
+	CapabilityAsyncJoin,
+	CapabilityLockContention,
+	CapabilityCacheOps,
+	CapabilityExternalCalls,
+	CapabilityTransactions,
+	CapabilityULIDEventIDs,
+}
+
+// routelessCollectorCapabilities are emitted to a collector without a
+// /capabilities route, such as the bundled Raceway server. It ignores fields
+// it does not know but rejects a batch with an event kind it does not know,
+// so newer fields are sent and newer kinds are still downgraded.
+var routelessCollectorCapabilities = []Capability{
+	CapabilityScatterFields,
+	CapabilityOriginTraceID,
+	CapabilityClientEnvelope,
+	CapabilityRegion,
+}
+
+// CapabilitySet is the set of optional features the SDK may emit.
+// Every feature that adds wire surface consults it through Has.
+type CapabilitySet struct {
+	caps map[Capability]bool
+}
+
+func newCapabilitySet(caps ...Capability) CapabilitySet {
+	set := CapabilitySet{caps: make(map[Capability]bool, len(caps))}
+	for _, c := range caps {
+		set.caps[c] = true
+	}
+	return set
+}
+
+// Has reports whether capability may be used.
+func (s CapabilitySet) Has(capability Capability) bool {
+	return s.caps[capability]
+}
+
+// List returns the enabled capabilities in sorted order.
+func (s CapabilitySet) List() []Capability {
+	list := make([]Capability, 0, len(s.caps))
+	for c := range s.caps {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// with returns a copy of s that also includes caps.
+func (s CapabilitySet) with(caps []Capability) CapabilitySet {
+	if len(caps) == 0 {
+		return s
+	}
+	merged := newCapabilitySet(caps...)
+	for c := range s.caps {
+		merged.caps[c] = true
+	}
+	return merged
+}
+
+// capabilityState holds the negotiated capabilities together with
+// Config.ForceCapabilities, so each event reads them without merging.
+type capabilityState struct {
+	mu  sync.RWMutex
+	set CapabilitySet
+}
+
+func (s *capabilityState) get() CapabilitySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.set
+}
+
+func (s *capabilityState) store(set CapabilitySet) {
+	s.mu.Lock()
+	s.set = set
+	s.mu.Unlock()
+}
+
+// Capabilities returns the capabilities the SDK currently emits: those the
+// collector advertised in the last handshake, plus Config.ForceCapabilities.
+// Until a handshake succeeds, only the forced ones are emitted.
+func (c *Client) Capabilities() CapabilitySet {
+	return c.capabilities.get()
+}
+
+// negotiated stores the capabilities the collector advertised.
+func (c *Client) negotiated(set CapabilitySet) {
+	c.capabilities.store(set.with(c.config.ForceCapabilities))
+}
+
+// handshakeNeeded reports whether negotiation can change what is emitted,
+// which it cannot once Config.ForceCapabilities enables every capability.
+func (c *Client) handshakeNeeded() bool {
+	forced := newCapabilitySet(c.config.ForceCapabilities...)
+	for _, capability := range AllCapabilities {
+		if !forced.Has(capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ping requests the collector's supported capabilities from <Endpoint>/capabilities,
+// which responds with a JSON array of capability names. A collector without
+// that route is sent the fields it ignores but none of the newer event kinds.
+// On failure the SDK falls back to emitting no optional capabilities.
+func (c *Client) Ping(ctx context.Context) error {
+	caps, err := c.fetchCapabilities(ctx)
+	if err != nil {
+		c.negotiated(newCapabilitySet())
+		return err
+	}
+	c.negotiated(newCapabilitySet(caps...))
+	return nil
+}
+
+func (c *Client) fetchCapabilities(ctx context.Context) ([]Capability, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/capabilities", c.baseURL), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return routelessCollectorCapabilities, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("raceway: capabilities request returned status %d", resp.StatusCode)
+	}
+
+	var caps []Capability
+	if err := json.Unmarshal(body, &caps); err != nil {
+		// Accept the server's {"success": true, "data": [...]} envelope as well.
+		var envelope struct {
+			Data []Capability `json:"data"`
+		}
+		if envErr := json.Unmarshal(body, &envelope); envErr != nil {
+			return nil, fmt.Errorf("raceway: decoding capabilities: %w", err)
+		}
+		caps = envelope.Data
+	}
+	return caps, nil
+}
+
+// negotiateCapabilities performs the startup handshake and refreshes it periodically.
+func (c *Client) negotiateCapabilities() {
+	interval := c.config.CapabilityRefreshInterval
+	if interval <= 0 {
+		interval = defaultCapabilityRefresh
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := c.Ping(ctx); err != nil {
+			c.logger.Debugf("Capability negotiation failed: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+// downgradeEvents re-encodes events whose kinds the collector does not support.
+func (c *Client) downgradeEvents(events []Event) {
+	caps := c.Capabilities()
+	for i := range events {
+		switch {
+		case events[i].Kind.AntiPattern != nil && !caps.Has(CapabilityAntiPatternEvents):
+			events[i] = downgradeAntiPattern(events[i])
+		case events[i].Kind.Fence != nil && !caps.Has(CapabilityFences):
+			events[i] = downgradeFence(events[i])
+		case events[i].Kind.Annotation != nil && !caps.Has(CapabilityAnnotations):
+			events[i] = downgradeAnnotation(events[i])
+		case events[i].Kind.Custom != nil && !caps.Has(CapabilityCustomEvents):
+			events[i] = downgradeCustom(events[i])
+		case events[i].Kind.AsyncJoin != nil && !caps.Has(CapabilityAsyncJoin):
+			events[i] = downgradeAsyncJoin(events[i])
+		case events[i].Kind.LockContention != nil && !caps.Has(CapabilityLockContention):
+			events[i] = downgradeLockContention(events[i])
+		case events[i].Kind.CacheOp != nil && !caps.Has(CapabilityCacheOps):
+			events[i] = downgradeCacheOp(events[i])
+		case events[i].Kind.ExternalCall != nil && !caps.Has(CapabilityExternalCalls):
+			events[i] = downgradeExternalCall(events[i])
+		case events[i].Kind.Transaction != nil && !caps.Has(CapabilityTransactions):
+			events[i] = downgradeTransaction(events[i])
+		}
+	}
+}
+
+// downgradeAsyncJoin re-encodes an AsyncJoin as an AsyncAwait on the task ID
+// for collectors that do not understand the AsyncJoin kind. The event's own
+// causality vector already includes the child's clock.
+func downgradeAsyncJoin(event Event) Event {
+	data := event.Kind.AsyncJoin
+	event.Kind = EventKind{
+		AsyncAwait: &AsyncAwaitData{
+			FutureID:  data.TaskID,
+			AwaitedAt: data.JoinedAt,
+		},
+	}
+	return event
+}
+
+// downgradeAntiPattern re-encodes an AntiPattern warning as an Error event for
+// collectors that do not understand the AntiPattern kind.
+func downgradeAntiPattern(event Event) Event {
+	data := event.Kind.AntiPattern
+	event.Kind = EventKind{
+		Error: &ErrorData{
+			ErrorType:  "AntiPattern:" + data.Pattern,
+			Message:    data.Message,
+			StackTrace: data.Locations,
+		},
+	}
+	return event
+}
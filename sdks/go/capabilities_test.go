@@ -0,0 +1,219 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func capabilityServer(t *testing.T, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/capabilities" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCapabilitiesEmptyUntilNegotiated(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ForceCapabilities = nil })
+	if caps := c.Capabilities().List(); len(caps) != 0 {
+		t.Fatalf("expected no capabilities before a handshake, got %v", caps)
+	}
+
+	forced := newBufferingClient(t, nil)
+	for _, capability := range AllCapabilities {
+		if !forced.Capabilities().Has(capability) {
+			t.Errorf("expected forced capability %s", capability)
+		}
+	}
+}
+
+func TestHandshakeRunsByDefault(t *testing.T) {
+	server := capabilityServer(t, http.StatusOK, `["transactions"]`)
+	c := New(Config{ServiceName: "test-service", ServerURL: server.URL, Sink: discardSink{}})
+	defer c.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.Capabilities().Has(CapabilityTransactions) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the startup handshake to negotiate transactions")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSyncModeNegotiatesOnFirstFlush(t *testing.T) {
+	server := capabilityServer(t, http.StatusOK, `["transactions"]`)
+	c := New(Config{ServiceName: "test-service", ServerURL: server.URL, SyncMode: true, Sink: discardSink{}})
+	defer c.Shutdown()
+
+	if c.Capabilities().Has(CapabilityTransactions) {
+		t.Fatal("expected no handshake before the first flush")
+	}
+	if err := c.FlushSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Capabilities().Has(CapabilityTransactions) {
+		t.Error("expected the first flush to negotiate transactions")
+	}
+}
+
+func TestForcingEveryCapabilitySkipsHandshake(t *testing.T) {
+	var handshakes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/capabilities" {
+			handshakes.Add(1)
+		}
+	}))
+	defer server.Close()
+	c := New(Config{ServiceName: "test-service", ServerURL: server.URL, SyncMode: true, ForceCapabilities: AllCapabilities})
+	defer c.Shutdown()
+
+	if err := c.FlushSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := handshakes.Load(); n != 0 {
+		t.Errorf("expected no handshake with every capability forced, got %d", n)
+	}
+}
+
+func TestPingNegotiatesAdvertisedCapabilities(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want map[Capability]bool
+	}{
+		{"array", `["scatter_fields","future_feature"]`, map[Capability]bool{CapabilityScatterFields: true}},
+		{"envelope", `{"success":true,"data":["anti_pattern_events"]}`, map[Capability]bool{CapabilityAntiPatternEvents: true}},
+		{"empty", `[]`, map[Capability]bool{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := capabilityServer(t, http.StatusOK, tc.body)
+			c := newBufferingClient(t, func(cfg *Config) {
+				cfg.ServerURL = server.URL
+				cfg.ForceCapabilities = nil
+			})
+			if err := c.Ping(context.Background()); err != nil {
+				t.Fatalf("Ping: %v", err)
+			}
+			caps := c.Capabilities()
+			for _, capability := range []Capability{CapabilityAntiPatternEvents, CapabilityScatterFields} {
+				if caps.Has(capability) != tc.want[capability] {
+					t.Errorf("Has(%s) = %v, want %v", capability, caps.Has(capability), tc.want[capability])
+				}
+			}
+		})
+	}
+}
+
+func TestFailedHandshakeIsConservative(t *testing.T) {
+	server := capabilityServer(t, http.StatusServiceUnavailable, "")
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.ForceCapabilities = nil
+		cfg.AntiPatternDetection = true
+	})
+	if err := c.Ping(context.Background()); err == nil {
+		t.Fatal("expected handshake error")
+	}
+	if len(c.Capabilities().List()) != 0 {
+		t.Fatalf("expected no capabilities after failed handshake, got %v", c.Capabilities().List())
+	}
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	sg := c.StartScatter(ctx, "query", 1)
+	parsed := parseHeaders(t, sg.Headers(0))
+	if parsed.ScatterID != "" {
+		t.Errorf("expected scatter fields to be suppressed, got %q", parsed.ScatterID)
+	}
+
+	warning := downgradeAntiPattern(Event{Kind: EventKind{AntiPattern: &AntiPatternData{Pattern: AntiPatternUnprotectedWrite, Message: "m"}}})
+	if warning.Kind.AntiPattern != nil || warning.Kind.Error == nil || warning.Kind.Error.ErrorType != "AntiPattern:unprotected_write" {
+		t.Errorf("expected anti-pattern to be downgraded to an Error event, got %+v", warning.Kind)
+	}
+}
+
+// TestCollectorWithoutCapabilitiesRoute runs against a collector that, like
+// the bundled Raceway server, has no /capabilities route.
+func TestCollectorWithoutCapabilitiesRoute(t *testing.T) {
+	var batch struct {
+		Events []map[string]json.RawMessage `json:"events"`
+		Client json.RawMessage              `json:"client"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("decoding batch: %v", err)
+		}
+	}))
+	defer server.Close()
+	c := New(Config{ServiceName: "test-service", ServerURL: server.URL, Region: "eu-west-1", SyncMode: true})
+	defer c.Shutdown()
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackCustom(ctx, "audit", nil)
+	if err := c.FlushSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Capability{CapabilityClientEnvelope, CapabilityOriginTraceID, CapabilityRegion, CapabilityScatterFields}
+	if got := c.Stats().Capabilities; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the fields a collector ignores, got %v", got)
+	}
+	if len(batch.Events) != 1 || batch.Client == nil {
+		t.Fatalf("expected one event in a client envelope, got %d events and %s", len(batch.Events), batch.Client)
+	}
+	if kind := string(batch.Events[0]["kind"]); !strings.Contains(kind, "FunctionCall") {
+		t.Errorf("expected the Custom event downgraded, got %s", kind)
+	}
+	if metadata := string(batch.Events[0]["metadata"]); !strings.Contains(metadata, `"region":"eu-west-1"`) {
+		t.Errorf("expected the region sent, got %s", metadata)
+	}
+}
+
+func TestForceCapabilitiesOverridesNegotiation(t *testing.T) {
+	server := capabilityServer(t, http.StatusOK, `[]`)
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.ForceCapabilities = []Capability{CapabilityScatterFields}
+	})
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	sg := c.StartScatter(ctx, "query", 2)
+	parsed := parseHeaders(t, sg.Headers(1))
+	if parsed.ScatterID != sg.ID() || parsed.ScatterIndex != 1 {
+		t.Errorf("expected forced scatter fields, got %q/%d", parsed.ScatterID, parsed.ScatterIndex)
+	}
+	if c.Capabilities().Has(CapabilityAntiPatternEvents) {
+		t.Errorf("expected only forced capabilities")
+	}
+}
+
+func parseHeaders(t *testing.T, headers map[string]string) ParsedTraceContext {
+	t.Helper()
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return ParseIncomingHeaders(h, "downstream", "instance")
+}
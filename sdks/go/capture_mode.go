@@ -0,0 +1,222 @@
+package raceway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Capture modes for Config.CaptureMode.
+const (
+	// CaptureModeAll sends every captured event. It is the default.
+	CaptureModeAll = "all"
+	// CaptureModeOnError holds each trace's events until the trace is
+	// committed, by a 5xx response, a panic, or CommitTrace, and discards
+	// them when it is abandoned.
+	CaptureModeOnError = "on_error"
+)
+
+// DefaultPendingTraceTTL is used when Config.PendingTraceTTL is zero.
+const DefaultPendingTraceTTL = time.Minute
+
+// traceDecision is what became of a trace held by CaptureModeOnError.
+type traceDecision int
+
+const (
+	tracePending traceDecision = iota
+	traceCommitted
+	traceAbandoned
+)
+
+// heldTrace is one trace's events in capture order, kept in a ring of at
+// most limit events that overwrites the oldest.
+type heldTrace struct {
+	events   []Event
+	start    int
+	decision traceDecision
+	touched  time.Time
+}
+
+func (t *heldTrace) push(event Event, limit int) {
+	if len(t.events) < limit {
+		t.events = append(t.events, event)
+		return
+	}
+	t.events[t.start] = event
+	t.start = (t.start + 1) % limit
+}
+
+// take returns the held events in capture order and releases them.
+func (t *heldTrace) take() []Event {
+	events := append(t.events[t.start:len(t.events):len(t.events)], t.events[:t.start]...)
+	t.events, t.start = nil, 0
+	return events
+}
+
+// heldTraces holds the events of traces in CaptureModeOnError until each is
+// committed or abandoned. Decided traces are remembered until they have been
+// idle for ttl, so their later events follow the same decision.
+type heldTraces struct {
+	mu     sync.Mutex
+	limit  int
+	ttl    time.Duration
+	traces map[string]*heldTrace
+}
+
+func newHeldTraces(limit int, ttl time.Duration) *heldTraces {
+	return &heldTraces{limit: limit, ttl: ttl, traces: make(map[string]*heldTrace)}
+}
+
+// hold keeps event unless its trace was committed, in which case it reports
+// false and the event is sent as usual. Events of abandoned traces are
+// discarded.
+func (h *heldTraces) hold(event Event, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.traces[event.TraceID]
+	if t == nil {
+		t = &heldTrace{}
+		h.traces[event.TraceID] = t
+	}
+	t.touched = now
+	switch t.decision {
+	case traceCommitted:
+		return false
+	case traceAbandoned:
+		return true
+	}
+	t.push(event, h.limit)
+	return true
+}
+
+// commit marks traceID committed and passes its held events to send, in
+// capture order.
+func (h *heldTraces) commit(traceID string, now time.Time, send func(Event) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.traces[traceID]
+	if t == nil {
+		t = &heldTrace{}
+		h.traces[traceID] = t
+	}
+	t.touched = now
+	t.decision = traceCommitted
+	for _, event := range t.take() {
+		send(event)
+	}
+}
+
+// abandon discards the held events of traceID, unless it was committed.
+func (h *heldTraces) abandon(traceID string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.traces[traceID]
+	if t == nil {
+		t = &heldTrace{}
+		h.traces[traceID] = t
+	}
+	t.touched = now
+	if t.decision == traceCommitted {
+		return
+	}
+	t.decision = traceAbandoned
+	t.events, t.start = nil, 0
+}
+
+// edit applies edit to the held event eventID of traceID and reports whether
+// it was found.
+func (h *heldTraces) edit(traceID, eventID string, edit func(*Event)) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.traces[traceID]
+	if t == nil {
+		return false
+	}
+	for i := range t.events {
+		if t.events[i].ID == eventID {
+			edit(&t.events[i])
+			return true
+		}
+	}
+	return false
+}
+
+// reap forgets traces idle for longer than ttl, abandoning those still
+// pending, and returns how many were abandoned.
+func (h *heldTraces) reap(now time.Time) (abandoned int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for traceID, t := range h.traces {
+		if now.Sub(t.touched) <= h.ttl {
+			continue
+		}
+		if t.decision == tracePending {
+			abandoned++
+		}
+		delete(h.traces, traceID)
+	}
+	return abandoned
+}
+
+// CommitTrace sends the events of ctx's trace held by CaptureModeOnError, in
+// capture order, and sends its later events as they are captured.
+// Middleware commits the trace of a request answered with a 5xx status, and
+// a recorded panic commits its trace. It does nothing in other capture modes.
+func (c *Client) CommitTrace(ctx context.Context) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil || c.held == nil {
+		return
+	}
+	c.held.commit(rctx.TraceID, time.Now(), c.enqueue)
+	if c.config.SyncMode {
+		c.flushFullBatch()
+	}
+}
+
+// AbandonTrace discards the events of ctx's trace held by
+// CaptureModeOnError, and its later ones, unless the trace was committed.
+// Middleware abandons the trace of every request it does not commit; traces
+// neither committed nor abandoned are abandoned once idle for
+// Config.PendingTraceTTL. It does nothing in other capture modes.
+func (c *Client) AbandonTrace(ctx context.Context) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil || c.held == nil {
+		return
+	}
+	c.held.abandon(rctx.TraceID, time.Now())
+}
+
+// settleTrace commits the trace of a request answered with status 500 or
+// above and abandons it otherwise.
+func (c *Client) settleTrace(ctx context.Context, status int) {
+	if c.held == nil {
+		return
+	}
+	if status >= 500 {
+		c.CommitTrace(ctx)
+		return
+	}
+	c.AbandonTrace(ctx)
+}
+
+// reapHeldTraces abandons idle held traces until Shutdown.
+func (c *Client) reapHeldTraces() {
+	ticker := time.NewTicker(c.held.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if n := c.held.reap(now); n > 0 {
+				c.logger.Debugf("Abandoned %d traces idle for %v", n, c.held.ttl)
+			}
+		case <-c.stopChan:
+			return
+		}
+	}
+}
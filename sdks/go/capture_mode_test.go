@@ -0,0 +1,165 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnErrorDiscardsSuccessfulRequests(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.CaptureMode = CaptureModeOnError })
+	var reqCtx context.Context
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCtx = r.Context()
+		c.TrackStateChange(r.Context(), "balance", 100, 90, "capture_mode_test.go:1", "Write")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", nil))
+
+	// Events of the abandoned trace captured later, such as by a goroutine
+	// the handler started, are discarded too
+	c.TrackStateChange(reqCtx, "balance", 90, 80, "capture_mode_test.go:2", "Write")
+	if events := bufferedEvents(c); len(events) != 0 {
+		t.Fatalf("expected no events from a successful request, got %d", len(events))
+	}
+}
+
+func TestOnErrorSendsFailedRequestsInOrder(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.CaptureMode = CaptureModeOnError })
+	var reqCtx context.Context
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCtx = r.Context()
+		c.TrackStateChange(r.Context(), "balance", 100, 90, "capture_mode_test.go:1", "Write")
+		c.TrackStateChange(r.Context(), "ledger", 0, 1, "capture_mode_test.go:2", "Write")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", nil))
+	c.TrackStateChange(reqCtx, "audit", 0, 1, "capture_mode_test.go:3", "Write")
+
+	events := bufferedEvents(c)
+	want := []string{"HttpRequest", "StateChange", "StateChange", "HttpResponse", "StateChange"}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(events))
+	}
+	for i, event := range events {
+		if event.Kind.Name() != want[i] {
+			t.Errorf("event %d: expected %s, got %s", i, want[i], event.Kind.Name())
+		}
+		if i > 0 && event.Seq <= events[i-1].Seq {
+			t.Errorf("event %d: expected capture order, got seq %d after %d", i, event.Seq, events[i-1].Seq)
+		}
+	}
+	if events[3].Kind.HTTPResponse.Status != http.StatusBadGateway {
+		t.Errorf("unexpected response %+v", events[3].Kind.HTTPResponse)
+	}
+}
+
+func TestOnErrorCommitsPanickingRequests(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureMode = CaptureModeOnError
+		cfg.RecoverPanics = true
+	})
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.TrackStateChange(r.Context(), "balance", 100, 90, "capture_mode_test.go:1", "Write")
+		panic("ledger unavailable")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", nil))
+
+	events := bufferedEvents(c)
+	if len(events) != 4 || events[2].Kind.Error == nil || events[3].Kind.HTTPResponse == nil {
+		t.Fatalf("expected the request, write, panic and response, got %+v", events)
+	}
+}
+
+func TestCommitTraceOutsideMiddleware(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.CaptureMode = CaptureModeOnError })
+	ctx := c.StartTrace(context.Background(), "reconcile")
+	other := c.StartTrace(context.Background(), "reconcile")
+	c.TrackStateChange(ctx, "balance", 100, 90, "capture_mode_test.go:1", "Write")
+	c.TrackStateChange(other, "balance", 100, 90, "capture_mode_test.go:2", "Write")
+
+	c.CommitTrace(ctx)
+	c.AbandonTrace(ctx) // a trace once committed stays committed
+	c.TrackStateChange(ctx, "balance", 90, 80, "capture_mode_test.go:3", "Write")
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected the committed trace's 3 events, got %d", len(events))
+	}
+	for _, event := range events {
+		if event.TraceID != FromContext(ctx).TraceID {
+			t.Errorf("expected only the committed trace, got an event of %s", event.TraceID)
+		}
+	}
+}
+
+func TestHeldTraceKeepsLatestEvents(t *testing.T) {
+	var trace heldTrace
+	for i := 0; i < 5; i++ {
+		trace.push(Event{Seq: uint64(i + 1)}, 3)
+	}
+	events := trace.take()
+	if len(events) != 3 || events[0].Seq != 3 || events[1].Seq != 4 || events[2].Seq != 5 {
+		t.Fatalf("expected the latest 3 events in order, got %+v", events)
+	}
+	if len(trace.events) != 0 {
+		t.Errorf("expected take to release the events, got %d", len(trace.events))
+	}
+}
+
+func TestIdleHeldTracesAreAbandoned(t *testing.T) {
+	logger := &capturingLogger{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureMode = CaptureModeOnError
+		cfg.PendingTraceTTL = 20 * time.Millisecond
+		cfg.Logger = logger
+		cfg.Debug = true
+	})
+	ctx := c.StartTrace(context.Background(), "job")
+	c.TrackStateChange(ctx, "balance", 100, 90, "capture_mode_test.go:1", "Write")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.held.mu.Lock()
+		n := len(c.held.traces)
+		c.held.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the idle trace to be reaped, %d held", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Committing after the TTL sends only what is captured from then on
+	c.CommitTrace(ctx)
+	if events := bufferedEvents(c); len(events) != 0 {
+		t.Fatalf("expected the reaped trace's events discarded, got %d", len(events))
+	}
+	abandoned := false
+	for _, message := range logger.logged("debug") {
+		abandoned = abandoned || strings.HasPrefix(message, "Abandoned 1 traces idle")
+	}
+	if !abandoned {
+		t.Errorf("expected the abandoned trace to be logged, got %v", logger.logged("debug"))
+	}
+}
+
+func TestUnknownCaptureModeSendsEverything(t *testing.T) {
+	logger := &capturingLogger{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureMode = "sometimes"
+		cfg.Logger = logger
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", 100, 90, "capture_mode_test.go:1", "Write")
+	if len(bufferedEvents(c)) != 1 || len(logger.logged("warn")) != 1 {
+		t.Errorf("expected the event sent and a warning, got warnings %v", logger.logged("warn"))
+	}
+}
@@ -0,0 +1,146 @@
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Carrier reads and writes propagation headers on a transport, so trace
+// context can cross Kafka, NATS or AMQP messages as well as HTTP requests.
+// Header names are matched case-insensitively by the adapters in this package.
+type Carrier interface {
+	Set(key, value string)
+	Get(key string) string
+	Keys() []string
+}
+
+// valuesCarrier is implemented by carriers that can hold several values for a
+// header, such as HTTP. Headers whose values may be split across fields, like
+// baggage, are read with Values when it is available.
+type valuesCarrier interface {
+	Values(key string) []string
+}
+
+// MapCarrier is a Carrier backed by a map, e.g. the headers of a NATS message
+// converted to a map or message attributes of a queue.
+type MapCarrier map[string]string
+
+// Set sets key to value, replacing any key that differs only in case.
+func (m MapCarrier) Set(key, value string) {
+	for k := range m {
+		if k != key && strings.EqualFold(k, key) {
+			delete(m, k)
+		}
+	}
+	m[key] = value
+}
+
+// Get returns the value of key, matched case-insensitively.
+func (m MapCarrier) Get(key string) string {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// Keys returns the keys in sorted order.
+func (m MapCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HeaderCarrier adapts http.Header to Carrier.
+type HeaderCarrier http.Header
+
+// Set sets the header key to value.
+func (h HeaderCarrier) Set(key, value string) { http.Header(h).Set(key, value) }
+
+// Get returns the first value of the header key.
+func (h HeaderCarrier) Get(key string) string { return http.Header(h).Get(key) }
+
+// Values returns every value of the header key.
+func (h HeaderCarrier) Values(key string) []string { return http.Header(h).Values(key) }
+
+// Keys returns the header names in sorted order.
+func (h HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// carrierValues returns the values of key in carrier, using Values when the
+// carrier supports several values per key.
+func carrierValues(carrier Carrier, key string) []string {
+	if vc, ok := carrier.(valuesCarrier); ok {
+		return vc.Values(key)
+	}
+	if v := carrier.Get(key); v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+// Inject writes the propagation headers for ctx to carrier, in every
+// configured format, like PropagationHeaders does for HTTP.
+//
+// Example:
+//
+//	headers := raceway.MapCarrier{}
+//	if err := client.Inject(ctx, headers); err != nil {
+//	    return err
+//	}
+//	for k, v := range headers {
+//	    msg.Header.Set(k, v)
+//	}
+func (c *Client) Inject(ctx context.Context, carrier Carrier) error {
+	if BuildDisabled || c.disabled {
+		return nil
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		if c.config.Strict && !isUntraced(ctx) {
+			c.strictViolation(StrictMissingContext, "propagation headers injected outside of Raceway context")
+		}
+		return fmt.Errorf("raceway: propagation headers injected outside of active context")
+	}
+	c.injectContext(carrier, rctx)
+	return nil
+}
+
+// Extract parses the trace context in headers, as ParseIncomingHeaders does
+// for HTTP. Empty serviceName and instanceID default to the client's. Use
+// ContextFromParsed to continue the trace.
+func (c *Client) Extract(headers Carrier, serviceName, instanceID string) ParsedTraceContext {
+	if serviceName == "" {
+		serviceName = c.config.ServiceName
+	}
+	if instanceID == "" {
+		instanceID = c.instanceID
+	}
+	return parseIncomingHeaders(headers, serviceName, instanceID, c.componentRegion())
+}
+
+// ContextFromParsed returns a copy of ctx carrying a Raceway context that
+// continues the trace in parsed, as Middleware does for each request. Record
+// the message's receipt with it, e.g. with TrackFunctionCall.
+func (c *Client) ContextFromParsed(ctx context.Context, parsed ParsedTraceContext) context.Context {
+	if BuildDisabled || c.disabled {
+		return ctx
+	}
+	return c.contextFromParsed(ctx, parsed)
+}
@@ -0,0 +1,100 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCarrierMultiHopPropagation(t *testing.T) {
+	a := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "service-a", "a1" })
+	b := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "service-b", "b1" })
+	c := newBufferingClient(t, nil)
+
+	// A publishes a message to B
+	ctxA := a.newContext(context.Background(), validTraceID)
+	FromContext(ctxA).Baggage = map[string]string{"tenant": "acme"}
+	messageAB := MapCarrier{}
+	if err := a.Inject(ctxA, messageAB); err != nil {
+		t.Fatal(err)
+	}
+
+	// B consumes it and publishes to C
+	parsedB := b.Extract(messageAB, "", "")
+	if parsedB.TraceID != validTraceID || !parsedB.Distributed || parsedB.Baggage["tenant"] != "acme" {
+		t.Fatalf("expected B to continue the trace, got %+v", parsedB)
+	}
+	ctxB := b.ContextFromParsed(context.Background(), parsedB)
+	b.TrackStateChange(ctxB, "orders", nil, 1, "carrier_test.go:1", "Write")
+	messageBC := MapCarrier{}
+	if err := b.Inject(ctxB, messageBC); err != nil {
+		t.Fatal(err)
+	}
+
+	parsedC := c.Extract(messageBC, "service-c", "c1")
+	if parsedC.TraceID != validTraceID {
+		t.Error("trace ID should be preserved")
+	}
+	if parsedC.ParentSpanID == nil || *parsedC.ParentSpanID != FromContext(ctxB).SpanID {
+		t.Error("parent span ID should reference B's span ID")
+	}
+	want := map[string]uint64{"service-a#a1": 0, "service-b#b1": 1, "service-c#c1": 0}
+	if len(parsedC.ClockVector) != len(want) {
+		t.Fatalf("expected %v, got %v", want, parsedC.ClockVector)
+	}
+	for component, value := range want {
+		if !hasClockComponent(parsedC.ClockVector, component, value) {
+			t.Errorf("expected %s:%d, got %v", component, value, parsedC.ClockVector)
+		}
+	}
+}
+
+func TestInjectMatchesPropagationHeaders(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.PropagationFormats = []string{PropagationFormatB3} })
+	ctx := NewContext(context.Background(), validTraceID, "test-service", "test-instance")
+
+	carrier := MapCarrier{}
+	if err := c.Inject(ctx, carrier); err != nil {
+		t.Fatal(err)
+	}
+	headers, err := c.PropagationHeaders(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(carrier) != len(headers) {
+		t.Fatalf("expected the same headers as PropagationHeaders, got %v and %v", carrier.Keys(), headers)
+	}
+	for k := range headers {
+		if carrier.Get(k) == "" {
+			t.Errorf("expected %s to be injected", k)
+		}
+	}
+
+	// B3 read back from a carrier keyed in another case
+	lower := MapCarrier{}
+	for _, k := range carrier.Keys() {
+		if k != traceparentHeader && k != racewayClockHeader {
+			lower[strings.ToLower(k)] = carrier[k]
+		}
+	}
+	if parsed := c.Extract(lower, "", ""); parsed.TraceID != validTraceID {
+		t.Errorf("expected B3 headers to carry the trace, got %s", parsed.TraceID)
+	}
+
+	if err := c.Inject(context.Background(), MapCarrier{}); err == nil {
+		t.Error("expected an error outside of a Raceway context")
+	}
+}
+
+func TestMapCarrierMatchesKeysCaseInsensitively(t *testing.T) {
+	carrier := MapCarrier{"Traceparent": validTraceparent}
+	if carrier.Get("traceparent") != validTraceparent {
+		t.Errorf("expected a case-insensitive match")
+	}
+	carrier.Set("traceparent", "replaced")
+	if len(carrier) != 1 || carrier["traceparent"] != "replaced" {
+		t.Errorf("expected Set to replace the differently cased key, got %v", carrier)
+	}
+}
@@ -2,16 +2,14 @@
 package raceway
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,20 +21,273 @@ type Config struct {
 	// Deprecated: Use ServerURL instead for clarity
 	Endpoint string
 	// ServerURL is the Raceway server URL (default: http://localhost:8080)
-	// Preferred over Endpoint for clarity
+	// Preferred over Endpoint for clarity. A URL of the form
+	// unix:///var/run/raceway.sock sends to a server or agent listening on
+	// that Unix domain socket.
 	ServerURL string
+	// EventsPath is the path batches are posted to on the server (default: /events)
+	EventsPath string
+	// UseProxy sends to the server through the proxy named by the HTTP_PROXY,
+	// HTTPS_PROXY, and NO_PROXY environment variables; by default it is
+	// reached directly
+	UseProxy bool
+	// APIKey authenticates with servers that require it; sent as a bearer token
+	APIKey string
+	// Tags are attached to every event
+	Tags map[string]string
+	// TagProvider, if set, returns tags for each event from the context it is
+	// captured with, e.g. a user ID set by authentication middleware. Its tags
+	// take precedence over Tags; those attached with WithTags take precedence
+	// over its.
+	TagProvider func(ctx context.Context) map[string]string
+	// Compression encodes batch uploads; "gzip" or empty for none. Falls back
+	// to uncompressed if the server answers 415 Unsupported Media Type.
+	Compression string
+	// CompressionThreshold is the smallest batch payload, in bytes, that is
+	// compressed (default: 4096)
+	CompressionThreshold int
+	// MaxPayloadBytes is the largest uncompressed batch payload posted to the
+	// server; larger flushes are split into several posts (default: 1MB)
+	MaxPayloadBytes int
 	// ServiceName identifies this service in event metadata
 	ServiceName string
 	// InstanceID distinguishes this instance in distributed clocks (default: hostname-pid)
 	InstanceID string
+	// InstanceIDProvider, if set, names this instance when InstanceID is
+	// empty, e.g. KubernetesInstanceID. New calls it once; an empty result
+	// falls back to hostname-pid.
+	InstanceIDProvider func() string
 	// Environment specifies the deployment environment (development, staging, production)
 	Environment string
 	// BatchSize is the number of events to buffer before sending (default: 50)
 	BatchSize int
 	// FlushInterval is how often to flush buffered events (default: 1 second)
 	FlushInterval time.Duration
+	// SyncMode runs no background writer or flush goroutines, for platforms
+	// such as AWS Lambda that freeze them between invocations. FlushInterval
+	// is ignored: the tracking call that fills a batch of BatchSize sends it,
+	// and the rest are sent by FlushSync, which should end every invocation.
+	SyncMode bool
+	// Disabled makes the client record and send nothing, at the cost of one
+	// branch per tracking call: no goroutines are started, Middleware and
+	// Transport return the handler or transport they are given, and tracking
+	// methods return before allocating. Building with the raceway_disabled
+	// tag disables every client and lets the compiler remove the calls.
+	Disabled bool
 	// Debug enables debug logging
 	Debug bool
+	// PreDetect flags writes whose old value is not the value last recorded
+	// for their variable in the trace, the lost update of a read-modify-write
+	// race, with the tag suspect=stale_read on the write and on the event that
+	// recorded the overwritten value. Such a trace is sent whatever its
+	// sampling decision or CaptureMode. Server-side detection is unaffected.
+	PreDetect bool
+	// SetAsDefault makes New register the client with SetDefault, so the
+	// package-level tracking functions use it
+	SetAsDefault bool
+	// Logger receives the SDK's diagnostic messages (default: "[Raceway] "
+	// lines on stdout, with debug messages only when Debug is set)
+	Logger Logger
+	// VariableAliases rewrites tracked variable names (old -> new) at capture time.
+	// Patterns may capture segments with %s, e.g. "accounts[%s].balance" -> "account:%s:balance"
+	VariableAliases map[string]string
+	// LockAliases rewrites lock IDs (old -> new) using the same rules as VariableAliases
+	LockAliases map[string]string
+	// AliasTransitionPeriod is how long rewritten events carry an aliased_from tag (default: forever)
+	AliasTransitionPeriod time.Duration
+	// ContinuationSecret signs trace continuation tokens (required for SuspendTrace/ResumeTrace)
+	ContinuationSecret []byte
+	// MaxSuspension is the maximum age of a continuation token before resuming starts
+	// a new linked trace instead (default: no limit)
+	MaxSuspension time.Duration
+	// AntiPatternDetection emits AntiPattern warning events for known race shapes,
+	// detected on the flush path
+	AntiPatternDetection bool
+	// Routes deliver matching events to dedicated sinks; unmatched events go to the server
+	Routes []Route
+	// RouteToAllMatches delivers each event to every matching route instead of only the first
+	RouteToAllMatches bool
+	// Strict reports SDK misuse that is otherwise silently ignored, such as tracking
+	// without a context. Intended for development and test profiles.
+	Strict bool
+	// StrictHandler receives strict-mode violations (default: PanicStrictHandler)
+	StrictHandler StrictHandler
+	// StrictDowngrade lists violation classes that are logged instead of handled
+	StrictDowngrade map[StrictClass]bool
+	// DisableGoroutineLabels skips setting raceway_trace/raceway_thread pprof labels
+	// on goroutines while they run with a Raceway context
+	DisableGoroutineLabels bool
+	// CapabilityRefreshInterval is how often the client asks the collector
+	// which optional wire fields it supports (default: 5m). Until the first
+	// handshake succeeds, none are emitted; in SyncMode the handshake runs
+	// once, on the first flush.
+	CapabilityRefreshInterval time.Duration
+	// ForceCapabilities are emitted whatever the collector advertises, for
+	// collectors known to support them or sinks that are not a collector;
+	// AllCapabilities enables every one
+	ForceCapabilities []Capability
+	// HeartbeatInterval, if set, is how often the client reports its service,
+	// instance, SDK version, counters, and Go runtime stats to <Endpoint>/heartbeat
+	// so the server can show idle instances as live. Servers without that
+	// endpoint receive ServiceHeartbeat Custom events instead.
+	HeartbeatInterval time.Duration
+	// RemoteConfigURL, if set, is polled every RemoteConfigInterval for a JSON
+	// document overriding the fields UpdateConfig may change, such as
+	// {"sample_rate": 0.1, "debug": true, "flush_interval": "5s"}
+	RemoteConfigURL string
+	// RemoteConfigInterval is how often RemoteConfigURL is polled (default: 5m)
+	RemoteConfigInterval time.Duration
+	// TraceIDAdapter keys incoming requests by an application-assigned ID before
+	// falling back to traceparent or a generated trace ID
+	TraceIDAdapter TraceIDAdapter
+	// DetectDuplicateRequests tags retried requests with duplicate_of_trace, matching
+	// on idempotency key or request body hash within DuplicateWindow
+	DetectDuplicateRequests bool
+	// DuplicateWindow is how long a request is remembered for duplicate detection (default: 30s)
+	DuplicateWindow time.Duration
+	// IdempotencyHeaders are the headers holding an idempotency key
+	// (default: Idempotency-Key, X-Idempotency-Key)
+	IdempotencyHeaders []string
+	// Region qualifies clock components as "service#instance@region" so identical
+	// instance IDs in different regions stay distinct (default: detected from
+	// RACEWAY_REGION, AWS_REGION, and similar environment variables)
+	Region string
+	// LegacyClockComponents keeps emitting "service#instance" clock components
+	// while a region rollout is in progress. Region is still reported in metadata.
+	LegacyClockComponents bool
+	// AnnotationSecret authenticates requests to AnnotateHandler; the handler
+	// rejects every request when it is empty
+	AnnotationSecret string
+	// MaxEventsPerTrace caps the events captured in one trace. The first
+	// event beyond it is replaced by an Error event of type
+	// "EventLimitExceeded" and later ones are dropped (default: 10,000)
+	MaxEventsPerTrace int
+	// CaptureMode selects which traces are sent. CaptureModeAll, the default,
+	// sends every event; CaptureModeOnError holds up to MaxEventsPerTrace of
+	// each trace's latest events and sends them only if the trace is
+	// committed, see CommitTrace
+	CaptureMode string
+	// RetainTraces keeps a copy of the events captured in the most recently
+	// active traces, so they can be written out with ExportTrace
+	RetainTraces bool
+	// MaxRetainedTraces is how many traces RetainTraces keeps; the trace
+	// that has gone longest without an event is evicted first (default: 100)
+	MaxRetainedTraces int
+	// PendingTraceTTL is how long a trace held by CaptureModeOnError may go
+	// without events before it is abandoned (default: 1 minute)
+	PendingTraceTTL time.Duration
+	// ShutdownTimeout bounds the final flush performed by Shutdown (default: 10 seconds)
+	ShutdownTimeout time.Duration
+	// SpillPath is a file Shutdown writes undelivered events to, as
+	// newline-delimited JSON, when the final flush fails or runs out of
+	// ShutdownTimeout. New buffers and deletes the events spilled there by
+	// an earlier process, so they are sent on the next flush.
+	SpillPath string
+	// LockContentionThreshold is the wait for a lock in WithLock,
+	// WithLockTimeout or WithRWLockRead from which a LockContention event is
+	// recorded (default: 10ms)
+	LockContentionThreshold time.Duration
+	// LockEventSampling records the LockAcquire and LockRelease events of one
+	// in every LockEventSampling WithRWLockRead calls (default: 1, every call).
+	// Reads that are skipped still put the lock in the lock set of events
+	// captured under it, and are still recorded as LockContention when they
+	// wait LockContentionThreshold or longer.
+	LockEventSampling int
+	// CaptureRequestBody records the body of requests traced by Middleware
+	// on their HttpRequest event, for JSON and text/* content types
+	CaptureRequestBody bool
+	// CaptureResponseBody records the body handlers write on the
+	// HttpResponse event Middleware tracks, for the same content types
+	CaptureResponseBody bool
+	// MaxBodyBytes is how much of a captured body is recorded; longer bodies
+	// are cut and suffixed with "...(truncated)" (default: 8KB)
+	MaxBodyBytes int
+	// MaxValueBytes is the longest string recorded within a tracked variable
+	// value, function argument, or return value; longer strings are cut and
+	// suffixed with "...(truncated)" (default: 4KB)
+	MaxValueBytes int
+	// MaxCollectionItems is how many elements of a slice or map within a
+	// tracked value are recorded; the rest are counted in a "__truncated"
+	// entry (default: 100)
+	MaxCollectionItems int
+	// RecoverPanics makes Middleware and racewaygin.Middleware answer a panicking
+	// handler with 500 Internal Server Error instead of re-panicking. The panic
+	// is recorded as an Error event either way.
+	RecoverPanics bool
+	// IgnorePaths lists request paths Middleware does not trace, such as
+	// health checks and static assets. A pattern is an exact path, such as
+	// "/health", or has a "*" at its end or start to match any path with that
+	// prefix or suffix, such as "/static/*" or "*.css"
+	IgnorePaths []string
+	// ShouldTrace, if set, is called by Middleware for each request not
+	// matched by IgnorePaths; the request is traced only if it returns true
+	ShouldTrace func(r *http.Request) bool
+	// MaxRetries is how many times a failed batch is resent to the server before
+	// its events are requeued for the next flush (default: 3 in DefaultConfig)
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry, doubled after each attempt
+	// and randomized by up to half to spread out retries (default: 100ms)
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries (default: 5 seconds)
+	MaxBackoff time.Duration
+	// MaxBufferedEvents caps buffered and requeued events together; the oldest
+	// events beyond the cap are dropped and counted in Stats (default: 10000).
+	// It also bounds the queue between tracking calls and the writer
+	// goroutine; events captured while the queue is full are dropped.
+	MaxBufferedEvents int
+	// OnEventsDropped, if set, receives events the client gives up on, such as
+	// a batch the server rejected, so they can be written to disk or a
+	// fallback queue. It runs on a goroutine of its own, one call at a time in
+	// the order of the drops; a panic in it is recovered and logged.
+	OnEventsDropped func(reason DropReason, events []Event)
+	// OnStats, if set, is called with a Stats snapshot after every flush that
+	// had events to send. It runs on the flushing goroutine and should not block.
+	OnStats func(ClientStats)
+	// SampleRate is the fraction of traces recorded, from 0 to 1. The decision
+	// is derived from the trace ID, so every service in a distributed trace
+	// agrees on it. Zero means unset and records every trace.
+	SampleRate float64
+	// Sampler, if set, replaces SampleRate; it is called once per trace with
+	// the trace ID and the request path ("" outside of middleware). Traces
+	// whose upstream already decided, via traceparent flags or B3 headers,
+	// keep that decision.
+	Sampler func(traceID, path string) bool
+	// PropagationFormats lists additional header formats emitted on outbound
+	// requests alongside traceparent and raceway-clock; "b3" adds Zipkin
+	// X-B3-* headers. Incoming B3 headers are always understood.
+	PropagationFormats []string
+	// Sink, if set, receives the events no Route matches in place of the
+	// Raceway server at ServerURL, e.g. a FileSink in CI or NoopSink where
+	// no server is reachable. It is closed by Shutdown if it implements
+	// io.Closer.
+	Sink EventSink
+	// MaxCustomPayloadBytes bounds the serialized payload of TrackCustom
+	// events; entries beyond it are dropped and the event is tagged
+	// truncated=true (default: 16KB)
+	MaxCustomPayloadBytes int
+	// RedactKeys lists argument, variable, header, and object keys, such as
+	// "password" or "authorization", whose values are recorded as "[REDACTED]".
+	// Keys match case-insensitively; a variable like "user.password" also
+	// matches on its last segment.
+	RedactKeys []string
+	// Redactor, if set, is consulted for every key RedactKeys does not match,
+	// with the value as decoded JSON. Returning true records the returned
+	// value in its place.
+	Redactor func(key string, value interface{}) (interface{}, bool)
+	// OTelBridge keys new traces by the OpenTelemetry span active in the
+	// request or StartTrace context, when no upstream trace context is found,
+	// so both tracers agree on the trace ID. It requires importing the
+	// racewayotel module, github.com/mode7labs/raceway/sdks/go/contrib/otel.
+	OTelBridge bool
+	// PathPrefixes lists directories stripped from the source files recorded
+	// in event locations, e.g. "/src/app" turns "/src/app/internal/payments/transfer.go"
+	// into "internal/payments/transfer.go". Files under none of them are made
+	// relative to the root of their module, found from its go.mod.
+	PathPrefixes []string
+	// IDFormat selects the form of event IDs: IDFormatUUID4, random UUIDs and
+	// the default, IDFormatUUID7, time-ordered UUIDs, or IDFormatULID, which
+	// also needs CapabilityULIDEventIDs in ForceCapabilities
+	IDFormat string
 }
 
 // DefaultConfig returns a Config with sensible defaults.
@@ -55,20 +306,118 @@ func DefaultConfig() Config {
 		BatchSize:     50,
 		FlushInterval: time.Second,
 		Debug:         false,
+		MaxRetries:    3,
 	}
 }
 
 // Client is the main Raceway SDK client.
 type Client struct {
+	// disabled is set by Config.Disabled or the raceway_disabled build tag
+	disabled    bool
 	config      Config
 	instanceID  string
 	eventBuffer []Event
 	mu          sync.Mutex
 	httpClient  *http.Client
+	// baseURL is the server URL requests are addressed to
+	baseURL     string
 	flushTicker *time.Ticker
 	stopChan    chan struct{}
+	startedAt   time.Time
+
+	variableAliases *aliasTable
+	lockAliases     *aliasTable
+	detector        *antiPatternDetector
+	router          *router
+	capabilities    capabilityState
+	fences          fenceRegistry
+	duplicates      *duplicateTracker
+	held            *heldTraces
+	retained        *retainedTraces
+	redactor        *redactor
+	ignoredPaths    *pathFilter
+	paths           *pathTrimmer
+	logger          Logger
+	region          string
+	// baseTags are the tags every event starts with: the SDK's, Config.Tags,
+	// and region, built once by newClient
+	baseTags map[string]string
+
+	// pipeline carries captured events to the writer goroutine, which alone
+	// appends them to eventBuffer; pipelineDone is closed when it exits
+	pipeline      chan pipelineItem
+	pipelineDone  chan struct{}
+	batchFlushing atomic.Bool
+	// flushing is held by the flush that has taken the buffer until its
+	// delivery is done, so batches are sent one at a time in capture order
+	flushing chan struct{}
+	// requeued holds events from transiently failed flushes, sent ahead of new events
+	requeued []Event
+	// unreportedDrops counts drops not yet reported in a batch envelope
+	unreportedDrops atomic.Uint64
+	// eventSeq numbers events in capture order
+	eventSeq atomic.Uint64
+	// readLocks counts WithRWLockRead calls for Config.LockEventSampling
+	readLocks atomic.Uint64
+	// eventID generates event IDs in Config.IDFormat
+	eventID func() string
+	// rejections are the server rejection reasons already logged
+	rejections rejectionLog
+	// live is the configuration as last changed by UpdateConfig, and debug
+	// its Debug flag; configMu serializes updates, which signal reconfigured
+	live         atomic.Pointer[Config]
+	debug        atomic.Bool
+	configMu     sync.Mutex
+	reconfigured chan struct{}
+	// drops delivers dropped events to Config.OnEventsDropped
+	drops    dropNotifier
+	stats    clientStats
+	stopOnce sync.Once
+	// handshake negotiates capabilities on the first flush in SyncMode
+	handshake sync.Once
+	closeOnce sync.Once
+	closeErr  error
+	// closed is set once Shutdown is called; events tracked afterwards are
+	// dropped, and reported once through lateCapture
+	closed      atomic.Bool
+	lateCapture sync.Once
+	// shutdownMu serializes Shutdown calls; shutdownDone is set once one of
+	// them has closed the sinks
+	shutdownMu   sync.Mutex
+	shutdownDone bool
 }
 
+// SDKVersion is reported in the batch envelope, heartbeats, and the
+// sdk_version tag of every event.
+const SDKVersion = "0.1.0"
+
+const (
+	// DefaultShutdownTimeout is used when Config.ShutdownTimeout is zero.
+	DefaultShutdownTimeout = 10 * time.Second
+	// DefaultMaxBufferedEvents is used when Config.MaxBufferedEvents is zero.
+	DefaultMaxBufferedEvents = 10000
+	// DefaultMaxCustomPayloadBytes is used when Config.MaxCustomPayloadBytes is zero.
+	DefaultMaxCustomPayloadBytes = 16 * 1024
+	// DefaultMaxPayloadBytes is used when Config.MaxPayloadBytes is zero.
+	DefaultMaxPayloadBytes = 1 << 20
+	// DefaultLockContentionThreshold is used when Config.LockContentionThreshold is zero.
+	DefaultLockContentionThreshold = 10 * time.Millisecond
+	// DefaultMaxEventsPerTrace is used when Config.MaxEventsPerTrace is zero.
+	DefaultMaxEventsPerTrace = 10000
+	// DefaultMaxBodyBytes is used when Config.MaxBodyBytes is zero.
+	DefaultMaxBodyBytes = 8 * 1024
+	// DefaultMaxValueBytes is used when Config.MaxValueBytes is zero.
+	DefaultMaxValueBytes = 4 * 1024
+	// DefaultMaxCollectionItems is used when Config.MaxCollectionItems is zero.
+	DefaultMaxCollectionItems = 100
+	// DefaultMaxRetainedTraces is used when Config.MaxRetainedTraces is zero.
+	DefaultMaxRetainedTraces = 100
+)
+
+// traceLimitErrorType is the ErrorType of the Error event recorded in place
+// of the first event beyond Config.MaxEventsPerTrace.
+const traceLimitErrorType = "EventLimitExceeded"
+
 // ServiceName returns the configured service name.
 func (c *Client) ServiceName() string {
 	return c.config.ServiceName
@@ -81,6 +430,45 @@ func (c *Client) InstanceID() string {
 
 // New creates a new Raceway client.
 func New(config Config) *Client {
+	client := newClient(config)
+	if client.disabled {
+		// Nothing is recorded, so nothing runs in the background
+		if client.config.SetAsDefault {
+			SetDefault(client)
+		}
+		client.runWithoutWriter()
+		return client
+	}
+	if !client.config.SyncMode && client.handshakeNeeded() {
+		go client.negotiateCapabilities()
+	}
+	if client.config.HeartbeatInterval > 0 {
+		go client.runHeartbeats()
+	}
+	if client.config.RemoteConfigURL != "" {
+		go client.pollRemoteConfig()
+	}
+	if client.held != nil {
+		go client.reapHeldTraces()
+	}
+	if client.config.SetAsDefault {
+		SetDefault(client)
+	}
+	if client.config.SyncMode {
+		client.runWithoutWriter()
+		return client
+	}
+
+	// Start the writer and auto-flush goroutines
+	go client.runPipeline()
+	go client.autoFlush()
+
+	return client
+}
+
+// newClient creates a client with config's defaults applied and starts none
+// of its goroutines.
+func newClient(config Config) *Client {
 	// Prefer ServerURL over Endpoint for clarity
 	if config.ServerURL != "" {
 		config.Endpoint = config.ServerURL
@@ -88,27 +476,120 @@ func New(config Config) *Client {
 		config.Endpoint = "http://localhost:8080"
 	}
 
-	instanceID := config.InstanceID
-	if instanceID == "" {
-		host, err := os.Hostname()
-		if err != nil || host == "" {
-			host = "instance"
-		}
-		instanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	if config.BatchSize <= 0 {
+		config.BatchSize = 50
 	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+
+	// Resolved once, so every context and event of this client agrees
+	instanceID := resolveInstanceID(config)
+	httpClient, baseURL := newHTTPClient(config.Endpoint, config.UseProxy, 10*time.Second)
 
 	client := &Client{
+		disabled:    BuildDisabled || config.Disabled,
 		config:      config,
 		instanceID:  instanceID,
 		eventBuffer: make([]Event, 0, config.BatchSize),
-		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		httpClient:  httpClient,
+		baseURL:     baseURL,
 		flushTicker: time.NewTicker(config.FlushInterval),
 		stopChan:    make(chan struct{}),
+		startedAt:   time.Now(),
+		region:      config.Region,
+		paths:       newPathTrimmer(config.PathPrefixes),
+		logger:      config.Logger,
+
+		pipeline:     make(chan pipelineItem, queueSize(config)),
+		pipelineDone: make(chan struct{}),
+		flushing:     make(chan struct{}, 1),
+		reconfigured: make(chan struct{}, 1),
+	}
+	client.debug.Store(config.Debug)
+	if client.logger == nil {
+		client.logger = stdoutLogger{debug: &client.debug}
+	}
+	if client.region == "" {
+		client.region = detectRegion()
+	}
+	client.baseTags = map[string]string{"sdk_language": "go", "sdk_version": SDKVersion}
+	for k, v := range config.Tags {
+		client.baseTags[k] = v
+	}
+	if client.region != "" {
+		client.baseTags["region"] = client.region
+	}
+	if config.OTelBridge && otelSpanSource.Load() == nil {
+		client.logger.Warnf("OTelBridge is set but no bridge is registered; import github.com/mode7labs/raceway/sdks/go/contrib/otel")
 	}
 
-	// Start auto-flush goroutine
-	go client.autoFlush()
-
+	var err error
+	if client.variableAliases, err = compileAliases(config.VariableAliases); err != nil {
+		client.logger.Warnf("Ignoring variable aliases: %v", err)
+		client.config.VariableAliases = nil
+	}
+	if client.lockAliases, err = compileAliases(config.LockAliases); err != nil {
+		client.logger.Warnf("Ignoring lock aliases: %v", err)
+		client.config.LockAliases = nil
+	}
+	var sink EventSink = config.Sink
+	if sink == nil {
+		sink = newHTTPSink(client, baseURL+eventsPath(config.EventsPath))
+	}
+	client.router = newRouter(config.Routes, Route{
+		Name:         defaultRouteName,
+		Sink:         sink,
+		MaxRetries:   config.MaxRetries,
+		RetryBackoff: config.InitialBackoff,
+		MaxBackoff:   config.MaxBackoff,
+	}, config.RouteToAllMatches, client.eventsDropped)
+	if config.DetectDuplicateRequests {
+		client.duplicates = newDuplicateTracker(config.DuplicateWindow)
+	}
+	if config.IDFormat == IDFormatULID && !newCapabilitySet(config.ForceCapabilities...).Has(CapabilityULIDEventIDs) {
+		// The Raceway server rejects IDs that are not UUIDs
+		client.logger.Warnf("Using %q event IDs: %q IDs need CapabilityULIDEventIDs in ForceCapabilities", IDFormatUUID7, IDFormatULID)
+		client.config.IDFormat = IDFormatUUID7
+	}
+	client.eventID = eventIDFunc(client.config.IDFormat)
+	if client.eventID == nil {
+		client.logger.Warnf("Ignoring unsupported event ID format %q", config.IDFormat)
+		client.eventID = newEventID
+	}
+	if config.AntiPatternDetection {
+		client.detector = newAntiPatternDetector(client.eventID)
+	}
+	switch config.CaptureMode {
+	case "", CaptureModeAll:
+	case CaptureModeOnError:
+		limit, ttl := config.MaxEventsPerTrace, config.PendingTraceTTL
+		if limit <= 0 {
+			limit = DefaultMaxEventsPerTrace
+		}
+		if ttl <= 0 {
+			ttl = DefaultPendingTraceTTL
+		}
+		client.held = newHeldTraces(limit, ttl)
+	default:
+		client.logger.Warnf("Ignoring unsupported capture mode %q", config.CaptureMode)
+	}
+	if config.RetainTraces {
+		limit := config.MaxRetainedTraces
+		if limit <= 0 {
+			limit = DefaultMaxRetainedTraces
+		}
+		client.retained = newRetainedTraces(limit)
+	}
+	client.redactor = newRedactor(config)
+	client.ignoredPaths = newPathFilter(config.IgnorePaths)
+	client.negotiated(newCapabilitySet())
+	live := client.config
+	client.live.Store(&live)
+	client.emitAliasManifest()
+	if config.SpillPath != "" {
+		client.recoverSpill()
+	}
 	return client
 }
 
@@ -117,6 +598,13 @@ func NewClient(config Config) *Client {
 	return New(config)
 }
 
+// authorize adds the configured API key to a request to the Raceway server.
+func (c *Client) authorize(req *http.Request) {
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	}
+}
+
 // Middleware returns standard HTTP middleware that automatically initializes Raceway context.
 // It follows the standard Go pattern: func(http.Handler) http.Handler
 //
@@ -127,50 +615,110 @@ func NewClient(config Config) *Client {
 //	wrappedHandler := client.Middleware(mux)
 //	http.ListenAndServe(":3000", wrappedHandler)
 //
-// Usage with Gin:
+// For Gin, Chi and Echo, use the middleware in the racewaygin, racewaychi
+// and racewayecho modules under github.com/mode7labs/raceway/sdks/go/contrib,
+// which also record the matched route pattern.
 //
-//	router := gin.Default()
-//	router.Use(client.GinMiddleware())
-//	router.GET("/api/endpoint", handler)
+// Middleware records the handler's response status, body size, and duration
+// as an HTTPResponse event once the handler returns, and the request and
+// response bodies with Config.CaptureRequestBody and CaptureResponseBody.
+// The ResponseWriter passed
+// to the handler implements http.Flusher, http.Hijacker, and io.ReaderFrom
+// when the server's writer does.
+//
+// Requests matched by Config.IgnorePaths, or for which Config.ShouldTrace
+// returns false, are passed to next with no Raceway context: nothing is
+// recorded for them, Track* calls are no-ops, and PropagationHeaders returns
+// an error.
+//
+// Background work that should outlive the request, such as audit logging,
+// should run with raceway.Detach(r.Context()) or, to analyze it as its own
+// trace, raceway.Isolate(r.Context()).
 func (c *Client) Middleware(next http.Handler) http.Handler {
+	if BuildDisabled || c.disabled {
+		return next
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Parse incoming trace headers
-		parsed := ParseIncomingHeaders(r.Header, c.config.ServiceName, c.instanceID)
-
-		// Create Raceway context and attach to request context
-		ctxWith := NewContext(r.Context(), parsed.TraceID, c.config.ServiceName, c.instanceID)
-		if rctx := FromContext(ctxWith); rctx != nil {
-			rctx.SpanID = parsed.SpanID
-			rctx.ParentSpanID = parsed.ParentSpanID
-			rctx.Distributed = parsed.Distributed
-			rctx.ClockVector = parsed.ClockVector
-			rctx.TraceState = parsed.TraceState
+		if !c.shouldTrace(r) {
+			next.ServeHTTP(w, untraced(r))
+			return
 		}
+		start := time.Now()
+
+		// Continue the trace in the incoming headers
+		ctxWith := c.ContextFromRequest(r)
 
 		// Track HTTP request as root event
-		c.TrackHTTPRequest(ctxWith, r.Method, r.URL.Path, nil, nil)
+		check := c.startRequestCheck(r)
+		body, bodyPending := c.captureRequestBody(r)
+		var requestBody interface{}
+		if body != nil && !bodyPending {
+			requestBody = body.value(c.redactor)
+		}
+		rootID := c.trackRootRequest(ctxWith, r, requestBody, c.rootTags(ctxWith, check))
+		if bodyPending {
+			defer func() { c.setRequestBody(FromContext(ctxWith).TraceID, rootID, body.value(c.redactor)) }()
+		}
 
-		// Update request with new context and call next handler
-		next.ServeHTTP(w, r.WithContext(ctxWith))
+		// Update request with new context and call next handler, recording
+		// the response it writes
+		rec := &responseRecorder{ResponseWriter: w, captureBody: c.config.CaptureResponseBody, client: c}
+		defer c.recoverHandlerPanic(ctxWith, func() {
+			rec.WriteHeader(http.StatusInternalServerError)
+			c.trackResponse(ctxWith, rec, start)
+			c.settleTrace(ctxWith, rec.status)
+		})
+		defer c.watchCancellation(ctxWith)()
+		c.runLabeled(ctxWith, func(ctx context.Context) {
+			next.ServeHTTP(rec.wrap(), r.WithContext(ctx))
+		})
+		c.finishRequestCheck(ctxWith, check, rootID)
+		c.trackResponse(ctxWith, rec, start)
+		c.settleTrace(ctxWith, rec.status)
 	})
 }
 
-// GinMiddleware returns Gin-compatible middleware that automatically initializes Raceway context.
-// This is type-safe for Gin and provides the same functionality as Middleware.
+// routeTag records the route pattern that matched a request.
+const routeTag = "http.route"
+
+// SetRoute records pattern, the router's pattern for the request of ctx such
+// as "/accounts/{id}", in the http.route tag, so requests to URLs with IDs
+// aggregate by route on the server. The tag is added to the request's root
+// event while it is still buffered and to every later event of the request,
+// including the HTTPResponse recorded by Middleware. Router integrations such
+// as racewaychi call it; handlers rarely need to.
+func (c *Client) SetRoute(ctx context.Context, pattern string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil || pattern == "" {
+		return
+	}
+	rctx.setTag(routeTag, pattern)
+	if rootID := rctx.RootEventID(); rootID != "" {
+		c.tagBufferedEvent(rctx.TraceID, rootID, map[string]string{routeTag: pattern})
+	}
+}
+
+// GinMiddleware returns middleware for frameworks whose context exposes the
+// request through a Request() method and continues with Next().
 //
-// Usage:
+// Deprecated: *gin.Context exposes Request as a field, so this never matches
+// it. Use racewaygin.Middleware from
+// github.com/mode7labs/raceway/sdks/go/contrib/gin, which also records the
+// response:
 //
 //	router := gin.Default()
-//	router.Use(client.GinMiddleware())
-//	router.GET("/api/transfer", func(c *gin.Context) {
-//	    ctx := c.Request.Context()
-//	    // Use ctx with Raceway tracking methods
-//	})
-//
-// Note: This returns a Gin HandlerFunc directly without importing gin as a dependency.
-// It works with any framework that uses func(*http.Request).
+//	router.Use(racewaygin.Middleware(client))
 func (c *Client) GinMiddleware() func(interface{}) {
 	return func(ginCtx interface{}) {
+		if BuildDisabled || c.disabled {
+			if next, ok := ginCtx.(interface{ Next() }); ok {
+				next.Next()
+			}
+			return
+		}
 		// Use type assertion with minimal interface requirements
 		type contextWithRequest interface {
 			Request() *http.Request
@@ -182,16 +730,7 @@ func (c *Client) GinMiddleware() func(interface{}) {
 			// If type assertion fails, try to extract just the request
 			if reqGetter, ok := ginCtx.(interface{ Request() *http.Request }); ok {
 				req := reqGetter.Request()
-				parsed := ParseIncomingHeaders(req.Header, c.config.ServiceName, c.instanceID)
-
-				ctxWith := NewContext(req.Context(), parsed.TraceID, c.config.ServiceName, c.instanceID)
-				if rctx := FromContext(ctxWith); rctx != nil {
-					rctx.SpanID = parsed.SpanID
-					rctx.ParentSpanID = parsed.ParentSpanID
-					rctx.Distributed = parsed.Distributed
-					rctx.ClockVector = parsed.ClockVector
-					rctx.TraceState = parsed.TraceState
-				}
+				ctxWith := c.ContextFromRequest(req)
 
 				c.TrackHTTPRequest(ctxWith, req.Method, req.URL.Path, nil, nil)
 				*req = *req.WithContext(ctxWith)
@@ -200,31 +739,113 @@ func (c *Client) GinMiddleware() func(interface{}) {
 		}
 
 		req := gc.Request()
-		parsed := ParseIncomingHeaders(req.Header, c.config.ServiceName, c.instanceID)
-
-		// Create Raceway context
-		ctxWith := NewContext(req.Context(), parsed.TraceID, c.config.ServiceName, c.instanceID)
-		if rctx := FromContext(ctxWith); rctx != nil {
-			rctx.SpanID = parsed.SpanID
-			rctx.ParentSpanID = parsed.ParentSpanID
-			rctx.Distributed = parsed.Distributed
-			rctx.ClockVector = parsed.ClockVector
-			rctx.TraceState = parsed.TraceState
-		}
+		ctxWith := c.ContextFromRequest(req)
 
 		// Track HTTP request
-		c.TrackHTTPRequest(ctxWith, req.Method, req.URL.Path, nil, nil)
+		check := c.startRequestCheck(req)
+		rootID := c.trackRootRequest(ctxWith, req, nil, c.rootTags(ctxWith, check))
+
+		// Update request with context and call next handler
+		defer c.recoverHandlerPanic(ctxWith, func() {
+			if aborter, ok := ginCtx.(interface{ AbortWithStatus(int) }); ok {
+				aborter.AbortWithStatus(http.StatusInternalServerError)
+			}
+		})
+		c.runLabeled(ctxWith, func(ctx context.Context) {
+			*req = *req.WithContext(ctx)
+			gc.Next()
+		})
+		c.finishRequestCheck(ctxWith, check, rootID)
+	}
+}
+
+// contextFromParsed creates a RacewayContext populated from parsed incoming headers.
+func (c *Client) contextFromParsed(ctx context.Context, parsed ParsedTraceContext) context.Context {
+	parsed = c.adoptOTelSpan(ctx, parsed)
+	ctxWith := c.newContext(ctx, parsed.TraceID)
+	FromContext(ctxWith).applyParsed(parsed, FromContext(ctx), func() bool { return c.sampleTrace(parsed.TraceID, parsed.path) })
+	return ctxWith
+}
 
-		// Update request with context
-		*req = *req.WithContext(ctxWith)
+// ContextFromRequest returns a copy of r's context carrying a Raceway context
+// that continues the trace in r's headers, as Middleware does for each
+// request, for handlers and frameworks that record the request themselves.
+//
+// Example:
+//
+//	ctx := client.ContextFromRequest(r)
+//	client.TrackHTTPRequest(ctx, r.Method, r.URL.Path, nil, nil)
+func (c *Client) ContextFromRequest(r *http.Request) context.Context {
+	if BuildDisabled || c.disabled {
+		return r.Context()
+	}
+	return c.contextFromParsed(r.Context(), c.parseRequest(r))
+}
 
-		// Call next handler
-		gc.Next()
+// StartTrace starts a new trace for work that does not begin with an
+// incoming request, such as a cron task or a queue consumer, and returns a
+// copy of ctx carrying its root context. The root event is a FunctionCall
+// named name; name is also offered to Config.Sampler as the path. Events and
+// PropagationHeaders called with the returned context belong to the new
+// trace, even if ctx already carried one.
+//
+// Example:
+//
+//	ctx := client.StartTrace(context.Background(), "nightly_report")
+//	client.TrackStateChange(ctx, "reports.last_run", nil, now, "cron.go:42", "Write")
+func (c *Client) StartTrace(ctx context.Context, name string) context.Context {
+	if BuildDisabled || c.disabled {
+		return ctx
 	}
+	file, line := c.captureFileLine(2)
+	return c.startTrace(ctx, name, file, line)
+}
+
+// EnsureContext returns ctx if it carries a Raceway context and otherwise
+// starts a trace with a root event named "background", as StartTrace does.
+func (c *Client) EnsureContext(ctx context.Context) context.Context {
+	if BuildDisabled || c.disabled {
+		return ctx
+	}
+	if FromContext(ctx) != nil {
+		return ctx
+	}
+	file, line := c.captureFileLine(2)
+	return c.startTrace(ctx, "background", file, line)
+}
+
+func (c *Client) startTrace(ctx context.Context, name, file string, line int) context.Context {
+	ctxWith := c.newContext(ctx, "")
+	rctx := FromContext(ctxWith)
+	rctx.decideSampling(nil, func() bool { return c.sampleTrace(rctx.TraceID, name) })
+	c.captureEvent(ctxWith, EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: name,
+			Module:       "app",
+			Args:         nil,
+			File:         file,
+			Line:         line,
+		},
+	})
+	return ctxWith
 }
 
 // TrackStateChange tracks a read or write to a variable.
+// oldValue and newValue are serialized before TrackStateChange returns, so later
+// mutations are not recorded and the SDK keeps no reference to them. The same
+// holds for every value passed to a Track* method.
+//
+// An empty location records the caller's; an absolute one is made relative
+// like captured locations, see Config.PathPrefixes.
 func (c *Client) TrackStateChange(ctx context.Context, variable string, oldValue, newValue interface{}, location, accessType string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	if location == "" {
+		location = c.captureLocation(2)
+	} else {
+		location = c.paths.trimLocation(location)
+	}
 	c.captureEvent(ctx, EventKind{
 		StateChange: &StateChangeData{
 			Variable:   variable,
@@ -236,8 +857,57 @@ func (c *Client) TrackStateChange(ctx context.Context, variable string, oldValue
 	})
 }
 
-// TrackFunctionCall tracks a function entry.
+// TrackedWrite calls fn, which performs a write to variable and returns the
+// values before and after it, and records the write at the call site. Reading
+// and mutating inside fn, under the lock that guards variable, keeps the
+// recorded values consistent with the write; the event is timestamped when fn
+// returns.
+func (c *Client) TrackedWrite(ctx context.Context, variable string, fn func() (oldValue, newValue interface{})) {
+	if BuildDisabled || c.disabled {
+		fn()
+		return
+	}
+	location := c.captureLocation(2)
+	oldValue, newValue := fn()
+	at := time.Now()
+	c.captureEventWith(ctx, EventKind{
+		StateChange: &StateChangeData{
+			Variable:   variable,
+			OldValue:   oldValue,
+			NewValue:   newValue,
+			Location:   location,
+			AccessType: "Write",
+		},
+	}, captureOptions{timestamp: &at})
+}
+
+// TrackedRead calls fn, which reads variable, records the read at the call
+// site, and returns the value fn read.
+func (c *Client) TrackedRead(ctx context.Context, variable string, fn func() interface{}) interface{} {
+	if BuildDisabled || c.disabled {
+		return fn()
+	}
+	location := c.captureLocation(2)
+	value := fn()
+	at := time.Now()
+	c.captureEventWith(ctx, EventKind{
+		StateChange: &StateChangeData{
+			Variable:   variable,
+			NewValue:   value,
+			Location:   location,
+			AccessType: "Read",
+		},
+	}, captureOptions{timestamp: &at})
+	return value
+}
+
+// TrackFunctionCall tracks a function entry. An empty file records the
+// caller's file and line.
 func (c *Client) TrackFunctionCall(ctx context.Context, functionName, module string, args interface{}, file string, line int) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	file, line = c.callerFileLine(file, line)
 	c.captureEvent(ctx, EventKind{
 		FunctionCall: &FunctionCallData{
 			FunctionName: functionName,
@@ -249,8 +919,13 @@ func (c *Client) TrackFunctionCall(ctx context.Context, functionName, module str
 	})
 }
 
-// TrackFunctionReturn tracks a function return.
+// TrackFunctionReturn tracks a function return. An empty file records the
+// caller's file and line.
 func (c *Client) TrackFunctionReturn(ctx context.Context, functionName string, returnValue interface{}, file string, line int) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	file, line = c.callerFileLine(file, line)
 	c.captureEvent(ctx, EventKind{
 		FunctionReturn: &FunctionReturnData{
 			FunctionName: functionName,
@@ -261,8 +936,117 @@ func (c *Client) TrackFunctionReturn(ctx context.Context, functionName string, r
 	})
 }
 
+// StartFunction tracks a call to functionName immediately and returns a
+// function that tracks its return with the elapsed duration. Events captured
+// with ctx in between, and the return, are children of the call event. It is
+// meant to be deferred:
+//
+//	defer client.StartFunction(ctx, "transfer", map[string]interface{}{"amount": 100})()
+func (c *Client) StartFunction(ctx context.Context, functionName string, args interface{}) func() {
+	if BuildDisabled || c.disabled {
+		return func() {}
+	}
+	file, line := c.captureFileLine(2)
+	return c.startFunction(ctx, functionName, args, file, line)
+}
+
+func (c *Client) startFunction(ctx context.Context, functionName string, args interface{}, file string, line int) func() {
+	restore := c.trackFunctionCall(ctx, functionName, args, file, line)
+
+	start := time.Now()
+	return func() {
+		c.trackFunctionReturn(ctx, functionName, nil, file, line, time.Since(start))
+		restore()
+	}
+}
+
+// TrackFunction tracks a call to fn as functionName, including its return
+// value and duration, and returns fn's result.
+func (c *Client) TrackFunction(ctx context.Context, functionName string, args interface{}, fn func() interface{}) interface{} {
+	if BuildDisabled || c.disabled {
+		return fn()
+	}
+	file, line := c.captureFileLine(2)
+	return c.trackFunction(ctx, functionName, args, fn, file, line)
+}
+
+func (c *Client) trackFunction(ctx context.Context, functionName string, args interface{}, fn func() interface{}, file string, line int) interface{} {
+	restore := c.trackFunctionCall(ctx, functionName, args, file, line)
+	defer restore()
+
+	start := time.Now()
+	result := fn()
+	c.trackFunctionReturn(ctx, functionName, result, file, line, time.Since(start))
+	return result
+}
+
+// Trace tracks a call to fn as name, like TrackFunction, for functions that
+// can fail. Events fn captures with the ctx it is given are children of the
+// call event. If fn returns an error, an Error event with the elapsed
+// duration is recorded in place of the return; otherwise the return value is
+// recorded. fn's results are returned unchanged.
+func (c *Client) Trace(ctx context.Context, name string, args map[string]interface{}, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if BuildDisabled || c.disabled {
+		return fn(ctx)
+	}
+	file, line := c.captureFileLine(2)
+	restore := c.trackFunctionCall(ctx, name, args, file, line)
+	defer restore()
+
+	start := time.Now()
+	result, err := fn(ctx)
+	if err != nil {
+		durationNs := time.Since(start).Nanoseconds()
+		c.captureEventWith(ctx, EventKind{
+			Error: &ErrorData{
+				ErrorType:  fmt.Sprintf("%T", err),
+				Message:    err.Error(),
+				StackTrace: []string{},
+			},
+		}, captureOptions{durationNs: &durationNs})
+		return result, err
+	}
+	c.trackFunctionReturn(ctx, name, result, file, line, time.Since(start))
+	return result, nil
+}
+
+// trackFunctionCall records the call event for StartFunction and
+// TrackFunction and pins it as the parent of the events that follow until
+// restore is called.
+func (c *Client) trackFunctionCall(ctx context.Context, functionName string, args interface{}, file string, line int) (restore func()) {
+	callID := c.captureEventWith(ctx, EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: functionName,
+			Module:       "app",
+			Args:         args,
+			File:         file,
+			Line:         line,
+		},
+	}, captureOptions{})
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return func() {}
+	}
+	return rctx.pinParent(callID)
+}
+
+func (c *Client) trackFunctionReturn(ctx context.Context, functionName string, returnValue interface{}, file string, line int, elapsed time.Duration) {
+	durationNs := elapsed.Nanoseconds()
+	c.captureEventWith(ctx, EventKind{
+		FunctionReturn: &FunctionReturnData{
+			FunctionName: functionName,
+			ReturnValue:  returnValue,
+			File:         file,
+			Line:         line,
+		},
+	}, captureOptions{durationNs: &durationNs})
+}
+
 // TrackHTTPRequest tracks an HTTP request.
 func (c *Client) TrackHTTPRequest(ctx context.Context, method, url string, headers map[string]string, body interface{}) {
+	if BuildDisabled || c.disabled {
+		return
+	}
 	if headers == nil {
 		headers = make(map[string]string)
 	}
@@ -276,23 +1060,108 @@ func (c *Client) TrackHTTPRequest(ctx context.Context, method, url string, heade
 	})
 }
 
-// TrackHTTPResponse tracks an HTTP response.
+// trackRootRequest records the HttpRequest root event for an incoming request and returns its ID.
+func (c *Client) trackRootRequest(ctx context.Context, r *http.Request, body interface{}, tags map[string]string) string {
+	return c.captureEventWith(ctx, EventKind{
+		HTTPRequest: &HTTPRequestData{
+			Method:  r.Method,
+			URL:     r.URL.Path,
+			Headers: make(map[string]string),
+			Body:    body,
+		},
+	}, captureOptions{tags: tags})
+}
+
+// TrackHTTPResponse tracks an HTTP response that took durationMs
+// milliseconds. TrackHTTPResponseDuration keeps sub-millisecond precision.
 func (c *Client) TrackHTTPResponse(ctx context.Context, status int, headers map[string]string, body interface{}, durationMs int64) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.TrackHTTPResponseDuration(ctx, status, headers, body, time.Duration(durationMs)*time.Millisecond)
+}
+
+// TrackHTTPResponseDuration tracks an HTTP response that took duration,
+// recorded as the event's metadata duration_ns as well as its duration_ms.
+//
+// Example:
+//
+//	start := time.Now()
+//	// ... handle the request ...
+//	client.TrackHTTPResponseDuration(ctx, 200, nil, nil, time.Since(start))
+func (c *Client) TrackHTTPResponseDuration(ctx context.Context, status int, headers map[string]string, body interface{}, duration time.Duration) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.trackHTTPResponse(ctx, status, headers, body, duration, nil)
+}
+
+func (c *Client) trackHTTPResponse(ctx context.Context, status int, headers map[string]string, body interface{}, duration time.Duration, tags map[string]string) {
 	if headers == nil {
 		headers = make(map[string]string)
 	}
-	c.captureEvent(ctx, EventKind{
+	durationNs := duration.Nanoseconds()
+	c.captureEventWith(ctx, EventKind{
 		HTTPResponse: &HTTPResponseData{
 			Status:     status,
 			Headers:    headers,
 			Body:       body,
-			DurationMs: durationMs,
+			DurationMs: duration.Milliseconds(),
 		},
-	})
+	}, captureOptions{tags: tags, durationNs: &durationNs})
 }
 
 // TrackAsyncSpawn tracks spawning a goroutine.
 func (c *Client) TrackAsyncSpawn(ctx context.Context, taskID, taskName, location string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.captureEvent(ctx, EventKind{
+		AsyncSpawn: &AsyncSpawnData{
+			TaskID:    taskID,
+			TaskName:  taskName,
+			SpawnedAt: location,
+		},
+	})
+}
+
+// Go runs fn in a new goroutine on its own virtual thread within the same trace.
+//
+// It records an AsyncSpawn event with a generated task ID, then derives a child
+// context with a new ThreadID and SpanID and a copy of the parent's clock vector,
+// so events inside fn are causally ordered after the spawn. The returned task ID
+// can be passed to TrackAsyncJoin when the parent waits for the goroutine.
+//
+// Example:
+//
+//	client.Go(ctx, "send_receipt", func(ctx context.Context) {
+//	    client.TrackStateChange(ctx, "receipts_sent", n, n+1, "receipts.go:42", "Write")
+//	})
+func (c *Client) Go(ctx context.Context, taskName string, fn func(context.Context)) string {
+	if BuildDisabled || c.disabled {
+		go fn(ctx)
+		return ""
+	}
+	return c.spawn(ctx, taskName, InheritShared, fn, c.captureLocation(2))
+}
+
+// GoWithPolicy is Go with an explicit InheritancePolicy. Use InheritDetached for
+// work that outlives the request and InheritIsolated to record it as its own trace.
+func (c *Client) GoWithPolicy(ctx context.Context, taskName string, policy InheritancePolicy, fn func(context.Context)) string {
+	if BuildDisabled || c.disabled {
+		go fn(ctx)
+		return ""
+	}
+	return c.spawn(ctx, taskName, policy, fn, c.captureLocation(2))
+}
+
+func (c *Client) spawn(ctx context.Context, taskName string, policy InheritancePolicy, fn func(context.Context), location string) string {
+	taskID := uuid.New().String()
+	if FromContext(ctx) == nil {
+		go fn(ctx)
+		return taskID
+	}
+
 	c.captureEvent(ctx, EventKind{
 		AsyncSpawn: &AsyncSpawnData{
 			TaskID:    taskID,
@@ -300,10 +1169,89 @@ func (c *Client) TrackAsyncSpawn(ctx context.Context, taskID, taskName, location
 			SpawnedAt: location,
 		},
 	})
+	child := policy.Apply(ctx)
+
+	go c.runLabeled(child, func(ctx context.Context) {
+		// The panic will crash the process, so deliver its Error event first
+		defer c.rethrowPanicAndFlush(ctx)
+		fn(ctx)
+	})
+	return taskID
+}
+
+// Join folds the clock of childCtx, typically the context of a goroutine
+// started with Go, into ctx once the goroutine has finished, so events
+// captured with ctx afterwards are ordered after everything the goroutine
+// recorded. Call it only after waiting for the goroutine, e.g. after
+// wg.Wait(). Join records no event of its own.
+func (c *Client) Join(ctx, childCtx context.Context) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	rctx, child := FromContext(ctx), FromContext(childCtx)
+	if rctx == nil || child == nil || rctx == child {
+		return
+	}
+	rctx.joinClock(child.clock())
+}
+
+// TrackAsyncJoin records that ctx observed the completion of the goroutine
+// taskID, whose context is childCtx, e.g. after wg.Wait() or on receiving its
+// result. Like Join it first merges the goroutine's clock into ctx, so the
+// AsyncJoin event and everything after it are ordered after the goroutine's
+// events; without it a later access by the parent looks concurrent with them.
+//
+// Example:
+//
+//	var childCtx context.Context
+//	taskID := client.Go(ctx, "load_prices", func(ctx context.Context) {
+//	    defer wg.Done()
+//	    childCtx = ctx
+//	    prices = loadPrices(ctx)
+//	})
+//	wg.Wait()
+//	client.TrackAsyncJoin(ctx, taskID, childCtx)
+func (c *Client) TrackAsyncJoin(ctx context.Context, taskID string, childCtx context.Context) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.TrackAsyncJoinAt(ctx, taskID, childCtx, c.captureLocation(2))
+}
+
+// TrackAsyncJoinAt is TrackAsyncJoin with the location of the join given,
+// like TrackAsyncSpawn's, for helpers that wait on their callers' behalf.
+func (c *Client) TrackAsyncJoinAt(ctx context.Context, taskID string, childCtx context.Context, location string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	var childClock []CausalityEntry
+	clock := 0
+	if child := FromContext(childCtx); child != nil && child != FromContext(ctx) {
+		childClock, clock = child.clock()
+	}
+	c.trackAsyncJoin(ctx, taskID, location, childClock, clock)
+}
+
+// trackAsyncJoin merges childClock and the child's Lamport clock into ctx and
+// records the AsyncJoin event.
+func (c *Client) trackAsyncJoin(ctx context.Context, taskID, location string, childClock []CausalityEntry, clock int) {
+	if rctx := FromContext(ctx); rctx != nil {
+		rctx.joinClock(childClock, clock)
+	}
+	c.captureEvent(ctx, EventKind{
+		AsyncJoin: &AsyncJoinData{
+			TaskID:     taskID,
+			JoinedAt:   location,
+			ChildClock: childClock,
+		},
+	})
 }
 
 // TrackAsyncAwait tracks waiting for an async operation.
 func (c *Client) TrackAsyncAwait(ctx context.Context, futureID, location string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
 	c.captureEvent(ctx, EventKind{
 		AsyncAwait: &AsyncAwaitData{
 			FutureID:  futureID,
@@ -312,20 +1260,16 @@ func (c *Client) TrackAsyncAwait(ctx context.Context, futureID, location string)
 	})
 }
 
-// captureLocation captures the file:line of the caller.
-// skip parameter controls how many stack frames to skip (2 = caller's caller).
-func captureLocation(skip int) string {
-	_, file, line, ok := runtime.Caller(skip)
-	if !ok {
-		return "unknown:0"
-	}
-	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
-}
-
 // TrackLockAcquire tracks acquiring a lock.
 // Location is automatically captured from the call site.
 func (c *Client) TrackLockAcquire(ctx context.Context, lockID, lockType string) {
-	location := captureLocation(2)
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.trackLockAcquire(ctx, lockID, lockType, c.captureLocation(2))
+}
+
+func (c *Client) trackLockAcquire(ctx context.Context, lockID, lockType, location string) {
 	c.captureEvent(ctx, EventKind{
 		LockAcquire: &LockAcquireData{
 			LockID:   lockID,
@@ -338,7 +1282,13 @@ func (c *Client) TrackLockAcquire(ctx context.Context, lockID, lockType string)
 // TrackLockRelease tracks releasing a lock.
 // Location is automatically captured from the call site.
 func (c *Client) TrackLockRelease(ctx context.Context, lockID, lockType string) {
-	location := captureLocation(2)
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.trackLockRelease(ctx, lockID, lockType, c.captureLocation(2))
+}
+
+func (c *Client) trackLockRelease(ctx context.Context, lockID, lockType, location string) {
 	c.captureEvent(ctx, EventKind{
 		LockRelease: &LockReleaseData{
 			LockID:   lockID,
@@ -350,6 +1300,10 @@ func (c *Client) TrackLockRelease(ctx context.Context, lockID, lockType string)
 
 // WithLock executes fn while holding the lock, automatically tracking acquire/release.
 // This is the recommended way to track locks as it ensures release is always tracked.
+// The LockAcquire event records how long the lock was waited for, and a wait
+// of Config.LockContentionThreshold or more is also recorded as a
+// LockContention event. WithLock waits indefinitely; use WithLockTimeout where
+// the lock may never be released.
 //
 // Example:
 //
@@ -357,16 +1311,26 @@ func (c *Client) TrackLockRelease(ctx context.Context, lockID, lockType string)
 //	    accounts["alice"].Balance -= 100
 //	})
 func (c *Client) WithLock(ctx context.Context, lock sync.Locker, lockID, lockType string, fn func()) {
-	c.TrackLockAcquire(ctx, lockID, lockType)
+	if BuildDisabled || c.disabled {
+		lock.Lock()
+		defer lock.Unlock()
+		fn()
+		return
+	}
+	c.withLock(ctx, lock, lockID, lockType, fn, c.captureLocation(2))
+}
+
+func (c *Client) withLock(ctx context.Context, lock sync.Locker, lockID, lockType string, fn func(), location string) {
+	start := time.Now()
 	lock.Lock()
-	defer func() {
-		c.TrackLockRelease(ctx, lockID, lockType)
-		lock.Unlock()
-	}()
-	fn()
+	c.trackLockAcquired(ctx, lockID, lockType, location, time.Since(start))
+	c.runLocked(ctx, lock, lockID, lockType, location, fn)
 }
 
 // WithRWLockRead executes fn while holding a read lock, automatically tracking acquire/release.
+// The lock is released before the release event is recorded, so tracking does
+// not hold up writers waiting for it. Config.LockEventSampling thins the
+// acquire and release events of busy read paths.
 //
 // Example:
 //
@@ -375,16 +1339,18 @@ func (c *Client) WithLock(ctx context.Context, lock sync.Locker, lockID, lockTyp
 //	    fmt.Println(balance)
 //	})
 func (c *Client) WithRWLockRead(ctx context.Context, lock *sync.RWMutex, lockID string, fn func()) {
-	c.TrackLockAcquire(ctx, lockID, "RWLock-Read")
-	lock.RLock()
-	defer func() {
-		c.TrackLockRelease(ctx, lockID, "RWLock-Read")
-		lock.RUnlock()
-	}()
-	fn()
+	if BuildDisabled || c.disabled {
+		lock.RLock()
+		defer lock.RUnlock()
+		fn()
+		return
+	}
+	c.withRWLockRead(ctx, lock, lockID, fn, c.captureLocation(2))
 }
 
 // WithRWLockWrite executes fn while holding a write lock, automatically tracking acquire/release.
+// Like WithLock, it records how long the lock was waited for and any
+// contention.
 //
 // Example:
 //
@@ -392,168 +1358,446 @@ func (c *Client) WithRWLockRead(ctx context.Context, lock *sync.RWMutex, lockID
 //	    accounts["alice"].Balance -= 100
 //	})
 func (c *Client) WithRWLockWrite(ctx context.Context, lock *sync.RWMutex, lockID string, fn func()) {
-	c.TrackLockAcquire(ctx, lockID, "RWLock-Write")
-	lock.Lock()
-	defer func() {
-		c.TrackLockRelease(ctx, lockID, "RWLock-Write")
-		lock.Unlock()
-	}()
-	fn()
+	if BuildDisabled || c.disabled {
+		lock.Lock()
+		defer lock.Unlock()
+		fn()
+		return
+	}
+	c.withLock(ctx, lock, lockID, "RWLock-Write", fn, c.captureLocation(2))
 }
 
-// TrackError tracks an error.
+// TrackError tracks an error. Absolute source paths in stackTrace are made
+// relative like captured locations.
 func (c *Client) TrackError(ctx context.Context, errorType, message string, stackTrace []string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
 	c.captureEvent(ctx, EventKind{
 		Error: &ErrorData{
 			ErrorType:  errorType,
 			Message:    message,
-			StackTrace: stackTrace,
+			StackTrace: c.paths.trimStack(stackTrace),
 		},
 	})
 }
 
 // PropagationHeaders builds outbound headers for distributed tracing.
 func (c *Client) PropagationHeaders(ctx context.Context, extra map[string]string) (map[string]string, error) {
+	if BuildDisabled || c.disabled {
+		headers := make(map[string]string, len(extra))
+		for k, v := range extra {
+			headers[k] = v
+		}
+		return headers, nil
+	}
 	rctx := FromContext(ctx)
 	if rctx == nil {
+		if c.config.Strict && !isUntraced(ctx) {
+			c.strictViolation(StrictMissingContext, "propagation headers requested outside of Raceway context")
+		}
 		return nil, fmt.Errorf("raceway: propagation headers requested outside of active context")
 	}
 
-	result := BuildPropagationHeaders(rctx.TraceID, rctx.SpanID, rctx.TraceState, rctx.ClockVector, rctx.ServiceName, rctx.InstanceID)
+	headers := MapCarrier{}
+	c.injectContext(headers, rctx)
+	for k, v := range extra {
+		headers[k] = v
+	}
+
+	return headers, nil
+}
 
-	rctx.ClockVector = result.ClockVector
-	rctx.Distributed = true
+// injectContext writes the outbound headers for a new hop from rctx to
+// headers. The hop carries the vector of rctx's latest event and does not
+// advance its clock; only recorded events do.
+func (c *Client) injectContext(headers Carrier, rctx *RacewayContext) {
+	c.contextHeaders(headers, rctx, nil)
+	rctx.markDistributed()
 	// Do NOT modify rctx.SpanID - this context should keep using its own span ID
 	// The child span ID is only for the downstream service in the headers
+}
 
-	headers := make(map[string]string, len(result.Headers))
-	for k, v := range result.Headers {
-		headers[k] = v
-	}
-	for k, v := range extra {
-		headers[k] = v
+// contextHeaders writes the outbound headers for rctx in every configured
+// propagation format to headers. extra holds additive raceway-clock payload
+// fields.
+func (c *Client) contextHeaders(headers Carrier, rctx *RacewayContext, extra map[string]interface{}) PropagationResult {
+	sampled := c.sampled(rctx)
+	rctx.mu.Lock()
+	vector, serviceName, instanceID, region := rctx.ClockVector, rctx.ServiceName, rctx.InstanceID, rctx.Region
+	rctx.mu.Unlock()
+	result := buildPropagationHeaders(headers, rctx.TraceID, rctx.SpanID, rctx.TraceState, rctx.Baggage, vector,
+		serviceName, instanceID, region, sampled, c.propagationExtra(rctx, extra))
+	for _, format := range c.config.PropagationFormats {
+		if format == PropagationFormatB3 {
+			addB3Headers(headers, rctx.TraceID, result.ChildSpanID, rctx.SpanID, sampled)
+		}
 	}
+	return result
+}
 
-	return headers, nil
+// captureOptions carries per-event adjustments that helpers layer on top of
+// the defaults derived from the RacewayContext.
+type captureOptions struct {
+	// tags are merged into the event metadata tags
+	tags map[string]string
+	// tagKey, if set, is one more tag with tagValue, for helpers that tag
+	// most events once and would otherwise build a map for each
+	tagKey, tagValue string
+	// parentID overrides the context's current parent event
+	parentID *string
+	// durationNs is recorded as the event's metadata duration
+	durationNs *int64
+	// timestamp overrides the capture time, for helpers that observe the
+	// tracked operation before recording it
+	timestamp *time.Time
 }
 
 func (c *Client) captureEvent(ctx context.Context, kind EventKind) {
+	c.captureEventWith(ctx, kind, captureOptions{})
+}
+
+// captureEventWith records an event and returns its ID, or "" if nothing was captured.
+func (c *Client) captureEventWith(ctx context.Context, kind EventKind, opts captureOptions) string {
+	if BuildDisabled || c.disabled {
+		return ""
+	}
 	rctx := FromContext(ctx)
 	if rctx == nil {
-		if c.config.Debug {
-			fmt.Printf("[Raceway] captureEvent called outside of Raceway context\n")
+		if c.config.Strict && !isUntraced(ctx) {
+			c.strictViolation(StrictMissingContext, "%s event tracked outside of Raceway context", kind.Name())
 		}
-		return
+		c.logger.Debugf("captureEvent called outside of Raceway context")
+		return ""
+	}
+	if c.closed.Load() {
+		c.recordDropped(1)
+		c.lateCapture.Do(func() {
+			c.logger.Debugf("Dropping %s event tracked after Shutdown; later ones are dropped silently", kind.Name())
+		})
+		return ""
+	}
+	rctx.bind(c)
+	if rctx.lifetime.expired() {
+		c.logger.Debugf("Dropping event from finalized detached context")
+		return ""
+	}
+	var eventID string
+	suspect := false
+	if c.config.PreDetect && kind.StateChange != nil {
+		eventID = c.eventID()
+		suspect = c.preDetect(ctx, rctx, kind.StateChange, eventID)
+	}
+	if !c.sampled(rctx) {
+		return ""
+	}
+	if eventID == "" {
+		eventID = c.eventID()
 	}
 
-	// Increment local clock component and clone vector for event payload
-	rctx.ClockVector = incrementClockVector(rctx.ClockVector, rctx.ServiceName, rctx.InstanceID)
-	causalityVector := make([]CausalityEntry, len(rctx.ClockVector))
-	copy(causalityVector, rctx.ClockVector)
+	live := c.snapshotKind(kind)
+	c.redactKind(kind)
+	if c.config.Strict {
+		c.strictCheckEvent(kind)
+	}
+
+	aliasTags := c.applyAliases(kind)
+	lockSet := c.trackLocks(rctx, kind)
+	txID := rctx.trackTransaction(kind)
+	if rctx.shared != nil {
+		limit := c.config.MaxEventsPerTrace
+		if limit <= 0 {
+			limit = DefaultMaxEventsPerTrace
+		}
+		within, first := rctx.shared.countEvent(limit)
+		if !within {
+			// A runaway loop in one trace must not exhaust the buffer
+			c.recordDropped(1)
+			if !first {
+				return ""
+			}
+			kind = EventKind{Error: &ErrorData{
+				ErrorType:  traceLimitErrorType,
+				Message:    fmt.Sprintf("event limit exceeded: trace captured more than %d events; later events are dropped", limit),
+				StackTrace: []string{},
+			}}
+			live, aliasTags, txID = nil, nil, ""
+		}
+	}
+
+	// Increment the local clock component and claim the event's place in the
+	// context in one step, so events captured concurrently with the same
+	// context get distinct clocks. Contexts never modify a vector in place,
+	// so the event shares it.
+	causalityVector, parentID := rctx.advance(eventID, opts.parentID)
+
+	at := time.Now()
+	if opts.timestamp != nil {
+		at = *opts.timestamp
+	}
 
 	event := Event{
-		ID:              uuid.New().String(),
+		ID:              eventID,
 		TraceID:         rctx.TraceID,
-		ParentID:        rctx.ParentID,
-		Timestamp:       time.Now().UTC().Format(time.RFC3339Nano),
+		ParentID:        parentID,
+		Timestamp:       at.UTC().Format(time.RFC3339Nano),
 		Kind:            kind,
-		Metadata:        c.buildMetadata(rctx),
+		Metadata:        c.buildMetadata(ctx, rctx),
 		CausalityVector: causalityVector,
-		LockSet:         []string{},
+		LockSet:         lockSet,
+		Seq:             c.eventSeq.Add(1),
+		live:            live,
 	}
-
-	// Update context: set root ID if first event, update parent, increment clock
-	if rctx.RootID == nil {
-		rctx.RootID = &event.ID
+	for k, v := range aliasTags {
+		event.Metadata.Tags[k] = v
+	}
+	if opts.tagKey != "" {
+		event.Metadata.Tags[opts.tagKey] = opts.tagValue
+	}
+	for k, v := range opts.tags {
+		event.Metadata.Tags[k] = v
+	}
+	if opts.durationNs != nil {
+		event.Metadata.DurationNs = opts.durationNs
+	}
+	if suspect {
+		event.Metadata.Tags[suspectTag] = staleReadSuspect
+	}
+	if txID != "" {
+		event.Metadata.Tags[txIDTag] = txID
 	}
-	rctx.ParentID = &event.ID
-	rctx.Clock++
 
-	// Buffer event for sending
-	c.mu.Lock()
-	c.eventBuffer = append(c.eventBuffer, event)
-	shouldFlush := len(c.eventBuffer) >= c.config.BatchSize
-	c.mu.Unlock()
+	if c.retained != nil {
+		c.retained.add(event)
+	}
 
-	if c.config.Debug {
-		kindName := ""
-		if kind.StateChange != nil {
-			kindName = "StateChange"
-		} else if kind.FunctionCall != nil {
-			kindName = "FunctionCall"
-		} else if kind.HTTPRequest != nil {
-			kindName = "HttpRequest"
-		} else if kind.HTTPResponse != nil {
-			kindName = "HttpResponse"
+	// Hand the event to the writer goroutine for buffering, unless its trace
+	// is held until it is committed
+	if c.held == nil || !c.held.hold(event, time.Now()) {
+		if !c.enqueue(event) {
+			return ""
+		}
+		if c.config.SyncMode {
+			c.flushFullBatch()
 		}
-		fmt.Printf("[Raceway] Captured %s event %s\n", kindName, event.ID[:8])
 	}
 
-	if shouldFlush {
-		go c.Flush()
+	if debugEnabled(c.logger) {
+		c.logger.Debugf("Captured %s event %s", kind.Name(), event.ID[:8])
 	}
+
+	return event.ID
 }
 
-func (c *Client) buildMetadata(rctx *RacewayContext) Metadata {
+func (c *Client) buildMetadata(ctx context.Context, rctx *RacewayContext) Metadata {
 	// Phase 2: Always populate distributed tracing fields when we have a context
 	// This ensures entry-point services also create distributed spans
 	instanceID := &rctx.InstanceID
 	spanID := &rctx.SpanID
 	upstreamSpanID := rctx.ParentSpanID
 
+	// Sized for the usual tags, so adding them does not grow the map
+	rctx.mu.Lock()
+	tags := make(map[string]string, len(c.baseTags)+len(rctx.Baggage)+len(rctx.tags))
+	for k, v := range c.baseTags {
+		tags[k] = v
+	}
+	for k, v := range rctx.Baggage {
+		tags[baggageTagPrefix+k] = v
+	}
+	rctx.mu.Unlock()
+	var region *string
+	if c.region != "" && c.Capabilities().Has(CapabilityRegion) {
+		region = &c.region
+	}
+	// TagProvider is user code and runs without the context locked
+	for k, v := range c.providedTags(ctx) {
+		tags[k] = v
+	}
+	rctx.mu.Lock()
+	for k, v := range rctx.tags {
+		tags[k] = v
+	}
+	links := copyLinks(rctx.links)
+	rctx.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		tags[ctxCanceledTag] = "true"
+		tags[ctxErrTag] = err.Error()
+	}
+
 	return Metadata{
 		ThreadID:    rctx.ThreadID, // Use virtual thread ID from context
 		ProcessID:   os.Getpid(),
 		ServiceName: c.config.ServiceName,
 		Environment: c.config.Environment,
-		Tags:        map[string]string{"sdk_language": "go"},
+		Tags:        tags,
 		DurationNs:  nil,
 		// Phase 2: Distributed tracing fields
 		InstanceID:        instanceID,
 		DistributedSpanID: spanID,
 		UpstreamSpanID:    upstreamSpanID,
+		Region:            region,
+		Links:             links,
 	}
 }
 
-// Flush sends buffered events to the server.
+// Flush sends buffered events to the server, logging any error.
 func (c *Client) Flush() {
+	if err := c.FlushContext(context.Background()); err != nil {
+		c.logger.Errorf("Sending events failed: %v", err)
+	}
+}
+
+// FlushSync is an alias for FlushContext, for short-lived processes that
+// must deliver their events before exiting. Like FlushContext it sends on the
+// calling goroutine and returns once the events are delivered, or with the
+// error once ctx expires.
+func (c *Client) FlushSync(ctx context.Context) error {
+	return c.FlushContext(ctx)
+}
+
+// FlushContext sends buffered events, honoring ctx cancellation and deadline.
+// On failure it returns a *FlushError; events that failed transiently, such as
+// on a network error, a 5xx response, or ctx expiring, are buffered again and
+// sent ahead of new events on the next flush.
+//
+// Flushes are sent one at a time: a flush started while another is delivering
+// waits for it, so the server receives batches in capture order whether they
+// come from Flush, the flush interval, or a full batch.
+func (c *Client) FlushContext(ctx context.Context) error {
+	if BuildDisabled || c.disabled {
+		return nil
+	}
+	if c.config.SyncMode && c.handshakeNeeded() {
+		c.handshake.Do(func() {
+			if err := c.Ping(ctx); err != nil {
+				c.logger.Debugf("Capability negotiation failed: %v", err)
+			}
+		})
+	}
+	c.syncPipeline(ctx)
+	select {
+	case c.flushing <- struct{}{}:
+	case <-ctx.Done():
+		// The events stay buffered behind those still being delivered
+		c.mu.Lock()
+		pending := len(c.requeued) + len(c.eventBuffer)
+		c.mu.Unlock()
+		return &FlushError{Requeued: pending, Err: ctx.Err()}
+	}
+	defer func() { <-c.flushing }()
+
 	c.mu.Lock()
-	if len(c.eventBuffer) == 0 {
+	if len(c.eventBuffer) == 0 && len(c.requeued) == 0 {
 		c.mu.Unlock()
-		return
+		return nil
 	}
 
 	events := make([]Event, len(c.eventBuffer))
 	copy(events, c.eventBuffer)
 	c.eventBuffer = c.eventBuffer[:0]
+	requeued := c.requeued
+	c.requeued = nil
+	c.stats.buffered.Add(-int64(len(events) + len(requeued)))
+	c.stats.requeued.Store(0)
 	c.mu.Unlock()
+	start := time.Now()
 
-	payload := map[string]interface{}{
-		"events": events,
+	// Requeued events were already inspected and downgraded by an earlier flush
+	if c.debug.Load() {
+		for _, warning := range checkLiveValues(events) {
+			c.logger.Warnf("%s", warning)
+		}
+	}
+	if c.detector != nil {
+		warnings := c.detector.inspect(events)
+		for i := range warnings {
+			warnings[i].Seq = c.eventSeq.Add(1)
+		}
+		events = append(events, warnings...)
+	}
+	c.downgradeEvents(events)
+	events = append(requeued, c.validateEvents(events)...)
+	if len(events) == 0 {
+		return nil
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("[Raceway] Error marshaling events: %v\n", err)
-		return
+	retry, dropped, err := c.router.deliver(ctx, events)
+	if err == nil {
+		c.recordFlush(len(events), time.Since(start), nil)
+		c.logger.Debugf("Sent %d events", len(events))
+		return nil
 	}
 
-	resp, err := c.httpClient.Post(
-		fmt.Sprintf("%s/events", c.config.Endpoint),
-		"application/json",
-		bytes.NewReader(data),
-	)
-	if err != nil {
-		fmt.Printf("[Raceway] Error sending events: %v\n", err)
-		return
+	c.recordDropped(dropped)
+	kept := c.requeue(retry)
+	c.recordFlush(len(events)-len(retry)-dropped, time.Since(start), err)
+	flushErr := &FlushError{
+		Dropped:  dropped + len(retry) - kept,
+		Requeued: kept,
+		Err:      err,
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		flushErr.StatusCode = statusErr.StatusCode
+		flushErr.Body = statusErr.Body
+	}
+	return flushErr
+}
+
+// requeue buffers events for redelivery ahead of newer events and returns how
+// many of them were kept under MaxBufferedEvents.
+func (c *Client) requeue(events []Event) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A concurrent flush may have requeued its own failures in the meantime
+	c.requeued = append(events, c.requeued...)
+	c.stats.buffered.Add(int64(len(events)))
+	kept := len(events) - c.enforceBufferLimitLocked()
+	c.stats.requeued.Store(int64(len(c.requeued)))
+	if kept < 0 {
+		kept = 0
+	}
+	return kept
+}
+
+// enforceBufferLimitLocked drops the oldest events beyond MaxBufferedEvents,
+// requeued events first, and returns how many were dropped. c.mu must be held.
+func (c *Client) enforceBufferLimitLocked() int {
+	limit := queueSize(c.config)
+	over := len(c.requeued) + len(c.eventBuffer) - limit
+	if over <= 0 {
+		return 0
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("[Raceway] Failed to send events: status %d, body: %s\n", resp.StatusCode, string(body))
-	} else if c.config.Debug {
-		fmt.Printf("[Raceway] Sent %d events\n", len(events))
+	n := over
+	if n > len(c.requeued) {
+		n = len(c.requeued)
+	}
+	c.eventsDropped(DropBufferFull, c.requeued[:n])
+	c.requeued = c.requeued[n:]
+	if rest := over - n; rest > 0 {
+		c.eventsDropped(DropBufferFull, c.eventBuffer[:rest])
+		c.eventBuffer = append(c.eventBuffer[:0], c.eventBuffer[rest:]...)
+	}
+	c.stats.buffered.Add(-int64(over))
+	c.stats.requeued.Store(int64(len(c.requeued)))
+	c.recordDropped(over)
+	return over
+}
+
+// queueSize returns the effective MaxBufferedEvents.
+func queueSize(config Config) int {
+	if config.MaxBufferedEvents <= 0 {
+		return DefaultMaxBufferedEvents
+	}
+	return config.MaxBufferedEvents
+}
+
+func (c *Client) recordDropped(n int) {
+	if n > 0 {
+		c.stats.dropped.Add(uint64(n))
+		c.unreportedDrops.Add(uint64(n))
 	}
 }
 
@@ -562,17 +1806,126 @@ func (c *Client) autoFlush() {
 		select {
 		case <-c.flushTicker.C:
 			c.Flush()
+		case <-c.reconfigured:
+			c.flushTicker.Reset(c.live.Load().FlushInterval)
 		case <-c.stopChan:
 			return
 		}
 	}
 }
 
-// Shutdown flushes remaining events and stops the auto-flush goroutine.
+// Shutdown stops the auto-flush goroutine, flushes remaining events within
+// Config.ShutdownTimeout, and closes route sinks and Config.Sink, logging any
+// error. Its caller cannot retry, so events the flush leaves undelivered are
+// spilled to Config.SpillPath or dropped. Use ShutdownContext to handle the
+// error instead.
 func (c *Client) Shutdown() {
-	close(c.stopChan)
-	c.flushTicker.Stop()
-	c.Flush()
+	if err := c.shutdown(context.Background(), false); err != nil {
+		c.logger.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+// ShutdownContext is Shutdown, giving up when ctx is done or
+// Config.ShutdownTimeout expires, whichever is first. It returns the flush
+// error, if any. With Config.SpillPath, events requeued after a failure are
+// written to the spill file. Otherwise sinks are left open and
+// ShutdownContext may be called again to retry delivery; events still
+// undelivered when it gives up are dropped and passed to
+// Config.OnEventsDropped.
+//
+// Shutdown is safe to call more than once and from several goroutines, such
+// as a signal handler and a deferred call: concurrent calls wait for the one
+// in progress, and calls after the sinks are closed return nil at once.
+// Events tracked after Shutdown is first called are dropped.
+func (c *Client) ShutdownContext(ctx context.Context) error {
+	return c.shutdown(ctx, true)
+}
+
+// shutdown implements Shutdown and ShutdownContext. retry reports whether the
+// caller can call again to retry delivery; without it, undelivered events
+// are dropped as if the time had run out.
+func (c *Client) shutdown(ctx context.Context, retry bool) error {
+	c.shutdownMu.Lock()
+	defer c.shutdownMu.Unlock()
+	if c.shutdownDone {
+		return nil
+	}
+	c.closed.Store(true)
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+		c.flushTicker.Stop()
+	})
+
+	timeout := c.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := c.FlushContext(ctx)
+	var flushErr *FlushError
+	if errors.As(err, &flushErr) && flushErr.Requeued > 0 && c.config.SpillPath != "" {
+		spilled, spillErr := c.spill()
+		flushErr.Spilled = spilled
+		flushErr.Requeued = int(c.stats.requeued.Load())
+		if spillErr != nil {
+			err = errors.Join(err, spillErr)
+		}
+	}
+	if errors.As(err, &flushErr) && flushErr.Requeued > 0 {
+		if retry && ctx.Err() == nil {
+			return err
+		}
+		// Out of time or retries: the undelivered events are given up on
+		reason := DropShutdownTimeout
+		if ctx.Err() == nil {
+			reason = DropSendFailed
+		}
+		abandoned := c.takeBuffered()
+		c.recordDropped(len(abandoned))
+		c.eventsDropped(reason, abandoned)
+		flushErr.Dropped += len(abandoned)
+		flushErr.Requeued = 0
+	}
+	c.shutdownDone = true
+	return errors.Join(err, c.closeSinks())
+}
+
+// Closed reports whether Shutdown has been called, including a ShutdownContext
+// that returned with events requeued for a retry. Events tracked once it has
+// are dropped; a retry only delivers the events tracked before.
+func (c *Client) Closed() bool {
+	return c.closed.Load()
+}
+
+// takeBuffered removes and returns the requeued events followed by the
+// buffered ones.
+func (c *Client) takeBuffered() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events := append(c.takeRequeuedLocked(), c.eventBuffer...)
+	c.stats.buffered.Add(-int64(len(c.eventBuffer)))
+	c.eventBuffer = c.eventBuffer[:0]
+	return events
+}
+
+// takeRequeuedLocked removes and returns the requeued events. c.mu must be held.
+func (c *Client) takeRequeuedLocked() []Event {
+	events := c.requeued
+	c.requeued = nil
+	c.stats.buffered.Add(-int64(len(events)))
+	c.stats.requeued.Store(0)
+	return events
+}
+
+func (c *Client) closeSinks() error {
+	c.closeOnce.Do(func() {
+		if err := c.router.close(); err != nil {
+			c.closeErr = fmt.Errorf("raceway: closing sinks: %w", err)
+		}
+	})
+	return c.closeErr
 }
 
 // Stop is an alias for Shutdown() for compatibility with documentation.
@@ -580,6 +1933,12 @@ func (c *Client) Stop() {
 	c.Shutdown()
 }
 
+// Close is ShutdownContext without a deadline beyond Config.ShutdownTimeout,
+// so a Client is an io.Closer.
+func (c *Client) Close() error {
+	return c.ShutdownContext(context.Background())
+}
+
 // getGoroutineID returns the current goroutine ID (for debugging purposes).
 func getGoroutineID() int {
 	var buf [64]byte
@@ -1,7 +1,18 @@
+//go:build !raceway_disabled
+
 package raceway
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -250,3 +261,503 @@ func BenchmarkTrackFunctionCall(b *testing.B) {
 		}, "client_test.go", 223)
 	}
 }
+
+// captureEventAllocBudget is the most allocations captureEvent may make for
+// an event without a payload to snapshot: the event ID and the pointer to it
+// kept as the context's parent, the timestamp, the tags map and its entries,
+// and the causality vector with its incremented value.
+const captureEventAllocBudget = 7
+
+// BenchmarkCaptureEventAllocs measures the cost of capturing an event apart
+// from snapshotting its payload.
+func BenchmarkCaptureEventAllocs(b *testing.B) {
+	c := New(Config{ServiceName: "bench-service", InstanceID: "bench-instance", BatchSize: 1 << 20, FlushInterval: time.Hour,
+		MaxEventsPerTrace: 1 << 30, Tags: map[string]string{"team": "payments"}})
+	defer c.Shutdown()
+	ctx := NewContext(context.Background(), "", "bench-service", "bench-instance")
+	kind := EventKind{AsyncAwait: &AsyncAwaitData{FutureID: "task-1", AwaitedAt: "client_test.go:1"}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// Keep the buffer below its limit, which would drop events
+		if i%4096 == 0 {
+			b.StopTimer()
+			c.syncPipeline(context.Background())
+			c.mu.Lock()
+			c.eventBuffer = c.eventBuffer[:0]
+			c.mu.Unlock()
+			b.StartTimer()
+		}
+		c.captureEvent(ctx, kind)
+	}
+}
+
+func TestCaptureEventAllocBudget(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Tags = map[string]string{"team": "payments"} })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	kind := EventKind{AsyncAwait: &AsyncAwaitData{FutureID: "task-1", AwaitedAt: "client_test.go:1"}}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		c.captureEvent(ctx, kind)
+	})
+	if allocs > captureEventAllocBudget {
+		t.Errorf("expected at most %d allocations per event, got %v", captureEventAllocBudget, allocs)
+	}
+}
+
+// newBufferingClient returns a client that never flushes on its own, so tests
+// can inspect captured events through bufferedEvents.
+func newBufferingClient(t *testing.T, configure func(*Config)) *Client {
+	t.Helper()
+	for _, name := range regionEnvVars {
+		t.Setenv(name, "")
+	}
+	config := DefaultConfig()
+	config.ServiceName = "test-service"
+	config.InstanceID = "test-instance"
+	config.BatchSize = 100000
+	config.FlushInterval = time.Hour
+	// Events reach the sink as captured unless a test negotiates
+	config.ForceCapabilities = AllCapabilities
+	if configure != nil {
+		configure(&config)
+	}
+	client := New(config)
+	t.Cleanup(func() {
+		// Discard what the test left buffered so Stop doesn't send it
+		client.DrainTo(io.Discard)
+		client.Stop()
+	})
+	return client
+}
+
+// bufferedEvents returns a copy of the events currently waiting to be flushed.
+func bufferedEvents(c *Client) []Event {
+	c.syncPipeline(context.Background())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events := make([]Event, len(c.eventBuffer))
+	copy(events, c.eventBuffer)
+	return events
+}
+
+func TestTrackedWriteRecordsValuesFromFn(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var mu sync.Mutex
+	balance := 100
+	var mutatedAt time.Time
+	c.TrackedWrite(ctx, "account.balance", func() (interface{}, interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		old := balance
+		balance -= 30
+		mutatedAt = time.Now()
+		return old, balance
+	})
+	_, _, line, _ := runtime.Caller(0)
+	read := c.TrackedRead(ctx, "account.balance", func() interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return balance
+	})
+	if read != 70 {
+		t.Errorf("TrackedRead returned %v, want 70", read)
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	write := events[0].Kind.StateChange
+	if write.AccessType != "Write" || string(write.OldValue.(json.RawMessage)) != "100" || string(write.NewValue.(json.RawMessage)) != "70" {
+		t.Errorf("unexpected write %+v", write)
+	}
+	if want := fmt.Sprintf("client_test.go:%d", line-8); write.Location != want {
+		t.Errorf("write location = %q, want %q", write.Location, want)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, events[0].Timestamp)
+	if err != nil || ts.Before(mutatedAt) {
+		t.Errorf("write timestamp %s precedes the mutation at %s", events[0].Timestamp, mutatedAt.UTC())
+	}
+	readEvent := events[1].Kind.StateChange
+	if readEvent.AccessType != "Read" || readEvent.OldValue != nil || readEvent.Location != fmt.Sprintf("client_test.go:%d", line+1) {
+		t.Errorf("unexpected read %+v", readEvent)
+	}
+}
+
+func TestStartFunctionParentsEventsToCall(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var line int
+	func() {
+		_, _, line, _ = runtime.Caller(0)
+		defer c.StartFunction(ctx, "transfer", nil)()
+		c.TrackStateChange(ctx, "from.balance", 100, 70, "client_test.go:1", "Write")
+		c.TrackFunction(ctx, "credit", nil, func() interface{} {
+			c.TrackStateChange(ctx, "to.balance", 0, 30, "client_test.go:2", "Write")
+			return nil
+		})
+		c.TrackStateChange(ctx, "audit", nil, "done", "client_test.go:3", "Write")
+	}()
+	c.TrackStateChange(ctx, "after", nil, 1, "client_test.go:4", "Write")
+
+	events := bufferedEvents(c)
+	names := make([]string, len(events))
+	for i, e := range events {
+		names[i] = e.Kind.Name()
+	}
+	want := []string{"FunctionCall", "StateChange", "FunctionCall", "StateChange", "FunctionReturn", "StateChange", "FunctionReturn", "StateChange"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+
+	call := events[0]
+	if call.Kind.FunctionCall.File != "client_test.go" || call.Kind.FunctionCall.Line != line+1 {
+		t.Errorf("expected the call at client_test.go:%d, got %s:%d", line+1, call.Kind.FunctionCall.File, call.Kind.FunctionCall.Line)
+	}
+	parentOf := func(e Event) string {
+		if e.ParentID == nil {
+			return ""
+		}
+		return *e.ParentID
+	}
+	for i, wantParent := range []string{"", call.ID, call.ID, events[2].ID, events[2].ID, call.ID, call.ID, events[6].ID} {
+		if got := parentOf(events[i]); got != wantParent {
+			t.Errorf("event %d (%s): parent %q, want %q", i, names[i], got, wantParent)
+		}
+	}
+}
+
+func TestTracePairsCallAndReturn(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.RedactKeys = []string{"password"} })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	result, err := c.Trace(ctx, "login", map[string]interface{}{"user": "alice"}, func(ctx context.Context) (interface{}, error) {
+		c.TrackStateChange(ctx, "sessions", 0, 1, "client_test.go:1", "Write")
+		return map[string]interface{}{"password": "hunter2", "ok": true}, nil
+	})
+	if err != nil || result.(map[string]interface{})["password"] != "hunter2" {
+		t.Fatalf("expected fn's result returned unchanged, got %v (%v)", result, err)
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 3 || events[0].Kind.FunctionCall == nil || events[2].Kind.FunctionReturn == nil {
+		t.Fatalf("expected call, state change, and return, got %d events", len(events))
+	}
+	call, ret := events[0], events[2]
+	if events[1].ParentID == nil || *events[1].ParentID != call.ID || ret.ParentID == nil || *ret.ParentID != call.ID {
+		t.Errorf("expected the state change and return to be children of the call %s", call.ID)
+	}
+	if ret.Kind.FunctionReturn.FunctionName != "login" || ret.Metadata.DurationNs == nil {
+		t.Errorf("expected a timed return for login, got %+v", ret)
+	}
+	value := decodedValue(t, ret.Kind.FunctionReturn.ReturnValue).(map[string]interface{})
+	if value["password"] != "[REDACTED]" || value["ok"] != true {
+		t.Errorf("expected the return value redacted like args, got %v", value)
+	}
+}
+
+func TestTraceRecordsErrorInPlaceOfReturn(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	failure := errors.New("insufficient funds")
+
+	_, err := c.Trace(ctx, "debit", nil, func(ctx context.Context) (interface{}, error) {
+		return nil, failure
+	})
+	if err != failure {
+		t.Fatalf("expected fn's error returned, got %v", err)
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 2 || events[1].Kind.Error == nil {
+		t.Fatalf("expected a call and an error, got %d events", len(events))
+	}
+	call, failed := events[0], events[1]
+	if failed.ParentID == nil || *failed.ParentID != call.ID {
+		t.Errorf("expected the error to be a child of the call %s", call.ID)
+	}
+	if failed.Kind.Error.Message != "insufficient funds" || failed.Kind.Error.ErrorType != "*errors.errorString" || failed.Metadata.DurationNs == nil {
+		t.Errorf("unexpected error event %+v", failed.Kind.Error)
+	}
+}
+
+func TestStartTraceRootsBackgroundJob(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	ctx := c.StartTrace(context.Background(), "nightly_report")
+	c.TrackStateChange(ctx, "reports.last_run", nil, "2024-01-01", "client_test.go:1", "Write")
+	headers, err := c.PropagationHeaders(ctx, nil)
+	if err != nil {
+		t.Fatalf("expected propagation headers from a started trace, got %v", err)
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected root and write events, got %d", len(events))
+	}
+	root, write := events[0], events[1]
+	call := root.Kind.FunctionCall
+	if call == nil || call.FunctionName != "nightly_report" || call.File != "client_test.go" || root.ParentID != nil {
+		t.Fatalf("expected a FunctionCall root named after the job, got %+v", root.Kind)
+	}
+	rctx := FromContext(ctx)
+	if rctx.RootID == nil || *rctx.RootID != root.ID || !rctx.Sampled {
+		t.Errorf("expected the job's event to be the sampled trace root")
+	}
+	if write.TraceID != root.TraceID || write.ParentID == nil || *write.ParentID != root.ID {
+		t.Errorf("expected the write in the job's trace under its root")
+	}
+	parsed := ParseIncomingHeaders(http.Header{"Raceway-Clock": {headers["raceway-clock"]}}, "downstream", "d1")
+	if parsed.TraceID != root.TraceID {
+		t.Errorf("expected downstream to continue trace %s, got %s", root.TraceID, parsed.TraceID)
+	}
+
+	other := c.StartTrace(ctx, "nightly_report")
+	if FromContext(other).TraceID == root.TraceID {
+		t.Error("expected each StartTrace to begin a new trace")
+	}
+}
+
+func TestEnsureContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	if got := c.EnsureContext(ctx); got != ctx {
+		t.Error("expected a context with a Raceway context to be returned unchanged")
+	}
+	if len(bufferedEvents(c)) != 0 {
+		t.Error("expected no root event for an existing context")
+	}
+
+	started := c.EnsureContext(context.Background())
+	events := bufferedEvents(c)
+	if FromContext(started) == nil || len(events) != 1 || events[0].Kind.FunctionCall.FunctionName != "background" {
+		t.Fatalf("expected a background trace to be started, got %d events", len(events))
+	}
+	if events[0].TraceID != FromContext(started).TraceID {
+		t.Error("expected the root event in the started trace")
+	}
+}
+
+func TestEventSeqIsUniqueAcrossConcurrentCapture(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.MaxBufferedEvents = 20000 })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	const goroutines, perGoroutine = 50, 200
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				c.TrackStateChange(ctx, "counter", i, i+1, "client_test.go:1", "Write")
+			}
+		}(InheritShared.Apply(ctx))
+	}
+	wg.Wait()
+
+	events := bufferedEvents(c)
+	if len(events) != goroutines*perGoroutine {
+		t.Fatalf("expected %d events, got %d", goroutines*perGoroutine, len(events))
+	}
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Event
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[uint64]bool, len(decoded))
+	for _, event := range decoded {
+		if event.Seq < 1 || event.Seq > uint64(len(decoded)) || seen[event.Seq] {
+			t.Fatalf("expected sequence numbers 1..%d without duplicates, got %d", len(decoded), event.Seq)
+		}
+		seen[event.Seq] = true
+	}
+}
+
+func TestMaxEventsPerTraceRecordsOneMarker(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.MaxEventsPerTrace = 5 })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	for i := 0; i < 20; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "client_test.go:1", "Write")
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 6 {
+		t.Fatalf("expected 5 events and the limit marker, got %d", len(events))
+	}
+	marker := events[5].Kind.Error
+	if marker == nil || marker.ErrorType != traceLimitErrorType {
+		t.Fatalf("expected the limit marker last, got %+v", events[5].Kind)
+	}
+	if stats := c.Stats(); stats.EventsDropped != 15 {
+		t.Errorf("expected 15 dropped events, got %d", stats.EventsDropped)
+	}
+	if n := FromContext(ctx).EventCount(); n != 20 {
+		t.Errorf("expected an event count of 20, got %d", n)
+	}
+}
+
+func TestMaxEventsPerTraceSharedAcrossGoroutines(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.MaxEventsPerTrace = 50 })
+	runaway := NewContext(context.Background(), "", "test-service", "test-instance")
+	other := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		c.Go(runaway, "loop", func(ctx context.Context) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.TrackStateChange(ctx, "counter", j, j+1, "client_test.go:1", "Write")
+			}
+		})
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 40; j++ {
+			c.TrackStateChange(other, "orders", j, j+1, "client_test.go:2", "Write")
+		}
+	}()
+	wg.Wait()
+
+	perTrace := map[string]int{}
+	markers := 0
+	for _, event := range bufferedEvents(c) {
+		perTrace[event.TraceID]++
+		if event.Kind.Error != nil && event.Kind.Error.ErrorType == traceLimitErrorType {
+			markers++
+		}
+	}
+	if got := perTrace[FromContext(runaway).TraceID]; got != 51 || markers != 1 {
+		t.Errorf("expected 50 events and one marker in the runaway trace, got %d events and %d markers", got, markers)
+	}
+	if got := perTrace[FromContext(other).TraceID]; got != 40 {
+		t.Errorf("expected the other trace to keep all 40 events, got %d", got)
+	}
+	if n := FromContext(runaway).EventCount(); n != 404 {
+		t.Errorf("expected spawns and loop events counted across goroutines, got %d", n)
+	}
+}
+
+// countingCloseSink counts the events it receives and its Close calls.
+type countingCloseSink struct {
+	events atomic.Int32
+	closes atomic.Int32
+}
+
+func (s *countingCloseSink) Send(ctx context.Context, events []Event) error {
+	s.events.Add(int32(len(events)))
+	return nil
+}
+
+func (s *countingCloseSink) Close() error {
+	s.closes.Add(1)
+	return nil
+}
+
+func TestShutdownTwice(t *testing.T) {
+	sink := &countingCloseSink{}
+	c := New(Config{ServiceName: "test-service", FlushInterval: time.Hour, Sink: sink})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "client_test.go:1", "Write")
+
+	if c.Closed() {
+		t.Error("expected the client open before Shutdown")
+	}
+	if err := c.ShutdownContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ShutdownContext(context.Background()); err != nil {
+		t.Errorf("expected a second Shutdown to return nil, got %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("expected Close after Shutdown to return nil, got %v", err)
+	}
+	if !c.Closed() || sink.events.Load() != 1 || sink.closes.Load() != 1 {
+		t.Errorf("expected one delivered event and one Close, got %d and %d", sink.events.Load(), sink.closes.Load())
+	}
+}
+
+func TestClosedWhileShutdownAwaitsRetry(t *testing.T) {
+	sink := &recordingSink{fail: 1}
+	c := New(Config{ServiceName: "test-service", FlushInterval: time.Hour, ShutdownTimeout: time.Second, Sink: sink})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "client_test.go:1", "Write")
+
+	if err := c.ShutdownContext(context.Background()); err == nil {
+		t.Fatal("expected the failed flush returned for a retry")
+	}
+	if !c.Closed() {
+		t.Error("expected the client closed while its events await a retry")
+	}
+	c.TrackStateChange(ctx, "counter", 1, 2, "client_test.go:2", "Write")
+	if stats := c.Stats(); stats.EventsDropped != 1 || stats.EventsRequeued != 1 {
+		t.Errorf("expected the late event dropped and the first one requeued, got %+v", stats)
+	}
+
+	if err := c.ShutdownContext(context.Background()); err != nil {
+		t.Fatalf("retried Shutdown failed: %v", err)
+	}
+	if events := sink.received(); len(events) != 1 || events[0].Kind.StateChange.Location != "client_test.go:1" {
+		t.Errorf("expected only the event tracked before Shutdown delivered, got %d", len(events))
+	}
+}
+
+func TestConcurrentShutdown(t *testing.T) {
+	sink := &countingCloseSink{}
+	c := New(Config{ServiceName: "test-service", FlushInterval: time.Hour, Sink: sink})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 100; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "client_test.go:1", "Write")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.ShutdownContext(context.Background()); err != nil {
+				t.Errorf("unexpected Shutdown error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sink.events.Load() != 100 || sink.closes.Load() != 1 {
+		t.Errorf("expected 100 events delivered once and one Close, got %d and %d", sink.events.Load(), sink.closes.Load())
+	}
+}
+
+func TestTrackAfterShutdownIsNoop(t *testing.T) {
+	sink := &countingCloseSink{}
+	logger := &capturingLogger{}
+	c := New(Config{ServiceName: "test-service", FlushInterval: time.Hour, Sink: sink, Logger: logger})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.Shutdown()
+
+	var mu sync.Mutex
+	for i := 0; i < 3; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "client_test.go:1", "Write")
+		c.WithLock(ctx, &mu, "counter", "Mutex", func() {})
+	}
+	c.Flush()
+
+	if stats := c.Stats(); stats.EventsBuffered != 0 || stats.EventsDropped != 9 {
+		t.Errorf("expected every late event dropped, got %+v", stats)
+	}
+	if sink.events.Load() != 0 {
+		t.Errorf("expected nothing delivered after Shutdown, got %d events", sink.events.Load())
+	}
+	if debug := logger.logged("debug"); len(debug) != 1 || !strings.Contains(debug[0], "after Shutdown") {
+		t.Errorf("expected one debug message about late events, got %q", debug)
+	}
+}
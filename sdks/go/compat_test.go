@@ -0,0 +1,102 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestDocumentedAndCanonicalStylesProduceSameEvents drives one client through
+// both API styles: NewClient/Endpoint/NewRacewayContext/Stop and
+// New/ServerURL/NewContext/Shutdown.
+func TestDocumentedAndCanonicalStylesProduceSameEvents(t *testing.T) {
+	for _, name := range regionEnvVars {
+		t.Setenv(name, "")
+	}
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			auth = append(auth, r.Header.Get("Authorization"))
+		}
+	}))
+	defer server.Close()
+
+	legacy := NewClient(Config{Endpoint: server.URL, ServiceName: "banking-api", InstanceID: "i-1", APIKey: "secret"})
+	canonical := New(Config{ServerURL: server.URL, ServiceName: "banking-api", InstanceID: "i-1", FlushInterval: time.Hour})
+	if legacy.config.Endpoint != server.URL || canonical.config.Endpoint != server.URL {
+		t.Fatalf("Endpoint and ServerURL must both configure the server URL")
+	}
+
+	legacyCtx := WithRacewayContext(context.Background(), NewRacewayContext(""))
+	canonicalCtx := NewContext(context.Background(), "", "banking-api", "i-1")
+	for _, run := range []struct {
+		client *Client
+		ctx    context.Context
+	}{{legacy, legacyCtx}, {canonical, canonicalCtx}} {
+		func() {
+			defer run.client.StartFunction(run.ctx, "transfer", map[string]interface{}{"amount": 100})()
+			run.client.TrackStateChange(run.ctx, "alice.balance", 1000, 900, "compat_test.go:1", "Write")
+		}()
+	}
+
+	legacyEvents, canonicalEvents := bufferedEvents(legacy), bufferedEvents(canonical)
+	if len(legacyEvents) != 3 || len(canonicalEvents) != 3 {
+		t.Fatalf("expected 3 events from each style, got %d and %d", len(legacyEvents), len(canonicalEvents))
+	}
+	for i := range legacyEvents {
+		l, c := legacyEvents[i], canonicalEvents[i]
+		if l.Kind.Name() != c.Kind.Name() {
+			t.Errorf("event %d: kind %s vs %s", i, l.Kind.Name(), c.Kind.Name())
+		}
+		if l.Metadata.ServiceName != c.Metadata.ServiceName || *l.Metadata.InstanceID != *c.Metadata.InstanceID {
+			t.Errorf("event %d: identity %s/%s vs %s/%s", i, l.Metadata.ServiceName, *l.Metadata.InstanceID, c.Metadata.ServiceName, *c.Metadata.InstanceID)
+		}
+		lClock, _ := json.Marshal(l.CausalityVector)
+		cClock, _ := json.Marshal(c.CausalityVector)
+		if string(lClock) != string(cClock) {
+			t.Errorf("event %d: causality_vector %s vs %s", i, lClock, cClock)
+		}
+		if want := fmt.Sprintf(`[["banking-api#i-1",%d]]`, i+1); string(cClock) != want {
+			t.Errorf("event %d: expected causality_vector %s incremented per event, got %s", i, want, cClock)
+		}
+	}
+	if GetRacewayContext(legacyCtx).ServiceName != "banking-api" {
+		t.Errorf("NewRacewayContext should adopt the capturing client's identity")
+	}
+
+	ret := legacyEvents[2]
+	if ret.Kind.FunctionReturn == nil || ret.Metadata.DurationNs == nil || legacyEvents[0].Kind.FunctionCall == nil {
+		t.Errorf("StartFunction should record the call first and the return with a duration")
+	}
+
+	legacy.Stop()
+	if err := canonical.ShutdownContext(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if len(auth) != 2 || auth[0] != "Bearer secret" || auth[1] != "" {
+		t.Errorf("expected the API key only on the configured client, got %q", auth)
+	}
+}
+
+func TestTrackFunctionReturnsResult(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Tags = map[string]string{"team": "payments"} })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	result := c.TrackFunction(ctx, "charge", nil, func() interface{} { return "ok" })
+	if result != "ok" {
+		t.Fatalf("expected fn result, got %v", result)
+	}
+	events := bufferedEvents(c)
+	if len(events) != 2 || events[1].Kind.FunctionReturn == nil || events[0].Metadata.Tags["team"] != "payments" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if got := string(events[1].Kind.FunctionReturn.ReturnValue.(json.RawMessage)); got != `"ok"` {
+		t.Errorf("unexpected return value %s", got)
+	}
+}
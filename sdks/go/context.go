@@ -2,7 +2,11 @@ package raceway
 
 import (
 	"context"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
@@ -12,24 +16,424 @@ type contextKey int
 const racewayContextKey contextKey = 0
 
 // RacewayContext holds the trace context for a request.
+//
+// A RacewayContext may be used by several goroutines at once, such as
+// requests fanned out with errgroup that track events and build propagation
+// headers with the same ctx. The SDK synchronizes its own access; fields that
+// change as events are captured are marked deprecated for direct access and
+// should be read through the methods named in their comments instead.
 type RacewayContext struct {
-	TraceID      string
-	ThreadID     string // Unique virtual thread ID for this goroutine/request
-	ParentID     *string
-	RootID       *string
+	TraceID  string
+	ThreadID string // Unique virtual thread ID for this goroutine/request
+	// ParentID is the last event captured with this context.
+	//
+	// Deprecated: reading it races with concurrent captures; use
+	// CurrentParentEventID.
+	ParentID *string
+	// RootID is the first event captured with this context.
+	//
+	// Deprecated: reading it races with concurrent captures; use RootEventID.
+	RootID *string
+	// Clock counts the events captured with this context.
+	//
+	// Deprecated: reading it races with concurrent captures; use
+	// ClockSnapshot.
 	Clock        int
 	SpanID       string
 	ParentSpanID *string
-	Distributed  bool
-	ClockVector  []CausalityEntry
-	TraceState   *string
-	ServiceName  string
-	InstanceID   string
+	// Distributed reports whether this context continues a propagated trace.
+	//
+	// Deprecated: reading it races with concurrent propagation; use
+	// IsDistributed.
+	Distributed bool
+	// ClockVector is the vector clock of the last event captured.
+	//
+	// Deprecated: reading it races with concurrent captures; use
+	// ClockSnapshot.
+	ClockVector []CausalityEntry
+	TraceState  *string
+	ServiceName string
+	InstanceID  string
+	// Region is the region suffix of this context's clock component, if any
+	Region string
+	// Baggage is propagated downstream as the W3C baggage header and recorded
+	// on every event as "baggage."-prefixed tags
+	Baggage map[string]string
+	// Sampled reports whether events of this trace are recorded. It is decided
+	// once per trace, from the upstream headers or Config.SampleRate/Sampler.
+	//
+	// Deprecated: reading it races with the sampling decision; use IsSampled.
+	Sampled bool
+
+	// mu guards the fields that change after the context is shared: the
+	// deprecated fields above, the identity adopted from the first client,
+	// and tags, links, client, heldLocks, fences, sampleDecided and spanParent
+	mu sync.Mutex
+
+	// tags are attached to every event captured with this context
+	tags map[string]string
+	// links are attached to every event captured with this context
+	links []Link
+	// tx is the innermost transaction the context is within. It is set
+	// before the context is shared and never changes.
+	tx *transaction
+	// shared is trace-scoped state common to every context derived from this one
+	shared *traceState
+	// lifetime is set on detached contexts that finalize independently of the request
+	lifetime *lifetime
+	// heldLocks holds the tracked locks this context holds, recorded as each event's lock set
+	heldLocks heldLocks
+	// client is the Client that created or last captured with this context,
+	// used by package-level helpers such as Fence
+	client *Client
+	// fences is the latest observed epoch per fence name
+	fences *fenceEpochs
+	// sampleDecided is set once Sampled holds the trace's sampling decision
+	sampleDecided bool
+	// spanParent, when set, is the start event of the enclosing Span and the
+	// parent of every event captured with this context
+	spanParent *string
+	// ownComponent caches componentLocked() for the identity in ownIdentity
+	ownComponent string
+	ownIdentity  [3]string
+}
+
+// pinParent makes the event id the parent of every event captured with r
+// until the returned function restores the previous parent. Function
+// tracking uses it so events inside a function are children of its call.
+func (r *RacewayContext) pinParent(id string) (restore func()) {
+	if id == "" {
+		return func() {}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.spanParent
+	r.spanParent = &id
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.spanParent = previous
+	}
+}
+
+// setTag attaches a tag to every subsequent event captured with this context.
+func (r *RacewayContext) setTag(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tags == nil {
+		r.tags = make(map[string]string)
+	}
+	r.tags[key] = value
+}
+
+// tag returns the value of a tag set with setTag, or "".
+func (r *RacewayContext) tag(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tags[key]
+}
+
+// boundClient returns the Client that created or first captured with r, or nil.
+func (r *RacewayContext) boundClient() *Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.client
+}
+
+// bind makes c the context's client unless it has one, giving the context
+// c's identity if it was created without one.
+func (r *RacewayContext) bind(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client != nil {
+		return
+	}
+	r.client = c
+	r.adoptIdentityLocked(c.config.ServiceName, c.instanceID)
+	r.adoptRegionLocked(c.componentRegion())
+}
+
+// advance records eventID as captured with r: it increments r's own clock
+// component and returns the event's vector and parent, preferring override,
+// then the enclosing Span's start, then the previous event. The vector is
+// shared with r, which never modifies a vector in place.
+func (r *RacewayContext) advance(eventID string, override *string) (vector []CausalityEntry, parentID *string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ClockVector = incrementComponent(r.ClockVector, r.componentLocked())
+	parentID = r.ParentID
+	if r.spanParent != nil {
+		parentID = r.spanParent
+	}
+	if override != nil {
+		parentID = override
+	}
+	if r.RootID == nil {
+		r.RootID = &eventID
+	}
+	r.ParentID = &eventID
+	r.Clock++
+	return r.ClockVector, parentID
+}
+
+// clock returns r's vector clock, shared rather than copied, and the number
+// of events captured with r. Callers must not modify the vector.
+func (r *RacewayContext) clock() ([]CausalityEntry, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ClockVector, r.Clock
+}
+
+// mergeClock merges vector into r's vector clock.
+func (r *RacewayContext) mergeClock(vector []CausalityEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ClockVector = MergeClockVectors(r.ClockVector, vector)
+}
+
+// markDistributed records that r's trace has been propagated.
+func (r *RacewayContext) markDistributed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Distributed = true
+}
+
+// traceState holds state shared by all contexts derived from the same root context.
+type traceState struct {
+	mu sync.Mutex
+	// pins records the first version observed per VersionedPointer variable
+	pins map[string]uint64
+	// panic is the last panic value recorded as an Error event
+	panic interface{}
+	// errors counts the captures of each error by CaptureError
+	errors map[string]int
+	// accesses indexes the trace's recent StateChange values for Config.PreDetect
+	accesses accessIndex
+	// promoted is set once Config.PreDetect finds a suspect in the trace,
+	// which is then recorded whatever its sampling decision
+	promoted atomic.Bool
+	// events counts the events captured in the trace, including those
+	// dropped beyond Config.MaxEventsPerTrace
+	events atomic.Int64
+}
+
+// countEvent counts an event against limit. It reports whether the event is
+// within limit and whether it is the first beyond it, which is replaced by
+// the trace's event limit marker.
+func (s *traceState) countEvent(limit int) (within, first bool) {
+	n := s.events.Add(1)
+	return n <= int64(limit), n == int64(limit)+1
+}
+
+// EventCount returns the number of events captured in r's trace by this
+// process, across every context derived from the same root, including those
+// dropped beyond Config.MaxEventsPerTrace.
+func (r *RacewayContext) EventCount() int64 {
+	if r == nil || r.shared == nil {
+		return 0
+	}
+	return r.shared.events.Load()
+}
+
+// CurrentParentEventID returns the ID of the event that becomes the parent
+// of the next event captured with r: the start of the enclosing Span, or
+// else the last event captured with r. It returns "" before r's first event.
+func (r *RacewayContext) CurrentParentEventID() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.spanParent != nil {
+		return *r.spanParent
+	}
+	if r.ParentID != nil {
+		return *r.ParentID
+	}
+	return ""
+}
+
+// ClockSnapshot returns a copy of r's vector clock, which later events do not
+// change.
+func (r *RacewayContext) ClockSnapshot() []CausalityEntry {
+	if r == nil {
+		return nil
+	}
+	vector, _ := r.clock()
+	clock := make([]CausalityEntry, len(vector))
+	copy(clock, vector)
+	return clock
+}
+
+// RootEventID returns the ID of the first event captured with r, or "" before
+// r's first event.
+func (r *RacewayContext) RootEventID() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.RootID == nil {
+		return ""
+	}
+	return *r.RootID
+}
+
+// IsDistributed reports whether r continues a trace propagated from another
+// service.
+func (r *RacewayContext) IsDistributed() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Distributed
+}
+
+// IsSampled reports whether events of r's trace are recorded, as decided so
+// far.
+func (r *RacewayContext) IsSampled() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Sampled
+}
+
+// pinVersion pins version for variable if nothing is pinned yet and returns the pinned version.
+func (s *traceState) pinVersion(variable string, version uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pins == nil {
+		s.pins = make(map[string]uint64)
+	}
+	pinned, ok := s.pins[variable]
+	if !ok {
+		s.pins[variable] = version
+		return version
+	}
+	return pinned
+}
+
+// derive returns a child context for concurrent work within the same trace.
+// The child gets its own virtual thread and span, starts from a copy of the
+// parent's clock vector, and shares the parent's trace-scoped state.
+func (r *RacewayContext) derive() *RacewayContext {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clock := make([]CausalityEntry, len(r.ClockVector))
+	copy(clock, r.ClockVector)
+	parentSpanID := r.SpanID
+
+	return &RacewayContext{
+		TraceID:      r.TraceID,
+		ThreadID:     uuid.New().String(),
+		ParentID:     r.ParentID,
+		RootID:       r.RootID,
+		Clock:        r.Clock,
+		SpanID:       generateSpanID(),
+		ParentSpanID: &parentSpanID,
+		Distributed:  r.Distributed,
+		ClockVector:  clock,
+		TraceState:   r.TraceState,
+		ServiceName:  r.ServiceName,
+		InstanceID:   r.InstanceID,
+		Region:       r.Region,
+		Baggage:      copyTags(r.Baggage),
+		Sampled:      r.Sampled,
+		tags:         copyTags(r.tags),
+		links:        copyLinks(r.links),
+		tx:           r.tx,
+		shared:       r.shared,
+		lifetime:     r.lifetime,
+		client:       r.client,
+		fences:       r.fences.copy(),
+
+		sampleDecided: r.sampleDecided,
+		spanParent:    r.spanParent,
+	}
+}
+
+// joinClock merges the clock of work that r has waited for into r, so events
+// captured with r afterwards are ordered after that work.
+func (r *RacewayContext) joinClock(vector []CausalityEntry, clock int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ClockVector = MergeClockVectors(r.ClockVector, vector)
+	if clock > r.Clock {
+		r.Clock = clock
+	}
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return copied
 }
 
 // NewContext creates a new context with Raceway tracing enabled.
 // If traceID is empty, a new UUID will be generated.
 func NewContext(ctx context.Context, traceID, serviceName, instanceID string) context.Context {
+	return newContext(ctx, traceID, serviceName, instanceID, "")
+}
+
+// ContextFromRequest returns a copy of ctx carrying a Raceway context that
+// continues the trace in the incoming headers h, for the service serviceName
+// and instance instanceID. It is ParseIncomingHeaders and NewContext in one
+// call, with every parsed field carried over. Without an upstream sampling
+// decision, the trace is sampled; Client.ContextFromRequest applies the
+// client's Config.SampleRate and Sampler instead.
+//
+// Example:
+//
+//	ctx := raceway.ContextFromRequest(r.Context(), r.Header, client.ServiceName(), client.InstanceID())
+//	client.TrackHTTPRequest(ctx, r.Method, r.URL.Path, nil, nil)
+func ContextFromRequest(ctx context.Context, h http.Header, serviceName, instanceID string) context.Context {
+	parsed := ParseIncomingHeaders(h, serviceName, instanceID)
+	ctxWith := NewContext(ctx, parsed.TraceID, serviceName, instanceID)
+	FromContext(ctxWith).applyParsed(parsed, FromContext(ctx), func() bool { return true })
+	return ctxWith
+}
+
+// applyParsed populates r, a context created for parsed.TraceID and not yet
+// shared, from parsed incoming headers. It is the one place parsed fields
+// map onto context fields. local is the context the request arrived in, if
+// any, and sample makes the sampling decision when parsed carries none.
+func (r *RacewayContext) applyParsed(parsed ParsedTraceContext, local *RacewayContext, sample func() bool) {
+	r.SpanID = parsed.SpanID
+	r.ParentSpanID = parsed.ParentSpanID
+	r.Distributed = parsed.Distributed
+	r.ClockVector = parsed.ClockVector
+	// A request arriving mid-trace, such as a webhook callback, keeps what
+	// this service already knew about the trace
+	if local != nil && local.TraceID == parsed.TraceID {
+		localClock, _ := local.clock()
+		r.ClockVector = MergeClockVectors(localClock, parsed.ClockVector)
+	}
+	r.TraceState = parsed.TraceState
+	r.Baggage = parsed.Baggage
+	r.decideSampling(parsed.Sampled, sample)
+	if parsed.ScatterID != "" {
+		r.setTag("scatter_id", parsed.ScatterID)
+		r.setTag("scatter_index", strconv.Itoa(parsed.ScatterIndex))
+	}
+	if parsed.OriginTraceID != "" {
+		r.setTag(originTraceIDTag, parsed.OriginTraceID)
+	}
+	if parsed.UpstreamRegion != "" {
+		r.setTag("upstream_region", parsed.UpstreamRegion)
+	}
+	r.mergeFences(parsed.Fences)
+}
+
+// newContext is NewContext with a region-qualified clock component.
+func newContext(ctx context.Context, traceID, serviceName, instanceID, region string) context.Context {
 	if traceID == "" {
 		traceID = uuid.New().String()
 	}
@@ -37,7 +441,7 @@ func NewContext(ctx context.Context, traceID, serviceName, instanceID string) co
 	// Generate unique virtual thread ID for this context
 	threadID := uuid.New().String()
 
-	component := clockComponent(serviceName, instanceID)
+	component := clockComponent(serviceName, instanceID, region)
 
 	rctx := &RacewayContext{
 		TraceID:      traceID,
@@ -52,6 +456,9 @@ func NewContext(ctx context.Context, traceID, serviceName, instanceID string) co
 		TraceState:   nil,
 		ServiceName:  serviceName,
 		InstanceID:   instanceID,
+		Region:       region,
+		Sampled:      true,
+		shared:       &traceState{},
 	}
 
 	return context.WithValue(ctx, racewayContextKey, rctx)
@@ -67,6 +474,34 @@ func FromContext(ctx context.Context) *RacewayContext {
 	return rctx
 }
 
+// TraceIDFromContext returns the ID of the trace ctx belongs to, or "" if ctx
+// carries no RacewayContext, so applications can include it in their own logs
+// and find the request in Raceway.
+func TraceIDFromContext(ctx context.Context) string {
+	if rctx := FromContext(ctx); rctx != nil {
+		return rctx.TraceID
+	}
+	return ""
+}
+
+// NewRacewayContext returns a context for traceID, or a new trace if traceID
+// is empty. The service and instance are taken from the first Client that
+// captures an event with it. Attach it with WithRacewayContext; NewContext
+// does both in one call.
+func NewRacewayContext(traceID string) *RacewayContext {
+	return FromContext(newContext(context.Background(), traceID, "", "", ""))
+}
+
+// WithRacewayContext returns a copy of ctx carrying rctx.
+func WithRacewayContext(ctx context.Context, rctx *RacewayContext) context.Context {
+	return context.WithValue(ctx, racewayContextKey, rctx)
+}
+
+// GetRacewayContext is an alias for FromContext.
+func GetRacewayContext(ctx context.Context) *RacewayContext {
+	return FromContext(ctx)
+}
+
 // WithTraceID creates a new context with the specified trace ID (legacy helper).
 func WithTraceID(ctx context.Context, traceID string) context.Context {
 	return NewContext(ctx, traceID, "unknown-service", "instance")
@@ -77,8 +512,72 @@ func WithTraceIDAndInstance(ctx context.Context, traceID, serviceName, instanceI
 	return NewContext(ctx, traceID, serviceName, instanceID)
 }
 
-func clockComponent(serviceName, instanceID string) string {
-	return serviceName + "#" + instanceID
+// adoptIdentityLocked gives a context created without a service or
+// instance, such as one from NewRacewayContext, the identity of the client
+// that first captures with it, carrying over the local clock value. r.mu
+// must be held.
+func (r *RacewayContext) adoptIdentityLocked(serviceName, instanceID string) {
+	if r.ServiceName != "" || r.InstanceID != "" {
+		return
+	}
+	legacy := r.componentLocked()
+	r.ServiceName, r.InstanceID = serviceName, instanceID
+	r.renameComponentLocked(legacy)
+}
+
+// clockComponent formats a vector clock component as "service#instance", or
+// "service#instance@region" when region is set.
+func clockComponent(serviceName, instanceID, region string) string {
+	if region == "" {
+		return serviceName + "#" + instanceID
+	}
+	return serviceName + "#" + instanceID + "@" + region
+}
+
+// parseClockComponent splits a clock component into its parts. Components
+// written before regions were introduced have no "@region" suffix.
+func parseClockComponent(component string) (serviceName, instanceID, region string) {
+	serviceName, rest, _ := strings.Cut(component, "#")
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		return serviceName, rest[:i], rest[i+1:]
+	}
+	return serviceName, rest, ""
+}
+
+// componentLocked returns this context's own clock component. r.mu must be
+// held.
+func (r *RacewayContext) componentLocked() string {
+	identity := [3]string{r.ServiceName, r.InstanceID, r.Region}
+	if r.ownComponent == "" || identity != r.ownIdentity {
+		r.ownComponent, r.ownIdentity = clockComponent(r.ServiceName, r.InstanceID, r.Region), identity
+	}
+	return r.ownComponent
+}
+
+// adoptRegionLocked moves a context created without a region onto the
+// region-qualified component, carrying over the local clock value. r.mu must
+// be held.
+func (r *RacewayContext) adoptRegionLocked(region string) {
+	if r.Region != "" || region == "" {
+		return
+	}
+	legacy := r.componentLocked()
+	r.Region = region
+	r.renameComponentLocked(legacy)
+}
+
+// renameComponentLocked moves the clock value of component legacy onto this
+// context's own component. The vector is copied rather than modified in
+// place, since recorded events share it. r.mu must be held.
+func (r *RacewayContext) renameComponentLocked(legacy string) {
+	renamed := make([]CausalityEntry, len(r.ClockVector))
+	for i, entry := range r.ClockVector {
+		if entry.Component() == legacy {
+			entry = NewCausalityEntry(r.componentLocked(), entry.Value())
+		}
+		renamed[i] = entry
+	}
+	r.ClockVector = renamed
 }
 
 func generateSpanID() string {
@@ -0,0 +1,215 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestContextAccessors(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := c.newContext(context.Background(), "")
+	rctx := FromContext(ctx)
+	if rctx.CurrentParentEventID() != "" || rctx.IsDistributed() {
+		t.Fatalf("expected a fresh local context, got parent %q", rctx.CurrentParentEventID())
+	}
+
+	c.TrackStateChange(ctx, "balance", 100, 90, "context_test.go:1", "Write")
+	snapshot := rctx.ClockSnapshot()
+	span, spanCtx := c.StartSpan(ctx, "debit", nil)
+	if got := FromContext(spanCtx).CurrentParentEventID(); got != span.EventID() {
+		t.Errorf("expected the span's start event as the parent inside it, got %q", got)
+	}
+	c.TrackStateChange(spanCtx, "balance", 90, 80, "context_test.go:2", "Write")
+	span.End()
+
+	events := bufferedEvents(c)
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	if got := rctx.CurrentParentEventID(); got != events[0].ID {
+		t.Errorf("expected the last event outside the span as the parent, got %q", got)
+	}
+	if !hasClockComponent(snapshot, "test-service#test-instance", 1) || len(snapshot) != 1 {
+		t.Errorf("expected the snapshot unchanged by later events, got %v", snapshot)
+	}
+	if rctx.EventCount() != 4 {
+		t.Errorf("expected 4 events counted, got %d", rctx.EventCount())
+	}
+
+	parsed := c.Extract(MapCarrier{"traceparent": validTraceparent}, "test-service", "test-instance")
+	if !FromContext(c.contextFromParsed(context.Background(), parsed)).IsDistributed() {
+		t.Errorf("expected a propagated context to be distributed")
+	}
+
+	var missing *RacewayContext
+	if missing.CurrentParentEventID() != "" || missing.ClockSnapshot() != nil || missing.IsDistributed() {
+		t.Errorf("expected zero values from a nil context")
+	}
+}
+
+// TestContextSharedAcrossGoroutines fans one request context out to
+// goroutines that each build propagation headers and track an event, as
+// errgroup fan-outs do; run with -race.
+func TestContextSharedAcrossGoroutines(t *testing.T) {
+	const fanOut = 50
+	c := newBufferingClient(t, nil)
+	ctx := c.newContext(context.Background(), "")
+	rctx := FromContext(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < fanOut; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			headers, err := c.PropagationHeaders(ctx, nil)
+			if err != nil || headers[racewayClockHeader] == "" {
+				t.Errorf("expected propagation headers, got %v, %v", headers, err)
+			}
+			c.TrackStateChange(ctx, fmt.Sprintf("shard-%d", i), nil, i, "context_test.go:1", "Write")
+		}(i)
+	}
+	wg.Wait()
+
+	events := bufferedEvents(c)
+	if len(events) != fanOut {
+		t.Fatalf("expected %d events, got %d", fanOut, len(events))
+	}
+	clocks := make(map[uint64]bool, fanOut)
+	for _, event := range events {
+		for _, entry := range event.CausalityVector {
+			if entry.Component() == "test-service#test-instance" {
+				clocks[entry.Value()] = true
+			}
+		}
+	}
+	if len(clocks) != fanOut {
+		t.Errorf("expected %d distinct clock values, got %d", fanOut, len(clocks))
+	}
+	if !hasClockComponent(rctx.ClockSnapshot(), "test-service#test-instance", fanOut) {
+		t.Errorf("expected the context's clock to count every event, got %v", rctx.ClockSnapshot())
+	}
+	if !rctx.IsDistributed() || rctx.RootEventID() == "" || rctx.CurrentParentEventID() == "" {
+		t.Errorf("expected a distributed context with root and parent events")
+	}
+}
+
+// TestContextFromParsedCoversEveryField fails when a field added to
+// ParsedTraceContext is not carried onto the RacewayContext: map it in
+// applyParsed and add its check here.
+func TestContextFromParsedCoversEveryField(t *testing.T) {
+	var sampledPath string
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Sampler = func(traceID, path string) bool {
+			sampledPath = path
+			return true
+		}
+	})
+	parentSpanID, traceState, sampled := "00f067aa0ba902b7", "vendor=value", false
+	parsed := ParsedTraceContext{
+		TraceID:        validTraceID,
+		SpanID:         validSpanID,
+		ParentSpanID:   &parentSpanID,
+		TraceState:     &traceState,
+		ClockVector:    []CausalityEntry{NewCausalityEntry("upstream#1", 3)},
+		Distributed:    true,
+		ScatterID:      "scatter-1",
+		ScatterIndex:   4,
+		OriginTraceID:  "req-42",
+		Fences:         map[string]uint64{"deploy": 7},
+		UpstreamRegion: "eu-west-1",
+		Baggage:        map[string]string{"tenant": "acme"},
+		Sampled:        &sampled,
+		path:           "/accounts/42",
+	}
+	rctx := FromContext(c.contextFromParsed(context.Background(), parsed))
+	unsampled := parsed
+	unsampled.Sampled = nil
+	c.contextFromParsed(context.Background(), unsampled)
+
+	checks := map[string]bool{
+		"TraceID":        rctx.TraceID == validTraceID,
+		"SpanID":         rctx.SpanID == validSpanID,
+		"ParentSpanID":   rctx.ParentSpanID != nil && *rctx.ParentSpanID == parentSpanID,
+		"TraceState":     rctx.TraceState != nil && *rctx.TraceState == traceState,
+		"ClockVector":    hasClockComponent(rctx.ClockSnapshot(), "upstream#1", 3),
+		"Distributed":    rctx.IsDistributed(),
+		"ScatterID":      rctx.tag("scatter_id") == "scatter-1",
+		"ScatterIndex":   rctx.tag("scatter_index") == "4",
+		"OriginTraceID":  rctx.tag(originTraceIDTag) == "req-42",
+		"Fences":         rctx.fenceSnapshot()["deploy"] == 7,
+		"UpstreamRegion": rctx.tag("upstream_region") == "eu-west-1",
+		"Baggage":        rctx.Baggage["tenant"] == "acme",
+		"Sampled":        !rctx.IsSampled(),
+		"path":           sampledPath == "/accounts/42",
+	}
+	fields := reflect.TypeOf(parsed)
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Name
+		ok, covered := checks[name]
+		if !covered {
+			t.Errorf("ParsedTraceContext.%s is not covered by this test", name)
+		} else if !ok {
+			t.Errorf("ParsedTraceContext.%s was not carried onto the context", name)
+		}
+	}
+}
+
+func TestContextFromRequest(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("traceparent", validTraceparent)
+	headers.Set("tracestate", "vendor=value")
+	headers.Set("baggage", "tenant=acme")
+	ctx := ContextFromRequest(context.Background(), headers, "test-service", "test-instance")
+
+	rctx := FromContext(ctx)
+	if rctx == nil || rctx.TraceID != validTraceID || rctx.SpanID != validSpanID || !rctx.IsDistributed() {
+		t.Fatalf("expected the incoming trace continued, got %+v", rctx)
+	}
+	if rctx.TraceState == nil || *rctx.TraceState != "vendor=value" || rctx.Baggage["tenant"] != "acme" || !rctx.IsSampled() {
+		t.Errorf("expected tracestate, baggage and the sampled flag carried over")
+	}
+	if rctx.ServiceName != "test-service" || rctx.InstanceID != "test-instance" {
+		t.Errorf("expected the given identity, got %s#%s", rctx.ServiceName, rctx.InstanceID)
+	}
+
+	c := newBufferingClient(t, nil)
+	req := httptest.NewRequest("GET", "/accounts/42", nil)
+	req.Header = headers
+	if got := FromContext(c.ContextFromRequest(req)); got == nil || got.TraceID != validTraceID || got.client != c {
+		t.Errorf("expected the client's context for the incoming trace, got %+v", got)
+	}
+}
+
+func TestTraceIDFromContext(t *testing.T) {
+	if got := TraceIDFromContext(context.Background()); got != "" {
+		t.Errorf("expected no trace ID outside a trace, got %q", got)
+	}
+	ctx := NewContext(context.Background(), validTraceID, "test-service", "test-instance")
+	if got := TraceIDFromContext(ctx); got != validTraceID {
+		t.Errorf("expected %q, got %q", validTraceID, got)
+	}
+}
+
+func ExampleTraceIDFromContext() {
+	client := New(Config{ServiceName: "payments", Sink: NoopSink{}})
+	defer client.Shutdown()
+
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Log the trace ID with the application's own logs to find the
+		// request in Raceway
+		fmt.Printf("level=info msg=\"transfer accepted\" trace_id=%s\n", TraceIDFromContext(r.Context()))
+	}))
+
+	req := httptest.NewRequest("POST", "/transfer", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	// Output:
+	// level=info msg="transfer accepted" trace_id=4bf92f35-77b3-4da6-a3ce-929d0e0e4736
+}
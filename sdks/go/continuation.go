@@ -0,0 +1,173 @@
+package raceway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	continuationPrefix = "rwc1."
+	// maxContinuationTokenBytes bounds tokens so they fit in typical database columns and headers.
+	maxContinuationTokenBytes = 4096
+)
+
+var (
+	// ErrNoContinuationSecret is returned when SuspendTrace or ResumeTrace is used without Config.ContinuationSecret.
+	ErrNoContinuationSecret = errors.New("raceway: continuation secret not configured")
+	// ErrInvalidContinuation is returned for malformed, oversized, or tampered continuation tokens.
+	ErrInvalidContinuation = errors.New("raceway: invalid continuation token")
+)
+
+// continuationPayload is the signed body of a continuation token.
+// Unknown fields are ignored on resume so newer SDKs can add to it.
+type continuationPayload struct {
+	Version     int             `json:"v"`
+	TraceID     string          `json:"trace_id"`
+	SpanID      string          `json:"span_id"`
+	ClockVector [][]interface{} `json:"clock"`
+	Service     string          `json:"service"`
+	Instance    string          `json:"instance"`
+	IssuedAtMs  int64           `json:"issued_at_ms"`
+}
+
+// SuspendTrace returns an opaque, signed continuation token for the trace in ctx.
+// Store it alongside the external reference (e.g. a payment provider ID) and pass it
+// to ResumeTrace when the callback arrives, so both halves of the flow share one trace.
+func (c *Client) SuspendTrace(ctx context.Context) (string, error) {
+	if BuildDisabled || c.disabled {
+		return "", nil
+	}
+	if len(c.config.ContinuationSecret) == 0 {
+		return "", ErrNoContinuationSecret
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return "", fmt.Errorf("raceway: suspend requested outside of active context")
+	}
+
+	rctx.mu.Lock()
+	payload := continuationPayload{
+		Version:     1,
+		TraceID:     rctx.TraceID,
+		SpanID:      rctx.SpanID,
+		ClockVector: encodeClockVector(rctx.ClockVector),
+		Service:     rctx.ServiceName,
+		Instance:    rctx.InstanceID,
+		IssuedAtMs:  time.Now().UnixMilli(),
+	}
+	rctx.mu.Unlock()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+	token := continuationPrefix + encoded + "." + c.signContinuation(encoded)
+	if len(token) > maxContinuationTokenBytes {
+		return "", fmt.Errorf("raceway: continuation token exceeds %d bytes", maxContinuationTokenBytes)
+	}
+	return token, nil
+}
+
+// ResumeTrace reconstructs a trace suspended with SuspendTrace.
+//
+// The returned context continues the original trace on a new virtual thread,
+// with the suspending span as its parent, the suspended clock merged in, and a
+// resumed_after_ms tag. If the token is older than Config.MaxSuspension, a new
+// trace is started instead and linked to the original via linked_trace_id tags.
+// Tokens that fail signature verification return ErrInvalidContinuation.
+func (c *Client) ResumeTrace(ctx context.Context, token string) (context.Context, error) {
+	if BuildDisabled || c.disabled {
+		return ctx, nil
+	}
+	payload, err := c.verifyContinuation(token)
+	if err != nil {
+		return ctx, err
+	}
+
+	suspendedFor := time.Since(time.UnixMilli(payload.IssuedAtMs))
+	if suspendedFor < 0 {
+		suspendedFor = 0
+	}
+
+	if max := c.config.MaxSuspension; max > 0 && suspendedFor > max {
+		resumed := c.newContext(ctx, "")
+		rctx := FromContext(resumed)
+		rctx.setTag("linked_trace_id", payload.TraceID)
+		rctx.setTag("linked_span_id", payload.SpanID)
+		rctx.setTag("link_relation", "continues")
+		rctx.setTag("resumed_after_ms", strconv.FormatInt(suspendedFor.Milliseconds(), 10))
+		return resumed, nil
+	}
+
+	resumed := c.newContext(ctx, payload.TraceID)
+	rctx := FromContext(resumed)
+	parentSpanID := payload.SpanID
+	rctx.ParentSpanID = &parentSpanID
+	rctx.Distributed = true
+	rctx.ClockVector = MergeClockVectors(rctx.ClockVector, decodeClockVector(payload.ClockVector))
+	rctx.setTag("resumed_after_ms", strconv.FormatInt(suspendedFor.Milliseconds(), 10))
+	return resumed, nil
+}
+
+func (c *Client) signContinuation(encoded string) string {
+	mac := hmac.New(sha256.New, c.config.ContinuationSecret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Client) verifyContinuation(token string) (continuationPayload, error) {
+	if len(c.config.ContinuationSecret) == 0 {
+		return continuationPayload{}, ErrNoContinuationSecret
+	}
+	if len(token) > maxContinuationTokenBytes || !strings.HasPrefix(token, continuationPrefix) {
+		return continuationPayload{}, ErrInvalidContinuation
+	}
+
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, continuationPrefix), ".")
+	if !ok {
+		return continuationPayload{}, ErrInvalidContinuation
+	}
+	if !hmac.Equal([]byte(signature), []byte(c.signContinuation(encoded))) {
+		return continuationPayload{}, ErrInvalidContinuation
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return continuationPayload{}, ErrInvalidContinuation
+	}
+	var payload continuationPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.TraceID == "" {
+		return continuationPayload{}, ErrInvalidContinuation
+	}
+	return payload, nil
+}
+
+// decodeClockVector converts the [[component, value], ...] wire form into causality entries,
+// skipping malformed items.
+func decodeClockVector(encoded [][]interface{}) []CausalityEntry {
+	entries := make([]CausalityEntry, 0, len(encoded))
+	for _, item := range encoded {
+		if len(item) != 2 {
+			continue
+		}
+		component, ok := item[0].(string)
+		if !ok {
+			continue
+		}
+		value, ok := item[1].(float64)
+		if !ok || value < 0 {
+			continue
+		}
+		entries = append(entries, NewCausalityEntry(component, uint64(value)))
+	}
+	return entries
+}
@@ -0,0 +1,132 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuspendResumeRoundTrip(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) {
+		cfg.ContinuationSecret = []byte("test-secret")
+		cfg.MaxSuspension = time.Hour
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	client.TrackStateChange(ctx, "payment.status", nil, "pending", "continuation_test.go:1", "Write")
+	client.TrackStateChange(ctx, "payment.status", "pending", "submitted", "continuation_test.go:2", "Write")
+	suspended := FromContext(ctx)
+
+	token, err := client.SuspendTrace(ctx)
+	if err != nil {
+		t.Fatalf("unexpected suspend error: %v", err)
+	}
+	if !strings.HasPrefix(token, "rwc1.") {
+		t.Errorf("expected versioned token, got %q", token)
+	}
+
+	resumed, err := client.ResumeTrace(context.Background(), token)
+	if err != nil {
+		t.Fatalf("unexpected resume error: %v", err)
+	}
+	rctx := FromContext(resumed)
+	if rctx.TraceID != suspended.TraceID {
+		t.Errorf("expected resumed trace %s, got %s", suspended.TraceID, rctx.TraceID)
+	}
+	if rctx.ThreadID == suspended.ThreadID {
+		t.Error("expected resumed context to run on a new virtual thread")
+	}
+	if rctx.ParentSpanID == nil || *rctx.ParentSpanID != suspended.SpanID {
+		t.Errorf("expected parent span %s, got %v", suspended.SpanID, rctx.ParentSpanID)
+	}
+	if !rctx.Distributed {
+		t.Error("expected resumed context to be marked distributed")
+	}
+
+	client.TrackStateChange(resumed, "payment.status", "submitted", "settled", "continuation_test.go:3", "Write")
+	events := bufferedEvents(client)
+	last := events[len(events)-1]
+	if !hasClockComponent(last.CausalityVector, "test-service#test-instance", 3) {
+		t.Errorf("expected clock to continue from the suspended value, got %v", last.CausalityVector)
+	}
+	if _, ok := last.Metadata.Tags["resumed_after_ms"]; !ok {
+		t.Error("expected resumed_after_ms tag")
+	}
+}
+
+func TestResumeExpiredTokenStartsLinkedTrace(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) {
+		cfg.ContinuationSecret = []byte("test-secret")
+		cfg.MaxSuspension = time.Millisecond
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	token, err := client.SuspendTrace(ctx)
+	if err != nil {
+		t.Fatalf("unexpected suspend error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	resumed, err := client.ResumeTrace(context.Background(), token)
+	if err != nil {
+		t.Fatalf("unexpected resume error: %v", err)
+	}
+	rctx := FromContext(resumed)
+	original := FromContext(ctx)
+	if rctx.TraceID == original.TraceID {
+		t.Error("expected expired token to start a new trace")
+	}
+	if rctx.tags["linked_trace_id"] != original.TraceID {
+		t.Errorf("expected link to original trace, got %v", rctx.tags)
+	}
+	if rctx.tags["link_relation"] != "continues" {
+		t.Errorf("expected continues relation, got %v", rctx.tags)
+	}
+}
+
+func TestResumeRejectsTamperedTokens(t *testing.T) {
+	client := newBufferingClient(t, func(cfg *Config) {
+		cfg.ContinuationSecret = []byte("test-secret")
+		cfg.MaxSuspension = 0
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	token, err := client.SuspendTrace(ctx)
+	if err != nil {
+		t.Fatalf("unexpected suspend error: %v", err)
+	}
+
+	body, signature, _ := strings.Cut(strings.TrimPrefix(token, "rwc1."), ".")
+	tampered := []string{
+		"rwc1." + body + "x." + signature,
+		"rwc1." + body + "." + signature[:len(signature)-2] + "AA",
+		"rwc1." + body,
+		"rwc2." + body + "." + signature,
+		"garbage",
+		"rwc1." + strings.Repeat("a", maxContinuationTokenBytes),
+	}
+	for _, bad := range tampered {
+		if _, err := client.ResumeTrace(context.Background(), bad); !errors.Is(err, ErrInvalidContinuation) {
+			t.Errorf("expected ErrInvalidContinuation for %q, got %v", bad[:min(len(bad), 20)], err)
+		}
+	}
+
+	other := newBufferingClient(t, func(c *Config) { c.ContinuationSecret = []byte("other-secret") })
+	if _, err := other.ResumeTrace(context.Background(), token); !errors.Is(err, ErrInvalidContinuation) {
+		t.Errorf("expected a different secret to fail verification, got %v", err)
+	}
+}
+
+func TestContinuationRequiresSecret(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	if _, err := client.SuspendTrace(ctx); !errors.Is(err, ErrNoContinuationSecret) {
+		t.Errorf("expected ErrNoContinuationSecret, got %v", err)
+	}
+	if _, err := client.ResumeTrace(ctx, "rwc1.a.b"); !errors.Is(err, ErrNoContinuationSecret) {
+		t.Errorf("expected ErrNoContinuationSecret, got %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/mode7labs/raceway/sdks/go/contrib/chi
+
+go 1.21
+
+require (
+	github.com/go-chi/chi/v5 v5.0.12
+	github.com/mode7labs/raceway/sdks/go v0.0.0-00010101000000-000000000000
+)
+
+require github.com/google/uuid v1.6.0 // indirect
+
+replace github.com/mode7labs/raceway/sdks/go => ../..
@@ -0,0 +1,38 @@
+// Package racewaychi records requests served by a chi router as Raceway
+// traces.
+//
+// It lives in its own module so that the core SDK does not depend on chi:
+//
+//	router := chi.NewRouter()
+//	router.Use(racewaychi.Middleware(client))
+//	router.Get("/accounts/{id}", func(w http.ResponseWriter, r *http.Request) {
+//	    ctx := r.Context()
+//	    client.TrackStateChange(ctx, "balance", old, new, "main.go:42", "Read")
+//	})
+//
+// Middleware behaves like raceway.Client.Middleware and also records the
+// matched route pattern, such as "/accounts/{id}", in the http.route tag.
+package racewaychi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// Middleware returns chi middleware that traces each request with client.
+func Middleware(client *raceway.Client) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// chi completes the pattern as subrouters match, so it is read
+			// once the handler returns
+			defer func() {
+				if rctx := chi.RouteContext(r.Context()); rctx != nil {
+					client.SetRoute(r.Context(), rctx.RoutePattern())
+				}
+			}()
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
@@ -0,0 +1,83 @@
+//go:build !raceway_disabled
+
+package racewaychi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+func newTestRouter(t *testing.T) (*chi.Mux, *raceway.Recorder) {
+	t.Helper()
+	config := raceway.Config{ServiceName: "racewaychi", InstanceID: "test", Region: "test"}
+	recorder := raceway.NewRecorderWithConfig(config)
+	t.Cleanup(func() { recorder.Shutdown() })
+
+	router := chi.NewRouter()
+	router.Use(Middleware(recorder.Client))
+	return router, recorder
+}
+
+func TestMiddlewareWithTraceparent(t *testing.T) {
+	router, recorder := newTestRouter(t)
+	traceID := "550e8400-e29b-41d4-a716-446655440000"
+	router.Route("/accounts", func(r chi.Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			rctx := raceway.FromContext(r.Context())
+			if rctx == nil {
+				t.Fatal("expected Raceway context to be set")
+			}
+			if rctx.TraceID != traceID || !rctx.Distributed {
+				t.Errorf("expected the distributed trace %s, got %s", traceID, rctx.TraceID)
+			}
+			w.WriteHeader(http.StatusAccepted)
+		})
+	})
+
+	req := httptest.NewRequest("GET", "/accounts/42", nil)
+	req.Header.Set("traceparent", "00-550e8400e29b41d4a716446655440000-0123456789abcdef-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", rec.Code)
+	}
+
+	events := recorder.Events()
+	if len(events) != 2 {
+		t.Fatalf("expected request and response, got %d events", len(events))
+	}
+	request, response := events[0].Kind.HTTPRequest, events[1].Kind.HTTPResponse
+	if request == nil || request.URL != "/accounts/42" {
+		t.Errorf("expected the request first, got %+v", events[0].Kind)
+	}
+	if response == nil || response.Status != http.StatusAccepted {
+		t.Errorf("expected a 202 response, got %+v", events[1].Kind)
+	}
+	for _, event := range events {
+		if event.TraceID != traceID {
+			t.Errorf("expected trace %s, got %s", traceID, event.TraceID)
+		}
+		if event.Metadata.Tags["http.route"] != "/accounts/{id}" {
+			t.Errorf("expected the route pattern on %s, got %v", event.Kind.Name(), event.Metadata.Tags)
+		}
+	}
+}
+
+func TestMiddlewareWithoutTraceparentStartsTrace(t *testing.T) {
+	router, recorder := newTestRouter(t)
+	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+
+	events := recorder.Events()
+	if len(events) != 2 || events[0].TraceID == "" || events[0].TraceID != events[1].TraceID {
+		t.Fatalf("expected a new trace for the request, got %+v", events)
+	}
+	if response := events[1].Kind.HTTPResponse; response == nil || response.Status != http.StatusOK {
+		t.Errorf("expected a 200 response, got %+v", events[1].Kind)
+	}
+}
@@ -0,0 +1,46 @@
+// Package racewayecho records requests served by Echo as Raceway traces.
+//
+// It lives in its own module so that the core SDK does not depend on Echo:
+//
+//	e := echo.New()
+//	e.Use(racewayecho.Middleware(client))
+//	e.GET("/accounts/:id", func(c echo.Context) error {
+//	    ctx := c.Request().Context()
+//	    client.TrackStateChange(ctx, "balance", old, new, "main.go:42", "Read")
+//	    return c.NoContent(http.StatusOK)
+//	})
+//
+// Middleware behaves like raceway.Client.Middleware and also records the
+// matched route path, such as "/accounts/:id", in the http.route tag. An error
+// returned by a handler is passed to Echo's HTTPErrorHandler before the
+// response is recorded, so the recorded status is the one the client sees.
+package racewayecho
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// Middleware returns Echo middleware that traces each request with client.
+func Middleware(client *raceway.Client) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			response := c.Response()
+			writer := response.Writer
+			client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				client.SetRoute(r.Context(), c.Path())
+				// Handlers write through the Raceway recorder, which writes to
+				// the server's writer
+				response.Writer = w
+				if err = next(c); err != nil {
+					c.Error(err)
+				}
+			})).ServeHTTP(writer, c.Request())
+			response.Writer = writer
+			return err
+		}
+	}
+}
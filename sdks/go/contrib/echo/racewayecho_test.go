@@ -0,0 +1,88 @@
+//go:build !raceway_disabled
+
+package racewayecho
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+func newTestServer(t *testing.T) (*echo.Echo, *raceway.Recorder) {
+	t.Helper()
+	config := raceway.Config{ServiceName: "racewayecho", InstanceID: "test", Region: "test"}
+	recorder := raceway.NewRecorderWithConfig(config)
+	t.Cleanup(func() { recorder.Shutdown() })
+
+	e := echo.New()
+	e.Use(Middleware(recorder.Client))
+	return e, recorder
+}
+
+func TestMiddlewareWithTraceparent(t *testing.T) {
+	e, recorder := newTestServer(t)
+	traceID := "550e8400-e29b-41d4-a716-446655440000"
+	e.GET("/accounts/:id", func(c echo.Context) error {
+		rctx := raceway.FromContext(c.Request().Context())
+		if rctx == nil {
+			t.Fatal("expected Raceway context to be set")
+		}
+		if rctx.TraceID != traceID || !rctx.Distributed {
+			t.Errorf("expected the distributed trace %s, got %s", traceID, rctx.TraceID)
+		}
+		return c.String(http.StatusAccepted, "queued")
+	})
+
+	req := httptest.NewRequest("GET", "/accounts/42", nil)
+	req.Header.Set("traceparent", "00-550e8400e29b41d4a716446655440000-0123456789abcdef-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "queued" {
+		t.Fatalf("expected status 202 with the body, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	events := recorder.Events()
+	if len(events) != 2 {
+		t.Fatalf("expected request and response, got %d events", len(events))
+	}
+	request, response := events[0].Kind.HTTPRequest, events[1].Kind.HTTPResponse
+	if request == nil || request.URL != "/accounts/42" {
+		t.Errorf("expected the request first, got %+v", events[0].Kind)
+	}
+	if response == nil || response.Status != http.StatusAccepted {
+		t.Errorf("expected a 202 response, got %+v", events[1].Kind)
+	}
+	if events[1].Metadata.Tags["response_bytes"] != "6" {
+		t.Errorf("expected the body size recorded, got %v", events[1].Metadata.Tags)
+	}
+	for _, event := range events {
+		if event.TraceID != traceID {
+			t.Errorf("expected trace %s, got %s", traceID, event.TraceID)
+		}
+		if event.Metadata.Tags["http.route"] != "/accounts/:id" {
+			t.Errorf("expected the route path on %s, got %v", event.Kind.Name(), event.Metadata.Tags)
+		}
+	}
+}
+
+func TestMiddlewareRecordsHandlerErrorStatus(t *testing.T) {
+	e, recorder := newTestServer(t)
+	e.GET("/accounts/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound, "account not found")
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/accounts/42", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+
+	events := recorder.Events()
+	last := events[len(events)-1].Kind.HTTPResponse
+	if last == nil || last.Status != http.StatusNotFound {
+		t.Errorf("expected a 404 response recorded, got %+v", events[len(events)-1].Kind)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/mode7labs/raceway/sdks/go/contrib/errgroup
+
+go 1.21
+
+require (
+	github.com/google/uuid v1.6.0
+	github.com/mode7labs/raceway/sdks/go v0.0.0-00010101000000-000000000000
+	golang.org/x/sync v0.7.0
+)
+
+replace github.com/mode7labs/raceway/sdks/go => ../..
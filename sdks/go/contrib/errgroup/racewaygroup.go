@@ -0,0 +1,150 @@
+// Package racewaygroup wraps golang.org/x/sync/errgroup so that the branches
+// of a fan-out are recorded as concurrent goroutines of the trace and the
+// parent's events after Wait are ordered after all of them.
+//
+// It lives in its own module so that the core SDK does not depend on x/sync:
+//
+//	g, ctx := racewaygroup.WithContext(ctx, client)
+//	for _, account := range accounts {
+//	    account := account
+//	    g.Go("load_"+account, func(ctx context.Context) error {
+//	        return load(ctx, account)
+//	    })
+//	}
+//	if err := g.Wait(); err != nil {
+//	    return err
+//	}
+//
+// Each Go records an AsyncSpawn event and runs its function with a context
+// derived by raceway.DeriveThread, on a new virtual thread and span. An error
+// the function returns is recorded with Client.CaptureError. Wait records an
+// AsyncJoin for every branch, merging its clock into the parent's.
+package racewaygroup
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+	"golang.org/x/sync/errgroup"
+)
+
+// taskTag is the tag naming the branch on the Error events of its failures.
+const taskTag = "errgroup_task"
+
+// Group is an errgroup.Group whose branches are recorded with a Raceway
+// client. Like errgroup's, a Group must not be copied after first use.
+type Group struct {
+	client *raceway.Client
+	// parent records the spawn and join events; it is the context passed
+	// to WithContext, so they are not marked cancelled when the group is
+	parent context.Context
+	// ctx is errgroup's derived context, which branches inherit
+	ctx   context.Context
+	group *errgroup.Group
+
+	mu       sync.Mutex
+	branches []branch
+}
+
+// branch is a function started by Go, awaiting its join.
+type branch struct {
+	taskID string
+	ctx    context.Context
+}
+
+// WithContext returns a new Group and an associated context derived from ctx,
+// as errgroup.WithContext does. The derived context is cancelled the first
+// time a function passed to Go returns an error or the first time Wait
+// returns, whichever occurs first.
+func WithContext(ctx context.Context, client *raceway.Client) (*Group, context.Context) {
+	group, groupCtx := errgroup.WithContext(ctx)
+	return &Group{client: client, parent: ctx, ctx: groupCtx, group: group}, groupCtx
+}
+
+// Go calls fn in a new goroutine on its own virtual thread, recording an
+// AsyncSpawn event named name first. It blocks until fn can run when the
+// number of active goroutines has reached the limit set with SetLimit.
+func (g *Group) Go(name string, fn func(ctx context.Context) error) {
+	location := callerLocation(g.client)
+	ready := make(chan context.Context, 1)
+	g.group.Go(g.run(name, fn, ready))
+	g.spawn(name, location, ready)
+}
+
+// TryGo calls fn in a new goroutine like Go only if the number of active
+// goroutines is below the limit set with SetLimit, and reports whether it
+// did. Nothing is recorded when it does not.
+func (g *Group) TryGo(name string, fn func(ctx context.Context) error) bool {
+	location := callerLocation(g.client)
+	ready := make(chan context.Context, 1)
+	if !g.group.TryGo(g.run(name, fn, ready)) {
+		return false
+	}
+	g.spawn(name, location, ready)
+	return true
+}
+
+// SetLimit limits the number of active goroutines in the group to at most n,
+// as errgroup.Group.SetLimit does. A negative value means no limit.
+func (g *Group) SetLimit(n int) {
+	g.group.SetLimit(n)
+}
+
+// Wait blocks until every function started with Go or TryGo has returned,
+// records an AsyncJoin event for each, and returns the first non-nil error
+// any of them returned. Events captured with the parent context afterwards
+// are ordered after every event of the branches.
+func (g *Group) Wait() error {
+	err := g.group.Wait()
+	location := callerLocation(g.client)
+
+	g.mu.Lock()
+	branches := g.branches
+	g.branches = nil
+	g.mu.Unlock()
+	for _, b := range branches {
+		g.client.TrackAsyncJoinAt(g.parent, b.taskID, b.ctx, location)
+	}
+	return err
+}
+
+// run returns the errgroup function for fn. It waits for the branch context,
+// which spawn derives once the spawn event is recorded, so that fn's events
+// are ordered after it.
+func (g *Group) run(name string, fn func(ctx context.Context) error, ready <-chan context.Context) func() error {
+	return func() error {
+		ctx := <-ready
+		if err := fn(ctx); err != nil {
+			g.client.CaptureError(ctx, err, map[string]string{taskTag: name})
+			return err
+		}
+		return nil
+	}
+}
+
+// spawn records the AsyncSpawn event of the branch name and hands the
+// branch its context.
+func (g *Group) spawn(name, location string, ready chan<- context.Context) {
+	taskID := uuid.New().String()
+	g.client.TrackAsyncSpawn(g.parent, taskID, name, location)
+	ctx := raceway.DeriveThread(g.ctx)
+
+	g.mu.Lock()
+	g.branches = append(g.branches, branch{taskID: taskID, ctx: ctx})
+	g.mu.Unlock()
+	ready <- ctx
+}
+
+// callerLocation returns the location of the call to the Group method
+// calling it, as the client records locations.
+func callerLocation(client *raceway.Client) string {
+	_, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+	return client.TrimPath(file) + ":" + strconv.Itoa(line)
+}
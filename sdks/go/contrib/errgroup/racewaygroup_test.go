@@ -0,0 +1,150 @@
+//go:build !raceway_disabled
+
+package racewaygroup
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+func newTestGroup(t *testing.T) (*raceway.Recorder, context.Context) {
+	t.Helper()
+	rec := raceway.NewRecorder()
+	t.Cleanup(func() { rec.Shutdown() })
+	return rec, raceway.NewContext(context.Background(), "", "test", "test")
+}
+
+// dominates reports whether clock a is at or after b in every component.
+func dominates(a, b []raceway.CausalityEntry) bool {
+	values := make(map[string]uint64, len(a))
+	for _, entry := range a {
+		values[entry.Component()] = entry.Value()
+	}
+	for _, entry := range b {
+		if values[entry.Component()] < entry.Value() {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGroupRecordsFanOutAndFanIn(t *testing.T) {
+	rec, ctx := newTestGroup(t)
+	parentThread := raceway.FromContext(ctx).ThreadID
+
+	g, groupCtx := WithContext(ctx, rec.Client)
+	for _, account := range []string{"alice", "bob", "carol"} {
+		account := account
+		g.Go("load_"+account, func(ctx context.Context) error {
+			rec.TrackStateChange(ctx, "balance:"+account, nil, 100, "bank.go:1", "Read")
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if groupCtx.Err() == nil {
+		t.Errorf("expected the group context cancelled once Wait returns, as errgroup's is")
+	}
+	rec.TrackStateChange(ctx, "total", nil, 300, "bank.go:2", "Write")
+
+	spawns, joins := rec.EventsOfKind("AsyncSpawn"), rec.EventsOfKind("AsyncJoin")
+	if len(spawns) != 3 || len(joins) != 3 {
+		t.Fatalf("expected 3 spawns and 3 joins, got %d and %d", len(spawns), len(joins))
+	}
+	if !strings.HasPrefix(spawns[0].Kind.AsyncSpawn.SpawnedAt, "racewaygroup_test.go:") ||
+		!strings.HasPrefix(joins[0].Kind.AsyncJoin.JoinedAt, "racewaygroup_test.go:") {
+		t.Errorf("expected the caller's locations, got %s and %s", spawns[0].Kind.AsyncSpawn.SpawnedAt, joins[0].Kind.AsyncJoin.JoinedAt)
+	}
+
+	var branches []raceway.Event
+	threads := map[string]bool{}
+	for _, event := range rec.EventsOfKind("StateChange") {
+		if event.Kind.StateChange.Variable != "total" {
+			branches = append(branches, event)
+			threads[event.Metadata.ThreadID] = true
+		}
+	}
+	if len(threads) != 3 || threads[parentThread] {
+		t.Errorf("expected each branch on its own thread, got %v", threads)
+	}
+
+	total, _ := rec.Find(func(e raceway.Event) bool {
+		return e.Kind.StateChange != nil && e.Kind.StateChange.Variable == "total"
+	})
+	for _, branch := range branches {
+		if !dominates(total.CausalityVector, branch.CausalityVector) {
+			t.Errorf("expected the write after Wait %v to be ordered after the branch event %v", total.CausalityVector, branch.CausalityVector)
+		}
+		if !dominates(branch.CausalityVector, spawns[0].CausalityVector) {
+			t.Errorf("expected the branch event %v to be ordered after the first spawn %v", branch.CausalityVector, spawns[0].CausalityVector)
+		}
+	}
+}
+
+func TestGroupRecordsBranchErrors(t *testing.T) {
+	rec, ctx := newTestGroup(t)
+	errInsufficient := errors.New("insufficient funds")
+
+	g, _ := WithContext(ctx, rec.Client)
+	g.Go("debit", func(ctx context.Context) error { return errInsufficient })
+	g.Go("credit", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	if err := g.Wait(); err != errInsufficient {
+		t.Fatalf("expected the branch error from Wait, got %v", err)
+	}
+
+	errs := rec.EventsOfKind("Error")
+	if len(errs) != 1 || errs[0].Kind.Error.Message != "insufficient funds" || errs[0].Metadata.Tags[taskTag] != "debit" {
+		t.Fatalf("expected one Error event for the debit branch, got %+v", errs)
+	}
+	if errs[0].Metadata.ThreadID == raceway.FromContext(ctx).ThreadID {
+		t.Errorf("expected the error recorded on the branch's thread")
+	}
+}
+
+func TestGroupSetLimit(t *testing.T) {
+	rec, ctx := newTestGroup(t)
+	g, _ := WithContext(ctx, rec.Client)
+	g.SetLimit(1)
+
+	var active, peak atomic.Int32
+	release := make(chan struct{})
+	g.Go("first", func(ctx context.Context) error {
+		peak.Store(active.Add(1))
+		<-release
+		active.Add(-1)
+		return nil
+	})
+	if g.TryGo("rejected", func(ctx context.Context) error { return nil }) {
+		t.Errorf("expected TryGo to fail at the limit")
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		g.Go("next", func(ctx context.Context) error {
+			if n := active.Add(1); n > peak.Load() {
+				peak.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+			active.Add(-1)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if peak.Load() != 1 {
+		t.Errorf("expected at most one active branch, got %d", peak.Load())
+	}
+	if spawns := rec.EventsOfKind("AsyncSpawn"); len(spawns) != 4 {
+		t.Errorf("expected no spawn recorded for the rejected TryGo, got %d spawns", len(spawns))
+	}
+}
@@ -0,0 +1,94 @@
+// Package racewaygin records Gin requests as Raceway traces.
+//
+// It lives in its own module so that the core SDK does not depend on Gin:
+//
+//	router := gin.New()
+//	router.Use(racewaygin.Middleware(client))
+//	router.POST("/api/transfer", func(c *gin.Context) {
+//	    ctx := c.Request.Context()
+//	    client.TrackStateChange(ctx, "balance", old, new, "main.go:42", "Write")
+//	})
+//
+// Middleware behaves like raceway.Client.Middleware: it continues the trace in
+// the incoming headers, installs the Raceway context on c.Request, and
+// records the request and, once the handlers return, the response status,
+// body size, and duration. The matched route, such as "/accounts/:id", is
+// recorded in the http.route tag.
+package racewaygin
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// ContextKey is the key under which Middleware stores the request's
+// *raceway.RacewayContext in the gin.Context.
+const ContextKey = "raceway"
+
+// Middleware returns Gin middleware that traces each request with client.
+func Middleware(client *raceway.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := c.Writer
+		completed := false
+		client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.Request = r
+			c.Set(ContextKey, raceway.FromContext(r.Context()))
+			client.SetRoute(r.Context(), c.FullPath())
+			// Handlers write through the Raceway recorder, which writes to
+			// Gin's own writer
+			c.Writer = &responseWriter{ResponseWriter: writer, traced: w}
+			c.Next()
+			completed = true
+		})).ServeHTTP(writer, c.Request)
+		c.Writer = writer
+		if !completed {
+			// A handler panicked and Client.Middleware answered it, as
+			// Config.RecoverPanics asks; the remaining handlers must not run
+			c.Abort()
+		}
+	}
+}
+
+// FromContext returns the Raceway context Middleware stored in c, or nil.
+func FromContext(c *gin.Context) *raceway.RacewayContext {
+	rctx, _ := c.Value(ContextKey).(*raceway.RacewayContext)
+	return rctx
+}
+
+// responseWriter is a gin.ResponseWriter whose writes go through traced, so
+// the Raceway middleware observes the response.
+type responseWriter struct {
+	gin.ResponseWriter
+	traced http.ResponseWriter
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.traced.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	return w.traced.Write(data)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.traced.Write([]byte(s))
+}
+
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.traced.(http.Flusher); ok {
+		flusher.Flush()
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.traced.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return w.ResponseWriter.Hijack()
+}
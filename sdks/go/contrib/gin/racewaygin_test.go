@@ -0,0 +1,135 @@
+//go:build !raceway_disabled
+
+package racewaygin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+func newTestRouter(t *testing.T, configure func(*raceway.Config)) (*gin.Engine, *raceway.Recorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	config := raceway.Config{ServiceName: "racewaygin", InstanceID: "test", Region: "test"}
+	if configure != nil {
+		configure(&config)
+	}
+	recorder := raceway.NewRecorderWithConfig(config)
+	t.Cleanup(func() { recorder.Shutdown() })
+
+	router := gin.New()
+	router.Use(Middleware(recorder.Client))
+	return router, recorder
+}
+
+func TestMiddlewareTracesRequestAndResponse(t *testing.T) {
+	router, recorder := newTestRouter(t, nil)
+	router.POST("/api/transfer", func(c *gin.Context) {
+		if c.Request.Context() == nil || raceway.FromContext(c.Request.Context()) != FromContext(c) || FromContext(c) == nil {
+			t.Error("expected the Raceway context on the request and in the gin.Context")
+		}
+		recorder.TrackStateChange(c.Request.Context(), "balance", 100, 50, "racewaygin_test.go:1", "Write")
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	req := httptest.NewRequest("POST", "/api/transfer", nil)
+	req.Header.Set("traceparent", "00-550e8400e29b41d4a716446655440000-0123456789abcdef-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected the handler's status, got %d", rec.Code)
+	}
+
+	events := recorder.Events()
+	if len(events) != 3 {
+		t.Fatalf("expected request, state change and response, got %d events", len(events))
+	}
+	request, response := events[0].Kind.HTTPRequest, events[2].Kind.HTTPResponse
+	if request == nil || request.Method != "POST" || request.URL != "/api/transfer" {
+		t.Errorf("expected the request first, got %+v", events[0].Kind)
+	}
+	if response == nil || response.Status != http.StatusCreated {
+		t.Fatalf("expected a 201 response last, got %+v", events[2].Kind)
+	}
+	if events[2].Metadata.Tags["response_bytes"] != "11" {
+		t.Errorf("expected the body size recorded, got %v", events[2].Metadata.Tags)
+	}
+	for _, event := range events {
+		if event.TraceID != "550e8400-e29b-41d4-a716-446655440000" {
+			t.Errorf("expected the incoming trace to continue, got %s", event.TraceID)
+		}
+		if event.Metadata.Tags["http.route"] != "/api/transfer" {
+			t.Errorf("expected the route on %s, got %v", event.Kind.Name(), event.Metadata.Tags)
+		}
+	}
+}
+
+func TestMiddlewareRecordsAbortStatus(t *testing.T) {
+	router, recorder := newTestRouter(t, nil)
+	router.GET("/forbidden", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusForbidden)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/forbidden", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+	events := recorder.Events()
+	last := events[len(events)-1].Kind.HTTPResponse
+	if last == nil || last.Status != http.StatusForbidden {
+		t.Errorf("expected a 403 response recorded, got %+v", events[len(events)-1].Kind)
+	}
+}
+
+func TestMiddlewareStopsChainAfterRecoveredPanic(t *testing.T) {
+	router, recorder := newTestRouter(t, func(config *raceway.Config) { config.RecoverPanics = true })
+	ranAfter := false
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	}, func(c *gin.Context) {
+		ranAfter = true
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+	if ranAfter {
+		t.Error("expected the handlers after the panic to be skipped")
+	}
+	var panicked bool
+	for _, event := range recorder.Events() {
+		if event.Kind.Error != nil {
+			panicked = true
+		}
+	}
+	if !panicked {
+		t.Error("expected the panic recorded as an Error event")
+	}
+}
+
+func TestMiddlewareSkipsIgnoredPaths(t *testing.T) {
+	router, recorder := newTestRouter(t, func(cfg *raceway.Config) { cfg.IgnorePaths = []string{"/healthz"} })
+	router.GET("/healthz", func(c *gin.Context) {
+		if FromContext(c) != nil || raceway.FromContext(c.Request.Context()) != nil {
+			t.Error("expected no Raceway context for an ignored path")
+		}
+		recorder.TrackStateChange(c.Request.Context(), "probes", nil, 1, "racewaygin_test.go:9", "Write")
+		c.String(http.StatusOK, "ok")
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Fatalf("expected the handler's response, got %d %q", rec.Code, rec.Body.String())
+	}
+	if events := recorder.Events(); len(events) != 0 {
+		t.Errorf("expected no events for an ignored path, got %d", len(events))
+	}
+}
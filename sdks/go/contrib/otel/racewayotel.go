@@ -0,0 +1,40 @@
+// Package racewayotel connects Raceway to OpenTelemetry.
+//
+// It lives in its own module so that the core SDK does not depend on
+// OpenTelemetry. Importing it registers a bridge that, with
+// raceway.Config.OTelBridge set, keys new Raceway traces by the
+// OpenTelemetry span active in the request or StartTrace context, so both
+// tracers report the same trace ID:
+//
+//	import _ "github.com/mode7labs/raceway/sdks/go/contrib/otel"
+//
+//	client := raceway.New(raceway.Config{ServiceName: "api", OTelBridge: true})
+//	handler := otelhttp.NewHandler(client.Middleware(mux), "api")
+//
+// A trace context arriving in traceparent, B3, or raceway-clock headers
+// always wins, and it agrees with OpenTelemetry's for the same headers.
+//
+// Sink exports call and HTTP events as OpenTelemetry spans, so Raceway data
+// shows up in Jaeger alongside existing traces.
+package racewayotel
+
+import (
+	"context"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	raceway.RegisterOTelBridge(SpanSource)
+}
+
+// SpanSource returns the W3C trace ID and span ID of the OpenTelemetry span
+// in ctx. It is registered with raceway.RegisterOTelBridge on import.
+func SpanSource(ctx context.Context) (traceID, spanID string, ok bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", "", false
+	}
+	return sc.TraceID().String(), sc.SpanID().String(), true
+}
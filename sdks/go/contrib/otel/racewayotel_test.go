@@ -0,0 +1,245 @@
+//go:build !raceway_disabled
+
+package racewayotel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+	racewaypropagation "github.com/mode7labs/raceway/sdks/go/propagation"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestClient(t *testing.T, service string, configure func(*raceway.Config)) *raceway.Client {
+	t.Helper()
+	config := raceway.DefaultConfig()
+	config.ServiceName = service
+	config.InstanceID = "test"
+	config.Region = "test"
+	config.BatchSize = 10000
+	config.FlushInterval = time.Hour
+	config.OTelBridge = true
+	config.Sink = raceway.NoopSink{}
+	if configure != nil {
+		configure(&config)
+	}
+	client := raceway.New(config)
+	t.Cleanup(func() { client.Shutdown() })
+	return client
+}
+
+// otelTraceID returns the OpenTelemetry trace ID of ctx as a Raceway trace ID.
+func otelTraceID(t *testing.T, ctx context.Context) string {
+	t.Helper()
+	traceID, err := racewaypropagation.TraceIDToUUID(trace.SpanContextFromContext(ctx).TraceID().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return traceID
+}
+
+// TestTwoHopTraceIDAgreement calls service B from service A, each traced by
+// both OpenTelemetry and Raceway, and checks all four agree on the trace.
+func TestTwoHopTraceIDAgreement(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	propagator := propagation.TraceContext{}
+
+	for _, otelLast := range []bool{false, true} {
+		clientA := newTestClient(t, "service-a", nil)
+		clientB := newTestClient(t, "service-b", nil)
+
+		var mu sync.Mutex
+		var otelB, racewayB string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The OpenTelemetry server span, as otelhttp would start it,
+			// wraps the Raceway middleware
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tp.Tracer("service-b").Start(ctx, "GET /accounts")
+			defer span.End()
+			clientB.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				otelB = otelTraceID(t, r.Context())
+				racewayB = raceway.FromContext(r.Context()).TraceID
+			})).ServeHTTP(w, r.WithContext(ctx))
+		}))
+
+		ctx, span := tp.Tracer("service-a").Start(context.Background(), "nightly_sync")
+		ctx = clientA.StartTrace(ctx, "nightly_sync")
+		otelA := otelTraceID(t, ctx)
+		if racewayA := raceway.FromContext(ctx).TraceID; racewayA != otelA {
+			t.Fatalf("service A: Raceway trace %s, OpenTelemetry trace %s", racewayA, otelA)
+		}
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/accounts", nil)
+		headers, err := clientA.PropagationHeaders(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if otelLast {
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+		} else {
+			propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		span.End()
+		server.Close()
+
+		mu.Lock()
+		if otelB != otelA || racewayB != otelA {
+			t.Errorf("otelLast=%v: service B has OpenTelemetry trace %s and Raceway trace %s, want %s", otelLast, otelB, racewayB, otelA)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestMiddlewareAdoptsActiveSpanWithoutHeaders(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	client := newTestClient(t, "service", nil)
+
+	var racewayTrace string
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		racewayTrace = raceway.FromContext(r.Context()).TraceID
+	}))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "GET /")
+	defer span.End()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	if want := otelTraceID(t, ctx); racewayTrace != want {
+		t.Errorf("expected Raceway trace %s, got %s", want, racewayTrace)
+	}
+}
+
+func spanNamed(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("no span named %q", name)
+	return nil
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestSinkExportsNestedCallSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	client := newTestClient(t, "service", func(config *raceway.Config) {
+		config.Routes = []raceway.Route{{Name: "otel", Match: raceway.RouteMatch{Kinds: Kinds}, Sink: &Sink{TracerProvider: tp}, Mirror: true}}
+	})
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "job")
+	ctx = client.StartTrace(ctx, "nightly_sync")
+	var mu sync.Mutex
+	func() {
+		defer client.StartFunction(ctx, "transfer", nil)()
+		client.WithLock(ctx, &mu, "accounts", "Mutex", func() {
+			defer client.StartFunction(ctx, "debit", nil)()
+			client.TrackError(ctx, "InsufficientFunds", "balance too low", nil)
+		})
+	}()
+	span.End()
+	client.Flush()
+
+	spans := recorder.Ended()
+	transfer := spanNamed(t, spans, "transfer")
+	debit := spanNamed(t, spans, "debit")
+	if debit.Parent().SpanID() != transfer.SpanContext().SpanID() {
+		t.Error("expected debit nested in transfer")
+	}
+	if transfer.SpanContext().TraceID() != trace.SpanContextFromContext(ctx).TraceID() {
+		t.Errorf("expected spans in the OpenTelemetry trace, got %s", transfer.SpanContext().TraceID())
+	}
+	if debit.StartTime().Before(transfer.StartTime()) || debit.EndTime().After(transfer.EndTime()) {
+		t.Error("expected debit to run within transfer")
+	}
+	if locks, _ := attributeValue(debit, "raceway.lock_set"); len(locks.AsStringSlice()) != 1 || locks.AsStringSlice()[0] != "accounts" {
+		t.Errorf("expected the held lock as an attribute, got %v", locks.AsStringSlice())
+	}
+	if clock, _ := attributeValue(debit, "raceway.clock"); len(clock.AsStringSlice()) == 0 {
+		t.Error("expected the vector clock as an attribute")
+	}
+	if debit.Status().Code != codes.Error || len(debit.Events()) != 1 || debit.Events()[0].Name != "exception" {
+		t.Errorf("expected the error recorded on debit, got %+v %+v", debit.Status(), debit.Events())
+	}
+}
+
+func TestSinkExportsRequestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	client := newTestClient(t, "service", func(config *raceway.Config) {
+		config.Sink = &Sink{TracerProvider: tp}
+	})
+
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfers?dry_run=1", nil))
+	client.Flush()
+
+	request := spanNamed(t, recorder.Ended(), "POST /transfers")
+	if request.SpanKind() != trace.SpanKindServer || request.Status().Code != codes.Error {
+		t.Errorf("unexpected request span kind %s, status %+v", request.SpanKind(), request.Status())
+	}
+	if status, _ := attributeValue(request, "http.status_code"); status.AsInt64() != http.StatusServiceUnavailable {
+		t.Errorf("expected the response status as an attribute, got %v", status.Emit())
+	}
+}
+
+func TestSinkExportsReturnWithoutCall(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	durationNs := int64(time.Second)
+	end := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink := &Sink{TracerProvider: tp}
+	sink.Send(context.Background(), []raceway.Event{{
+		ID:        "5f0c2a4e-8d1b-4c3a-9e7f-1a2b3c4d5e6f",
+		TraceID:   "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+		Timestamp: end.Format(time.RFC3339Nano),
+		Kind:      raceway.EventKind{FunctionReturn: &raceway.FunctionReturnData{FunctionName: "transfer"}},
+		Metadata:  raceway.Metadata{ThreadID: "t1", DurationNs: &durationNs},
+	}})
+
+	span := spanNamed(t, recorder.Ended(), "transfer")
+	if !span.StartTime().Equal(end.Add(-time.Second)) || !span.EndTime().Equal(end) {
+		t.Errorf("expected the span to cover the return's duration, got %s to %s", span.StartTime(), span.EndTime())
+	}
+	if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the event's trace, got %s", span.SpanContext().TraceID())
+	}
+}
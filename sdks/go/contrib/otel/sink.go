@@ -0,0 +1,260 @@
+package racewayotel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+	"github.com/mode7labs/raceway/sdks/go/propagation"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by Sink.
+const tracerName = "github.com/mode7labs/raceway/sdks/go/contrib/otel"
+
+// maxOpenSpans bounds the calls and requests awaiting their return or
+// response; the least recently active thread's spans are discarded beyond it.
+const maxOpenSpans = 10000
+
+// Sink is a raceway.EventSink that exports events as OpenTelemetry spans. A
+// FunctionCall and its FunctionReturn, or an HttpRequest and its
+// HttpResponse, on the same Raceway thread become one span, nested in the
+// call open when it started. Error events are recorded on the innermost open
+// span. The event's vector clock, lock set, and tags are recorded as span
+// attributes.
+//
+// Spans are created in the event's trace, under the span the service's
+// Raceway context continues, so with raceway.Config.OTelBridge they appear
+// within the OpenTelemetry trace of the request. Use it as a mirroring route
+// to keep the events flowing to the Raceway server as well:
+//
+//	config.Routes = []raceway.Route{{
+//	    Name:   "otel",
+//	    Match:  raceway.RouteMatch{Kinds: racewayotel.Kinds},
+//	    Sink:   &racewayotel.Sink{},
+//	    Mirror: true,
+//	}}
+type Sink struct {
+	// TracerProvider creates the spans (default: otel.GetTracerProvider())
+	TracerProvider trace.TracerProvider
+
+	mu      sync.Mutex
+	tracer  trace.Tracer
+	threads map[string]*threadSpans
+	open    int
+	sends   uint64
+}
+
+// Kinds lists the event kinds Sink exports.
+var Kinds = []string{"FunctionCall", "FunctionReturn", "HttpRequest", "HttpResponse", "Error"}
+
+// threadSpans holds the open spans of one Raceway thread, innermost last.
+type threadSpans struct {
+	stack []openSpan
+	// active is the Send in which the thread last had an event
+	active uint64
+}
+
+type openSpan struct {
+	ctx  context.Context
+	span trace.Span
+	// function is the called function, or "" for an HTTP request
+	function string
+}
+
+// Send converts events into spans. Spans are ended as their returns and
+// responses arrive, possibly in a later Send, and exported by the
+// TracerProvider's span processors.
+func (s *Sink) Send(ctx context.Context, events []raceway.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tracer == nil {
+		provider := s.TracerProvider
+		if provider == nil {
+			provider = otel.GetTracerProvider()
+		}
+		s.tracer = provider.Tracer(tracerName)
+		s.threads = make(map[string]*threadSpans)
+	}
+	s.sends++
+
+	for i := range events {
+		event := &events[i]
+		key := event.TraceID + "/" + event.Metadata.ThreadID
+		thread := s.threads[key]
+		if thread == nil {
+			thread = &threadSpans{}
+			s.threads[key] = thread
+		}
+		thread.active = s.sends
+
+		kind := event.Kind
+		switch {
+		case kind.FunctionCall != nil:
+			s.start(thread, event, eventTime(event), kind.FunctionCall.FunctionName, kind.FunctionCall.FunctionName, trace.SpanKindInternal,
+				attribute.String("code.function", kind.FunctionCall.FunctionName),
+				attribute.String("code.namespace", kind.FunctionCall.Module),
+				attribute.String("code.filepath", kind.FunctionCall.File),
+				attribute.Int("code.lineno", kind.FunctionCall.Line))
+		case kind.HTTPRequest != nil:
+			s.start(thread, event, eventTime(event), "", requestSpanName(kind.HTTPRequest), trace.SpanKindServer,
+				attribute.String("http.method", kind.HTTPRequest.Method),
+				attribute.String("http.url", kind.HTTPRequest.URL))
+		case kind.FunctionReturn != nil:
+			s.end(thread, event, kind.FunctionReturn.FunctionName)
+		case kind.HTTPResponse != nil:
+			s.end(thread, event, "")
+		case kind.Error != nil:
+			if n := len(thread.stack); n > 0 {
+				span := thread.stack[n-1].span
+				span.AddEvent("exception", trace.WithTimestamp(eventTime(event)), trace.WithAttributes(
+					attribute.String("exception.type", kind.Error.ErrorType),
+					attribute.String("exception.message", kind.Error.Message),
+					attribute.StringSlice("exception.stacktrace", kind.Error.StackTrace),
+				))
+				span.SetStatus(codes.Error, kind.Error.Message)
+			}
+		}
+		if len(thread.stack) == 0 {
+			delete(s.threads, key)
+		}
+	}
+	s.evict()
+	return nil
+}
+
+// start opens a span for event at the given time, nested in the thread's
+// innermost open span or else in the span the event's Raceway context
+// continues.
+func (s *Sink) start(thread *threadSpans, event *raceway.Event, at time.Time, function, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) {
+	parent := remoteParent(event)
+	if n := len(thread.stack); n > 0 {
+		parent = thread.stack[n-1].ctx
+	}
+	ctx, span := s.tracer.Start(parent, name,
+		trace.WithTimestamp(at),
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(append(attrs, eventAttributes(event)...)...))
+	thread.stack = append(thread.stack, openSpan{ctx: ctx, span: span, function: function})
+	s.open++
+}
+
+// end ends the innermost open span for function, or for an HTTP request if
+// function is "", along with any spans opened inside it and never closed.
+// A FunctionReturn whose call was not seen becomes a span of its own when
+// it carries a duration.
+func (s *Sink) end(thread *threadSpans, event *raceway.Event, function string) {
+	at := eventTime(event)
+	for i := len(thread.stack) - 1; i >= 0; i-- {
+		if thread.stack[i].function != function {
+			continue
+		}
+		if response := event.Kind.HTTPResponse; response != nil {
+			span := thread.stack[i].span
+			span.SetAttributes(attribute.Int("http.status_code", response.Status))
+			if response.Status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(response.Status))
+			}
+		}
+		for j := len(thread.stack) - 1; j >= i; j-- {
+			thread.stack[j].span.End(trace.WithTimestamp(at))
+		}
+		s.open -= len(thread.stack) - i
+		thread.stack = thread.stack[:i]
+		return
+	}
+
+	if event.Kind.FunctionReturn == nil || event.Metadata.DurationNs == nil {
+		return
+	}
+	s.start(thread, event, at.Add(-time.Duration(*event.Metadata.DurationNs)), function, function, trace.SpanKindInternal,
+		attribute.String("code.function", function),
+		attribute.String("code.filepath", event.Kind.FunctionReturn.File),
+		attribute.Int("code.lineno", event.Kind.FunctionReturn.Line))
+	open := thread.stack[len(thread.stack)-1]
+	thread.stack = thread.stack[:len(thread.stack)-1]
+	s.open--
+	open.span.End(trace.WithTimestamp(at))
+}
+
+// evict discards the open spans of the least recently active threads beyond
+// maxOpenSpans, such as calls whose returns were never recorded. Discarded
+// spans are never ended and so never exported.
+func (s *Sink) evict() {
+	for s.open > maxOpenSpans {
+		var oldestKey string
+		var oldest *threadSpans
+		for key, thread := range s.threads {
+			if oldest == nil || thread.active < oldest.active {
+				oldestKey, oldest = key, thread
+			}
+		}
+		s.open -= len(oldest.stack)
+		delete(s.threads, oldestKey)
+	}
+}
+
+// remoteParent returns a context carrying the span the event's Raceway
+// context continues, in the event's trace.
+func remoteParent(event *raceway.Event) context.Context {
+	traceID, _ := trace.TraceIDFromHex(propagation.Normalize(event.TraceID))
+	var spanID trace.SpanID
+	if event.Metadata.DistributedSpanID != nil {
+		spanID, _ = trace.SpanIDFromHex(*event.Metadata.DistributedSpanID)
+	}
+	if id := strings.ReplaceAll(event.ID, "-", ""); !spanID.IsValid() && len(id) >= 16 {
+		// Events always carry a span ID; derive one from the event ID if not
+		spanID, _ = trace.SpanIDFromHex(id[:16])
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	return trace.ContextWithRemoteSpanContext(context.Background(), sc)
+}
+
+// eventAttributes returns the Raceway metadata of event as span attributes.
+func eventAttributes(event *raceway.Event) []attribute.KeyValue {
+	clock := make([]string, len(event.CausalityVector))
+	for i, entry := range event.CausalityVector {
+		clock[i] = fmt.Sprintf("%s=%d", entry.Component(), entry.Value())
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("raceway.event_id", event.ID),
+		attribute.String("raceway.trace_id", event.TraceID),
+		attribute.String("raceway.thread_id", event.Metadata.ThreadID),
+		attribute.String("raceway.service", event.Metadata.ServiceName),
+		attribute.StringSlice("raceway.clock", clock),
+		attribute.StringSlice("raceway.lock_set", event.LockSet),
+	}
+	for k, v := range event.Metadata.Tags {
+		attrs = append(attrs, attribute.String("raceway.tag."+k, v))
+	}
+	return attrs
+}
+
+func requestSpanName(request *raceway.HTTPRequestData) string {
+	path := request.URL
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	return request.Method + " " + path
+}
+
+// eventTime parses the event's timestamp, falling back to the current time.
+func eventTime(event *raceway.Event) time.Time {
+	at, err := time.Parse(time.RFC3339Nano, event.Timestamp)
+	if err != nil {
+		return time.Now()
+	}
+	return at
+}
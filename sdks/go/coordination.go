@@ -0,0 +1,173 @@
+package raceway
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// TrackedWaitGroup is a sync.WaitGroup that records the happens-before edges
+// it creates: Add records an AsyncSpawn per added goroutine, Done records the
+// goroutine's completion, and Wait merges the clocks of every completed
+// goroutine into the context the group was created with and records an
+// AsyncJoin. Without these edges, accesses made by the waiting goroutine after
+// Wait look concurrent with the goroutines' accesses.
+//
+// Add and Wait must be called from the goroutine that owns the group's
+// context. Each goroutine calls Done with its own context:
+//
+//	wg := client.TrackedWaitGroup(ctx)
+//	for _, item := range items {
+//	    wg.Go("process_item", func(ctx context.Context) {
+//	        process(ctx, item)
+//	    })
+//	}
+//	wg.Wait()
+type TrackedWaitGroup struct {
+	client *Client
+	ctx    context.Context
+	id     string
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// clock and lamport merge the final clocks of every goroutine that called Done
+	clock   []CausalityEntry
+	lamport int
+}
+
+// TrackedWaitGroup returns a TrackedWaitGroup whose Add and Wait events are
+// recorded with ctx.
+func (c *Client) TrackedWaitGroup(ctx context.Context) *TrackedWaitGroup {
+	return &TrackedWaitGroup{client: c, ctx: ctx, id: uuid.New().String()}
+}
+
+// Add adds delta to the counter like sync.WaitGroup.Add, recording an
+// AsyncSpawn for each goroutine added. A goroutine started after Add should
+// run with a context derived from the group's, e.g.
+// raceway.DeriveThread(ctx), and pass it to Done.
+func (w *TrackedWaitGroup) Add(delta int) {
+	location := w.client.captureLocation(2)
+	for i := 0; i < delta; i++ {
+		w.client.captureEvent(w.ctx, EventKind{
+			AsyncSpawn: &AsyncSpawnData{
+				TaskID:    uuid.New().String(),
+				TaskName:  "sync.WaitGroup",
+				SpawnedAt: location,
+			},
+		})
+	}
+	w.wg.Add(delta)
+}
+
+// Done records the completion of the goroutine running with ctx and
+// decrements the counter. Its clock is merged into the group's context by
+// the next Wait.
+func (w *TrackedWaitGroup) Done(ctx context.Context) {
+	if rctx := FromContext(ctx); rctx != nil {
+		w.client.TrackCustom(ctx, "waitgroup_done", map[string]interface{}{"waitgroup": w.id})
+		vector, lamport := rctx.clock()
+		w.mu.Lock()
+		w.clock = MergeClockVectors(w.clock, vector)
+		if lamport > w.lamport {
+			w.lamport = lamport
+		}
+		w.mu.Unlock()
+	}
+	w.wg.Done()
+}
+
+// Go runs fn in a new goroutine like Client.Go and calls Done with its
+// context when fn returns.
+func (w *TrackedWaitGroup) Go(taskName string, fn func(context.Context)) {
+	w.wg.Add(1)
+	w.client.spawn(w.ctx, taskName, InheritShared, func(ctx context.Context) {
+		defer w.Done(ctx)
+		fn(ctx)
+	}, w.client.captureLocation(2))
+}
+
+// Wait blocks until the counter is zero, then merges the clocks of the
+// completed goroutines into the group's context and records an AsyncJoin on
+// the group's ID.
+func (w *TrackedWaitGroup) Wait() {
+	w.wg.Wait()
+	if FromContext(w.ctx) == nil {
+		return
+	}
+	w.mu.Lock()
+	clock, lamport := MergeClockVectors(w.clock, nil), w.lamport
+	w.mu.Unlock()
+	w.client.trackAsyncJoin(w.ctx, w.id, w.client.captureLocation(2), clock, lamport)
+}
+
+// TrackedChan is a channel that carries the sender's clock with each value.
+// Recv merges that clock into the receiver's context and records an
+// AsyncAwait, so the receiver's later events are ordered after everything
+// the sender did before sending.
+//
+// Example:
+//
+//	results := raceway.NewTrackedChan[Result](client, "results", 10)
+//	client.Go(ctx, "fetch", func(ctx context.Context) {
+//	    results.Send(ctx, fetch(ctx))
+//	})
+//	result, ok := results.Recv(ctx)
+type TrackedChan[T any] struct {
+	client *Client
+	name   string
+	ch     chan trackedMessage[T]
+}
+
+type trackedMessage[T any] struct {
+	value   T
+	id      string
+	clock   []CausalityEntry
+	lamport int
+}
+
+// NewTrackedChan creates a TrackedChan named name with the given buffer size.
+func NewTrackedChan[T any](client *Client, name string, size int) *TrackedChan[T] {
+	return &TrackedChan[T]{client: client, name: name, ch: make(chan trackedMessage[T], size)}
+}
+
+// Send records a "chan_send" Custom event and sends value with the sender's
+// clock. It blocks like a channel send.
+func (t *TrackedChan[T]) Send(ctx context.Context, value T) {
+	msg := trackedMessage[T]{value: value, id: uuid.New().String()}
+	if rctx := FromContext(ctx); rctx != nil && t.client != nil {
+		t.client.TrackCustom(ctx, "chan_send", map[string]interface{}{"channel": t.name, "message_id": msg.id})
+		msg.clock, msg.lamport = rctx.clock()
+	}
+	t.ch <- msg
+}
+
+// Recv receives a value like a channel receive; ok is false once the channel
+// is closed and drained. The sender's clock is merged into ctx and an
+// AsyncAwait on the message ID is recorded.
+func (t *TrackedChan[T]) Recv(ctx context.Context) (value T, ok bool) {
+	msg, ok := <-t.ch
+	if !ok {
+		return value, false
+	}
+	if rctx := FromContext(ctx); rctx != nil && t.client != nil {
+		rctx.joinClock(msg.clock, msg.lamport)
+		t.client.captureEvent(ctx, EventKind{
+			AsyncAwait: &AsyncAwaitData{
+				FutureID:  msg.id,
+				AwaitedAt: t.client.captureLocation(2),
+			},
+		})
+	}
+	return msg.value, true
+}
+
+// Close closes the channel. Receivers drain the remaining values first.
+func (t *TrackedChan[T]) Close() {
+	close(t.ch)
+}
+
+// Len returns the number of values buffered in the channel.
+func (t *TrackedChan[T]) Len() int {
+	return len(t.ch)
+}
@@ -0,0 +1,165 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestTrackedWaitGroupOrdersWaiterAfterGoroutines(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	wg := c.TrackedWaitGroup(ctx)
+	for i := 0; i < 4; i++ {
+		i := i
+		wg.Go("process_item", func(ctx context.Context) {
+			c.TrackStateChange(ctx, "items", nil, i, "coordination_test.go:1", "Write")
+		})
+	}
+	wg.Wait()
+	c.TrackStateChange(ctx, "items", nil, 4, "coordination_test.go:2", "Read")
+
+	events := bufferedEvents(c)
+	var writes, spawns, dones int
+	var join *AsyncJoinData
+	read := events[len(events)-1]
+	for _, event := range events {
+		switch {
+		case event.Kind.AsyncSpawn != nil:
+			spawns++
+		case event.Kind.Custom != nil && event.Kind.Custom.Type == "waitgroup_done":
+			dones++
+		case event.Kind.AsyncJoin != nil:
+			join = event.Kind.AsyncJoin
+		case event.Kind.StateChange != nil && event.Kind.StateChange.AccessType == "Write":
+			writes++
+			if !dominates(read.CausalityVector, event.CausalityVector) || read.Metadata.ThreadID == event.Metadata.ThreadID {
+				t.Errorf("expected the read %v to be ordered after the write %v", read.CausalityVector, event.CausalityVector)
+			}
+			if join != nil {
+				t.Errorf("expected the join after every write")
+			}
+		}
+	}
+	if writes != 4 || spawns != 4 || dones != 4 {
+		t.Errorf("expected 4 writes, spawns and completions, got %d, %d and %d", writes, spawns, dones)
+	}
+	if join == nil || join.TaskID != wg.id || len(join.ChildClock) == 0 {
+		t.Fatalf("expected an AsyncJoin on the wait group, got %+v", join)
+	}
+}
+
+func TestTrackedWaitGroupAddRecordsSpawns(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	wg := c.TrackedWaitGroup(ctx)
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func(ctx context.Context) {
+			defer wg.Done(ctx)
+			c.TrackStateChange(ctx, "counter", nil, 1, "coordination_test.go:3", "Write")
+		}(InheritShared.Apply(ctx))
+	}
+	wg.Wait()
+
+	events := bufferedEvents(c)
+	spawns := 0
+	for _, event := range events {
+		if spawn := event.Kind.AsyncSpawn; spawn != nil {
+			spawns++
+			if spawn.TaskName != "sync.WaitGroup" || spawn.SpawnedAt == "" {
+				t.Errorf("unexpected spawn %+v", spawn)
+			}
+		}
+	}
+	if spawns != 2 {
+		t.Errorf("expected one AsyncSpawn per added goroutine, got %d", spawns)
+	}
+	if last := events[len(events)-1]; last.Kind.AsyncJoin == nil {
+		t.Errorf("expected Wait to record an AsyncJoin, got %s", last.Kind.Name())
+	}
+}
+
+func TestTrackedChanCarriesSenderClock(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	results := NewTrackedChan[int](c, "results", 0)
+
+	c.Go(ctx, "producer", func(ctx context.Context) {
+		for i := 1; i <= 3; i++ {
+			c.TrackStateChange(ctx, "result", nil, i, "coordination_test.go:4", "Write")
+			results.Send(ctx, i)
+		}
+		results.Close()
+	})
+	sum := 0
+	for {
+		v, ok := results.Recv(ctx)
+		if !ok {
+			break
+		}
+		sum += v
+	}
+	if sum != 6 {
+		t.Fatalf("expected every value delivered, got sum %d", sum)
+	}
+
+	sends := make(map[string]Event)
+	awaits := 0
+	for _, event := range bufferedEvents(c) {
+		if custom := event.Kind.Custom; custom != nil && custom.Type == "chan_send" {
+			var id string
+			data, _ := json.Marshal(custom.Payload["message_id"])
+			json.Unmarshal(data, &id)
+			sends[id] = event
+		}
+		if await := event.Kind.AsyncAwait; await != nil {
+			awaits++
+			send, ok := sends[await.FutureID]
+			if !ok {
+				t.Fatalf("expected the await to follow the send of message %s", await.FutureID)
+			}
+			if !dominates(event.CausalityVector, send.CausalityVector) || event.Metadata.ThreadID == send.Metadata.ThreadID {
+				t.Errorf("expected the receive %v to be ordered after the send %v", event.CausalityVector, send.CausalityVector)
+			}
+		}
+	}
+	if len(sends) != 3 || awaits != 3 {
+		t.Errorf("expected 3 sends and receives, got %d and %d", len(sends), awaits)
+	}
+}
+
+func TestTrackedChanConcurrentSenders(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	ch := NewTrackedChan[string](c, "jobs", 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		c.Go(ctx, "sender", func(ctx context.Context) {
+			defer wg.Done()
+			ch.Send(ctx, "job")
+		})
+	}
+	go func() {
+		wg.Wait()
+		ch.Close()
+	}()
+
+	received := 0
+	for {
+		if _, ok := ch.Recv(ctx); !ok {
+			break
+		}
+		received++
+	}
+	if received != 8 || ch.Len() != 0 {
+		t.Errorf("expected 8 values received, got %d", received)
+	}
+}
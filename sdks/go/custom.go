@@ -0,0 +1,92 @@
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// truncatedTag marks Custom events whose payload exceeded
+// Config.MaxCustomPayloadBytes.
+const truncatedTag = "truncated"
+
+// TrackCustom records an application-defined event, such as
+// "cache_invalidation" or "feature_flag_read", that no other event kind
+// describes. It is chained and ordered like any other event and returns the
+// event ID.
+//
+// The payload is serialized when TrackCustom is called. If it exceeds
+// Config.MaxCustomPayloadBytes, entries are kept in key order while they fit,
+// the rest are dropped, and the event is tagged truncated=true.
+func (c *Client) TrackCustom(ctx context.Context, eventType string, payload map[string]interface{}) string {
+	if BuildDisabled || c.disabled {
+		return ""
+	}
+	snapshot, truncated := c.customPayload(eventType, payload)
+	var opts captureOptions
+	if truncated {
+		opts.tags = map[string]string{truncatedTag: "true"}
+	}
+	return c.captureEventWith(ctx, EventKind{
+		Custom: &CustomData{
+			Type:    eventType,
+			Payload: snapshot,
+		},
+	}, opts)
+}
+
+// customPayload snapshots payload entry by entry, within the configured size
+// limit. It reports whether any entry was dropped.
+func (c *Client) customPayload(eventType string, payload map[string]interface{}) (map[string]interface{}, bool) {
+	limit := c.config.MaxCustomPayloadBytes
+	if limit <= 0 {
+		limit = DefaultMaxCustomPayloadBytes
+	}
+
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	snapshot := make(map[string]interface{}, len(payload))
+	size := len("{}")
+	truncated := false
+	for _, k := range keys {
+		data, err := json.Marshal(payload[k])
+		if err != nil {
+			if c.config.Strict {
+				c.strictViolation(StrictSerialization, "%s payload %q of type %T cannot be serialized: %v", eventType, k, payload[k], err)
+			}
+			data, _ = json.Marshal(fmt.Sprintf("[unserializable %T]", payload[k]))
+		}
+		key, _ := json.Marshal(k)
+		// Entries after the first are separated by a comma
+		entry := len(key) + 1 + len(data)
+		if len(snapshot) > 0 {
+			entry++
+		}
+		if size+entry > limit {
+			truncated = true
+			continue
+		}
+		size += entry
+		snapshot[k] = json.RawMessage(data)
+	}
+	return snapshot, truncated
+}
+
+// downgradeCustom re-encodes a Custom event as a FunctionCall for collectors
+// that do not understand the Custom kind.
+func downgradeCustom(event Event) Event {
+	data := event.Kind.Custom
+	event.Kind = EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: data.Type,
+			Module:       "raceway.custom",
+			Args:         data.Payload,
+		},
+	}
+	return event
+}
@@ -0,0 +1,119 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTrackCustomRoundTripsThroughJSON(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackCustom(ctx, "feature_flag_read", map[string]interface{}{
+		"flag":    "new_checkout",
+		"enabled": true,
+		"rollout": map[string]interface{}{"percent": 25},
+	})
+
+	data, err := json.Marshal(bufferedEvents(c)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire struct {
+		Kind map[string]json.RawMessage `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"feature_flag_read","payload":{"enabled":true,"flag":"new_checkout","rollout":{"percent":25}}}`
+	if got := string(wire.Kind["Custom"]); got != want {
+		t.Errorf("expected kind %s, got %s", want, got)
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	custom := decoded.Kind.Custom
+	if decoded.Kind.Name() != "Custom" || custom == nil || custom.Type != "feature_flag_read" {
+		t.Fatalf("expected a Custom event after decoding, got %+v", decoded.Kind)
+	}
+	if custom.Payload["flag"] != "new_checkout" || custom.Payload["enabled"] != true {
+		t.Errorf("unexpected decoded payload %v", custom.Payload)
+	}
+}
+
+func TestTrackCustomChainsLikeOtherEvents(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "cache.user:1", nil, "v1", "custom_test.go:1", "Write")
+	id := c.TrackCustom(ctx, "cache_invalidation", map[string]interface{}{"key": "user:1"})
+	c.TrackStateChange(ctx, "cache.user:1", nil, "v2", "custom_test.go:2", "Write")
+
+	events := bufferedEvents(c)
+	if len(events) != 3 || events[1].ID != id {
+		t.Fatalf("expected the custom event between the writes, got %d events", len(events))
+	}
+	if events[1].ParentID == nil || *events[1].ParentID != events[0].ID {
+		t.Errorf("expected the custom event to be parented to the previous event")
+	}
+	if events[2].ParentID == nil || *events[2].ParentID != id {
+		t.Errorf("expected the next event to be parented to the custom event")
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].CausalityVector[0].Value() <= events[i-1].CausalityVector[0].Value() {
+			t.Errorf("expected event %d to advance the clock", i)
+		}
+	}
+}
+
+func TestTrackCustomTruncatesOversizedPayload(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.MaxCustomPayloadBytes = 64 })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	payload := map[string]interface{}{
+		"a_key":  "kept",
+		"b_blob": strings.Repeat("x", 100),
+		"c_key":  "also kept",
+	}
+	c.TrackCustom(ctx, "cache_invalidation", payload)
+	c.TrackCustom(ctx, "cache_invalidation", map[string]interface{}{"key": "small"})
+
+	events := bufferedEvents(c)
+	custom := events[0].Kind.Custom
+	if _, ok := custom.Payload["b_blob"]; ok || len(custom.Payload) != 2 {
+		t.Errorf("expected only the entries that fit, got %v", custom.Payload)
+	}
+	if events[0].Metadata.Tags[truncatedTag] != "true" {
+		t.Errorf("expected truncated=true, got %v", events[0].Metadata.Tags)
+	}
+	data, _ := json.Marshal(custom.Payload)
+	if len(data) > 64 {
+		t.Errorf("expected the payload within 64 bytes, got %d", len(data))
+	}
+	if _, ok := events[1].Metadata.Tags[truncatedTag]; ok {
+		t.Errorf("expected no truncated tag on a payload within the limit")
+	}
+	if len(payload) != 3 {
+		t.Errorf("expected the caller's payload to be untouched, got %v", payload)
+	}
+}
+
+func TestCustomDowngradedWithoutCapability(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ForceCapabilities = nil })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackCustom(ctx, "cache_invalidation", map[string]interface{}{"key": "user:1"})
+	events := bufferedEvents(c)
+	c.downgradeEvents(events)
+
+	call := events[0].Kind.FunctionCall
+	if events[0].Kind.Custom != nil || call == nil || call.FunctionName != "cache_invalidation" || call.Module != "raceway.custom" {
+		t.Errorf("expected a FunctionCall carrying the custom event, got %+v", events[0].Kind)
+	}
+}
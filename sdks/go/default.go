@@ -0,0 +1,204 @@
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultClient is the Client used by the package-level tracking functions.
+var defaultClient atomic.Pointer[Client]
+
+// SetDefault makes client the Client used by the package-level tracking
+// functions, such as TrackStateChange and Middleware, replacing any previous
+// one. A nil client makes them no-ops again. It is safe to call concurrently
+// with them, so tests can swap in a client of their own:
+//
+//	raceway.SetDefault(raceway.New(config))
+//	defer raceway.SetDefault(nil)
+//
+// Config.SetAsDefault registers a client as it is created.
+func SetDefault(client *Client) {
+	defaultClient.Store(client)
+}
+
+// Default returns the Client set by SetDefault, or nil if there is none.
+func Default() *Client {
+	return defaultClient.Load()
+}
+
+// TrackStateChange is Client.TrackStateChange with the default client. It
+// does nothing without one.
+func TrackStateChange(ctx context.Context, variable string, oldValue, newValue interface{}, location, accessType string) {
+	c := Default()
+	if c == nil {
+		return
+	}
+	if location == "" {
+		location = c.captureLocation(2)
+	}
+	c.TrackStateChange(ctx, variable, oldValue, newValue, location, accessType)
+}
+
+// TrackFunctionCall is Client.TrackFunctionCall with the default client. It
+// does nothing without one.
+func TrackFunctionCall(ctx context.Context, functionName, module string, args interface{}, file string, line int) {
+	c := Default()
+	if c == nil {
+		return
+	}
+	file, line = c.callerFileLine(file, line)
+	c.TrackFunctionCall(ctx, functionName, module, args, file, line)
+}
+
+// TrackFunctionReturn is Client.TrackFunctionReturn with the default client.
+// It does nothing without one.
+func TrackFunctionReturn(ctx context.Context, functionName string, returnValue interface{}, file string, line int) {
+	c := Default()
+	if c == nil {
+		return
+	}
+	file, line = c.callerFileLine(file, line)
+	c.TrackFunctionReturn(ctx, functionName, returnValue, file, line)
+}
+
+// StartFunction is Client.StartFunction with the default client. Without one
+// it records nothing and returns a function that does nothing.
+//
+//	defer raceway.StartFunction(ctx, "transfer", nil)()
+func StartFunction(ctx context.Context, functionName string, args interface{}) func() {
+	c := Default()
+	if c == nil {
+		return func() {}
+	}
+	file, line := c.captureFileLine(2)
+	return c.startFunction(ctx, functionName, args, file, line)
+}
+
+// TrackFunction is Client.TrackFunction with the default client. Without one
+// it only calls fn.
+func TrackFunction(ctx context.Context, functionName string, args interface{}, fn func() interface{}) interface{} {
+	c := Default()
+	if c == nil {
+		return fn()
+	}
+	file, line := c.captureFileLine(2)
+	return c.trackFunction(ctx, functionName, args, fn, file, line)
+}
+
+// TrackLockAcquire is Client.TrackLockAcquire with the default client. It
+// does nothing without one.
+func TrackLockAcquire(ctx context.Context, lockID, lockType string) {
+	if c := Default(); c != nil {
+		c.trackLockAcquire(ctx, lockID, lockType, c.captureLocation(2))
+	}
+}
+
+// TrackLockRelease is Client.TrackLockRelease with the default client. It
+// does nothing without one.
+func TrackLockRelease(ctx context.Context, lockID, lockType string) {
+	if c := Default(); c != nil {
+		c.trackLockRelease(ctx, lockID, lockType, c.captureLocation(2))
+	}
+}
+
+// WithLock is Client.WithLock with the default client. Without one it only
+// calls fn with lock held.
+func WithLock(ctx context.Context, lock sync.Locker, lockID, lockType string, fn func()) {
+	c := Default()
+	if c == nil {
+		lock.Lock()
+		defer lock.Unlock()
+		fn()
+		return
+	}
+	c.withLock(ctx, lock, lockID, lockType, fn, c.captureLocation(2))
+}
+
+// WithRWLockRead is Client.WithRWLockRead with the default client. Without
+// one it only calls fn with lock held for reading.
+func WithRWLockRead(ctx context.Context, lock *sync.RWMutex, lockID string, fn func()) {
+	c := Default()
+	if c == nil {
+		lock.RLock()
+		defer lock.RUnlock()
+		fn()
+		return
+	}
+	c.withRWLockRead(ctx, lock, lockID, fn, c.captureLocation(2))
+}
+
+// WithRWLockWrite is Client.WithRWLockWrite with the default client. Without
+// one it only calls fn with lock held for writing.
+func WithRWLockWrite(ctx context.Context, lock *sync.RWMutex, lockID string, fn func()) {
+	c := Default()
+	if c == nil {
+		lock.Lock()
+		defer lock.Unlock()
+		fn()
+		return
+	}
+	c.withLock(ctx, lock, lockID, "RWLock-Write", fn, c.captureLocation(2))
+}
+
+// TrackError is Client.TrackError with the default client. It does nothing
+// without one.
+func TrackError(ctx context.Context, errorType, message string, stackTrace []string) {
+	if c := Default(); c != nil {
+		c.TrackError(ctx, errorType, message, stackTrace)
+	}
+}
+
+// CaptureError is Client.CaptureError with the default client. It does
+// nothing without one.
+func CaptureError(ctx context.Context, err error, tags map[string]string) {
+	if c := Default(); c != nil {
+		c.captureError(ctx, err, tags, 3)
+	}
+}
+
+// TrackCustom is Client.TrackCustom with the default client. Without one it
+// records nothing and returns "".
+func TrackCustom(ctx context.Context, eventType string, payload map[string]interface{}) string {
+	if c := Default(); c != nil {
+		return c.TrackCustom(ctx, eventType, payload)
+	}
+	return ""
+}
+
+// Go is Client.Go with the default client. Without one it only runs fn in a
+// new goroutine with ctx, and returns "".
+func Go(ctx context.Context, taskName string, fn func(context.Context)) string {
+	c := Default()
+	if c == nil {
+		go fn(ctx)
+		return ""
+	}
+	return c.spawn(ctx, taskName, InheritShared, fn, c.captureLocation(2))
+}
+
+// StartTrace is Client.StartTrace with the default client. Without one it
+// returns ctx unchanged.
+func StartTrace(ctx context.Context, name string) context.Context {
+	c := Default()
+	if c == nil {
+		return ctx
+	}
+	file, line := c.captureFileLine(2)
+	return c.startTrace(ctx, name, file, line)
+}
+
+// Middleware is Client.Middleware with the default client, which is looked up
+// for each request, so it may be set after the handler is built. Requests
+// served without one are passed to next untraced.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := Default()
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.Middleware(next).ServeHTTP(w, r)
+	})
+}
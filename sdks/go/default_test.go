@@ -0,0 +1,139 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// useDefault makes c the default client until the test ends.
+func useDefault(t *testing.T, c *Client) {
+	t.Helper()
+	previous := Default()
+	SetDefault(c)
+	t.Cleanup(func() { SetDefault(previous) })
+}
+
+func TestPackageFunctionsWithoutDefaultAreNoops(t *testing.T) {
+	useDefault(t, nil)
+	ctx := context.Background()
+
+	TrackStateChange(ctx, "balance", 100, 90, "", "Write")
+	TrackFunctionCall(ctx, "transfer", "payments", nil, "", 0)
+	TrackFunctionReturn(ctx, "transfer", nil, "", 0)
+	StartFunction(ctx, "transfer", nil)()
+	TrackLockAcquire(ctx, "account", "Mutex")
+	TrackLockRelease(ctx, "account", "Mutex")
+	TrackError(ctx, "panic", "boom", nil)
+	CaptureError(ctx, errInsufficientFunds, nil)
+	if id := TrackCustom(ctx, "cache_miss", nil); id != "" {
+		t.Errorf("expected no event ID, got %q", id)
+	}
+	if StartTrace(ctx, "job") != ctx {
+		t.Errorf("expected StartTrace to return ctx unchanged")
+	}
+
+	// Functions wrapping application code still run it
+	var mu sync.Mutex
+	var rw sync.RWMutex
+	ran := 0
+	if TrackFunction(ctx, "transfer", nil, func() interface{} { return 42 }) != 42 {
+		t.Errorf("expected TrackFunction to return fn's result")
+	}
+	WithLock(ctx, &mu, "account", "Mutex", func() { ran++ })
+	WithRWLockRead(ctx, &rw, "ledger", func() { ran++ })
+	WithRWLockWrite(ctx, &rw, "ledger", func() { ran++ })
+	done := make(chan struct{})
+	Go(ctx, "audit", func(context.Context) { close(done) })
+	<-done
+	if ran != 3 || !mu.TryLock() || !rw.TryLock() {
+		t.Errorf("expected every fn run and every lock released, ran %d", ran)
+	}
+
+	recorder := httptest.NewRecorder()
+	Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if FromContext(r.Context()) != nil {
+			t.Errorf("expected the request untraced")
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusAccepted {
+		t.Errorf("expected the handler's response, got %d", recorder.Code)
+	}
+}
+
+func TestPackageFunctionsUseDefault(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	useDefault(t, c)
+	var got *RacewayContext
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+		ctx := r.Context()
+		_, _, line, _ := runtime.Caller(0)
+		TrackStateChange(ctx, "balance", 100, 90, "", "Write")
+		defer StartFunction(ctx, "transfer", nil)()
+		var mu sync.Mutex
+		WithLock(ctx, &mu, "account", "Mutex", func() {})
+		var rw sync.RWMutex
+		WithRWLockWrite(ctx, &rw, "ledger", func() {})
+
+		events := bufferedEvents(c)
+		if len(events) != 7 {
+			t.Fatalf("expected 7 events, got %d", len(events))
+		}
+		if want := fmt.Sprintf("default_test.go:%d", line+1); events[1].Kind.StateChange.Location != want {
+			t.Errorf("expected the caller's location %q, got %q", want, events[1].Kind.StateChange.Location)
+		}
+		if call := events[2].Kind.FunctionCall; call.File != "default_test.go" || call.Line != line+2 {
+			t.Errorf("expected the call at default_test.go:%d, got %s:%d", line+2, call.File, call.Line)
+		}
+		if want := fmt.Sprintf("default_test.go:%d", line+4); events[3].Kind.LockAcquire.Location != want {
+			t.Errorf("expected the lock at %q, got %q", want, events[3].Kind.LockAcquire.Location)
+		}
+		if want := fmt.Sprintf("default_test.go:%d", line+6); events[5].Kind.LockAcquire.Location != want {
+			t.Errorf("expected the write lock at %q, got %q", want, events[5].Kind.LockAcquire.Location)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", nil))
+	if got == nil {
+		t.Fatalf("expected the request traced by the default client")
+	}
+}
+
+func TestConfigSetAsDefault(t *testing.T) {
+	useDefault(t, nil)
+	c := newBufferingClient(t, func(cfg *Config) { cfg.SetAsDefault = true })
+	if Default() != c {
+		t.Errorf("expected New to register the client as the default")
+	}
+}
+
+func TestSetDefaultConcurrentWithTracking(t *testing.T) {
+	useDefault(t, nil)
+	clients := []*Client{newBufferingClient(t, nil), newBufferingClient(t, nil), nil}
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				SetDefault(clients[(i+j)%len(clients)])
+			}
+		}(i)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				TrackStateChange(ctx, "balance", j, j+1, "default_test.go:1", "Write")
+			}
+		}(DeriveThread(ctx))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,27 @@
+//go:build raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDisabledBuildAllocatesNothing(t *testing.T) {
+	c := New(Config{ServiceName: "test-service"})
+	defer c.Shutdown()
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	balance := 1000
+	allocs := testing.AllocsPerRun(100, func() {
+		c.TrackStateChange(ctx, "balance", balance, balance-50, "", "Write")
+		c.TrackFunctionCall(ctx, "transfer", "app", map[string]interface{}{"amount": balance}, "", 0)
+		c.TrackCustom(ctx, "step", map[string]interface{}{"balance": balance})
+		span, spanCtx := c.StartSpan(ctx, "validate", nil)
+		c.TrackLockAcquire(spanCtx, "account_lock", "Mutex")
+		span.End()
+	})
+	if allocs != 0 {
+		t.Errorf("expected tracking calls to compile away, got %v allocations per run", allocs)
+	}
+}
@@ -0,0 +1,131 @@
+package raceway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingSink counts the batches sent to it.
+type countingSink struct {
+	sends atomic.Int32
+}
+
+func (s *countingSink) Send(ctx context.Context, events []Event) error {
+	s.sends.Add(1)
+	return nil
+}
+
+type okHandler struct{}
+
+func (okHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func newDisabledClient(t *testing.T) (*Client, *countingSink) {
+	t.Helper()
+	sink := &countingSink{}
+	c := New(Config{ServiceName: "test-service", Disabled: true, Sink: sink})
+	t.Cleanup(func() { c.Shutdown() })
+	return c, sink
+}
+
+func TestDisabledClientRecordsNothing(t *testing.T) {
+	before := runtime.NumGoroutine()
+	c, sink := newDisabledClient(t)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "balance", 100, 50, "", "Write")
+	c.TrackFunctionCall(ctx, "transfer", "app", map[string]interface{}{"amount": 50}, "", 0)
+	c.TrackCustom(ctx, "step", nil)
+	c.CaptureError(ctx, errors.New("failed"), nil)
+	span, spanCtx := c.StartSpan(ctx, "validate", nil)
+	c.TrackLockAcquire(spanCtx, "account_lock", "Mutex")
+	span.End()
+	if err := c.FlushSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := c.Stats(); stats.EventsBuffered != 0 || stats.EventsSent != 0 {
+		t.Errorf("expected nothing recorded, got %+v", stats)
+	}
+	if sink.sends.Load() != 0 {
+		t.Errorf("expected nothing sent, got %d batches", sink.sends.Load())
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected no goroutines started, went from %d to %d", before, after)
+	}
+	if err := c.ShutdownContext(context.Background()); err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+}
+
+func TestDisabledClientPassesHandlersThrough(t *testing.T) {
+	c, _ := newDisabledClient(t)
+
+	var next http.Handler = okHandler{}
+	if c.Middleware(next) != next {
+		t.Error("expected Middleware to return the handler unchanged")
+	}
+	if c.Transport(http.DefaultTransport) != http.DefaultTransport {
+		t.Error("expected Transport to return the transport unchanged")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+	if ctx := c.ContextFromRequest(r); FromContext(ctx) != nil {
+		t.Error("expected no Raceway context for the request")
+	}
+	if ctx := c.StartTrace(context.Background(), "cron"); FromContext(ctx) != nil {
+		t.Error("expected StartTrace to start no trace")
+	}
+	headers, err := c.PropagationHeaders(context.Background(), map[string]string{"x-request-id": "42"})
+	if err != nil || len(headers) != 1 || headers["x-request-id"] != "42" {
+		t.Errorf("PropagationHeaders = %v, %v; want only the extra headers", headers, err)
+	}
+}
+
+func TestDisabledClientStillRunsCallbacks(t *testing.T) {
+	c, _ := newDisabledClient(t)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var lock sync.Mutex
+	ran := 0
+	c.WithLock(ctx, &lock, "lock", "Mutex", func() { ran++ })
+	if !lock.TryLock() {
+		t.Error("expected WithLock to release the lock")
+	}
+	lock.Unlock()
+	c.TrackedWrite(ctx, "counter", func() (interface{}, interface{}) {
+		ran++
+		return 0, 1
+	})
+	if got := c.TrackedRead(ctx, "counter", func() interface{} { return 1 }); got != 1 {
+		t.Errorf("TrackedRead = %v, want 1", got)
+	}
+	errFailed := errors.New("failed")
+	if err := c.WithTransaction(ctx, "transfer", func(ctx context.Context) error { return errFailed }); err != errFailed {
+		t.Errorf("WithTransaction = %v, want fn's error", err)
+	}
+	done := make(chan struct{})
+	c.Go(ctx, "worker", func(ctx context.Context) { close(done) })
+	<-done
+
+	if ran != 2 {
+		t.Errorf("expected both callbacks to run, ran %d", ran)
+	}
+}
+
+func BenchmarkDisabledTrackStateChange(b *testing.B) {
+	c := New(Config{ServiceName: "bench-service", Disabled: true})
+	defer c.Shutdown()
+	ctx := NewContext(context.Background(), "", "bench-service", "bench-instance")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.TrackStateChange(ctx, "balance", 100, 50, "", "Write")
+	}
+}
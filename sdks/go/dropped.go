@@ -0,0 +1,106 @@
+package raceway
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// DropReason identifies why events were dropped.
+type DropReason string
+
+const (
+	// DropBufferFull is an event discarded because MaxBufferedEvents events
+	// were already buffered or queued.
+	DropBufferFull DropReason = "buffer_full"
+	// DropMarshalError is a batch that could not be encoded as JSON.
+	DropMarshalError DropReason = "marshal_error"
+	// DropSendFailed is a batch a sink rejected permanently, or that could not
+	// be delivered within a flush.
+	DropSendFailed DropReason = "send_failed"
+	// DropShutdownTimeout is an event still undelivered when Shutdown ran out
+	// of Config.ShutdownTimeout.
+	DropShutdownTimeout DropReason = "shutdown_timeout"
+	// DropServerRejected is an event the server refused in a batch it
+	// otherwise accepted, as reported in its response body.
+	DropServerRejected DropReason = "server_rejected"
+)
+
+// droppedEvents is one pending call of Config.OnEventsDropped.
+type droppedEvents struct {
+	reason DropReason
+	events []Event
+}
+
+// dropNotifier runs Config.OnEventsDropped on a goroutine of its own, in the
+// order events were dropped. The goroutine exits once nothing is pending.
+type dropNotifier struct {
+	mu      sync.Mutex
+	pending []droppedEvents
+	running bool
+}
+
+// eventsDropped reports events dropped for reason to Config.OnEventsDropped
+// without waiting for it, so a slow hook cannot stall capture or flushing.
+func (c *Client) eventsDropped(reason DropReason, events []Event) {
+	if c.config.OnEventsDropped == nil || len(events) == 0 {
+		return
+	}
+	events = append([]Event(nil), events...)
+
+	n := &c.drops
+	n.mu.Lock()
+	if last := len(n.pending) - 1; last >= 0 && n.pending[last].reason == reason {
+		// Coalesce drops made while the hook is busy, such as a burst of
+		// events captured while the queue is full
+		n.pending[last].events = append(n.pending[last].events, events...)
+	} else {
+		n.pending = append(n.pending, droppedEvents{reason: reason, events: events})
+	}
+	start := !n.running
+	n.running = true
+	n.mu.Unlock()
+
+	if start {
+		go c.notifyDropped()
+	}
+}
+
+func (c *Client) notifyDropped() {
+	n := &c.drops
+	for {
+		n.mu.Lock()
+		if len(n.pending) == 0 {
+			n.running = false
+			n.mu.Unlock()
+			return
+		}
+		next := n.pending[0]
+		n.pending = n.pending[1:]
+		n.mu.Unlock()
+
+		c.callDropHook(next)
+	}
+}
+
+// callDropHook calls Config.OnEventsDropped, recovering a panic so that a
+// misbehaving hook cannot take down the application.
+func (c *Client) callDropHook(dropped droppedEvents) {
+	defer func() {
+		if v := recover(); v != nil {
+			c.logger.Errorf("OnEventsDropped panicked with %d %s events: %v", len(dropped.events), dropped.reason, v)
+		}
+	}()
+	c.config.OnEventsDropped(dropped.reason, dropped.events)
+}
+
+// dropReason classifies the error a batch was dropped with.
+func dropReason(err error) DropReason {
+	var unsupportedType *json.UnsupportedTypeError
+	var unsupportedValue *json.UnsupportedValueError
+	var marshaler *json.MarshalerError
+	if errors.As(err, &unsupportedType) || errors.As(err, &unsupportedValue) || errors.As(err, &marshaler) {
+		return DropMarshalError
+	}
+	return DropSendFailed
+}
@@ -0,0 +1,245 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// dropRecorder collects OnEventsDropped calls.
+type dropRecorder chan droppedEvents
+
+func (r dropRecorder) hook(reason DropReason, events []Event) {
+	r <- droppedEvents{reason: reason, events: events}
+}
+
+// wait returns the events dropped for reason until n have been received.
+func (r dropRecorder) wait(t *testing.T, reason DropReason, n int) []Event {
+	t.Helper()
+	var events []Event
+	for len(events) < n {
+		select {
+		case dropped := <-r:
+			if dropped.reason != reason {
+				t.Fatalf("expected %s, got %s", reason, dropped.reason)
+			}
+			events = append(events, dropped.events...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d %s events, got %d", n, reason, len(events))
+		}
+	}
+	return events
+}
+
+func TestOnEventsDroppedReceivesFailedBatch(t *testing.T) {
+	drops := make(dropRecorder, 8)
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Sink = &permanentSink{}
+		cfg.OnEventsDropped = drops.hook
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, c.TrackCustom(ctx, "step", map[string]interface{}{"i": i}))
+	}
+
+	var flushErr *FlushError
+	if err := c.FlushContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Dropped != 3 {
+		t.Fatalf("expected 3 dropped events, got %v", err)
+	}
+	events := drops.wait(t, DropSendFailed, 3)
+	for i, event := range events {
+		if event.ID != ids[i] {
+			t.Errorf("expected the failed batch in order, got %s at %d", event.ID, i)
+		}
+	}
+}
+
+func TestOnEventsDroppedReportsBufferOverflow(t *testing.T) {
+	drops := make(dropRecorder, 8)
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.MaxBufferedEvents = 5
+		cfg.OnEventsDropped = drops.hook
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	var ids []string
+	for i := 0; i < 8; i++ {
+		ids = append(ids, c.TrackCustom(ctx, "step", nil))
+		// Let the writer goroutine buffer each event before the next
+		c.syncPipeline(context.Background())
+	}
+
+	events := drops.wait(t, DropBufferFull, 3)
+	for i, event := range events {
+		if event.ID != ids[i] {
+			t.Errorf("expected the oldest events dropped, got %s at %d", event.ID, i)
+		}
+	}
+	if stats := c.Stats(); stats.EventsDropped != 3 || stats.EventsBuffered != 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// stallingSink blocks every Send until its context is done.
+type stallingSink struct{}
+
+func (stallingSink) Send(ctx context.Context, events []Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestShutdownTimeoutDropsUndeliveredEvents(t *testing.T) {
+	drops := make(dropRecorder, 8)
+	c := New(Config{
+		ServiceName:     "test-service",
+		BatchSize:       100,
+		FlushInterval:   time.Hour,
+		ShutdownTimeout: 50 * time.Millisecond,
+		Sink:            stallingSink{},
+		OnEventsDropped: drops.hook,
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackCustom(ctx, "step", nil)
+	c.TrackCustom(ctx, "step", nil)
+
+	var flushErr *FlushError
+	if err := c.ShutdownContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Dropped != 2 || flushErr.Requeued != 0 {
+		t.Fatalf("expected both events dropped, got %v", err)
+	}
+	drops.wait(t, DropShutdownTimeout, 2)
+	if stats := c.Stats(); stats.EventsBuffered != 0 || stats.EventsDropped != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestShutdownContextGivesUpWhenContextIsDone(t *testing.T) {
+	c := New(Config{
+		ServiceName:     "test-service",
+		BatchSize:       100,
+		FlushInterval:   time.Hour,
+		ShutdownTimeout: time.Hour,
+		Sink:            stallingSink{},
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackCustom(ctx, "step", nil)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var flushErr *FlushError
+	if err := c.ShutdownContext(shutdownCtx); !errors.As(err, &flushErr) || flushErr.Dropped != 1 {
+		t.Fatalf("expected the event dropped once the context was done, got %v", err)
+	}
+}
+
+func TestOnEventsDroppedRecoversPanic(t *testing.T) {
+	drops := make(dropRecorder, 8)
+	panicked := make(chan struct{})
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Sink = &permanentSink{}
+		cfg.OnEventsDropped = func(reason DropReason, events []Event) {
+			if events[0].Kind.Custom.Type == "first" {
+				close(panicked)
+				panic("dead-letter queue unavailable")
+			}
+			drops.hook(reason, events)
+		}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackCustom(ctx, "first", nil)
+	c.FlushContext(context.Background())
+	<-panicked
+	c.TrackCustom(ctx, "second", nil)
+	c.FlushContext(context.Background())
+
+	events := drops.wait(t, DropSendFailed, 1)
+	if events[0].Kind.Custom.Type != "second" {
+		t.Errorf("expected the hook called again after panicking, got %s", events[0].Kind.Custom.Type)
+	}
+}
+
+func TestDropReasonClassifiesMarshalErrors(t *testing.T) {
+	_, err := json.Marshal(math.Inf(1))
+	if reason := dropReason(permanent(err)); reason != DropMarshalError {
+		t.Errorf("expected %s, got %s", DropMarshalError, reason)
+	}
+	if reason := dropReason(&StatusError{StatusCode: 422}); reason != DropSendFailed {
+		t.Errorf("expected %s, got %s", DropSendFailed, reason)
+	}
+}
+
+func TestDrainToWritesBufferAsNDJSON(t *testing.T) {
+	drops := make(dropRecorder, 8)
+	c := newBufferingClient(t, func(cfg *Config) { cfg.OnEventsDropped = drops.hook })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, c.TrackCustom(ctx, "step", map[string]interface{}{"i": i}))
+	}
+	// An event that cannot be encoded is skipped and reported
+	c.syncPipeline(context.Background())
+	c.mu.Lock()
+	c.eventBuffer = append(c.eventBuffer, Event{ID: "bad", Kind: EventKind{Custom: &CustomData{Payload: map[string]interface{}{"x": math.NaN()}}}})
+	c.stats.buffered.Add(1)
+	c.mu.Unlock()
+
+	var buf bytes.Buffer
+	n, err := c.DrainTo(&buf)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 events written, got %d, %v", n, err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	for i := 0; scanner.Scan(); i++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.ID != ids[i] {
+			t.Errorf("line %d: expected event %s, got %s (%v)", i, ids[i], event.ID, err)
+		}
+	}
+	if dropped := drops.wait(t, DropMarshalError, 1); dropped[0].ID != "bad" {
+		t.Errorf("expected the unencodable event reported, got %s", dropped[0].ID)
+	}
+	if stats := c.Stats(); stats.EventsBuffered != 0 {
+		t.Errorf("expected an empty buffer, got %+v", stats)
+	}
+}
+
+// failingWriter accepts limit writes and fails the rest.
+type failingWriter struct {
+	limit int
+	bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.limit == 0 {
+		return 0, errors.New("disk full")
+	}
+	w.limit--
+	return w.Buffer.Write(p)
+}
+
+func TestDrainToKeepsUnwrittenEvents(t *testing.T) {
+	sink := &recordingSink{}
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Sink = sink })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 5; i++ {
+		c.TrackCustom(ctx, "step", nil)
+	}
+
+	n, err := c.DrainTo(&failingWriter{limit: 2})
+	if err == nil || n != 2 {
+		t.Fatalf("expected 2 events written before the error, got %d, %v", n, err)
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(sink.received()); got != 3 {
+		t.Errorf("expected the 3 unwritten events to stay buffered, got %d sent", got)
+	}
+}
@@ -0,0 +1,197 @@
+package raceway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultDuplicateWindow is how long a request fingerprint is remembered.
+	defaultDuplicateWindow = 30 * time.Second
+	// maxDuplicateEntries bounds the process-local fingerprint map.
+	maxDuplicateEntries = 4096
+)
+
+// defaultIdempotencyHeaders are read when Config.IdempotencyHeaders is empty.
+var defaultIdempotencyHeaders = []string{"Idempotency-Key", "X-Idempotency-Key"}
+
+// duplicateTracker remembers recent request fingerprints and the trace that first used them.
+type duplicateTracker struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]duplicateEntry
+	order   []string
+	now     func() time.Time
+}
+
+type duplicateEntry struct {
+	traceID string
+	seen    time.Time
+}
+
+func newDuplicateTracker(window time.Duration) *duplicateTracker {
+	if window <= 0 {
+		window = defaultDuplicateWindow
+	}
+	return &duplicateTracker{
+		window:  window,
+		entries: make(map[string]duplicateEntry),
+		now:     time.Now,
+	}
+}
+
+// observe records fingerprint for traceID and returns the trace that used the
+// same fingerprint within the window, if any.
+func (d *duplicateTracker) observe(fingerprint, traceID string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if entry, ok := d.entries[fingerprint]; ok && now.Sub(entry.seen) <= d.window && entry.traceID != traceID {
+		return entry.traceID, true
+	}
+
+	if _, ok := d.entries[fingerprint]; !ok {
+		if len(d.order) >= maxDuplicateEntries {
+			delete(d.entries, d.order[0])
+			d.order = d.order[1:]
+		}
+		d.order = append(d.order, fingerprint)
+	}
+	d.entries[fingerprint] = duplicateEntry{traceID: traceID, seen: now}
+	return "", false
+}
+
+// hashingBody hashes a request body as the handler reads it, without buffering.
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+	read bool
+	done bool
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.read = true
+		b.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.done = true
+	}
+	return n, err
+}
+
+// sum returns the body hash if the handler read the body to the end.
+func (b *hashingBody) sum() (string, bool) {
+	if b == nil || !b.done || !b.read {
+		return "", false
+	}
+	return hex.EncodeToString(b.hash.Sum(nil)), true
+}
+
+// requestCheck carries duplicate-detection state for one request.
+type requestCheck struct {
+	route          string
+	idempotencyKey string
+	body           *hashingBody
+}
+
+// startRequestCheck captures the idempotency key and wraps the request body for
+// hashing. It returns nil when duplicate detection is disabled.
+func (c *Client) startRequestCheck(r *http.Request) *requestCheck {
+	if c.duplicates == nil {
+		return nil
+	}
+	check := &requestCheck{route: r.Method + " " + r.URL.Path}
+
+	headers := c.config.IdempotencyHeaders
+	if len(headers) == 0 {
+		headers = defaultIdempotencyHeaders
+	}
+	for _, header := range headers {
+		if value := r.Header.Get(header); value != "" {
+			check.idempotencyKey = value
+			break
+		}
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		check.body = &hashingBody{ReadCloser: r.Body, hash: sha256.New()}
+		r.Body = check.body
+	}
+	return check
+}
+
+// rootTags returns the tags for the request's root event. Requests with an
+// idempotency key are checked for duplicates immediately.
+func (c *Client) rootTags(ctx context.Context, check *requestCheck) map[string]string {
+	if check == nil || check.idempotencyKey == "" {
+		return nil
+	}
+	tags := map[string]string{"idempotency_key": check.idempotencyKey}
+	if rctx := FromContext(ctx); rctx != nil {
+		if original, ok := c.duplicates.observe(check.route+" key:"+check.idempotencyKey, rctx.TraceID); ok {
+			tags["duplicate_of_trace"] = original
+		}
+	}
+	return tags
+}
+
+// finishRequestCheck records the body hash once the handler has consumed the
+// body and, for requests without an idempotency key, checks it for duplicates.
+// The tags are added to the root event if it is still buffered, or recorded on
+// a request_fingerprint event parented to it otherwise.
+func (c *Client) finishRequestCheck(ctx context.Context, check *requestCheck, rootID string) {
+	if check == nil || rootID == "" {
+		return
+	}
+	sum, ok := check.body.sum()
+	if !ok {
+		return
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return
+	}
+
+	tags := map[string]string{"request_body_sha256": sum}
+	if check.idempotencyKey == "" {
+		if original, dup := c.duplicates.observe(check.route+" body:"+sum, rctx.TraceID); dup {
+			tags["duplicate_of_trace"] = original
+		}
+	}
+
+	if c.tagBufferedEvent(rctx.TraceID, rootID, tags) {
+		return
+	}
+	c.captureEventWith(ctx, EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "request_fingerprint",
+			Module:       "raceway",
+			Args:         tags,
+			File:         "raceway",
+		},
+	}, captureOptions{tags: tags, parentID: &rootID})
+}
+
+// tagBufferedEvent adds tags to a buffered event of traceID. It reports false
+// if the event has already been flushed.
+func (c *Client) tagBufferedEvent(traceID, eventID string, tags map[string]string) bool {
+	return c.editBufferedEvent(traceID, eventID, func(event *Event) {
+		merged := make(map[string]string, len(event.Metadata.Tags)+len(tags))
+		for k, v := range event.Metadata.Tags {
+			merged[k] = v
+		}
+		for k, v := range tags {
+			merged[k] = v
+		}
+		event.Metadata.Tags = merged
+	})
+}
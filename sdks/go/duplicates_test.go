@@ -0,0 +1,128 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveDuplicateCheck(t *testing.T, c *Client, req *http.Request, readBody bool) Event {
+	t.Helper()
+	var traceID string
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = FromContext(r.Context()).TraceID
+		if readBody {
+			io.ReadAll(r.Body)
+		}
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, e := range bufferedEvents(c) {
+		if e.TraceID == traceID && e.Kind.HTTPRequest != nil {
+			return e
+		}
+	}
+	t.Fatalf("no root event for trace %s", traceID)
+	return Event{}
+}
+
+func TestRetriedPostsAreLinked(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.DetectDuplicateRequests = true })
+
+	first := serveDuplicateCheck(t, c, httptest.NewRequest("POST", "/payments", strings.NewReader(`{"amount":10}`)), true)
+	retry := serveDuplicateCheck(t, c, httptest.NewRequest("POST", "/payments", strings.NewReader(`{"amount":10}`)), true)
+
+	hash := first.Metadata.Tags["request_body_sha256"]
+	if hash == "" || retry.Metadata.Tags["request_body_sha256"] != hash {
+		t.Fatalf("expected matching body hashes, got %v and %v", first.Metadata.Tags, retry.Metadata.Tags)
+	}
+	if _, ok := first.Metadata.Tags["duplicate_of_trace"]; ok {
+		t.Errorf("first request must not be tagged as a duplicate")
+	}
+	if retry.Metadata.Tags["duplicate_of_trace"] != first.TraceID {
+		t.Errorf("retry duplicate_of_trace = %q, want %s", retry.Metadata.Tags["duplicate_of_trace"], first.TraceID)
+	}
+}
+
+func TestIdempotencyKeyLinksDifferentBodies(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.DetectDuplicateRequests = true })
+
+	req := func(body string) *http.Request {
+		r := httptest.NewRequest("POST", "/payments", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", "key-1")
+		return r
+	}
+	first := serveDuplicateCheck(t, c, req(`{"amount":10}`), false)
+	retry := serveDuplicateCheck(t, c, req(`{"amount":10,"retry":true}`), false)
+
+	if first.Metadata.Tags["idempotency_key"] != "key-1" {
+		t.Errorf("expected idempotency_key tag, got %v", first.Metadata.Tags)
+	}
+	if retry.Metadata.Tags["duplicate_of_trace"] != first.TraceID {
+		t.Errorf("expected idempotency key to link the retry, got %v", retry.Metadata.Tags)
+	}
+	if _, ok := retry.Metadata.Tags["request_body_sha256"]; ok {
+		t.Errorf("unread bodies must not be hashed")
+	}
+}
+
+func TestDifferentBodiesAreNotLinked(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.DetectDuplicateRequests = true })
+
+	serveDuplicateCheck(t, c, httptest.NewRequest("POST", "/payments", strings.NewReader(`{"amount":10}`)), true)
+	other := serveDuplicateCheck(t, c, httptest.NewRequest("POST", "/payments", strings.NewReader(`{"amount":20}`)), true)
+	otherRoute := serveDuplicateCheck(t, c, httptest.NewRequest("POST", "/refunds", strings.NewReader(`{"amount":10}`)), true)
+
+	for _, e := range []Event{other, otherRoute} {
+		if _, ok := e.Metadata.Tags["duplicate_of_trace"]; ok {
+			t.Errorf("unexpected duplicate tag on %s: %v", e.Kind.HTTPRequest.URL, e.Metadata.Tags)
+		}
+	}
+}
+
+func TestDuplicateWindowExpires(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.DetectDuplicateRequests = true })
+	now := time.Now()
+	c.duplicates.now = func() time.Time { return now }
+
+	serveDuplicateCheck(t, c, httptest.NewRequest("POST", "/payments", strings.NewReader(`{"amount":10}`)), true)
+	now = now.Add(defaultDuplicateWindow + time.Second)
+	late := serveDuplicateCheck(t, c, httptest.NewRequest("POST", "/payments", strings.NewReader(`{"amount":10}`)), true)
+
+	if _, ok := late.Metadata.Tags["duplicate_of_trace"]; ok {
+		t.Errorf("expected no link after the window expired, got %v", late.Metadata.Tags)
+	}
+}
+
+func TestGetWithoutBodyIsNotFingerprinted(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.DetectDuplicateRequests = true })
+
+	first := serveDuplicateCheck(t, c, httptest.NewRequest("GET", "/payments", nil), true)
+	second := serveDuplicateCheck(t, c, httptest.NewRequest("GET", "/payments", nil), true)
+
+	for _, e := range []Event{first, second} {
+		if len(e.Metadata.Tags) != 2 {
+			t.Errorf("expected only default tags on GET, got %v", e.Metadata.Tags)
+		}
+	}
+}
+
+func TestFingerprintRecordedAfterRootEventFlushed(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.DetectDuplicateRequests = true })
+	c.router.fallback.route.Sink = &recordingSink{}
+
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Flush()
+		io.ReadAll(r.Body)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/payments", strings.NewReader("x")))
+
+	events := bufferedEvents(c)
+	if len(events) != 2 || events[0].Kind.FunctionCall == nil || events[0].Metadata.Tags["request_body_sha256"] == "" {
+		t.Fatalf("expected a request_fingerprint event before the response, got %+v", events)
+	}
+}
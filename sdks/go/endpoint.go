@@ -0,0 +1,54 @@
+package raceway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultEventsPath is used when Config.EventsPath is empty.
+const DefaultEventsPath = "/events"
+
+// unixScheme prefixes Endpoint values naming a Unix domain socket, as in
+// "unix:///var/run/raceway.sock".
+const unixScheme = "unix://"
+
+// unixBaseURL stands in for the server URL of requests sent over a Unix
+// socket; the transport ignores its host and dials the socket.
+const unixBaseURL = "http://raceway"
+
+// newHTTPClient returns the HTTP client for requests to the server at
+// endpoint and the base URL to address them to. Proxies from the environment
+// are used only if useProxy is set, and never for a Unix socket.
+func newHTTPClient(endpoint string, useProxy bool, timeout time.Duration) (*http.Client, string) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if useProxy {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	baseURL := strings.TrimRight(endpoint, "/")
+	if socket, ok := strings.CutPrefix(endpoint, unixScheme); ok {
+		var dialer net.Dialer
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		baseURL = unixBaseURL
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, baseURL
+}
+
+// eventsPath returns path with a leading slash, or DefaultEventsPath if it is
+// empty.
+func eventsPath(path string) string {
+	if path == "" {
+		return DefaultEventsPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
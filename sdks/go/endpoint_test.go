@@ -0,0 +1,121 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// pathRecorder counts the events posted to each path.
+type pathRecorder struct {
+	mu     sync.Mutex
+	events map[string]int
+}
+
+func (r *pathRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	json.NewDecoder(req.Body).Decode(&body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[req.URL.Path] += len(body.Events)
+}
+
+func (r *pathRecorder) received(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events[path]
+}
+
+// newUnixServer serves handler on a Unix socket and returns its path.
+func newUnixServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	// Socket paths are limited to about 100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "raceway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "raceway.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return socket
+}
+
+func TestUnixSocketEndpoint(t *testing.T) {
+	recorder := &pathRecorder{events: make(map[string]int)}
+	socket := newUnixServer(t, recorder)
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = "unix://" + socket
+		cfg.EventsPath = "/ingest/v1/events"
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 3; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "endpoint_test.go:1", "Write")
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("flush over Unix socket failed: %v", err)
+	}
+	c.TrackStateChange(ctx, "counter", 3, 4, "endpoint_test.go:2", "Write")
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("second flush over Unix socket failed: %v", err)
+	}
+	if n := recorder.received("/ingest/v1/events"); n != 4 {
+		t.Errorf("expected 4 events at the configured path, got %d", n)
+	}
+}
+
+func TestEventsPathDefault(t *testing.T) {
+	recorder := &pathRecorder{events: make(map[string]int)}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ServerURL = server.URL + "/" })
+	c.TrackStateChange(NewContext(context.Background(), "", "test-service", "test-instance"), "counter", 0, 1, "endpoint_test.go:1", "Write")
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if n := recorder.received("/events"); n != 1 {
+		t.Errorf("expected 1 event at /events, got %d", n)
+	}
+}
+
+func TestEventsPath(t *testing.T) {
+	for path, want := range map[string]string{"": "/events", "/ingest": "/ingest", "ingest/events": "/ingest/events"} {
+		if got := eventsPath(path); got != want {
+			t.Errorf("eventsPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestUseProxy(t *testing.T) {
+	transport := func(endpoint string, useProxy bool) *http.Transport {
+		client, _ := newHTTPClient(endpoint, useProxy, 0)
+		return client.Transport.(*http.Transport)
+	}
+	if transport("http://collector:8080", false).Proxy != nil {
+		t.Errorf("expected no proxy by default")
+	}
+	if transport("http://collector:8080", true).Proxy == nil {
+		t.Errorf("expected proxies from the environment with UseProxy")
+	}
+	if transport("unix:///var/run/raceway.sock", true).Proxy != nil {
+		t.Errorf("expected no proxy for a Unix socket")
+	}
+}
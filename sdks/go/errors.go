@@ -0,0 +1,189 @@
+package raceway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+)
+
+// maxErrorFrames caps the stack trace recorded for each error.
+const maxErrorFrames = 32
+
+const (
+	// errorDepthTag is the position of an error in the chain recorded by
+	// CaptureError, 0 for the error passed to it.
+	errorDepthTag = "error_depth"
+	// repeatCountTag counts the captures of the same error at the same place
+	// within a trace, from 2 on the first repeat.
+	repeatCountTag = "repeat_count"
+)
+
+// CaptureError records err and each error it wraps, as found by
+// errors.Unwrap, as Error events with the error's type, as printed by %T,
+// and message, each a child of the one wrapping it. Every event carries tags
+// and its depth in the chain in the error_depth tag.
+//
+// The outermost error records the stack of CaptureError's caller. An error
+// with a StackTrace method, such as those created by github.com/pkg/errors,
+// records its own stack instead. Frames are recorded as "file:line function",
+// at most 32 of them.
+//
+// Capturing an error with the same type and message at the same place again
+// in a trace records only the outermost error, tagged with repeat_count, so a
+// sentinel error returned in a loop does not flood the trace.
+//
+// Example:
+//
+//	if err := debit(ctx, account, amount); err != nil {
+//	    client.CaptureError(ctx, err, map[string]string{"account": account})
+//	    return err
+//	}
+func (c *Client) CaptureError(ctx context.Context, err error, tags map[string]string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.captureError(ctx, err, tags, 3)
+}
+
+// WrapAndCapture wraps err as fmt.Errorf("msg: %w", err) would, records the
+// result with CaptureError, and returns it. It returns nil if err is nil, so
+// it fits existing return sites:
+//
+//	if err := debit(ctx, account, amount); err != nil {
+//	    return client.WrapAndCapture(ctx, err, "debit "+account)
+//	}
+func (c *Client) WrapAndCapture(ctx context.Context, err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	wrapped := fmt.Errorf("%s: %w", msg, err)
+	c.captureError(ctx, wrapped, nil, 3)
+	return wrapped
+}
+
+// captureError is CaptureError with the caller's stack starting skip frames
+// above runtime.Callers.
+func (c *Client) captureError(ctx context.Context, err error, tags map[string]string, skip int) {
+	if err == nil || BuildDisabled || c.disabled {
+		return
+	}
+	pcs := make([]uintptr, maxErrorFrames)
+	site := c.formatFrames(pcs[:runtime.Callers(skip, pcs)])
+
+	stack := c.errorStack(err)
+	if stack == nil {
+		stack = site
+	}
+	top := ""
+	if len(site) > 0 {
+		top = site[0]
+	}
+	if rctx := FromContext(ctx); rctx != nil && rctx.shared != nil {
+		if n := rctx.shared.noteError(fmt.Sprintf("%T", err), err.Error(), top); n > 1 {
+			repeated := errorTags(tags, 0)
+			repeated[repeatCountTag] = strconv.Itoa(n)
+			c.captureEventWith(ctx, errorKind(err, stack), captureOptions{tags: repeated})
+			return
+		}
+	}
+
+	var parentID *string
+	for depth := 0; err != nil; depth++ {
+		if depth > 0 {
+			stack = c.errorStack(err)
+			if stack == nil {
+				stack = []string{}
+			}
+		}
+		opts := captureOptions{tags: errorTags(tags, depth), parentID: parentID}
+		id := c.captureEventWith(ctx, errorKind(err, stack), opts)
+		if id == "" {
+			return
+		}
+		parentID = &id
+		err = errors.Unwrap(err)
+	}
+}
+
+func errorKind(err error, stack []string) EventKind {
+	return EventKind{
+		Error: &ErrorData{
+			ErrorType:  fmt.Sprintf("%T", err),
+			Message:    err.Error(),
+			StackTrace: stack,
+		},
+	}
+}
+
+// errorTags returns a copy of tags with the error_depth tag set to depth.
+func errorTags(tags map[string]string, depth int) map[string]string {
+	linkTags := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		linkTags[k] = v
+	}
+	linkTags[errorDepthTag] = strconv.Itoa(depth)
+	return linkTags
+}
+
+// errorStack returns the stack err carries in a StackTrace method returning
+// program counters, like github.com/pkg/errors, or strings, or nil if it has
+// none. The method is found by reflection so that no such package is needed.
+func (c *Client) errorStack(err error) []string {
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return nil
+	}
+	trace := method.Call(nil)[0]
+	if trace.Kind() != reflect.Slice {
+		return nil
+	}
+	switch trace.Type().Elem().Kind() {
+	case reflect.Uintptr:
+		pcs := make([]uintptr, min(trace.Len(), maxErrorFrames))
+		for i := range pcs {
+			pcs[i] = uintptr(trace.Index(i).Uint())
+		}
+		return c.formatFrames(pcs)
+	case reflect.String:
+		stack := make([]string, min(trace.Len(), maxErrorFrames))
+		for i := range stack {
+			stack[i] = trace.Index(i).String()
+		}
+		return c.paths.trimStack(stack)
+	}
+	return nil
+}
+
+// formatFrames formats the frames of pcs as "file:line function", with files
+// trimmed like event locations, never returning nil.
+func (c *Client) formatFrames(pcs []uintptr) []string {
+	stack := []string{}
+	if len(pcs) == 0 {
+		return stack
+	}
+	frames := runtime.CallersFrames(pcs)
+	for len(stack) < maxErrorFrames {
+		frame, more := frames.Next()
+		stack = append(stack, fmt.Sprintf("%s:%d %s", c.paths.trim(frame.File), frame.Line, frame.Function))
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// noteError counts a capture of the error with errorType and message at
+// site and returns how many times it has been captured in the trace.
+func (s *traceState) noteError(errorType, message, site string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errors == nil {
+		s.errors = make(map[string]int)
+	}
+	key := errorType + "\x00" + message + "\x00" + site
+	s.errors[key]++
+	return s.errors[key]
+}
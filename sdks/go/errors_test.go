@@ -0,0 +1,161 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
+func TestCaptureErrorRecordsChain(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	err := fmt.Errorf("transfer: %w", fmt.Errorf("debit alice: %w", errInsufficientFunds))
+	_, _, line, _ := runtime.Caller(0)
+	c.CaptureError(ctx, err, map[string]string{"account": "alice"})
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected an event per error in the chain, got %d", len(events))
+	}
+	want := []struct{ errorType, message string }{
+		{"*fmt.wrapError", "transfer: debit alice: insufficient funds"},
+		{"*fmt.wrapError", "debit alice: insufficient funds"},
+		{"*errors.errorString", "insufficient funds"},
+	}
+	for i, event := range events {
+		data := event.Kind.Error
+		if data.ErrorType != want[i].errorType || data.Message != want[i].message {
+			t.Errorf("event %d: expected %s %q, got %s %q", i, want[i].errorType, want[i].message, data.ErrorType, data.Message)
+		}
+		if event.Metadata.Tags[errorDepthTag] != fmt.Sprint(i) || event.Metadata.Tags["account"] != "alice" {
+			t.Errorf("event %d: unexpected tags %v", i, event.Metadata.Tags)
+		}
+		if i > 0 && (event.ParentID == nil || *event.ParentID != events[i-1].ID) {
+			t.Errorf("event %d: expected the wrapping error as its parent", i)
+		}
+	}
+
+	stack := events[0].Kind.Error.StackTrace
+	if top := fmt.Sprintf("errors_test.go:%d ", line+1); len(stack) == 0 || !strings.HasPrefix(stack[0], top) || !strings.HasSuffix(stack[0], ".TestCaptureErrorRecordsChain") {
+		t.Errorf("expected the capture site %q first, got %v", top, stack)
+	}
+	if len(events[2].Kind.Error.StackTrace) != 0 {
+		t.Errorf("expected no stack for a wrapped error without one, got %v", events[2].Kind.Error.StackTrace)
+	}
+}
+
+// frame and stackTrace mirror github.com/pkg/errors.
+type frame uintptr
+
+type stackTrace []frame
+
+type stackError struct {
+	msg   string
+	stack stackTrace
+}
+
+func (e *stackError) Error() string { return e.msg }
+
+func (e *stackError) StackTrace() stackTrace { return e.stack }
+
+func newStackError(msg string) error {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(1, pcs)
+	stack := make(stackTrace, n)
+	for i, pc := range pcs[:n] {
+		stack[i] = frame(pc)
+	}
+	return &stackError{msg: msg, stack: stack}
+}
+
+func TestCaptureErrorUsesErrorStackTrace(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.CaptureError(ctx, fmt.Errorf("ledger: %w", newStackError("row locked")), nil)
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	stack := events[1].Kind.Error.StackTrace
+	if len(stack) == 0 || !strings.HasSuffix(stack[0], ".newStackError") || !strings.HasPrefix(stack[0], "errors_test.go:") {
+		t.Errorf("expected the error's own stack, got %v", stack)
+	}
+}
+
+func TestCaptureErrorCapsStack(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var recurse func(n int)
+	recurse = func(n int) {
+		if n == 0 {
+			c.CaptureError(ctx, errInsufficientFunds, nil)
+			return
+		}
+		recurse(n - 1)
+	}
+	recurse(2 * maxErrorFrames)
+
+	events := bufferedEvents(c)
+	if len(events) != 1 || len(events[0].Kind.Error.StackTrace) != maxErrorFrames {
+		t.Fatalf("expected one event with %d frames, got %+v", maxErrorFrames, events)
+	}
+}
+
+func TestCaptureErrorDeduplicatesRepeats(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	for i := 0; i < 3; i++ {
+		c.CaptureError(ctx, fmt.Errorf("debit: %w", errInsufficientFunds), nil)
+	}
+	c.CaptureError(ctx, fmt.Errorf("debit: %w", errInsufficientFunds), nil)
+	// Another trace counts its own repeats
+	c.CaptureError(NewContext(context.Background(), "", "test-service", "test-instance"), errInsufficientFunds, nil)
+	c.CaptureError(NewContext(context.Background(), "", "test-service", "test-instance"), errInsufficientFunds, nil)
+
+	events := bufferedEvents(c)
+	if len(events) != 8 {
+		t.Fatalf("expected the first chain, an event per repeat, a chain captured elsewhere, and one per new trace, got %d", len(events))
+	}
+	for i, want := range []string{"", "", "2", "3", "", "", "", ""} {
+		if got := events[i].Metadata.Tags[repeatCountTag]; got != want {
+			t.Errorf("event %d: expected repeat_count %q, got %q", i, want, got)
+		}
+	}
+	if events[3].Kind.Error.ErrorType != "*fmt.wrapError" || events[3].Metadata.Tags[errorDepthTag] != "0" {
+		t.Errorf("expected a repeat to record the outermost error, got %+v", events[3].Kind.Error)
+	}
+}
+
+func TestWrapAndCapture(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	if err := c.WrapAndCapture(ctx, nil, "debit"); err != nil {
+		t.Fatalf("expected nil for a nil error, got %v", err)
+	}
+	_, _, line, _ := runtime.Caller(0)
+	err := c.WrapAndCapture(ctx, errInsufficientFunds, "debit alice")
+	if !errors.Is(err, errInsufficientFunds) || err.Error() != "debit alice: insufficient funds" {
+		t.Fatalf("unexpected wrapped error %v", err)
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 2 || events[0].Kind.Error.Message != err.Error() {
+		t.Fatalf("expected the wrapped chain recorded, got %+v", events)
+	}
+	if top := fmt.Sprintf("errors_test.go:%d ", line+1); !strings.HasPrefix(events[0].Kind.Error.StackTrace[0], top) {
+		t.Errorf("expected the caller's site %q first, got %v", top, events[0].Kind.Error.StackTrace)
+	}
+}
@@ -0,0 +1,195 @@
+package raceway
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event ID formats for Config.IDFormat.
+const (
+	// IDFormatUUID4 makes event IDs random (version 4) UUIDs. It is the default.
+	IDFormatUUID4 = "uuid4"
+	// IDFormatUUID7 makes event IDs time-ordered (version 7) UUIDs, which
+	// keep a server's index on event IDs in capture order.
+	IDFormatUUID7 = "uuid7"
+	// IDFormatULID makes event IDs ULIDs: 26 Crockford base32 characters,
+	// time-ordered like IDFormatUUID7 but not UUIDs. The Raceway server
+	// rejects them, so they are only generated with CapabilityULIDEventIDs
+	// in Config.ForceCapabilities, for collectors that accept them.
+	IDFormatULID = "ulid"
+)
+
+// eventIDBatch is the number of event IDs drawn from crypto/rand at once.
+const eventIDBatch = 64
+
+// eventIDSource holds random bytes for the next event IDs.
+type eventIDSource struct {
+	random [16 * eventIDBatch]byte
+	next   int
+}
+
+// fill copies the random bytes of the next event ID to id, reading a new
+// batch from crypto/rand when needed. It reports false if crypto/rand fails.
+func (s *eventIDSource) fill(id *[16]byte) bool {
+	if s.next == eventIDBatch {
+		if _, err := rand.Read(s.random[:]); err != nil {
+			return false
+		}
+		s.next = 0
+	}
+	copy(id[:], s.random[16*s.next:])
+	s.next++
+	return true
+}
+
+var eventIDSources = sync.Pool{New: func() interface{} {
+	return &eventIDSource{next: eventIDBatch}
+}}
+
+// eventIDFunc returns the generator of event IDs in format, or nil if the
+// format is not supported. Each call returns a generator with its own
+// monotonic state.
+func eventIDFunc(format string) func() string {
+	switch format {
+	case "", IDFormatUUID4:
+		return newEventID
+	case IDFormatUUID7:
+		return (&timeOrderedIDs{now: time.Now}).uuid7
+	case IDFormatULID:
+		return (&timeOrderedIDs{now: time.Now}).ulid
+	}
+	return nil
+}
+
+// newEventID returns a random (version 4) UUID in its canonical string form,
+// as uuid.New().String() does, allocating only the string.
+func newEventID() string {
+	src := eventIDSources.Get().(*eventIDSource)
+	var id [16]byte
+	ok := src.fill(&id)
+	eventIDSources.Put(src)
+	if !ok {
+		return uuid.New().String()
+	}
+
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return formatUUID(id)
+}
+
+// formatUUID returns id in the canonical UUID string form.
+func formatUUID(id [16]byte) string {
+	const hexDigits = "0123456789abcdef"
+	var buf [36]byte
+	j := 0
+	for i, b := range id {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			buf[j] = '-'
+			j++
+		}
+		buf[j], buf[j+1] = hexDigits[b>>4], hexDigits[b&0x0f]
+		j += 2
+	}
+	return string(buf[:])
+}
+
+// timeOrderedIDs generates the IDs of IDFormatUUID7 and IDFormatULID, which
+// start with a millisecond Unix timestamp. IDs generated in the same
+// millisecond, or after the wall clock stepped back, continue from the last
+// one, so every ID sorts after the one before it.
+type timeOrderedIDs struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	source eventIDSource
+	// lastMs is the timestamp of the last ID
+	lastMs uint64
+	// last holds the bytes after the timestamp of the last ULID
+	last [10]byte
+	// seq is the 12-bit counter of the last UUIDv7, in its rand_a field
+	seq uint16
+}
+
+// uuid7Seq bounds the counter a millisecond's first UUIDv7 starts from,
+// leaving room for at least 2048 more IDs in that millisecond.
+const uuid7Seq = 0x800
+
+// uuid7 returns a version 7 UUID in its canonical string form. Its 12-bit
+// rand_a field counts the IDs of the same millisecond from a random start,
+// and the next millisecond is borrowed when it runs out (RFC 9562, method 1).
+func (g *timeOrderedIDs) uuid7() string {
+	var id [16]byte
+	g.mu.Lock()
+	if !g.source.fill(&id) {
+		g.mu.Unlock()
+		return uuid.Must(uuid.NewV7()).String()
+	}
+	ms := uint64(g.now().UnixMilli())
+	switch {
+	case ms > g.lastMs:
+		g.lastMs, g.seq = ms, binary.BigEndian.Uint16(id[6:])%uuid7Seq
+	case g.seq < 0xfff:
+		g.seq++
+	default:
+		g.lastMs++
+		g.seq = binary.BigEndian.Uint16(id[6:]) % uuid7Seq
+	}
+	ms, seq := g.lastMs, g.seq
+	g.mu.Unlock()
+
+	id[0], id[1], id[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	id[3], id[4], id[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+	id[6] = 0x70 | byte(seq>>8) // version 7
+	id[7] = byte(seq)
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return formatUUID(id)
+}
+
+// ulid returns a ULID: a 48-bit millisecond timestamp and 80 random bits,
+// encoded as 26 Crockford base32 characters. An ID in the same millisecond
+// as the last one increments its random bits instead, as the ULID
+// specification's monotonic generator does.
+func (g *timeOrderedIDs) ulid() string {
+	var id [16]byte
+	g.mu.Lock()
+	// Without entropy from crypto/rand, the IDs count on from the last one
+	if ms := uint64(g.now().UnixMilli()); ms > g.lastMs && g.source.fill(&id) {
+		g.lastMs = ms
+		copy(g.last[:], id[6:])
+	} else if !increment(g.last[:]) {
+		// The random bits overflowed; move on to the next millisecond
+		g.lastMs++
+	}
+	ms := g.lastMs
+	copy(id[6:], g.last[:])
+	g.mu.Unlock()
+
+	id[0], id[1], id[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	id[3], id[4], id[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+
+	// 26 characters of 5 bits each encode the 128 bits, the first holding 3
+	var buf [26]byte
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// increment adds one to the big-endian number b, reporting false if it
+// overflowed to zero.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewEventIDIsUUIDv4(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 3*eventIDBatch; i++ {
+		id := newEventID()
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			t.Fatalf("%q is not a UUID: %v", id, err)
+		}
+		if parsed.Version() != 4 || parsed.Variant() != uuid.RFC4122 || parsed.String() != id {
+			t.Errorf("expected a canonical version 4 UUID, got %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate event ID %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+// idTimestamp returns the millisecond timestamp an IDFormatUUID7 or
+// IDFormatULID ID starts with.
+func idTimestamp(t *testing.T, format, id string) uint64 {
+	t.Helper()
+	var raw [16]byte
+	if format == IDFormatULID {
+		var ok bool
+		if raw, ok = decodeULID(id); !ok {
+			t.Fatalf("expected a ULID, got %q", id)
+		}
+	} else {
+		parsed, err := uuid.Parse(id)
+		if err != nil || parsed.Version() != 7 || parsed.Variant() != uuid.RFC4122 {
+			t.Fatalf("expected a version 7 UUID, got %q", id)
+		}
+		raw = parsed
+	}
+	var ms uint64
+	for _, b := range raw[:6] {
+		ms = ms<<8 | uint64(b)
+	}
+	return ms
+}
+
+func TestTimeOrderedIDsConcurrent(t *testing.T) {
+	const goroutines, perGoroutine = 8, 12500
+	for _, format := range []string{IDFormatUUID7, IDFormatULID} {
+		t.Run(format, func(t *testing.T) {
+			next := eventIDFunc(format)
+			ids := make([][]string, goroutines)
+			var wg sync.WaitGroup
+			for g := range ids {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					ids[g] = make([]string, perGoroutine)
+					for i := range ids[g] {
+						ids[g][i] = next()
+					}
+				}(g)
+			}
+			wg.Wait()
+
+			seen := make(map[string]bool, goroutines*perGoroutine)
+			for _, own := range ids {
+				var lastMs uint64
+				for i, id := range own {
+					if seen[id] {
+						t.Fatalf("duplicate event ID %q", id)
+					}
+					seen[id] = true
+					if ms := idTimestamp(t, format, id); ms < lastMs {
+						t.Fatalf("timestamp of %q went back from %d to %d", id, lastMs, ms)
+					} else {
+						lastMs = ms
+					}
+					if i > 0 && id <= own[i-1] {
+						t.Fatalf("expected %q to sort after %q", id, own[i-1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTimeOrderedIDsWithinOneMillisecond(t *testing.T) {
+	at := time.UnixMilli(1469918176385)
+	for _, format := range []string{IDFormatUUID7, IDFormatULID} {
+		g := &timeOrderedIDs{now: func() time.Time { return at }}
+		next := g.uuid7
+		if format == IDFormatULID {
+			next = g.ulid
+		}
+		// More IDs than the UUIDv7 counter holds in one millisecond
+		previous := next()
+		for i := 0; i < 5000; i++ {
+			id := next()
+			if id <= previous {
+				t.Fatalf("%s: expected %q to sort after %q", format, id, previous)
+			}
+			previous = id
+		}
+		if first := idTimestamp(t, format, previous); first < uint64(at.UnixMilli()) {
+			t.Errorf("%s: expected timestamps from %d, got %d", format, at.UnixMilli(), first)
+		}
+
+		// A wall clock stepping back does not reorder the IDs
+		at = at.Add(-time.Second)
+		if id := next(); id <= previous {
+			t.Errorf("%s: expected %q to sort after %q after the clock stepped back", format, id, previous)
+		}
+		at = at.Add(time.Second)
+	}
+}
+
+func TestULIDEncoding(t *testing.T) {
+	// The timestamp of the example in the ULID specification
+	g := &timeOrderedIDs{now: func() time.Time { return time.UnixMilli(1469918176385) }}
+	id := g.ulid()
+	if !strings.HasPrefix(id, "01ARYZ6S41") || idTimestamp(t, IDFormatULID, id) != 1469918176385 {
+		t.Errorf("expected a ULID starting 01ARYZ6S41, got %q", id)
+	}
+}
+
+func TestConfigIDFormat(t *testing.T) {
+	for format, valid := range map[string]func(string) bool{
+		"":            func(id string) bool { return uuid.MustParse(id).Version() == 4 },
+		IDFormatUUID7: func(id string) bool { return uuid.MustParse(id).Version() == 7 },
+		IDFormatULID: func(id string) bool {
+			_, ok := decodeULID(id)
+			return ok
+		},
+	} {
+		c := newBufferingClient(t, func(cfg *Config) { cfg.IDFormat = format })
+		ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+		c.TrackStateChange(ctx, "balance", nil, 100, "eventid_test.go:1", "Write")
+		if id := bufferedEvents(c)[0].ID; !valid(id) {
+			t.Errorf("format %q: unexpected event ID %q", format, id)
+		}
+	}
+
+	logger := &capturingLogger{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.IDFormat = "snowflake"
+		cfg.Logger = logger
+	})
+	if warnings := logger.logged("warn"); len(warnings) != 1 || !strings.Contains(warnings[0], "snowflake") {
+		t.Errorf("expected a warning about the format, got %q", warnings)
+	}
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", nil, 100, "eventid_test.go:2", "Write")
+	if id := bufferedEvents(c)[0].ID; uuid.MustParse(id).Version() != 4 {
+		t.Errorf("expected a version 4 UUID for an unsupported format, got %q", id)
+	}
+}
+
+func TestULIDNeedsForcedCapability(t *testing.T) {
+	logger := &capturingLogger{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.IDFormat = IDFormatULID
+		cfg.ForceCapabilities = nil
+		cfg.Logger = logger
+	})
+	if warnings := logger.logged("warn"); len(warnings) != 1 || !strings.Contains(warnings[0], "CapabilityULIDEventIDs") {
+		t.Errorf("expected a warning about the capability, got %q", warnings)
+	}
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", nil, 100, "eventid_test.go:3", "Write")
+	if id := bufferedEvents(c)[0].ID; uuid.MustParse(id).Version() != 7 {
+		t.Errorf("expected ULIDs to fall back to version 7 UUIDs, got %q", id)
+	}
+}
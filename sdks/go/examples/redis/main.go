@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// Example tracking Redis operations with TrackCacheOp. The cache read-modify-
+// write below races: two requests read the same balance and both write back.
+//
+// fakeRedis stands in for a Redis client such as go-redis, so the example
+// runs without a Redis server; wrap your client's calls the same way.
+
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (r *fakeRedis) Get(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.data[key]
+	return v, ok
+}
+
+func (r *fakeRedis) Set(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.data[key] = value
+}
+
+// trackedRedis records every call as a CacheOp event.
+type trackedRedis struct {
+	client *raceway.Client
+	rdb    *fakeRedis
+}
+
+func (r trackedRedis) Get(ctx context.Context, key string) (string, bool) {
+	start := time.Now()
+	v, ok := r.rdb.Get(key)
+	r.client.TrackCacheOp(ctx, "redis", "GET", key, &ok, time.Since(start))
+	return v, ok
+}
+
+func (r trackedRedis) Set(ctx context.Context, key, value string) {
+	start := time.Now()
+	r.rdb.Set(key, value)
+	r.client.TrackCacheOp(ctx, "redis", "SET", key, nil, time.Since(start))
+}
+
+func main() {
+	config := raceway.DefaultConfig()
+	config.ServiceName = "redis-example"
+	client := raceway.New(config)
+	defer client.Shutdown()
+
+	cache := trackedRedis{client: client, rdb: &fakeRedis{data: map[string]string{"balance:alice": "1000"}}}
+
+	// Two withdrawals, each a request of its own, read and write the same key
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(request int) {
+			defer wg.Done()
+			ctx := client.StartTrace(context.Background(), "withdraw")
+
+			raw, _ := cache.Get(ctx, "balance:alice")
+			balance, _ := strconv.Atoi(raw)
+			time.Sleep(10 * time.Millisecond)
+			cache.Set(ctx, "balance:alice", strconv.Itoa(balance-100))
+
+			client.TrackExternalCall(ctx, "ledger", "https://ledger.example.com/v1/entries", "record_withdrawal", "201", 5*time.Millisecond)
+			fmt.Printf("request %d: balance %d -> %d\n", request, balance, balance-100)
+		}(i)
+	}
+	wg.Wait()
+
+	final, _ := cache.Get(context.Background(), "balance:alice")
+	fmt.Printf("final balance: %s (expected 800)\n", final)
+	fmt.Println("Both requests' accesses to redis:balance:alice are in Raceway for race analysis.")
+}
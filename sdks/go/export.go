@@ -0,0 +1,164 @@
+package raceway
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TraceExportFormat identifies the documents written by ExportTrace.
+const TraceExportFormat = "raceway.trace.v1"
+
+// ErrTraceNotRetained is returned by ExportTrace for a trace the client has
+// no copy of: Config.RetainTraces is off, the trace captured no events, or it
+// was evicted to stay within Config.MaxRetainedTraces.
+var ErrTraceNotRetained = errors.New("raceway: trace not retained")
+
+// TraceExport is the document written by ExportTrace: the events one client
+// captured for a trace, with the service and SDK that captured them.
+type TraceExport struct {
+	// Format is TraceExportFormat
+	Format      string `json:"format"`
+	TraceID     string `json:"trace_id"`
+	ExportedAt  string `json:"exported_at"`
+	ServiceName string `json:"service_name"`
+	InstanceID  string `json:"instance_id"`
+	Environment string `json:"environment,omitempty"`
+	Region      string `json:"region,omitempty"`
+	SDKLanguage string `json:"sdk_language"`
+	SDKVersion  string `json:"sdk_version"`
+	// Events are in capture order, as recorded before any downgrade for the
+	// collector
+	Events []Event `json:"events"`
+}
+
+// ExportTrace writes the events captured so far in the trace of ctx to w as
+// an indented JSON TraceExport, to attach to a bug report or import into the
+// Raceway UI. It requires Config.RetainTraces; events already flushed are
+// included, while events of other services in the trace are not.
+//
+// Example:
+//
+//	f, err := os.Create("transfer-race.json")
+//	if err != nil {
+//	    return err
+//	}
+//	defer f.Close()
+//	return client.ExportTrace(ctx, f)
+func (c *Client) ExportTrace(ctx context.Context, w io.Writer) error {
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return errors.New("raceway: ExportTrace called outside of Raceway context")
+	}
+	return c.ExportTraceByID(rctx.TraceID, w)
+}
+
+// ExportTraceByID is ExportTrace for the trace traceID, such as one read from
+// a log line or a response header.
+func (c *Client) ExportTraceByID(traceID string, w io.Writer) error {
+	if c.retained == nil {
+		return ErrTraceNotRetained
+	}
+	events, ok := c.retained.events(traceID)
+	if !ok {
+		return ErrTraceNotRetained
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(TraceExport{
+		Format:      TraceExportFormat,
+		TraceID:     traceID,
+		ExportedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+		ServiceName: c.config.ServiceName,
+		InstanceID:  c.instanceID,
+		Environment: c.config.Environment,
+		Region:      c.region,
+		SDKLanguage: "go",
+		SDKVersion:  SDKVersion,
+		Events:      events,
+	})
+}
+
+// retainedTraces keeps a copy of the events of the most recently captured
+// traces for ExportTrace. Events are never modified in place once captured,
+// so the copies share their data with the events on their way to the sinks.
+type retainedTraces struct {
+	limit int
+
+	mu     sync.Mutex
+	traces map[string]*list.Element
+	// recent holds a *retainedTrace per trace, most recently captured first
+	recent *list.List
+}
+
+// retainedTrace is the events retained for one trace, in the order they
+// were added.
+type retainedTrace struct {
+	traceID string
+	events  []Event
+}
+
+func newRetainedTraces(limit int) *retainedTraces {
+	return &retainedTraces{
+		limit:  limit,
+		traces: make(map[string]*list.Element),
+		recent: list.New(),
+	}
+}
+
+// add retains a copy of event, evicting the least recently captured trace if
+// there are more than limit.
+func (r *retainedTraces) add(event Event) {
+	event.live, event.encoded = nil, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.traces[event.TraceID]; ok {
+		trace := elem.Value.(*retainedTrace)
+		trace.events = append(trace.events, event)
+		r.recent.MoveToFront(elem)
+		return
+	}
+	r.traces[event.TraceID] = r.recent.PushFront(&retainedTrace{traceID: event.TraceID, events: []Event{event}})
+	for r.recent.Len() > r.limit {
+		oldest := r.recent.Back()
+		r.recent.Remove(oldest)
+		delete(r.traces, oldest.Value.(*retainedTrace).traceID)
+	}
+}
+
+// edit applies edit to the retained copy of an event of traceID, as
+// editBufferedEvent does to the buffered one.
+func (r *retainedTraces) edit(traceID, eventID string, edit func(*Event)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elem, ok := r.traces[traceID]
+	if !ok {
+		return
+	}
+	events := elem.Value.(*retainedTrace).events
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].ID == eventID {
+			edit(&events[i])
+			return
+		}
+	}
+}
+
+// events returns a copy of the events retained for traceID, which can be
+// read without the lock.
+func (r *retainedTraces) events(traceID string) ([]Event, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elem, ok := r.traces[traceID]
+	if !ok {
+		return nil, false
+	}
+	return append([]Event(nil), elem.Value.(*retainedTrace).events...), true
+}
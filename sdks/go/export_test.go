@@ -0,0 +1,166 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func exportTrace(t *testing.T, c *Client, traceID string) TraceExport {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.ExportTraceByID(traceID, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var export TraceExport
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatalf("export is not valid JSON: %v", err)
+	}
+	return export
+}
+
+func TestExportTrace(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.RetainTraces = true
+		cfg.Environment = "test"
+		cfg.Sink = discardSink{}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	other := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "balance", nil, 100, "export_test.go:1", "Read")
+	c.TrackStateChange(other, "balance", nil, 100, "export_test.go:2", "Read")
+	// Events already sent are exported too
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	c.Go(ctx, "audit", func(ctx context.Context) {
+		defer close(done)
+		c.TrackCustom(ctx, "audit", map[string]interface{}{"amount": 100})
+	})
+	<-done
+	c.TrackStateChange(ctx, "balance", 100, 50, "export_test.go:3", "Write")
+
+	var buf bytes.Buffer
+	if err := c.ExportTrace(ctx, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var export TraceExport
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatalf("export is not valid JSON: %v", err)
+	}
+	traceID := FromContext(ctx).TraceID
+	if export.Format != TraceExportFormat || export.TraceID != traceID || export.ExportedAt == "" {
+		t.Errorf("unexpected header %+v", export)
+	}
+	if export.ServiceName != "test-service" || export.InstanceID != "test-instance" || export.Environment != "test" ||
+		export.SDKLanguage != "go" || export.SDKVersion != SDKVersion {
+		t.Errorf("unexpected service metadata %+v", export)
+	}
+	if len(export.Events) != 4 {
+		t.Fatalf("expected the trace's 4 events, got %d", len(export.Events))
+	}
+	for i, event := range export.Events {
+		if err := ValidateEvent(event); err != nil {
+			t.Errorf("event %d is invalid: %v", i, err)
+		}
+		if event.TraceID != traceID {
+			t.Errorf("event %d belongs to trace %s", i, event.TraceID)
+		}
+		if i > 0 && event.Seq <= export.Events[i-1].Seq {
+			t.Errorf("event %d is out of capture order", i)
+		}
+	}
+	if write := export.Events[3].Kind.StateChange; write == nil || write.Location != "export_test.go:3" {
+		t.Errorf("expected the write last, got %+v", export.Events[3].Kind)
+	}
+}
+
+func TestExportTraceIncludesLaterEdits(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.RetainTraces = true })
+	var traceID string
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = FromContext(r.Context()).TraceID
+		c.SetRoute(r.Context(), "/accounts/{id}")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/accounts/alice", nil))
+
+	export := exportTrace(t, c, traceID)
+	if len(export.Events) == 0 || export.Events[0].Kind.HTTPRequest == nil {
+		t.Fatalf("expected the request first, got %+v", export.Events)
+	}
+	if got := export.Events[0].Metadata.Tags[routeTag]; got != "/accounts/{id}" {
+		t.Errorf("expected the route tagged after capture, got %q", got)
+	}
+}
+
+func TestExportTraceEvictsLeastRecentlyActive(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.RetainTraces = true
+		cfg.MaxRetainedTraces = 2
+	})
+	var traces []context.Context
+	for i := 0; i < 3; i++ {
+		ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+		c.TrackCustom(ctx, "step", nil)
+		traces = append(traces, ctx)
+		if i == 1 {
+			// The first trace is active again, so the second is evicted
+			c.TrackCustom(traces[0], "step", nil)
+		}
+	}
+
+	if err := c.ExportTrace(traces[1], &bytes.Buffer{}); !errors.Is(err, ErrTraceNotRetained) {
+		t.Errorf("expected the second trace evicted, got %v", err)
+	}
+	if export := exportTrace(t, c, FromContext(traces[0]).TraceID); len(export.Events) != 2 {
+		t.Errorf("expected both events of the first trace, got %d", len(export.Events))
+	}
+	if export := exportTrace(t, c, FromContext(traces[2]).TraceID); len(export.Events) != 1 {
+		t.Errorf("expected the event of the third trace, got %d", len(export.Events))
+	}
+}
+
+func TestExportTraceRequiresRetention(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackCustom(ctx, "step", nil)
+
+	if err := c.ExportTrace(ctx, &bytes.Buffer{}); !errors.Is(err, ErrTraceNotRetained) {
+		t.Errorf("expected ErrTraceNotRetained without RetainTraces, got %v", err)
+	}
+	if err := c.ExportTrace(context.Background(), &bytes.Buffer{}); err == nil {
+		t.Error("expected an error outside of a Raceway context")
+	}
+}
+
+func TestExportTraceConcurrentWithCapture(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.RetainTraces = true })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackCustom(ctx, "step", nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			c.TrackStateChange(ctx, "counter", i, i+1, "export_test.go:1", "Write")
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := c.ExportTrace(ctx, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if export := exportTrace(t, c, FromContext(ctx).TraceID); len(export.Events) != 201 {
+		t.Errorf("expected every event exported, got %d", len(export.Events))
+	}
+}
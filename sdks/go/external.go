@@ -0,0 +1,52 @@
+package raceway
+
+import (
+	"context"
+	"time"
+)
+
+// TrackExternalCall tracks a call to a third-party API, such as a payment
+// provider, taking duration. system names the provider, such as "stripe",
+// endpoint is the URL or method called, and operation what the call does,
+// such as "create_charge". status is the outcome as the provider reports it,
+// such as "200" or "card_declined". The caller's location is recorded.
+//
+// Example:
+//
+//	start := time.Now()
+//	charge, err := stripe.Charges.New(params)
+//	client.TrackExternalCall(ctx, "stripe", "/v1/charges", "create_charge", status(err), time.Since(start))
+func (c *Client) TrackExternalCall(ctx context.Context, system, endpoint, operation, status string, duration time.Duration) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.captureEvent(ctx, EventKind{
+		ExternalCall: &ExternalCallData{
+			System:     system,
+			Endpoint:   endpoint,
+			Operation:  operation,
+			Status:     status,
+			DurationNs: duration.Nanoseconds(),
+			Location:   c.captureLocation(2),
+		},
+	})
+}
+
+// downgradeExternalCall re-encodes an ExternalCall as a FunctionCall for
+// collectors that do not understand the ExternalCall kind.
+func downgradeExternalCall(event Event) Event {
+	data := event.Kind.ExternalCall
+	event.Kind = EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "external_call:" + data.System + ":" + data.Operation,
+			Module:       "raceway.external",
+			Args: map[string]interface{}{
+				"endpoint":    data.Endpoint,
+				"status":      data.Status,
+				"duration_ns": data.DurationNs,
+			},
+			File: data.Location,
+		},
+	}
+	return event
+}
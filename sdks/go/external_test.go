@@ -0,0 +1,45 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackExternalCall(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := c.newContext(context.Background(), "")
+	c.TrackExternalCall(ctx, "stripe", "/v1/charges", "create_charge", "card_declined", 80*time.Millisecond)
+
+	events := bufferedEvents(c)
+	call := events[0].Kind.ExternalCall
+	if call == nil || events[0].Kind.Name() != "ExternalCall" {
+		t.Fatalf("expected an ExternalCall event, got %+v", events[0].Kind)
+	}
+	if call.System != "stripe" || call.Endpoint != "/v1/charges" || call.Operation != "create_charge" || call.Status != "card_declined" {
+		t.Errorf("unexpected external call %+v", call)
+	}
+	if call.DurationNs != int64(80*time.Millisecond) || !strings.HasPrefix(call.Location, "external_test.go:") {
+		t.Errorf("expected the duration and the caller's location, got %d and %s", call.DurationNs, call.Location)
+	}
+}
+
+func TestExternalCallDowngradedWithoutCapability(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ForceCapabilities = nil })
+	ctx := c.newContext(context.Background(), "")
+	c.TrackExternalCall(ctx, "stripe", "/v1/charges", "create_charge", "200", time.Millisecond)
+	c.TrackCacheOp(ctx, "redis", "DEL", "session:1", nil, time.Millisecond)
+
+	events := bufferedEvents(c)
+	c.downgradeEvents(events)
+	call := events[0].Kind.FunctionCall
+	if call == nil || call.FunctionName != "external_call:stripe:create_charge" || call.Args.(map[string]interface{})["status"] != "200" {
+		t.Errorf("expected the external call downgraded to a FunctionCall, got %+v", events[0].Kind)
+	}
+	if change := events[1].Kind.StateChange; change == nil || change.Variable != "redis:session:1" || change.AccessType != "Write" {
+		t.Errorf("expected the cache operation downgraded to a StateChange, got %+v", events[1].Kind)
+	}
+}
@@ -0,0 +1,247 @@
+package raceway
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxFenceNames bounds the fence epochs carried by a context and its
+// raceway-clock header. The least recently advanced name is evicted first.
+const maxFenceNames = 16
+
+const (
+	// maxFenceReleases bounds the release history kept per fence name. When exceeded,
+	// the two oldest releases are merged, which can only drop ordering, never invent it.
+	maxFenceReleases = 8
+	// maxFenceRegistryNames bounds the fence names tracked by a client.
+	maxFenceRegistryNames = 1024
+)
+
+// fenceRegistry orders fences within a process: a release publishes the
+// releasing context's clock, and an acquire at a higher epoch merges every
+// clock published at a lower epoch, making the fenced accesses causally ordered.
+type fenceRegistry struct {
+	mu    sync.Mutex
+	names map[string][]fenceRelease
+	order []string
+}
+
+type fenceRelease struct {
+	epoch uint64
+	clock []CausalityEntry
+}
+
+func (r *fenceRegistry) release(name string, epoch uint64, clock []CausalityEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names == nil {
+		r.names = make(map[string][]fenceRelease)
+	}
+	releases, ok := r.names[name]
+	if !ok {
+		if len(r.order) >= maxFenceRegistryNames {
+			delete(r.names, r.order[0])
+			r.order = r.order[1:]
+		}
+		r.order = append(r.order, name)
+	}
+
+	i := sort.Search(len(releases), func(i int) bool { return releases[i].epoch >= epoch })
+	if i < len(releases) && releases[i].epoch == epoch {
+		releases[i].clock = MergeClockVectors(releases[i].clock, clock)
+	} else {
+		releases = append(releases, fenceRelease{})
+		copy(releases[i+1:], releases[i:])
+		releases[i] = fenceRelease{epoch: epoch, clock: MergeClockVectors(nil, clock)}
+	}
+	if len(releases) > maxFenceReleases {
+		releases[1].clock = MergeClockVectors(releases[0].clock, releases[1].clock)
+		releases = releases[1:]
+	}
+	r.names[name] = releases
+}
+
+// acquire returns the merged clocks of every release of name below epoch.
+func (r *fenceRegistry) acquire(name string, epoch uint64) []CausalityEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var merged []CausalityEntry
+	for _, release := range r.names[name] {
+		if release.epoch >= epoch {
+			break
+		}
+		merged = MergeClockVectors(merged, release.clock)
+	}
+	return merged
+}
+
+// fenceEpochs is the latest observed epoch per fence name, in the order the
+// names were last advanced.
+type fenceEpochs struct {
+	epochs map[string]uint64
+	order  []string
+}
+
+// observe records epoch for name if it is newer than the known epoch.
+// It reports whether the epoch advanced.
+func (f *fenceEpochs) observe(name string, epoch uint64) bool {
+	if f.epochs == nil {
+		f.epochs = make(map[string]uint64)
+	}
+	if current, ok := f.epochs[name]; ok {
+		if epoch <= current {
+			return false
+		}
+		for i, n := range f.order {
+			if n == name {
+				f.order = append(f.order[:i], f.order[i+1:]...)
+				break
+			}
+		}
+	} else if len(f.order) >= maxFenceNames {
+		delete(f.epochs, f.order[0])
+		f.order = f.order[1:]
+	}
+	f.epochs[name] = epoch
+	f.order = append(f.order, name)
+	return true
+}
+
+func (f *fenceEpochs) copy() *fenceEpochs {
+	if f == nil {
+		return nil
+	}
+	copied := &fenceEpochs{
+		epochs: make(map[string]uint64, len(f.epochs)),
+		order:  append([]string(nil), f.order...),
+	}
+	for k, v := range f.epochs {
+		copied.epochs[k] = v
+	}
+	return copied
+}
+
+// snapshot returns the epochs as a map suitable for the raceway-clock payload.
+func (f *fenceEpochs) snapshot() map[string]uint64 {
+	if f == nil || len(f.epochs) == 0 {
+		return nil
+	}
+	snapshot := make(map[string]uint64, len(f.epochs))
+	for k, v := range f.epochs {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// Fence records that every access made in ctx so far happens-before every access
+// made after a fence with the same name and a higher epoch, in any context or
+// service. Use it for synchronization the SDK cannot see, such as a Kafka
+// rebalance barrier or a leader-election fence token.
+//
+// Fence is both a ReleaseFence and an AcquireFence. Within a process, fences are
+// enforced through the vector clock: acquiring merges the clocks released at lower
+// epochs. The latest epoch observed per name also travels in the raceway-clock
+// header so the server can order fences across services. Fence is a no-op if
+// ctx has not been used with a Client.
+func Fence(ctx context.Context, name string, epoch uint64) {
+	emitFence(ctx, name, epoch, FenceFull)
+}
+
+// ReleaseFence publishes the accesses made in ctx so far: they happen-before
+// anything after an AcquireFence or Fence with the same name and a higher epoch.
+// Accesses after the release are not ordered by it.
+func ReleaseFence(ctx context.Context, name string, epoch uint64) {
+	emitFence(ctx, name, epoch, FenceRelease)
+}
+
+// AcquireFence orders the accesses made after it in ctx after everything
+// published by a ReleaseFence or Fence with the same name and a lower epoch.
+// Accesses before the acquire are not ordered by it.
+func AcquireFence(ctx context.Context, name string, epoch uint64) {
+	emitFence(ctx, name, epoch, FenceAcquire)
+}
+
+func emitFence(ctx context.Context, name string, epoch uint64, direction string) {
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return
+	}
+	client := rctx.boundClient()
+	if client == nil {
+		return
+	}
+	client.trackFence(ctx, rctx, name, epoch, direction, client.captureLocation(3))
+}
+
+func (c *Client) trackFence(ctx context.Context, rctx *RacewayContext, name string, epoch uint64, direction, location string) {
+	rctx.mergeFences(map[string]uint64{name: epoch})
+
+	if direction != FenceRelease {
+		if published := c.fences.acquire(name, epoch); len(published) > 0 {
+			rctx.mergeClock(published)
+		}
+	}
+
+	c.captureEvent(ctx, EventKind{
+		Fence: &FenceData{
+			Name:      name,
+			Epoch:     epoch,
+			Direction: direction,
+			Location:  location,
+		},
+	})
+
+	if direction != FenceAcquire {
+		vector, _ := rctx.clock()
+		c.fences.release(name, epoch, vector)
+	}
+}
+
+// mergeFences folds incoming fence epochs into rctx.
+func (r *RacewayContext) mergeFences(incoming map[string]uint64) {
+	if len(incoming) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fences == nil {
+		r.fences = &fenceEpochs{}
+	}
+	for name, epoch := range incoming {
+		r.fences.observe(name, epoch)
+	}
+}
+
+// fenceSnapshot returns rctx's fence epochs, or nil if it has observed none.
+func (r *RacewayContext) fenceSnapshot() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fences.snapshot()
+}
+
+// downgradeFence re-encodes a Fence event as a FunctionCall for collectors that
+// do not understand the Fence kind.
+func downgradeFence(event Event) Event {
+	data := event.Kind.Fence
+	file, line := data.Location, 0
+	if i := strings.LastIndex(file, ":"); i >= 0 {
+		line, _ = strconv.Atoi(file[i+1:])
+		file = file[:i]
+	}
+	event.Kind = EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "fence:" + data.Name,
+			Module:       "raceway.fence",
+			Args: map[string]interface{}{
+				"epoch":     strconv.FormatUint(data.Epoch, 10),
+				"direction": data.Direction,
+			},
+			File: file,
+			Line: line,
+		},
+	}
+	return event
+}
@@ -0,0 +1,143 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func clockValue(vector []CausalityEntry, component string) uint64 {
+	for _, entry := range vector {
+		if entry.Component() == component {
+			return entry.Value()
+		}
+	}
+	return 0
+}
+
+func TestFenceOrdersAccessesAcrossContexts(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctxA := NewContext(context.Background(), "trace", "test-service", "a")
+	ctxB := NewContext(context.Background(), "trace", "test-service", "b")
+	ctxC := NewContext(context.Background(), "trace", "test-service", "c")
+
+	c.TrackStateChange(ctxA, "offset", 0, 1, "consumer.go:1", "Write")
+	Fence(ctxA, "rebalance", 1)
+
+	// ctxC has not been used with the client yet, so the fence is a no-op.
+	Fence(ctxC, "rebalance", 2)
+
+	c.TrackFunctionCall(ctxB, "poll", "consumer", nil, "consumer.go", 2)
+	AcquireFence(ctxB, "rebalance", 1)
+	c.TrackStateChange(ctxB, "offset", 1, 2, "consumer.go:3", "Write")
+	if got := clockValue(FromContext(ctxB).ClockVector, "test-service#a"); got != 0 {
+		t.Fatalf("acquire at the same epoch must not order after the release, got a=%d", got)
+	}
+
+	AcquireFence(ctxB, "rebalance", 2)
+	c.TrackStateChange(ctxB, "offset", 2, 3, "consumer.go:4", "Write")
+
+	events := bufferedEvents(c)
+	writeA := events[0]
+	writeB := events[len(events)-1]
+	if got, want := clockValue(writeB.CausalityVector, "test-service#a"), clockValue(writeA.CausalityVector, "test-service#a"); got < want {
+		t.Errorf("write after acquire does not happen-after the fenced write: a=%d, want >= %d", got, want)
+	}
+
+	var fences []*FenceData
+	for _, e := range events {
+		if e.Kind.Fence != nil {
+			fences = append(fences, e.Kind.Fence)
+		}
+	}
+	if len(fences) != 3 || fences[0].Direction != FenceFull || fences[2].Direction != FenceAcquire || fences[2].Epoch != 2 {
+		t.Errorf("unexpected fence events %+v", fences)
+	}
+}
+
+func TestReleaseFenceDoesNotOrderLaterAccesses(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctxA := NewContext(context.Background(), "trace", "test-service", "a")
+	ctxB := NewContext(context.Background(), "trace", "test-service", "b")
+
+	c.TrackStateChange(ctxA, "leader", nil, "a", "election.go:1", "Write")
+	ReleaseFence(ctxA, "leader", 7)
+	released := clockValue(FromContext(ctxA).ClockVector, "test-service#a")
+	c.TrackStateChange(ctxA, "leader", "a", "a2", "election.go:2", "Write")
+
+	c.TrackFunctionCall(ctxB, "follow", "election", nil, "election.go", 3)
+	AcquireFence(ctxB, "leader", 8)
+	if got := clockValue(FromContext(ctxB).ClockVector, "test-service#a"); got != released {
+		t.Errorf("acquire merged a=%d, want the released clock a=%d", got, released)
+	}
+}
+
+func TestFenceEpochsPropagateAcrossServices(t *testing.T) {
+	upstream := newBufferingClient(t, nil)
+	downstream := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName = "downstream" })
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	upstream.TrackFunctionCall(ctx, "handler", "main", nil, "main.go", 1)
+	Fence(ctx, "rebalance", 4)
+	Fence(ctx, "leader", 9)
+	Fence(ctx, "rebalance", 3) // older epochs never move a fence backwards
+
+	headers, err := upstream.PropagationHeaders(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	parsed := ParseIncomingHeaders(h, "downstream", "test-instance")
+	if parsed.Fences["rebalance"] != 4 || parsed.Fences["leader"] != 9 {
+		t.Fatalf("unexpected propagated fences %v", parsed.Fences)
+	}
+
+	downCtx := downstream.contextFromParsed(context.Background(), parsed)
+	rctx := FromContext(downCtx)
+	if rctx.fences.epochs["rebalance"] != 4 || rctx.client != downstream {
+		t.Errorf("expected downstream context to carry fences and its client, got %v", rctx.fences.epochs)
+	}
+}
+
+func TestFenceEpochsAreBounded(t *testing.T) {
+	var f fenceEpochs
+	for i := 0; i < maxFenceNames+4; i++ {
+		f.observe(fmt.Sprintf("fence-%d", i), 1)
+	}
+	if len(f.epochs) != maxFenceNames {
+		t.Fatalf("expected %d fence names, got %d", maxFenceNames, len(f.epochs))
+	}
+	if _, ok := f.epochs["fence-0"]; ok {
+		t.Errorf("expected the oldest fence name to be evicted")
+	}
+
+	// Advancing a name makes it the most recent, so it survives the next eviction.
+	f.observe("fence-4", 2)
+	f.observe("fence-new", 1)
+	if _, ok := f.epochs["fence-4"]; !ok {
+		t.Errorf("expected recently advanced fence to be kept")
+	}
+	if _, ok := f.epochs["fence-5"]; ok {
+		t.Errorf("expected least recently advanced fence to be evicted")
+	}
+}
+
+func TestFenceDowngradedWithoutCapability(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ForceCapabilities = nil })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackFunctionCall(ctx, "handler", "main", nil, "main.go", 1)
+	Fence(ctx, "rebalance", 1)
+
+	events := bufferedEvents(c)
+	c.downgradeEvents(events)
+	call := events[len(events)-1].Kind.FunctionCall
+	if call == nil || call.FunctionName != "fence:rebalance" || call.Line == 0 {
+		t.Errorf("expected fence downgraded to a FunctionCall, got %+v", events[len(events)-1].Kind)
+	}
+}
@@ -0,0 +1,245 @@
+package raceway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileSink writes events to a file as newline-delimited JSON, one event per
+// line, for environments that cannot reach a Raceway server. The file can be
+// sent to a server later with ReplayFile.
+//
+//	sink := &raceway.FileSink{Path: "raceway-events.ndjson", MaxBytes: 64 << 20}
+//	client := raceway.New(raceway.Config{ServiceName: "api", Sink: sink})
+type FileSink struct {
+	// Path is the file events are appended to; it is created if needed
+	Path string
+	// MaxBytes rotates the file before a batch would grow it past MaxBytes:
+	// the file is renamed to Path.1 and older files shift to Path.2 and so on.
+	// A batch is never split across files (default: no rotation)
+	MaxBytes int64
+	// MaxBackups is how many rotated files are kept (default: all)
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Send appends events to the file, rotating it first if MaxBytes would be
+// exceeded.
+func (s *FileSink) Send(ctx context.Context, events []Event) error {
+	var buf bytes.Buffer
+	for i := range events {
+		data := events[i].encoded
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(events[i]); err != nil {
+				return permanent(fmt.Errorf("raceway: marshaling events: %w", err))
+			}
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openLocked(); err != nil {
+		return err
+	}
+	if s.MaxBytes > 0 && s.size > 0 && s.size+int64(buf.Len()) > s.MaxBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("raceway: writing %s: %w", s.Path, err)
+	}
+	return nil
+}
+
+// Close closes the file. A later Send reopens it.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileSink) openLocked() error {
+	if s.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("raceway: opening %s: %w", s.Path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("raceway: opening %s: %w", s.Path, err)
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotateLocked shifts the current file and its backups up by one and opens
+// a new, empty file at Path.
+func (s *FileSink) rotateLocked() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("raceway: rotating %s: %w", s.Path, err)
+	}
+	s.file = nil
+
+	last := s.MaxBackups
+	if last <= 0 {
+		// Keep everything: shift up to the first unused backup number
+		for last = 1; ; last++ {
+			if _, err := os.Stat(s.backupPath(last)); errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+		}
+	}
+	if err := os.Remove(s.backupPath(last)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("raceway: rotating %s: %w", s.Path, err)
+	}
+	for i := last - 1; i >= 1; i-- {
+		if err := os.Rename(s.backupPath(i), s.backupPath(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("raceway: rotating %s: %w", s.Path, err)
+		}
+	}
+	if err := os.Rename(s.Path, s.backupPath(1)); err != nil {
+		return fmt.Errorf("raceway: rotating %s: %w", s.Path, err)
+	}
+	return s.openLocked()
+}
+
+func (s *FileSink) backupPath(n int) string {
+	return s.Path + "." + strconv.Itoa(n)
+}
+
+// NoopSink discards every event. Use it as Config.Sink to run instrumented
+// code without a Raceway server.
+type NoopSink struct{}
+
+// Send discards events.
+func (NoopSink) Send(ctx context.Context, events []Event) error { return nil }
+
+// DrainTo empties the event buffer, including events requeued after failed
+// flushes, into w as newline-delimited JSON in the format FileSink writes,
+// and returns how many events were written. It is meant for a SIGTERM
+// handler that cannot wait for the server; the file can be sent later with
+// ReplayFile:
+//
+//	<-sigterm
+//	f, err := os.Create("raceway-pending.ndjson")
+//	if err == nil {
+//	    client.DrainTo(f)
+//	    f.Close()
+//	}
+//
+// Events not written because w failed stay buffered.
+func (c *Client) DrainTo(w io.Writer) (int, error) {
+	c.syncPipeline(context.Background())
+	events := c.takeBuffered()
+
+	written := 0
+	for i := range events {
+		data := events[i].encoded
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(events[i]); err != nil {
+				c.recordDropped(1)
+				c.eventsDropped(DropMarshalError, events[i:i+1])
+				continue
+			}
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			c.requeue(events[i:])
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// replayBatchSize is the number of events ReplayFile posts per request.
+const replayBatchSize = 1000
+
+// ReplayFile posts the events in a newline-delimited JSON file, as written by
+// FileSink, to the Raceway server at endpoint, e.g. "http://localhost:8080"
+// or "unix:///var/run/raceway.sock".
+// Events are sent in file order in batches; it stops at the first batch the
+// server does not accept.
+func ReplayFile(path, endpoint string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("raceway: replaying %s: %w", path, err)
+	}
+	defer file.Close()
+
+	httpClient, baseURL := newHTTPClient(endpoint, true, 30*time.Second)
+	post := func(batch []json.RawMessage) error {
+		data, err := json.Marshal(struct {
+			Events []json.RawMessage `json:"events"`
+		}{batch})
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Post(baseURL+DefaultEventsPath, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("raceway: replaying %s: %w", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			var body bytes.Buffer
+			body.ReadFrom(resp.Body)
+			return &StatusError{StatusCode: resp.StatusCode, Body: body.String()}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	var batch []json.RawMessage
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("raceway: replaying %s: line %d is not valid JSON", path, line)
+		}
+		batch = append(batch, append(json.RawMessage(nil), data...))
+		if len(batch) == replayBatchSize {
+			if err := post(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("raceway: replaying %s: %w", path, err)
+	}
+	if len(batch) > 0 {
+		return post(batch)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testEvents(prefix string, n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{
+			ID:      prefix + "-" + strconv.Itoa(i),
+			TraceID: "trace",
+			Kind:    EventKind{StateChange: &StateChangeData{Variable: "balance", AccessType: "Write"}},
+		}
+	}
+	return events
+}
+
+// fileEventIDs returns the IDs of the events in an NDJSON file, in order.
+func fileEventIDs(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestFileSinkRotatesAtSizeBoundary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	line, err := json.Marshal(testEvents("a", 1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineSize := int64(len(line) + 1)
+	sink := &FileSink{Path: path, MaxBytes: 2 * lineSize, MaxBackups: 2}
+	defer sink.Close()
+
+	ctx := context.Background()
+	// Two events fill the file exactly; the next batch starts a new file
+	for _, prefix := range []string{"a", "b", "c", "d"} {
+		if err := sink.Send(ctx, testEvents(prefix, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Send(ctx, testEvents("e", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]string{
+		path:        {"e-0"},
+		path + ".1": {"c-0", "d-0"},
+		path + ".2": {"a-0", "b-0"},
+	}
+	for file, want := range files {
+		got := fileEventIDs(t, file)
+		if len(got) != len(want) || got[0] != want[0] || got[len(got)-1] != want[len(want)-1] {
+			t.Errorf("%s: expected %v, got %v", filepath.Base(file), want, got)
+		}
+	}
+
+	// A third rotation drops the oldest backup
+	for _, prefix := range []string{"f", "g"} {
+		if err := sink.Send(ctx, testEvents(prefix, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fileEventIDs(t, path+".2"); len(got) != 2 || got[0] != "c-0" {
+		t.Errorf("expected the oldest backup to be dropped, got %v in events.ndjson.2", got)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, got %v", err)
+	}
+}
+
+func TestFileSinkNeverSplitsABatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink := &FileSink{Path: path, MaxBytes: 1}
+	defer sink.Close()
+
+	for _, prefix := range []string{"a", "b"} {
+		if err := sink.Send(context.Background(), testEvents(prefix, 3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fileEventIDs(t, path); len(got) != 3 || got[0] != "b-0" {
+		t.Errorf("expected the second batch whole in the current file, got %v", got)
+	}
+	if got := fileEventIDs(t, path+".1"); len(got) != 3 || got[0] != "a-0" {
+		t.Errorf("expected the first batch whole in the backup, got %v", got)
+	}
+}
+
+func TestReplayFilePostsEventsInBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink := &FileSink{Path: path}
+	first, second := testEvents("a", replayBatchSize-1), testEvents("b", 2)
+	for _, batch := range [][]Event{first, second} {
+		if err := sink.Send(context.Background(), batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	var mu sync.Mutex
+	var posts [][]Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct{ Events []Event }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posts = append(posts, body.Events)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	if err := ReplayFile(path, server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || len(posts[0]) != replayBatchSize || len(posts[1]) != 1 {
+		t.Fatalf("expected batches of %d and 1 events, got %d posts", replayBatchSize, len(posts))
+	}
+	if posts[0][0].ID != "a-0" || posts[0][replayBatchSize-1].ID != "b-0" || posts[1][0].ID != "b-1" {
+		t.Errorf("expected events in file order, got %s, %s, %s", posts[0][0].ID, posts[0][replayBatchSize-1].ID, posts[1][0].ID)
+	}
+}
+
+func TestReplayFileReportsRejectedBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink := &FileSink{Path: path}
+	sink.Send(context.Background(), testEvents("a", 1))
+	sink.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad batch", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := ReplayFile(path, server.URL)
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a 400 StatusError, got %v", err)
+	}
+}
+
+func TestConfigSinkReplacesServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink := &FileSink{Path: path}
+	c := New(Config{ServiceName: "test-service", BatchSize: 100, FlushInterval: time.Hour, Sink: sink})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", 100, 50, "file_sink_test.go:1", "Write")
+	if err := c.ShutdownContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fileEventIDs(t, path); len(got) != 1 {
+		t.Fatalf("expected the event in the file, got %v", got)
+	}
+	sink.mu.Lock()
+	closed := sink.file == nil
+	sink.mu.Unlock()
+	if !closed {
+		t.Error("expected Shutdown to close the sink")
+	}
+}
+
+func TestNoopSinkDiscardsEvents(t *testing.T) {
+	c := New(Config{ServiceName: "test-service", FlushInterval: time.Hour, Sink: NoopSink{}})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", 100, 50, "file_sink_test.go:1", "Write")
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("expected NoopSink to accept events, got %v", err)
+	}
+	c.Shutdown()
+	if stats := c.Stats(); stats.EventsSent != 1 {
+		t.Errorf("expected the event to be counted as sent, got %+v", stats)
+	}
+}
@@ -0,0 +1,138 @@
+package raceway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// HeartbeatEventType is the Custom event type of heartbeats buffered for
+// servers without a /heartbeat endpoint.
+const HeartbeatEventType = "ServiceHeartbeat"
+
+const (
+	// heartbeatTimeout bounds one heartbeat request.
+	heartbeatTimeout = 10 * time.Second
+	// heartbeatMaxBackoff caps the delay between heartbeats while the server
+	// is unreachable, unless HeartbeatInterval is longer.
+	heartbeatMaxBackoff = 5 * time.Minute
+)
+
+// errHeartbeatUnsupported reports a server that answers /heartbeat with 404.
+var errHeartbeatUnsupported = errors.New("raceway: server has no heartbeat endpoint")
+
+// heartbeatPayload describes this instance and its delivery counters.
+func (c *Client) heartbeatPayload() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := c.Stats()
+	return map[string]interface{}{
+		"service_name":    c.config.ServiceName,
+		"instance_id":     c.instanceID,
+		"sdk_language":    "go",
+		"sdk_version":     SDKVersion,
+		"uptime_ms":       time.Since(c.startedAt).Milliseconds(),
+		"events_buffered": stats.EventsBuffered,
+		"events_sent":     stats.EventsSent,
+		"events_dropped":  stats.EventsDropped,
+		"goroutines":      runtime.NumGoroutine(),
+		"heap_alloc":      mem.HeapAlloc,
+	}
+}
+
+// postHeartbeat sends payload to <Endpoint>/heartbeat.
+func (c *Client) postHeartbeat(ctx context.Context, payload map[string]interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/heartbeat", c.baseURL), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errHeartbeatUnsupported
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("raceway: heartbeat returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// trackHeartbeat buffers payload as a ServiceHeartbeat Custom event in a
+// trace of its own.
+func (c *Client) trackHeartbeat(payload map[string]interface{}) {
+	if c.closed.Load() {
+		return
+	}
+	c.TrackCustom(c.newContext(context.Background(), ""), HeartbeatEventType, payload)
+}
+
+// runHeartbeats reports this instance every Config.HeartbeatInterval until
+// Shutdown, starting at once. Servers that answer 404 get ServiceHeartbeat
+// events instead; while the server is unreachable the interval doubles, up to
+// heartbeatMaxBackoff.
+func (c *Client) runHeartbeats() {
+	interval := c.config.HeartbeatInterval
+	maxDelay := max(interval, heartbeatMaxBackoff)
+	delay := interval
+	unsupported := false
+
+	// Shutdown abandons a heartbeat in flight
+	stopped, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.stopChan
+		cancel()
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-c.stopChan:
+			return
+		}
+
+		payload := c.heartbeatPayload()
+		if unsupported {
+			c.trackHeartbeat(payload)
+			timer.Reset(interval)
+			continue
+		}
+
+		ctx, cancelBeat := context.WithTimeout(stopped, heartbeatTimeout)
+		err := c.postHeartbeat(ctx, payload)
+		cancelBeat()
+
+		switch {
+		case errors.Is(err, errHeartbeatUnsupported):
+			c.logger.Debugf("Server has no heartbeat endpoint; sending %s events instead", HeartbeatEventType)
+			unsupported = true
+			c.trackHeartbeat(payload)
+			delay = interval
+		case err != nil:
+			delay = min(delay*2, maxDelay)
+			c.logger.Debugf("Heartbeat failed, next in %v: %v", delay, err)
+		default:
+			delay = interval
+		}
+		timer.Reset(delay)
+	}
+}
@@ -0,0 +1,149 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// heartbeatServer answers /heartbeat with status and records each heartbeat
+// and when it arrived.
+type heartbeatServer struct {
+	mu       sync.Mutex
+	status   int
+	payloads []map[string]interface{}
+	times    []time.Time
+}
+
+func (s *heartbeatServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/heartbeat" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var payload map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&payload)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloads = append(s.payloads, payload)
+	s.times = append(s.times, time.Now())
+	w.WriteHeader(s.status)
+}
+
+func (s *heartbeatServer) received() ([]map[string]interface{}, []time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.payloads...), append([]time.Time(nil), s.times...)
+}
+
+// waitForHeartbeats waits until s has received n heartbeats.
+func (s *heartbeatServer) waitForHeartbeats(t *testing.T, n int) ([]map[string]interface{}, []time.Time) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		payloads, times := s.received()
+		if len(payloads) >= n {
+			return payloads, times
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d heartbeats, got %d", n, len(payloads))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHeartbeatPayload(t *testing.T) {
+	server := &heartbeatServer{status: http.StatusOK}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = ts.URL
+		cfg.HeartbeatInterval = 20 * time.Millisecond
+	})
+
+	payloads, _ := server.waitForHeartbeats(t, 2)
+	beat := payloads[1]
+	if beat["service_name"] != "test-service" || beat["instance_id"] != "test-instance" || beat["sdk_version"] != SDKVersion || beat["sdk_language"] != "go" {
+		t.Errorf("unexpected identity in heartbeat %v", beat)
+	}
+	for _, key := range []string{"uptime_ms", "events_buffered", "events_sent", "events_dropped", "goroutines", "heap_alloc"} {
+		if _, ok := beat[key].(float64); !ok {
+			t.Errorf("expected numeric %s in heartbeat %v", key, beat)
+		}
+	}
+	if beat["goroutines"].(float64) < 1 || beat["heap_alloc"].(float64) <= 0 {
+		t.Errorf("expected runtime stats in heartbeat %v", beat)
+	}
+	if len(bufferedEvents(c)) != 0 {
+		t.Error("expected no events for heartbeats the server accepted")
+	}
+
+	c.Shutdown()
+	sent, _ := server.received()
+	time.Sleep(100 * time.Millisecond)
+	if after, _ := server.received(); len(after) != len(sent) {
+		t.Errorf("expected heartbeats to stop on Shutdown, got %d more", len(after)-len(sent))
+	}
+}
+
+func TestHeartbeatBacksOffWhileServerFails(t *testing.T) {
+	server := &heartbeatServer{status: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	interval := 10 * time.Millisecond
+	newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = ts.URL
+		cfg.HeartbeatInterval = interval
+	})
+
+	// Failed heartbeats are followed by 2, 4, then 8 intervals
+	_, times := server.waitForHeartbeats(t, 4)
+	if gap := times[3].Sub(times[2]); gap < 6*interval {
+		t.Errorf("expected the third retry to wait about 8 intervals, waited %v", gap)
+	}
+	if first, third := times[1].Sub(times[0]), times[3].Sub(times[2]); third <= first {
+		t.Errorf("expected growing gaps, got %v then %v", first, third)
+	}
+}
+
+func TestHeartbeatFallsBackToEventsWithoutEndpoint(t *testing.T) {
+	server := &heartbeatServer{status: http.StatusNotFound}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = ts.URL
+		cfg.HeartbeatInterval = 10 * time.Millisecond
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	var beats []Event
+	for len(beats) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		beats = beats[:0]
+		for _, event := range bufferedEvents(c) {
+			if event.Kind.Custom != nil && event.Kind.Custom.Type == HeartbeatEventType {
+				beats = append(beats, event)
+			}
+		}
+	}
+	if len(beats) < 3 {
+		t.Fatalf("expected heartbeat events, got %d", len(beats))
+	}
+	if payloads, _ := server.received(); len(payloads) != 1 {
+		t.Errorf("expected the endpoint to be tried once, got %d posts", len(payloads))
+	}
+	beat := beats[0]
+	if beat.Metadata.Tags["sdk_version"] != SDKVersion {
+		t.Errorf("expected the sdk_version tag, got %v", beat.Metadata.Tags)
+	}
+	if decodedValue(t, beat.Kind.Custom.Payload["instance_id"]) != "test-instance" {
+		t.Errorf("unexpected heartbeat payload %v", beat.Kind.Custom.Payload)
+	}
+	if beats[1].TraceID == beats[0].TraceID {
+		t.Error("expected each heartbeat in a trace of its own")
+	}
+}
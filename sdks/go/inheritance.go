@@ -0,0 +1,137 @@
+package raceway
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultMaxDetachedDuration bounds how long a detached context records events
+// when no WithMaxDetachedDuration option is given.
+const DefaultMaxDetachedDuration = 5 * time.Minute
+
+// InheritancePolicy controls how work spawned from a request relates to the request's trace.
+type InheritancePolicy int
+
+const (
+	// InheritShared continues the trace on a new virtual thread bound to the parent's lifetime.
+	InheritShared InheritancePolicy = iota
+	// InheritDetached continues the trace on a new virtual thread that outlives the parent. See Detach.
+	InheritDetached
+	// InheritIsolated starts a new trace linked to the parent. See Isolate.
+	InheritIsolated
+)
+
+// Apply returns a context for spawned work according to the policy.
+// ctx is returned unchanged if it carries no Raceway context.
+func (p InheritancePolicy) Apply(ctx context.Context, opts ...DetachOption) context.Context {
+	switch p {
+	case InheritDetached:
+		return Detach(ctx, opts...)
+	case InheritIsolated:
+		return Isolate(ctx)
+	}
+	return DeriveThread(ctx)
+}
+
+// DeriveThread returns a context for a goroutine started with the go
+// statement: it continues ctx's trace, from its current event and clock, on a
+// new virtual thread and span, so the server sees the goroutine's accesses as
+// concurrent with the parent's. Call it once per goroutine, before starting
+// it; Go does so itself. ctx is returned unchanged if it carries no Raceway
+// context.
+//
+//	go worker(raceway.DeriveThread(ctx))
+func DeriveThread(ctx context.Context) context.Context {
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, racewayContextKey, rctx.derive())
+}
+
+// DetachOption configures Detach.
+type DetachOption func(*detachOptions)
+
+type detachOptions struct {
+	maxDuration time.Duration
+}
+
+// WithMaxDetachedDuration sets how long the detached context records events
+// before it self-finalizes.
+func WithMaxDetachedDuration(d time.Duration) DetachOption {
+	return func(o *detachOptions) {
+		o.maxDuration = d
+	}
+}
+
+// lifetime ends a detached context independently of the request it came from.
+type lifetime struct {
+	finalized atomic.Bool
+}
+
+func (l *lifetime) expired() bool {
+	return l != nil && l.finalized.Load()
+}
+
+// Detach returns a context for background work that intentionally outlives the
+// request, such as audit logging or cache warming.
+//
+// The detached context keeps the trace ID and the request's tags, starts a new
+// virtual thread from the parent's clock, and is tagged "detached". It is not
+// cancelled with the parent; instead it self-finalizes after the maximum detached
+// duration, after which its Done channel closes and events captured with it are dropped.
+func Detach(ctx context.Context, opts ...DetachOption) context.Context {
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return ctx
+	}
+
+	options := detachOptions{maxDuration: DefaultMaxDetachedDuration}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	detached := rctx.derive()
+	detached.setTag("detached", "true")
+	detached.lifetime = &lifetime{}
+
+	base := context.WithoutCancel(ctx)
+	if options.maxDuration > 0 {
+		var cancel context.CancelFunc
+		base, cancel = context.WithCancel(base)
+		life := detached.lifetime
+		time.AfterFunc(options.maxDuration, func() {
+			life.finalized.Store(true)
+			cancel()
+		})
+	}
+
+	return context.WithValue(base, racewayContextKey, detached)
+}
+
+// Isolate returns a context for spawned work that should be analyzed as its own
+// trace. The new trace carries the request's tags, starts from the parent's
+// clock, and is linked to the parent span with link_relation "spawned_by".
+// Like Detach, it is not cancelled with the parent.
+func Isolate(ctx context.Context) context.Context {
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return ctx
+	}
+
+	isolated := rctx.derive()
+	isolated.TraceID = uuid.New().String()
+	isolated.ParentID = nil
+	isolated.RootID = nil
+	isolated.ParentSpanID = nil
+	isolated.Distributed = false
+	isolated.shared = &traceState{}
+	isolated.setTag("linked_trace_id", rctx.TraceID)
+	isolated.setTag("linked_span_id", rctx.SpanID)
+	isolated.setTag("link_relation", "spawned_by")
+
+	return context.WithValue(context.WithoutCancel(ctx), racewayContextKey, isolated)
+}
@@ -0,0 +1,142 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDetachOutlivesRequest(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	ctx := NewContext(reqCtx, "", "test-service", "test-instance")
+	parent := FromContext(ctx)
+	parent.setTag("tenant", "acme")
+	c.TrackStateChange(ctx, "order", nil, 1, "handler.go:10", "Write")
+
+	detached := Detach(ctx)
+	cancel()
+
+	if detached.Err() != nil {
+		t.Fatalf("detached context cancelled with request: %v", detached.Err())
+	}
+	c.TrackStateChange(detached, "audit", nil, 1, "audit.go:5", "Write")
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	audit := events[1]
+	if audit.TraceID != parent.TraceID {
+		t.Errorf("detached event trace = %s, want %s", audit.TraceID, parent.TraceID)
+	}
+	if audit.Metadata.ThreadID == events[0].Metadata.ThreadID {
+		t.Errorf("expected detached work on a new virtual thread")
+	}
+	if audit.Metadata.Tags["detached"] != "true" || audit.Metadata.Tags["tenant"] != "acme" {
+		t.Errorf("unexpected detached tags %v", audit.Metadata.Tags)
+	}
+	if !hasClockComponent(audit.CausalityVector, "test-service#test-instance", 2) {
+		t.Errorf("expected detached clock to continue from parent, got %v", audit.CausalityVector)
+	}
+}
+
+func TestDetachSelfFinalizesAfterMaxDuration(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	detached := Detach(ctx, WithMaxDetachedDuration(20*time.Millisecond))
+	c.TrackStateChange(detached, "cache", nil, 1, "warm.go:1", "Write")
+
+	select {
+	case <-detached.Done():
+	case <-time.After(time.Second):
+		t.Fatal("detached context did not finalize")
+	}
+	c.TrackStateChange(detached, "cache", 1, 2, "warm.go:2", "Write")
+
+	if got := len(bufferedEvents(c)); got != 1 {
+		t.Errorf("expected events after finalization to be dropped, got %d events", got)
+	}
+}
+
+func TestIsolateLinksNewTrace(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	parent := FromContext(ctx)
+	parent.setTag("session", "s-1")
+	c.TrackStateChange(ctx, "order", nil, 1, "handler.go:10", "Write")
+
+	isolated := Isolate(ctx)
+	c.TrackStateChange(isolated, "report", nil, 1, "report.go:3", "Write")
+
+	event := bufferedEvents(c)[1]
+	if event.TraceID == parent.TraceID {
+		t.Fatalf("expected a new trace")
+	}
+	tags := event.Metadata.Tags
+	if tags["linked_trace_id"] != parent.TraceID || tags["linked_span_id"] != parent.SpanID || tags["link_relation"] != "spawned_by" {
+		t.Errorf("unexpected link tags %v", tags)
+	}
+	if tags["session"] != "s-1" {
+		t.Errorf("expected session tag to be copied, got %v", tags)
+	}
+	if !hasClockComponent(event.CausalityVector, "test-service#test-instance", 2) {
+		t.Errorf("expected isolated clock to merge parent clock, got %v", event.CausalityVector)
+	}
+}
+
+func TestDeriveThreadSeparatesGoroutines(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", nil, 100, "handler.go:1", "Read")
+	parent := FromContext(ctx)
+	before := struct {
+		ThreadID, SpanID string
+		Clock            int
+		ParentID         *string
+	}{parent.ThreadID, parent.SpanID, parent.Clock, parent.ParentID}
+	beforeClock := fmt.Sprint(parent.ClockVector)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		child := DeriveThread(ctx)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.TrackStateChange(child, "balance", 100, 100-i, "worker.go:1", "Write")
+		}(i)
+	}
+	wg.Wait()
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	threads := map[string]bool{}
+	for _, event := range events[1:] {
+		threads[event.Metadata.ThreadID] = true
+		if event.TraceID != events[0].TraceID {
+			t.Errorf("expected the trace to be shared, got %s and %s", event.TraceID, events[0].TraceID)
+		}
+		if event.ParentID == nil || *event.ParentID != events[0].ID {
+			t.Errorf("expected each goroutine's first event to follow the parent's last")
+		}
+	}
+	if len(threads) != 2 || threads[events[0].Metadata.ThreadID] {
+		t.Errorf("expected distinct thread IDs for the parent and each goroutine, got %v", threads)
+	}
+
+	if parent.ThreadID != before.ThreadID || parent.SpanID != before.SpanID || parent.Clock != before.Clock ||
+		*parent.ParentID != *before.ParentID || fmt.Sprint(parent.ClockVector) != beforeClock {
+		t.Error("expected the parent context to be left unchanged by its goroutines")
+	}
+	if DeriveThread(context.Background()) != context.Background() {
+		t.Error("expected a context without Raceway context to be returned unchanged")
+	}
+}
@@ -0,0 +1,117 @@
+package raceway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// kubernetesEnvVars are the downward-API variables KubernetesInstanceID
+// reads, and StableInstanceID hashes by default.
+var kubernetesEnvVars = []string{"POD_NAMESPACE", "POD_NAME", "CONTAINER_NAME"}
+
+// serviceAccountNamespacePath holds the pod's namespace in pods that mount a
+// service account token.
+var serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// resolveInstanceID returns Config.InstanceID, or else the non-empty result
+// of Config.InstanceIDProvider, or else "hostname-pid".
+func resolveInstanceID(config Config) string {
+	if config.InstanceID != "" {
+		return config.InstanceID
+	}
+	if config.InstanceIDProvider != nil {
+		if id := strings.TrimSpace(config.InstanceIDProvider()); id != "" {
+			return id
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "instance"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// KubernetesInstanceID names this instance "namespace/pod/container" from the
+// downward-API variables POD_NAMESPACE, POD_NAME, and CONTAINER_NAME, for use
+// as Config.InstanceIDProvider. The namespace falls back to the service
+// account's, and, inside a pod, the pod falls back to HOSTNAME, which
+// Kubernetes sets to the pod name, then to the host name. Parts that cannot
+// be found are left out, and "" is returned outside of a pod.
+//
+// Expose the variables in the pod spec:
+//
+//	env:
+//	- name: POD_NAMESPACE
+//	  valueFrom: {fieldRef: {fieldPath: metadata.namespace}}
+//	- name: POD_NAME
+//	  valueFrom: {fieldRef: {fieldPath: metadata.name}}
+//	- name: CONTAINER_NAME
+//	  value: api
+func KubernetesInstanceID() string {
+	namespace := firstEnv("POD_NAMESPACE")
+	if namespace == "" {
+		if data, err := os.ReadFile(serviceAccountNamespacePath); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	pod := firstEnv("POD_NAME")
+	if pod == "" {
+		// The host name is only the pod name inside a pod
+		if namespace == "" && os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+			return ""
+		}
+		if pod = firstEnv("HOSTNAME"); pod == "" {
+			pod, _ = os.Hostname()
+		}
+	}
+	if pod == "" {
+		return ""
+	}
+
+	var parts []string
+	for _, part := range []string{namespace, pod, firstEnv("CONTAINER_NAME")} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// StableInstanceID returns a 12-character token hashed from the names and
+// values of the environment variables seedEnvVars, by default POD_NAMESPACE,
+// POD_NAME, and CONTAINER_NAME, so it stays the same across restarts for as
+// long as they do. It returns "" if every variable is unset, rather than a
+// token every instance would share.
+//
+//	config.InstanceIDProvider = func() string {
+//	    return raceway.StableInstanceID("STATEFULSET_ORDINAL", "NODE_NAME")
+//	}
+func StableInstanceID(seedEnvVars ...string) string {
+	if len(seedEnvVars) == 0 {
+		seedEnvVars = kubernetesEnvVars
+	}
+	h := sha256.New()
+	found := false
+	for _, name := range seedEnvVars {
+		value := strings.TrimSpace(os.Getenv(name))
+		found = found || value != ""
+		fmt.Fprintf(h, "%s=%s\n", name, value)
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// firstEnv returns the first non-empty variable of names.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearKubernetesEnv unsets every variable KubernetesInstanceID reads and
+// points it at a service account namespace file that does not exist.
+func clearKubernetesEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range append(kubernetesEnvVars, "HOSTNAME", "KUBERNETES_SERVICE_HOST") {
+		t.Setenv(name, "")
+	}
+	path := serviceAccountNamespacePath
+	serviceAccountNamespacePath = filepath.Join(t.TempDir(), "namespace")
+	t.Cleanup(func() { serviceAccountNamespacePath = path })
+}
+
+func TestResolveInstanceIDPrecedence(t *testing.T) {
+	calls := 0
+	provider := func(id string) func() string {
+		return func() string {
+			calls++
+			return id
+		}
+	}
+	host, _ := os.Hostname()
+
+	if got := resolveInstanceID(Config{InstanceID: "explicit", InstanceIDProvider: provider("pod")}); got != "explicit" || calls != 0 {
+		t.Errorf("expected the explicit InstanceID without calling the provider, got %q after %d calls", got, calls)
+	}
+	if got := resolveInstanceID(Config{InstanceIDProvider: provider(" pod ")}); got != "pod" {
+		t.Errorf("expected the provider's trimmed result, got %q", got)
+	}
+	if got := resolveInstanceID(Config{InstanceIDProvider: provider("")}); !strings.HasPrefix(got, host+"-") {
+		t.Errorf("expected an empty result to fall back to hostname-pid, got %q", got)
+	}
+}
+
+func TestProviderCalledOnceAndUsedEverywhere(t *testing.T) {
+	calls := 0
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.InstanceID = ""
+		cfg.InstanceIDProvider = func() string {
+			calls++
+			return "payments/api-7f9c/api"
+		}
+	})
+	c.TrackStateChange(c.newContext(context.Background(), ""), "balance", 100, 90, "instance_test.go:1", "Write")
+	c.TrackStateChange(WithRacewayContext(context.Background(), NewRacewayContext("")), "balance", 90, 80, "instance_test.go:2", "Write")
+
+	if calls != 1 {
+		t.Errorf("expected the provider called once, got %d", calls)
+	}
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.Metadata.InstanceID == nil || *event.Metadata.InstanceID != "payments/api-7f9c/api" {
+			t.Errorf("event %d: unexpected instance %v", i, event.Metadata.InstanceID)
+		}
+		if !hasClockComponent(event.CausalityVector, "test-service#payments/api-7f9c/api", 1) {
+			t.Errorf("event %d: expected the clock keyed by the same instance, got %v", i, event.CausalityVector)
+		}
+	}
+}
+
+func TestKubernetesInstanceID(t *testing.T) {
+	t.Run("downward API", func(t *testing.T) {
+		clearKubernetesEnv(t)
+		t.Setenv("POD_NAMESPACE", "payments")
+		t.Setenv("POD_NAME", "api-7f9c")
+		t.Setenv("CONTAINER_NAME", "api")
+		t.Setenv("HOSTNAME", "ignored")
+		if got := KubernetesInstanceID(); got != "payments/api-7f9c/api" {
+			t.Errorf("unexpected instance %q", got)
+		}
+	})
+
+	t.Run("service account namespace and HOSTNAME", func(t *testing.T) {
+		clearKubernetesEnv(t)
+		if err := os.WriteFile(serviceAccountNamespacePath, []byte("payments\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("HOSTNAME", "api-7f9c")
+		if got := KubernetesInstanceID(); got != "payments/api-7f9c" {
+			t.Errorf("unexpected instance %q", got)
+		}
+	})
+
+	t.Run("HOSTNAME without a namespace", func(t *testing.T) {
+		clearKubernetesEnv(t)
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+		t.Setenv("HOSTNAME", "api-7f9c")
+		if got := KubernetesInstanceID(); got != "api-7f9c" {
+			t.Errorf("unexpected instance %q", got)
+		}
+	})
+
+	t.Run("explicit pod outside a cluster", func(t *testing.T) {
+		clearKubernetesEnv(t)
+		t.Setenv("POD_NAME", "api-7f9c")
+		if got := KubernetesInstanceID(); got != "api-7f9c" {
+			t.Errorf("unexpected instance %q", got)
+		}
+	})
+
+	t.Run("outside a pod", func(t *testing.T) {
+		clearKubernetesEnv(t)
+		t.Setenv("HOSTNAME", "laptop")
+		if got := KubernetesInstanceID(); got != "" {
+			t.Errorf("expected no instance outside a pod, got %q", got)
+		}
+	})
+}
+
+func TestStableInstanceID(t *testing.T) {
+	clearKubernetesEnv(t)
+	if got := StableInstanceID(); got != "" {
+		t.Errorf("expected no instance with every variable unset, got %q", got)
+	}
+
+	t.Setenv("POD_NAMESPACE", "payments")
+	t.Setenv("POD_NAME", "api-0")
+	first := StableInstanceID()
+	if len(first) != 12 || first != StableInstanceID() {
+		t.Fatalf("expected a stable 12-character token, got %q", first)
+	}
+	t.Setenv("POD_NAME", "api-1")
+	if StableInstanceID() == first {
+		t.Errorf("expected a different token for a different pod")
+	}
+
+	t.Setenv("NODE_NAME", "node-a")
+	if got := StableInstanceID("NODE_NAME"); got == "" || got == StableInstanceID() {
+		t.Errorf("expected a token from the given variables, got %q", got)
+	}
+}
@@ -0,0 +1,118 @@
+package raceway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pprof label keys attached to goroutines running with a RacewayContext.
+const (
+	traceLabel  = "raceway_trace"
+	threadLabel = "raceway_thread"
+)
+
+// runLabeled calls fn with ctx, setting raceway_trace and raceway_thread pprof
+// labels on the goroutine for the duration of the call so goroutine profiles
+// attribute it to the trace. Prior labels are restored when fn returns.
+func (c *Client) runLabeled(ctx context.Context, fn func(context.Context)) {
+	rctx := FromContext(ctx)
+	if c.config.DisableGoroutineLabels || rctx == nil {
+		fn(ctx)
+		return
+	}
+	pprof.Do(ctx, pprof.Labels(traceLabel, rctx.TraceID, threadLabel, rctx.ThreadID), fn)
+}
+
+// goroutineGroup is one record of a debug=1 goroutine profile.
+type goroutineGroup struct {
+	count  int
+	trace  string
+	thread string
+	frames []string
+}
+
+// AnnotatedStackDump writes the stacks of all goroutines grouped by the trace and
+// virtual thread they are running for, followed by goroutines with no trace.
+// Attribution comes from the pprof labels set while a RacewayContext is bound
+// to execution, e.g. inside Middleware.
+func AnnotatedStackDump(w io.Writer) error {
+	var profile bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&profile, 1); err != nil {
+		return err
+	}
+
+	groups := parseGoroutineProfile(&profile)
+	sort.SliceStable(groups, func(i, j int) bool {
+		if (groups[i].trace == "") != (groups[j].trace == "") {
+			return groups[i].trace != ""
+		}
+		if groups[i].trace != groups[j].trace {
+			return groups[i].trace < groups[j].trace
+		}
+		return groups[i].thread < groups[j].thread
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, g := range groups {
+		if g.trace != "" {
+			fmt.Fprintf(bw, "trace %s thread %s: %d goroutine(s)\n", g.trace, g.thread, g.count)
+		} else {
+			fmt.Fprintf(bw, "no trace: %d goroutine(s)\n", g.count)
+		}
+		for _, frame := range g.frames {
+			fmt.Fprintf(bw, "    %s\n", frame)
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// parseGoroutineProfile parses the debug=1 text format of the goroutine profile.
+func parseGoroutineProfile(r io.Reader) []goroutineGroup {
+	var groups []goroutineGroup
+	var current *goroutineGroup
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, " @ ") && !strings.HasPrefix(line, "#"):
+			count, err := strconv.Atoi(strings.TrimSpace(line[:strings.Index(line, " @ ")]))
+			if err != nil {
+				current = nil
+				continue
+			}
+			groups = append(groups, goroutineGroup{count: count})
+			current = &groups[len(groups)-1]
+		case current == nil:
+		case strings.HasPrefix(line, "# labels: "):
+			var labels map[string]string
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "# labels: ")), &labels) == nil {
+				current.trace = labels[traceLabel]
+				current.thread = labels[threadLabel]
+			}
+		case strings.HasPrefix(line, "#\t"):
+			// "#\t0xaddr\tfunction+0xoff\tfile:line"
+			fields := strings.Fields(strings.TrimPrefix(line, "#"))
+			if len(fields) >= 3 {
+				function := fields[1]
+				if i := strings.LastIndex(function, "+0x"); i > 0 {
+					function = function[:i]
+				}
+				current.frames = append(current.frames, function+" "+fields[len(fields)-1])
+			}
+		case line == "":
+			current = nil
+		}
+	}
+	return groups
+}
@@ -0,0 +1,82 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+func blockUntil(release chan struct{}, started chan struct{}) {
+	close(started)
+	<-release
+}
+
+func TestMiddlewareLabelsGoroutines(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	var traceID, threadID string
+	var dump bytes.Buffer
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := FromContext(r.Context())
+		traceID, threadID = rctx.TraceID, rctx.ThreadID
+
+		started := make(chan struct{})
+		go func() {
+			defer close(done)
+			blockUntil(release, started)
+		}()
+		<-started
+
+		if err := AnnotatedStackDump(&dump); err != nil {
+			t.Errorf("AnnotatedStackDump: %v", err)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+
+	header := "trace " + traceID + " thread " + threadID
+	found := false
+	for _, block := range strings.Split(dump.String(), "\n\n") {
+		if strings.HasPrefix(block, header) && strings.Contains(block, "blockUntil") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected spawned goroutine under %s:\n%s", header, dump.String())
+	}
+
+	close(release)
+	<-done
+
+	var after bytes.Buffer
+	if err := AnnotatedStackDump(&after); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(after.String(), traceID) {
+		t.Errorf("expected no goroutines attributed to finished trace:\n%s", after.String())
+	}
+}
+
+func TestDisableGoroutineLabels(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.DisableGoroutineLabels = true })
+
+	var dump bytes.Buffer
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := pprof.Label(r.Context(), traceLabel); ok {
+			t.Errorf("expected no pprof labels when disabled")
+		}
+		AnnotatedStackDump(&dump)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+
+	if strings.Contains(dump.String(), "trace ") {
+		t.Errorf("expected no attributed goroutines:\n%s", dump.String())
+	}
+}
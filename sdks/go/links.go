@@ -0,0 +1,92 @@
+package raceway
+
+import "context"
+
+const (
+	// maxEventLinks is how many links an event carries. Links added beyond it
+	// are dropped.
+	maxEventLinks = 32
+
+	// batchItemSpan names the span TrackBatchItem opens, and
+	// batchItemRelationship is the relationship of its link.
+	batchItemSpan         = "batch_item"
+	batchItemRelationship = "caused_by"
+)
+
+// AddLink links every event captured with ctx from now on to the span
+// linkedSpanID of the trace linkedTraceID, with a relationship such as
+// "caused_by". Unlike continuing a trace, linking leaves the events in ctx's
+// own trace, so a job that handles work from many traces can relate its
+// events to each of them. linkedSpanID may be empty when only the trace is
+// known. A link already present is not added again, and an event carries at
+// most 32 links; further links are dropped with a warning.
+//
+// Example:
+//
+//	for _, transfer := range transfers {
+//	    client.AddLink(ctx, transfer.TraceID, transfer.SpanID, "caused_by")
+//	}
+func (c *Client) AddLink(ctx context.Context, linkedTraceID, linkedSpanID, relationship string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil || linkedTraceID == "" {
+		return
+	}
+	if !rctx.addLink(Link{TraceID: linkedTraceID, SpanID: linkedSpanID, Relationship: relationship}) {
+		c.logger.Warnf("Link to trace %s dropped: events carry at most %d links", linkedTraceID, maxEventLinks)
+	}
+}
+
+// TrackBatchItem runs fn for one item of a batch job, in a child span of ctx
+// whose events are linked to itemTraceID, the trace the item was created in,
+// with relationship "caused_by". Events captured with ctx after fn returns
+// do not carry the link.
+//
+// Example:
+//
+//	for _, transfer := range transfers {
+//	    client.TrackBatchItem(ctx, transfer.TraceID, func(ctx context.Context) {
+//	        settle(ctx, transfer)
+//	    })
+//	}
+func (c *Client) TrackBatchItem(ctx context.Context, itemTraceID string, fn func(ctx context.Context)) {
+	if BuildDisabled || c.disabled {
+		fn(ctx)
+		return
+	}
+	file, line := c.captureFileLine(2)
+	var links []Link
+	if itemTraceID != "" {
+		links = append(links, Link{TraceID: itemTraceID, Relationship: batchItemRelationship})
+	}
+	span, spanCtx := c.startSpan(ctx, batchItemSpan, map[string]interface{}{"item_trace_id": itemTraceID}, file, line, links...)
+	defer span.End()
+	fn(spanCtx)
+}
+
+// addLink attaches link to every subsequent event captured with r. It
+// reports false if r already carries maxEventLinks links.
+func (r *RacewayContext) addLink(link Link) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range r.links {
+		if l == link {
+			return true
+		}
+	}
+	if len(r.links) >= maxEventLinks {
+		return false
+	}
+	r.links = append(r.links, link)
+	return true
+}
+
+// copyLinks returns a copy of links, or nil if there are none.
+func copyLinks(links []Link) []Link {
+	if len(links) == 0 {
+		return nil
+	}
+	return append([]Link(nil), links...)
+}
@@ -0,0 +1,107 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAddLinkAppliesToSubsequentEvents(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "settlement", "job-1")
+
+	c.TrackStateChange(ctx, "batch", nil, "open", "settle.go:1", "Write")
+	c.AddLink(ctx, "trace-a", "span-a", "caused_by")
+	c.AddLink(ctx, "trace-a", "span-a", "caused_by")
+	c.TrackStateChange(ctx, "batch", "open", "settling", "settle.go:2", "Write")
+	child := DeriveThread(ctx)
+	c.TrackStateChange(child, "batch", "settling", "settled", "settle.go:3", "Write")
+
+	events := bufferedEvents(c)
+	if events[0].Metadata.Links != nil {
+		t.Errorf("expected no links before AddLink, got %v", events[0].Metadata.Links)
+	}
+	want := []Link{{TraceID: "trace-a", SpanID: "span-a", Relationship: "caused_by"}}
+	for _, e := range events[1:] {
+		if !reflect.DeepEqual(e.Metadata.Links, want) {
+			t.Errorf("event at %s has links %v, want %v", e.Kind.StateChange.Location, e.Metadata.Links, want)
+		}
+	}
+
+	data, _ := json.Marshal(events[1].Metadata)
+	if !strings.Contains(string(data), `"links":[{"trace_id":"trace-a","span_id":"span-a","relationship":"caused_by"}]`) {
+		t.Errorf("unexpected metadata %s", data)
+	}
+	if data, _ := json.Marshal(events[0].Metadata); strings.Contains(string(data), "links") {
+		t.Errorf("expected links omitted when empty, got %s", data)
+	}
+}
+
+func TestAddLinkCapsLinksPerEvent(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "settlement", "job-1")
+
+	for i := 0; i < maxEventLinks+5; i++ {
+		c.AddLink(ctx, "trace-"+strconv.Itoa(i), "", "caused_by")
+	}
+	c.TrackStateChange(ctx, "batch", nil, "settled", "settle.go:1", "Write")
+
+	links := bufferedEvents(c)[0].Metadata.Links
+	if len(links) != maxEventLinks || links[maxEventLinks-1].TraceID != "trace-31" {
+		t.Errorf("expected the first %d links, got %d", maxEventLinks, len(links))
+	}
+}
+
+func TestTrackBatchItemLinksOnlyEventsInside(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "settlement", "job-1")
+
+	for _, item := range []string{"trace-a", "trace-b"} {
+		c.TrackBatchItem(ctx, item, func(ctx context.Context) {
+			c.TrackStateChange(ctx, "transfer", "pending", "settled", "settle.go:"+item, "Write")
+		})
+	}
+	c.TrackStateChange(ctx, "batch", nil, "settled", "settle.go:after", "Write")
+
+	events := bufferedEvents(c)
+	if len(events) != 7 {
+		t.Fatalf("expected a call, write, and return per item and a final write, got %d events", len(events))
+	}
+	for i, item := range []string{"trace-a", "trace-b"} {
+		call, write, ret := events[3*i], events[3*i+1], events[3*i+2]
+		if call.Kind.FunctionCall == nil || call.Kind.FunctionCall.FunctionName != batchItemSpan || ret.Kind.FunctionReturn == nil {
+			t.Fatalf("expected item %s in a span, got %s and %s", item, call.Kind.Name(), ret.Kind.Name())
+		}
+		if write.ParentID == nil || *write.ParentID != call.ID {
+			t.Errorf("expected item %s's events to be children of its span", item)
+		}
+		want := []Link{{TraceID: item, Relationship: batchItemRelationship}}
+		for _, e := range []Event{call, write, ret} {
+			if !reflect.DeepEqual(e.Metadata.Links, want) {
+				t.Errorf("%s event for item %s has links %v, want %v", e.Kind.Name(), item, e.Metadata.Links, want)
+			}
+		}
+	}
+	if after := events[6]; after.Metadata.Links != nil {
+		t.Errorf("expected no links after TrackBatchItem returns, got %v", after.Metadata.Links)
+	}
+}
+
+func TestTrackBatchItemWithoutContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	ran := false
+	c.TrackBatchItem(context.Background(), "trace-a", func(ctx context.Context) { ran = true })
+	c.AddLink(context.Background(), "trace-a", "", "caused_by")
+	if !ran {
+		t.Error("expected fn to run without a Raceway context")
+	}
+	if events := bufferedEvents(c); len(events) != 0 {
+		t.Errorf("expected no events without a Raceway context, got %d", len(events))
+	}
+}
@@ -0,0 +1,150 @@
+package raceway
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pathTrimmer makes the file paths recorded in event locations relative to
+// their module root, e.g. "internal/payments/transfer.go", so they do not
+// reveal the build machine's directory layout and are the same on every
+// machine that builds the code.
+type pathTrimmer struct {
+	// prefixes are Config.PathPrefixes, each ending in a slash
+	prefixes []string
+	// roots caches the module root of each directory looked up, "" if none
+	roots sync.Map
+}
+
+func newPathTrimmer(prefixes []string) *pathTrimmer {
+	t := &pathTrimmer{}
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSuffix(toSlash(prefix), "/"); prefix != "" {
+			t.prefixes = append(t.prefixes, prefix+"/")
+		}
+	}
+	return t
+}
+
+// mainModule is the path of the main module, which -trimpath builds record
+// in place of its directory.
+var mainModule = sync.OnceValue(func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Path
+	}
+	return ""
+})
+
+// trim returns file relative to the first matching Config.PathPrefixes entry,
+// or else to the root of the module containing it. A file in no module found
+// is reduced to its base name rather than recorded in full.
+func (t *pathTrimmer) trim(file string) string {
+	file = toSlash(file)
+	for _, prefix := range t.prefixes {
+		if strings.HasPrefix(file, prefix) {
+			return file[len(prefix):]
+		}
+	}
+	if !isAbs(file) {
+		if module := mainModule(); module != "" && strings.HasPrefix(file, module+"/") {
+			return file[len(module)+1:]
+		}
+		return file
+	}
+	if root := t.moduleRoot(path.Dir(file)); root != "" {
+		return strings.TrimPrefix(file[len(root):], "/")
+	}
+	return path.Base(file)
+}
+
+// moduleRoot returns the nearest directory at or above dir containing a
+// go.mod file, or "" if there is none, such as when the sources are not
+// deployed with the binary.
+func (t *pathTrimmer) moduleRoot(dir string) string {
+	if root, ok := t.roots.Load(dir); ok {
+		return root.(string)
+	}
+	var root string
+	if _, err := os.Stat(dir + "/go.mod"); err == nil {
+		root = dir
+	} else if parent := path.Dir(dir); parent != dir {
+		root = t.moduleRoot(parent)
+	}
+	t.roots.Store(dir, root)
+	return root
+}
+
+// trimLocation trims the file of a "file:line" location.
+func (t *pathTrimmer) trimLocation(location string) string {
+	i := strings.LastIndexByte(location, ':')
+	if i < 0 {
+		return t.trim(location)
+	}
+	if _, err := strconv.Atoi(location[i+1:]); err != nil {
+		return t.trim(location)
+	}
+	return t.trim(location[:i]) + location[i:]
+}
+
+// stackPath matches the absolute source paths in a stack trace line, such as
+// "main.transfer (/src/app/transfer.go:84)" or a debug.Stack line.
+var stackPath = regexp.MustCompile(`(?:[A-Za-z]:)?[/\\][^\s():]*\.go`)
+
+// trimStack trims the source paths in each line of stack.
+func (t *pathTrimmer) trimStack(stack []string) []string {
+	if stack == nil {
+		return nil
+	}
+	trimmed := make([]string, len(stack))
+	for i, line := range stack {
+		trimmed[i] = stackPath.ReplaceAllStringFunc(line, t.trim)
+	}
+	return trimmed
+}
+
+func toSlash(file string) string {
+	return strings.ReplaceAll(file, `\`, "/")
+}
+
+// isAbs reports whether file is an absolute path on any platform.
+func isAbs(file string) bool {
+	return strings.HasPrefix(file, "/") || len(file) > 2 && file[1] == ':' && file[2] == '/'
+}
+
+// TrimPath returns file, such as one reported by runtime.Caller, as it is
+// recorded in event locations: relative to the first matching
+// Config.PathPrefixes entry or to its module root.
+func (c *Client) TrimPath(file string) string {
+	return c.paths.trim(file)
+}
+
+// captureLocation captures the file:line of the caller.
+// skip parameter controls how many stack frames to skip (2 = caller's caller).
+func (c *Client) captureLocation(skip int) string {
+	file, line := c.captureFileLine(skip + 1)
+	return file + ":" + strconv.Itoa(line)
+}
+
+// captureFileLine is like captureLocation but returns the file and line separately.
+func (c *Client) captureFileLine(skip int) (string, int) {
+	_, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown", 0
+	}
+	return c.paths.trim(file), line
+}
+
+// callerFileLine trims a user-supplied file, or captures the file and line of
+// the caller of the Track method calling it when file is empty.
+func (c *Client) callerFileLine(file string, line int) (string, int) {
+	if file == "" {
+		return c.captureFileLine(3)
+	}
+	return c.paths.trim(file), line
+}
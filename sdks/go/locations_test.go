@@ -0,0 +1,117 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTrimPathRelativeToModuleRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.ToSlash(root)
+	trimmer := newPathTrimmer(nil)
+
+	cases := map[string]string{
+		root + "/internal/payments/transfer.go": "internal/payments/transfer.go",
+		root + "/internal/orders/handler.go":    "internal/orders/handler.go",
+		root + "/main.go":                       "main.go",
+		"/nonexistent/src/app/handler.go":       "handler.go",
+		"internal/payments/transfer.go":         "internal/payments/transfer.go",
+	}
+	for file, want := range cases {
+		if got := trimmer.trim(file); got != want {
+			t.Errorf("trim(%q) = %q, want %q", file, got, want)
+		}
+	}
+	if got := trimmer.trimLocation(root + "/internal/payments/transfer.go:84"); got != "internal/payments/transfer.go:84" {
+		t.Errorf("unexpected location %q", got)
+	}
+}
+
+func TestTrimPathStripsConfiguredPrefixes(t *testing.T) {
+	trimmer := newPathTrimmer([]string{"/builds/ci/", `C:\src\app`})
+
+	cases := map[string]string{
+		"/builds/ci/internal/payments/transfer.go": "internal/payments/transfer.go",
+		`C:\src\app\internal\orders\handler.go`:    "internal/orders/handler.go",
+		"/home/dev/app/internal/orders/handler.go": "handler.go",
+	}
+	for file, want := range cases {
+		if got := trimmer.trim(file); got != want {
+			t.Errorf("trim(%q) = %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestTrimStackRewritesSourcePaths(t *testing.T) {
+	trimmer := newPathTrimmer([]string{"/builds/ci"})
+	stack := []string{
+		"main.transfer (/builds/ci/internal/payments/transfer.go:84)",
+		"\t/builds/ci/cmd/server/main.go:12 +0x1d",
+		"goroutine 1 [running]:",
+	}
+	want := []string{
+		"main.transfer (internal/payments/transfer.go:84)",
+		"\tcmd/server/main.go:12 +0x1d",
+		"goroutine 1 [running]:",
+	}
+	got := trimmer.trimStack(stack)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+	if stack[0] != "main.transfer (/builds/ci/internal/payments/transfer.go:84)" {
+		t.Error("trimStack modified its argument")
+	}
+}
+
+func TestTrackMethodsRecordRelativeLocations(t *testing.T) {
+	c := newBufferingClient(t, func(config *Config) {
+		config.PathPrefixes = []string{"/builds/ci"}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	_, _, line, _ := runtime.Caller(0)
+	c.TrackStateChange(ctx, "balance", 100, 70, "", "Write")
+	c.TrackStateChange(ctx, "balance", 70, 40, "/builds/ci/internal/payments/transfer.go:84", "Write")
+	c.TrackFunctionCall(ctx, "transfer", "payments", nil, "", 0)
+	c.TrackFunctionReturn(ctx, "transfer", nil, "/builds/ci/internal/payments/transfer.go", 90)
+	c.TrackLockAcquire(ctx, "account:1", "Mutex")
+	c.TrackLockRelease(ctx, "account:1", "Mutex")
+	c.TrackError(ctx, "panic", "boom", []string{"main.transfer (/builds/ci/internal/payments/transfer.go:84)"})
+
+	events := bufferedEvents(c)
+	if len(events) != 7 {
+		t.Fatalf("expected 7 events, got %d", len(events))
+	}
+	if got, want := events[0].Kind.StateChange.Location, fmt.Sprintf("locations_test.go:%d", line+1); got != want {
+		t.Errorf("captured state change location %q, want %q", got, want)
+	}
+	if got := events[1].Kind.StateChange.Location; got != "internal/payments/transfer.go:84" {
+		t.Errorf("supplied state change location %q", got)
+	}
+	if call := events[2].Kind.FunctionCall; call.File != "locations_test.go" || call.Line != line+3 {
+		t.Errorf("captured function call at %s:%d, want locations_test.go:%d", call.File, call.Line, line+3)
+	}
+	if ret := events[3].Kind.FunctionReturn; ret.File != "internal/payments/transfer.go" || ret.Line != 90 {
+		t.Errorf("supplied function return at %s:%d", ret.File, ret.Line)
+	}
+	if got, want := events[4].Kind.LockAcquire.Location, fmt.Sprintf("locations_test.go:%d", line+5); got != want {
+		t.Errorf("lock acquire location %q, want %q", got, want)
+	}
+	if got, want := events[5].Kind.LockRelease.Location, fmt.Sprintf("locations_test.go:%d", line+6); got != want {
+		t.Errorf("lock release location %q, want %q", got, want)
+	}
+	if got := events[6].Kind.Error.StackTrace[0]; got != "main.transfer (internal/payments/transfer.go:84)" {
+		t.Errorf("error stack trace %q", got)
+	}
+}
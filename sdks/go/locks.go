@@ -0,0 +1,332 @@
+package raceway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// heldLocks is the set of locks a context holds, in acquisition order.
+// Re-entrant acquisitions of the same lock ID are counted.
+type heldLocks struct {
+	order  []string
+	counts map[string]int
+	// since is when each lock was first acquired
+	since map[string]time.Time
+}
+
+func (h *heldLocks) acquire(lockID string, at time.Time) {
+	if h.counts == nil {
+		h.counts = make(map[string]int)
+		h.since = make(map[string]time.Time)
+	}
+	if h.counts[lockID] == 0 {
+		h.order = append(h.order, lockID)
+		h.since[lockID] = at
+	}
+	h.counts[lockID]++
+}
+
+// release drops one acquisition of lockID and reports false if it was not
+// held. The last release also returns when the lock was first acquired.
+func (h *heldLocks) release(lockID string) (acquired time.Time, ok bool) {
+	if h.counts[lockID] == 0 {
+		return time.Time{}, false
+	}
+	h.counts[lockID]--
+	if h.counts[lockID] > 0 {
+		return time.Time{}, true
+	}
+	acquired = h.since[lockID]
+	delete(h.counts, lockID)
+	delete(h.since, lockID)
+	for i, id := range h.order {
+		if id == lockID {
+			h.order = append(h.order[:i:i], h.order[i+1:]...)
+			break
+		}
+	}
+	return acquired, true
+}
+
+func (h heldLocks) copy() heldLocks {
+	if h.counts == nil {
+		return heldLocks{}
+	}
+	counts := make(map[string]int, len(h.counts))
+	for k, v := range h.counts {
+		counts[k] = v
+	}
+	since := make(map[string]time.Time, len(h.since))
+	for k, v := range h.since {
+		since[k] = v
+	}
+	return heldLocks{order: append([]string(nil), h.order...), counts: counts, since: since}
+}
+
+// snapshot returns a copy of the held lock IDs, never nil.
+func (h *heldLocks) snapshot() []string {
+	return append(make([]string, 0, len(h.order)), h.order...)
+}
+
+// trackLocks updates rctx's held locks for kind and returns the event's lock
+// set. Acquire and release events include the lock they refer to. The last
+// release of a lock records how long it was held.
+func (c *Client) trackLocks(rctx *RacewayContext, kind EventKind) []string {
+	rctx.mu.Lock()
+	if kind.LockAcquire != nil {
+		rctx.heldLocks.acquire(kind.LockAcquire.LockID, time.Now())
+	}
+	lockSet := rctx.heldLocks.snapshot()
+	var acquired time.Time
+	released := true
+	if kind.LockRelease != nil {
+		acquired, released = rctx.heldLocks.release(kind.LockRelease.LockID)
+	}
+	rctx.mu.Unlock()
+
+	if kind.LockRelease != nil {
+		lockID := kind.LockRelease.LockID
+		if !released && c.config.Strict {
+			c.strictViolation(StrictUnbalancedLock, "lock %s released without a matching acquire", lockID)
+		}
+		if !acquired.IsZero() && kind.LockRelease.HeldNs == nil {
+			heldNs := time.Since(acquired).Nanoseconds()
+			kind.LockRelease.HeldNs = &heldNs
+		}
+	}
+	return lockSet
+}
+
+// lockTimeoutErrorType is the ErrorType of the Error event recorded when
+// WithLockTimeout gives up on a lock.
+const lockTimeoutErrorType = "LockTimeout"
+
+// maxLockPoll caps the delay between TryLock attempts in WithLockTimeout.
+const maxLockPoll = 5 * time.Millisecond
+
+// ErrLockTimeout is returned by WithLockTimeout when the lock is not acquired
+// within its timeout.
+var ErrLockTimeout = errors.New("raceway: lock acquisition timed out")
+
+// WithLockTimeout is like WithLock but gives up if the lock is not acquired
+// within timeout, so a lock that is never released cannot hang the caller.
+// fn is not called in that case; a LockContention event marked timed out and
+// an Error event are recorded and an error wrapping ErrLockTimeout is
+// returned.
+//
+// Locks with a TryLock method, such as *sync.Mutex and *sync.RWMutex, are
+// polled. Any other sync.Locker is locked on a separate goroutine, which
+// unlocks it again if it is acquired after WithLockTimeout gave up.
+//
+// Example:
+//
+//	err := client.WithLockTimeout(ctx, &accountLock, "account_lock", "Mutex", time.Second, func() {
+//	    accounts["alice"].Balance -= 100
+//	})
+//	if errors.Is(err, raceway.ErrLockTimeout) {
+//	    return err
+//	}
+func (c *Client) WithLockTimeout(ctx context.Context, lock sync.Locker, lockID, lockType string, timeout time.Duration, fn func()) error {
+	location := c.captureLocation(2)
+	start := time.Now()
+	if !lockWithin(lock, timeout) {
+		c.trackLockContention(ctx, lockID, lockType, location, time.Since(start), true)
+		message := fmt.Sprintf("lock %s not acquired within %s", lockID, timeout)
+		c.TrackError(ctx, lockTimeoutErrorType, message, []string{location})
+		return fmt.Errorf("%w: %s", ErrLockTimeout, message)
+	}
+	c.trackLockAcquired(ctx, lockID, lockType, location, time.Since(start))
+	c.runLocked(ctx, lock, lockID, lockType, location, fn)
+	return nil
+}
+
+// lockWithin locks lock, giving up after timeout.
+func lockWithin(lock sync.Locker, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	if tl, ok := lock.(interface{ TryLock() bool }); ok {
+		poll := 50 * time.Microsecond
+		for !tl.TryLock() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return false
+			}
+			time.Sleep(min(poll, remaining))
+			poll = min(2*poll, maxLockPoll)
+		}
+		return true
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		lock.Lock()
+		close(acquired)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-acquired:
+		return true
+	case <-timer.C:
+		go func() {
+			<-acquired
+			lock.Unlock()
+		}()
+		return false
+	}
+}
+
+// trackLockAcquired records the acquisition of a lock after waiting wait for
+// it, preceded by a LockContention event if the wait reached
+// Config.LockContentionThreshold.
+func (c *Client) trackLockAcquired(ctx context.Context, lockID, lockType, location string, wait time.Duration) {
+	if wait >= c.lockContentionThreshold() {
+		c.trackLockContention(ctx, lockID, lockType, location, wait, false)
+	}
+	waitNs := wait.Nanoseconds()
+	c.captureEvent(ctx, EventKind{
+		LockAcquire: &LockAcquireData{
+			LockID:   lockID,
+			LockType: lockType,
+			Location: location,
+			WaitNs:   &waitNs,
+		},
+	})
+}
+
+func (c *Client) lockContentionThreshold() time.Duration {
+	if c.config.LockContentionThreshold <= 0 {
+		return DefaultLockContentionThreshold
+	}
+	return c.config.LockContentionThreshold
+}
+
+func (c *Client) trackLockContention(ctx context.Context, lockID, lockType, location string, wait time.Duration, timedOut bool) {
+	c.captureEvent(ctx, EventKind{
+		LockContention: &LockContentionData{
+			LockID:   lockID,
+			LockType: lockType,
+			WaitNs:   wait.Nanoseconds(),
+			TimedOut: timedOut,
+			Location: location,
+		},
+	})
+}
+
+// runLocked calls fn with lock held, recording the release and any panic.
+func (c *Client) runLocked(ctx context.Context, lock sync.Locker, lockID, lockType, location string, fn func()) {
+	defer func() {
+		v := recover()
+		if v != nil {
+			c.trackPanic(ctx, v)
+		}
+		c.trackLockRelease(ctx, lockID, lockType, location)
+		lock.Unlock()
+		if v != nil {
+			panic(v)
+		}
+	}()
+	fn()
+}
+
+// withRWLockRead calls fn with lock held for reading. Unlike runLocked it
+// releases the lock before recording anything, since readers do not exclude
+// each other and a release recorded late cannot hide a race between them;
+// the release is timestamped just before the unlock.
+func (c *Client) withRWLockRead(ctx context.Context, lock *sync.RWMutex, lockID string, fn func(), location string) {
+	const lockType = "RWLock-Read"
+	rctx := FromContext(ctx)
+	recorded := c.sampleReadLock()
+	if recorded {
+		c.trackLockAcquire(ctx, lockID, lockType, location)
+	} else {
+		rctx.holdUntracked(lockID)
+	}
+	start := time.Now()
+	lock.RLock()
+	wait := time.Since(start)
+	defer func() {
+		releasedAt := time.Now()
+		lock.RUnlock()
+		v := recover()
+		if v != nil {
+			c.trackPanic(ctx, v)
+		}
+		if wait >= c.lockContentionThreshold() {
+			c.trackLockContention(ctx, lockID, lockType, location, wait, false)
+		}
+		if recorded {
+			c.trackReadUnlock(ctx, lockID, location, releasedAt)
+		} else {
+			rctx.releaseUntracked(lockID)
+		}
+		if v != nil {
+			panic(v)
+		}
+	}()
+	fn()
+}
+
+// trackReadUnlock records the release of a read lock already unlocked at
+// releasedAt.
+func (c *Client) trackReadUnlock(ctx context.Context, lockID, location string, releasedAt time.Time) {
+	c.captureEventWith(ctx, EventKind{
+		LockRelease: &LockReleaseData{
+			LockID:   lockID,
+			LockType: "RWLock-Read",
+			Location: location,
+		},
+	}, captureOptions{timestamp: &releasedAt})
+}
+
+// sampleReadLock reports whether the events of the next WithRWLockRead call
+// are recorded under Config.LockEventSampling.
+func (c *Client) sampleReadLock() bool {
+	every := c.config.LockEventSampling
+	if every <= 1 {
+		return true
+	}
+	return c.readLocks.Add(1)%uint64(every) == 1
+}
+
+// holdUntracked marks lockID held by r without recording an acquire event, so
+// events captured while it is held still carry it in their lock sets.
+func (r *RacewayContext) holdUntracked(lockID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.heldLocks.acquire(lockID, time.Now())
+	r.mu.Unlock()
+}
+
+// releaseUntracked undoes holdUntracked.
+func (r *RacewayContext) releaseUntracked(lockID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.heldLocks.release(lockID)
+	r.mu.Unlock()
+}
+
+// downgradeLockContention re-encodes a LockContention as a FunctionCall for
+// collectors that do not understand the LockContention kind.
+func downgradeLockContention(event Event) Event {
+	data := event.Kind.LockContention
+	event.Kind = EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "lock_contention:" + data.LockID,
+			Module:       "raceway.lock",
+			Args: map[string]interface{}{
+				"lock_type": data.LockType,
+				"wait_ns":   data.WaitNs,
+				"timed_out": data.TimedOut,
+			},
+			File: data.Location,
+		},
+	}
+	return event
+}
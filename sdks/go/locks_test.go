@@ -0,0 +1,462 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventsCarryHeldLockSet(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var accounts sync.Mutex
+	var ledger sync.RWMutex
+	c.TrackStateChange(ctx, "balance", nil, 1, "locks_test.go:1", "Read")
+	c.WithLock(ctx, &accounts, "accounts", "Mutex", func() {
+		c.WithRWLockRead(ctx, &ledger, "ledger", func() {
+			c.TrackStateChange(ctx, "balance", 1, 2, "locks_test.go:2", "Write")
+		})
+		c.TrackStateChange(ctx, "balance", 2, 3, "locks_test.go:3", "Write")
+	})
+	c.TrackStateChange(ctx, "balance", nil, 3, "locks_test.go:4", "Read")
+
+	var got [][]string
+	for _, e := range bufferedEvents(c) {
+		if e.Kind.StateChange != nil {
+			got = append(got, e.LockSet)
+		}
+	}
+	want := [][]string{{}, {"accounts", "ledger"}, {"accounts"}, {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("state change lock sets = %q, want %q", got, want)
+	}
+}
+
+func TestLockSetCountsReentrantAcquisitions(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackLockAcquire(ctx, "cache", "Mutex")
+	c.TrackLockAcquire(ctx, "cache", "Mutex")
+	c.TrackLockRelease(ctx, "cache", "Mutex")
+	c.TrackStateChange(ctx, "entry", nil, 1, "locks_test.go:1", "Write")
+	c.TrackLockRelease(ctx, "cache", "Mutex")
+	c.TrackLockRelease(ctx, "unknown", "Mutex")
+	c.TrackStateChange(ctx, "entry", 1, 2, "locks_test.go:2", "Write")
+
+	events := bufferedEvents(c)
+	if len(events) != 7 {
+		t.Fatalf("expected 7 events, got %d", len(events))
+	}
+	if !reflect.DeepEqual(events[1].LockSet, []string{"cache"}) {
+		t.Errorf("expected a re-entrant acquire to keep a single entry, got %q", events[1].LockSet)
+	}
+	if !reflect.DeepEqual(events[3].LockSet, []string{"cache"}) {
+		t.Errorf("expected the lock still held after one of two releases, got %q", events[3].LockSet)
+	}
+	if !reflect.DeepEqual(events[5].LockSet, []string{}) || !reflect.DeepEqual(events[6].LockSet, []string{}) {
+		t.Errorf("expected an unknown release to be tolerated, got %q and %q", events[5].LockSet, events[6].LockSet)
+	}
+}
+
+func TestDerivedContextsStartWithoutLocks(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackLockAcquire(ctx, "accounts", "Mutex")
+	c.TrackStateChange(InheritShared.Apply(ctx), "balance", nil, 1, "locks_test.go:1", "Read")
+
+	events := bufferedEvents(c)
+	if got := events[len(events)-1].LockSet; len(got) != 0 {
+		t.Errorf("expected a new thread to hold no locks, got %q", got)
+	}
+}
+
+// holdLock locks lock on another goroutine and unlocks it after d.
+func holdLock(lock sync.Locker, d time.Duration) {
+	held := make(chan struct{})
+	go func() {
+		lock.Lock()
+		close(held)
+		time.Sleep(d)
+		lock.Unlock()
+	}()
+	<-held
+}
+
+// chanLocker is a sync.Locker without a TryLock method.
+type chanLocker chan struct{}
+
+func (l chanLocker) Lock()   { l <- struct{}{} }
+func (l chanLocker) Unlock() { <-l }
+
+func TestWithLockRecordsWaitAndContention(t *testing.T) {
+	c := newBufferingClient(t, func(config *Config) {
+		config.LockContentionThreshold = 10 * time.Millisecond
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var mu sync.Mutex
+	c.WithLock(ctx, &mu, "accounts", "Mutex", func() {})
+	holdLock(&mu, 30*time.Millisecond)
+	_, _, line, _ := runtime.Caller(0)
+	c.WithLock(ctx, &mu, "accounts", "Mutex", func() {})
+
+	events := bufferedEvents(c)
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	if wait := events[0].Kind.LockAcquire.WaitNs; wait == nil || time.Duration(*wait) >= 10*time.Millisecond {
+		t.Errorf("expected an uncontended acquire to record a short wait, got %v", wait)
+	}
+	contention := events[2].Kind.LockContention
+	if contention == nil || contention.LockID != "accounts" || contention.TimedOut {
+		t.Fatalf("expected a LockContention event, got %+v", events[2].Kind)
+	}
+	if time.Duration(contention.WaitNs) < 10*time.Millisecond {
+		t.Errorf("expected the contended wait to be recorded, got %v", time.Duration(contention.WaitNs))
+	}
+	if want := fmt.Sprintf("locks_test.go:%d", line+1); contention.Location != want {
+		t.Errorf("contention location %q, want %q", contention.Location, want)
+	}
+	acquire := events[3].Kind.LockAcquire
+	if acquire == nil || acquire.WaitNs == nil || *acquire.WaitNs != contention.WaitNs {
+		t.Errorf("expected the acquire to carry the contended wait, got %+v", events[3].Kind)
+	}
+}
+
+func TestWithRWLockWriteRecordsWaitAndContention(t *testing.T) {
+	c := newBufferingClient(t, func(config *Config) {
+		config.LockContentionThreshold = 10 * time.Millisecond
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var ledger sync.RWMutex
+	holdLock(&ledger, 30*time.Millisecond)
+	c.WithRWLockWrite(ctx, &ledger, "ledger", func() {})
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected contention, acquire and release events, got %d", len(events))
+	}
+	contention := events[0].Kind.LockContention
+	if contention == nil || contention.LockID != "ledger" || contention.LockType != "RWLock-Write" {
+		t.Fatalf("expected a LockContention event, got %+v", events[0].Kind)
+	}
+	if time.Duration(contention.WaitNs) < 10*time.Millisecond {
+		t.Errorf("expected the contended wait to be recorded, got %v", time.Duration(contention.WaitNs))
+	}
+	acquire := events[1].Kind.LockAcquire
+	if acquire == nil || acquire.WaitNs == nil || *acquire.WaitNs != contention.WaitNs {
+		t.Errorf("expected the acquire to carry the contended wait, got %+v", events[1].Kind)
+	}
+}
+
+func TestWithLockTimeoutGivesUpOnHeldLock(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var mu sync.Mutex
+	holdLock(&mu, 200*time.Millisecond)
+	called := false
+	err := c.WithLockTimeout(ctx, &mu, "accounts", "Mutex", 20*time.Millisecond, func() { called = true })
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if called {
+		t.Error("expected fn not to run without the lock")
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected contention and error events, got %d events", len(events))
+	}
+	if contention := events[0].Kind.LockContention; contention == nil || !contention.TimedOut || time.Duration(contention.WaitNs) < 20*time.Millisecond {
+		t.Errorf("expected a timed out LockContention event, got %+v", events[0].Kind)
+	}
+	if e := events[1].Kind.Error; e == nil || e.ErrorType != lockTimeoutErrorType {
+		t.Errorf("expected a LockTimeout error event, got %+v", events[1].Kind)
+	}
+	if len(events[1].LockSet) != 0 {
+		t.Errorf("expected no lock held after a timeout, got %q", events[1].LockSet)
+	}
+}
+
+func TestWithLockTimeoutAcquiresReleasedLock(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var mu sync.Mutex
+	holdLock(&mu, 20*time.Millisecond)
+	called := false
+	if err := c.WithLockTimeout(ctx, &mu, "accounts", "Mutex", time.Second, func() { called = true }); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("expected fn to run once the lock was released")
+	}
+	if !mu.TryLock() {
+		t.Error("expected the lock to be released after fn")
+	}
+}
+
+func TestWithLockTimeoutLockerWithoutTryLock(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	lock := make(chanLocker, 1)
+	lock.Lock()
+	if err := c.WithLockTimeout(ctx, lock, "queue", "Semaphore", 20*time.Millisecond, func() {}); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+
+	// The abandoned acquisition takes the lock once it is released and gives
+	// it back, so later callers are not blocked
+	lock.Unlock()
+	called := false
+	if err := c.WithLockTimeout(ctx, lock, "queue", "Semaphore", time.Second, func() { called = true }); err != nil || !called {
+		t.Fatalf("expected the lock to be acquired after release, got %v", err)
+	}
+}
+
+func TestLockContentionDowngradedWithoutCapability(t *testing.T) {
+	c := newBufferingClient(t, func(config *Config) {
+		config.ForceCapabilities = nil
+		config.LockContentionThreshold = time.Millisecond
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var mu sync.Mutex
+	holdLock(&mu, 20*time.Millisecond)
+	c.WithLock(ctx, &mu, "accounts", "Mutex", func() {})
+	events := bufferedEvents(c)
+	c.downgradeEvents(events)
+
+	call := events[0].Kind.FunctionCall
+	if events[0].Kind.LockContention != nil || call == nil || call.FunctionName != "lock_contention:accounts" || call.Args.(map[string]interface{})["timed_out"] != false {
+		t.Fatalf("expected contention downgraded to a FunctionCall, got %+v", events[0].Kind)
+	}
+	// The wait is still on the acquire the collector understands
+	if acquire := events[1].Kind.LockAcquire; acquire == nil || acquire.WaitNs == nil || *acquire.WaitNs != call.Args.(map[string]interface{})["wait_ns"] {
+		t.Errorf("expected the acquire to carry the contended wait, got %+v", events[1].Kind)
+	}
+}
+
+func TestWithRWLockWriteRecordsCallerLocation(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var ledger sync.RWMutex
+	_, _, line, _ := runtime.Caller(0)
+	c.WithRWLockWrite(ctx, &ledger, "ledger", func() {})
+
+	events := bufferedEvents(c)
+	if len(events) != 2 || events[0].Kind.LockAcquire == nil || events[1].Kind.LockRelease == nil {
+		t.Fatalf("expected acquire and release events, got %d", len(events))
+	}
+	want := fmt.Sprintf("locks_test.go:%d", line+1)
+	if got := events[0].Kind.LockAcquire.Location; got != want {
+		t.Errorf("acquire location %q, want %q", got, want)
+	}
+	if got := events[1].Kind.LockRelease.Location; got != want {
+		t.Errorf("release location %q, want %q", got, want)
+	}
+}
+
+func TestWithRWLockReadRecordsReleaseAfterUnlock(t *testing.T) {
+	var ledger sync.RWMutex
+	c := newBufferingClient(t, func(config *Config) {
+		// Tags each event with whether a writer could take the ledger while
+		// it was captured
+		config.TagProvider = func(ctx context.Context) map[string]string {
+			if !ledger.TryLock() {
+				return map[string]string{"ledger": "held"}
+			}
+			ledger.Unlock()
+			return map[string]string{"ledger": "free"}
+		}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.WithRWLockRead(ctx, &ledger, "ledger", func() {
+		c.TrackStateChange(ctx, "balance", nil, 1, "locks_test.go:1", "Read")
+	})
+
+	events := bufferedEvents(c)
+	if len(events) != 3 || events[2].Kind.LockRelease == nil {
+		t.Fatalf("expected acquire, read and release events, got %d", len(events))
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Metadata.Tags["ledger"])
+	}
+	if want := []string{"free", "held", "free"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ledger state while capturing = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(events[1].LockSet, []string{"ledger"}) {
+		t.Errorf("expected the read to carry the lock, got %q", events[1].LockSet)
+	}
+}
+
+func TestWithRWLockReadReleaseTimestampPrecedesWriter(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var ledger sync.RWMutex
+	writerLocked := make(chan time.Time, 1)
+	c.WithRWLockRead(ctx, &ledger, "ledger", func() {
+		go func() {
+			ledger.Lock()
+			writerLocked <- time.Now()
+			ledger.Unlock()
+		}()
+		// Give the writer time to queue behind the reader
+		time.Sleep(5 * time.Millisecond)
+	})
+	writerAt := <-writerLocked
+
+	events := bufferedEvents(c)
+	release := events[len(events)-1]
+	if release.Kind.LockRelease == nil {
+		t.Fatalf("expected the last event to be the release, got %+v", release.Kind)
+	}
+	releasedAt, err := time.Parse(time.RFC3339Nano, release.Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if releasedAt.After(writerAt) {
+		t.Errorf("release stamped %v, after the waiting writer locked at %v", releasedAt, writerAt)
+	}
+	if held := release.Kind.LockRelease.HeldNs; held == nil || time.Duration(*held) < 5*time.Millisecond {
+		t.Errorf("expected the hold time to cover fn, got %v", held)
+	}
+}
+
+func TestLockEventSamplingThinsReadLockEvents(t *testing.T) {
+	c := newBufferingClient(t, func(config *Config) {
+		config.LockEventSampling = 4
+		config.LockContentionThreshold = time.Millisecond
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var ledger sync.RWMutex
+	for i := 0; i < 7; i++ {
+		c.WithRWLockRead(ctx, &ledger, "ledger", func() {
+			c.TrackStateChange(ctx, "balance", nil, i, "locks_test.go:1", "Read")
+		})
+	}
+	// The eighth read is skipped but waits for a writer
+	holdLock(&ledger, 20*time.Millisecond)
+	c.WithRWLockRead(ctx, &ledger, "ledger", func() {})
+	c.TrackStateChange(ctx, "balance", nil, 7, "locks_test.go:2", "Read")
+
+	acquires, releases, contentions := 0, 0, 0
+	for _, e := range bufferedEvents(c) {
+		switch {
+		case e.Kind.LockAcquire != nil:
+			acquires++
+		case e.Kind.LockRelease != nil:
+			releases++
+		case e.Kind.LockContention != nil:
+			contentions++
+		case e.Kind.StateChange != nil:
+			want := []string{"ledger"}
+			if e.Kind.StateChange.Location == "locks_test.go:2" {
+				want = []string{}
+			}
+			if !reflect.DeepEqual(e.LockSet, want) {
+				t.Errorf("read at %s has lock set %q, want %q", e.Kind.StateChange.Location, e.LockSet, want)
+			}
+		}
+	}
+	if acquires != 2 || releases != 2 {
+		t.Errorf("expected 2 of 8 reads recorded, got %d acquires and %d releases", acquires, releases)
+	}
+	if contentions != 1 {
+		t.Errorf("expected the contended read to be recorded, got %d contention events", contentions)
+	}
+}
+
+// rwLockReadUnderLock is WithRWLockRead as it was before it released the lock
+// ahead of recording, for comparison in BenchmarkRWLockRead.
+func rwLockReadUnderLock(c *Client, ctx context.Context, lock *sync.RWMutex, lockID string, fn func()) {
+	c.TrackLockAcquire(ctx, lockID, "RWLock-Read")
+	lock.RLock()
+	defer func() {
+		c.TrackLockRelease(ctx, lockID, "RWLock-Read")
+		lock.RUnlock()
+	}()
+	fn()
+}
+
+// BenchmarkRWLockRead measures 32 concurrent readers of one RWMutex with a
+// writer taking it now and then, untracked, recording under the lock,
+// through WithRWLockRead, and through WithRWLockRead sampling 1 in 64.
+func BenchmarkRWLockRead(b *testing.B) {
+	const readers = 32
+	run := func(b *testing.B, sampling int, read func(c *Client, ctx context.Context, lock *sync.RWMutex, fn func())) {
+		config := DefaultConfig()
+		config.ServiceName = "bench-service"
+		config.LockEventSampling = sampling
+		config.Routes = []Route{{Name: "discard", Match: RouteMatch{Kinds: []string{"LockAcquire", "LockRelease", "LockContention"}}, Sink: discardSink{}}}
+		client := New(config)
+		defer client.Shutdown()
+
+		var lock sync.RWMutex
+		balance := 0
+		b.ResetTimer()
+		var wg sync.WaitGroup
+		for g := 0; g < readers; g++ {
+			n := b.N / readers
+			if g < b.N%readers {
+				n++
+			}
+			wg.Add(1)
+			go func(g, n int) {
+				defer wg.Done()
+				ctx := NewContext(context.Background(), "", "bench-service", "bench-instance")
+				for i := 0; i < n; i++ {
+					if g == 0 && i%100 == 0 {
+						lock.Lock()
+						balance++
+						lock.Unlock()
+						continue
+					}
+					read(client, ctx, &lock, func() { _ = balance })
+				}
+			}(g, n)
+		}
+		wg.Wait()
+	}
+
+	b.Run("RLock", func(b *testing.B) {
+		run(b, 0, func(c *Client, ctx context.Context, lock *sync.RWMutex, fn func()) {
+			lock.RLock()
+			fn()
+			lock.RUnlock()
+		})
+	})
+	b.Run("TrackedUnderLock", func(b *testing.B) {
+		run(b, 0, func(c *Client, ctx context.Context, lock *sync.RWMutex, fn func()) {
+			rwLockReadUnderLock(c, ctx, lock, "ledger", fn)
+		})
+	})
+	b.Run("WithRWLockRead", func(b *testing.B) {
+		run(b, 0, func(c *Client, ctx context.Context, lock *sync.RWMutex, fn func()) {
+			c.WithRWLockRead(ctx, lock, "ledger", fn)
+		})
+	})
+	b.Run("WithRWLockRead/Sampled", func(b *testing.B) {
+		run(b, 64, func(c *Client, ctx context.Context, lock *sync.RWMutex, fn func()) {
+			c.WithRWLockRead(ctx, lock, "ledger", fn)
+		})
+	})
+}
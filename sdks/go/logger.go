@@ -0,0 +1,100 @@
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Logger receives the SDK's diagnostic messages, such as failed flushes and
+// ignored configuration. Set Config.Logger to route them into the
+// application's structured logging.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// stdoutLogger is the default Logger. It prints "[Raceway] "-prefixed lines to
+// stdout, and debug messages only with Config.Debug. debug is the client's
+// flag, so UpdateConfig can change it.
+type stdoutLogger struct {
+	debug *atomic.Bool
+}
+
+func (l stdoutLogger) Debugf(format string, args ...interface{}) {
+	if l.debug != nil && l.debug.Load() {
+		l.printf(format, args...)
+	}
+}
+
+func (l stdoutLogger) Infof(format string, args ...interface{}) {
+	l.printf(format, args...)
+}
+
+func (l stdoutLogger) Warnf(format string, args ...interface{}) {
+	l.printf("Warning: "+format, args...)
+}
+
+func (l stdoutLogger) Errorf(format string, args ...interface{}) {
+	l.printf("Error: "+format, args...)
+}
+
+func (l stdoutLogger) printf(format string, args ...interface{}) {
+	fmt.Printf("[Raceway] "+format+"\n", args...)
+}
+
+// SlogLogger returns a Logger writing to logger at the matching slog level.
+// Which messages are kept is up to logger's handler; Config.Debug does not
+// apply.
+//
+// Example:
+//
+//	config.Logger = raceway.SlogLogger(slog.Default().With("component", "raceway"))
+func SlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+// debugEnabled reports whether l may print debug messages, so that hot paths
+// can skip formatting arguments for a Logger that discards them.
+func debugEnabled(l Logger) bool {
+	switch l := l.(type) {
+	case stdoutLogger:
+		return l.debug != nil && l.debug.Load()
+	case slogLogger:
+		return l.logger.Enabled(context.Background(), slog.LevelDebug)
+	}
+	return true
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debugf(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, format, args)
+}
+
+func (l slogLogger) Infof(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, format, args)
+}
+
+func (l slogLogger) Warnf(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, format, args)
+}
+
+func (l slogLogger) Errorf(format string, args ...interface{}) {
+	l.log(slog.LevelError, format, args)
+}
+
+// log formats the message only if the handler keeps level, since debug
+// messages are logged for every captured event.
+func (l slogLogger) log(level slog.Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	l.logger.Log(ctx, level, fmt.Sprintf(format, args...))
+}
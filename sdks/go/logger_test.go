@@ -0,0 +1,139 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// capturingLogger records the messages logged at each level.
+type capturingLogger struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (l *capturingLogger) record(level, format string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.messages == nil {
+		l.messages = make(map[string][]string)
+	}
+	l.messages[level] = append(l.messages[level], fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) {
+	l.record("debug", format, args)
+}
+
+func (l *capturingLogger) Infof(format string, args ...interface{}) {
+	l.record("info", format, args)
+}
+
+func (l *capturingLogger) Warnf(format string, args ...interface{}) {
+	l.record("warn", format, args)
+}
+
+func (l *capturingLogger) Errorf(format string, args ...interface{}) {
+	l.record("error", format, args)
+}
+
+func (l *capturingLogger) logged(level string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.messages[level]...)
+}
+
+func TestFlushFailureLogsOneError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	logger := &capturingLogger{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 0
+		cfg.Logger = logger
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "logger_test.go:1", "Write")
+	c.Flush()
+
+	errs := logger.logged("error")
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one error, got %q", errs)
+	}
+	if !strings.Contains(errs[0], "403") {
+		t.Errorf("expected the status code in the error, got %q", errs[0])
+	}
+}
+
+func TestConfigWarningsUseLogger(t *testing.T) {
+	logger := &capturingLogger{}
+	newBufferingClient(t, func(cfg *Config) {
+		cfg.VariableAliases = map[string]string{"balance": ""}
+		cfg.Logger = logger
+	})
+
+	if warnings := logger.logged("warn"); len(warnings) != 1 || !strings.Contains(warnings[0], "variable aliases") {
+		t.Errorf("expected a warning about the variable aliases, got %q", warnings)
+	}
+}
+
+func TestSlogLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := SlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	logger.Debugf("debug %d", 1)
+	logger.Infof("info %d", 2)
+	logger.Warnf("warn %d", 3)
+	logger.Errorf("error %d", 4)
+
+	out := buf.String()
+	if strings.Contains(out, "debug 1") {
+		t.Errorf("expected debug messages below the handler level to be dropped, got %q", out)
+	}
+	for _, want := range []string{`level=INFO msg="info 2"`, `level=WARN msg="warn 3"`, `level=ERROR msg="error 4"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %q", want, out)
+		}
+	}
+}
+
+func TestStdoutLoggerDebugRequiresDebug(t *testing.T) {
+	capture := func(logger stdoutLogger) string {
+		t.Helper()
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+		logger.Debugf("Sent %d events", 3)
+		logger.Errorf("flush failed")
+		os.Stdout = stdout
+		w.Close()
+		out, _ := io.ReadAll(r)
+		return string(out)
+	}
+
+	if out := capture(stdoutLogger{}); out != "[Raceway] Error: flush failed\n" {
+		t.Errorf("expected only the error without Debug, got %q", out)
+	}
+	debug := &atomic.Bool{}
+	debug.Store(true)
+	if out := capture(stdoutLogger{debug: debug}); out != "[Raceway] Sent 3 events\n[Raceway] Error: flush failed\n" {
+		t.Errorf("expected the debug message with Debug, got %q", out)
+	}
+}
@@ -0,0 +1,102 @@
+package raceway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// contextVersionPrefix starts strings produced by MarshalContext. It differs
+// from the raceway-clock header's "v1;" only in avoiding a character that
+// needs quoting in a shell.
+const contextVersionPrefix = "v1."
+
+// MarshalContext encodes the Raceway context of ctx as a single string for
+// continuing its trace in another process without HTTP headers, such as a
+// worker started with os/exec. The string holds only letters, digits, '.',
+// '-' and '_', so it can be passed in an environment variable or on a
+// command line unquoted. Like propagation headers, it costs no clock tick:
+// the other process is ordered after the latest event captured with ctx.
+//
+// Example:
+//
+//	encoded, err := raceway.MarshalContext(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	cmd := exec.CommandContext(ctx, "./worker")
+//	cmd.Env = append(os.Environ(), "RACEWAY_CTX="+encoded)
+func MarshalContext(ctx context.Context) (string, error) {
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return "", errors.New("raceway: MarshalContext called outside of Raceway context")
+	}
+	rctx.mu.Lock()
+	sampled := rctx.Sampled
+	payload := racewayClockPayload{
+		TraceID:       rctx.TraceID,
+		SpanID:        rctx.SpanID,
+		Service:       rctx.ServiceName,
+		Instance:      rctx.InstanceID,
+		Clock:         encodeClockVector(rctx.ClockVector),
+		OriginTraceID: rctx.tags[originTraceIDTag],
+		Fences:        rctx.fences.snapshot(),
+		Region:        rctx.Region,
+		Sampled:       &sampled,
+		Baggage:       rctx.Baggage,
+	}
+	rctx.mu.Unlock()
+	if rctx.ParentSpanID != nil {
+		payload.ParentSpanID = *rctx.ParentSpanID
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return contextVersionPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// UnmarshalContext returns a copy of parent carrying a Raceway context that
+// continues the trace encoded by MarshalContext in s, for the service
+// serviceName and instance instanceID. The new context has a fresh SpanID
+// whose ParentSpanID is the encoding context's span, and its clock vector is
+// the encoded one, merged with parent's if it belongs to the same trace. The
+// raceway-clock header value is accepted too.
+//
+// Example:
+//
+//	ctx, err := raceway.UnmarshalContext(context.Background(), os.Getenv("RACEWAY_CTX"), "worker", "")
+//	if err != nil {
+//	    ctx = client.StartTrace(context.Background(), "worker")
+//	}
+func UnmarshalContext(parent context.Context, s, serviceName, instanceID string) (context.Context, error) {
+	if rest, ok := strings.CutPrefix(s, contextVersionPrefix); ok {
+		s = clockVersionPrefix + rest
+	}
+	parsed, ok := parseRacewayClock(s)
+	if !ok || parsed.traceID == "" {
+		return parent, errors.New("raceway: malformed marshaled context")
+	}
+
+	ctx := newContext(parent, parsed.traceID, serviceName, instanceID, "")
+	rctx := FromContext(ctx)
+	rctx.ParentSpanID = parsed.spanID
+	rctx.Distributed = true
+	rctx.ClockVector = MergeClockVectors(parsed.clock, rctx.ClockVector)
+	if local := FromContext(parent); local != nil && local.TraceID == parsed.traceID {
+		localClock, _ := local.clock()
+		rctx.ClockVector = MergeClockVectors(localClock, rctx.ClockVector)
+	}
+	rctx.Baggage = parsed.baggage
+	rctx.decideSampling(parsed.sampled, func() bool { return true })
+	if parsed.originTraceID != "" {
+		rctx.setTag(originTraceIDTag, parsed.originTraceID)
+	}
+	if parsed.region != "" {
+		rctx.setTag("upstream_region", parsed.region)
+	}
+	rctx.mergeFences(parsed.fences)
+	return ctx, nil
+}
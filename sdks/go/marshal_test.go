@@ -0,0 +1,172 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// shellSafe matches strings that need no quoting in a POSIX shell.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func TestMarshalContextRoundTripsThroughEnv(t *testing.T) {
+	a := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "service-a", "a1" })
+	b := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "service-b", "b1" })
+
+	ctxA := a.newContext(context.Background(), validTraceID)
+	FromContext(ctxA).Baggage = map[string]string{"tenant": "acme"}
+	a.TrackStateChange(ctxA, "balance", 100, 90, "marshal_test.go:1", "Write")
+	a.TrackStateChange(ctxA, "balance", 90, 80, "marshal_test.go:2", "Write")
+
+	encoded, err := MarshalContext(ctxA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !shellSafe.MatchString(encoded) {
+		t.Errorf("expected a shell-safe string, got %q", encoded)
+	}
+	t.Setenv("RACEWAY_CTX", encoded)
+
+	// A → B
+	ctxB, err := UnmarshalContext(context.Background(), os.Getenv("RACEWAY_CTX"), "service-b", "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rctxA, rctxB := FromContext(ctxA), FromContext(ctxB)
+	if rctxB.TraceID != validTraceID || !rctxB.Distributed {
+		t.Errorf("expected the distributed trace %s, got %s", validTraceID, rctxB.TraceID)
+	}
+	if rctxB.SpanID == rctxA.SpanID || rctxB.ParentSpanID == nil || *rctxB.ParentSpanID != rctxA.SpanID {
+		t.Errorf("expected a fresh span whose parent is %s, got %s with parent %v", rctxA.SpanID, rctxB.SpanID, rctxB.ParentSpanID)
+	}
+	if rctxB.Baggage["tenant"] != "acme" || !rctxB.Sampled {
+		t.Errorf("expected baggage and sampling carried over, got %v and %v", rctxB.Baggage, rctxB.Sampled)
+	}
+	if !hasClockComponent(rctxB.ClockVector, "service-a#a1", 2) || !hasClockComponent(rctxB.ClockVector, "service-b#b1", 0) {
+		t.Errorf("expected service-a#a1:2 and service-b#b1:0, got %v", rctxB.ClockVector)
+	}
+	b.TrackStateChange(ctxB, "balance", 80, 70, "marshal_test.go:3", "Write")
+
+	// B → C
+	encoded, err = MarshalContext(ctxB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RACEWAY_CTX", encoded)
+	ctxC, err := UnmarshalContext(context.Background(), os.Getenv("RACEWAY_CTX"), "service-c", "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rctxC := FromContext(ctxC)
+	if rctxC.TraceID != validTraceID || *rctxC.ParentSpanID != rctxB.SpanID {
+		t.Errorf("expected the trace continued from span %s", rctxB.SpanID)
+	}
+	for component, value := range map[string]uint64{"service-a#a1": 2, "service-b#b1": 1, "service-c#c1": 0} {
+		if !hasClockComponent(rctxC.ClockVector, component, value) {
+			t.Errorf("expected %s:%d, got %v", component, value, rctxC.ClockVector)
+		}
+	}
+}
+
+func TestMarshalContextKeepsSamplingDecision(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Sampler = func(string, string) bool { return false } })
+	ctx := c.newContext(context.Background(), validTraceID)
+	c.sampled(FromContext(ctx))
+
+	encoded, err := MarshalContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker, err := UnmarshalContext(context.Background(), encoded, "worker", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FromContext(worker).Sampled {
+		t.Errorf("expected the unsampled decision carried over")
+	}
+}
+
+func TestUnmarshalContextAcceptsClockHeader(t *testing.T) {
+	headers := MapCarrier{}
+	buildPropagationHeaders(headers, validTraceID, "span-a", nil, nil, []CausalityEntry{NewCausalityEntry("service-a#a1", 3)},
+		"service-a", "a1", "", true, nil)
+	ctx, err := UnmarshalContext(context.Background(), headers.Get(racewayClockHeader), "service-b", "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rctx := FromContext(ctx); rctx.TraceID != validTraceID || !hasClockComponent(rctx.ClockVector, "service-a#a1", 3) {
+		t.Errorf("expected the header's trace and clock, got %s and %v", rctx.TraceID, rctx.ClockVector)
+	}
+}
+
+func TestMarshalContextErrors(t *testing.T) {
+	if _, err := MarshalContext(context.Background()); err == nil {
+		t.Errorf("expected an error outside of Raceway context")
+	}
+	parent := context.Background()
+	for _, s := range []string{"", "v1.", "v1.not base64", "v2.e30", "v1.e30"} {
+		ctx, err := UnmarshalContext(parent, s, "worker", "w1")
+		if err == nil {
+			t.Errorf("UnmarshalContext(%q): expected an error", s)
+		}
+		if ctx != parent {
+			t.Errorf("UnmarshalContext(%q): expected parent returned", s)
+		}
+	}
+}
+
+// TestMarshalContextAcrossExec continues a trace in a child process that
+// reads it from the environment, as a forked worker would.
+func TestMarshalContextAcrossExec(t *testing.T) {
+	if encoded := os.Getenv("RACEWAY_TEST_WORKER_CTX"); encoded != "" {
+		runMarshalContextWorker(t, encoded)
+		return
+	}
+	a := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "service-a", "a1" })
+	ctx := a.newContext(context.Background(), validTraceID)
+	a.TrackStateChange(ctx, "balance", 100, 90, "marshal_test.go:1", "Write")
+	encoded, err := MarshalContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMarshalContextAcrossExec$")
+	cmd.Env = append(os.Environ(), "RACEWAY_TEST_WORKER_CTX="+encoded)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("worker failed: %v\n%s", err, out)
+	}
+	var returned string
+	for _, line := range strings.Split(string(out), "\n") {
+		if rest, ok := strings.CutPrefix(line, "RACEWAY_CTX="); ok {
+			returned = rest
+		}
+	}
+	back, err := UnmarshalContext(ctx, returned, "service-a", "a1")
+	if err != nil {
+		t.Fatalf("unreadable context from worker %q: %v", returned, err)
+	}
+	rctx := FromContext(back)
+	if !hasClockComponent(rctx.ClockVector, "service-a#a1", 1) || !hasClockComponent(rctx.ClockVector, "worker#w1", 1) {
+		t.Errorf("expected service-a#a1:1 and worker#w1:1, got %v", rctx.ClockVector)
+	}
+}
+
+func runMarshalContextWorker(t *testing.T, encoded string) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "worker", "w1" })
+	ctx, err := UnmarshalContext(context.Background(), encoded, "worker", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.TrackStateChange(ctx, "balance", 90, 80, "marshal_test.go:2", "Write")
+	encoded, err = MarshalContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString("\nRACEWAY_CTX=" + encoded + "\n")
+}
@@ -1,3 +1,5 @@
+//go:build !raceway_disabled
+
 package raceway
 
 import (
@@ -196,3 +198,26 @@ func TestGinMiddleware(t *testing.T) {
 		t.Error("Expected Gin middleware to call Next()")
 	}
 }
+
+func TestSetRouteTagsRequestAndResponse(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client.SetRoute(r.Context(), "/accounts/{id}")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	client.Middleware(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/accounts/42", nil))
+
+	events := bufferedEvents(client)
+	if len(events) != 2 {
+		t.Fatalf("expected request and response, got %d events", len(events))
+	}
+	for _, event := range events {
+		if event.Metadata.Tags[routeTag] != "/accounts/{id}" {
+			t.Errorf("expected the route on %s, got %v", event.Kind.Name(), event.Metadata.Tags)
+		}
+	}
+	if events[0].Kind.HTTPRequest.URL != "/accounts/42" {
+		t.Errorf("expected the URL kept, got %s", events[0].Kind.HTTPRequest.URL)
+	}
+}
@@ -0,0 +1,115 @@
+package raceway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TrackedMutex is a sync.Mutex whose Lock and Unlock take a context and
+// record LockAcquire and LockRelease events under its lock ID, with how long
+// the lock was waited for and held. A wait of Config.LockContentionThreshold
+// or more is also recorded as a LockContention event, like WithLock.
+//
+// Its zero value is an untracked mutex. The embedded sync.Mutex can still be
+// locked without a context, as m.Mutex.Lock(), but such use is not recorded.
+//
+// Example:
+//
+//	type Account struct {
+//	    mu      *raceway.TrackedMutex
+//	    Balance int64
+//	}
+//
+//	account := &Account{mu: client.NewTrackedMutex("account_lock")}
+//	account.mu.Lock(ctx)
+//	defer account.mu.Unlock(ctx)
+type TrackedMutex struct {
+	sync.Mutex
+	client *Client
+	lockID string
+}
+
+// NewTrackedMutex returns an unlocked TrackedMutex recorded under lockID.
+func (c *Client) NewTrackedMutex(lockID string) *TrackedMutex {
+	return &TrackedMutex{client: c, lockID: lockID}
+}
+
+// Lock locks m, recording how long it waited with ctx.
+func (m *TrackedMutex) Lock(ctx context.Context) {
+	if m.client == nil {
+		m.Mutex.Lock()
+		return
+	}
+	location := m.client.captureLocation(2)
+	start := time.Now()
+	m.Mutex.Lock()
+	m.client.trackLockAcquired(ctx, m.lockID, "Mutex", location, time.Since(start))
+}
+
+// Unlock unlocks m, recording how long ctx held it.
+func (m *TrackedMutex) Unlock(ctx context.Context) {
+	if m.client != nil {
+		m.client.trackLockRelease(ctx, m.lockID, "Mutex", m.client.captureLocation(2))
+	}
+	m.Mutex.Unlock()
+}
+
+// TrackedRWMutex is a sync.RWMutex recorded like TrackedMutex, with the lock
+// types "RWLock-Write" and "RWLock-Read" of WithRWLockWrite and
+// WithRWLockRead. Its zero value is an untracked mutex.
+type TrackedRWMutex struct {
+	sync.RWMutex
+	client *Client
+	lockID string
+}
+
+// NewTrackedRWMutex returns an unlocked TrackedRWMutex recorded under lockID.
+func (c *Client) NewTrackedRWMutex(lockID string) *TrackedRWMutex {
+	return &TrackedRWMutex{client: c, lockID: lockID}
+}
+
+// Lock locks m for writing, recording how long it waited with ctx.
+func (m *TrackedRWMutex) Lock(ctx context.Context) {
+	if m.client == nil {
+		m.RWMutex.Lock()
+		return
+	}
+	location := m.client.captureLocation(2)
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.client.trackLockAcquired(ctx, m.lockID, "RWLock-Write", location, time.Since(start))
+}
+
+// Unlock unlocks m for writing, recording how long ctx held it.
+func (m *TrackedRWMutex) Unlock(ctx context.Context) {
+	if m.client != nil {
+		m.client.trackLockRelease(ctx, m.lockID, "RWLock-Write", m.client.captureLocation(2))
+	}
+	m.RWMutex.Unlock()
+}
+
+// RLock locks m for reading, recording how long it waited with ctx.
+func (m *TrackedRWMutex) RLock(ctx context.Context) {
+	if m.client == nil {
+		m.RWMutex.RLock()
+		return
+	}
+	location := m.client.captureLocation(2)
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.client.trackLockAcquired(ctx, m.lockID, "RWLock-Read", location, time.Since(start))
+}
+
+// RUnlock undoes one RLock, recording how long ctx held the read lock. Like
+// WithRWLockRead, it unlocks before recording the release.
+func (m *TrackedRWMutex) RUnlock(ctx context.Context) {
+	if m.client == nil {
+		m.RWMutex.RUnlock()
+		return
+	}
+	location := m.client.captureLocation(2)
+	releasedAt := time.Now()
+	m.RWMutex.RUnlock()
+	m.client.trackReadUnlock(ctx, m.lockID, location, releasedAt)
+}
@@ -0,0 +1,125 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrackedMutexRecordsWaitAndHold(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	mu := c.NewTrackedMutex("account_lock")
+
+	balance := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			mu.Lock(ctx)
+			defer mu.Unlock(ctx)
+			balance++
+			time.Sleep(time.Millisecond)
+		}(DeriveThread(ctx))
+	}
+	wg.Wait()
+	if balance != 10 {
+		t.Fatalf("expected mutual exclusion, got balance %d", balance)
+	}
+
+	var acquires, releases int
+	var waited bool
+	for _, event := range bufferedEvents(c) {
+		switch {
+		case event.Kind.LockAcquire != nil:
+			acquires++
+			data := event.Kind.LockAcquire
+			if data.LockID != "account_lock" || data.LockType != "Mutex" || data.WaitNs == nil {
+				t.Errorf("unexpected acquire %+v", data)
+				continue
+			}
+			waited = waited || *data.WaitNs > 0
+		case event.Kind.LockRelease != nil:
+			releases++
+			if held := event.Kind.LockRelease.HeldNs; held == nil || *held < time.Millisecond.Nanoseconds() {
+				t.Errorf("expected the release to record at least 1ms held, got %v", held)
+			}
+		}
+	}
+	if acquires != 10 || releases != 10 {
+		t.Fatalf("expected 10 acquires and releases, got %d and %d", acquires, releases)
+	}
+	if !waited {
+		t.Errorf("expected at least one acquire to record a wait")
+	}
+}
+
+func TestTrackedRWMutexLockTypes(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	mu := c.NewTrackedRWMutex("ledger")
+
+	mu.RLock(ctx)
+	mu.RUnlock(ctx)
+	mu.Lock(ctx)
+	mu.Unlock(ctx)
+
+	events := bufferedEvents(c)
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	for i, want := range []string{"RWLock-Read", "RWLock-Read", "RWLock-Write", "RWLock-Write"} {
+		var lockType string
+		if data := events[i].Kind.LockAcquire; data != nil {
+			lockType = data.LockType
+		} else if data := events[i].Kind.LockRelease; data != nil {
+			lockType = data.LockType
+			if data.HeldNs == nil {
+				t.Errorf("event %d: expected the hold time", i)
+			}
+		}
+		if lockType != want {
+			t.Errorf("event %d: expected %s, got %q", i, want, lockType)
+		}
+	}
+}
+
+func TestZeroTrackedMutexIsUntracked(t *testing.T) {
+	var mu TrackedMutex
+	var rw TrackedRWMutex
+	mu.Lock(context.Background())
+	mu.Unlock(context.Background())
+	rw.RLock(context.Background())
+	rw.RUnlock(context.Background())
+	rw.Lock(context.Background())
+	rw.Unlock(context.Background())
+}
+
+func TestLockReleaseRecordsHoldOfOutermostAcquire(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackLockAcquire(ctx, "cache", "Mutex")
+	c.TrackLockAcquire(ctx, "cache", "Mutex")
+	c.TrackLockRelease(ctx, "cache", "Mutex")
+	c.TrackLockRelease(ctx, "cache", "Mutex")
+	c.TrackLockRelease(ctx, "unknown", "Mutex")
+
+	events := bufferedEvents(c)
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	if events[2].Kind.LockRelease.HeldNs != nil {
+		t.Errorf("expected no hold time while the lock is still held, got %d", *events[2].Kind.LockRelease.HeldNs)
+	}
+	if events[3].Kind.LockRelease.HeldNs == nil {
+		t.Errorf("expected the last release to record the hold time")
+	}
+	if events[4].Kind.LockRelease.HeldNs != nil {
+		t.Errorf("expected no hold time for a lock that was not held")
+	}
+}
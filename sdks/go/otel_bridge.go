@@ -0,0 +1,57 @@
+package raceway
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mode7labs/raceway/sdks/go/propagation"
+)
+
+// OTelSpanSource returns the W3C trace ID and span ID, in hex, of the
+// OpenTelemetry span active in ctx, or false if there is none.
+type OTelSpanSource func(ctx context.Context) (traceID, spanID string, ok bool)
+
+var otelSpanSource atomic.Pointer[OTelSpanSource]
+
+// RegisterOTelBridge installs the source consulted by clients with
+// Config.OTelBridge set. The racewayotel module registers one when imported,
+// so applications do not normally call it.
+func RegisterOTelBridge(source OTelSpanSource) {
+	otelSpanSource.Store(&source)
+}
+
+// otelSpan returns the trace ID, as a Raceway UUID, and span ID of the
+// OpenTelemetry span active in ctx when Config.OTelBridge is set.
+func (c *Client) otelSpan(ctx context.Context) (traceID, spanID string, ok bool) {
+	if !c.config.OTelBridge || ctx == nil {
+		return "", "", false
+	}
+	source := otelSpanSource.Load()
+	if source == nil {
+		return "", "", false
+	}
+	wireTraceID, spanID, ok := (*source)(ctx)
+	if !ok {
+		return "", "", false
+	}
+	traceID, err := propagation.TraceIDToUUID(strings.ToLower(wireTraceID))
+	if err != nil || len(spanID) != 16 {
+		return "", "", false
+	}
+	return traceID, strings.ToLower(spanID), true
+}
+
+// adoptOTelSpan keys parsed by the OpenTelemetry span active in ctx when no
+// upstream trace context or TraceIDAdapter ID was found, so Raceway and
+// OpenTelemetry agree on the trace of a request.
+func (c *Client) adoptOTelSpan(ctx context.Context, parsed ParsedTraceContext) ParsedTraceContext {
+	if parsed.Distributed || parsed.OriginTraceID != "" {
+		return parsed
+	}
+	if traceID, spanID, ok := c.otelSpan(ctx); ok {
+		parsed.TraceID = traceID
+		parsed.SpanID = spanID
+	}
+	return parsed
+}
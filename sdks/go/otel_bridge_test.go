@@ -0,0 +1,75 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type otelSpanKey struct{}
+
+// fakeOTelSpan registers a bridge reading the trace and span IDs stored under
+// otelSpanKey, restoring the previous bridge when the test ends.
+func fakeOTelSpan(t *testing.T) {
+	t.Helper()
+	previous := otelSpanSource.Load()
+	t.Cleanup(func() { otelSpanSource.Store(previous) })
+	RegisterOTelBridge(func(ctx context.Context) (string, string, bool) {
+		ids, ok := ctx.Value(otelSpanKey{}).([2]string)
+		return ids[0], ids[1], ok
+	})
+}
+
+func withOTelSpan(ctx context.Context) context.Context {
+	return context.WithValue(ctx, otelSpanKey{}, [2]string{"4BF92F3577B34DA6A3CE929D0E0E4736", "00f067aa0ba902b7"})
+}
+
+func TestStartTraceAdoptsOTelTraceID(t *testing.T) {
+	fakeOTelSpan(t)
+	c := newBufferingClient(t, func(config *Config) { config.OTelBridge = true })
+
+	rctx := FromContext(c.StartTrace(withOTelSpan(context.Background()), "job"))
+	if rctx.TraceID != "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" || rctx.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected the OpenTelemetry trace and span, got %s/%s", rctx.TraceID, rctx.SpanID)
+	}
+
+	if rctx := FromContext(c.StartTrace(context.Background(), "job")); rctx.TraceID == "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" {
+		t.Error("expected a generated trace ID without an active span")
+	}
+}
+
+func TestOTelBridgeDisabledByDefault(t *testing.T) {
+	fakeOTelSpan(t)
+	c := newBufferingClient(t, nil)
+
+	if rctx := FromContext(c.StartTrace(withOTelSpan(context.Background()), "job")); rctx.TraceID == "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" {
+		t.Error("expected the OpenTelemetry span to be ignored without OTelBridge")
+	}
+}
+
+func TestMiddlewarePrefersIncomingTraceOverOTelSpan(t *testing.T) {
+	fakeOTelSpan(t)
+	c := newBufferingClient(t, func(config *Config) { config.OTelBridge = true })
+
+	var traceIDs []string
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceIDs = append(traceIDs, FromContext(r.Context()).TraceID)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(withOTelSpan(req.Context())))
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(withOTelSpan(req.Context())))
+
+	if traceIDs[0] != "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" {
+		t.Errorf("expected a request without trace headers to adopt the OpenTelemetry trace, got %s", traceIDs[0])
+	}
+	if traceIDs[1] != "0af76519-16cd-43dd-8448-eb211c80319c" {
+		t.Errorf("expected the incoming traceparent to win, got %s", traceIDs[1])
+	}
+}
@@ -0,0 +1,108 @@
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// panicErrorType is the ErrorType of Error events recorded for panics.
+const panicErrorType = "panic"
+
+// trackPanic records v, a recovered panic value, as an Error event with the
+// panicking goroutine's stack, and commits the trace in CaptureModeOnError. A
+// panic already recorded in this trace, as it unwinds through nested helpers,
+// is not recorded again.
+func (c *Client) trackPanic(ctx context.Context, v interface{}) {
+	rctx := FromContext(ctx)
+	if rctx == nil || (rctx.shared != nil && !rctx.shared.notePanic(v)) {
+		return
+	}
+	c.TrackError(ctx, panicErrorType, fmt.Sprint(v), panicStack())
+	c.CommitTrace(ctx)
+}
+
+// recoverHandlerPanic is deferred by the HTTP middleware. It records a panic
+// from the handler and re-panics, or, with Config.RecoverPanics, calls
+// respond instead. http.ErrAbortHandler is a deliberate abort, not a failure,
+// so it is passed on unrecorded.
+func (c *Client) recoverHandlerPanic(ctx context.Context, respond func()) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	c.trackPanic(ctx, v)
+	if !c.config.RecoverPanics {
+		panic(v)
+	}
+	respond()
+}
+
+// rethrowPanicAndFlush is deferred by goroutines started with Go. An
+// unrecovered panic there ends the process, so the Error event is flushed,
+// within Config.ShutdownTimeout, before the panic resumes.
+func (c *Client) rethrowPanicAndFlush(ctx context.Context) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	c.trackPanic(ctx, v)
+
+	timeout := c.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = c.FlushContext(flushCtx)
+	panic(v)
+}
+
+// notePanic remembers v and reports whether it differs from the last panic
+// recorded for the trace.
+func (s *traceState) notePanic(v interface{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if samePanic(s.panic, v) {
+		return false
+	}
+	s.panic = v
+	return true
+}
+
+// samePanic compares panic values, treating incomparable values as different.
+func samePanic(a, b interface{}) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// panicStack returns the frames of the panicking goroutine, starting at the
+// function that panicked.
+func panicStack() []string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+
+	var stack []string
+	unwinding := false
+	for {
+		frame, more := frames.Next()
+		if unwinding && !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line))
+		}
+		if frame.Function == "runtime.gopanic" {
+			unwinding = true
+		}
+		if !more {
+			return stack
+		}
+	}
+}
@@ -0,0 +1,165 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func errorEvents(events []Event) []Event {
+	var errs []Event
+	for _, e := range events {
+		if e.Kind.Error != nil {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+func TestWithLockRecordsPanicAndReleasesLock(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	var mu sync.Mutex
+
+	func() {
+		defer func() {
+			if v := recover(); v != "insufficient funds" {
+				t.Fatalf("expected the original panic to resume, got %v", v)
+			}
+		}()
+		c.WithLock(ctx, &mu, "account_lock", "Mutex", func() {
+			panic("insufficient funds")
+		})
+	}()
+
+	if !mu.TryLock() {
+		t.Fatal("expected the lock to be unlocked after the panic")
+	}
+	mu.Unlock()
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected acquire, error, and release events, got %d", len(events))
+	}
+	errEvent, release := events[1].Kind.Error, events[2].Kind.LockRelease
+	if errEvent == nil || errEvent.ErrorType != "panic" || errEvent.Message != "insufficient funds" {
+		t.Fatalf("unexpected error event %+v", events[1].Kind)
+	}
+	if len(errEvent.StackTrace) == 0 || !strings.Contains(errEvent.StackTrace[0], "TestWithLockRecordsPanicAndReleasesLock") {
+		t.Fatalf("expected the stack to start at the panicking function, got %v", errEvent.StackTrace)
+	}
+	if release == nil || release.LockID != "account_lock" {
+		t.Fatalf("expected the lock release to be tracked, got %+v", events[2].Kind)
+	}
+}
+
+func TestNestedHelpersRecordPanicOnce(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	var outer, inner sync.RWMutex
+
+	func() {
+		defer func() { _ = recover() }()
+		c.WithRWLockWrite(ctx, &outer, "outer", func() {
+			span, spanCtx := c.StartSpan(ctx, "debit", nil)
+			defer span.End()
+			c.WithRWLockRead(spanCtx, &inner, "inner", func() {
+				panic("boom")
+			})
+		})
+	}()
+
+	events := bufferedEvents(c)
+	errs := errorEvents(events)
+	if len(errs) != 1 {
+		t.Fatalf("expected one error event, got %d", len(errs))
+	}
+	releases := 0
+	for _, e := range events {
+		if e.Kind.LockRelease != nil {
+			releases++
+		}
+	}
+	if releases != 2 {
+		t.Fatalf("expected both lock releases, got %d", releases)
+	}
+}
+
+func TestGoFlushesPanicBeforeResuming(t *testing.T) {
+	sink := &recordingSink{}
+	c := newBufferingClient(t, nil)
+	c.router.fallback.route.Sink = sink
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	// Exercise the wrapper directly; a panic escaping a real goroutine would
+	// end the test binary
+	recovered := make(chan interface{}, 1)
+	child := InheritShared.Apply(ctx)
+	go func() {
+		defer func() { recovered <- recover() }()
+		defer c.rethrowPanicAndFlush(child)
+		panic("worker failed")
+	}()
+	if v := <-recovered; v != "worker failed" {
+		t.Fatalf("expected the panic to resume, got %v", v)
+	}
+	if errs := errorEvents(sink.received()); len(errs) != 1 || errs[0].Kind.Error.Message != "worker failed" {
+		t.Fatalf("expected the panic to be delivered, got %+v", errs)
+	}
+}
+
+func TestMiddlewareRecordsHandlerPanic(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+
+	t.Run("re-panics by default", func(t *testing.T) {
+		c := newBufferingClient(t, nil)
+		func() {
+			defer func() {
+				if v := recover(); v != "handler failed" {
+					t.Fatalf("expected the panic to resume, got %v", v)
+				}
+			}()
+			c.Middleware(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/transfer", nil))
+		}()
+		if errs := errorEvents(bufferedEvents(c)); len(errs) != 1 || errs[0].Kind.Error.ErrorType != "panic" {
+			t.Fatalf("expected one panic error event, got %+v", errs)
+		}
+	})
+
+	t.Run("responds 500 with RecoverPanics", func(t *testing.T) {
+		c := newBufferingClient(t, func(cfg *Config) { cfg.RecoverPanics = true })
+		rec := httptest.NewRecorder()
+		c.Middleware(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transfer", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("expected 500, got %d", rec.Code)
+		}
+		if errs := errorEvents(bufferedEvents(c)); len(errs) != 1 {
+			t.Fatalf("expected one error event, got %d", len(errs))
+		}
+	})
+
+	t.Run("passes ErrAbortHandler through", func(t *testing.T) {
+		c := newBufferingClient(t, func(cfg *Config) { cfg.RecoverPanics = true })
+		abort := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+		func() {
+			defer func() {
+				if v := recover(); v != http.ErrAbortHandler {
+					t.Fatalf("expected ErrAbortHandler to resume, got %v", v)
+				}
+			}()
+			c.Middleware(abort).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/transfer", nil))
+		}()
+		if errs := errorEvents(bufferedEvents(c)); len(errs) != 0 {
+			t.Fatalf("expected no error events, got %d", len(errs))
+		}
+	})
+}
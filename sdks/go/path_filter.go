@@ -0,0 +1,130 @@
+package raceway
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxPathDecisions is how many request paths' Config.IgnorePaths decisions
+// are cached.
+const maxPathDecisions = 1024
+
+// untracedKey marks the context of a request that Middleware does not trace,
+// so calls that find no RacewayContext in it are not strict violations.
+type untracedKey struct{}
+
+// pathFilter matches request paths against Config.IgnorePaths. Decisions for
+// the most recently seen paths are cached, so a path is matched against the
+// patterns once however often it is requested.
+type pathFilter struct {
+	exact    map[string]bool
+	prefixes []string
+	suffixes []string
+	contains []string
+
+	mu        sync.Mutex
+	decisions map[string]*list.Element
+	// recent holds a pathDecision per cached path, most recently used first
+	recent *list.List
+}
+
+// pathDecision is a cached pathFilter decision.
+type pathDecision struct {
+	path    string
+	ignored bool
+}
+
+// newPathFilter returns nil when there are no patterns, so requests are not
+// matched by default.
+func newPathFilter(patterns []string) *pathFilter {
+	if len(patterns) == 0 {
+		return nil
+	}
+	f := &pathFilter{
+		exact:     make(map[string]bool),
+		decisions: make(map[string]*list.Element),
+		recent:    list.New(),
+	}
+	for _, p := range patterns {
+		leading, trailing := strings.HasPrefix(p, "*"), strings.HasSuffix(p, "*")
+		switch {
+		case p == "*":
+			f.prefixes = append(f.prefixes, "")
+		case leading && trailing:
+			f.contains = append(f.contains, p[1:len(p)-1])
+		case trailing:
+			f.prefixes = append(f.prefixes, p[:len(p)-1])
+		case leading:
+			f.suffixes = append(f.suffixes, p[1:])
+		default:
+			f.exact[p] = true
+		}
+	}
+	return f
+}
+
+// ignored reports whether path matches one of the patterns.
+func (f *pathFilter) ignored(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.decisions[path]; ok {
+		f.recent.MoveToFront(e)
+		return e.Value.(pathDecision).ignored
+	}
+	ignored := f.match(path)
+	f.decisions[path] = f.recent.PushFront(pathDecision{path: path, ignored: ignored})
+	if f.recent.Len() > maxPathDecisions {
+		oldest := f.recent.Back()
+		f.recent.Remove(oldest)
+		delete(f.decisions, oldest.Value.(pathDecision).path)
+	}
+	return ignored
+}
+
+func (f *pathFilter) match(path string) bool {
+	if f.exact[path] {
+		return true
+	}
+	for _, p := range f.prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	for _, s := range f.suffixes {
+		if strings.HasSuffix(path, s) {
+			return true
+		}
+	}
+	for _, s := range f.contains {
+		if strings.Contains(path, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldTrace reports whether Middleware traces r, per Config.IgnorePaths and
+// Config.ShouldTrace.
+func (c *Client) shouldTrace(r *http.Request) bool {
+	if c.ignoredPaths != nil && c.ignoredPaths.ignored(r.URL.Path) {
+		return false
+	}
+	return c.config.ShouldTrace == nil || c.config.ShouldTrace(r)
+}
+
+// untraced returns r for a handler when Middleware does not trace it: its
+// context has no RacewayContext, and is marked so that Track* and
+// PropagationHeaders calls with it are not strict violations.
+func untraced(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), untracedKey{}, true))
+}
+
+// isUntraced reports whether ctx belongs to a request Middleware does not
+// trace.
+func isUntraced(ctx context.Context) bool {
+	untraced, _ := ctx.Value(untracedKey{}).(bool)
+	return untraced
+}
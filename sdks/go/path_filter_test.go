@@ -0,0 +1,126 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPathFilterPatterns(t *testing.T) {
+	f := newPathFilter([]string{"/health", "/static/*", "*.css", "*/debug/*"})
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"/health", true},
+		{"/health/deep", false},
+		{"/healthz", false},
+		{"/static/app.js", true},
+		{"/static/", true},
+		{"/static", false},
+		{"/themes/dark.css", true},
+		{"/api/debug/vars", true},
+		{"/api/transfer", false},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		if got := f.ignored(tt.path); got != tt.ignored {
+			t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.ignored)
+		}
+	}
+	if newPathFilter(nil) != nil {
+		t.Error("expected no filter without patterns")
+	}
+	if !newPathFilter([]string{"*"}).ignored("/anything") {
+		t.Error(`expected "*" to match every path`)
+	}
+}
+
+func TestPathFilterCachesRecentPaths(t *testing.T) {
+	f := newPathFilter([]string{"/static/*"})
+
+	for i := 0; i < maxPathDecisions+10; i++ {
+		f.ignored("/accounts/" + strconv.Itoa(i))
+	}
+	f.ignored("/accounts/10")
+	f.ignored("/accounts/" + strconv.Itoa(maxPathDecisions+10))
+	if f.recent.Len() != maxPathDecisions || len(f.decisions) != maxPathDecisions {
+		t.Fatalf("expected %d cached decisions, got %d", maxPathDecisions, f.recent.Len())
+	}
+	if _, ok := f.decisions["/accounts/0"]; ok {
+		t.Error("expected the least recently used path evicted")
+	}
+	if _, ok := f.decisions["/accounts/10"]; !ok {
+		t.Error("expected a path used again kept")
+	}
+}
+
+func TestMiddlewareSkipsIgnoredPaths(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.IgnorePaths = []string{"/health", "/static/*"}
+		cfg.ShouldTrace = func(r *http.Request) bool { return r.Header.Get("X-Synthetic") == "" }
+	})
+
+	var traced []bool
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		traced = append(traced, FromContext(ctx) != nil)
+		c.TrackStateChange(ctx, "hits", nil, 1, "health.go:1", "Write")
+		if _, err := c.PropagationHeaders(ctx, nil); (err != nil) == (FromContext(ctx) != nil) {
+			t.Errorf("%s: PropagationHeaders error %v", r.URL.Path, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	synthetic := httptest.NewRequest("GET", "/api/accounts", nil)
+	synthetic.Header.Set("X-Synthetic", "probe")
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/health", nil),
+		httptest.NewRequest("GET", "/static/app.js", nil),
+		httptest.NewRequest("GET", "/health", nil),
+		synthetic,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s: status %d, want the handler's 204", r.URL.Path, rec.Code)
+		}
+	}
+	c.syncPipeline(synthetic.Context())
+	if events := bufferedEvents(c); len(events) != 0 {
+		t.Fatalf("expected no events for ignored requests, got %d", len(events))
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/accounts", nil))
+	c.syncPipeline(synthetic.Context())
+	if events := bufferedEvents(c); len(events) != 3 {
+		t.Errorf("expected request, write, and response events for a traced request, got %d", len(events))
+	}
+	if want := []bool{false, false, false, false, true}; !reflect.DeepEqual(traced, want) {
+		t.Errorf("handler saw a Raceway context %v, want %v", traced, want)
+	}
+}
+
+func TestIgnoredRequestsAreNotStrictViolations(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Strict = true
+		cfg.IgnorePaths = []string{"/health"}
+	})
+
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.TrackStateChange(r.Context(), "hits", nil, 1, "health.go:1", "Write")
+		if _, err := c.PropagationHeaders(r.Context(), nil); err == nil {
+			t.Error("expected an error from PropagationHeaders in an ignored request")
+		}
+	}))
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("expected no strict violation, got %v", r)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+}
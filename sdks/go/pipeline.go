@@ -0,0 +1,122 @@
+package raceway
+
+import (
+	"context"
+)
+
+// pipelineItem is a captured event on its way to the event buffer, or a
+// barrier that is closed once every event queued before it is buffered.
+type pipelineItem struct {
+	event   Event
+	barrier chan struct{}
+}
+
+// enqueue hands event to the writer goroutine without blocking. If the queue
+// is full the event is dropped and counted rather than stalling the caller.
+func (c *Client) enqueue(event Event) bool {
+	select {
+	case c.pipeline <- pipelineItem{event: event}:
+		c.stats.buffered.Add(1)
+		return true
+	default:
+		c.recordDropped(1)
+		c.eventsDropped(DropBufferFull, []Event{event})
+		c.logger.Debugf("Event queue full, dropping %s event", event.Kind.Name())
+		return false
+	}
+}
+
+// runPipeline is the writer goroutine: it moves queued events into the event
+// buffer and starts a flush whenever BatchSize events are buffered. It is the
+// only receiver until Shutdown stops it.
+func (c *Client) runPipeline() {
+	defer close(c.pipelineDone)
+	for {
+		select {
+		case item := <-c.pipeline:
+			c.mu.Lock()
+			c.bufferItemLocked(item)
+			// Take whatever else is queued under the same lock
+			for n := len(c.pipeline); n > 0; n-- {
+				c.bufferItemLocked(<-c.pipeline)
+			}
+			shouldFlush := len(c.eventBuffer) >= c.config.BatchSize
+			c.mu.Unlock()
+
+			// One batch flush at a time; it takes everything buffered so far
+			if shouldFlush && c.batchFlushing.CompareAndSwap(false, true) {
+				go func() {
+					defer c.batchFlushing.Store(false)
+					c.Flush()
+				}()
+			}
+		case <-c.stopChan:
+			c.drainPipeline()
+			return
+		}
+	}
+}
+
+// runWithoutWriter leaves the client without its writer and auto-flush
+// goroutines: queued events are moved into the buffer by whoever flushes or
+// waits for them.
+func (c *Client) runWithoutWriter() {
+	c.flushTicker.Stop()
+	close(c.pipelineDone)
+}
+
+// flushFullBatch flushes on the calling goroutine once BatchSize events are
+// queued, standing in for the writer goroutine in Config.SyncMode.
+func (c *Client) flushFullBatch() {
+	if len(c.pipeline) < c.config.BatchSize || !c.batchFlushing.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.batchFlushing.Store(false)
+	c.Flush()
+}
+
+// bufferItemLocked appends a queued event to the event buffer or releases a
+// barrier. c.mu must be held.
+func (c *Client) bufferItemLocked(item pipelineItem) {
+	if item.barrier != nil {
+		close(item.barrier)
+		return
+	}
+	c.eventBuffer = append(c.eventBuffer, item.event)
+	c.enforceBufferLimitLocked()
+}
+
+// drainPipeline buffers every queued event. It is used once the writer
+// goroutine has stopped.
+func (c *Client) drainPipeline() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		select {
+		case item := <-c.pipeline:
+			c.bufferItemLocked(item)
+		default:
+			return
+		}
+	}
+}
+
+// syncPipeline waits until every event queued before the call is in the
+// event buffer, or until ctx is done.
+func (c *Client) syncPipeline(ctx context.Context) {
+	barrier := make(chan struct{})
+	select {
+	case c.pipeline <- pipelineItem{barrier: barrier}:
+	case <-c.pipelineDone:
+		c.drainPipeline()
+		return
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-barrier:
+	case <-c.pipelineDone:
+		c.drainPipeline()
+	case <-ctx.Done():
+	}
+}
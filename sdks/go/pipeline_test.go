@@ -0,0 +1,184 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaptureDropsInsteadOfBlockingWhenQueueIsFull(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.MaxBufferedEvents = 8 })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	// Stall the writer goroutine on the buffer lock
+	c.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			c.TrackStateChange(ctx, "counter", i, i+1, "pipeline_test.go:1", "Write")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.mu.Unlock()
+		t.Fatal("tracking blocked on a full queue")
+	}
+	c.mu.Unlock()
+
+	// The writer may have taken one event off the queue before stalling
+	if dropped := c.Stats().EventsDropped; dropped < 11 || dropped > 12 {
+		t.Errorf("expected 11 or 12 dropped events, got %d", dropped)
+	}
+	if got := len(bufferedEvents(c)); uint64(got) != 20-c.Stats().EventsDropped {
+		t.Errorf("expected every queued event buffered, got %d", got)
+	}
+}
+
+func TestWriterFlushesAtBatchSize(t *testing.T) {
+	sink := &recordingSink{}
+	c := newBufferingClient(t, func(cfg *Config) { cfg.BatchSize = 5 })
+	c.router.fallback.route.Sink = sink
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 5; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "pipeline_test.go:1", "Write")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.received()) < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a flush once 5 events were buffered, got %d events", len(sink.received()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownDrainsQueue(t *testing.T) {
+	sink := &recordingSink{}
+	c := New(Config{
+		ServiceName:   "test-service",
+		BatchSize:     100000,
+		FlushInterval: time.Hour,
+		Routes:        []Route{{Name: "all", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: sink}},
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+			for i := 0; i < 500; i++ {
+				c.TrackStateChange(ctx, "counter", i, i+1, "pipeline_test.go:1", "Write")
+			}
+		}()
+	}
+	wg.Wait()
+	if err := c.ShutdownContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(sink.received()); got != 4000 {
+		t.Errorf("expected all 4000 queued events delivered on shutdown, got %d", got)
+	}
+	if stats := c.Stats(); stats.EventsBuffered != 0 || stats.EventsDropped != 0 {
+		t.Errorf("unexpected stats after shutdown %+v", stats)
+	}
+}
+
+// BenchmarkConcurrentTracking measures capture throughput from 64 goroutines.
+// Run with -blockprofile or -mutexprofile to check that tracking goroutines
+// do not contend on the client's buffer lock.
+func BenchmarkConcurrentTracking(b *testing.B) {
+	config := DefaultConfig()
+	config.ServiceName = "bench-service"
+	config.Routes = []Route{{Name: "discard", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: discardSink{}}}
+	client := New(config)
+	defer client.Shutdown()
+
+	const goroutines = 64
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		n := b.N / goroutines
+		if g < b.N%goroutines {
+			n++
+		}
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			ctx := NewContext(context.Background(), "", "bench-service", "bench-instance")
+			for i := 0; i < n; i++ {
+				client.TrackStateChange(ctx, "variable", i, i+1, "pipeline_test.go:1", "Write")
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+type discardSink struct{}
+
+func (discardSink) Send(ctx context.Context, events []Event) error { return nil }
+
+func TestSyncModeLeavesNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	sink := &recordingSink{}
+	c := New(Config{ServiceName: "test-service", SyncMode: true, Sink: sink})
+	defer c.Shutdown()
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "pipeline_test.go:1", "Write")
+	c.TrackStateChange(ctx, "counter", 1, 2, "pipeline_test.go:2", "Write")
+	if len(sink.received()) != 0 {
+		t.Fatal("expected nothing sent before a full batch or FlushSync")
+	}
+	if err := c.FlushSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.received()) != 2 {
+		t.Errorf("expected FlushSync to deliver both events, got %d", len(sink.received()))
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected no goroutines left running, went from %d to %d", before, after)
+	}
+}
+
+func TestSyncModeSendsFullBatchOnTrackingCall(t *testing.T) {
+	sink := &recordingSink{}
+	c := New(Config{ServiceName: "test-service", SyncMode: true, BatchSize: 3, Sink: sink})
+	defer c.Shutdown()
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 3; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "pipeline_test.go:1", "Write")
+	}
+	if len(sink.received()) != 3 {
+		t.Errorf("expected the call filling the batch to send it, got %d events", len(sink.received()))
+	}
+}
+
+func TestFlushSyncHonorsDeadline(t *testing.T) {
+	c := New(Config{ServiceName: "test-service", SyncMode: true, MaxRetries: 0, Sink: stallingSink{}, ShutdownTimeout: 10 * time.Millisecond})
+	defer c.Shutdown()
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackCustom(ctx, "step", nil)
+
+	deadline, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var flushErr *FlushError
+	if err := c.FlushSync(deadline); !errors.As(err, &flushErr) {
+		t.Fatalf("expected a FlushError once the deadline passed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected FlushSync to return at the deadline, took %v", elapsed)
+	}
+}
@@ -0,0 +1,111 @@
+package raceway
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	// suspectTag marks events Config.PreDetect found suspicious, with the
+	// kind of suspicion as its value.
+	suspectTag = "suspect"
+	// staleReadSuspect is a write whose old value is not the value last
+	// observed for its variable in the trace: another context wrote in
+	// between, the lost update of a read-modify-write race.
+	staleReadSuspect = "stale_read"
+)
+
+// preDetectMaxVariables bounds the variables each trace's access index keeps.
+const preDetectMaxVariables = 64
+
+// accessIndex is the last observed value of the most recently accessed
+// variables of a trace, for Config.PreDetect. Values are kept as hashes of
+// their JSON encoding, so the index neither retains nor exposes them.
+type accessIndex struct {
+	mu        sync.Mutex
+	variables map[string]*list.Element
+	recent    *list.List
+}
+
+type observedAccess struct {
+	variable string
+	eventID  string
+	value    uint64
+}
+
+// observe records change, captured as event eventID, and returns the ID of
+// the event that last observed a value of its variable if change is a write
+// whose old value differs from it.
+func (x *accessIndex) observe(change *StateChangeData, eventID string) (stale string, ok bool) {
+	if isAtomicAccess(change.AccessType) {
+		return "", false
+	}
+	value, hashed := hashValue(change.NewValue)
+	if !hashed {
+		return "", false
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.variables == nil {
+		x.variables = make(map[string]*list.Element)
+		x.recent = list.New()
+	}
+	element, seen := x.variables[change.Variable]
+	if seen && change.AccessType != "Read" && change.OldValue != nil {
+		prior := element.Value.(*observedAccess)
+		if old, hashed := hashValue(change.OldValue); hashed && old != prior.value {
+			stale, ok = prior.eventID, true
+		}
+	}
+	if seen {
+		access := element.Value.(*observedAccess)
+		access.eventID, access.value = eventID, value
+		x.recent.MoveToFront(element)
+		return stale, ok
+	}
+	x.variables[change.Variable] = x.recent.PushFront(&observedAccess{variable: change.Variable, eventID: eventID, value: value})
+	if x.recent.Len() > preDetectMaxVariables {
+		oldest := x.recent.Back()
+		x.recent.Remove(oldest)
+		delete(x.variables, oldest.Value.(*observedAccess).variable)
+	}
+	return stale, ok
+}
+
+// hashValue hashes the JSON encoding of v, reporting false for values that
+// cannot be encoded.
+func hashValue(v interface{}) (uint64, bool) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, false
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64(), true
+}
+
+// preDetect runs Config.PreDetect on a StateChange about to be captured as
+// event eventID, including in traces that are not sampled, and reports
+// whether change is a suspected stale write. The event that observed the
+// value it overwrote is tagged if it was recorded and not yet sent, and the
+// trace is recorded and sent from then on whatever its sampling decision or
+// capture mode.
+func (c *Client) preDetect(ctx context.Context, rctx *RacewayContext, change *StateChangeData, eventID string) bool {
+	if rctx.shared == nil {
+		return false
+	}
+	prior, suspect := rctx.shared.accesses.observe(change, eventID)
+	if !suspect {
+		return false
+	}
+	rctx.shared.promoted.Store(true)
+	if prior != "" {
+		c.tagBufferedEvent(rctx.TraceID, prior, map[string]string{suspectTag: staleReadSuspect})
+	}
+	c.CommitTrace(ctx)
+	return true
+}
@@ -0,0 +1,141 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// suspects returns the indexes of the events tagged by Config.PreDetect.
+func suspects(events []Event) []int {
+	var tagged []int
+	for i, event := range events {
+		if event.Metadata.Tags[suspectTag] == staleReadSuspect {
+			tagged = append(tagged, i)
+		}
+	}
+	return tagged
+}
+
+// lostUpdate replays the banking race: two threads of one trace read the
+// same balance and each writes back its own debit.
+func lostUpdate(c *Client, ctx context.Context) {
+	a, b := DeriveThread(ctx), DeriveThread(ctx)
+	c.TrackStateChange(a, "alice.balance", nil, 1000, "predetect_test.go:1", "Read")
+	c.TrackStateChange(b, "alice.balance", nil, 1000, "predetect_test.go:2", "Read")
+	c.TrackStateChange(a, "alice.balance", 1000, 900, "predetect_test.go:3", "Write")
+	c.TrackStateChange(b, "alice.balance", 1000, 800, "predetect_test.go:4", "Write")
+}
+
+func TestPreDetectTagsLostUpdate(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.PreDetect = true })
+	lostUpdate(c, NewContext(context.Background(), "", "test-service", "test-instance"))
+
+	events := bufferedEvents(c)
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	// The overwritten write and the write that overwrote it
+	if got := fmt.Sprint(suspects(events)); got != "[2 3]" {
+		t.Errorf("expected events [2 3] tagged, got %s", got)
+	}
+}
+
+func TestPreDetectIgnoresConsistentUpdates(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.PreDetect = true })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	a, b := DeriveThread(ctx), DeriveThread(ctx)
+	c.TrackStateChange(a, "alice.balance", nil, 1000, "predetect_test.go:1", "Read")
+	c.TrackStateChange(a, "alice.balance", 1000, 900, "predetect_test.go:2", "Write")
+	c.TrackStateChange(b, "alice.balance", nil, 900, "predetect_test.go:3", "Read")
+	c.TrackStateChange(b, "alice.balance", 900, 800, "predetect_test.go:4", "Write")
+	c.TrackStateChange(b, "alice.balance", 800, 700, "predetect_test.go:5", "AtomicWrite")
+	c.TrackStateChange(a, "bob.balance", 0, 100, "predetect_test.go:6", "Write")
+
+	if tagged := suspects(bufferedEvents(c)); len(tagged) != 0 {
+		t.Errorf("expected no suspects, got events %v", tagged)
+	}
+}
+
+func TestPreDetectOffByDefault(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	lostUpdate(c, NewContext(context.Background(), "", "test-service", "test-instance"))
+	if tagged := suspects(bufferedEvents(c)); len(tagged) != 0 {
+		t.Errorf("expected no suspects without PreDetect, got events %v", tagged)
+	}
+}
+
+func TestPreDetectSeparatesTraces(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.PreDetect = true })
+	first := NewContext(context.Background(), "", "test-service", "test-instance")
+	second := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(first, "alice.balance", nil, 1000, "predetect_test.go:1", "Read")
+	c.TrackStateChange(second, "alice.balance", 500, 400, "predetect_test.go:2", "Write")
+	if tagged := suspects(bufferedEvents(c)); len(tagged) != 0 {
+		t.Errorf("expected no suspects across traces, got events %v", tagged)
+	}
+}
+
+func TestPreDetectIndexIsBounded(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.PreDetect = true })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "v0", nil, 1, "predetect_test.go:1", "Read")
+	for i := 1; i <= preDetectMaxVariables; i++ {
+		c.TrackStateChange(ctx, fmt.Sprintf("v%d", i), nil, 1, "predetect_test.go:2", "Read")
+	}
+	// v0 was evicted, so its stale write goes unnoticed
+	c.TrackStateChange(ctx, "v0", 2, 3, "predetect_test.go:3", "Write")
+	if tagged := suspects(bufferedEvents(c)); len(tagged) != 0 {
+		t.Errorf("expected the evicted variable forgotten, got events %v", tagged)
+	}
+	if n := FromContext(ctx).shared.accesses.recent.Len(); n != preDetectMaxVariables {
+		t.Errorf("expected %d variables indexed, got %d", preDetectMaxVariables, n)
+	}
+}
+
+func TestPreDetectPromotesOnErrorTrace(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.CaptureMode = CaptureModeOnError
+		cfg.PreDetect = true
+	})
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lostUpdate(c, r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", nil))
+
+	events := bufferedEvents(c)
+	want := []string{"HttpRequest", "StateChange", "StateChange", "StateChange", "StateChange", "HttpResponse"}
+	if len(events) != len(want) {
+		t.Fatalf("expected the successful request's %d events sent, got %d", len(want), len(events))
+	}
+	if got := fmt.Sprint(suspects(events)); got != "[3 4]" {
+		t.Errorf("expected events [3 4] tagged, got %s", got)
+	}
+}
+
+func TestPreDetectPromotesUnsampledTrace(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.PreDetect = true
+		cfg.Sampler = func(string, string) bool { return false }
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	lostUpdate(c, ctx)
+	c.TrackStateChange(ctx, "alice.balance", 800, 700, "predetect_test.go:5", "Write")
+
+	// Only the suspicious write and what follows it were recorded
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if got := fmt.Sprint(suspects(events)); got != "[0]" {
+		t.Errorf("expected event [0] tagged, got %s", got)
+	}
+	if fmt.Sprint(events[0].Kind.StateChange.NewValue) != "800" {
+		t.Errorf("expected the stale write recorded first, got %v", events[0].Kind.StateChange.NewValue)
+	}
+}
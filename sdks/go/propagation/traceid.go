@@ -0,0 +1,98 @@
+// Package propagation converts between Raceway trace IDs (UUID strings) and
+// W3C Trace Context trace IDs (32 lowercase hex characters).
+package propagation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidLength is returned when an ID does not have the expected number of characters.
+	ErrInvalidLength = errors.New("propagation: invalid trace ID length")
+	// ErrInvalidFormat is returned when an ID contains non-hex characters or misplaced dashes.
+	ErrInvalidFormat = errors.New("propagation: invalid trace ID format")
+	// ErrZeroTraceID is returned for the all-zero trace ID, which the W3C spec declares invalid.
+	ErrZeroTraceID = errors.New("propagation: all-zero trace ID")
+)
+
+const (
+	traceIDHexLength = 32
+	uuidLength       = 36
+)
+
+// UUIDToTraceID converts a UUID (canonical 8-4-4-4-12 form, or 32 hex digits
+// without dashes) into a W3C trace ID. Input is validated strictly: short or
+// malformed input is an error rather than being padded.
+func UUIDToTraceID(value string) (string, error) {
+	var cleaned string
+	switch len(value) {
+	case uuidLength:
+		for _, i := range []int{8, 13, 18, 23} {
+			if value[i] != '-' {
+				return "", ErrInvalidFormat
+			}
+		}
+		cleaned = value[0:8] + value[9:13] + value[14:18] + value[19:23] + value[24:36]
+	case traceIDHexLength:
+		cleaned = value
+	default:
+		return "", ErrInvalidLength
+	}
+	return validateTraceID(cleaned)
+}
+
+// TraceIDToUUID converts a W3C trace ID into the canonical UUID form used for Raceway trace IDs.
+func TraceIDToUUID(value string) (string, error) {
+	if len(value) != traceIDHexLength {
+		return "", ErrInvalidLength
+	}
+	traceID, err := validateTraceID(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		traceID[0:8],
+		traceID[8:12],
+		traceID[12:16],
+		traceID[16:20],
+		traceID[20:32],
+	}, "-"), nil
+}
+
+// Normalize returns a valid W3C trace ID for any Raceway trace ID. UUIDs convert
+// exactly as UUIDToTraceID does; anything else (including application-chosen IDs
+// like "trace-123") is hashed into a stable, non-zero trace ID so that outbound
+// traceparent headers are always spec-compliant.
+func Normalize(value string) string {
+	if traceID, err := UUIDToTraceID(value); err == nil {
+		return traceID
+	}
+	sum := sha256.Sum256([]byte(value))
+	traceID := hex.EncodeToString(sum[:16])
+	if isZero(traceID) {
+		// Unreachable in practice, but the zero ID must never be emitted.
+		return "00000000000000000000000000000001"
+	}
+	return traceID
+}
+
+func validateTraceID(value string) (string, error) {
+	lowered := strings.ToLower(value)
+	for i := 0; i < len(lowered); i++ {
+		c := lowered[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", ErrInvalidFormat
+		}
+	}
+	if isZero(lowered) {
+		return "", ErrZeroTraceID
+	}
+	return lowered, nil
+}
+
+func isZero(value string) bool {
+	return strings.Trim(value, "0") == ""
+}
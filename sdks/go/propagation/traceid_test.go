@@ -0,0 +1,137 @@
+package propagation
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUUIDToTraceID(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{"canonical uuid", "0af76519-16cd-43dd-8448-eb211c80319c", "0af7651916cd43dd8448eb211c80319c", nil},
+		{"uppercase uuid", "0AF76519-16CD-43DD-8448-EB211C80319C", "0af7651916cd43dd8448eb211c80319c", nil},
+		{"bare hex", "0af7651916cd43dd8448eb211c80319c", "0af7651916cd43dd8448eb211c80319c", nil},
+		{"short input is not padded", "abc123", "", ErrInvalidLength},
+		{"empty", "", "", ErrInvalidLength},
+		{"misplaced dashes", "0af7651-916cd-43dd-8448-eb211c80319c", "", ErrInvalidFormat},
+		{"non hex", "0af76519-16cd-43dd-8448-eb211c80319z", "", ErrInvalidFormat},
+		{"all zero", "00000000-0000-0000-0000-000000000000", "", ErrZeroTraceID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UUIDToTraceID(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTraceIDToUUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{"valid", "0af7651916cd43dd8448eb211c80319c", "0af76519-16cd-43dd-8448-eb211c80319c", nil},
+		{"uppercase", "0AF7651916CD43DD8448EB211C80319C", "0af76519-16cd-43dd-8448-eb211c80319c", nil},
+		// Regression: inputs shorter than 32 characters used to panic with a slice bounds error.
+		{"short input", "0af76519", "", ErrInvalidLength},
+		{"empty", "", "", ErrInvalidLength},
+		{"non hex", "0af7651916cd43dd8448eb211c80319g", "", ErrInvalidFormat},
+		{"all zero", "00000000000000000000000000000000", "", ErrZeroTraceID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TraceIDToUUID(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("0af76519-16cd-43dd-8448-eb211c80319c"); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("expected UUIDs to convert exactly, got %q", got)
+	}
+
+	// Regression: non-UUID trace IDs used to be zero-padded into invalid traceparent values.
+	got := Normalize("trace-123")
+	if _, err := TraceIDToUUID(got); err != nil {
+		t.Errorf("expected a valid trace ID for a custom ID, got %q (%v)", got, err)
+	}
+	if Normalize("trace-123") != got {
+		t.Error("expected Normalize to be deterministic")
+	}
+	if Normalize("trace-124") == got {
+		t.Error("expected different inputs to normalize differently")
+	}
+	if _, err := TraceIDToUUID(Normalize("00000000-0000-0000-0000-000000000000")); err != nil {
+		t.Errorf("expected the zero UUID to normalize to a valid trace ID, got %v", err)
+	}
+}
+
+func FuzzUUIDToTraceID(f *testing.F) {
+	f.Add("0af76519-16cd-43dd-8448-eb211c80319c")
+	f.Add("0af7651916cd43dd8448eb211c80319c")
+	f.Add("00000000-0000-0000-0000-000000000000")
+	f.Add("abc")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		traceID, err := UUIDToTraceID(input)
+		if err != nil {
+			return
+		}
+		uuid, err := TraceIDToUUID(traceID)
+		if err != nil {
+			t.Fatalf("valid trace ID %q from %q failed to convert back: %v", traceID, input, err)
+		}
+		again, err := UUIDToTraceID(uuid)
+		if err != nil || again != traceID {
+			t.Fatalf("round trip unstable: %q -> %q -> %q (%v)", traceID, uuid, again, err)
+		}
+		if Normalize(input) != traceID {
+			t.Fatalf("Normalize disagrees with strict conversion for %q", input)
+		}
+	})
+}
+
+func FuzzTraceIDToUUID(f *testing.F) {
+	f.Add("0af7651916cd43dd8448eb211c80319c")
+	f.Add("00000000000000000000000000000000")
+	f.Add("0af76519")
+	f.Add("zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		uuid, err := TraceIDToUUID(input)
+		if err != nil {
+			if _, nerr := TraceIDToUUID(Normalize(input)); nerr != nil {
+				t.Fatalf("Normalize(%q) produced an invalid trace ID: %v", input, nerr)
+			}
+			return
+		}
+		traceID, err := UUIDToTraceID(uuid)
+		if err != nil {
+			t.Fatalf("valid UUID %q from %q failed to convert back: %v", uuid, input, err)
+		}
+		back, _ := TraceIDToUUID(traceID)
+		if back != uuid {
+			t.Fatalf("round trip unstable: %q -> %q -> %q", input, uuid, back)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package raceway
+
+import (
+	"context"
+	"fmt"
+)
+
+// queueTag names the queue a consumer's events handle a message from.
+const queueTag = "queue"
+
+// MessageAttributes returns the propagation headers for ctx, to embed in a
+// queue message's attributes, such as SQS message attributes or AMQP
+// headers. They carry the same data as PropagationHeaders, but ctx is not
+// marked Distributed: the trace only crosses services once a consumer
+// continues it with StartConsumerTrace.
+//
+// Example:
+//
+//	attrs, err := client.MessageAttributes(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	for k, v := range attrs {
+//	    input.MessageAttributes[k] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+//	}
+func (c *Client) MessageAttributes(ctx context.Context) (map[string]string, error) {
+	if BuildDisabled || c.disabled {
+		return map[string]string{}, nil
+	}
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		if c.config.Strict && !isUntraced(ctx) {
+			c.strictViolation(StrictMissingContext, "message attributes requested outside of Raceway context")
+		}
+		return nil, fmt.Errorf("raceway: message attributes requested outside of active context")
+	}
+	attrs := MapCarrier{}
+	c.contextHeaders(attrs, rctx, nil)
+	return attrs, nil
+}
+
+// StartConsumerTrace returns a copy of ctx carrying a Raceway context that
+// continues the trace in a consumed message's attributes, written by
+// MessageAttributes or Inject, and records an AsyncAwait on the message's
+// span so the consumer is ordered after the producer. Every event recorded
+// with the returned context is tagged with queueName. Attributes without
+// trace context start a new trace, rooted at the AsyncAwait.
+//
+// Example:
+//
+//	for _, msg := range out.Messages {
+//	    ctx := client.StartConsumerTrace(ctx, attributes(msg), "orders")
+//	    handle(ctx, msg)
+//	}
+func (c *Client) StartConsumerTrace(ctx context.Context, attrs map[string]string, queueName string) context.Context {
+	if BuildDisabled || c.disabled {
+		return ctx
+	}
+	parsed := c.Extract(MapCarrier(attrs), "", "")
+	var ctxWith context.Context
+	if parsed.Distributed {
+		ctxWith = c.contextFromParsed(ctx, parsed)
+	} else {
+		ctxWith = c.newContext(ctx, "")
+		rctx := FromContext(ctxWith)
+		rctx.decideSampling(nil, func() bool { return c.sampleTrace(rctx.TraceID, queueName) })
+	}
+	rctx := FromContext(ctxWith)
+	rctx.setTag(queueTag, queueName)
+	c.captureEvent(ctxWith, EventKind{
+		AsyncAwait: &AsyncAwaitData{
+			FutureID:  rctx.SpanID,
+			AwaitedAt: c.captureLocation(2),
+		},
+	})
+	return ctxWith
+}
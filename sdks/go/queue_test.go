@@ -0,0 +1,91 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProduceThenConsumeContinuesClock(t *testing.T) {
+	producer := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "orders", "o1" })
+	consumer := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "billing", "b1" })
+
+	ctx := producer.newContext(context.Background(), validTraceID)
+	producer.TrackStateChange(ctx, "order.status", "new", "placed", "queue_test.go:1", "Write")
+	producer.TrackStateChange(ctx, "order.total", 0, 42, "queue_test.go:2", "Write")
+	attrs, err := producer.MessageAttributes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs[traceparentHeader] == "" || attrs[racewayClockHeader] == "" {
+		t.Fatalf("expected traceparent and raceway-clock attributes, got %v", attrs)
+	}
+	if FromContext(ctx).Distributed {
+		t.Error("expected the producer context not to be marked distributed")
+	}
+
+	consumerCtx := consumer.StartConsumerTrace(context.Background(), attrs, "orders-queue")
+	rctx := FromContext(consumerCtx)
+	if rctx.TraceID != validTraceID || !rctx.Distributed {
+		t.Fatalf("expected the consumer to continue trace %s, got %+v", validTraceID, rctx)
+	}
+	consumer.TrackStateChange(consumerCtx, "invoice.total", 0, 42, "queue_test.go:3", "Write")
+
+	events := bufferedEvents(consumer)
+	if len(events) != 2 || events[0].Kind.AsyncAwait == nil {
+		t.Fatalf("expected an AsyncAwait then the consumer's event, got %+v", events)
+	}
+	await := events[0]
+	if await.Kind.AsyncAwait.FutureID != rctx.SpanID {
+		t.Errorf("expected the await on the message span %s, got %s", rctx.SpanID, await.Kind.AsyncAwait.FutureID)
+	}
+	for i, want := range []map[string]uint64{
+		{"orders#o1": 2, "billing#b1": 1},
+		{"orders#o1": 2, "billing#b1": 2},
+	} {
+		got := events[i].CausalityVector
+		if len(got) != len(want) {
+			t.Errorf("event %d: expected %v, got %v", i, want, got)
+		}
+		for component, value := range want {
+			if !hasClockComponent(got, component, value) {
+				t.Errorf("event %d: expected %s:%d, got %v", i, component, value, got)
+			}
+		}
+		if events[i].Metadata.Tags[queueTag] != "orders-queue" {
+			t.Errorf("event %d: expected the queue tag, got %v", i, events[i].Metadata.Tags)
+		}
+	}
+}
+
+func TestStartConsumerTraceWithoutAttributes(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	ctx := c.StartConsumerTrace(context.Background(), map[string]string{"content-type": "application/json"}, "jobs")
+	rctx := FromContext(ctx)
+	if rctx == nil || rctx.TraceID == "" || rctx.Distributed {
+		t.Fatalf("expected a new root trace, got %+v", rctx)
+	}
+	events := bufferedEvents(c)
+	if len(events) != 1 || events[0].Kind.AsyncAwait == nil || events[0].TraceID != rctx.TraceID {
+		t.Fatalf("expected the AsyncAwait to root the trace, got %+v", events)
+	}
+	if got := events[0].CausalityVector; len(got) != 1 || !hasClockComponent(got, "test-service#test-instance", 1) {
+		t.Errorf("expected only the consumer's clock, got %v", got)
+	}
+	if events[0].Metadata.Tags[queueTag] != "jobs" {
+		t.Errorf("expected the queue tag, got %v", events[0].Metadata.Tags)
+	}
+
+	if other := FromContext(c.StartConsumerTrace(context.Background(), nil, "jobs")); other.TraceID == rctx.TraceID {
+		t.Error("expected each message without attributes in a trace of its own")
+	}
+}
+
+func TestMessageAttributesOutsideContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	if _, err := c.MessageAttributes(context.Background()); err == nil {
+		t.Error("expected an error outside of a Raceway context")
+	}
+}
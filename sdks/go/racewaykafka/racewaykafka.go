@@ -0,0 +1,78 @@
+// Package racewaykafka carries Raceway trace context in Kafka message
+// headers.
+//
+// Kafka clients such as github.com/segmentio/kafka-go and
+// github.com/confluentinc/confluent-kafka-go represent headers as a slice of
+// structs with a string Key and a []byte Value. Headers adapts a pointer to
+// such a slice to raceway.Carrier without importing either client:
+//
+//	msg := kafka.Message{Topic: "orders", Value: payload}
+//	if err := client.Inject(ctx, racewaykafka.Headers(&msg.Headers)); err != nil {
+//	    log.Printf("raceway: %v", err)
+//	}
+//
+// and on the consumer side:
+//
+//	parsed := client.Extract(racewaykafka.Headers(&msg.Headers), "", "")
+//	ctx := client.ContextFromParsed(ctx, parsed)
+package racewaykafka
+
+import (
+	"strings"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// Header is the shape of a Kafka record header shared by the common clients.
+type Header interface {
+	~struct {
+		Key   string
+		Value []byte
+	}
+}
+
+// header is the common underlying type of every Header; type parameters do
+// not expose struct fields, so headers are converted to it.
+type header = struct {
+	Key   string
+	Value []byte
+}
+
+// Headers returns a raceway.Carrier over the header slice at headers. Set
+// replaces an existing header whose key matches case-insensitively or appends
+// a new one; Get returns the first match.
+func Headers[H Header](headers *[]H) raceway.Carrier {
+	return &carrier[H]{headers: headers}
+}
+
+type carrier[H Header] struct {
+	headers *[]H
+}
+
+func (c *carrier[H]) Set(key, value string) {
+	set := H(header{Key: key, Value: []byte(value)})
+	for i, h := range *c.headers {
+		if strings.EqualFold(header(h).Key, key) {
+			(*c.headers)[i] = set
+			return
+		}
+	}
+	*c.headers = append(*c.headers, set)
+}
+
+func (c *carrier[H]) Get(key string) string {
+	for _, h := range *c.headers {
+		if h := header(h); strings.EqualFold(h.Key, key) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c *carrier[H]) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, header(h).Key)
+	}
+	return keys
+}
@@ -0,0 +1,70 @@
+//go:build !raceway_disabled
+
+package racewaykafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// kafkaHeader mirrors kafka.Header in the common Kafka clients.
+type kafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+func newClient(t *testing.T, service string) *raceway.Client {
+	t.Helper()
+	client := raceway.New(raceway.Config{ServiceName: service, InstanceID: "1", FlushInterval: time.Hour, Sink: raceway.NoopSink{}})
+	t.Cleanup(func() { client.Shutdown() })
+	return client
+}
+
+func TestHeadersRoundTripTraceContext(t *testing.T) {
+	producer, consumer := newClient(t, "orders"), newClient(t, "billing")
+	ctx := raceway.NewContext(context.Background(), "", "orders", "1")
+	producer.TrackStateChange(ctx, "order.status", "new", "placed", "racewaykafka_test.go:1", "Write")
+
+	headers := []kafkaHeader{{Key: "content-type", Value: []byte("application/json")}}
+	if err := producer.Inject(ctx, Headers(&headers)); err != nil {
+		t.Fatal(err)
+	}
+	// Injecting again replaces the trace headers instead of appending
+	n := len(headers)
+	if err := producer.Inject(ctx, Headers(&headers)); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != n || headers[0].Key != "content-type" {
+		t.Fatalf("expected existing headers kept and trace headers replaced, got %v", headers)
+	}
+
+	parsed := consumer.Extract(Headers(&headers), "", "")
+	rctx := raceway.FromContext(ctx)
+	if parsed.TraceID != rctx.TraceID || !parsed.Distributed {
+		t.Fatalf("expected the consumer to continue trace %s, got %+v", rctx.TraceID, parsed)
+	}
+	found := false
+	for _, entry := range parsed.ClockVector {
+		if entry.Component() == "orders#1" && entry.Value() == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the producer's clock orders#1:1 after one event and two injects, got %v", parsed.ClockVector)
+	}
+}
+
+func TestHeadersKeys(t *testing.T) {
+	headers := []kafkaHeader{{Key: "traceparent", Value: []byte("x")}}
+	carrier := Headers(&headers)
+	carrier.Set("Raceway-Clock", "y")
+	if keys := carrier.Keys(); len(keys) != 2 || keys[1] != "Raceway-Clock" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if carrier.Get("TRACEPARENT") != "x" || carrier.Get("missing") != "" {
+		t.Errorf("expected case-insensitive lookups")
+	}
+}
@@ -0,0 +1,53 @@
+package racewayscenarios
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// traceNamespace seeds the name-based UUIDs used as scenario trace IDs.
+var traceNamespace = uuid.MustParse("6f1c2e0a-5b7d-4c3e-9a8f-72d4b1e0c9a5")
+
+// TraceID returns the trace ID a scenario is emitted on for the given run.
+// It is a name-based UUID, so the same scenario and run ID always map to the
+// same trace.
+func TraceID(scenario, runID string) string {
+	return uuid.NewSHA1(traceNamespace, []byte(runID+"/"+scenario)).String()
+}
+
+// Emit replays script through client on TraceID(script.Scenario, runID) and
+// returns the trace ID. Steps run sequentially in script order, each on its
+// own virtual thread, so the captured interleaving is exactly the scripted one.
+// Lock events carry Emit's own call site as their location, since the SDK
+// captures it automatically.
+func Emit(ctx context.Context, client *raceway.Client, script Script, runID string) string {
+	traceID := TraceID(script.Scenario, runID)
+	root := raceway.NewContext(ctx, traceID, client.ServiceName(), client.InstanceID())
+
+	threads := make([]context.Context, script.Threads)
+	threads[0] = root
+	for _, step := range script.Steps {
+		if step.Op == OpSpawn {
+			task := fmt.Sprintf("worker%d", step.Thread)
+			client.TrackAsyncSpawn(root, task, task, script.Scenario+":spawn")
+			threads[step.Thread] = raceway.InheritShared.Apply(root)
+			continue
+		}
+
+		ctx := threads[step.Thread]
+		switch step.Op {
+		case OpRead:
+			client.TrackStateChange(ctx, step.Target, step.Old, step.New, step.Location, "Read")
+		case OpWrite:
+			client.TrackStateChange(ctx, step.Target, step.Old, step.New, step.Location, "Write")
+		case OpAcquire:
+			client.TrackLockAcquire(ctx, step.Target, "Mutex")
+		case OpRelease:
+			client.TrackLockRelease(ctx, step.Target, "Mutex")
+		}
+	}
+	return traceID
+}
@@ -0,0 +1,341 @@
+package racewayscenarios
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// QueryClient reads analysis results from the Raceway server's query API.
+type QueryClient struct {
+	// ServerURL is the Raceway server URL
+	ServerURL string
+	// APIKey is sent as a bearer token, if set
+	APIKey string
+	// HTTPClient is used for requests (default: http.DefaultClient)
+	HTTPClient *http.Client
+}
+
+// errTraceNotFound is returned while the server has not ingested a trace yet.
+var errTraceNotFound = errors.New("racewayscenarios: trace not found")
+
+// TraceFindings returns the races the server reports for traceID.
+func (q *QueryClient) TraceFindings(ctx context.Context, traceID string) ([]Finding, error) {
+	url := strings.TrimRight(q.ServerURL, "/") + "/api/traces/" + traceID
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if q.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+q.APIKey)
+	}
+
+	httpClient := q.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errTraceNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("racewayscenarios: query returned status %d, body: %s", resp.StatusCode, body)
+	}
+
+	var body struct {
+		Data struct {
+			Analysis struct {
+				RaceDetails []struct {
+					Severity       string `json:"severity"`
+					Variable       string `json:"variable"`
+					Event1Location string `json:"event1_location"`
+					Event2Location string `json:"event2_location"`
+				} `json:"race_details"`
+			} `json:"analysis"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("racewayscenarios: decoding trace analysis: %w", err)
+	}
+
+	findings := make([]Finding, 0, len(body.Data.Analysis.RaceDetails))
+	for _, race := range body.Data.Analysis.RaceDetails {
+		findings = append(findings, Finding{
+			Kind:      FindingRace,
+			Variable:  race.Variable,
+			Severity:  race.Severity,
+			Locations: [2]string{race.Event1Location, race.Event2Location},
+		})
+	}
+	return findings, nil
+}
+
+// matches reports whether reported satisfies the expected finding. The event
+// pair may be reported in either order.
+func (f Finding) matches(reported Finding) bool {
+	if f.Kind != reported.Kind || f.Variable != reported.Variable {
+		return false
+	}
+	if f.Severity != "" && f.Severity != reported.Severity {
+		return false
+	}
+	return f.Locations == reported.Locations ||
+		f.Locations == [2]string{reported.Locations[1], reported.Locations[0]}
+}
+
+// missing returns the expected findings that are absent from reported.
+func missing(expected, reported []Finding) []Finding {
+	var absent []Finding
+	for _, want := range expected {
+		found := false
+		for _, got := range reported {
+			if want.matches(got) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			absent = append(absent, want)
+		}
+	}
+	return absent
+}
+
+// Runner emits scenarios through a real client and checks the server's findings.
+type Runner struct {
+	// ServerURL is the Raceway server receiving events and answering queries
+	ServerURL string
+	// APIKey authenticates queries, if the server requires it
+	APIKey string
+	// ServiceName is reported on emitted events (default: "raceway-scenarios")
+	ServiceName string
+	// Params parameterize every scenario
+	Params Params
+	// Timeout bounds how long each scenario waits for its findings (default: 30s)
+	Timeout time.Duration
+	// PollInterval is the delay between queries (default: 500ms)
+	PollInterval time.Duration
+	// HTTPClient is used for queries (default: http.DefaultClient)
+	HTTPClient *http.Client
+}
+
+// Run emits each scenario and waits for the server to report its expected
+// findings. Scenarios whose findings the server cannot report yet are skipped.
+// Run returns an error only if the client cannot be used at all; per-scenario
+// failures are recorded in the report.
+func (r *Runner) Run(ctx context.Context, scenarios ...Scenario) (*Report, error) {
+	if r.ServerURL == "" {
+		return nil, errors.New("racewayscenarios: ServerURL is required")
+	}
+	serviceName := r.ServiceName
+	if serviceName == "" {
+		serviceName = "raceway-scenarios"
+	}
+
+	config := raceway.DefaultConfig()
+	config.ServerURL = r.ServerURL
+	config.ServiceName = serviceName
+	config.InstanceID = "scenarios"
+	config.BatchSize = raceway.DefaultMaxBufferedEvents
+	config.FlushInterval = time.Hour
+	client := raceway.New(config)
+	defer client.Shutdown()
+
+	query := &QueryClient{ServerURL: r.ServerURL, APIKey: r.APIKey, HTTPClient: r.HTTPClient}
+	report := &Report{Name: "raceway-scenarios"}
+	for _, scenario := range scenarios {
+		start := time.Now()
+		result := r.runScenario(ctx, client, query, scenario)
+		result.Duration = time.Since(start)
+		report.Cases = append(report.Cases, result)
+	}
+	return report, nil
+}
+
+func (r *Runner) runScenario(ctx context.Context, client *raceway.Client, query *QueryClient, scenario Scenario) CaseResult {
+	script := scenario.Generate(r.Params)
+	result := CaseResult{Scenario: scenario.Name}
+
+	for _, finding := range script.Expected {
+		if finding.Kind != FindingRace {
+			result.Status = StatusSkipped
+			result.Message = fmt.Sprintf("server does not report %s findings", finding.Kind)
+			return result
+		}
+	}
+
+	result.TraceID = Emit(ctx, client, script, r.Params.RunID)
+	if err := client.FlushContext(ctx); err != nil {
+		result.Status = StatusError
+		result.Message = err.Error()
+		return result
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	absent := script.Expected
+	var lastErr error
+	for {
+		reported, err := query.TraceFindings(waitCtx, result.TraceID)
+		if err == nil {
+			if absent = missing(script.Expected, reported); len(absent) == 0 {
+				result.Status = StatusPassed
+				return result
+			}
+		} else if !errors.Is(err, errTraceNotFound) {
+			lastErr = err
+		}
+
+		select {
+		case <-waitCtx.Done():
+			result.Status = StatusFailed
+			result.Missing = absent
+			lines := make([]string, 0, len(absent)+1)
+			lines = append(lines, fmt.Sprintf("%d of %d expected findings not reported", len(absent), len(script.Expected)))
+			for _, finding := range absent {
+				lines = append(lines, "  "+finding.String())
+			}
+			if lastErr != nil {
+				lines = append(lines, "last query error: "+lastErr.Error())
+			}
+			result.Message = strings.Join(lines, "\n")
+			return result
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Status is the outcome of one scenario.
+type Status string
+
+const (
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+	StatusError   Status = "error"
+)
+
+// CaseResult is the outcome of one scenario run.
+type CaseResult struct {
+	Scenario string
+	TraceID  string
+	Status   Status
+	Message  string
+	Missing  []Finding
+	Duration time.Duration
+}
+
+// Report collects scenario results.
+type Report struct {
+	Name  string
+	Cases []CaseResult
+}
+
+// Passed reports whether no scenario failed or errored.
+func (r *Report) Passed() bool {
+	for _, c := range r.Cases {
+		if c.Status == StatusFailed || c.Status == StatusError {
+			return false
+		}
+	}
+	return true
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report as a JUnit XML test suite.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suite := junitSuite{Name: r.Name, Tests: len(r.Cases)}
+	var total time.Duration
+	for _, c := range r.Cases {
+		total += c.Duration
+		tc := junitCase{
+			Name:      c.Scenario,
+			ClassName: r.Name,
+			Time:      seconds(c.Duration),
+		}
+		if c.TraceID != "" {
+			tc.SystemOut = "trace_id=" + c.TraceID
+		}
+		summary, _, _ := strings.Cut(c.Message, "\n")
+		switch c.Status {
+		case StatusFailed:
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: summary, Body: c.Message}
+		case StatusError:
+			suite.Errors++
+			tc.Error = &junitFailure{Message: summary, Body: c.Message}
+		case StatusSkipped:
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{Message: c.Message}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,322 @@
+// Package racewayscenarios generates canonical race patterns as scripted SDK
+// calls with known expected findings, and runs them against a Raceway server
+// to check that the whole pipeline still detects them.
+//
+// Generators are pure: the same Params always produce the same Script, and
+// Emit replays a Script in step order on a trace ID derived from the scenario
+// name and Params.RunID, so repeated runs produce comparable traces.
+package racewayscenarios
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Op is a single scripted SDK call.
+type Op string
+
+const (
+	// OpSpawn starts Step.Thread as a new virtual thread of the scenario's root thread.
+	OpSpawn Op = "spawn"
+	// OpRead tracks a Read of Step.Target.
+	OpRead Op = "read"
+	// OpWrite tracks a Write of Step.Target from Step.Old to Step.New.
+	OpWrite Op = "write"
+	// OpAcquire tracks acquiring the Mutex Step.Target.
+	OpAcquire Op = "acquire"
+	// OpRelease tracks releasing the Mutex Step.Target.
+	OpRelease Op = "release"
+)
+
+// Step is one SDK call made by one virtual thread. Thread 0 is the root thread.
+type Step struct {
+	Thread   int         `json:"thread"`
+	Op       Op          `json:"op"`
+	Target   string      `json:"target,omitempty"`
+	Old      interface{} `json:"old,omitempty"`
+	New      interface{} `json:"new,omitempty"`
+	Location string      `json:"location,omitempty"`
+}
+
+// FindingKind classifies an expected finding.
+type FindingKind string
+
+const (
+	// FindingRace is a pair of conflicting, unordered accesses to Variable,
+	// reported by the server's trace race analysis.
+	FindingRace FindingKind = "race"
+	// FindingLockOrder is a pair of locks acquired in opposite orders by
+	// different threads. The server does not report these yet.
+	FindingLockOrder FindingKind = "lock_order"
+)
+
+// Severities reported by the server for FindingRace.
+const (
+	SeverityCritical = "CRITICAL" // write-write
+	SeverityWarning  = "WARNING"  // read-write
+)
+
+// Finding is a result the server is expected to report for a scenario.
+type Finding struct {
+	Kind FindingKind `json:"kind"`
+	// Variable is the raced variable, or for FindingLockOrder the two locks joined by " -> "
+	Variable string `json:"variable"`
+	Severity string `json:"severity,omitempty"`
+	// Locations identify the event pair, in either order
+	Locations [2]string `json:"locations"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s %s %s [%s, %s]", f.Kind, f.Severity, f.Variable, f.Locations[0], f.Locations[1])
+}
+
+// Script is the event sequence generated for one scenario run.
+type Script struct {
+	Scenario string    `json:"scenario"`
+	Threads  int       `json:"threads"`
+	Steps    []Step    `json:"steps"`
+	Expected []Finding `json:"expected"`
+}
+
+// Params parameterize the generators.
+type Params struct {
+	// Namespace prefixes variable and lock names (default: "scenario")
+	Namespace string
+	// Workers is the number of racing threads where a scenario supports more
+	// than two (default: 2)
+	Workers int
+	// RunID is mixed into trace IDs so runs against a shared server stay apart
+	RunID string
+}
+
+func (p Params) withDefaults() Params {
+	if p.Namespace == "" {
+		p.Namespace = "scenario"
+	}
+	if p.Workers < 2 {
+		p.Workers = 2
+	}
+	return p
+}
+
+// Scenario is a named generator for a known race pattern.
+type Scenario struct {
+	Name        string
+	Description string
+	generate    func(b *builder, p Params)
+}
+
+// Generate returns the scripted event sequence and expected findings for p.
+func (s Scenario) Generate(p Params) Script {
+	p = p.withDefaults()
+	b := &builder{script: Script{Scenario: s.Name, Threads: 1}}
+	s.generate(b, p)
+	return b.script
+}
+
+var (
+	// LostUpdate is the banking transfer: every worker reads the balance,
+	// then writes back its own result, overwriting the others' updates.
+	LostUpdate = Scenario{
+		Name:        "lost_update",
+		Description: "read-modify-write of an account balance without a lock",
+		generate:    generateLostUpdate,
+	}
+	// CheckThenAct is a map lookup followed by an insert on a miss, with the
+	// check and the insert not covered by one critical section.
+	CheckThenAct = Scenario{
+		Name:        "check_then_act",
+		Description: "map membership check followed by an unguarded insert",
+		generate:    generateCheckThenAct,
+	}
+	// DoubleCheckedLocking is lazy initialization whose fast path reads the
+	// instance without the lock while another thread initializes it.
+	DoubleCheckedLocking = Scenario{
+		Name:        "double_checked_locking",
+		Description: "unlocked fast-path read racing with locked initialization",
+		generate:    generateDoubleCheckedLocking,
+	}
+	// LockOrderInversion acquires two locks in opposite orders on two threads,
+	// a deadlock candidate even when this particular run did not deadlock.
+	LockOrderInversion = Scenario{
+		Name:        "lock_order_inversion",
+		Description: "two locks acquired in opposite orders by two threads",
+		generate:    generateLockOrderInversion,
+	}
+	// StaleReadAfterRelease reads under a lock, releases it, and later writes
+	// a value computed from the stale read under a new acquisition.
+	StaleReadAfterRelease = Scenario{
+		Name:        "stale_read_after_release",
+		Description: "write based on a read made in an earlier critical section",
+		generate:    generateStaleReadAfterRelease,
+	}
+)
+
+// All returns every scenario in a stable order.
+func All() []Scenario {
+	return []Scenario{LostUpdate, CheckThenAct, DoubleCheckedLocking, LockOrderInversion, StaleReadAfterRelease}
+}
+
+// builder appends steps and expected findings to a script.
+type builder struct {
+	script Script
+}
+
+func (b *builder) spawn(thread int) {
+	b.script.Steps = append(b.script.Steps, Step{Thread: thread, Op: OpSpawn})
+	if thread+1 > b.script.Threads {
+		b.script.Threads = thread + 1
+	}
+}
+
+func (b *builder) step(thread int, op Op, target string, old, new interface{}, location string) string {
+	b.script.Steps = append(b.script.Steps, Step{Thread: thread, Op: op, Target: target, Old: old, New: new, Location: location})
+	return location
+}
+
+func (b *builder) read(thread int, variable string, value interface{}, location string) string {
+	return b.step(thread, OpRead, variable, value, value, location)
+}
+
+func (b *builder) write(thread int, variable string, old, new interface{}, location string) string {
+	return b.step(thread, OpWrite, variable, old, new, location)
+}
+
+func (b *builder) acquire(thread int, lock, location string) {
+	b.step(thread, OpAcquire, lock, nil, nil, location)
+}
+
+func (b *builder) release(thread int, lock, location string) {
+	b.step(thread, OpRelease, lock, nil, nil, location)
+}
+
+func (b *builder) expect(kind FindingKind, variable, severity, loc1, loc2 string) {
+	b.script.Expected = append(b.script.Expected, Finding{Kind: kind, Variable: variable, Severity: severity, Locations: [2]string{loc1, loc2}})
+}
+
+// expectRaces expects a race for every pair of accesses from different
+// threads where at least one access is a write.
+func (b *builder) expectRaces(variable string, accesses []access) {
+	for i := range accesses {
+		for j := i + 1; j < len(accesses); j++ {
+			a, c := accesses[i], accesses[j]
+			if a.thread == c.thread || (!a.write && !c.write) {
+				continue
+			}
+			severity := SeverityWarning
+			if a.write && c.write {
+				severity = SeverityCritical
+			}
+			b.expect(FindingRace, variable, severity, a.location, c.location)
+		}
+	}
+	sort.SliceStable(b.script.Expected, func(i, j int) bool {
+		return b.script.Expected[i].Severity < b.script.Expected[j].Severity
+	})
+}
+
+type access struct {
+	thread   int
+	write    bool
+	location string
+}
+
+// location returns a synthetic source location such as "lost_update:worker1:read_balance".
+func (b *builder) location(thread int, what string) string {
+	return fmt.Sprintf("%s:worker%d:%s", b.script.Scenario, thread, what)
+}
+
+func generateLostUpdate(b *builder, p Params) {
+	const balance, amount = 1000, 100
+	variable := p.Namespace + ".accounts[alice].balance"
+	for w := 1; w <= p.Workers; w++ {
+		b.spawn(w)
+	}
+
+	var accesses []access
+	for w := 1; w <= p.Workers; w++ {
+		accesses = append(accesses, access{w, false, b.read(w, variable, balance, b.location(w, "read_balance"))})
+	}
+	for w := 1; w <= p.Workers; w++ {
+		accesses = append(accesses, access{w, true, b.write(w, variable, balance, balance-amount, b.location(w, "write_balance"))})
+	}
+	b.expectRaces(variable, accesses)
+}
+
+func generateCheckThenAct(b *builder, p Params) {
+	variable := p.Namespace + ".sessions[user-42]"
+	for w := 1; w <= p.Workers; w++ {
+		b.spawn(w)
+	}
+
+	var accesses []access
+	for w := 1; w <= p.Workers; w++ {
+		accesses = append(accesses, access{w, false, b.read(w, variable, nil, b.location(w, "check_missing"))})
+	}
+	for w := 1; w <= p.Workers; w++ {
+		session := fmt.Sprintf("session-%d", w)
+		accesses = append(accesses, access{w, true, b.write(w, variable, nil, session, b.location(w, "insert"))})
+	}
+	b.expectRaces(variable, accesses)
+}
+
+func generateDoubleCheckedLocking(b *builder, p Params) {
+	variable := p.Namespace + ".config.instance"
+	lock := p.Namespace + ".config.mu"
+	b.spawn(1)
+	b.spawn(2)
+
+	// Worker 1 takes the slow path and initializes the instance under the lock
+	check1 := b.read(1, variable, nil, b.location(1, "fast_check"))
+	b.acquire(1, lock, b.location(1, "lock"))
+	b.read(1, variable, nil, b.location(1, "locked_check"))
+	init := b.write(1, variable, nil, "config@v1", b.location(1, "initialize"))
+	b.release(1, lock, b.location(1, "unlock"))
+
+	// Worker 2 sees the instance on the unlocked fast path and never synchronizes
+	check2 := b.read(2, variable, "config@v1", b.location(2, "fast_check"))
+
+	b.expectRaces(variable, []access{{1, false, check1}, {1, true, init}, {2, false, check2}})
+}
+
+func generateLockOrderInversion(b *builder, p Params) {
+	first, second := p.Namespace+".ledger.mu", p.Namespace+".audit.mu"
+	b.spawn(1)
+	b.spawn(2)
+
+	b.acquire(1, first, b.location(1, "lock_ledger"))
+	b.acquire(1, second, b.location(1, "lock_audit"))
+	b.release(1, second, b.location(1, "unlock_audit"))
+	b.release(1, first, b.location(1, "unlock_ledger"))
+
+	b.acquire(2, second, b.location(2, "lock_audit"))
+	b.acquire(2, first, b.location(2, "lock_ledger"))
+	b.release(2, first, b.location(2, "unlock_ledger"))
+	b.release(2, second, b.location(2, "unlock_audit"))
+
+	b.expect(FindingLockOrder, first+" -> "+second, "", b.location(1, "lock_audit"), b.location(2, "lock_ledger"))
+}
+
+func generateStaleReadAfterRelease(b *builder, p Params) {
+	const stock = 5
+	variable := p.Namespace + ".inventory[sku-7].stock"
+	lock := p.Namespace + ".inventory.mu"
+	b.spawn(1)
+	b.spawn(2)
+
+	b.acquire(1, lock, b.location(1, "lock_read"))
+	read := b.read(1, variable, stock, b.location(1, "read_stock"))
+	b.release(1, lock, b.location(1, "unlock_read"))
+
+	b.acquire(2, lock, b.location(2, "lock"))
+	b.read(2, variable, stock, b.location(2, "read_stock"))
+	other := b.write(2, variable, stock, stock-1, b.location(2, "reserve"))
+	b.release(2, lock, b.location(2, "unlock"))
+
+	b.acquire(1, lock, b.location(1, "lock_write"))
+	stale := b.write(1, variable, stock, stock-1, b.location(1, "reserve_stale"))
+	b.release(1, lock, b.location(1, "unlock_write"))
+
+	b.expect(FindingRace, variable, SeverityCritical, stale, other)
+	b.expect(FindingRace, variable, SeverityWarning, read, other)
+}
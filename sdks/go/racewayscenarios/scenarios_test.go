@@ -0,0 +1,288 @@
+//go:build !raceway_disabled
+
+package racewayscenarios
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+var update = flag.Bool("update", false, "rewrite golden fixtures")
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []raceway.Event
+}
+
+func (s *recordingSink) Send(ctx context.Context, events []raceway.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func newRecordingClient(t *testing.T) (*raceway.Client, *recordingSink) {
+	t.Helper()
+	sink := &recordingSink{}
+	config := raceway.DefaultConfig()
+	config.ServiceName = "raceway-scenarios"
+	config.InstanceID = "scenarios"
+	config.Region = "test"
+	config.BatchSize = 10000
+	config.FlushInterval = time.Hour
+	config.Routes = []raceway.Route{{Name: "all", Sink: sink}}
+	client := raceway.New(config)
+	t.Cleanup(func() { client.Shutdown() })
+	return client, sink
+}
+
+// normalizedEvent is the run-independent view of an event compared against
+// golden fixtures: random IDs are replaced by their order of appearance.
+type normalizedEvent struct {
+	Event    string          `json:"event"`
+	Parent   string          `json:"parent,omitempty"`
+	Thread   string          `json:"thread"`
+	Kind     string          `json:"kind"`
+	Target   string          `json:"target,omitempty"`
+	Access   string          `json:"access,omitempty"`
+	Location string          `json:"location,omitempty"`
+	Old      json.RawMessage `json:"old,omitempty"`
+	New      json.RawMessage `json:"new,omitempty"`
+	Clock    []string        `json:"clock"`
+}
+
+func normalize(events []raceway.Event) []normalizedEvent {
+	ids := map[string]string{}
+	threads := map[string]string{}
+	name := func(m map[string]string, prefix, id string) string {
+		if _, ok := m[id]; !ok {
+			m[id] = fmt.Sprintf("%s%d", prefix, len(m))
+		}
+		return m[id]
+	}
+
+	out := make([]normalizedEvent, 0, len(events))
+	for _, event := range events {
+		n := normalizedEvent{
+			Event:  name(ids, "e", event.ID),
+			Thread: name(threads, "t", event.Metadata.ThreadID),
+			Kind:   event.Kind.Name(),
+		}
+		if event.ParentID != nil {
+			n.Parent = name(ids, "e", *event.ParentID)
+		}
+		for _, entry := range event.CausalityVector {
+			n.Clock = append(n.Clock, fmt.Sprintf("%s=%d", entry.Component(), entry.Value()))
+		}
+		switch {
+		case event.Kind.StateChange != nil:
+			sc := event.Kind.StateChange
+			n.Target, n.Access, n.Location = sc.Variable, sc.AccessType, sc.Location
+			n.Old, _ = json.Marshal(sc.OldValue)
+			n.New, _ = json.Marshal(sc.NewValue)
+		case event.Kind.LockAcquire != nil:
+			n.Target = event.Kind.LockAcquire.LockID
+		case event.Kind.LockRelease != nil:
+			n.Target = event.Kind.LockRelease.LockID
+		case event.Kind.AsyncSpawn != nil:
+			n.Target, n.Location = event.Kind.AsyncSpawn.TaskName, event.Kind.AsyncSpawn.SpawnedAt
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+type golden struct {
+	Expected []Finding         `json:"expected"`
+	Events   []normalizedEvent `json:"events"`
+}
+
+func TestGeneratorsMatchGoldenFixtures(t *testing.T) {
+	for _, scenario := range All() {
+		t.Run(scenario.Name, func(t *testing.T) {
+			client, sink := newRecordingClient(t)
+			script := scenario.Generate(Params{})
+			traceID := Emit(context.Background(), client, script, "golden")
+			if err := client.FlushContext(context.Background()); err != nil {
+				t.Fatalf("flush failed: %v", err)
+			}
+			for _, event := range sink.events {
+				if event.TraceID != traceID {
+					t.Fatalf("event on trace %s, want %s", event.TraceID, traceID)
+				}
+			}
+
+			got, err := json.MarshalIndent(golden{Expected: script.Expected, Events: normalize(sink.events)}, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", scenario.Name+".golden.json")
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading golden fixture (run with -update to create): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("emitted events differ from %s (run with -update to accept):\n%s", path, got)
+			}
+		})
+	}
+}
+
+func TestGenerateIsDeterministicAndParameterized(t *testing.T) {
+	a, _ := json.Marshal(LostUpdate.Generate(Params{Workers: 3}))
+	b, _ := json.Marshal(LostUpdate.Generate(Params{Workers: 3}))
+	if !bytes.Equal(a, b) {
+		t.Fatalf("generator is not deterministic")
+	}
+
+	script := LostUpdate.Generate(Params{Namespace: "bank", Workers: 3})
+	if script.Threads != 4 {
+		t.Errorf("expected root plus 3 workers, got %d threads", script.Threads)
+	}
+	// 3 write-write pairs, and each read races with the 2 other workers' writes
+	if len(script.Expected) != 9 {
+		t.Errorf("expected 9 findings for 3 workers, got %d", len(script.Expected))
+	}
+	for _, finding := range script.Expected {
+		if !strings.HasPrefix(finding.Variable, "bank.") {
+			t.Errorf("namespace not applied: %s", finding.Variable)
+		}
+	}
+
+	if TraceID("lost_update", "run-1") != TraceID("lost_update", "run-1") || TraceID("lost_update", "run-1") == TraceID("lost_update", "run-2") {
+		t.Errorf("trace IDs must be stable per run and distinct across runs")
+	}
+}
+
+// fakeServer ingests events and reports races the way the server's trace
+// analysis does for these scenarios: conflicting StateChange pairs on
+// different threads, all of which are on concurrent branches here.
+type fakeServer struct {
+	mu     sync.Mutex
+	events map[string][]raceway.Event
+	silent bool
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/events" {
+		var batch struct {
+			Events []raceway.Event `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&batch)
+		for _, event := range batch.Events {
+			s.events[event.TraceID] = append(s.events[event.TraceID], event)
+		}
+		return
+	}
+
+	traceID := strings.TrimPrefix(r.URL.Path, "/api/traces/")
+	events, ok := s.events[traceID]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	type race struct {
+		Severity       string `json:"severity"`
+		Variable       string `json:"variable"`
+		Event1Location string `json:"event1_location"`
+		Event2Location string `json:"event2_location"`
+	}
+	races := []race{}
+	for i := range events {
+		for j := i + 1; j < len(events) && !s.silent; j++ {
+			a, b := events[i].Kind.StateChange, events[j].Kind.StateChange
+			if a == nil || b == nil || a.Variable != b.Variable || events[i].Metadata.ThreadID == events[j].Metadata.ThreadID {
+				continue
+			}
+			if a.AccessType == "Read" && b.AccessType == "Read" {
+				continue
+			}
+			severity := SeverityWarning
+			if a.AccessType == "Write" && b.AccessType == "Write" {
+				severity = SeverityCritical
+			}
+			races = append(races, race{severity, a.Variable, a.Location, b.Location})
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    map[string]interface{}{"analysis": map[string]interface{}{"race_details": races}},
+	})
+}
+
+func TestRunnerReportsFindings(t *testing.T) {
+	server := httptest.NewServer(&fakeServer{events: map[string][]raceway.Event{}})
+	defer server.Close()
+
+	runner := &Runner{ServerURL: server.URL, Params: Params{RunID: "ci"}, Timeout: time.Second, PollInterval: 10 * time.Millisecond}
+	report, err := runner.Run(context.Background(), All()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range report.Cases {
+		want := StatusPassed
+		if c.Scenario == LockOrderInversion.Name {
+			want = StatusSkipped
+		}
+		if c.Status != want {
+			t.Errorf("%s: status %s, want %s: %s", c.Scenario, c.Status, want, c.Message)
+		}
+	}
+	if !report.Passed() {
+		t.Errorf("expected report to pass")
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteJUnit(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `tests="5" failures="0" errors="0" skipped="1"`) {
+		t.Errorf("unexpected junit report:\n%s", out.String())
+	}
+}
+
+func TestRunnerReportsMissingFindings(t *testing.T) {
+	server := httptest.NewServer(&fakeServer{events: map[string][]raceway.Event{}, silent: true})
+	defer server.Close()
+
+	runner := &Runner{ServerURL: server.URL, Timeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+	report, err := runner.Run(context.Background(), LostUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := report.Cases[0]
+	if c.Status != StatusFailed || len(c.Missing) != 3 || report.Passed() {
+		t.Fatalf("expected 3 missing findings, got %s %v", c.Status, c.Missing)
+	}
+
+	var out bytes.Buffer
+	report.WriteJUnit(&out)
+	if !strings.Contains(out.String(), `<failure message="3 of 3 expected findings not reported">`) {
+		t.Errorf("unexpected junit report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,332 @@
+// Package racewaysql records database/sql queries as Raceway events, so
+// read-modify-write cycles against a database are analyzed like those on
+// in-memory state.
+//
+// Instrumentation is opt-in: wrap a *sql.DB and use the wrapper's Context
+// methods with the request context.
+//
+//	db := racewaysql.Wrap(sqlDB, client)
+//	row := db.QueryRowContext(ctx, "SELECT balance FROM accounts WHERE id = $1", id)
+//
+// Each statement records a FunctionCall named "sql.<VERB>" with the
+// normalized statement, a StateChange per table on variable "table:<name>"
+// (Read for tables a statement reads, Write for those INSERT, UPDATE, or
+// DELETE write), and a FunctionReturn with the row count and duration in
+// milliseconds. A transaction holds a "tx:<table>" lock, recorded as
+// LockAcquire before its first statement on each table and LockRelease on
+// Commit or Rollback, so the analyzer sees which accesses were atomic.
+//
+// Statements run without a Raceway context, and those made through methods
+// without a context argument, are not recorded.
+package racewaysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// module is the Module of recorded FunctionCall events.
+const module = "database/sql"
+
+// txLockType is the LockType of transaction lock events.
+const txLockType = "Transaction"
+
+// DB wraps a *sql.DB, recording the statements run through its Context
+// methods. The embedded *sql.DB remains available for everything else.
+type DB struct {
+	*sql.DB
+	client *raceway.Client
+}
+
+// Wrap returns db instrumented with client.
+func Wrap(db *sql.DB, client *raceway.Client) *DB {
+	return &DB{DB: db, client: client}
+}
+
+// QueryContext runs a query and records it. The returned Rows records the
+// query's return, with the number of rows read, when it is closed or
+// exhausted.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	call := db.start(ctx, query, caller())
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	return call.rows(rows, err)
+}
+
+// QueryRowContext runs a query expected to return at most one row and
+// records it like QueryContext.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	call := db.start(ctx, query, caller())
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	return call.row(rows, err)
+}
+
+// ExecContext runs a statement and records it with the number of rows
+// affected.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	call := db.start(ctx, query, caller())
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	return call.result(result, err)
+}
+
+// BeginTx starts a transaction whose statements are recorded. ctx is used
+// for the lock release recorded by Commit or Rollback.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db, ctx: ctx, locked: make(map[string]bool)}, nil
+}
+
+func (db *DB) start(ctx context.Context, query string, site callSite) *call {
+	c := &call{client: db.client, ctx: ctx, stmt: parseStatement(query), site: site}
+	c.begin()
+	return c
+}
+
+// Tx wraps a *sql.Tx, recording its statements and the table locks it holds.
+type Tx struct {
+	*sql.Tx
+	db  *DB
+	ctx context.Context
+
+	mu     sync.Mutex
+	order  []string
+	locked map[string]bool
+	done   bool
+}
+
+// QueryContext runs a query in the transaction and records it.
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	call := tx.start(ctx, query, caller())
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	return call.rows(rows, err)
+}
+
+// QueryRowContext runs a single-row query in the transaction and records it.
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	call := tx.start(ctx, query, caller())
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	return call.row(rows, err)
+}
+
+// ExecContext runs a statement in the transaction and records it.
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	call := tx.start(ctx, query, caller())
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	return call.result(result, err)
+}
+
+// Commit commits the transaction and records the release of its locks.
+func (tx *Tx) Commit() error {
+	err := tx.Tx.Commit()
+	tx.release()
+	return err
+}
+
+// Rollback aborts the transaction and records the release of its locks.
+// Calling it after Commit, as a deferred cleanup, records nothing.
+func (tx *Tx) Rollback() error {
+	err := tx.Tx.Rollback()
+	tx.release()
+	return err
+}
+
+// start records the lock acquisition for tables the transaction has not
+// touched yet, then starts recording the statement.
+func (tx *Tx) start(ctx context.Context, query string, site callSite) *call {
+	c := &call{client: tx.db.client, ctx: ctx, stmt: parseStatement(query), site: site}
+
+	tx.mu.Lock()
+	if !tx.done {
+		for _, ta := range c.stmt.tables {
+			if !tx.locked[ta.table] {
+				tx.locked[ta.table] = true
+				tx.order = append(tx.order, ta.table)
+				tx.db.client.TrackLockAcquire(ctx, "tx:"+ta.table, txLockType)
+			}
+		}
+	}
+	tx.mu.Unlock()
+
+	c.begin()
+	return c
+}
+
+// release records the release of every table lock, in reverse order of
+// acquisition, the first time the transaction ends.
+func (tx *Tx) release() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return
+	}
+	tx.done = true
+	for i := len(tx.order) - 1; i >= 0; i-- {
+		tx.db.client.TrackLockRelease(tx.ctx, "tx:"+tx.order[i], txLockType)
+	}
+}
+
+// call records one statement: its FunctionCall and table accesses when it
+// starts and its FunctionReturn when it finishes.
+type call struct {
+	client *raceway.Client
+	ctx    context.Context
+	stmt   statement
+	site   callSite
+	start  time.Time
+	once   sync.Once
+}
+
+func (c *call) functionName() string {
+	if c.stmt.verb == "" {
+		return "sql"
+	}
+	return "sql." + c.stmt.verb
+}
+
+func (c *call) begin() {
+	tables := make([]string, 0, len(c.stmt.tables))
+	for _, ta := range c.stmt.tables {
+		tables = append(tables, ta.table)
+	}
+	c.client.TrackFunctionCall(c.ctx, c.functionName(), module, map[string]interface{}{
+		"verb":      c.stmt.verb,
+		"statement": c.stmt.text,
+		"tables":    tables,
+	}, c.site.file, c.site.line)
+	c.start = time.Now()
+}
+
+// finish records the statement's table accesses, unless it failed, and its
+// return. rows is -1 when the count is unknown.
+func (c *call) finish(rows int64, err error) {
+	c.once.Do(func() {
+		elapsed := time.Since(c.start)
+		if err == nil {
+			for _, ta := range c.stmt.tables {
+				c.client.TrackStateChange(c.ctx, "table:"+ta.table, nil, c.stmt.text, c.site.String(), ta.access)
+			}
+		}
+
+		result := map[string]interface{}{
+			"duration_ms": float64(elapsed.Microseconds()) / 1000,
+		}
+		if rows >= 0 {
+			result["rows"] = rows
+		}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		c.client.TrackFunctionReturn(c.ctx, c.functionName(), result, c.site.file, c.site.line)
+	})
+}
+
+func (c *call) rows(rows *sql.Rows, err error) (*Rows, error) {
+	if err != nil {
+		c.finish(-1, err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, call: c}, nil
+}
+
+func (c *call) row(rows *sql.Rows, err error) *Row {
+	r, err := c.rows(rows, err)
+	return &Row{rows: r, err: err}
+}
+
+func (c *call) result(result sql.Result, err error) (sql.Result, error) {
+	if err != nil {
+		c.finish(-1, err)
+		return result, err
+	}
+	affected, affectedErr := result.RowsAffected()
+	if affectedErr != nil {
+		affected = -1
+	}
+	c.finish(affected, nil)
+	return result, nil
+}
+
+// Rows wraps *sql.Rows, counting the rows read so the query's return can be
+// recorded with them once the rows are closed or exhausted.
+type Rows struct {
+	*sql.Rows
+	call  *call
+	count int64
+}
+
+// Next advances to the next row, recording the query's return when there
+// are no more rows.
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		r.count++
+		return true
+	}
+	r.call.finish(r.count, r.Rows.Err())
+	return false
+}
+
+// Close closes the rows and records the query's return.
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.call.finish(r.count, r.Rows.Err())
+	return err
+}
+
+// Row is the result of QueryRowContext. Like *sql.Row, errors are deferred
+// until Scan.
+type Row struct {
+	rows *Rows
+	err  error
+}
+
+// Scan copies the columns of the first row into dest, returning
+// sql.ErrNoRows if there is none.
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	return r.rows.Close()
+}
+
+// Err returns the error, if any, encountered running the query.
+func (r *Row) Err() error {
+	return r.err
+}
+
+// callSite is the code that called a DB or Tx method.
+type callSite struct {
+	file string
+	line int
+}
+
+func (s callSite) String() string {
+	return fmt.Sprintf("%s:%d", s.file, s.line)
+}
+
+// caller returns the call site of the DB or Tx method calling it. The file is
+// made relative by the client when the call site is recorded.
+func caller() callSite {
+	_, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return callSite{file: "unknown"}
+	}
+	return callSite{file: file, line: line}
+}
@@ -0,0 +1,249 @@
+//go:build !raceway_disabled
+
+package racewaysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// fakeDriver answers every query with rowsPerQuery single-column rows and
+// every statement with rowsAffected, and fails statements containing "fail".
+type fakeDriver struct{}
+
+const (
+	rowsPerQuery = 2
+	rowsAffected = 3
+)
+
+func init() { sql.Register("racewaysql-fake", fakeDriver{}) }
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if failing(query) {
+		return nil, errors.New("query failed")
+	}
+	return &fakeRows{}, nil
+}
+
+func (fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if failing(query) {
+		return nil, errors.New("exec failed")
+	}
+	return driver.RowsAffected(rowsAffected), nil
+}
+
+func failing(query string) bool { return strings.Contains(query, "fail") }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{ n int }
+
+func (r *fakeRows) Columns() []string { return []string{"balance"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n == rowsPerQuery {
+		return io.EOF
+	}
+	r.n++
+	dest[0] = int64(100 * r.n)
+	return nil
+}
+
+func newTestDB(t *testing.T) (*DB, *raceway.Recorder) {
+	t.Helper()
+	config := raceway.Config{ServiceName: "racewaysql", InstanceID: "test", Region: "test"}
+	rec := raceway.NewRecorderWithConfig(config)
+	t.Cleanup(func() { rec.Shutdown() })
+
+	db, err := sql.Open("racewaysql-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return Wrap(db, rec.Client), rec
+}
+
+// decoded returns a recorded argument or return value as decoded JSON.
+func decoded(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func kinds(events []raceway.Event) []string {
+	names := make([]string, len(events))
+	for i, e := range events {
+		names[i] = e.Kind.Name()
+	}
+	return names
+}
+
+func TestQueryAndExecRecordCallsAndTableAccess(t *testing.T) {
+	db, rec := newTestDB(t)
+	ctx := raceway.NewContext(context.Background(), "", "racewaysql", "test")
+
+	var balance int
+	if err := db.QueryRowContext(ctx, "SELECT balance FROM accounts WHERE id = 'alice'").Scan(&balance); err != nil || balance != 100 {
+		t.Fatalf("expected the first row, got %d, %v", balance, err)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE accounts SET balance = $1 WHERE id = $2", 50, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := rec.Events()
+	want := []string{"FunctionCall", "StateChange", "FunctionReturn", "FunctionCall", "StateChange", "FunctionReturn"}
+	if got := kinds(events); len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i, name := range want {
+		if events[i].Kind.Name() != name {
+			t.Fatalf("expected %v, got %v", want, kinds(events))
+		}
+	}
+
+	selectCall := events[0].Kind.FunctionCall
+	args := decoded(t, selectCall.Args)
+	if selectCall.FunctionName != "sql.SELECT" || args["statement"] != "SELECT balance FROM accounts WHERE id = ?" {
+		t.Errorf("unexpected select call %+v", selectCall)
+	}
+	if selectCall.File != "racewaysql/racewaysql_test.go" {
+		t.Errorf("expected the caller's file, got %q", selectCall.File)
+	}
+	read, write := events[1].Kind.StateChange, events[4].Kind.StateChange
+	if read.Variable != "table:accounts" || read.AccessType != "Read" {
+		t.Errorf("unexpected read %+v", read)
+	}
+	if write.Variable != "table:accounts" || write.AccessType != "Write" {
+		t.Errorf("unexpected write %+v", write)
+	}
+	if rows := decoded(t, events[2].Kind.FunctionReturn.ReturnValue)["rows"]; rows != float64(1) {
+		t.Errorf("expected QueryRow to report 1 row read, got %v", rows)
+	}
+	if rows := decoded(t, events[5].Kind.FunctionReturn.ReturnValue)["rows"]; rows != float64(rowsAffected) {
+		t.Errorf("expected rows affected to be reported, got %v", rows)
+	}
+}
+
+func TestQueryReportsRowsWhenExhausted(t *testing.T) {
+	db, rec := newTestDB(t)
+	ctx := raceway.NewContext(context.Background(), "", "racewaysql", "test")
+
+	rows, err := db.QueryContext(ctx, "SELECT balance FROM accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+
+	events := rec.Events()
+	last := events[len(events)-1].Kind.FunctionReturn
+	if last == nil || decoded(t, last.ReturnValue)["rows"] != float64(rowsPerQuery) {
+		t.Fatalf("expected one return reporting %d rows, got %v", rowsPerQuery, kinds(events))
+	}
+}
+
+func TestFailedStatementRecordsErrorWithoutAccess(t *testing.T) {
+	db, rec := newTestDB(t)
+	ctx := raceway.NewContext(context.Background(), "", "racewaysql", "test")
+
+	if _, err := db.ExecContext(ctx, "UPDATE fail SET x = 1"); err == nil {
+		t.Fatal("expected the statement to fail")
+	}
+
+	events := rec.Events()
+	if len(events) != 2 || events[1].Kind.FunctionReturn == nil {
+		t.Fatalf("expected only a call and return, got %v", kinds(events))
+	}
+	if msg := decoded(t, events[1].Kind.FunctionReturn.ReturnValue)["error"]; msg != "exec failed" {
+		t.Errorf("expected the error to be recorded, got %v", msg)
+	}
+}
+
+func TestTransactionHoldsTableLocks(t *testing.T) {
+	db, rec := newTestDB(t)
+	ctx := raceway.NewContext(context.Background(), "", "racewaysql", "test")
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var balance int
+	if err := tx.QueryRowContext(ctx, "SELECT balance FROM accounts WHERE id = $1", "alice").Scan(&balance); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = $1", balance-50); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO ledger (amount) VALUES ($1)", -50); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	var locks []string
+	var lockSets [][]string
+	for _, e := range rec.Events() {
+		switch {
+		case e.Kind.LockAcquire != nil:
+			locks = append(locks, "+"+e.Kind.LockAcquire.LockID)
+		case e.Kind.LockRelease != nil:
+			locks = append(locks, "-"+e.Kind.LockRelease.LockID)
+		case e.Kind.StateChange != nil:
+			lockSets = append(lockSets, e.LockSet)
+		}
+	}
+	want := []string{"+tx:accounts", "+tx:ledger", "-tx:ledger", "-tx:accounts"}
+	if len(locks) != len(want) {
+		t.Fatalf("expected %v, got %v", want, locks)
+	}
+	for i := range want {
+		if locks[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, locks)
+		}
+	}
+	for _, set := range lockSets {
+		if len(set) == 0 || set[0] != "tx:accounts" {
+			t.Errorf("expected every access inside the transaction to hold tx:accounts, got %v", set)
+		}
+	}
+}
+
+func TestStatementsOutsideRacewayContextAreNotRecorded(t *testing.T) {
+	db, rec := newTestDB(t)
+	if _, err := db.ExecContext(context.Background(), "DELETE FROM sessions"); err != nil {
+		t.Fatal(err)
+	}
+	if events := rec.Events(); len(events) != 0 {
+		t.Fatalf("expected no events, got %v", kinds(events))
+	}
+}
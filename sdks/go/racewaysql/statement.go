@@ -0,0 +1,214 @@
+package racewaysql
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tableAccess is a table a statement touches and how.
+type tableAccess struct {
+	table  string
+	access string // "Read" or "Write"
+}
+
+// statement is a parsed SQL statement.
+type statement struct {
+	verb   string
+	text   string
+	tables []tableAccess
+}
+
+// clauseKeywords end a table list in a FROM, JOIN, INTO, or UPDATE clause.
+var clauseKeywords = map[string]bool{
+	"WHERE": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true,
+	"FULL": true, "CROSS": true, "NATURAL": true, "ON": true, "USING": true,
+	"GROUP": true, "ORDER": true, "HAVING": true, "LIMIT": true, "OFFSET": true,
+	"SET": true, "VALUES": true, "VALUE": true, "DEFAULT": true, "RETURNING": true,
+	"UNION": true, "INTERSECT": true, "EXCEPT": true, "FOR": true, "WINDOW": true,
+	"SELECT": true, "FROM": true, "ONLY": true,
+}
+
+// writeVerbs are the statements whose target table is written.
+var writeVerbs = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true}
+
+// parseStatement normalizes query and finds the tables it reads and writes.
+// It understands the common shapes of SELECT, INSERT, UPDATE, and DELETE
+// rather than full SQL; tables it cannot find are simply not reported.
+func parseStatement(query string) statement {
+	tokens, spaced := tokenize(query)
+	stmt := statement{text: normalize(tokens, spaced)}
+	if len(tokens) == 0 {
+		return stmt
+	}
+
+	stmt.verb = strings.ToUpper(tokens[0])
+	if stmt.verb == "WITH" {
+		// The statement after the common table expressions decides the verb
+		stmt.verb = "SELECT"
+		for _, t := range tokens {
+			if writeVerbs[strings.ToUpper(t)] {
+				stmt.verb = strings.ToUpper(t)
+				break
+			}
+		}
+	}
+
+	seen := make(map[tableAccess]bool)
+	add := func(table, access string) {
+		ta := tableAccess{table: table, access: access}
+		if !seen[ta] {
+			seen[ta] = true
+			stmt.tables = append(stmt.tables, ta)
+		}
+	}
+	for i := 0; i < len(tokens); i++ {
+		keyword := strings.ToUpper(tokens[i])
+		prev := ""
+		if i > 0 {
+			prev = strings.ToUpper(tokens[i-1])
+		}
+		var access string
+		switch {
+		case keyword == "INTO" && prev == "INSERT",
+			keyword == "UPDATE" && (i == 0 || prev == ")"),
+			keyword == "FROM" && prev == "DELETE":
+			access = "Write"
+		case keyword == "FROM", keyword == "JOIN":
+			access = "Read"
+		default:
+			continue
+		}
+		for _, table := range tableList(tokens[i+1:]) {
+			add(table, access)
+		}
+	}
+	return stmt
+}
+
+// tableList returns the comma-separated tables at the start of tokens,
+// skipping aliases, up to the next clause.
+func tableList(tokens []string) []string {
+	var tables []string
+	expectTable := true
+	for _, t := range tokens {
+		upper := strings.ToUpper(t)
+		switch {
+		case t == ",":
+			expectTable = true
+		case t == "(" || t == ")" || t == ";" || clauseKeywords[upper]:
+			return tables
+		case expectTable:
+			if upper == "LATERAL" {
+				continue
+			}
+			tables = append(tables, tableName(t))
+			expectTable = false
+		}
+	}
+	return tables
+}
+
+// tableName strips identifier quoting. Unquoted names are case-insensitive
+// in SQL, so they are lowercased.
+func tableName(token string) string {
+	if strings.ContainsAny(token, "\"`[") {
+		return strings.NewReplacer(`"`, "", "`", "", "[", "", "]", "").Replace(token)
+	}
+	return strings.ToLower(token)
+}
+
+// tokenize splits query into identifiers, keywords, literals, and single
+// punctuation characters, and reports which tokens followed whitespace.
+// Comments are dropped; string and numeric literals become "?" so statements
+// that differ only in literal values normalize to the same text.
+func tokenize(query string) (tokens []string, spaced []bool) {
+	space := false
+	emit := func(token string) {
+		tokens = append(tokens, token)
+		spaced = append(spaced, space)
+		space = false
+	}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			space = true
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			space = true
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens, spaced
+			}
+			i += end + 4
+		case c == '\'':
+			i = skipQuoted(query, i, '\'')
+			emit("?")
+		case c == '"' || c == '`':
+			start := i
+			i = skipQuoted(query, i, c)
+			emit(query[start:i])
+		case c >= '0' && c <= '9':
+			for i < len(query) && (isWordByte(query[i]) || query[i] == '.') {
+				i++
+			}
+			emit("?")
+		case c == '$' || c == ':' || c == '@':
+			// Placeholders such as $1, :name, and @p1 are kept as written
+			start := i
+			i++
+			for i < len(query) && isWordByte(query[i]) {
+				i++
+			}
+			emit(query[start:i])
+		case isWordByte(c):
+			start := i
+			for i < len(query) && (isWordByte(query[i]) || query[i] == '.' || query[i] == '"') {
+				i++
+			}
+			emit(query[start:i])
+		default:
+			emit(string(c))
+			i++
+		}
+	}
+	return tokens, spaced
+}
+
+// skipQuoted returns the index just past the quoted section starting at
+// query[start], treating a doubled quote as an escaped one.
+func skipQuoted(query string, start int, quote byte) int {
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != quote {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(query)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// normalize joins tokens, separating those that were separated by
+// whitespace or comments in the query with a single space.
+func normalize(tokens []string, spaced []bool) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && spaced[i] {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t)
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package racewaysql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		query  string
+		verb   string
+		text   string
+		tables []tableAccess
+	}{
+		{
+			query:  "SELECT balance FROM accounts WHERE id = 'alice' AND amount > 100",
+			verb:   "SELECT",
+			text:   "SELECT balance FROM accounts WHERE id = ? AND amount > ?",
+			tables: []tableAccess{{"accounts", "Read"}},
+		},
+		{
+			query:  "select a.balance, l.amount\n  from Accounts a, ledger l join audit on audit.id = a.id -- comment\n",
+			verb:   "SELECT",
+			text:   "select a.balance, l.amount from Accounts a, ledger l join audit on audit.id = a.id",
+			tables: []tableAccess{{"accounts", "Read"}, {"ledger", "Read"}, {"audit", "Read"}},
+		},
+		{
+			query:  "UPDATE accounts SET balance = balance - $1 WHERE id = $2",
+			verb:   "UPDATE",
+			text:   "UPDATE accounts SET balance = balance - $1 WHERE id = $2",
+			tables: []tableAccess{{"accounts", "Write"}},
+		},
+		{
+			query:  `INSERT INTO "Ledger" (account, amount) SELECT id, 0 FROM accounts`,
+			verb:   "INSERT",
+			text:   `INSERT INTO "Ledger" (account, amount) SELECT id, ? FROM accounts`,
+			tables: []tableAccess{{"Ledger", "Write"}, {"accounts", "Read"}},
+		},
+		{
+			query:  "DELETE FROM sessions WHERE expires_at < now()",
+			verb:   "DELETE",
+			text:   "DELETE FROM sessions WHERE expires_at < now()",
+			tables: []tableAccess{{"sessions", "Write"}},
+		},
+		{
+			query:  "SELECT balance FROM accounts WHERE id = ? FOR UPDATE",
+			verb:   "SELECT",
+			text:   "SELECT balance FROM accounts WHERE id = ? FOR UPDATE",
+			tables: []tableAccess{{"accounts", "Read"}},
+		},
+		{
+			query:  "WITH debited AS (SELECT id FROM accounts) UPDATE ledger SET amount = 1",
+			verb:   "UPDATE",
+			text:   "WITH debited AS (SELECT id FROM accounts) UPDATE ledger SET amount = ?",
+			tables: []tableAccess{{"accounts", "Read"}, {"ledger", "Write"}},
+		},
+		{
+			query: "BEGIN",
+			verb:  "BEGIN",
+			text:  "BEGIN",
+		},
+	}
+	for _, tt := range tests {
+		stmt := parseStatement(tt.query)
+		if stmt.verb != tt.verb || stmt.text != tt.text || !reflect.DeepEqual(stmt.tables, tt.tables) {
+			t.Errorf("parseStatement(%q) = %+v, want verb %q text %q tables %v", tt.query, stmt, tt.verb, tt.text, tt.tables)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package racewaytest provides helpers for using the Raceway SDK in tests.
+package racewaytest
+
+import (
+	"testing"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// StrictHandler returns a strict-mode handler that fails t for each violation
+// instead of panicking.
+//
+//	client := raceway.New(raceway.Config{
+//	    Strict:        true,
+//	    StrictHandler: racewaytest.StrictHandler(t),
+//	})
+func StrictHandler(t testing.TB) raceway.StrictHandler {
+	return func(v raceway.StrictViolation) {
+		t.Helper()
+		t.Errorf("%s", v)
+	}
+}
+
+// AssertStateChange fails t unless rec recorded a StateChange of variable
+// with accessType, such as "Read" or "Write", and returns the first one.
+//
+//	rec := raceway.NewRecorder()
+//	transfer(ctx, rec.Client, "alice", "bob", 100)
+//	racewaytest.AssertStateChange(t, rec, "accounts[alice].balance", "Read")
+func AssertStateChange(t testing.TB, rec *raceway.Recorder, variable, accessType string) raceway.Event {
+	t.Helper()
+	var recorded []string
+	for _, event := range rec.EventsOfKind("StateChange") {
+		change := event.Kind.StateChange
+		if change.Variable == variable && change.AccessType == accessType {
+			return event
+		}
+		recorded = append(recorded, change.AccessType+" "+change.Variable)
+	}
+	t.Errorf("expected a %s of %s, recorded state changes: %v", accessType, variable, recorded)
+	return raceway.Event{}
+}
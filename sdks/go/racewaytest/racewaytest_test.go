@@ -0,0 +1,38 @@
+//go:build !raceway_disabled
+
+package racewaytest
+
+import (
+	"context"
+	"testing"
+
+	raceway "github.com/mode7labs/raceway/sdks/go"
+)
+
+// failureRecorder is a testing.TB that records failures instead of failing.
+type failureRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (f *failureRecorder) Helper() {}
+
+func (f *failureRecorder) Errorf(format string, args ...interface{}) { f.failed = true }
+
+func TestAssertStateChange(t *testing.T) {
+	rec := raceway.NewRecorder()
+	defer rec.Shutdown()
+	ctx := raceway.NewContext(context.Background(), "", "test", "test")
+	rec.TrackStateChange(ctx, "accounts[alice].balance", nil, 100, "", "Read")
+
+	event := AssertStateChange(t, rec, "accounts[alice].balance", "Read")
+	if event.Kind.StateChange == nil {
+		t.Fatal("expected the matching event to be returned")
+	}
+
+	missing := &failureRecorder{TB: t}
+	AssertStateChange(missing, rec, "accounts[alice].balance", "Write")
+	if !missing.failed {
+		t.Error("expected a missing Write to fail the test")
+	}
+}
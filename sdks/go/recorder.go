@@ -0,0 +1,91 @@
+package raceway
+
+import (
+	"context"
+	"sync"
+)
+
+// Recorder is a Client that keeps events in memory, for unit testing
+// instrumented code. Its Track methods behave as a Client's do, capturing
+// locations, advancing clocks, and chaining ParentID, but it starts no
+// goroutines and never contacts a server: events are delivered to it when
+// they are read. Up to Config.MaxBufferedEvents events are held between reads.
+//
+//	rec := raceway.NewRecorder()
+//	transfer(raceway.NewContext(context.Background(), "", "test", "test"), rec.Client)
+//	if len(rec.EventsOfKind("StateChange")) != 2 { ... }
+type Recorder struct {
+	*Client
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewRecorder returns a Recorder for the service "test". Every capability is
+// enabled, so events are recorded as captured, not downgraded for a collector.
+func NewRecorder() *Recorder {
+	return NewRecorderWithConfig(Config{ServiceName: "test", InstanceID: "test"})
+}
+
+// NewRecorderWithConfig returns a Recorder whose client is configured by
+// config, for testing code that depends on settings such as IgnorePaths or
+// RecoverPanics. The Recorder replaces config's Sink, Routes, and
+// ForceCapabilities.
+func NewRecorderWithConfig(config Config) *Recorder {
+	r := &Recorder{}
+	config.Sink = recorderSink{r}
+	config.Routes = nil
+	config.ForceCapabilities = AllCapabilities
+	r.Client = newClient(config)
+	r.Client.runWithoutWriter()
+	return r
+}
+
+// recorderSink delivers flushed events to a Recorder.
+type recorderSink struct{ r *Recorder }
+
+func (s recorderSink) Send(ctx context.Context, events []Event) error {
+	s.r.mu.Lock()
+	s.r.events = append(s.r.events, events...)
+	s.r.mu.Unlock()
+	return nil
+}
+
+func (s recorderSink) Close() error { return nil }
+
+// Events returns the events recorded so far, in capture order.
+func (r *Recorder) Events() []Event {
+	r.Client.Flush()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// EventsOfKind returns the recorded events whose kind is named kind, such as
+// "StateChange" or "FunctionCall".
+func (r *Recorder) EventsOfKind(kind string) []Event {
+	var events []Event
+	for _, event := range r.Events() {
+		if event.Kind.Name() == kind {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Find returns the first recorded event match reports true for.
+func (r *Recorder) Find(match func(Event) bool) (Event, bool) {
+	for _, event := range r.Events() {
+		if match(event) {
+			return event, true
+		}
+	}
+	return Event{}, false
+}
+
+// Reset discards the events recorded so far.
+func (r *Recorder) Reset() {
+	r.Client.Flush()
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
@@ -0,0 +1,90 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestRecorderRecordsEventsLikeAClient(t *testing.T) {
+	before := runtime.NumGoroutine()
+	rec := NewRecorder()
+	defer rec.Shutdown()
+	if runtime.NumGoroutine() > before {
+		t.Errorf("expected NewRecorder to start no goroutines, went from %d to %d", before, runtime.NumGoroutine())
+	}
+
+	ctx := NewContext(context.Background(), "", "test", "test")
+	func() {
+		defer rec.StartFunction(ctx, "transfer", nil)()
+		rec.TrackStateChange(ctx, "balance", nil, 100, "", "Read")
+		rec.TrackStateChange(ctx, "balance", 100, 70, "", "Write")
+	}()
+
+	events := rec.Events()
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	call := events[0]
+	for i, event := range events {
+		if got := event.CausalityVector[0].Value(); got != uint64(i+1) {
+			t.Errorf("event %d: expected clock %d, got %d", i, i+1, got)
+		}
+		if i > 0 && (event.ParentID == nil || *event.ParentID != call.ID) {
+			t.Errorf("event %d: expected the call as parent", i)
+		}
+	}
+	if read := events[1].Kind.StateChange; read.Location == "" || read.AccessType != "Read" {
+		t.Errorf("expected a located Read, got %+v", read)
+	}
+
+	if changes := rec.EventsOfKind("StateChange"); len(changes) != 2 {
+		t.Errorf("expected 2 state changes, got %d", len(changes))
+	}
+	write, ok := rec.Find(func(e Event) bool {
+		return e.Kind.StateChange != nil && e.Kind.StateChange.AccessType == "Write"
+	})
+	if !ok || write.ID != events[2].ID {
+		t.Errorf("expected Find to return the write, got %+v", write)
+	}
+
+	rec.Reset()
+	if events := rec.Events(); len(events) != 0 {
+		t.Errorf("expected no events after Reset, got %d", len(events))
+	}
+	rec.TrackStateChange(ctx, "balance", 70, 40, "", "Write")
+	if events := rec.Events(); len(events) != 1 {
+		t.Errorf("expected recording to continue after Reset, got %d events", len(events))
+	}
+}
+
+func TestRecorderWithConfigKeepsSettingsButRecords(t *testing.T) {
+	before := runtime.NumGoroutine()
+	rec := NewRecorderWithConfig(Config{
+		ServiceName: "payments",
+		IgnorePaths: []string{"/healthz"},
+		Routes:      []Route{{Name: "collector", Sink: discardSink{}}},
+	})
+	defer rec.Shutdown()
+	if runtime.NumGoroutine() > before {
+		t.Errorf("expected NewRecorderWithConfig to start no goroutines, went from %d to %d", before, runtime.NumGoroutine())
+	}
+
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if events := rec.Events(); len(events) != 0 {
+		t.Errorf("expected the ignored path untraced, got %d events", len(events))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/accounts", nil))
+	events := rec.Events()
+	if len(events) != 2 {
+		t.Fatalf("expected the request recorded instead of routed, got %d events", len(events))
+	}
+	if events[0].Metadata.ServiceName != "payments" {
+		t.Errorf("expected the configured service, got %q", events[0].Metadata.ServiceName)
+	}
+}
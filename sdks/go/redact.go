@@ -0,0 +1,148 @@
+package raceway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// redactedValue replaces values whose key matches Config.RedactKeys.
+const redactedValue = "[REDACTED]"
+
+// redactor applies Config.RedactKeys and Config.Redactor to captured values.
+type redactor struct {
+	keys   map[string]bool
+	custom func(key string, value interface{}) (interface{}, bool)
+}
+
+// newRedactor returns nil when config asks for no redaction, so capture does
+// no extra work by default.
+func newRedactor(config Config) *redactor {
+	if len(config.RedactKeys) == 0 && config.Redactor == nil {
+		return nil
+	}
+	r := &redactor{keys: make(map[string]bool, len(config.RedactKeys)), custom: config.Redactor}
+	for _, k := range config.RedactKeys {
+		r.keys[strings.ToLower(k)] = true
+	}
+	return r
+}
+
+// replace returns the replacement for value under key, if it is redacted.
+// A variable such as "user.password" matches on its last segment as well.
+func (r *redactor) replace(key string, value interface{}) (interface{}, bool) {
+	lower := strings.ToLower(key)
+	if r.keys[lower] {
+		return redactedValue, true
+	}
+	if i := strings.LastIndexByte(lower, '.'); i >= 0 && r.keys[lower[i+1:]] {
+		return redactedValue, true
+	}
+	if r.custom != nil {
+		return r.custom(key, value)
+	}
+	return nil, false
+}
+
+// redact returns v, a snapshot taken by snapshotValue, with redacted values
+// replaced. key names v itself, such as a StateChange variable, or is ""
+// when only the keys of nested objects apply. The snapshot is decoded and
+// re-encoded only if something was replaced; the caller's value is never
+// touched.
+func (r *redactor) redact(key string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	var decoded interface{} = v
+	if raw, ok := v.(json.RawMessage); ok {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&decoded); err != nil {
+			return v
+		}
+	}
+
+	redacted, changed := r.walk(key, decoded)
+	if !changed {
+		return v
+	}
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		return redactedValue
+	}
+	return json.RawMessage(data)
+}
+
+// walk redacts v, descending into objects and arrays. Array elements are
+// checked under the key of the array.
+func (r *redactor) walk(key string, v interface{}) (interface{}, bool) {
+	if key != "" {
+		if replacement, ok := r.replace(key, v); ok {
+			return replacement, true
+		}
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		changed := false
+		for k, child := range v {
+			if redacted, ok := r.walk(k, child); ok {
+				v[k] = redacted
+				changed = true
+			}
+		}
+		return v, changed
+	case []interface{}:
+		changed := false
+		for i, child := range v {
+			if redacted, ok := r.walk(key, child); ok {
+				v[i] = redacted
+				changed = true
+			}
+		}
+		return v, changed
+	}
+	return v, false
+}
+
+// redactHeaders redacts header values in place. headers must already be the
+// event's own copy.
+func (r *redactor) redactHeaders(headers map[string]string) {
+	for k, v := range headers {
+		if replacement, ok := r.replace(k, v); ok {
+			if s, isString := replacement.(string); isString {
+				headers[k] = s
+			} else {
+				headers[k] = fmt.Sprint(replacement)
+			}
+		}
+	}
+}
+
+// redactKind applies the client's redaction to the values in kind, which
+// snapshotKind has already replaced with snapshots.
+func (c *Client) redactKind(kind EventKind) {
+	r := c.redactor
+	if r == nil {
+		return
+	}
+	switch {
+	case kind.StateChange != nil:
+		kind.StateChange.OldValue = r.redact(kind.StateChange.Variable, kind.StateChange.OldValue)
+		kind.StateChange.NewValue = r.redact(kind.StateChange.Variable, kind.StateChange.NewValue)
+	case kind.FunctionCall != nil:
+		kind.FunctionCall.Args = r.redact("", kind.FunctionCall.Args)
+	case kind.FunctionReturn != nil:
+		kind.FunctionReturn.ReturnValue = r.redact("", kind.FunctionReturn.ReturnValue)
+	case kind.HTTPRequest != nil:
+		r.redactHeaders(kind.HTTPRequest.Headers)
+		kind.HTTPRequest.Body = r.redact("", kind.HTTPRequest.Body)
+	case kind.HTTPResponse != nil:
+		r.redactHeaders(kind.HTTPResponse.Headers)
+		kind.HTTPResponse.Body = r.redact("", kind.HTTPResponse.Body)
+	case kind.Custom != nil:
+		for k, v := range kind.Custom.Payload {
+			kind.Custom.Payload[k] = r.redact(k, v)
+		}
+	}
+}
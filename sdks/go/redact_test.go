@@ -0,0 +1,126 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodedValue returns a recorded value as decoded JSON.
+func decodedValue(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestRedactKeysInNestedArgsWithoutMutatingCaller(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.RedactKeys = []string{"Password", "authorization"} })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	args := map[string]interface{}{
+		"user": "alice",
+		"credentials": map[string]interface{}{
+			"PASSWORD": "hunter2",
+			"history":  []interface{}{map[string]interface{}{"password": "old"}},
+		},
+		"headers": map[string]string{"Authorization": "Bearer secret"},
+	}
+	c.TrackFunctionCall(ctx, "login", "auth", args, "redact_test.go", 1)
+
+	want := map[string]interface{}{
+		"user": "alice",
+		"credentials": map[string]interface{}{
+			"PASSWORD": redactedValue,
+			"history":  []interface{}{map[string]interface{}{"password": redactedValue}},
+		},
+		"headers": map[string]interface{}{"Authorization": redactedValue},
+	}
+	got := decodedValue(t, bufferedEvents(c)[0].Kind.FunctionCall.Args)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	credentials := args["credentials"].(map[string]interface{})
+	if credentials["PASSWORD"] != "hunter2" || credentials["history"].([]interface{})[0].(map[string]interface{})["password"] != "old" {
+		t.Errorf("expected the caller's args to be untouched, got %v", args)
+	}
+	if args["headers"].(map[string]string)["Authorization"] != "Bearer secret" {
+		t.Errorf("expected the caller's headers to be untouched, got %v", args["headers"])
+	}
+}
+
+func TestRedactStateChangeByVariableAndStructFields(t *testing.T) {
+	type account struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	c := newBufferingClient(t, func(cfg *Config) { cfg.RedactKeys = []string{"token", "balance"} })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "accounts.alice.balance", 100, 50, "redact_test.go:1", "Write")
+	before := account{ID: "alice", Token: "t-1"}
+	c.TrackStateChange(ctx, "session", before, account{ID: "alice", Token: "t-2"}, "redact_test.go:2", "Write")
+
+	events := bufferedEvents(c)
+	balance := events[0].Kind.StateChange
+	if decodedValue(t, balance.OldValue) != redactedValue || decodedValue(t, balance.NewValue) != redactedValue {
+		t.Errorf("expected the balance to be redacted by its last segment, got %v -> %v", balance.OldValue, balance.NewValue)
+	}
+	want := map[string]interface{}{"id": "alice", "token": redactedValue}
+	if got := decodedValue(t, events[1].Kind.StateChange.OldValue); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if before.Token != "t-1" {
+		t.Errorf("expected the caller's struct to be untouched, got %+v", before)
+	}
+}
+
+func TestRedactorCustomizesValues(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.RedactKeys = []string{"cookie"}
+		cfg.Redactor = func(key string, value interface{}) (interface{}, bool) {
+			if s, ok := value.(string); ok && strings.EqualFold(key, "card") && len(s) > 4 {
+				return "****" + s[len(s)-4:], true
+			}
+			return nil, false
+		}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	headers := map[string]string{"Cookie": "session=abc", "Card": "4111111111111111", "Accept": "*/*"}
+	c.TrackHTTPRequest(ctx, "POST", "/pay", headers, map[string]interface{}{"card": "4111111111111111", "amount": 12.5})
+
+	request := bufferedEvents(c)[0].Kind.HTTPRequest
+	wantHeaders := map[string]string{"Cookie": redactedValue, "Card": "****1111", "Accept": "*/*"}
+	if !reflect.DeepEqual(request.Headers, wantHeaders) {
+		t.Errorf("expected headers %v, got %v", wantHeaders, request.Headers)
+	}
+	wantBody := map[string]interface{}{"card": "****1111", "amount": 12.5}
+	if got := decodedValue(t, request.Body); !reflect.DeepEqual(got, wantBody) {
+		t.Errorf("expected body %v, got %v", wantBody, got)
+	}
+	if headers["Cookie"] != "session=abc" {
+		t.Errorf("expected the caller's headers to be untouched, got %v", headers)
+	}
+}
+
+func TestNoRedactionKeepsSnapshot(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "password", nil, "hunter2", "redact_test.go:1", "Write")
+	if got := decodedValue(t, bufferedEvents(c)[0].Kind.StateChange.NewValue); got != "hunter2" {
+		t.Errorf("expected values to be recorded as-is without redaction config, got %v", got)
+	}
+}
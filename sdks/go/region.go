@@ -0,0 +1,54 @@
+package raceway
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// regionEnvVars are consulted in order when Config.Region is empty.
+var regionEnvVars = []string{
+	"RACEWAY_REGION",
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"GOOGLE_CLOUD_REGION",
+	"AZURE_REGION",
+	"FLY_REGION",
+	"TOPOLOGY_REGION",
+	"REGION",
+}
+
+// detectRegion returns the first region found in the environment, or "".
+func detectRegion() string {
+	for _, name := range regionEnvVars {
+		if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// componentRegion is the region used in this client's clock components.
+func (c *Client) componentRegion() string {
+	if c.config.LegacyClockComponents {
+		return ""
+	}
+	return c.region
+}
+
+// newContext creates a context for this client's service, instance, and region.
+// An empty traceID is taken from the active OpenTelemetry span with
+// Config.OTelBridge, or generated.
+func (c *Client) newContext(ctx context.Context, traceID string) context.Context {
+	spanID := ""
+	if traceID == "" {
+		traceID, spanID, _ = c.otelSpan(ctx)
+	}
+	ctxWith := newContext(ctx, traceID, c.config.ServiceName, c.instanceID, c.componentRegion())
+	rctx := FromContext(ctxWith)
+	rctx.client = c
+	if spanID != "" {
+		rctx.SpanID = spanID
+	}
+	return ctxWith
+}
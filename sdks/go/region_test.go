@@ -0,0 +1,149 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseClockComponentHandlesLegacyAndRegional(t *testing.T) {
+	cases := []struct {
+		component, service, instance, region string
+	}{
+		{"payments#pod-abc123", "payments", "pod-abc123", ""},
+		{"payments#pod-abc123@eu-west-1", "payments", "pod-abc123", "eu-west-1"},
+		{"payments#user@host@us-east-1", "payments", "user@host", "us-east-1"},
+	}
+	for _, tc := range cases {
+		service, instance, region := parseClockComponent(tc.component)
+		if service != tc.service || instance != tc.instance || region != tc.region {
+			t.Errorf("parseClockComponent(%q) = %q, %q, %q", tc.component, service, instance, region)
+		}
+		if got := clockComponent(service, instance, region); got != tc.component {
+			t.Errorf("clockComponent round trip = %q, want %q", got, tc.component)
+		}
+	}
+}
+
+func TestRegionQualifiesClockComponentsAndMetadata(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Region = "eu-west-1" })
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", 0, 1, "region_test.go:1", "Write")
+
+	event := bufferedEvents(c)[0]
+	if !hasClockComponent(event.CausalityVector, "test-service#test-instance@eu-west-1", 1) {
+		t.Errorf("expected regional component, got %v", event.CausalityVector)
+	}
+	if clockValue(event.CausalityVector, "test-service#test-instance") != 0 {
+		t.Errorf("legacy component should be replaced, got %v", event.CausalityVector)
+	}
+	if event.Metadata.Region == nil || *event.Metadata.Region != "eu-west-1" || event.Metadata.Tags["region"] != "eu-west-1" {
+		t.Errorf("expected region metadata and tag, got %+v", event.Metadata)
+	}
+}
+
+func TestRegionDetectedFromEnvironment(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	if c.region != "" {
+		t.Fatalf("expected no region with a clean environment, got %q", c.region)
+	}
+
+	t.Setenv("AWS_REGION", "ap-south-1")
+	if got := detectRegion(); got != "ap-south-1" {
+		t.Errorf("detectRegion() = %q, want ap-south-1", got)
+	}
+	t.Setenv("RACEWAY_REGION", "override")
+	if got := detectRegion(); got != "override" {
+		t.Errorf("expected RACEWAY_REGION to take precedence, got %q", got)
+	}
+}
+
+func TestCrossRegionPropagation(t *testing.T) {
+	us := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServiceName = "payments"
+		cfg.InstanceID = "pod-abc123"
+		cfg.Region = "us-east-1"
+	})
+	eu := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServiceName = "payments"
+		cfg.InstanceID = "pod-abc123"
+		cfg.Region = "eu-west-1"
+	})
+
+	ctx := us.newContext(context.Background(), "")
+	us.TrackStateChange(ctx, "balance", 0, 1, "region_test.go:2", "Write")
+	headers, err := us.PropagationHeaders(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header = h
+	parsed := eu.parseRequest(req)
+	if parsed.UpstreamRegion != "us-east-1" {
+		t.Errorf("expected upstream region in payload, got %q", parsed.UpstreamRegion)
+	}
+
+	euCtx := eu.contextFromParsed(context.Background(), parsed)
+	eu.TrackStateChange(euCtx, "balance", 1, 2, "region_test.go:3", "Write")
+	event := bufferedEvents(eu)[0]
+	if !hasClockComponent(event.CausalityVector, "payments#pod-abc123@us-east-1", 1) ||
+		!hasClockComponent(event.CausalityVector, "payments#pod-abc123@eu-west-1", 1) {
+		t.Errorf("expected distinct components for the same pod in each region, got %v", event.CausalityVector)
+	}
+	if event.Metadata.Tags["upstream_region"] != "us-east-1" {
+		t.Errorf("expected upstream_region tag, got %v", event.Metadata.Tags)
+	}
+}
+
+func TestLegacyClockComponentsFlag(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Region = "eu-west-1"
+		cfg.LegacyClockComponents = true
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "balance", 0, 1, "region_test.go:4", "Write")
+
+	event := bufferedEvents(c)[0]
+	if !hasClockComponent(event.CausalityVector, "test-service#test-instance", 1) {
+		t.Errorf("expected legacy component during rollout, got %v", event.CausalityVector)
+	}
+	if event.Metadata.Tags["region"] != "eu-west-1" {
+		t.Errorf("region should still be reported during rollout, got %v", event.Metadata.Tags)
+	}
+}
+
+func TestMergeClockVectorsKeepsRegionsDistinct(t *testing.T) {
+	merged := MergeClockVectors(
+		[]CausalityEntry{
+			NewCausalityEntry("payments#pod-abc123@us-east-1", 5),
+			NewCausalityEntry("payments#pod-abc123", 7),
+		},
+		[]CausalityEntry{
+			NewCausalityEntry("payments#pod-abc123@eu-west-1", 3),
+			NewCausalityEntry("payments#pod-abc123@us-east-1", 4),
+		},
+	)
+
+	want := map[string]uint64{
+		"payments#pod-abc123":           7,
+		"payments#pod-abc123@eu-west-1": 3,
+		"payments#pod-abc123@us-east-1": 5,
+	}
+	if len(merged) != len(want) {
+		t.Fatalf("expected %d components, got %v", len(want), merged)
+	}
+	for component, value := range want {
+		if !hasClockComponent(merged, component, value) {
+			t.Errorf("expected %s=%d in %v", component, value, merged)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultRemoteConfigInterval is used when Config.RemoteConfigInterval is zero.
+const DefaultRemoteConfigInterval = 5 * time.Minute
+
+// remoteConfig is the document served at Config.RemoteConfigURL. Absent
+// fields leave the current value unchanged.
+type remoteConfig struct {
+	SampleRate    *float64 `json:"sample_rate"`
+	Debug         *bool    `json:"debug"`
+	FlushInterval string   `json:"flush_interval"`
+}
+
+// apply copies the document's overrides into cfg, or none of them if one is
+// invalid.
+func (r remoteConfig) apply(cfg *Config) error {
+	if r.SampleRate != nil && (*r.SampleRate < 0 || *r.SampleRate > 1) {
+		return fmt.Errorf("sample_rate %v is not between 0 and 1", *r.SampleRate)
+	}
+	interval := cfg.FlushInterval
+	if r.FlushInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(r.FlushInterval); err != nil {
+			return fmt.Errorf("flush_interval: %w", err)
+		}
+	}
+	if r.SampleRate != nil {
+		cfg.SampleRate = *r.SampleRate
+	}
+	if r.Debug != nil {
+		cfg.Debug = *r.Debug
+	}
+	cfg.FlushInterval = interval
+	return nil
+}
+
+// pollRemoteConfig applies the document at Config.RemoteConfigURL through
+// UpdateConfig, at once and then every Config.RemoteConfigInterval until
+// Shutdown.
+func (c *Client) pollRemoteConfig() {
+	interval := c.config.RemoteConfigInterval
+	if interval <= 0 {
+		interval = DefaultRemoteConfigInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := c.refreshRemoteConfig(ctx); err != nil {
+			c.logger.Warnf("Ignoring remote configuration: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+func (c *Client) refreshRemoteConfig(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.RemoteConfigURL, nil)
+	if err != nil {
+		return err
+	}
+	// The API key is only for the Raceway server
+	if strings.HasPrefix(c.config.RemoteConfigURL, c.baseURL+"/") {
+		c.authorize(req)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request returned status %d", resp.StatusCode)
+	}
+
+	var doc remoteConfig
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+	var applyErr error
+	if err := c.UpdateConfig(func(cfg *Config) { applyErr = doc.apply(cfg) }); err != nil {
+		return err
+	}
+	return applyErr
+}
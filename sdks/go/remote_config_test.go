@@ -0,0 +1,120 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// configServer serves a remote configuration document and records the
+// Authorization header of each request.
+type configServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	body string
+	auth []string
+}
+
+func newConfigServer(t *testing.T, body string) *configServer {
+	s := &configServer{body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.auth = append(s.auth, r.Header.Get("Authorization"))
+		w.Write([]byte(s.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *configServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.auth...)
+}
+
+// waitForConfig waits until the client's live configuration satisfies ok.
+func waitForConfig(t *testing.T, c *Client, ok func(*Config) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok(c.live.Load()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("configuration not applied, got %+v", *c.live.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRemoteConfigApplied(t *testing.T) {
+	server := newConfigServer(t, `{"sample_rate": 0.25, "debug": true, "flush_interval": "5s", "unknown": 1}`)
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.RemoteConfigURL = server.URL + "/config"
+		cfg.RemoteConfigInterval = 10 * time.Millisecond
+	})
+
+	waitForConfig(t, c, func(cfg *Config) bool {
+		return cfg.SampleRate == 0.25 && cfg.Debug && cfg.FlushInterval == 5*time.Second
+	})
+	if !c.debug.Load() {
+		t.Error("expected debug logging on")
+	}
+
+	// Later documents are applied too, leaving absent fields as they are
+	server.mu.Lock()
+	server.body = `{"debug": false}`
+	server.mu.Unlock()
+	waitForConfig(t, c, func(cfg *Config) bool {
+		return !cfg.Debug && cfg.SampleRate == 0.25 && cfg.FlushInterval == 5*time.Second
+	})
+}
+
+func TestRemoteConfigIgnoresInvalidDocument(t *testing.T) {
+	for _, body := range []string{`not json`, `{"sample_rate": 2, "debug": true}`, `{"flush_interval": "soon", "debug": true}`, `{"flush_interval": "0s"}`} {
+		logger := &capturingLogger{}
+		server := newConfigServer(t, body)
+		c := newBufferingClient(t, func(cfg *Config) {
+			cfg.RemoteConfigURL = server.URL
+			cfg.Logger = logger
+		})
+		deadline := time.Now().Add(5 * time.Second)
+		for len(logger.logged("warn")) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		if warnings := logger.logged("warn"); len(warnings) != 1 || !strings.Contains(warnings[0], "remote configuration") {
+			t.Errorf("%s: expected a warning, got %q", body, warnings)
+		}
+		if cfg := c.live.Load(); cfg.Debug || cfg.SampleRate != 0 || cfg.FlushInterval != time.Hour {
+			t.Errorf("%s: expected nothing applied, got %+v", body, *cfg)
+		}
+	}
+}
+
+func TestRemoteConfigSendsAPIKeyOnlyToServer(t *testing.T) {
+	raceway := newConfigServer(t, `{"debug": true}`)
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = raceway.URL
+		cfg.APIKey = "secret"
+		cfg.RemoteConfigURL = raceway.URL + "/config"
+	})
+	waitForConfig(t, c, func(cfg *Config) bool { return cfg.Debug })
+	if auth := raceway.requests(); auth[0] != "Bearer secret" {
+		t.Errorf("expected the API key sent to the Raceway server, got %q", auth[0])
+	}
+
+	other := newConfigServer(t, `{"debug": true}`)
+	c = newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = raceway.URL
+		cfg.APIKey = "secret"
+		cfg.RemoteConfigURL = other.URL + "/config"
+	})
+	waitForConfig(t, c, func(cfg *Config) bool { return cfg.Debug })
+	if auth := other.requests(); auth[0] != "" {
+		t.Errorf("expected no API key sent elsewhere, got %q", auth[0])
+	}
+}
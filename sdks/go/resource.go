@@ -0,0 +1,89 @@
+package raceway
+
+import (
+	"context"
+	"strings"
+)
+
+// resourceEscaper percent-encodes the characters with a meaning in resource
+// names: "/" separates segments, "#" introduces the field of a resource
+// without an ID, and "%" starts an escape.
+var resourceEscaper = strings.NewReplacer("%", "%25", "/", "%2F", "#", "%23")
+
+// Resource names a piece of shared state by its type, the ID of one instance,
+// and a field, so every service and SDK that touches it records the same
+// variable. Build one with NewResource; the zero Resource names nothing.
+//
+//	raceway.NewResource("accounts").ID("alice").Field("balance") // accounts/alice/balance
+type Resource struct {
+	resourceType string
+	id           *string
+	field        string
+}
+
+// NewResource returns the Resource naming all resources of resourceType, such
+// as "accounts". Narrow it with ID and Field.
+func NewResource(resourceType string) Resource {
+	return Resource{resourceType: resourceType}
+}
+
+// ID returns r narrowed to the resource with id. An empty id is a valid ID.
+func (r Resource) ID(id string) Resource {
+	r.id = &id
+	return r
+}
+
+// Field returns r narrowed to field.
+func (r Resource) Field(field string) Resource {
+	r.field = field
+	return r
+}
+
+// String returns r's canonical name: the type, ID, and field joined by "/",
+// as in "accounts/alice/balance". The field of a resource without an ID
+// follows a "#" instead, as in "settings#timeout", so it never reads as an
+// ID. "%", "/", and "#" within each part are percent-encoded as "%25", "%2F",
+// and "%23", so "accounts/a/b/balance" cannot come from an ID "a/b".
+func (r Resource) String() string {
+	var b strings.Builder
+	b.WriteString(resourceEscaper.Replace(r.resourceType))
+	if r.id != nil {
+		b.WriteByte('/')
+		b.WriteString(resourceEscaper.Replace(*r.id))
+	}
+	if r.field != "" {
+		if r.id != nil {
+			b.WriteByte('/')
+		} else {
+			b.WriteByte('#')
+		}
+		b.WriteString(resourceEscaper.Replace(r.field))
+	}
+	return b.String()
+}
+
+// TrackResourceChange tracks a read or write to resource. The event's
+// Variable is resource.String(), and its type, ID, and field are recorded
+// separately. The caller's location is recorded.
+//
+// Example:
+//
+//	balance := raceway.NewResource("accounts").ID(from).Field("balance")
+//	client.TrackResourceChange(ctx, balance, old, updated, "Write")
+func (c *Client) TrackResourceChange(ctx context.Context, resource Resource, oldValue, newValue interface{}, accessType string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.captureEvent(ctx, EventKind{
+		StateChange: &StateChangeData{
+			Variable:     resource.String(),
+			OldValue:     oldValue,
+			NewValue:     newValue,
+			Location:     c.captureLocation(2),
+			AccessType:   accessType,
+			ResourceType: resource.resourceType,
+			ResourceID:   resource.id,
+			Field:        resource.field,
+		},
+	})
+}
@@ -0,0 +1,98 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResourceString(t *testing.T) {
+	tests := []struct {
+		resource Resource
+		want     string
+	}{
+		{NewResource("accounts"), "accounts"},
+		{NewResource("accounts").ID("alice"), "accounts/alice"},
+		{NewResource("accounts").ID("alice").Field("balance"), "accounts/alice/balance"},
+		{NewResource("settings").Field("timeout"), "settings#timeout"},
+		{NewResource("accounts").ID(""), "accounts/"},
+		{NewResource("accounts").ID("").Field("balance"), "accounts//balance"},
+		{NewResource("accounts").ID("alice").Field("balance").ID("bob"), "accounts/bob/balance"},
+
+		// Separators and the escape character are percent-encoded in every part
+		{NewResource("accounts").ID("a/b"), "accounts/a%2Fb"},
+		{NewResource("accounts").ID("a#b"), "accounts/a%23b"},
+		{NewResource("accounts").ID("100%"), "accounts/100%25"},
+		{NewResource("accounts").ID("%2F"), "accounts/%252F"},
+		{NewResource("accounts").ID("/#%"), "accounts/%2F%23%25"},
+		{NewResource("accounts").ID("//"), "accounts/%2F%2F"},
+		{NewResource("a/b").ID("c"), "a%2Fb/c"},
+		{NewResource("a#b").Field("c"), "a%23b#c"},
+		{NewResource("accounts").ID("alice").Field("balance/usd"), "accounts/alice/balance%2Fusd"},
+		{NewResource("settings").Field("a#b"), "settings#a%23b"},
+
+		// Other characters are kept as they are
+		{NewResource("accounts").ID(`a\b [c].d`), `accounts/a\b [c].d`},
+		{NewResource("accounts").ID("åß?&=:"), "accounts/åß?&=:"},
+	}
+	for _, tt := range tests {
+		if got := tt.resource.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestResourceNamesDoNotCollide(t *testing.T) {
+	resources := []Resource{
+		NewResource("accounts").ID("a/b"),
+		NewResource("accounts").ID("a").Field("b"),
+		NewResource("accounts/a").Field("b"),
+		NewResource("accounts").Field("a/b"),
+		NewResource("accounts").ID("a#b"),
+		NewResource("accounts#a").ID("b"),
+		NewResource("accounts").ID("a").Field("b#c"),
+		NewResource("accounts").ID("a%2Fb"),
+		NewResource("accounts").Field("a"),
+		NewResource("accounts").ID("a"),
+		NewResource("accounts").ID("").Field("a"),
+	}
+	seen := map[string]int{}
+	for i, r := range resources {
+		name := r.String()
+		if j, ok := seen[name]; ok {
+			t.Errorf("resources %d and %d are both named %q", j, i, name)
+		}
+		seen[name] = i
+	}
+}
+
+func TestTrackResourceChange(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	balance := NewResource("accounts").ID("a/b").Field("balance")
+	c.TrackResourceChange(ctx, balance, 100, 50, "Write")
+	c.TrackResourceChange(ctx, NewResource("settings").Field("timeout"), nil, 30, "Read")
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	write := events[0].Kind.StateChange
+	if write.Variable != "accounts/a%2Fb/balance" || write.ResourceType != "accounts" || write.ResourceID == nil || *write.ResourceID != "a/b" || write.Field != "balance" {
+		t.Errorf("unexpected resource access %+v", write)
+	}
+	if write.AccessType != "Write" || fmt.Sprint(write.NewValue) != "50" {
+		t.Errorf("unexpected access %+v", write)
+	}
+	if !strings.HasPrefix(write.Location, "resource_test.go:") {
+		t.Errorf("expected the caller's location, got %q", write.Location)
+	}
+	read := events[1].Kind.StateChange
+	if read.Variable != "settings#timeout" || read.ResourceID != nil || read.Field != "timeout" {
+		t.Errorf("unexpected resource access %+v", read)
+	}
+}
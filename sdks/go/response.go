@@ -0,0 +1,183 @@
+package raceway
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// responseBytesTag records the number of body bytes a handler wrote on the
+// HTTPResponse event tracked by Middleware.
+const responseBytesTag = "response_bytes"
+
+// responseRecorder observes the status and body size of a response written
+// through it, and with captureBody the start of the body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+
+	captureBody bool
+	client      *Client
+	// body captures the response body once the first write shows it is
+	// recorded; decided is set by then
+	body    *bodyCapture
+	decided bool
+}
+
+// startBody decides from the response headers, or by sniffing p when the
+// handler set no Content-Type, whether the body is recorded, as net/http
+// does when sending it.
+func (r *responseRecorder) startBody(p []byte, sniff bool) {
+	if !r.captureBody || r.decided {
+		return
+	}
+	r.decided = true
+	header := r.Header()
+	contentType := header.Get("Content-Type")
+	if contentType == "" && sniff {
+		contentType = http.DetectContentType(p)
+	}
+	r.body = r.client.newBodyCapture(contentType, header.Get("Content-Encoding"))
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if len(p) > 0 {
+		r.startBody(p, true)
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	if r.body != nil {
+		r.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (r *responseRecorder) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := r.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && r.status == 0 {
+		// The connection now belongs to the handler, typically to upgrade it
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *responseRecorder) readFrom(src io.Reader) (int64, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.startBody(nil, false)
+	if r.body != nil {
+		src = io.TeeReader(src, r.body)
+	}
+	n, err := r.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	r.bytes += n
+	return n, err
+}
+
+type flusher struct{ *responseRecorder }
+
+func (f flusher) Flush() { f.flush() }
+
+type hijacker struct{ *responseRecorder }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) { return h.hijack() }
+
+type readerFrom struct{ *responseRecorder }
+
+func (rf readerFrom) ReadFrom(src io.Reader) (int64, error) { return rf.readFrom(src) }
+
+// wrap returns a ResponseWriter that writes through r and implements
+// http.Flusher, http.Hijacker, and io.ReaderFrom exactly when the underlying
+// writer does, so handlers that check for them behave as without Raceway.
+func (r *responseRecorder) wrap() http.ResponseWriter {
+	_, canFlush := r.ResponseWriter.(http.Flusher)
+	_, canHijack := r.ResponseWriter.(http.Hijacker)
+	_, canReadFrom := r.ResponseWriter.(io.ReaderFrom)
+
+	switch {
+	case canFlush && canHijack && canReadFrom:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+			readerFrom
+		}{r, flusher{r}, hijacker{r}, readerFrom{r}}
+	case canFlush && canHijack:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+		}{r, flusher{r}, hijacker{r}}
+	case canFlush && canReadFrom:
+		return struct {
+			*responseRecorder
+			flusher
+			readerFrom
+		}{r, flusher{r}, readerFrom{r}}
+	case canHijack && canReadFrom:
+		return struct {
+			*responseRecorder
+			hijacker
+			readerFrom
+		}{r, hijacker{r}, readerFrom{r}}
+	case canFlush:
+		return struct {
+			*responseRecorder
+			flusher
+		}{r, flusher{r}}
+	case canHijack:
+		return struct {
+			*responseRecorder
+			hijacker
+		}{r, hijacker{r}}
+	case canReadFrom:
+		return struct {
+			*responseRecorder
+			readerFrom
+		}{r, readerFrom{r}}
+	default:
+		return r
+	}
+}
+
+// trackResponse records the response observed by rec as an HTTPResponse
+// event. A handler that never wrote a status answered 200 OK.
+func (c *Client) trackResponse(ctx context.Context, rec *responseRecorder, start time.Time) {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	duration := time.Since(start)
+	var body interface{}
+	if rec.body != nil {
+		body = rec.body.value(c.redactor)
+	}
+	c.trackHTTPResponse(ctx, status, nil, body, duration, map[string]string{responseBytesTag: strconv.FormatInt(rec.bytes, 10)})
+}
@@ -0,0 +1,226 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// responseEvent returns the single HTTPResponse event buffered by c.
+func responseEvent(t *testing.T, c *Client) Event {
+	t.Helper()
+	var found []Event
+	for _, e := range bufferedEvents(c) {
+		if e.Kind.HTTPResponse != nil {
+			found = append(found, e)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected one HTTPResponse event, got %d", len(found))
+	}
+	return found[0]
+}
+
+func serveMiddleware(c *Client, w http.ResponseWriter, handler http.HandlerFunc) {
+	c.Middleware(handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/transfer", nil))
+}
+
+func TestMiddlewareRecordsResponse(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	serveMiddleware(c, httptest.NewRecorder(), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	event := responseEvent(t, c)
+	if event.Kind.HTTPResponse.Status != http.StatusCreated {
+		t.Errorf("expected the first status written, got %d", event.Kind.HTTPResponse.Status)
+	}
+	if got := event.Metadata.Tags[responseBytesTag]; got != "7" {
+		t.Errorf("expected 7 response bytes, got %q", got)
+	}
+}
+
+func TestMiddlewareRecordsResponseDuration(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	serveMiddleware(c, httptest.NewRecorder(), func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+	})
+
+	event := responseEvent(t, c)
+	if event.Metadata.DurationNs == nil || *event.Metadata.DurationNs < int64(2*time.Millisecond) {
+		t.Fatalf("expected a duration of at least 2ms in metadata, got %v", event.Metadata.DurationNs)
+	}
+	if got, want := event.Kind.HTTPResponse.DurationMs, *event.Metadata.DurationNs/int64(time.Millisecond); got != want {
+		t.Errorf("expected duration_ms %d to agree with duration_ns, got %d", want, got)
+	}
+}
+
+func TestTrackHTTPResponseDuration(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackHTTPResponseDuration(ctx, http.StatusOK, nil, nil, 1500*time.Microsecond)
+	c.TrackHTTPResponse(ctx, http.StatusOK, nil, nil, 150)
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	for i, want := range []struct{ ns, ms int64 }{{1500000, 1}, {150000000, 150}} {
+		event := events[i]
+		if event.Metadata.DurationNs == nil || *event.Metadata.DurationNs != want.ns {
+			t.Errorf("event %d: expected duration_ns %d, got %v", i, want.ns, event.Metadata.DurationNs)
+		}
+		if event.Kind.HTTPResponse.DurationMs != want.ms {
+			t.Errorf("event %d: expected duration_ms %d, got %d", i, want.ms, event.Kind.HTTPResponse.DurationMs)
+		}
+	}
+}
+
+func TestMiddlewareRecordsStatusOKWhenNeverWritten(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	serveMiddleware(c, httptest.NewRecorder(), func(w http.ResponseWriter, r *http.Request) {})
+
+	event := responseEvent(t, c)
+	if event.Kind.HTTPResponse.Status != http.StatusOK {
+		t.Errorf("expected 200, got %d", event.Kind.HTTPResponse.Status)
+	}
+	if got := event.Metadata.Tags[responseBytesTag]; got != "0" {
+		t.Errorf("expected 0 response bytes, got %q", got)
+	}
+}
+
+func TestMiddlewareRecordsRecoveredPanicResponse(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.RecoverPanics = true })
+	serveMiddleware(c, httptest.NewRecorder(), func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+
+	if status := responseEvent(t, c).Kind.HTTPResponse.Status; status != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", status)
+	}
+}
+
+func TestMiddlewarePreservesFlusher(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	rec := httptest.NewRecorder()
+	serveMiddleware(c, rec, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Hijacker); ok {
+			t.Error("expected no Hijacker when the underlying writer lacks one")
+		}
+		if _, ok := w.(io.ReaderFrom); ok {
+			t.Error("expected no ReaderFrom when the underlying writer lacks one")
+		}
+		w.Write([]byte("chunk"))
+		w.(http.Flusher).Flush()
+	})
+
+	if !rec.Flushed {
+		t.Error("expected Flush to reach the underlying writer")
+	}
+	if got := responseEvent(t, c).Metadata.Tags[responseBytesTag]; got != "5" {
+		t.Errorf("expected 5 response bytes, got %q", got)
+	}
+}
+
+// hijackableWriter is a ResponseWriter that supports only Hijack.
+type hijackableWriter struct {
+	http.ResponseWriter
+	hijacked bool
+}
+
+func (w *hijackableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	server, client := net.Pipe()
+	client.Close()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func TestMiddlewarePreservesHijacker(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	underlying := &hijackableWriter{ResponseWriter: httptest.NewRecorder()}
+	serveMiddleware(c, struct {
+		http.ResponseWriter
+		http.Hijacker
+	}{underlying, underlying}, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); ok {
+			t.Error("expected no Flusher when the underlying writer lacks one")
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	})
+
+	if !underlying.hijacked {
+		t.Error("expected Hijack to reach the underlying writer")
+	}
+	if status := responseEvent(t, c).Kind.HTTPResponse.Status; status != http.StatusSwitchingProtocols {
+		t.Errorf("expected a hijacked response to be recorded as 101, got %d", status)
+	}
+}
+
+// readerFromWriter is a ResponseWriter that supports only ReadFrom.
+type readerFromWriter struct {
+	http.ResponseWriter
+	readFrom bool
+}
+
+func (w *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseWriter, src)
+}
+
+func TestMiddlewarePreservesReaderFrom(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	underlying := &readerFromWriter{ResponseWriter: httptest.NewRecorder()}
+	serveMiddleware(c, struct {
+		http.ResponseWriter
+		io.ReaderFrom
+	}{underlying, underlying}, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); ok {
+			t.Error("expected no Flusher when the underlying writer lacks one")
+		}
+		// LimitReader hides strings.Reader's WriteTo, which io.Copy would prefer
+		io.Copy(w, io.LimitReader(strings.NewReader("statement.csv contents"), 1<<20))
+	})
+
+	if !underlying.readFrom {
+		t.Error("expected io.Copy to use the underlying ReadFrom")
+	}
+	if got := responseEvent(t, c).Metadata.Tags[responseBytesTag]; got != "22" {
+		t.Errorf("expected 22 response bytes, got %q", got)
+	}
+}
+
+func TestMiddlewarePreservesServerInterfaces(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	server := httptest.NewServer(c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, canFlush := w.(http.Flusher)
+		_, canHijack := w.(http.Hijacker)
+		_, canReadFrom := w.(io.ReaderFrom)
+		if !canFlush || !canHijack || !canReadFrom {
+			t.Errorf("expected the server's Flusher, Hijacker, and ReaderFrom, got %v %v %v", canFlush, canHijack, canReadFrom)
+		}
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("expected ResponseController to reach the server's writer: %v", err)
+		}
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
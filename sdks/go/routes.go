@@ -0,0 +1,379 @@
+package raceway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Route delivers events matching Match to a dedicated sink, for example
+// shipping Error and lock events to long-retention storage while high-volume
+// StateChange events go to the analysis cluster.
+type Route struct {
+	// Name identifies the route in RouteStats
+	Name string
+	// Match selects the events delivered to this route
+	Match RouteMatch
+	// Sink receives the matched events
+	Sink EventSink
+	// BatchSize is the maximum number of events per Send (default: no limit)
+	BatchSize int
+	// MaxRetries is how many times a failed Send is retried (default: 0)
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled after each attempt
+	// and randomized by up to half (default: 100ms)
+	RetryBackoff time.Duration
+	// MaxBackoff caps the delay between retries (default: 5 seconds)
+	MaxBackoff time.Duration
+	// Mirror also delivers the matched events to the default route, so the
+	// route observes them, for example to export them elsewhere, without
+	// taking them from the Raceway server
+	Mirror bool
+}
+
+// RouteMatch selects events for a Route. All non-empty criteria must match.
+type RouteMatch struct {
+	// Kinds lists event kind names as returned by EventKind.Name, e.g. "StateChange", "Error"
+	Kinds []string
+	// Variables are path.Match globs applied to StateChange and CacheOp variables and lock IDs
+	Variables []string
+	// Tags must all be present on the event with the given values
+	Tags map[string]string
+}
+
+// Matches reports whether event satisfies the match criteria.
+func (m RouteMatch) Matches(event *Event) bool {
+	if len(m.Kinds) > 0 {
+		name := event.Kind.Name()
+		found := false
+		for _, kind := range m.Kinds {
+			if kind == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(m.Variables) > 0 {
+		subject, ok := routeSubject(event)
+		if !ok {
+			return false
+		}
+		found := false
+		for _, pattern := range m.Variables {
+			if matched, _ := path.Match(pattern, subject); matched {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for k, v := range m.Tags {
+		if event.Metadata.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func routeSubject(event *Event) (string, bool) {
+	switch {
+	case event.Kind.StateChange != nil:
+		return event.Kind.StateChange.Variable, true
+	case event.Kind.LockAcquire != nil:
+		return event.Kind.LockAcquire.LockID, true
+	case event.Kind.LockRelease != nil:
+		return event.Kind.LockRelease.LockID, true
+	case event.Kind.LockContention != nil:
+		return event.Kind.LockContention.LockID, true
+	case event.Kind.CacheOp != nil:
+		return CacheVariable(event.Kind.CacheOp.System, event.Kind.CacheOp.Key), true
+	}
+	return "", false
+}
+
+// RouteStats reports delivery counters for one route.
+type RouteStats struct {
+	Name      string
+	Sent      uint64
+	Failed    uint64
+	Batches   uint64
+	Retries   uint64
+	LastError string
+}
+
+// defaultRouteName is the name reported for events that match no route.
+const defaultRouteName = "default"
+
+// routePipeline batches and delivers events for one route.
+type routePipeline struct {
+	route Route
+	// onDrop receives the events of batches that failed permanently
+	onDrop func(DropReason, []Event)
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	batches atomic.Uint64
+	retries atomic.Uint64
+
+	mu        sync.Mutex
+	lastError string
+}
+
+func newRoutePipeline(route Route, onDrop func(DropReason, []Event)) *routePipeline {
+	if route.RetryBackoff <= 0 {
+		route.RetryBackoff = 100 * time.Millisecond
+	}
+	if route.MaxBackoff <= 0 {
+		route.MaxBackoff = 5 * time.Second
+	}
+	return &routePipeline{route: route, onDrop: onDrop}
+}
+
+// deliver sends events in batches of at most BatchSize, retrying failed batches.
+// It returns the undelivered events of batches that failed transiently, and the
+// events of batches that failed permanently and were dropped, which are also
+// passed to onDrop.
+func (p *routePipeline) deliver(ctx context.Context, events []Event) (requeue, dropped []Event, err error) {
+	size := p.route.BatchSize
+	if size <= 0 {
+		size = len(events)
+	}
+
+	var firstErr error
+	for start := 0; start < len(events); start += size {
+		end := start + size
+		if end > len(events) {
+			end = len(events)
+		}
+		batch := events[start:end]
+
+		err := ctx.Err()
+		if err == nil {
+			var delivered int
+			delivered, err = p.sendWithRetry(ctx, batch)
+			p.batches.Add(1)
+			p.sent.Add(uint64(delivered))
+			batch = batch[delivered:]
+		}
+		if err != nil {
+			p.failed.Add(uint64(len(batch)))
+			p.mu.Lock()
+			p.lastError = err.Error()
+			p.mu.Unlock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			if isTransient(err) {
+				requeue = append(requeue, batch...)
+			} else {
+				dropped = append(dropped, batch...)
+				p.onDrop(dropReason(err), batch)
+			}
+		}
+	}
+	return requeue, dropped, firstErr
+}
+
+// sendWithRetry sends batch, retrying transient failures with jittered
+// exponential backoff, and returns how many leading events were delivered
+// before err. A retry resends only the events not yet delivered. It runs on
+// the flushing goroutine, never on the caller that captured the events.
+func (p *routePipeline) sendWithRetry(ctx context.Context, batch []Event) (delivered int, err error) {
+	send := func() {
+		err = p.route.Sink.Send(ctx, batch[delivered:])
+		var partial *partialSendError
+		if errors.As(err, &partial) {
+			delivered += partial.sent
+			err = partial.err
+		}
+	}
+
+	backoff := p.route.RetryBackoff
+	send()
+	for attempt := 0; err != nil && isTransient(err) && attempt < p.route.MaxRetries; attempt++ {
+		select {
+		case <-ctx.Done():
+			return delivered, errors.Join(err, ctx.Err())
+		case <-time.After(jitter(backoff)):
+		}
+		if backoff *= 2; backoff > p.route.MaxBackoff {
+			backoff = p.route.MaxBackoff
+		}
+		p.retries.Add(1)
+		send()
+	}
+	if err == nil {
+		delivered = len(batch)
+	}
+	return delivered, err
+}
+
+// jitter returns a random duration in [d/2, d].
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(int64(d)-half+1))
+}
+
+func (p *routePipeline) stats() RouteStats {
+	p.mu.Lock()
+	lastError := p.lastError
+	p.mu.Unlock()
+	return RouteStats{
+		Name:      p.route.Name,
+		Sent:      p.sent.Load(),
+		Failed:    p.failed.Load(),
+		Batches:   p.batches.Load(),
+		Retries:   p.retries.Load(),
+		LastError: lastError,
+	}
+}
+
+func (p *routePipeline) close() error {
+	if closer, ok := p.route.Sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// router partitions events across route pipelines, falling back to the default pipeline.
+type router struct {
+	routes    []*routePipeline
+	fallback  *routePipeline
+	matchAll  bool
+	hasRoutes bool
+	onDrop    func(DropReason, []Event)
+}
+
+// newRouter returns a router for routes; onDrop receives every event dropped
+// after a permanent failure.
+func newRouter(routes []Route, fallback Route, matchAll bool, onDrop func(DropReason, []Event)) *router {
+	r := &router{
+		fallback:  newRoutePipeline(fallback, onDrop),
+		matchAll:  matchAll,
+		hasRoutes: len(routes) > 0,
+		onDrop:    onDrop,
+	}
+	for _, route := range routes {
+		r.routes = append(r.routes, newRoutePipeline(route, onDrop))
+	}
+	return r
+}
+
+// deliver partitions events and sends each partition through its pipeline.
+// Per-event encodings are computed once and shared by every route. Events that
+// failed transiently are returned once for redelivery, remembering the routes
+// they failed on, and are redelivered only to those. An event counts as
+// dropped only if it failed permanently and is not owed to another route.
+func (r *router) deliver(ctx context.Context, events []Event) (requeue []Event, dropped int, err error) {
+	if !r.hasRoutes {
+		requeue, lost, err := r.fallback.deliver(ctx, events)
+		return requeue, len(lost), err
+	}
+
+	if err := encodeEvents(events); err != nil {
+		r.onDrop(DropMarshalError, events)
+		return nil, len(events), permanent(err)
+	}
+
+	// The last partition is the default route's
+	fallback := len(r.routes)
+	partitions := make([][]Event, len(r.routes)+1)
+	for i := range events {
+		if events[i].pendingRoutes != nil {
+			for _, j := range events[i].pendingRoutes {
+				partitions[j] = append(partitions[j], events[i])
+			}
+			continue
+		}
+		matched, mirrored := false, false
+		for j, pipeline := range r.routes {
+			if !pipeline.route.Match.Matches(&events[i]) {
+				continue
+			}
+			partitions[j] = append(partitions[j], events[i])
+			matched = true
+			mirrored = mirrored || pipeline.route.Mirror
+			if !r.matchAll {
+				break
+			}
+		}
+		if !matched || mirrored {
+			partitions[fallback] = append(partitions[fallback], events[i])
+		}
+	}
+
+	var errs []error
+	// pending maps the ID of each requeued event to its index in requeue
+	pending := make(map[string]int)
+	lost := make(map[string]bool)
+	for j, partition := range partitions {
+		if len(partition) == 0 {
+			continue
+		}
+		pipeline := r.fallback
+		if j < fallback {
+			pipeline = r.routes[j]
+		}
+		retry, failed, err := pipeline.deliver(ctx, partition)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, event := range failed {
+			lost[event.ID] = true
+		}
+		for _, event := range retry {
+			if k, ok := pending[event.ID]; ok {
+				requeue[k].pendingRoutes = append(requeue[k].pendingRoutes, j)
+				continue
+			}
+			pending[event.ID] = len(requeue)
+			event.pendingRoutes = []int{j}
+			requeue = append(requeue, event)
+		}
+	}
+	for id := range lost {
+		if _, ok := pending[id]; !ok {
+			dropped++
+		}
+	}
+	return requeue, dropped, errors.Join(errs...)
+}
+
+func (r *router) stats() []RouteStats {
+	stats := make([]RouteStats, 0, len(r.routes)+1)
+	for _, pipeline := range r.routes {
+		stats = append(stats, pipeline.stats())
+	}
+	return append(stats, r.fallback.stats())
+}
+
+func (r *router) close() error {
+	var errs []error
+	for _, pipeline := range r.routes {
+		if err := pipeline.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := r.fallback.close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// RouteStats returns delivery counters for each configured route, followed by
+// the default route that receives unmatched events.
+func (c *Client) RouteStats() []RouteStats {
+	return c.router.stats()
+}
@@ -0,0 +1,248 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+	sends  int
+	fail   int
+	closed bool
+}
+
+func (s *recordingSink) Send(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sends++
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("sink unavailable")
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) received() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+func TestRoutesPartitionEventsByKindAndVariable(t *testing.T) {
+	errorsSink := &recordingSink{}
+	stateSink := &recordingSink{}
+	fallback := &recordingSink{}
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Routes = []Route{
+			{Name: "errors", Match: RouteMatch{Kinds: []string{"Error", "LockAcquire", "LockRelease"}}, Sink: errorsSink},
+			{Name: "state", Match: RouteMatch{Kinds: []string{"StateChange"}, Variables: []string{"account.*"}}, Sink: stateSink, BatchSize: 2},
+		}
+	})
+	c.router.fallback.route.Sink = fallback
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "account.balance", 1, 2, "routes_test.go:1", "Write")
+	c.TrackStateChange(ctx, "account.owner", "a", "b", "routes_test.go:1", "Write")
+	c.TrackStateChange(ctx, "account.limit", nil, 10, "routes_test.go:1", "Read")
+	c.TrackStateChange(ctx, "cache.size", 0, 1, "routes_test.go:1", "Write")
+	c.TrackLockAcquire(ctx, "account-lock", "Mutex")
+	c.TrackLockRelease(ctx, "account-lock", "Mutex")
+	c.TrackFunctionCall(ctx, "handler", "main", nil, "routes_test.go", 1)
+	c.Flush()
+
+	if got := len(errorsSink.received()); got != 2 {
+		t.Errorf("errors route received %d events, want 2", got)
+	}
+	for _, e := range errorsSink.received() {
+		if e.Kind.LockAcquire == nil && e.Kind.LockRelease == nil {
+			t.Errorf("errors route received %s event", e.Kind.Name())
+		}
+	}
+	state := stateSink.received()
+	if len(state) != 3 {
+		t.Fatalf("state route received %d events, want 3", len(state))
+	}
+	for _, e := range state {
+		if e.Kind.StateChange == nil || e.Kind.StateChange.Variable == "cache.size" {
+			t.Errorf("state route received unexpected event %+v", e.Kind)
+		}
+	}
+	if got := len(fallback.received()); got != 2 {
+		t.Errorf("default route received %d events, want 2", got)
+	}
+
+	stats := c.RouteStats()
+	if len(stats) != 3 || stats[2].Name != defaultRouteName {
+		t.Fatalf("unexpected route stats %+v", stats)
+	}
+	if stats[1].Sent != 3 || stats[1].Batches != 2 {
+		t.Errorf("state route stats = %+v, want 3 sent in 2 batches", stats[1])
+	}
+}
+
+func TestRoutesFirstMatchWinsUnlessAllMatches(t *testing.T) {
+	for _, all := range []bool{false, true} {
+		first := &recordingSink{}
+		second := &recordingSink{}
+		c := newBufferingClient(t, func(cfg *Config) {
+			cfg.RouteToAllMatches = all
+			cfg.Routes = []Route{
+				{Name: "first", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: first},
+				{Name: "second", Match: RouteMatch{Tags: map[string]string{"sdk_language": "go"}}, Sink: second},
+			}
+		})
+
+		ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+		c.TrackStateChange(ctx, "counter", 0, 1, "routes_test.go:1", "Write")
+		c.Flush()
+
+		want := 0
+		if all {
+			want = 1
+		}
+		if got := len(first.received()); got != 1 {
+			t.Errorf("all=%v: first route received %d events, want 1", all, got)
+		}
+		if got := len(second.received()); got != want {
+			t.Errorf("all=%v: second route received %d events, want %d", all, got, want)
+		}
+	}
+}
+
+func TestRequeuedEventRedeliveredOnlyToFailedRoute(t *testing.T) {
+	failing := &recordingSink{fail: 1}
+	healthy := &recordingSink{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.RouteToAllMatches = true
+		cfg.Routes = []Route{
+			{Name: "failing", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: failing},
+			{Name: "healthy", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: healthy},
+		}
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "routes_test.go:1", "Write")
+	var flushErr *FlushError
+	if err := c.FlushContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Requeued != 1 || flushErr.Dropped != 0 {
+		t.Fatalf("expected the event requeued for the failing route, got %v", err)
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(failing.received()); got != 1 {
+		t.Errorf("failing route received %d events after recovering, want 1", got)
+	}
+	if got := len(healthy.received()); got != 1 {
+		t.Errorf("healthy route received %d events, want 1 without a duplicate", got)
+	}
+}
+
+func TestEventRequeuedForOneRouteIsNotCountedDropped(t *testing.T) {
+	failing := &recordingSink{fail: 1}
+	rejecting := &permanentSink{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.RouteToAllMatches = true
+		cfg.Routes = []Route{
+			{Name: "rejecting", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: rejecting},
+			{Name: "failing", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: failing},
+		}
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "routes_test.go:1", "Write")
+	var flushErr *FlushError
+	if err := c.FlushContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Requeued != 1 || flushErr.Dropped != 0 {
+		t.Fatalf("expected the event requeued and not dropped, got %v", err)
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rejecting.sends.Load() != 1 || len(failing.received()) != 1 {
+		t.Errorf("expected the event resent only to the failing route, got %d rejected sends", rejecting.sends.Load())
+	}
+}
+
+func TestMirrorRouteAlsoDeliversToDefaultRoute(t *testing.T) {
+	mirror := &recordingSink{}
+	fallback := &recordingSink{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Routes = []Route{
+			{Name: "spans", Match: RouteMatch{Kinds: []string{"FunctionCall"}}, Sink: mirror, Mirror: true},
+		}
+	})
+	c.router.fallback.route.Sink = fallback
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackFunctionCall(ctx, "handler", "main", nil, "routes_test.go", 1)
+	c.TrackStateChange(ctx, "counter", 0, 1, "routes_test.go:1", "Write")
+	c.Flush()
+
+	if got := len(mirror.received()); got != 1 {
+		t.Errorf("mirror route received %d events, want 1", got)
+	}
+	if got := len(fallback.received()); got != 2 {
+		t.Errorf("default route received %d events, want both", got)
+	}
+}
+
+func TestRouteRetriesAndRecordsFailures(t *testing.T) {
+	flaky := &recordingSink{fail: 1}
+	down := &recordingSink{fail: 100}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Routes = []Route{
+			{Name: "flaky", Match: RouteMatch{Variables: []string{"flaky"}}, Sink: flaky, MaxRetries: 2, RetryBackoff: 1},
+			{Name: "down", Match: RouteMatch{Variables: []string{"down"}}, Sink: down, RetryBackoff: 1},
+		}
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "flaky", 0, 1, "routes_test.go:1", "Write")
+	c.TrackStateChange(ctx, "down", 0, 1, "routes_test.go:1", "Write")
+	c.Flush()
+
+	stats := c.RouteStats()
+	if stats[0].Sent != 1 || stats[0].Retries != 1 || stats[0].Failed != 0 {
+		t.Errorf("flaky route stats = %+v", stats[0])
+	}
+	if stats[1].Failed != 1 || stats[1].LastError == "" {
+		t.Errorf("down route stats = %+v", stats[1])
+	}
+}
+
+func TestShutdownClosesRouteSinks(t *testing.T) {
+	sink := &recordingSink{}
+	c := New(Config{
+		ServiceName:   "test-service",
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+		Routes:        []Route{{Name: "all", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: sink}},
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "routes_test.go:1", "Write")
+	c.Shutdown()
+
+	if len(sink.received()) != 1 {
+		t.Errorf("expected pending events to be delivered on shutdown")
+	}
+	if !sink.closed {
+		t.Errorf("expected route sink to be closed on shutdown")
+	}
+}
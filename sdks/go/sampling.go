@@ -0,0 +1,57 @@
+package raceway
+
+import "hash/fnv"
+
+// sampleTrace makes the local sampling decision for a trace that carried
+// none from upstream.
+func (c *Client) sampleTrace(traceID, path string) bool {
+	if c.config.Sampler != nil {
+		return c.config.Sampler(traceID, path)
+	}
+	rate := c.live.Load().SampleRate
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	return traceSampleValue(traceID) < rate
+}
+
+// traceSampleValue maps traceID to a uniformly distributed value in [0, 1).
+func traceSampleValue(traceID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// sampled returns rctx's sampling decision, making it on first use for
+// contexts created outside of the middleware.
+func (c *Client) sampled(rctx *RacewayContext) bool {
+	rctx.decideSampling(nil, func() bool { return c.sampleTrace(rctx.TraceID, "") })
+	rctx.mu.Lock()
+	defer rctx.mu.Unlock()
+	if !rctx.Sampled && rctx.shared != nil && rctx.shared.promoted.Load() {
+		rctx.Sampled = true
+	}
+	return rctx.Sampled
+}
+
+// decideSampling records the trace's sampling decision unless one was
+// already made, preferring the upstream decision when there is one. local
+// may run user code, such as Config.Sampler, so it is called without r
+// locked; when goroutines decide at once, the first to finish wins.
+func (r *RacewayContext) decideSampling(upstream *bool, local func() bool) {
+	r.mu.Lock()
+	decided := r.sampleDecided
+	r.mu.Unlock()
+	if decided {
+		return
+	}
+	sampled := upstream != nil && *upstream
+	if upstream == nil {
+		sampled = local()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.sampleDecided {
+		r.Sampled, r.sampleDecided = sampled, true
+	}
+}
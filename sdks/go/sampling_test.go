@@ -0,0 +1,92 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSamplingIsDeterministicAcrossServices(t *testing.T) {
+	frontend := newBufferingClient(t, func(cfg *Config) { cfg.SampleRate = 0.3 })
+	backend := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServiceName = "backend"
+		cfg.SampleRate = 0.3
+	})
+
+	const traces = 1000
+	sampled := 0
+	for i := 0; i < traces; i++ {
+		traceID := uuid.NewSHA1(uuid.NameSpaceOID, []byte{byte(i), byte(i >> 8)}).String()
+		a := frontend.sampleTrace(traceID, "/checkout")
+		if b := backend.sampleTrace(traceID, "/inventory"); a != b {
+			t.Fatalf("services disagree on trace %s", traceID)
+		}
+		if a {
+			sampled++
+		}
+	}
+	if sampled < 240 || sampled > 360 {
+		t.Errorf("sampled %d of %d traces at rate 0.3", sampled, traces)
+	}
+}
+
+func TestUnsampledTracesRecordNothingDownstream(t *testing.T) {
+	frontend := newBufferingClient(t, func(cfg *Config) { cfg.Sampler = func(string, string) bool { return false } })
+	backend := newBufferingClient(t, nil)
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	frontend.TrackStateChange(ctx, "cart", nil, 1, "sampling_test.go:1", "Read")
+	headers, err := frontend.PropagationHeaders(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bufferedEvents(frontend)) != 0 {
+		t.Errorf("expected no events for an unsampled trace")
+	}
+	if !strings.HasSuffix(headers["traceparent"], "-00") {
+		t.Errorf("expected the sampled flag cleared, got %q", headers["traceparent"])
+	}
+
+	// The backend records every trace locally but honors the upstream decision
+	req := httptest.NewRequest(http.MethodGet, "/inventory", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	backend.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backend.TrackStateChange(r.Context(), "stock", 1, 0, "sampling_test.go:1", "Write")
+		if FromContext(r.Context()).Sampled {
+			t.Errorf("expected the backend context to be unsampled")
+		}
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	if n := len(bufferedEvents(backend)); n != 0 {
+		t.Errorf("expected the backend to record nothing, got %d events", n)
+	}
+}
+
+func TestSamplerReceivesRequestPath(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.SampleRate = 0.0001
+		cfg.Sampler = func(traceID, path string) bool { return path == "/api/transfer" }
+	})
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.TrackStateChange(r.Context(), "balance", 1, 2, "sampling_test.go:1", "Write")
+	}))
+
+	for _, path := range []string{"/api/transfer", "/health", "/api/transfer"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	traces := map[string]bool{}
+	for _, e := range bufferedEvents(c) {
+		traces[e.TraceID] = true
+	}
+	if len(traces) != 2 {
+		t.Errorf("expected events from the 2 transfer requests only, got %d traces", len(traces))
+	}
+}
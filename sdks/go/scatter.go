@@ -0,0 +1,246 @@
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scatter tracks one logical operation fanned out to several downstream members,
+// such as the same query broadcast to every shard.
+//
+// Each member gets its own propagation headers, tagged with the scatter ID and
+// member index so downstream services can group the calls. Finish records a
+// summary event with the fastest and slowest members and any failures.
+//
+// Example:
+//
+//	sg := client.StartScatter(ctx, "shard_query", len(shards))
+//	for i, shard := range shards {
+//	    go func(i int, shard string) {
+//	        req, _ := http.NewRequestWithContext(ctx, "GET", shard, nil)
+//	        for k, v := range sg.Headers(i) {
+//	            req.Header.Set(k, v)
+//	        }
+//	        resp, err := http.DefaultClient.Do(req)
+//	        sg.DoneResponse(i, resp, err)
+//	    }(i, shard)
+//	}
+//	summary := sg.Finish()
+type Scatter struct {
+	client       *Client
+	ctx          context.Context
+	id           string
+	name         string
+	startEventID string
+	startedAt    time.Time
+	headers      []map[string]string
+
+	mu      sync.Mutex
+	members []scatterMember
+	echoed  []CausalityEntry
+}
+
+type scatterMember struct {
+	done     bool
+	status   int
+	err      error
+	duration time.Duration
+}
+
+// ScatterSummary describes the outcome of a scatter-gather operation.
+type ScatterSummary struct {
+	ScatterID string `json:"scatter_id"`
+	Name      string `json:"name"`
+	Members   int    `json:"members"`
+	Completed int    `json:"completed"`
+	// Failures lists the members that did not finish, or finished with an
+	// error or a 5xx status
+	Failures []int `json:"failures"`
+	// FastestIndex and SlowestIndex are -1 when no member completed
+	FastestIndex int   `json:"fastest_index"`
+	FastestMs    int64 `json:"fastest_ms"`
+	SlowestIndex int   `json:"slowest_index"`
+	SlowestMs    int64 `json:"slowest_ms"`
+	// StragglerGapMs is the difference between the slowest and fastest member
+	StragglerGapMs int64 `json:"straggler_gap_ms"`
+}
+
+// StartScatter begins a scatter-gather operation with n members and pre-builds
+// propagation headers for each of them. The local clock advances once for the
+// whole operation rather than once per member.
+func (c *Client) StartScatter(ctx context.Context, name string, n int) *Scatter {
+	if n < 0 {
+		n = 0
+	}
+	s := &Scatter{
+		client:    c,
+		ctx:       ctx,
+		id:        uuid.New().String(),
+		name:      name,
+		startedAt: time.Now(),
+		headers:   make([]map[string]string, n),
+		members:   make([]scatterMember, n),
+	}
+
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		for i := range s.headers {
+			s.headers[i] = map[string]string{}
+		}
+		return s
+	}
+
+	file, line := c.captureFileLine(2)
+	s.startEventID = c.captureEventWith(ctx, EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "scatter:" + name,
+			Module:       "raceway.scatter",
+			Args:         map[string]interface{}{"scatter_id": s.id, "members": n},
+			File:         file,
+			Line:         line,
+		},
+	}, captureOptions{tags: map[string]string{"scatter_id": s.id}})
+
+	scatterFields := c.Capabilities().Has(CapabilityScatterFields)
+	for i := 0; i < n; i++ {
+		var extra map[string]interface{}
+		if scatterFields {
+			extra = map[string]interface{}{"scatter_id": s.id, "scatter_index": i}
+		}
+		headers := MapCarrier{}
+		c.contextHeaders(headers, rctx, extra)
+		s.headers[i] = headers
+	}
+	rctx.markDistributed()
+
+	return s
+}
+
+// ID returns the scatter identifier carried to every member.
+func (s *Scatter) ID() string {
+	return s.id
+}
+
+// Headers returns the propagation headers for member i.
+func (s *Scatter) Headers(i int) map[string]string {
+	if i < 0 || i >= len(s.headers) {
+		return map[string]string{}
+	}
+	headers := make(map[string]string, len(s.headers[i]))
+	for k, v := range s.headers[i] {
+		headers[k] = v
+	}
+	return headers
+}
+
+// Done records the outcome of member i. It is safe to call from multiple goroutines.
+func (s *Scatter) Done(i int, status int, err error) {
+	s.done(i, status, err, nil)
+}
+
+// DoneResponse records the outcome of member i from its HTTP response, merging
+// any raceway-clock header the member echoed back.
+func (s *Scatter) DoneResponse(i int, resp *http.Response, err error) {
+	if resp == nil {
+		s.done(i, 0, err, nil)
+		return
+	}
+	var echoed []CausalityEntry
+	if raw := resp.Header.Get(racewayClockHeader); raw != "" {
+		if parsed, ok := parseRacewayClock(raw); ok {
+			echoed = parsed.clock
+		}
+	}
+	s.done(i, resp.StatusCode, err, echoed)
+}
+
+func (s *Scatter) done(i int, status int, err error, echoed []CausalityEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < 0 || i >= len(s.members) || s.members[i].done {
+		return
+	}
+	s.members[i] = scatterMember{
+		done:     true,
+		status:   status,
+		err:      err,
+		duration: time.Since(s.startedAt),
+	}
+	if len(echoed) > 0 {
+		s.echoed = MergeClockVectors(s.echoed, echoed)
+	}
+}
+
+// Finish merges echoed clocks into the originating context and emits a summary
+// event parented to the scatter start. Members that never reported are counted
+// as failures. Finish must be called from the goroutine that owns the context.
+func (s *Scatter) Finish() ScatterSummary {
+	s.mu.Lock()
+	summary := ScatterSummary{
+		ScatterID:    s.id,
+		Name:         s.name,
+		Members:      len(s.members),
+		Failures:     []int{},
+		FastestIndex: -1,
+		SlowestIndex: -1,
+	}
+	for i, m := range s.members {
+		// A status of 0 is a member that is not an HTTP call
+		if !m.done || m.err != nil || m.status >= 500 {
+			summary.Failures = append(summary.Failures, i)
+		}
+		if !m.done {
+			continue
+		}
+		summary.Completed++
+		ms := m.duration.Milliseconds()
+		if summary.FastestIndex < 0 || m.duration < s.members[summary.FastestIndex].duration {
+			summary.FastestIndex = i
+			summary.FastestMs = ms
+		}
+		if summary.SlowestIndex < 0 || m.duration > s.members[summary.SlowestIndex].duration {
+			summary.SlowestIndex = i
+			summary.SlowestMs = ms
+		}
+	}
+	if summary.Completed > 0 {
+		summary.StragglerGapMs = summary.SlowestMs - summary.FastestMs
+	}
+	echoed := s.echoed
+	s.mu.Unlock()
+
+	rctx := FromContext(s.ctx)
+	if rctx == nil {
+		return summary
+	}
+	if len(echoed) > 0 {
+		rctx.mergeClock(echoed)
+	}
+
+	var parentID *string
+	if s.startEventID != "" {
+		parentID = &s.startEventID
+	}
+	file, line := s.client.captureFileLine(2)
+	s.client.captureEventWith(s.ctx, EventKind{
+		FunctionReturn: &FunctionReturnData{
+			FunctionName: "scatter:" + s.name,
+			ReturnValue:  summary,
+			File:         file,
+			Line:         line,
+		},
+	}, captureOptions{
+		parentID: parentID,
+		tags: map[string]string{
+			"scatter_id":       s.id,
+			"scatter_failures": fmt.Sprint(len(summary.Failures)),
+		},
+	})
+
+	return summary
+}
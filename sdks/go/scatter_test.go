@@ -0,0 +1,163 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScatterGatherAcrossBackends(t *testing.T) {
+	upstream := newBufferingClient(t, nil)
+	downstream := newBufferingClient(t, func(c *Config) {
+		c.ServiceName = "shard"
+		c.InstanceID = "shard-1"
+	})
+
+	const members = 10
+	var seenMu sync.Mutex
+	seen := make(map[int]string)
+
+	backends := make([]*httptest.Server, members)
+	for i := range backends {
+		delay := time.Duration(i*10) * time.Millisecond
+		status := http.StatusOK
+		if i == 7 {
+			status = http.StatusInternalServerError
+		}
+		backends[i] = httptest.NewServer(downstream.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rctx := FromContext(r.Context())
+			index, _ := strconv.Atoi(rctx.tags["scatter_index"])
+			seenMu.Lock()
+			seen[index] = rctx.tags["scatter_id"]
+			seenMu.Unlock()
+
+			// Echo the downstream clock so the caller can merge it.
+			headers, _ := downstream.PropagationHeaders(r.Context(), nil)
+			w.Header().Set("raceway-clock", headers["raceway-clock"])
+			time.Sleep(delay)
+			w.WriteHeader(status)
+		})))
+		defer backends[i].Close()
+	}
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	sg := upstream.StartScatter(ctx, "shard_query", members)
+
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", url, nil)
+			for k, v := range sg.Headers(i) {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			sg.DoneResponse(i, resp, err)
+		}(i, backend.URL)
+	}
+	wg.Wait()
+	summary := sg.Finish()
+
+	if len(seen) != members {
+		t.Fatalf("expected all %d members to see scatter tags, got %d", members, len(seen))
+	}
+	for i, id := range seen {
+		if id != sg.ID() {
+			t.Errorf("member %d saw scatter ID %q, want %q", i, id, sg.ID())
+		}
+	}
+
+	if summary.Completed != members {
+		t.Errorf("expected %d completed members, got %d", members, summary.Completed)
+	}
+	if len(summary.Failures) != 1 || summary.Failures[0] != 7 {
+		t.Errorf("expected member 7 to fail, got %v", summary.Failures)
+	}
+	if summary.FastestIndex != 0 || summary.SlowestIndex != members-1 {
+		t.Errorf("expected fastest 0 and slowest %d, got %d and %d", members-1, summary.FastestIndex, summary.SlowestIndex)
+	}
+	if summary.StragglerGapMs < 60 {
+		t.Errorf("expected straggler gap of at least 60ms, got %d", summary.StragglerGapMs)
+	}
+
+	rctx := FromContext(ctx)
+	if !hasClockComponent(rctx.ClockVector, "shard#shard-1", 1) {
+		t.Errorf("expected echoed downstream clock to be merged, got %v", rctx.ClockVector)
+	}
+
+	events := bufferedEvents(upstream)
+	if len(events) != 2 {
+		t.Fatalf("expected start and summary events, got %d", len(events))
+	}
+	start, finish := events[0], events[1]
+	if finish.ParentID == nil || *finish.ParentID != start.ID {
+		t.Error("expected summary event to be parented to the scatter start")
+	}
+	if finish.Metadata.Tags["scatter_id"] != sg.ID() {
+		t.Errorf("expected summary to carry scatter ID, got %v", finish.Metadata.Tags)
+	}
+}
+
+func TestScatterHeadersShareOneClockTick(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	sg := client.StartScatter(ctx, "fanout", 3)
+	for i := 0; i < 3; i++ {
+		clock, ok := parseRacewayClock(sg.Headers(i)["raceway-clock"])
+		if !ok {
+			t.Fatalf("member %d: expected a raceway-clock header", i)
+		}
+		if !hasClockComponent(clock.clock, "test-service#test-instance", 1) {
+			t.Errorf("member %d: expected clock at the start event's tick, got %v", i, clock.clock)
+		}
+		if clock.scatterIndex == nil || *clock.scatterIndex != i {
+			t.Errorf("member %d: unexpected scatter index %v", i, clock.scatterIndex)
+		}
+	}
+	if sg.Headers(0)["traceparent"] == sg.Headers(1)["traceparent"] {
+		t.Error("expected each member to get its own child span")
+	}
+}
+
+func TestScatterFailuresIgnoreMembersWithoutStatus(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	sg := client.StartScatter(ctx, "fanout", 4)
+	sg.Done(0, 0, nil)
+	sg.Done(1, 200, nil)
+	sg.Done(2, 503, nil)
+	sg.Done(3, 0, errors.New("connection refused"))
+
+	summary := sg.Finish()
+	if len(summary.Failures) != 2 || summary.Failures[0] != 2 || summary.Failures[1] != 3 {
+		t.Errorf("expected only the 5xx and errored members to fail, got %v", summary.Failures)
+	}
+}
+
+func TestScatterWithoutContext(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	sg := client.StartScatter(context.Background(), "fanout", 2)
+	if len(sg.Headers(1)) != 0 {
+		t.Error("expected no headers outside a Raceway context")
+	}
+	sg.Done(0, 200, nil)
+	summary := sg.Finish()
+	if summary.Completed != 1 || len(summary.Failures) != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if len(bufferedEvents(client)) != 0 {
+		t.Error("expected no events outside a Raceway context")
+	}
+}
@@ -0,0 +1,95 @@
+package raceway
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// SchemaVersion is the version of the event wire format, sent as
+// schema_version in every batch posted to the server. It is incremented by
+// any change to Event or its kinds that the server must decode differently.
+const SchemaVersion = 1
+
+// accessTypes are the StateChange access types the server accepts.
+var accessTypes = map[string]bool{
+	"Read":            true,
+	"Write":           true,
+	AccessAtomicRead:  true,
+	AccessAtomicWrite: true,
+	AccessAtomicRMW:   true,
+}
+
+// ValidateEvent reports the first reason the server would reject event: a
+// missing ID or trace ID, a timestamp that is not RFC 3339, a kind with other
+// than exactly one variant set, an unknown StateChange access type, or a
+// causality entry without a component.
+func ValidateEvent(e Event) error {
+	switch {
+	case e.ID == "":
+		return errors.New("raceway: event has no id")
+	case e.TraceID == "":
+		return fmt.Errorf("raceway: event %s has no trace_id", e.ID)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, e.Timestamp); err != nil {
+		return fmt.Errorf("raceway: event %s has timestamp %q, want RFC 3339", e.ID, e.Timestamp)
+	}
+	if n := e.Kind.variants(); n != 1 {
+		return fmt.Errorf("raceway: event %s sets %d kinds, want exactly one", e.ID, n)
+	}
+	if change := e.Kind.StateChange; change != nil && !accessTypes[change.AccessType] {
+		return fmt.Errorf("raceway: event %s has unknown access type %q", e.ID, change.AccessType)
+	}
+	for i, entry := range e.CausalityVector {
+		if entry.Component() == "" {
+			return fmt.Errorf("raceway: event %s has causality entry %d without a component", e.ID, i)
+		}
+	}
+	return nil
+}
+
+// variants returns how many of k's variants are set.
+func (k EventKind) variants() int {
+	n := 0
+	v := reflect.ValueOf(k)
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsNil() {
+			n++
+		}
+	}
+	return n
+}
+
+// validateEvents returns events without those that fail ValidateEvent, which
+// are logged and reported to Config.OnEventsDropped as DropMarshalError so
+// that one malformed event cannot get the whole batch rejected. In Debug mode
+// every event is checked; otherwise only the first of each flush, which is
+// enough to catch an encoding the server no longer accepts.
+func (c *Client) validateEvents(events []Event) []Event {
+	checked := len(events)
+	if !c.debug.Load() {
+		checked = min(checked, 1)
+	}
+	var valid, invalid []Event
+	for i := 0; i < checked; i++ {
+		err := ValidateEvent(events[i])
+		if err == nil {
+			if invalid != nil {
+				valid = append(valid, events[i])
+			}
+			continue
+		}
+		c.logger.Errorf("Dropping %s event: %v", events[i].Kind.Name(), err)
+		if invalid == nil {
+			valid = append(make([]Event, 0, len(events)-1), events[:i]...)
+		}
+		invalid = append(invalid, events[i])
+	}
+	if invalid == nil {
+		return events
+	}
+	c.recordDropped(len(invalid))
+	c.eventsDropped(DropMarshalError, invalid)
+	return append(valid, events[checked:]...)
+}
@@ -0,0 +1,221 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateWire = flag.Bool("update", false, "rewrite wire format golden files")
+
+// wireEvent returns an event of kind with every metadata field set to a
+// fixed value.
+func wireEvent(kind EventKind) Event {
+	parentID := "5b0c6d6e-2f4a-4c3e-9a51-0d3f2b1e7c10"
+	instanceID := "api-1"
+	durationNs := int64(1500)
+	return Event{
+		ID:        "9e1f4a2b-7c3d-4e5f-8a6b-1c2d3e4f5a6b",
+		TraceID:   validTraceID,
+		ParentID:  &parentID,
+		Timestamp: "2024-01-02T03:04:05.123456789Z",
+		Kind:      kind,
+		Metadata: Metadata{
+			ThreadID:    "goroutine-7",
+			ProcessID:   4242,
+			ServiceName: "api",
+			Environment: "test",
+			Tags:        map[string]string{"team": "payments"},
+			DurationNs:  &durationNs,
+			InstanceID:  &instanceID,
+		},
+		CausalityVector: []CausalityEntry{NewCausalityEntry("api#api-1", 3), NewCausalityEntry("billing#b-1", 1)},
+		LockSet:         []string{"accounts"},
+		Seq:             3,
+	}
+}
+
+// wireKinds holds one populated value of every EventKind variant, with
+// recorded values as snapshotted JSON as they are when captured.
+func wireKinds() []EventKind {
+	key := "alice"
+	waitNs := int64(2000)
+	hit := true
+	parentTxID := "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	return []EventKind{
+		{StateChange: &StateChangeData{Variable: "accounts[alice].balance", OldValue: json.RawMessage(`1000`), NewValue: json.RawMessage(`900`),
+			Location: "bank.go:42", AccessType: "Write", Container: "accounts", Key: &key, Field: "balance"}},
+		{FunctionCall: &FunctionCallData{FunctionName: "transfer", Module: "app", Args: json.RawMessage(`{"amount":100}`), File: "bank.go", Line: 40}},
+		{FunctionReturn: &FunctionReturnData{FunctionName: "transfer", ReturnValue: json.RawMessage(`"ok"`), File: "bank.go", Line: 50}},
+		{AsyncSpawn: &AsyncSpawnData{TaskID: "task-1", TaskName: "audit", SpawnedAt: "bank.go:44"}},
+		{AsyncAwait: &AsyncAwaitData{FutureID: "task-1", AwaitedAt: "bank.go:46"}},
+		{AsyncJoin: &AsyncJoinData{TaskID: "task-1", JoinedAt: "bank.go:47", ChildClock: []CausalityEntry{NewCausalityEntry("api#api-1", 5)}}},
+		{LockAcquire: &LockAcquireData{LockID: "accounts", LockType: "Mutex", Location: "bank.go:41", WaitNs: &waitNs}},
+		{LockRelease: &LockReleaseData{LockID: "accounts", LockType: "Mutex", Location: "bank.go:49"}},
+		{LockContention: &LockContentionData{LockID: "accounts", LockType: "Mutex", WaitNs: 2000, TimedOut: true, Location: "bank.go:41"}},
+		{HTTPRequest: &HTTPRequestData{Method: "POST", URL: "/transfer", Headers: map[string]string{"Content-Type": "application/json"}, Body: json.RawMessage(`{"amount":100}`)}},
+		{HTTPResponse: &HTTPResponseData{Status: 200, Headers: map[string]string{"Content-Type": "application/json"}, Body: json.RawMessage(`{"ok":true}`), DurationMs: 12}},
+		{Error: &ErrorData{ErrorType: "*errors.errorString", Message: "insufficient funds", StackTrace: []string{"bank.go:45"}}},
+		{AntiPattern: &AntiPatternData{Pattern: "check_then_act", Variable: "alice.balance", LockID: "accounts", Message: "read and write under separate locks",
+			EventIDs: []string{"e1", "e2"}, Locations: []string{"bank.go:42", "bank.go:48"}}},
+		{Fence: &FenceData{Name: "rebalance", Epoch: 7, Direction: FenceFull, Location: "consumer.go:12"}},
+		{Annotation: &AnnotationData{Message: "feature flag flipped", Attrs: map[string]string{"flag": "new-ledger"}, Location: "bank.go:10"}},
+		{Custom: &CustomData{Type: "cache_invalidation", Payload: map[string]interface{}{"key": json.RawMessage(`"accounts"`)}}},
+		{CacheOp: &CacheOpData{System: "redis", Operation: "GET", Key: "balance:alice", AccessType: "Read", Hit: &hit, DurationNs: 350000,
+			Location: "bank.go:43"}},
+		{ExternalCall: &ExternalCallData{System: "stripe", Endpoint: "/v1/charges", Operation: "create_charge", Status: "200",
+			DurationNs: 87000000, Location: "bank.go:52"}},
+		{Transaction: &TransactionData{TxID: "7d3f9a2e-1b4c-4e8a-9f6d-2c5b8e1a4d70", Name: "transfer", Phase: TransactionCommit,
+			ParentTxID: &parentTxID, Resources: []string{"accounts/alice/balance", "accounts/bob/balance"}, Location: "bank.go:39"}},
+	}
+}
+
+// checkGolden compares got with testdata/wire/name.golden.json, rewriting it
+// with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, got, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	indented.WriteByte('\n')
+
+	path := filepath.Join("testdata", "wire", name+".golden.json")
+	if *updateWire {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, indented.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create): %v", err)
+	}
+	if !bytes.Equal(indented.Bytes(), want) {
+		t.Errorf("wire format differs from %s (run with -update to accept):\n%s", path, indented.Bytes())
+	}
+}
+
+func TestEventWireFormat(t *testing.T) {
+	kinds := wireKinds()
+	if n := reflect.TypeOf(EventKind{}).NumField(); len(kinds) != n {
+		t.Fatalf("expected a fixture for each of the %d event kinds, got %d", n, len(kinds))
+	}
+	for _, kind := range kinds {
+		t.Run(kind.Name(), func(t *testing.T) {
+			event := wireEvent(kind)
+			if err := ValidateEvent(event); err != nil {
+				t.Fatalf("fixture is invalid: %v", err)
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, kind.Name(), data)
+		})
+	}
+}
+
+func TestBatchWireFormat(t *testing.T) {
+	events := []Event{wireEvent(wireKinds()[0])}
+	data, err := marshalBatch(events, &clientEnvelope{InstanceID: "api-1", BatchSeq: 12, SDKVersion: "0.0.0-test", DroppedSinceLastBatch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "batch", data)
+}
+
+// TestMetadataDurationWireFormat pins duration_ns as always present, null for
+// events that measure no duration.
+func TestMetadataDurationWireFormat(t *testing.T) {
+	event := wireEvent(wireKinds()[0])
+	event.Metadata.DurationNs = nil
+	data, err := json.Marshal(event.Metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "metadata_unmeasured", data)
+}
+
+func TestValidateEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Event)
+		want   string
+	}{
+		{"valid", func(e *Event) {}, ""},
+		{"missing id", func(e *Event) { e.ID = "" }, "no id"},
+		{"missing trace id", func(e *Event) { e.TraceID = "" }, "no trace_id"},
+		{"unparseable timestamp", func(e *Event) { e.Timestamp = "2024-01-02 03:04:05" }, "RFC 3339"},
+		{"no kind", func(e *Event) { e.Kind = EventKind{} }, "sets 0 kinds"},
+		{"two kinds", func(e *Event) { e.Kind.Custom = &CustomData{Type: "x"} }, "sets 2 kinds"},
+		{"unknown access type", func(e *Event) { e.Kind.StateChange.AccessType = "Delete" }, `access type "Delete"`},
+		{"atomic access type", func(e *Event) { e.Kind.StateChange.AccessType = AccessAtomicRMW }, ""},
+		{"empty component", func(e *Event) { e.CausalityVector = append(e.CausalityVector, NewCausalityEntry("", 1)) }, "entry 2 without a component"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := wireEvent(wireKinds()[0])
+			tt.modify(&event)
+			err := ValidateEvent(event)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("expected no error, got %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestInvalidEventsAreDroppedNotTheBatch(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		drops := make(dropRecorder, 8)
+		sink := &recordingSink{}
+		c := newBufferingClient(t, func(cfg *Config) {
+			cfg.Debug = debug
+			cfg.Logger = &capturingLogger{}
+			cfg.Sink = sink
+			cfg.OnEventsDropped = drops.hook
+		})
+		ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+		// The first event of a flush is always checked, later ones only in Debug mode
+		c.TrackStateChange(ctx, "a", 0, 1, "schema_test.go:1", "Delete")
+		c.TrackStateChange(ctx, "b", 0, 1, "schema_test.go:2", "Write")
+		c.TrackStateChange(ctx, "c", 0, 1, "schema_test.go:3", "Upsert")
+		if err := c.FlushContext(context.Background()); err != nil {
+			t.Fatalf("debug=%v: expected the valid events to be sent, got %v", debug, err)
+		}
+
+		wantDropped, wantSent := []string{"a"}, []string{"b", "c"}
+		if debug {
+			wantDropped, wantSent = []string{"a", "c"}, []string{"b"}
+		}
+		dropped := drops.wait(t, DropMarshalError, len(wantDropped))
+		var sent []string
+		for _, event := range sink.received() {
+			sent = append(sent, event.Kind.StateChange.Variable)
+		}
+		var droppedVars []string
+		for _, event := range dropped {
+			droppedVars = append(droppedVars, event.Kind.StateChange.Variable)
+		}
+		if !reflect.DeepEqual(droppedVars, wantDropped) || !reflect.DeepEqual(sent, wantSent) {
+			t.Errorf("debug=%v: dropped %v and sent %v, want %v and %v", debug, droppedVars, sent, wantDropped, wantSent)
+		}
+		if got := c.Stats().EventsDropped; got != uint64(len(wantDropped)) {
+			t.Errorf("debug=%v: expected %d dropped events in stats, got %d", debug, len(wantDropped), got)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package raceway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// EventSink delivers a batch of events to a destination.
+// Implementations must be safe for concurrent use.
+type EventSink interface {
+	Send(ctx context.Context, events []Event) error
+}
+
+// CompressionGzip compresses batch uploads with gzip. See Config.Compression.
+const CompressionGzip = "gzip"
+
+// DefaultCompressionThreshold is used when Config.CompressionThreshold is zero.
+const DefaultCompressionThreshold = 4096
+
+// httpSink posts batches to the Raceway server's events endpoint.
+type httpSink struct {
+	owner *Client
+	// url is the server URL with Config.EventsPath
+	url string
+	seq atomic.Uint64
+
+	// gzipThreshold is the smallest payload that is compressed; 0 disables compression
+	gzipThreshold int
+	// gzipRejected is set once the server answers 415 to a compressed batch
+	gzipRejected atomic.Bool
+	// maxPayload is the largest uncompressed payload posted at once
+	maxPayload int
+}
+
+func newHTTPSink(owner *Client, url string) *httpSink {
+	s := &httpSink{owner: owner, url: url, maxPayload: owner.config.MaxPayloadBytes}
+	if s.maxPayload <= 0 {
+		s.maxPayload = DefaultMaxPayloadBytes
+	}
+	switch owner.config.Compression {
+	case "":
+	case CompressionGzip:
+		s.gzipThreshold = owner.config.CompressionThreshold
+		if s.gzipThreshold <= 0 {
+			s.gzipThreshold = DefaultCompressionThreshold
+		}
+	default:
+		owner.logger.Warnf("Ignoring unsupported compression %q", owner.config.Compression)
+	}
+	return s
+}
+
+// clientEnvelope is the "client" block of the batch envelope. BatchSeq
+// increases by one for every post so the collector can detect loss and
+// reordering; Replayed marks posts that resend events from an earlier one.
+type clientEnvelope struct {
+	InstanceID            string `json:"instance_id"`
+	BatchSeq              uint64 `json:"batch_seq"`
+	SDKVersion            string `json:"sdk_version"`
+	DroppedSinceLastBatch uint64 `json:"dropped_since_last_batch"`
+	Replayed              bool   `json:"replayed,omitempty"`
+}
+
+// Send posts events as JSON batches in order, splitting them so that no
+// payload exceeds the sink's maxPayload. If a later batch fails, the error
+// reports how many events were already delivered.
+func (s *httpSink) Send(ctx context.Context, events []Event) error {
+	batches, err := s.split(events)
+	if err != nil {
+		return permanent(fmt.Errorf("raceway: marshaling events: %w", err))
+	}
+	sent := 0
+	for _, batch := range batches {
+		if err := s.sendBatch(ctx, batch); err != nil {
+			if sent == 0 {
+				return err
+			}
+			return &partialSendError{sent: sent, err: err}
+		}
+		sent += len(batch)
+	}
+	return nil
+}
+
+// maxEnvelope is the largest client block, used to reserve room for it in
+// every payload.
+var maxEnvelope = clientEnvelope{BatchSeq: math.MaxUint64, DroppedSinceLastBatch: math.MaxUint64, SDKVersion: SDKVersion, Replayed: true}
+
+// split encodes events and groups them, in order, into batches whose payload
+// fits in maxPayload. An event too large for a payload of its own has its
+// recorded values truncated and is sent alone.
+func (s *httpSink) split(events []Event) ([][]Event, error) {
+	if err := encodeEvents(events); err != nil {
+		return nil, err
+	}
+	envelope := maxEnvelope
+	envelope.InstanceID = s.owner.instanceID
+	overhead, err := marshalBatch(nil, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	limit := s.maxPayload - len(overhead)
+
+	var batches [][]Event
+	start, size := 0, 0
+	for i := range events {
+		if len(events[i].encoded) > limit {
+			events[i] = truncateEvent(events[i])
+			if err := encodeEvents(events[i : i+1]); err != nil {
+				return nil, err
+			}
+		}
+		// Events after the first are separated by a comma
+		n := len(events[i].encoded)
+		if i > start {
+			n++
+		}
+		if i > start && size+n > limit {
+			batches = append(batches, events[start:i])
+			start, n = i, len(events[i].encoded)
+			size = 0
+		}
+		size += n
+	}
+	if start < len(events) {
+		batches = append(batches, events[start:])
+	}
+	return batches, nil
+}
+
+// sendBatch posts events as a single JSON batch.
+func (s *httpSink) sendBatch(ctx context.Context, events []Event) error {
+	var envelope *clientEnvelope
+	if s.owner.Capabilities().Has(CapabilityClientEnvelope) {
+		envelope = &clientEnvelope{
+			InstanceID:            s.owner.instanceID,
+			BatchSeq:              s.seq.Add(1),
+			SDKVersion:            SDKVersion,
+			DroppedSinceLastBatch: s.owner.unreportedDrops.Swap(0),
+		}
+		for i := range events {
+			if events[i].attempted {
+				envelope.Replayed = true
+			}
+			events[i].attempted = true
+		}
+	}
+
+	err := s.post(ctx, events, envelope)
+	if err != nil && envelope != nil {
+		// Report the drops again until a batch is accepted
+		s.owner.unreportedDrops.Add(envelope.DroppedSinceLastBatch)
+	}
+	return err
+}
+
+func (s *httpSink) post(ctx context.Context, events []Event, envelope *clientEnvelope) error {
+	data, err := marshalBatch(events, envelope)
+	if err != nil {
+		return permanent(fmt.Errorf("raceway: marshaling events: %w", err))
+	}
+
+	if s.gzipThreshold > 0 && len(data) >= s.gzipThreshold && !s.gzipRejected.Load() {
+		compressed, err := gzipPayload(data)
+		if err != nil {
+			return permanent(fmt.Errorf("raceway: compressing events: %w", err))
+		}
+		err = s.postPayload(ctx, events, compressed, CompressionGzip)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnsupportedMediaType {
+			return err
+		}
+		// The server cannot decode gzip; send uncompressed from now on
+		s.gzipRejected.Store(true)
+		s.owner.logger.Debugf("Server rejected gzip batch, disabling compression")
+	}
+	return s.postPayload(ctx, events, data, "")
+}
+
+// postPayload posts data, the encoded batch of events. A 200 response, or a
+// 206 or 207 for a batch the server ingested in part, may acknowledge the
+// events individually.
+func (s *httpSink) postPayload(ctx context.Context, events []Event, data []byte, encoding string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	s.owner.authorize(req)
+
+	resp, err := s.owner.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusMultiStatus {
+		body, _ := io.ReadAll(resp.Body)
+		s.owner.stats.lastResponse.Store(ServerResponse{StatusCode: resp.StatusCode})
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	s.owner.acknowledge(events, resp)
+	return nil
+}
+
+func gzipPayload(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// StatusError is returned by the server sink when the server rejects a batch.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("raceway: server returned status %d, body: %s", e.StatusCode, e.Body)
+}
+
+// Transient reports whether the server may accept the batch if it is resent:
+// true for 5xx, 408 Request Timeout, and 429 Too Many Requests.
+func (e *StatusError) Transient() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// FlushError reports a failed flush. StatusCode and Body are set when the
+// server rejected a batch; Err holds the underlying sink errors.
+type FlushError struct {
+	StatusCode int
+	Body       string
+	// Dropped is the number of events discarded after a permanent failure
+	// or because MaxBufferedEvents was reached
+	Dropped int
+	// Requeued is the number of events buffered again for the next flush
+	Requeued int
+	// Spilled is the number of events Shutdown wrote to Config.SpillPath
+	Spilled int
+	Err     error
+}
+
+func (e *FlushError) Error() string {
+	if e.Spilled > 0 {
+		return fmt.Sprintf("raceway: flush failed (%d dropped, %d requeued, %d spilled): %v", e.Dropped, e.Requeued, e.Spilled, e.Err)
+	}
+	return fmt.Sprintf("raceway: flush failed (%d dropped, %d requeued): %v", e.Dropped, e.Requeued, e.Err)
+}
+
+func (e *FlushError) Unwrap() error { return e.Err }
+
+// partialSendError reports a Send that delivered the first sent events of
+// its batch before failing with err.
+type partialSendError struct {
+	sent int
+	err  error
+}
+
+func (e *partialSendError) Error() string {
+	return fmt.Sprintf("raceway: %d events delivered before: %v", e.sent, e.err)
+}
+
+func (e *partialSendError) Unwrap() error { return e.err }
+
+// permanentError marks a failure that resending the same batch cannot fix.
+type permanentError struct{ err error }
+
+func permanent(err error) error { return &permanentError{err: err} }
+
+func (e *permanentError) Error() string   { return e.err.Error() }
+func (e *permanentError) Unwrap() error   { return e.err }
+func (e *permanentError) Transient() bool { return false }
+
+// isTransient reports whether events that failed with err should be buffered
+// for redelivery. Errors are transient unless they, or an error they wrap,
+// implement Transient() bool and return false; sinks use this to report
+// batches that would be rejected again.
+func isTransient(err error) bool {
+	var t interface{ Transient() bool }
+	if errors.As(err, &t) {
+		return t.Transient()
+	}
+	return true
+}
+
+// encodeEvents serializes each event once so that batches for several sinks
+// can be assembled without re-marshaling shared events.
+func encodeEvents(events []Event) error {
+	for i := range events {
+		if events[i].encoded != nil {
+			continue
+		}
+		data, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		events[i].encoded = data
+	}
+	return nil
+}
+
+// marshalBatch builds the {"schema_version": N, "events": [...], "client": {...}}
+// envelope, reusing per-event encodings produced by encodeEvents where
+// available. The client block is omitted when envelope is nil.
+func marshalBatch(events []Event, envelope *clientEnvelope) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"schema_version":`)
+	buf.WriteString(strconv.Itoa(SchemaVersion))
+	buf.WriteString(`,"events":[`)
+	for i := range events {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		data := events[i].encoded
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(events[i]); err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(data)
+	}
+	buf.WriteByte(']')
+	if envelope != nil {
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"client":`)
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// truncateEvent returns a copy of event with its recorded values, such as
+// HTTP bodies and function arguments, replaced by a description of their
+// size, and tagged truncated=true. The original event is not modified.
+func truncateEvent(event Event) Event {
+	kind := event.Kind
+	switch {
+	case kind.StateChange != nil:
+		data := *kind.StateChange
+		data.OldValue, data.NewValue = truncatedValue(data.OldValue), truncatedValue(data.NewValue)
+		kind.StateChange = &data
+	case kind.FunctionCall != nil:
+		data := *kind.FunctionCall
+		data.Args = truncatedValue(data.Args)
+		kind.FunctionCall = &data
+	case kind.FunctionReturn != nil:
+		data := *kind.FunctionReturn
+		data.ReturnValue = truncatedValue(data.ReturnValue)
+		kind.FunctionReturn = &data
+	case kind.HTTPRequest != nil:
+		data := *kind.HTTPRequest
+		data.Body = truncatedValue(data.Body)
+		kind.HTTPRequest = &data
+	case kind.HTTPResponse != nil:
+		data := *kind.HTTPResponse
+		data.Body = truncatedValue(data.Body)
+		kind.HTTPResponse = &data
+	case kind.Custom != nil:
+		data := *kind.Custom
+		data.Payload = map[string]interface{}{}
+		kind.Custom = &data
+	}
+	event.Kind = kind
+
+	tags := make(map[string]string, len(event.Metadata.Tags)+1)
+	for k, v := range event.Metadata.Tags {
+		tags[k] = v
+	}
+	tags[truncatedTag] = "true"
+	event.Metadata.Tags = tags
+	event.encoded = nil
+	return event
+}
+
+// truncatedValue describes the serialized size of v.
+func truncatedValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("[unserializable %T]", v)
+	}
+	return fmt.Sprintf("[truncated: %d bytes]", len(data))
+}
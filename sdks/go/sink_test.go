@@ -0,0 +1,797 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushContextReturnsStatusAndRequeuesTransientFailures(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := int(status.Load())
+		if code == http.StatusOK {
+			received.Add(1)
+		}
+		w.WriteHeader(code)
+		w.Write([]byte("collector overloaded"))
+	}))
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 0
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+	c.TrackStateChange(ctx, "counter", 1, 2, "sink_test.go:2", "Write")
+
+	err := c.FlushContext(context.Background())
+	var flushErr *FlushError
+	if !errors.As(err, &flushErr) {
+		t.Fatalf("expected *FlushError, got %v", err)
+	}
+	if flushErr.StatusCode != http.StatusServiceUnavailable || flushErr.Body != "collector overloaded" {
+		t.Errorf("unexpected status/body: %d %q", flushErr.StatusCode, flushErr.Body)
+	}
+	if flushErr.Requeued != 2 || flushErr.Dropped != 0 {
+		t.Errorf("expected 2 requeued and 0 dropped, got %+v", flushErr)
+	}
+
+	status.Store(http.StatusOK)
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("retry flush failed: %v", err)
+	}
+	if received.Load() != 1 {
+		t.Errorf("expected requeued events to be sent in one batch, got %d requests", received.Load())
+	}
+	if err := c.FlushContext(context.Background()); err != nil || received.Load() != 1 {
+		t.Errorf("expected nothing left to flush, err=%v requests=%d", err, received.Load())
+	}
+}
+
+func TestFlushContextDropsPermanentFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad batch", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ServerURL = server.URL })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+
+	var flushErr *FlushError
+	if err := c.FlushContext(context.Background()); !errors.As(err, &flushErr) {
+		t.Fatalf("expected *FlushError, got %v", err)
+	}
+	if flushErr.StatusCode != http.StatusBadRequest || flushErr.Dropped != 1 || flushErr.Requeued != 0 {
+		t.Errorf("unexpected flush error %+v", flushErr)
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Errorf("dropped events must not be retried, got %v", err)
+	}
+}
+
+func TestFlushContextHonorsDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 0
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.FlushContext(flushCtx)
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("flush ignored the context deadline")
+	}
+	var flushErr *FlushError
+	if !errors.As(err, &flushErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline *FlushError, got %v", err)
+	}
+	if flushErr.Requeued != 1 {
+		t.Errorf("expected event to be requeued after cancellation, got %+v", flushErr)
+	}
+}
+
+func TestConcurrentFlushesArriveInCaptureOrder(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals [][]uint64
+	var posts atomic.Int32
+	firstArrived := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct{ Events []Event }
+		json.NewDecoder(r.Body).Decode(&body)
+		if posts.Add(1) == 1 {
+			// A slow first post gives a concurrent flush the chance to overtake it
+			close(firstArrived)
+			time.Sleep(100 * time.Millisecond)
+		}
+		var seqs []uint64
+		for _, event := range body.Events {
+			seqs = append(seqs, event.Seq)
+		}
+		mu.Lock()
+		arrivals = append(arrivals, seqs)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	track := func(n int) {
+		for i := 0; i < n; i++ {
+			c.TrackStateChange(ctx, "counter", i, i+1, "sink_test.go:1", "Write")
+		}
+	}
+
+	track(3)
+	first := make(chan error)
+	go func() { first <- c.FlushContext(context.Background()) }()
+	<-firstArrived
+	track(2)
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("second flush failed: %v", err)
+	}
+	if err := <-first; err != nil {
+		t.Fatalf("first flush failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := [][]uint64{{1, 2, 3}, {4, 5}}
+	if fmt.Sprint(arrivals) != fmt.Sprint(want) {
+		t.Errorf("batches arrived as %v, want %v", arrivals, want)
+	}
+}
+
+func TestFlushContextDeadlineWhileAnotherFlushDelivers(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	releaseFirst := func() { releaseOnce.Do(func() { close(release) }) }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer server.Close()
+	defer releaseFirst()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 0
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+	first := make(chan error)
+	go func() { first <- c.FlushContext(context.Background()) }()
+	<-arrived
+
+	c.TrackStateChange(ctx, "counter", 1, 2, "sink_test.go:2", "Write")
+	flushCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var flushErr *FlushError
+	if err := c.FlushContext(flushCtx); !errors.As(err, &flushErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline *FlushError, got %v", err)
+	}
+	if flushErr.Requeued != 1 {
+		t.Errorf("expected the waiting flush to leave its event buffered, got %+v", flushErr)
+	}
+
+	releaseFirst()
+	if err := <-first; err != nil {
+		t.Fatalf("first flush failed: %v", err)
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("expected the buffered event delivered next, got %v", err)
+	}
+}
+
+func TestBufferLimitDropsOldestEvents(t *testing.T) {
+	sink := &recordingSink{fail: 100}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.MaxBufferedEvents = 2
+		cfg.MaxRetries = 0
+	})
+	c.router.fallback.route.Sink = sink
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 3; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "sink_test.go:1", "Write")
+		// Let the writer buffer each event so the queue never fills
+		c.syncPipeline(context.Background())
+	}
+	if stats := c.Stats(); stats.EventsBuffered != 2 || stats.EventsDropped != 1 {
+		t.Fatalf("expected the oldest event dropped at capture, got %+v", stats)
+	}
+	newest := bufferedEvents(c)[1].ID
+
+	var flushErr *FlushError
+	if err := c.FlushContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Requeued != 2 {
+		t.Fatalf("expected 2 requeued events, got %v", err)
+	}
+	c.TrackStateChange(ctx, "counter", 3, 4, "sink_test.go:1", "Write")
+	c.syncPipeline(context.Background())
+
+	stats := c.Stats()
+	if stats.EventsBuffered != 2 || stats.EventsRequeued != 1 || stats.EventsDropped != 2 {
+		t.Errorf("expected the oldest requeued event dropped, got %+v", stats)
+	}
+	c.mu.Lock()
+	requeued := c.requeued[0].ID
+	c.mu.Unlock()
+	if requeued != newest {
+		t.Errorf("expected the newest requeued event kept")
+	}
+}
+
+func TestFlushRetriesWithBackoffUntilServerRecovers(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 3
+		cfg.InitialBackoff = time.Millisecond
+		cfg.MaxBackoff = 2 * time.Millisecond
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("expected flush to succeed after retries, got %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("expected 2 failures and 1 success, got %d requests", requests.Load())
+	}
+	if stats := c.RouteStats(); stats[len(stats)-1].Retries != 2 {
+		t.Errorf("expected 2 retries, got %+v", stats)
+	}
+	if stats := c.Stats(); stats.EventsBuffered != 0 || stats.EventsDropped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestFlushRetriesExhaustedRequeues(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 2
+		cfg.InitialBackoff = time.Millisecond
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+
+	var flushErr *FlushError
+	if err := c.FlushContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Requeued != 1 {
+		t.Fatalf("expected the event requeued after retries, got %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("expected 1 attempt and 2 retries, got %d requests", requests.Load())
+	}
+	if stats := c.Stats(); stats.EventsRequeued != 1 || stats.EventsDropped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPermanentFailuresAreNotRetried(t *testing.T) {
+	sink := &permanentSink{}
+	c := newBufferingClient(t, func(cfg *Config) { cfg.InitialBackoff = time.Millisecond })
+	c.router.fallback.route.Sink = sink
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+	c.FlushContext(context.Background())
+
+	if sink.sends.Load() != 1 || c.Stats().EventsDropped != 1 {
+		t.Errorf("expected a single send and one dropped event, got %d sends, %+v", sink.sends.Load(), c.Stats())
+	}
+}
+
+type permanentSink struct{ sends atomic.Int32 }
+
+func (s *permanentSink) Send(ctx context.Context, events []Event) error {
+	s.sends.Add(1)
+	return &StatusError{StatusCode: http.StatusUnprocessableEntity}
+}
+
+func TestShutdownReturnsFlushErrorAndCanBeRetried(t *testing.T) {
+	sink := &recordingSink{fail: 1}
+	c := New(Config{
+		ServiceName:     "test-service",
+		BatchSize:       100,
+		FlushInterval:   time.Hour,
+		ShutdownTimeout: time.Second,
+		Routes:          []Route{{Name: "all", Match: RouteMatch{Kinds: []string{"StateChange"}}, Sink: sink}},
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+
+	var flushErr *FlushError
+	if err := c.ShutdownContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Requeued != 1 {
+		t.Fatalf("expected requeued *FlushError from Shutdown, got %v", err)
+	}
+	if sink.closed {
+		t.Errorf("sinks must stay open while events are requeued")
+	}
+	if err := c.ShutdownContext(context.Background()); err != nil {
+		t.Fatalf("retried Shutdown failed: %v", err)
+	}
+	if len(sink.received()) != 1 || !sink.closed {
+		t.Errorf("expected event delivered and sink closed on retry")
+	}
+}
+
+func TestShutdownDropsEventsItCannotDeliver(t *testing.T) {
+	sink := &recordingSink{fail: 1}
+	drops := make(dropRecorder, 8)
+	c := New(Config{
+		ServiceName:     "test-service",
+		BatchSize:       100,
+		FlushInterval:   time.Hour,
+		ShutdownTimeout: time.Second,
+		Sink:            sink,
+		OnEventsDropped: drops.hook,
+	})
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+	c.Shutdown()
+
+	if events := drops.wait(t, DropSendFailed, 1); len(events) != 1 {
+		t.Errorf("expected the undelivered event dropped, got %d", len(events))
+	}
+	if stats := c.Stats(); stats.EventsBuffered != 0 || stats.EventsDropped != 1 {
+		t.Errorf("expected nothing left buffered, got %+v", stats)
+	}
+	if !sink.closed {
+		t.Error("expected the sink closed")
+	}
+}
+
+type envelopeRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	clients  []*clientEnvelope
+	counts   []int
+	versions []int
+}
+
+func (r *envelopeRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/capabilities" {
+		json.NewEncoder(w).Encode([]Capability{CapabilityAntiPatternEvents, CapabilityFences})
+		return
+	}
+	var body struct {
+		SchemaVersion int               `json:"schema_version"`
+		Events        []json.RawMessage `json:"events"`
+		Client        *clientEnvelope   `json:"client"`
+	}
+	json.NewDecoder(req.Body).Decode(&body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = append(r.clients, body.Client)
+	r.counts = append(r.counts, len(body.Events))
+	r.versions = append(r.versions, body.SchemaVersion)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestBatchEnvelopeSequenceAcrossFailures(t *testing.T) {
+	recorder := &envelopeRecorder{statuses: []int{http.StatusServiceUnavailable, http.StatusBadRequest}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 0
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	track := func(n int) {
+		for i := 0; i < n; i++ {
+			c.TrackStateChange(ctx, "counter", i, i+1, "sink_test.go:1", "Write")
+		}
+	}
+
+	track(2)
+	c.FlushContext(context.Background()) // 503: both events requeued
+	track(1)
+	c.FlushContext(context.Background()) // 400: all three dropped
+	track(1)
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("final flush failed: %v", err)
+	}
+	track(1)
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	want := []struct {
+		events   int
+		dropped  uint64
+		replayed bool
+	}{
+		{2, 0, false},
+		{3, 0, true},
+		{1, 3, false},
+		{1, 0, false},
+	}
+	if len(recorder.clients) != len(want) {
+		t.Fatalf("expected %d posts, got %d", len(want), len(recorder.clients))
+	}
+	for i, w := range want {
+		got := recorder.clients[i]
+		if got == nil {
+			t.Fatalf("post %d has no client block", i)
+		}
+		if got.BatchSeq != uint64(i+1) || got.InstanceID != "test-instance" || got.SDKVersion != SDKVersion || recorder.versions[i] != SchemaVersion {
+			t.Errorf("post %d: unexpected envelope %+v", i, got)
+		}
+		if recorder.counts[i] != w.events || got.DroppedSinceLastBatch != w.dropped || got.Replayed != w.replayed {
+			t.Errorf("post %d: events=%d envelope=%+v, want %+v", i, recorder.counts[i], got, w)
+		}
+	}
+}
+
+func TestBatchEnvelopeSuppressedForLegacyCollector(t *testing.T) {
+	recorder := &envelopeRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.ForceCapabilities = nil
+	})
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.clients) != 1 || recorder.clients[0] != nil || recorder.counts[0] != 1 {
+		t.Errorf("expected a plain events batch, got clients=%v counts=%v", recorder.clients, recorder.counts)
+	}
+	if recorder.versions[0] != SchemaVersion {
+		t.Errorf("expected schema_version %d without a client block, got %d", SchemaVersion, recorder.versions[0])
+	}
+}
+
+func TestGzipBatchRoundTrips(t *testing.T) {
+	var encodings []string
+	var decoded [][]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == CompressionGzip {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("invalid gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		var batch struct {
+			Events []json.RawMessage `json:"events"`
+		}
+		if err := json.NewDecoder(body).Decode(&batch); err != nil {
+			t.Errorf("decoding batch: %v", err)
+		}
+		decoded = append(decoded, batch.Events)
+	}))
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.Compression = CompressionGzip
+		cfg.CompressionThreshold = 2048
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "counter", 0, 1, "sink_test.go:1", "Write")
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	payload := strings.Repeat("x", 4096)
+	for i := 0; i < 3; i++ {
+		c.TrackFunctionCall(ctx, "handle", "app", map[string]string{"body": payload}, "sink_test.go", i)
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	if len(encodings) != 2 || encodings[0] != "" || encodings[1] != CompressionGzip {
+		t.Fatalf("expected only the large batch compressed, got %q", encodings)
+	}
+	if len(decoded[1]) != 3 || !strings.Contains(string(decoded[1][2]), payload) {
+		t.Errorf("compressed batch did not round-trip")
+	}
+}
+
+func TestGzipFallsBackAfterUnsupportedMediaType(t *testing.T) {
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.Compression = CompressionGzip
+		cfg.CompressionThreshold = 1
+		cfg.MaxRetries = 0
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 2; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "sink_test.go:1", "Write")
+		if err := c.FlushContext(context.Background()); err != nil {
+			t.Fatalf("flush %d failed: %v", i, err)
+		}
+	}
+
+	want := []string{CompressionGzip, "", ""}
+	if strings.Join(encodings, ",") != strings.Join(want, ",") {
+		t.Errorf("expected one rejected gzip post then plain posts, got %q", encodings)
+	}
+}
+
+func BenchmarkBatchUpload(b *testing.B) {
+	events := make([]Event, 1000)
+	for i := range events {
+		events[i] = Event{
+			ID:      fmt.Sprintf("event-%d", i),
+			TraceID: "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+			Kind: EventKind{FunctionCall: &FunctionCallData{
+				FunctionName: "handleCheckout",
+				Module:       "app",
+				Args:         map[string]interface{}{"cart_id": i, "items": []string{"sku-1", "sku-2", "sku-3"}, "note": "leave at the door"},
+				File:         "checkout.go",
+				Line:         42,
+			}},
+			Metadata:        Metadata{ThreadID: "thread-1", ServiceName: "checkout", Environment: "production", Tags: map[string]string{"sdk_language": "go"}},
+			CausalityVector: []CausalityEntry{NewCausalityEntry("checkout#i-1", uint64(i))},
+			LockSet:         []string{},
+		}
+	}
+
+	for _, compression := range []string{"", CompressionGzip} {
+		name := "none"
+		if compression != "" {
+			name = compression
+		}
+		b.Run(name, func(b *testing.B) {
+			var wire atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n, _ := io.Copy(io.Discard, r.Body)
+				wire.Store(n)
+			}))
+			defer server.Close()
+
+			config := DefaultConfig()
+			config.ServerURL = server.URL
+			config.FlushInterval = time.Hour
+			config.Compression = compression
+			c := New(config)
+			defer c.Shutdown()
+			sink := c.router.fallback.route.Sink
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := sink.Send(context.Background(), events); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(wire.Load()), "wire-bytes/op")
+		})
+	}
+}
+
+// limitedServer rejects payloads over limit with 413 and records the event
+// sequence numbers and tags of every accepted post.
+type limitedServer struct {
+	limit int
+	// failPost answers the post with this 1-based index with 503, once
+	failPost int
+
+	mu    sync.Mutex
+	posts int
+	seqs  [][]uint64
+	tags  []map[string]string
+	kinds []EventKind
+}
+
+func (s *limitedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	if len(data) > s.limit {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.posts++
+	if s.posts == s.failPost {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		return
+	}
+	var body struct{ Events []Event }
+	json.Unmarshal(data, &body)
+	var seqs []uint64
+	for _, event := range body.Events {
+		seqs = append(seqs, event.Seq)
+		s.tags = append(s.tags, event.Metadata.Tags)
+		s.kinds = append(s.kinds, event.Kind)
+	}
+	s.seqs = append(s.seqs, seqs)
+}
+
+func (s *limitedServer) received() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []uint64
+	for _, seqs := range s.seqs {
+		all = append(all, seqs...)
+	}
+	return all
+}
+
+func TestFlushSplitsBatchesUnderPayloadLimit(t *testing.T) {
+	recorder := &limitedServer{limit: 4096}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxPayloadBytes = recorder.limit
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 40; i++ {
+		c.TrackHTTPRequest(ctx, "POST", "/orders", nil, strings.Repeat("x", 300))
+	}
+
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("expected every batch to fit, got %v", err)
+	}
+	if len(recorder.seqs) < 2 {
+		t.Errorf("expected the flush split across posts, got %d", len(recorder.seqs))
+	}
+	got := recorder.received()
+	if len(got) != 40 {
+		t.Fatalf("expected 40 events, got %d", len(got))
+	}
+	for i, seq := range got {
+		if seq != uint64(i+1) {
+			t.Fatalf("expected events in capture order, got %v", got)
+		}
+	}
+}
+
+func TestFlushTruncatesEventLargerThanPayloadLimit(t *testing.T) {
+	recorder := &limitedServer{limit: 4096}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxPayloadBytes = recorder.limit
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackHTTPRequest(ctx, "POST", "/upload", nil, strings.Repeat("x", 10000))
+	c.TrackHTTPRequest(ctx, "POST", "/orders", nil, "small")
+
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("expected the oversized event to be truncated and sent, got %v", err)
+	}
+	if got := recorder.received(); len(got) != 2 {
+		t.Fatalf("expected both events, got %v", got)
+	}
+	if recorder.tags[0][truncatedTag] != "true" || recorder.kinds[0].HTTPRequest.Body != "[truncated: 10002 bytes]" {
+		t.Errorf("expected a truncated body and tag, got %v and %v", recorder.kinds[0].HTTPRequest.Body, recorder.tags[0])
+	}
+	if _, ok := recorder.tags[1][truncatedTag]; ok || recorder.kinds[1].HTTPRequest.Body != "small" {
+		t.Errorf("expected the small event untouched, got %v", recorder.kinds[1].HTTPRequest.Body)
+	}
+}
+
+func TestFlushRequeuesOnlyUndeliveredBatches(t *testing.T) {
+	recorder := &limitedServer{limit: 2048, failPost: 2}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxPayloadBytes = recorder.limit
+		cfg.MaxRetries = 0
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	for i := 0; i < 20; i++ {
+		c.TrackHTTPRequest(ctx, "POST", "/orders", nil, strings.Repeat("x", 300))
+	}
+
+	err := c.FlushContext(context.Background())
+	var flushErr *FlushError
+	if !errors.As(err, &flushErr) || flushErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 FlushError, got %v", err)
+	}
+	delivered := len(recorder.received())
+	if delivered == 0 || flushErr.Requeued != 20-delivered {
+		t.Errorf("expected only the %d undelivered events requeued, got %+v", 20-delivered, flushErr)
+	}
+
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("retry flush failed: %v", err)
+	}
+	got := recorder.received()
+	if len(got) != 20 {
+		t.Fatalf("expected every event exactly once, got %d", len(got))
+	}
+	for i, seq := range got {
+		if seq != uint64(i+1) {
+			t.Fatalf("expected events in capture order, got %v", got)
+		}
+	}
+}
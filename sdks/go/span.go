@@ -0,0 +1,160 @@
+package raceway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Span is a logical unit of work within a request, opened by StartSpan.
+// Events captured with the span's context are children of its start event.
+type Span struct {
+	client  *Client
+	name    string
+	eventID string
+	file    string
+	line    int
+	start   time.Time
+	parent  *RacewayContext
+	rctx    *RacewayContext
+	endOnce sync.Once
+}
+
+// disabledSpan is the span a disabled client's StartSpan returns. It has no
+// context, so End records nothing.
+var disabledSpan = &Span{}
+
+// StartSpan records a FunctionCall event for name with attrs and returns the
+// span along with a context whose events use that event as their parent. End
+// records the matching FunctionReturn with the span's duration; events
+// captured with ctx after End continue from ctx's own parent.
+//
+// Example:
+//
+//	span, spanCtx := client.StartSpan(ctx, "debit_account", map[string]interface{}{"account": from})
+//	defer span.End()
+func (c *Client) StartSpan(ctx context.Context, name string, attrs map[string]interface{}) (*Span, context.Context) {
+	if BuildDisabled || c.disabled {
+		return disabledSpan, ctx
+	}
+	file, line := c.captureFileLine(2)
+	return c.startSpan(ctx, name, attrs, file, line)
+}
+
+// startSpan is StartSpan for a span opened at file:line whose events, from
+// its start event on, carry links.
+func (c *Client) startSpan(ctx context.Context, name string, attrs map[string]interface{}, file string, line int, links ...Link) (*Span, context.Context) {
+	span := &Span{client: c, name: name, file: file, line: line}
+	parent := FromContext(ctx)
+	if parent == nil {
+		return span, ctx
+	}
+
+	child := parent.enterSpan()
+	for _, link := range links {
+		child.addLink(link)
+	}
+	spanCtx := context.WithValue(ctx, racewayContextKey, child)
+	span.eventID = c.captureEventWith(spanCtx, EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: name,
+			Module:       "span",
+			Args:         attrs,
+			File:         file,
+			Line:         line,
+		},
+	}, captureOptions{})
+	if span.eventID != "" {
+		child.mu.Lock()
+		child.spanParent = &span.eventID
+		child.mu.Unlock()
+	}
+	span.start = time.Now()
+	span.parent = parent
+	span.rctx = child
+	return span, spanCtx
+}
+
+// EventID returns the ID of the span's start event, or "" if none was captured.
+func (s *Span) EventID() string {
+	return s.eventID
+}
+
+// End records the span's return event. Calls after the first are ignored.
+// When End is deferred and the span's function panics, the panic is recorded
+// as an Error event inside the span, the span is ended, and the panic resumes.
+func (s *Span) End() {
+	v := recover()
+	if s.rctx != nil {
+		s.endOnce.Do(func() {
+			ctx := context.WithValue(context.Background(), racewayContextKey, s.rctx)
+			if v != nil {
+				s.client.trackPanic(ctx, v)
+			}
+			s.client.trackFunctionReturn(ctx, s.name, nil, s.file, s.line, time.Since(s.start))
+			s.parent.leaveSpan(s.rctx)
+		})
+	}
+	if v != nil {
+		panic(v)
+	}
+}
+
+// enterSpan returns a copy of r for events on the same thread that belong to
+// a span: it shares r's thread, trace-scoped state, and held locks at the
+// time of the call.
+func (r *RacewayContext) enterSpan() *RacewayContext {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &RacewayContext{
+		TraceID:      r.TraceID,
+		ThreadID:     r.ThreadID,
+		ParentID:     r.ParentID,
+		RootID:       r.RootID,
+		Clock:        r.Clock,
+		SpanID:       r.SpanID,
+		ParentSpanID: r.ParentSpanID,
+		Distributed:  r.Distributed,
+		ClockVector:  append([]CausalityEntry(nil), r.ClockVector...),
+		TraceState:   r.TraceState,
+		ServiceName:  r.ServiceName,
+		InstanceID:   r.InstanceID,
+		Region:       r.Region,
+		Baggage:      copyTags(r.Baggage),
+		Sampled:      r.Sampled,
+		tags:         copyTags(r.tags),
+		links:        copyLinks(r.links),
+		tx:           r.tx,
+		shared:       r.shared,
+		lifetime:     r.lifetime,
+		heldLocks:    r.heldLocks.copy(),
+		client:       r.client,
+		fences:       r.fences.copy(),
+
+		sampleDecided: r.sampleDecided,
+		spanParent:    r.spanParent,
+	}
+}
+
+// leaveSpan folds the progress made by a span's context back into r, so
+// events after the span are ordered after everything inside it.
+func (r *RacewayContext) leaveSpan(child *RacewayContext) {
+	child.mu.Lock()
+	vector, clock, rootID, distributed := child.ClockVector, child.Clock, child.RootID, child.Distributed
+	held := child.heldLocks.copy()
+	fences := child.fences.snapshot()
+	child.mu.Unlock()
+	r.mergeFences(fences)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ClockVector = MergeClockVectors(r.ClockVector, vector)
+	if clock > r.Clock {
+		r.Clock = clock
+	}
+	if r.RootID == nil {
+		r.RootID = rootID
+	}
+	r.Distributed = r.Distributed || distributed
+	r.heldLocks = held
+}
@@ -0,0 +1,80 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSpanParentsEventsAndRestoresContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackFunctionCall(ctx, "transfer", "app", nil, "span_test.go", 1)
+	span, spanCtx := c.StartSpan(ctx, "validate_transfer", map[string]interface{}{"amount": 100})
+	c.TrackStateChange(spanCtx, "from.balance", nil, 500, "span_test.go:2", "Read")
+	c.TrackStateChange(spanCtx, "to.balance", nil, 20, "span_test.go:3", "Read")
+	inner, innerCtx := c.StartSpan(spanCtx, "check_limits", nil)
+	c.TrackStateChange(innerCtx, "limit", nil, 1000, "span_test.go:4", "Read")
+	inner.End()
+	span.End()
+	span.End()
+	c.TrackStateChange(ctx, "from.balance", 500, 400, "span_test.go:5", "Write")
+
+	events := bufferedEvents(c)
+	if len(events) != 9 {
+		t.Fatalf("expected 9 events, got %d", len(events))
+	}
+	root, start, read1, read2, innerStart, limit, innerEnd, end, write :=
+		events[0], events[1], events[2], events[3], events[4], events[5], events[6], events[7], events[8]
+
+	if start.ID != span.EventID() || start.Kind.FunctionCall == nil || start.Kind.FunctionCall.FunctionName != "validate_transfer" {
+		t.Fatalf("unexpected span start event %+v", start.Kind)
+	}
+	parentOf := func(e Event) string {
+		if e.ParentID == nil {
+			return ""
+		}
+		return *e.ParentID
+	}
+	checks := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"span start", start, root.ID},
+		{"first read", read1, start.ID},
+		{"second read", read2, start.ID},
+		{"nested span start", innerStart, start.ID},
+		{"nested read", limit, innerStart.ID},
+		{"nested span end", innerEnd, innerStart.ID},
+		{"span end", end, start.ID},
+		{"write after End", write, root.ID},
+	}
+	for _, check := range checks {
+		if got := parentOf(check.event); got != check.want {
+			t.Errorf("%s parent = %s, want %s", check.name, got, check.want)
+		}
+	}
+
+	if end.Kind.FunctionReturn == nil || end.Metadata.DurationNs == nil {
+		t.Errorf("expected span end to be a FunctionReturn with a duration, got %+v", end)
+	}
+	if end.Metadata.ThreadID != root.Metadata.ThreadID {
+		t.Errorf("expected span events on the request's thread")
+	}
+	// Events after the span are causally after everything inside it
+	if write.CausalityVector[0].Value() <= end.CausalityVector[0].Value() {
+		t.Errorf("expected the clock to advance past the span, got %v after %v", write.CausalityVector, end.CausalityVector)
+	}
+}
+
+func TestSpanWithoutContextIsNoop(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	span, ctx := c.StartSpan(context.Background(), "orphan", nil)
+	span.End()
+	if FromContext(ctx) != nil || span.EventID() != "" || len(bufferedEvents(c)) != 0 {
+		t.Errorf("expected a no-op span outside of a Raceway context")
+	}
+}
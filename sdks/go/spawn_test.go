@@ -0,0 +1,208 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// dominates reports whether every component of b is <= the same component in a.
+func dominates(a, b []CausalityEntry) bool {
+	for _, entry := range b {
+		if clockValue(a, entry.Component()) < entry.Value() {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGoRunsOnNewVirtualThread(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	parent := FromContext(ctx)
+
+	done := make(chan struct{})
+	taskID := c.Go(ctx, "send_receipt", func(ctx context.Context) {
+		defer close(done)
+		c.TrackStateChange(ctx, "receipts", 0, 1, "spawn_test.go:1", "Write")
+	})
+	<-done
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected spawn and child events, got %d", len(events))
+	}
+	spawn, child := events[0], events[1]
+	if spawn.Kind.AsyncSpawn == nil || spawn.Kind.AsyncSpawn.TaskID != taskID || spawn.Kind.AsyncSpawn.TaskName != "send_receipt" {
+		t.Fatalf("unexpected spawn event %+v", spawn.Kind)
+	}
+	if !strings.HasPrefix(spawn.Kind.AsyncSpawn.SpawnedAt, "spawn_test.go:") {
+		t.Errorf("expected spawn location in caller, got %q", spawn.Kind.AsyncSpawn.SpawnedAt)
+	}
+	if spawn.Metadata.ThreadID != parent.ThreadID {
+		t.Errorf("spawn event must be on the parent thread")
+	}
+	if child.Metadata.ThreadID == parent.ThreadID || child.TraceID != parent.TraceID {
+		t.Errorf("child must be a new thread in the same trace")
+	}
+	if child.ParentID == nil || *child.ParentID != spawn.ID {
+		t.Errorf("child event should be parented to the spawn event")
+	}
+	if !dominates(child.CausalityVector, spawn.CausalityVector) {
+		t.Errorf("child vector %v does not dominate spawn vector %v", child.CausalityVector, spawn.CausalityVector)
+	}
+}
+
+func TestGoNestedSpawns(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	c.Go(ctx, "outer", func(ctx context.Context) {
+		defer wg.Done()
+		c.Go(ctx, "inner", func(ctx context.Context) {
+			defer wg.Done()
+			c.TrackStateChange(ctx, "inner_state", 0, 1, "spawn_test.go:2", "Write")
+		})
+	})
+	wg.Wait()
+
+	var spawns []Event
+	var inner Event
+	for _, e := range bufferedEvents(c) {
+		if e.Kind.AsyncSpawn != nil {
+			spawns = append(spawns, e)
+		}
+		if e.Kind.StateChange != nil {
+			inner = e
+		}
+	}
+	if len(spawns) != 2 {
+		t.Fatalf("expected two spawn events, got %d", len(spawns))
+	}
+	threads := map[string]bool{spawns[0].Metadata.ThreadID: true, spawns[1].Metadata.ThreadID: true, inner.Metadata.ThreadID: true}
+	if len(threads) != 3 {
+		t.Errorf("expected parent, outer, and inner on distinct threads")
+	}
+	for _, spawn := range spawns {
+		if !dominates(inner.CausalityVector, spawn.CausalityVector) {
+			t.Errorf("inner vector %v does not dominate spawn vector %v", inner.CausalityVector, spawn.CausalityVector)
+		}
+	}
+}
+
+func TestGoWithIsolatePolicy(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	done := make(chan struct{})
+	c.GoWithPolicy(ctx, "report", InheritIsolated, func(ctx context.Context) {
+		defer close(done)
+		c.TrackStateChange(ctx, "report", 0, 1, "spawn_test.go:3", "Write")
+	})
+	<-done
+
+	events := bufferedEvents(c)
+	if events[1].TraceID == events[0].TraceID || events[1].Metadata.Tags["link_relation"] != "spawned_by" {
+		t.Errorf("expected isolated child trace linked to the spawner, got %+v", events[1].Metadata)
+	}
+}
+
+func TestJoinOrdersParentAfterChild(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var wg sync.WaitGroup
+	var childCtx context.Context
+	wg.Add(1)
+	c.Go(ctx, "warm_cache", func(ctx context.Context) {
+		defer wg.Done()
+		childCtx = ctx
+		for i := 0; i < 3; i++ {
+			c.TrackStateChange(ctx, "cache", i, i+1, "spawn_test.go:1", "Write")
+		}
+	})
+	wg.Wait()
+	c.Join(ctx, childCtx)
+	c.TrackStateChange(ctx, "cache", nil, 3, "spawn_test.go:2", "Read")
+
+	events := bufferedEvents(c)
+	childLast, read := events[len(events)-2], events[len(events)-1]
+	if !dominates(read.CausalityVector, childLast.CausalityVector) || clockValue(read.CausalityVector, "test-service#test-instance") <= clockValue(childLast.CausalityVector, "test-service#test-instance") {
+		t.Errorf("expected the read %v to be ordered after the child's write %v", read.CausalityVector, childLast.CausalityVector)
+	}
+}
+
+func TestTrackAsyncJoinOrdersParentAfterChild(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var wg sync.WaitGroup
+	var childCtx context.Context
+	wg.Add(1)
+	taskID := c.Go(ctx, "load_prices", func(ctx context.Context) {
+		defer wg.Done()
+		childCtx = ctx
+		c.TrackStateChange(ctx, "prices", nil, 10, "spawn_test.go:1", "Write")
+		c.TrackStateChange(ctx, "prices", 10, 12, "spawn_test.go:2", "Write")
+	})
+	wg.Wait()
+	c.TrackAsyncJoin(ctx, taskID, childCtx)
+	c.TrackStateChange(ctx, "prices", nil, 12, "spawn_test.go:3", "Read")
+
+	events := bufferedEvents(c)
+	if len(events) != 5 {
+		t.Fatalf("expected spawn, two child writes, join and read, got %d events", len(events))
+	}
+	childLast, join, read := events[2], events[3], events[4]
+	data := join.Kind.AsyncJoin
+	if data == nil || data.TaskID != taskID || !strings.HasPrefix(data.JoinedAt, "spawn_test.go:") {
+		t.Fatalf("unexpected join event %+v", join.Kind)
+	}
+	if !dominates(data.ChildClock, childLast.CausalityVector) || !dominates(childLast.CausalityVector, data.ChildClock) {
+		t.Errorf("expected the child's final clock %v, got %v", childLast.CausalityVector, data.ChildClock)
+	}
+	if join.Metadata.ThreadID != FromContext(ctx).ThreadID {
+		t.Errorf("join event must be on the parent thread")
+	}
+	component := "test-service#test-instance"
+	for _, event := range []Event{join, read} {
+		if !dominates(event.CausalityVector, childLast.CausalityVector) || clockValue(event.CausalityVector, component) <= clockValue(childLast.CausalityVector, component) {
+			t.Errorf("expected %s %v to be ordered after the child's last write %v", event.Kind.Name(), event.CausalityVector, childLast.CausalityVector)
+		}
+	}
+}
+
+func TestTrackAsyncJoinAtRecordsGivenLocation(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	childCtx := DeriveThread(ctx)
+	c.TrackStateChange(childCtx, "prices", nil, 10, "spawn_test.go:1", "Write")
+	c.TrackAsyncJoinAt(ctx, "task-1", childCtx, "prices.go:12")
+
+	events := bufferedEvents(c)
+	join := events[1].Kind.AsyncJoin
+	if join == nil || join.TaskID != "task-1" || join.JoinedAt != "prices.go:12" {
+		t.Fatalf("unexpected join event %+v", events[1].Kind)
+	}
+	if !dominates(events[1].CausalityVector, events[0].CausalityVector) {
+		t.Errorf("expected the join ordered after the child's write")
+	}
+}
+
+func TestAsyncJoinDowngradedWithoutCapability(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ForceCapabilities = nil })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackAsyncJoin(ctx, "task-1", context.Background())
+
+	events := bufferedEvents(c)
+	c.downgradeEvents(events)
+	await := events[0].Kind.AsyncAwait
+	if events[0].Kind.AsyncJoin != nil || await == nil || await.FutureID != "task-1" || await.AwaitedAt == "" {
+		t.Errorf("expected an AsyncAwait on the task, got %+v", events[0].Kind)
+	}
+}
@@ -0,0 +1,129 @@
+package raceway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// spillLockWait is how long spilling or recovering waits for another
+	// process to release the spill file's lock.
+	spillLockWait = time.Second
+	// spillLockStale is the age at which a lock file is taken to be left
+	// behind by a process killed while holding it.
+	spillLockStale = 30 * time.Second
+)
+
+// lockSpillFile takes the lock guarding path against other processes by
+// creating path.lock exclusively, and returns the function releasing it.
+func lockSpillFile(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(spillLockWait)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("raceway: locking spill file: %w", err)
+		}
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > spillLockStale {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("raceway: spill file %s is locked by another process", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// spill appends the buffered events to Config.SpillPath, where the next
+// client created with the same SpillPath picks them up. It returns the number
+// of events written; events that could not be written stay buffered.
+func (c *Client) spill() (int, error) {
+	unlock, err := lockSpillFile(c.config.SpillPath)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(c.config.SpillPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("raceway: spilling events: %w", err)
+	}
+	n, err := c.DrainTo(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("raceway: spilling events: %w", err)
+	}
+	c.logger.Infof("Spilled %d undelivered events to %s", n, c.config.SpillPath)
+	return n, nil
+}
+
+// recoverSpill buffers the events spilled to Config.SpillPath by an earlier
+// client for redelivery and deletes the file. Lines that are not complete
+// events, such as one cut short by a crash, are skipped.
+func (c *Client) recoverSpill() {
+	unlock, err := lockSpillFile(c.config.SpillPath)
+	if err != nil {
+		c.logger.Warnf("Not recovering spilled events: %v", err)
+		return
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(c.config.SpillPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		c.logger.Warnf("Not recovering spilled events: %v", err)
+		return
+	}
+
+	var events []Event
+	skipped := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil || event.ID == "" || event.Kind.Name() == "" {
+			skipped++
+			continue
+		}
+		// The events may have reached the server before the flush that
+		// spilled them gave up, so they are resent as a replay
+		event.encoded = append([]byte(nil), line...)
+		event.attempted = true
+		events = append(events, event)
+	}
+	if err := os.Remove(c.config.SpillPath); err != nil {
+		c.logger.Warnf("Not recovering spilled events: %v", err)
+		return
+	}
+	if skipped > 0 {
+		c.logger.Warnf("Skipped %d unreadable lines in %s", skipped, c.config.SpillPath)
+	}
+	if len(events) > 0 {
+		c.requeue(events)
+		c.logger.Infof("Recovered %d spilled events from %s", len(events), c.config.SpillPath)
+	}
+}
@@ -0,0 +1,189 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventIDRecorder is a Raceway server recording the IDs of the events posted
+// to it and whether each post was flagged as a replay.
+type eventIDRecorder struct {
+	mu       sync.Mutex
+	ids      []string
+	replayed []bool
+}
+
+func (r *eventIDRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/events" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var body struct {
+		Events []Event         `json:"events"`
+		Client *clientEnvelope `json:"client"`
+	}
+	json.NewDecoder(req.Body).Decode(&body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range body.Events {
+		r.ids = append(r.ids, event.ID)
+	}
+	r.replayed = append(r.replayed, body.Client != nil && body.Client.Replayed)
+}
+
+func TestShutdownSpillsEventsAndNextClientResendsThem(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hanging.Close()
+	defer close(release)
+	spillPath := filepath.Join(t.TempDir(), "raceway-spill.ndjson")
+
+	drops := make(dropRecorder, 8)
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = hanging.URL
+		cfg.MaxRetries = 0
+		cfg.ShutdownTimeout = 100 * time.Millisecond
+		cfg.SpillPath = spillPath
+		cfg.OnEventsDropped = drops.hook
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackCustom(ctx, "debit", nil)
+	c.TrackCustom(ctx, "credit", nil)
+	var want []string
+	for _, event := range bufferedEvents(c) {
+		want = append(want, event.ID)
+	}
+
+	var flushErr *FlushError
+	if err := c.ShutdownContext(context.Background()); !errors.As(err, &flushErr) || flushErr.Spilled != 2 || flushErr.Dropped != 0 || flushErr.Requeued != 0 {
+		t.Fatalf("expected both events spilled, got %v", err)
+	}
+	if len(drops) != 0 {
+		t.Error("expected spilled events not to be reported as dropped")
+	}
+
+	data, err := os.ReadFile(spillPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 spilled events, got %q", data)
+	}
+	for i, line := range lines {
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil || event.ID != want[i] || event.Kind.Custom == nil {
+			t.Errorf("unexpected spilled line %s (%v)", line, err)
+		}
+	}
+
+	recorder := &eventIDRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	next := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 0
+		cfg.ShutdownTimeout = 100 * time.Millisecond
+		cfg.SpillPath = spillPath
+	})
+	defer next.Shutdown()
+	if _, err := os.Stat(spillPath); !os.IsNotExist(err) {
+		t.Errorf("expected the spill file to be deleted, got %v", err)
+	}
+	if err := next.FlushContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.ids) != 2 || recorder.ids[0] != want[0] || recorder.ids[1] != want[1] {
+		t.Errorf("expected the spilled events %v to be resent, got %v", want, recorder.ids)
+	}
+	if len(recorder.replayed) != 1 || !recorder.replayed[0] {
+		t.Errorf("expected the resend to be flagged as a replay, got %v", recorder.replayed)
+	}
+}
+
+func TestRecoverSpillSkipsCorruptLines(t *testing.T) {
+	spillPath := filepath.Join(t.TempDir(), "raceway-spill.ndjson")
+	valid, err := json.Marshal(Event{ID: "5f0c2a4e-8d1b-4c3a-9e7f-1a2b3c4d5e6f", Kind: EventKind{Custom: &CustomData{Type: "step"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := string(valid) + "\n" +
+		"not json\n" +
+		"\n" +
+		`{"id":"","kind":{}}` + "\n" +
+		string(valid[:len(valid)/2])
+	if err := os.WriteFile(spillPath, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &capturingLogger{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.SpillPath = spillPath
+		cfg.Logger = logger
+	})
+
+	if stats := c.Stats(); stats.EventsBuffered != 1 {
+		t.Errorf("expected the one complete event to be recovered, got %+v", stats)
+	}
+	if warnings := logger.logged("warn"); len(warnings) != 1 {
+		t.Errorf("expected one warning about the skipped lines, got %q", warnings)
+	}
+	if _, err := os.Stat(spillPath); !os.IsNotExist(err) {
+		t.Errorf("expected the spill file to be deleted, got %v", err)
+	}
+}
+
+func TestRecoverSpillHonorsLock(t *testing.T) {
+	spillPath := filepath.Join(t.TempDir(), "raceway-spill.ndjson")
+	valid, _ := json.Marshal(Event{ID: "5f0c2a4e-8d1b-4c3a-9e7f-1a2b3c4d5e6f", Kind: EventKind{Custom: &CustomData{Type: "step"}}})
+	if err := os.WriteFile(spillPath, append(valid, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(spillPath+".lock", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newBufferingClient(t, func(cfg *Config) { cfg.SpillPath = spillPath; cfg.Logger = &capturingLogger{} })
+	if stats := c.Stats(); stats.EventsBuffered != 0 {
+		t.Errorf("expected a locked spill file to be left alone, got %+v", stats)
+	}
+	if _, err := os.Stat(spillPath); err != nil {
+		t.Errorf("expected the spill file to remain, got %v", err)
+	}
+
+	// A lock left behind by a killed process expires
+	stale := time.Now().Add(-2 * spillLockStale)
+	if err := os.Chtimes(spillPath+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	c = newBufferingClient(t, func(cfg *Config) { cfg.SpillPath = spillPath })
+	if stats := c.Stats(); stats.EventsBuffered != 1 {
+		t.Errorf("expected the event to be recovered past a stale lock, got %+v", stats)
+	}
+	if _, err := os.Stat(spillPath + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+}
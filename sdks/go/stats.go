@@ -0,0 +1,84 @@
+package raceway
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ClientStats is a snapshot of the client's delivery counters.
+type ClientStats struct {
+	// EventsBuffered is the number of events waiting for the next flush,
+	// including queued and requeued ones
+	EventsBuffered int
+	// EventsRequeued is the number of buffered events held over from failed flushes
+	EventsRequeued int
+	// EventsSent is the total number of events accepted by their sinks
+	EventsSent uint64
+	// EventsDropped is the total number of events discarded after permanent
+	// send failures, rejected by the server, or because MaxBufferedEvents was
+	// reached
+	EventsDropped uint64
+	// EventsRejected is the total number of events the server refused in
+	// batches it otherwise accepted; they are counted in EventsSent too
+	EventsRejected uint64
+	// FlushCount is the number of flushes that had events to send
+	FlushCount uint64
+	// LastFlushDuration is how long the most recent flush took, retries included
+	LastFlushDuration time.Duration
+	// LastError is the error of the most recent failed flush, or empty
+	LastError string
+	// LastServerResponse summarizes the server's most recent response to a
+	// batch, or is zero if there was none
+	LastServerResponse ServerResponse
+	// Capabilities lists the capabilities currently emitted, negotiated with
+	// the collector or forced by Config.ForceCapabilities, in sorted order
+	Capabilities []Capability
+}
+
+// clientStats holds the counters behind ClientStats. They are updated
+// atomically so Stats never waits on the event buffer lock.
+type clientStats struct {
+	// buffered counts events captured and not yet taken by a flush, whether
+	// queued for the writer goroutine, buffered, or requeued
+	buffered      atomic.Int64
+	requeued      atomic.Int64
+	sent          atomic.Uint64
+	dropped       atomic.Uint64
+	flushes       atomic.Uint64
+	flushDuration atomic.Int64
+	lastError     atomic.Value // string
+	rejected      atomic.Uint64
+	lastResponse  atomic.Value // ServerResponse
+}
+
+// recordFlush updates the flush counters and invokes Config.OnStats.
+func (c *Client) recordFlush(sent int, elapsed time.Duration, err error) {
+	c.stats.sent.Add(uint64(sent))
+	c.stats.flushes.Add(1)
+	c.stats.flushDuration.Store(int64(elapsed))
+	if err != nil {
+		c.stats.lastError.Store(err.Error())
+	}
+	if c.config.OnStats != nil {
+		c.config.OnStats(c.Stats())
+	}
+}
+
+// Stats returns a snapshot of the client's delivery counters. It is safe to
+// call from health or metrics handlers while events are being captured.
+func (c *Client) Stats() ClientStats {
+	lastError, _ := c.stats.lastError.Load().(string)
+	lastResponse, _ := c.stats.lastResponse.Load().(ServerResponse)
+	return ClientStats{
+		EventsBuffered:     int(c.stats.buffered.Load()),
+		EventsRequeued:     int(c.stats.requeued.Load()),
+		EventsSent:         c.stats.sent.Load(),
+		EventsDropped:      c.stats.dropped.Load(),
+		FlushCount:         c.stats.flushes.Load(),
+		LastFlushDuration:  time.Duration(c.stats.flushDuration.Load()),
+		LastError:          lastError,
+		EventsRejected:     c.stats.rejected.Load(),
+		LastServerResponse: lastResponse,
+		Capabilities:       c.Capabilities().List(),
+	}
+}
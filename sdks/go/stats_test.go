@@ -0,0 +1,71 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStatsCountSentAndDroppedEvents(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var snapshots []ClientStats
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServerURL = server.URL
+		cfg.MaxRetries = 0
+		cfg.OnStats = func(s ClientStats) {
+			mu.Lock()
+			snapshots = append(snapshots, s)
+			mu.Unlock()
+		}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	for i := 0; i < 3; i++ {
+		c.TrackStateChange(ctx, "counter", i, i+1, "stats_test.go:1", "Write")
+	}
+	if stats := c.Stats(); stats.EventsBuffered != 3 || stats.FlushCount != 0 {
+		t.Fatalf("expected 3 buffered events before flushing, got %+v", stats)
+	}
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	stats := c.Stats()
+	if stats.EventsBuffered != 0 || stats.EventsSent != 3 || stats.FlushCount != 1 || stats.LastError != "" {
+		t.Errorf("unexpected stats after a successful flush: %+v", stats)
+	}
+	if stats.LastFlushDuration <= 0 {
+		t.Errorf("expected the flush duration to be recorded")
+	}
+
+	status.Store(http.StatusBadRequest)
+	c.TrackStateChange(ctx, "counter", 3, 4, "stats_test.go:1", "Write")
+	if err := c.FlushContext(context.Background()); err == nil {
+		t.Fatal("expected the rejected flush to fail")
+	}
+	// Empty flushes are not counted
+	c.FlushContext(context.Background())
+
+	stats = c.Stats()
+	if stats.EventsSent != 3 || stats.EventsDropped != 1 || stats.FlushCount != 2 || stats.LastError == "" {
+		t.Errorf("unexpected stats after a rejected flush: %+v", stats)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(snapshots) != 2 || snapshots[0].EventsSent != 3 || !reflect.DeepEqual(snapshots[1], stats) {
+		t.Errorf("expected OnStats after each flush, got %+v", snapshots)
+	}
+}
@@ -0,0 +1,101 @@
+package raceway
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// StrictClass identifies a kind of SDK misuse reported in strict mode.
+type StrictClass string
+
+const (
+	// StrictMissingContext is a Track* or PropagationHeaders call without a RacewayContext.
+	StrictMissingContext StrictClass = "missing_context"
+	// StrictSerialization is a tracked value that cannot be encoded as JSON.
+	StrictSerialization StrictClass = "serialization"
+	// StrictUnbalancedLock is a lock release with no matching acquire in the same context.
+	StrictUnbalancedLock StrictClass = "unbalanced_lock"
+)
+
+// StrictViolation describes one strict-mode failure and the call site that caused it.
+type StrictViolation struct {
+	Class   StrictClass
+	Message string
+	File    string
+	Line    int
+
+	// logger is the Logger of the client that found the violation
+	logger Logger
+}
+
+func (v StrictViolation) String() string {
+	return fmt.Sprintf("raceway strict mode: %s: %s (at %s:%d)", v.Class, v.Message, v.File, v.Line)
+}
+
+// StrictHandler is invoked for each strict-mode violation.
+type StrictHandler func(StrictViolation)
+
+// PanicStrictHandler panics with a description of the violation. It is the default.
+func PanicStrictHandler(v StrictViolation) {
+	panic(v.String())
+}
+
+// LogStrictHandler logs the violation as an error through the client's
+// Config.Logger instead of panicking.
+func LogStrictHandler(v StrictViolation) {
+	logger := v.logger
+	if logger == nil {
+		logger = stdoutLogger{}
+	}
+	logger.Errorf("%s", v)
+}
+
+// sdkDir is the directory of this package's sources, used to find the caller's frame.
+var sdkDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// strictViolation reports a violation to the configured handler. Callers check
+// c.config.Strict first so the disabled path costs a single branch.
+func (c *Client) strictViolation(class StrictClass, format string, args ...interface{}) {
+	violation := StrictViolation{Class: class, Message: fmt.Sprintf(format, args...), logger: c.logger}
+	file, line := strictCallSite()
+	violation.File, violation.Line = c.paths.trim(file), line
+
+	if c.config.StrictDowngrade[class] {
+		c.logger.Errorf("%s", violation)
+		return
+	}
+	handler := c.config.StrictHandler
+	if handler == nil {
+		handler = PanicStrictHandler
+	}
+	handler(violation)
+}
+
+// strictCallSite returns the first frame outside the SDK's own non-test sources.
+func strictCallSite() (string, int) {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != sdkDir || strings.HasSuffix(frame.File, "_test.go") {
+			return frame.File, frame.Line
+		}
+		if !more {
+			return "unknown", 0
+		}
+	}
+}
+
+// strictCheckEvent validates an event about to be captured. Unbalanced lock
+// releases are reported by trackLocks.
+func (c *Client) strictCheckEvent(kind EventKind) {
+	if _, err := json.Marshal(kind); err != nil {
+		c.strictViolation(StrictSerialization, "%s event cannot be serialized: %v", kind.Name(), err)
+	}
+}
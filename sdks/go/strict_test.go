@@ -0,0 +1,142 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrictReportsMissingContext(t *testing.T) {
+	var violations []StrictViolation
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Strict = true
+		cfg.StrictHandler = func(v StrictViolation) { violations = append(violations, v) }
+	})
+
+	c.TrackStateChange(context.Background(), "counter", 0, 1, "strict_test.go:1", "Write")
+	if _, err := c.PropagationHeaders(context.Background(), nil); err == nil {
+		t.Fatal("expected error outside of context")
+	}
+
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, got %d: %v", len(violations), violations)
+	}
+	for _, v := range violations {
+		if v.Class != StrictMissingContext {
+			t.Errorf("unexpected class %s", v.Class)
+		}
+		if v.File != "strict_test.go" {
+			t.Errorf("expected call site in strict_test.go, got %s:%d", v.File, v.Line)
+		}
+	}
+}
+
+func TestStrictReportsSerializationFailures(t *testing.T) {
+	var violations []StrictViolation
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Strict = true
+		cfg.StrictHandler = func(v StrictViolation) { violations = append(violations, v) }
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "queue", nil, make(chan int), "strict_test.go:1", "Write")
+
+	if len(violations) != 1 || violations[0].Class != StrictSerialization {
+		t.Fatalf("expected serialization violation, got %v", violations)
+	}
+}
+
+func TestStrictReportsUnbalancedLocks(t *testing.T) {
+	var violations []StrictViolation
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Strict = true
+		cfg.StrictHandler = func(v StrictViolation) { violations = append(violations, v) }
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackLockAcquire(ctx, "mu", "Mutex")
+	c.TrackLockRelease(ctx, "mu", "Mutex")
+	if len(violations) != 0 {
+		t.Fatalf("balanced locks reported violations: %v", violations)
+	}
+
+	c.TrackLockRelease(ctx, "mu", "Mutex")
+	if len(violations) != 1 || violations[0].Class != StrictUnbalancedLock {
+		t.Fatalf("expected unbalanced lock violation, got %v", violations)
+	}
+}
+
+func TestStrictDefaultHandlerPanics(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Strict = true })
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), string(StrictMissingContext)) {
+			t.Fatalf("expected strict panic, got %v", r)
+		}
+	}()
+	c.TrackStateChange(context.Background(), "counter", 0, 1, "strict_test.go:1", "Write")
+}
+
+func TestStrictDowngradedClassIsLogged(t *testing.T) {
+	var violations []StrictViolation
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Strict = true
+		cfg.StrictHandler = func(v StrictViolation) { violations = append(violations, v) }
+	})
+	c.config.StrictDowngrade = map[StrictClass]bool{StrictMissingContext: true}
+
+	c.TrackStateChange(context.Background(), "counter", 0, 1, "strict_test.go:1", "Write")
+
+	if len(violations) != 0 {
+		t.Fatalf("downgraded class reached handler: %v", violations)
+	}
+}
+
+func TestLogStrictHandlerUsesClientLogger(t *testing.T) {
+	logger := &capturingLogger{}
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Strict = true
+		cfg.StrictHandler = LogStrictHandler
+		cfg.Logger = logger
+	})
+
+	c.TrackStateChange(context.Background(), "counter", 0, 1, "strict_test.go:1", "Write")
+	if errors := logger.logged("error"); len(errors) != 1 || !strings.Contains(errors[0], string(StrictMissingContext)) {
+		t.Errorf("expected the violation logged as an error, got %q", errors)
+	}
+}
+
+func TestStrictDisabledDoesNotAllocate(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := context.Background()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.captureEvent(ctx, EventKind{})
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations on disabled strict path, got %v", allocs)
+	}
+}
+
+func BenchmarkCaptureWithoutContext(b *testing.B) {
+	for _, strict := range []bool{false, true} {
+		name := "disabled"
+		if strict {
+			name = "enabled"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := New(Config{ServiceName: "bench", BatchSize: 100, FlushInterval: time.Hour, Strict: strict,
+				StrictHandler: func(StrictViolation) {}})
+			defer c.Shutdown()
+			ctx := context.Background()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.captureEvent(ctx, EventKind{})
+			}
+		})
+	}
+}
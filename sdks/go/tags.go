@@ -0,0 +1,70 @@
+package raceway
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	// MaxTagKeyLength is the longest dynamic tag key, in bytes; longer keys
+	// are truncated.
+	MaxTagKeyLength = 64
+	// MaxTagValueLength is the longest dynamic tag value, in bytes; longer
+	// values are truncated.
+	MaxTagValueLength = 256
+)
+
+// WithTags attaches tags, such as a user ID or tenant, to every event
+// captured afterwards with the Raceway context of ctx, merged with the tags
+// it already carries. Contexts derived from it, for example by Client.Go or
+// Detach, inherit them. Keys and values are truncated to MaxTagKeyLength and
+// MaxTagValueLength. It returns ctx, which is unchanged outside of a Raceway
+// context.
+//
+// Context tags take precedence over Config.TagProvider and Config.Tags.
+//
+// Example:
+//
+//	ctx = raceway.WithTags(r.Context(), map[string]string{"tenant": tenantID})
+func WithTags(ctx context.Context, tags map[string]string) context.Context {
+	rctx := FromContext(ctx)
+	if rctx == nil || len(tags) == 0 {
+		return ctx
+	}
+	for k, v := range tags {
+		rctx.setTag(limitTag(k, v))
+	}
+	return ctx
+}
+
+// providedTags returns the tags of Config.TagProvider for ctx, truncated like
+// those of WithTags.
+func (c *Client) providedTags(ctx context.Context) map[string]string {
+	if c.config.TagProvider == nil || ctx == nil {
+		return nil
+	}
+	provided := c.config.TagProvider(ctx)
+	if len(provided) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(provided))
+	for k, v := range provided {
+		k, v = limitTag(k, v)
+		tags[k] = v
+	}
+	return tags
+}
+
+// limitTag truncates key and value to MaxTagKeyLength and MaxTagValueLength.
+func limitTag(key, value string) (string, string) {
+	return truncateString(key, MaxTagKeyLength), truncateString(value, MaxTagValueLength)
+}
+
+// truncateString shortens s to at most n bytes without splitting a UTF-8
+// sequence.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
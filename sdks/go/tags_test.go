@@ -0,0 +1,107 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type tenantKey struct{}
+
+func TestTagPrecedence(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Tags = map[string]string{"tenant": "static", "deploy": "blue", "team": "payments"}
+		cfg.TagProvider = func(ctx context.Context) map[string]string {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			return map[string]string{"tenant": tenant, "deploy": "green"}
+		}
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	ctx = context.WithValue(ctx, tenantKey{}, "provided")
+	c.TrackStateChange(ctx, "balance", nil, 1, "tags_test.go:1", "Write")
+
+	ctx = WithTags(ctx, map[string]string{"tenant": "acme", "user_id": "u1"})
+	ctx = WithTags(ctx, map[string]string{"user_id": "u2"})
+	c.TrackStateChange(ctx, "balance", 1, 2, "tags_test.go:2", "Write")
+
+	events := bufferedEvents(c)
+	tests := []struct {
+		tags map[string]string
+		want map[string]string
+	}{
+		{events[0].Metadata.Tags, map[string]string{"tenant": "provided", "deploy": "green", "team": "payments", "user_id": ""}},
+		{events[1].Metadata.Tags, map[string]string{"tenant": "acme", "deploy": "green", "team": "payments", "user_id": "u2"}},
+	}
+	for i, tt := range tests {
+		for k, v := range tt.want {
+			if tt.tags[k] != v {
+				t.Errorf("event %d: expected %s=%q, got %q", i, k, v, tt.tags[k])
+			}
+		}
+	}
+}
+
+func TestWithTagsLimitsLength(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	longKey := strings.Repeat("k", 100)
+	ctx = WithTags(ctx, map[string]string{
+		longKey: "v",
+		"note":  strings.Repeat("é", 200),
+	})
+	c.TrackStateChange(ctx, "balance", nil, 1, "tags_test.go:3", "Write")
+
+	tags := bufferedEvents(c)[0].Metadata.Tags
+	if tags[longKey[:MaxTagKeyLength]] != "v" {
+		t.Errorf("expected the key truncated to %d bytes, got %v", MaxTagKeyLength, tags)
+	}
+	if note := tags["note"]; len(note) != MaxTagValueLength || note != strings.Repeat("é", MaxTagValueLength/2) {
+		t.Errorf("expected the value truncated to %d bytes, got %d", MaxTagValueLength, len(note))
+	}
+
+	if WithTags(context.Background(), map[string]string{"a": "b"}) != context.Background() {
+		t.Error("expected ctx returned unchanged outside of a Raceway context")
+	}
+}
+
+func TestWithTagsOnDerivedContexts(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := WithTags(NewContext(context.Background(), "", "test-service", "test-instance"), map[string]string{"tenant": "acme"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Add(1)
+		c.Go(ctx, "worker", func(ctx context.Context) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				ctx = WithTags(ctx, map[string]string{"worker": strconv.Itoa(i), "step": strconv.Itoa(j)})
+				c.TrackStateChange(ctx, "items", nil, j, "tags_test.go:4", "Write")
+			}
+		})
+	}
+	wg.Wait()
+	WithTags(ctx, map[string]string{"phase": "done"})
+	c.TrackStateChange(ctx, "items", nil, 0, "tags_test.go:5", "Read")
+
+	for _, event := range bufferedEvents(c) {
+		if event.Kind.StateChange == nil {
+			continue
+		}
+		tags := event.Metadata.Tags
+		if tags["tenant"] != "acme" {
+			t.Errorf("expected the parent's tags inherited, got %v", tags)
+		}
+		if event.Kind.StateChange.AccessType == "Read" {
+			if tags["worker"] != "" || tags["phase"] != "done" {
+				t.Errorf("expected workers' tags kept off the parent, got %v", tags)
+			}
+		} else if tags["phase"] != "" || tags["worker"] == "" {
+			t.Errorf("expected each worker's own tags, got %v", tags)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package raceway
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// timerDelayTag records the delay of a timer started with AfterFunc or
+	// Tick on its AsyncSpawn event.
+	timerDelayTag = "timer_delay_ms"
+	// timerCanceledTag marks the AsyncAwait recorded when a timer is stopped
+	// before it fires, or a ticker is stopped; timerTicksTag holds how many
+	// times the ticker fired.
+	timerCanceledTag = "timer_canceled"
+	timerTicksTag    = "timer_ticks"
+)
+
+// Timer is the *time.Timer of a callback scheduled with AfterFunc. Its Stop
+// records the cancellation.
+type Timer struct {
+	*time.Timer
+	canceled func()
+}
+
+// Stop is time.Timer.Stop. If it stops the timer before it fires, an
+// AsyncAwait event tagged timer_canceled=true is recorded on the thread the
+// callback would have run on.
+func (t *Timer) Stop() bool {
+	if !t.Timer.Stop() {
+		return false
+	}
+	if t.canceled != nil {
+		t.canceled()
+	}
+	return true
+}
+
+// AfterFunc is time.AfterFunc for traced work. It records an AsyncSpawn
+// event tagged with the delay, and when the timer fires runs fn on a new
+// virtual thread whose first event, an AsyncAwait, is parented to the spawn
+// and carries the clock ctx had when AfterFunc was called. fn receives ctx's
+// values and cancellation; pass a context from Detach for callbacks that
+// outlive the request.
+//
+// Example:
+//
+//	timer := client.AfterFunc(ctx, 30*time.Second, "expire_hold", func(ctx context.Context) {
+//	    client.TrackStateChange(ctx, "hold", "active", "expired", "", "Write")
+//	})
+//	defer timer.Stop()
+func (c *Client) AfterFunc(ctx context.Context, d time.Duration, name string, fn func(ctx context.Context)) *Timer {
+	if BuildDisabled || c.disabled || FromContext(ctx) == nil {
+		return &Timer{Timer: time.AfterFunc(d, func() { fn(ctx) })}
+	}
+	task := c.scheduleTimer(ctx, d, name, c.captureLocation(2))
+	return &Timer{
+		Timer: time.AfterFunc(d, func() { task.run(task.thread(), fn) }),
+		canceled: func() {
+			task.await(task.thread(), map[string]string{timerCanceledTag: "true"})
+		},
+	}
+}
+
+// Ticker runs a callback scheduled with Tick until stopped.
+type Ticker struct {
+	stop func()
+}
+
+// Stop stops the ticker. A tick already running is not interrupted. Stop
+// records an AsyncAwait event tagged timer_canceled=true and with the number
+// of ticks in timer_ticks, ordered after the ticks started before it.
+func (t *Ticker) Stop() {
+	t.stop()
+}
+
+// Tick runs fn every d, like a time.Ticker, until the returned Ticker is
+// stopped. One AsyncSpawn event is recorded for the ticker. Each tick runs on
+// a new virtual thread and span whose AsyncAwait event is parented to the
+// spawn; ticks run one at a time, so each is ordered after the previous one.
+// Ticks are skipped while fn is still running, as with time.Ticker.
+func (c *Client) Tick(ctx context.Context, d time.Duration, name string, fn func(ctx context.Context)) *Ticker {
+	ticker := time.NewTicker(d)
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stopTicker := func() {
+		ticker.Stop()
+		close(done)
+	}
+
+	if BuildDisabled || c.disabled || FromContext(ctx) == nil {
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					fn(ctx)
+				case <-done:
+					return
+				}
+			}
+		}()
+		return &Ticker{stop: func() { stopOnce.Do(stopTicker) }}
+	}
+
+	task := c.scheduleTimer(ctx, d, name, c.captureLocation(2))
+	// last is the context of the latest tick, ticks how many have run, and
+	// stopped is set by Stop, after which no tick starts
+	var mu sync.Mutex
+	var last *RacewayContext
+	ticks := 0
+	stopped := false
+	// follow returns a context for the next tick, ordered after the last one.
+	// mu must be held.
+	follow := func() context.Context {
+		tickCtx := task.thread()
+		if last != nil {
+			FromContext(tickCtx).joinClock(last.clock())
+		}
+		last = FromContext(tickCtx)
+		return tickCtx
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				mu.Lock()
+				if stopped {
+					mu.Unlock()
+					return
+				}
+				ticks++
+				tickCtx := follow()
+				mu.Unlock()
+				task.run(tickCtx, fn)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return &Ticker{stop: func() {
+		stopOnce.Do(func() {
+			stopTicker()
+			mu.Lock()
+			stopped = true
+			n := ticks
+			stopCtx := follow()
+			mu.Unlock()
+			task.await(stopCtx, map[string]string{
+				timerCanceledTag: "true",
+				timerTicksTag:    strconv.Itoa(n),
+			})
+		})
+	}}
+}
+
+// timerTask is a callback scheduled with AfterFunc or Tick.
+type timerTask struct {
+	client   *Client
+	taskID   string
+	location string
+	// scheduled is the scheduling context as of the AsyncSpawn event
+	scheduled context.Context
+}
+
+// scheduleTimer records the AsyncSpawn of a timer firing after d.
+func (c *Client) scheduleTimer(ctx context.Context, d time.Duration, name, location string) *timerTask {
+	taskID := uuid.New().String()
+	c.captureEventWith(ctx, EventKind{
+		AsyncSpawn: &AsyncSpawnData{
+			TaskID:    taskID,
+			TaskName:  name,
+			SpawnedAt: location,
+		},
+	}, captureOptions{tags: map[string]string{timerDelayTag: strconv.FormatInt(d.Milliseconds(), 10)}})
+
+	// The snapshot records no events, so it stays at the spawn. It keeps the
+	// scheduling span so that callbacks are child spans of it.
+	snapshot := FromContext(ctx).derive()
+	snapshot.SpanID = *snapshot.ParentSpanID
+	return &timerTask{
+		client:    c,
+		taskID:    taskID,
+		location:  location,
+		scheduled: context.WithValue(ctx, racewayContextKey, snapshot),
+	}
+}
+
+// thread returns a context for one run of the task, on a new virtual thread
+// and span continuing from the AsyncSpawn event.
+func (t *timerTask) thread() context.Context {
+	return DeriveThread(t.scheduled)
+}
+
+// await records that ctx, the thread of one run of the task, picked it up.
+func (t *timerTask) await(ctx context.Context, tags map[string]string) {
+	t.client.captureEventWith(ctx, EventKind{
+		AsyncAwait: &AsyncAwaitData{
+			FutureID:  t.taskID,
+			AwaitedAt: t.location,
+		},
+	}, captureOptions{tags: tags})
+}
+
+// run records the AsyncAwait of one run of the task and calls fn with ctx.
+func (t *timerTask) run(ctx context.Context, fn func(context.Context)) {
+	t.await(ctx, nil)
+	t.client.runLabeled(ctx, func(ctx context.Context) {
+		// The panic will crash the process, so deliver its Error event first
+		defer t.client.rethrowPanicAndFlush(ctx)
+		fn(ctx)
+	})
+}
@@ -0,0 +1,163 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAfterFuncRunsOnThreadLinkedToSpawn(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	parent := FromContext(ctx)
+
+	fired := make(chan struct{})
+	c.AfterFunc(ctx, 5*time.Millisecond, "expire_hold", func(ctx context.Context) {
+		defer close(fired)
+		c.TrackStateChange(ctx, "hold", "active", "expired", "timers_test.go:1", "Write")
+	})
+	// Events captured before the timer fires are not ordered before it
+	c.TrackStateChange(ctx, "hold", nil, "active", "timers_test.go:2", "Write")
+	<-fired
+	c.syncPipeline(context.Background())
+
+	var spawn, await, write Event
+	for _, e := range bufferedEvents(c) {
+		switch {
+		case e.Kind.AsyncSpawn != nil:
+			spawn = e
+		case e.Kind.AsyncAwait != nil:
+			await = e
+		case e.Kind.StateChange != nil && e.Kind.StateChange.Location == "timers_test.go:1":
+			write = e
+		}
+	}
+	if spawn.Kind.AsyncSpawn == nil || spawn.Kind.AsyncSpawn.TaskName != "expire_hold" || spawn.Metadata.ThreadID != parent.ThreadID {
+		t.Fatalf("expected the spawn on the scheduling thread, got %+v", spawn)
+	}
+	if got := spawn.Metadata.Tags[timerDelayTag]; got != "5" {
+		t.Errorf("delay tag = %q, want 5", got)
+	}
+	if await.Kind.AsyncAwait == nil || await.Kind.AsyncAwait.FutureID != spawn.Kind.AsyncSpawn.TaskID {
+		t.Fatalf("expected an await of the spawned task, got %+v", await.Kind)
+	}
+	if await.ParentID == nil || *await.ParentID != spawn.ID {
+		t.Errorf("expected the await parented to the spawn")
+	}
+	if await.Metadata.ThreadID == parent.ThreadID || await.TraceID != parent.TraceID {
+		t.Errorf("expected the callback on a new thread of the same trace")
+	}
+	if !dominates(await.CausalityVector, spawn.CausalityVector) {
+		t.Errorf("await vector %v does not dominate spawn vector %v", await.CausalityVector, spawn.CausalityVector)
+	}
+	if clockValue(await.CausalityVector, parent.ThreadID) != clockValue(spawn.CausalityVector, parent.ThreadID) {
+		t.Errorf("expected the callback to carry the clock as of the spawn, got %v", await.CausalityVector)
+	}
+	if write.ParentID == nil || *write.ParentID != await.ID || write.Metadata.ThreadID != await.Metadata.ThreadID {
+		t.Errorf("expected the callback's events to follow the await")
+	}
+}
+
+func TestAfterFuncStoppedRecordsCancellation(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	timer := c.AfterFunc(ctx, time.Hour, "expire_hold", func(ctx context.Context) {
+		t.Error("stopped timer fired")
+	})
+	if !timer.Stop() {
+		t.Fatal("expected Stop to stop the timer")
+	}
+	if timer.Stop() {
+		t.Error("expected a second Stop to report the timer already stopped")
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 2 {
+		t.Fatalf("expected spawn and cancellation events, got %d", len(events))
+	}
+	canceled := events[1]
+	if canceled.Kind.AsyncAwait == nil || canceled.Metadata.Tags[timerCanceledTag] != "true" {
+		t.Fatalf("expected an await tagged canceled, got %+v", canceled)
+	}
+	if canceled.ParentID == nil || *canceled.ParentID != events[0].ID {
+		t.Errorf("expected the cancellation parented to the spawn")
+	}
+}
+
+func TestAfterFuncWithoutContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	fired := make(chan struct{})
+	c.AfterFunc(context.Background(), time.Millisecond, "untraced", func(ctx context.Context) {
+		close(fired)
+	})
+	<-fired
+	if events := bufferedEvents(c); len(events) != 0 {
+		t.Errorf("expected no events without a Raceway context, got %d", len(events))
+	}
+}
+
+func TestTickLinksEveryTickToOneSpawn(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	ticked := make(chan struct{}, 3)
+	ticker := c.Tick(ctx, 2*time.Millisecond, "refresh_rates", func(ctx context.Context) {
+		c.TrackStateChange(ctx, "rates", nil, "fresh", "timers_test.go:3", "Write")
+		select {
+		case ticked <- struct{}{}:
+		default:
+		}
+	})
+	for i := 0; i < 3; i++ {
+		<-ticked
+	}
+	ticker.Stop()
+	ticker.Stop()
+	c.syncPipeline(context.Background())
+
+	// A tick in flight when Stop was called may be captured after the stop
+	var spawns, ticks []Event
+	var stopped Event
+	for _, e := range bufferedEvents(c) {
+		switch {
+		case e.Kind.AsyncSpawn != nil:
+			spawns = append(spawns, e)
+		case e.Kind.AsyncAwait != nil && e.Metadata.Tags[timerCanceledTag] == "true":
+			stopped = e
+		case e.Kind.AsyncAwait != nil:
+			ticks = append(ticks, e)
+		}
+	}
+	if len(spawns) != 1 {
+		t.Fatalf("expected one spawn for the ticker, got %d", len(spawns))
+	}
+	if len(ticks) < 3 || stopped.Kind.AsyncAwait == nil {
+		t.Fatalf("expected an await per tick and one for Stop, got %d ticks", len(ticks))
+	}
+	seen := map[string]bool{}
+	for i, await := range append(ticks, stopped) {
+		if await.ParentID == nil || *await.ParentID != spawns[0].ID {
+			t.Errorf("await %d not parented to the spawn", i)
+		}
+		if seen[await.Metadata.ThreadID] {
+			t.Errorf("await %d reuses thread %s", i, await.Metadata.ThreadID)
+		}
+		seen[await.Metadata.ThreadID] = true
+	}
+	for i := 1; i < len(ticks); i++ {
+		if !dominates(ticks[i].CausalityVector, ticks[i-1].CausalityVector) {
+			t.Errorf("tick %d is not ordered after tick %d", i, i-1)
+		}
+	}
+	if !dominates(stopped.CausalityVector, ticks[0].CausalityVector) {
+		t.Errorf("stop is not ordered after the ticks")
+	}
+	if want := strconv.Itoa(len(ticks)); stopped.Metadata.Tags[timerTicksTag] != want {
+		t.Errorf("ticks tag = %q, want %s", stopped.Metadata.Tags[timerTicksTag], want)
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/mode7labs/raceway/sdks/go/propagation"
 )
 
 const (
@@ -15,9 +18,10 @@ const (
 	tracestateHeader   = "tracestate"
 	racewayClockHeader = "raceway-clock"
 
-	traceparentVersion = "00"
-	traceFlags         = "01"
-	clockVersionPrefix = "v1;"
+	traceparentVersion  = "00"
+	traceFlagsSampled   = "01"
+	traceFlagsUnsampled = "00"
+	clockVersionPrefix  = "v1;"
 )
 
 type ParsedTraceContext struct {
@@ -27,6 +31,24 @@ type ParsedTraceContext struct {
 	TraceState   *string
 	ClockVector  []CausalityEntry
 	Distributed  bool
+	// ScatterID identifies the scatter-gather operation this request is a member of, if any
+	ScatterID string
+	// ScatterIndex is this request's member index within ScatterID
+	ScatterIndex int
+	// OriginTraceID is the application's own request ID the trace was derived from, if any
+	OriginTraceID string
+	// Fences is the latest fence epoch per name observed upstream
+	Fences map[string]uint64
+	// UpstreamRegion is the region of the calling service, if it reported one
+	UpstreamRegion string
+	// Baggage holds the decoded entries of the W3C baggage header, if any
+	Baggage map[string]string
+	// Sampled is the upstream sampling decision carried by the traceparent
+	// trace flags or B3 headers, or nil if the request carried none
+	Sampled *bool
+
+	// path is the request path offered to Config.Sampler
+	path string
 }
 
 type PropagationResult struct {
@@ -36,30 +58,61 @@ type PropagationResult struct {
 }
 
 type racewayClockPayload struct {
-	TraceID      string          `json:"trace_id"`
-	SpanID       string          `json:"span_id"`
-	ParentSpanID string          `json:"parent_span_id"`
-	Service      string          `json:"service"`
-	Instance     string          `json:"instance"`
-	Clock        [][]interface{} `json:"clock"`
+	TraceID       string            `json:"trace_id"`
+	SpanID        string            `json:"span_id"`
+	ParentSpanID  string            `json:"parent_span_id"`
+	Service       string            `json:"service"`
+	Instance      string            `json:"instance"`
+	Clock         [][]interface{}   `json:"clock"`
+	ScatterID     string            `json:"scatter_id,omitempty"`
+	ScatterIndex  *int              `json:"scatter_index,omitempty"`
+	OriginTraceID string            `json:"origin_trace_id,omitempty"`
+	Fences        map[string]uint64 `json:"fences,omitempty"`
+	Region        string            `json:"region,omitempty"`
+	// Sampled and Baggage are set by MarshalContext, whose string carries no
+	// traceparent or baggage header
+	Sampled *bool             `json:"sampled,omitempty"`
+	Baggage map[string]string `json:"baggage,omitempty"`
 }
 
 func ParseIncomingHeaders(headers http.Header, serviceName, instanceID string) ParsedTraceContext {
+	return parseIncomingHeaders(HeaderCarrier(headers), serviceName, instanceID, "")
+}
+
+// parseIncomingHeaders is ParseIncomingHeaders with a region-qualified local clock component.
+func parseIncomingHeaders(headers Carrier, serviceName, instanceID, region string) ParsedTraceContext {
 	traceID := uuid.New().String()
 	var spanID *string
 	var parentSpanID *string
 	var traceState *string
+	var sampled *bool
 	distributed := false
 
 	if raw := headers.Get(traceparentHeader); raw != "" {
 		if parsedTrace, ok := parseTraceparent(raw); ok {
 			traceID = parsedTrace.traceID
 			spanID = parsedTrace.parentSpanID // This is the span ID for THIS service
+			sampled = &parsedTrace.sampled
+			distributed = true
+		}
+	}
+	// B3 is consulted only without a valid traceparent
+	if !distributed {
+		if parsedB3, ok := parseB3(headers); ok {
+			traceID = parsedB3.traceID
+			spanID = &parsedB3.spanID
+			parentSpanID = parsedB3.parentSpanID
+			sampled = parsedB3.sampled
 			distributed = true
 		}
 	}
 
 	clockVector := []CausalityEntry{}
+	scatterID := ""
+	scatterIndex := 0
+	originTraceID := ""
+	var fences map[string]uint64
+	upstreamRegion := ""
 	if raw := headers.Get(racewayClockHeader); raw != "" {
 		if parsedClock, ok := parseRacewayClock(raw); ok {
 			if parsedClock.traceID != "" {
@@ -72,8 +125,16 @@ func ParseIncomingHeaders(headers http.Header, serviceName, instanceID string) P
 			if parsedClock.parentSpanID != nil {
 				parentSpanID = parsedClock.parentSpanID
 			}
-			clockVector = parsedClock.clock
+			// A fanned-in header may name a component more than once
+			clockVector = MergeClockVectors(parsedClock.clock, nil)
 			distributed = true
+			if parsedClock.scatterID != "" && parsedClock.scatterIndex != nil {
+				scatterID = parsedClock.scatterID
+				scatterIndex = *parsedClock.scatterIndex
+			}
+			originTraceID = parsedClock.originTraceID
+			fences = parsedClock.fences
+			upstreamRegion = parsedClock.region
 		}
 	}
 
@@ -81,17 +142,8 @@ func ParseIncomingHeaders(headers http.Header, serviceName, instanceID string) P
 		traceState = &raw
 	}
 
-	component := clockComponent(serviceName, instanceID)
-	hasComponent := false
-	for _, entry := range clockVector {
-		if entry.Component() == component {
-			hasComponent = true
-			break
-		}
-	}
-	if !hasComponent {
-		clockVector = append(clockVector, NewCausalityEntry(component, 0))
-	}
+	component := clockComponent(serviceName, instanceID, region)
+	clockVector = MergeClockVectors(clockVector, []CausalityEntry{NewCausalityEntry(component, 0)})
 
 	// Use received span ID, or generate if not provided
 	finalSpanID := generateSpanID()
@@ -100,24 +152,61 @@ func ParseIncomingHeaders(headers http.Header, serviceName, instanceID string) P
 	}
 
 	return ParsedTraceContext{
-		TraceID:      traceID,
-		SpanID:       finalSpanID,
-		ParentSpanID: parentSpanID,
-		TraceState:   traceState,
-		ClockVector:  clockVector,
-		Distributed:  distributed,
+		TraceID:        traceID,
+		SpanID:         finalSpanID,
+		ParentSpanID:   parentSpanID,
+		TraceState:     traceState,
+		ClockVector:    clockVector,
+		Distributed:    distributed,
+		ScatterID:      scatterID,
+		ScatterIndex:   scatterIndex,
+		OriginTraceID:  originTraceID,
+		Fences:         fences,
+		UpstreamRegion: upstreamRegion,
+		Baggage:        parseBaggage(headers),
+		Sampled:        sampled,
 	}
 }
 
+// BuildPropagationHeaders returns the outbound headers continuing a trace in
+// a downstream service. clockVector is propagated as-is: it should be the
+// vector of the caller's latest event, and the result's ClockVector is the
+// same vector, since only recorded events advance the clock.
 func BuildPropagationHeaders(traceID, currentSpanID string, traceState *string, clockVector []CausalityEntry, serviceName, instanceID string) PropagationResult {
-	nextVector := incrementClockVector(clockVector, serviceName, instanceID)
+	headers := MapCarrier{}
+	result := buildPropagationHeaders(headers, traceID, currentSpanID, traceState, nil, clockVector, serviceName, instanceID, "", true, nil)
+	result.Headers = headers
+	return result
+}
+
+// BuildPropagationHeadersWithBaggage is BuildPropagationHeaders that also
+// emits baggage, typically ParsedTraceContext.Baggage, as the W3C baggage header.
+func BuildPropagationHeadersWithBaggage(traceID, currentSpanID string, traceState *string, baggage map[string]string, clockVector []CausalityEntry, serviceName, instanceID string) PropagationResult {
+	headers := MapCarrier{}
+	result := buildPropagationHeaders(headers, traceID, currentSpanID, traceState, baggage, clockVector, serviceName, instanceID, "", true, nil)
+	result.Headers = headers
+	return result
+}
+
+// buildPropagationHeaders writes outbound headers to headers; the returned
+// result carries no Headers map. The clock vector is propagated as-is, so a
+// send costs no clock tick of its own: the downstream service is ordered
+// after the sender's latest event, and the sender's next event is concurrent
+// with the downstream's. sampled sets the traceparent sampled flag. extra
+// holds additive raceway-clock payload fields.
+func buildPropagationHeaders(headers Carrier, traceID, currentSpanID string, traceState *string, baggage map[string]string, clockVector []CausalityEntry, serviceName, instanceID, region string, sampled bool, extra map[string]interface{}) PropagationResult {
+	nextVector := clockVector
 	childSpanID := generateSpanID()
+	flags := traceFlagsSampled
+	if !sampled {
+		flags = traceFlagsUnsampled
+	}
 
 	traceparent := strings.Join([]string{
 		traceparentVersion,
-		uuidToTraceparent(traceID),
+		propagation.Normalize(traceID),
 		childSpanID,
-		traceFlags,
+		flags,
 	}, "-")
 
 	payload := map[string]interface{}{
@@ -128,32 +217,40 @@ func BuildPropagationHeaders(traceID, currentSpanID string, traceState *string,
 		"instance":       instanceID,
 		"clock":          encodeClockVector(nextVector),
 	}
+	for k, v := range extra {
+		payload[k] = v
+	}
 
 	payloadJSON, _ := json.Marshal(payload)
 	racewayClock := clockVersionPrefix + base64.RawURLEncoding.EncodeToString(payloadJSON)
 
-	headers := map[string]string{
-		traceparentHeader:  traceparent,
-		racewayClockHeader: racewayClock,
-	}
+	headers.Set(traceparentHeader, traceparent)
+	headers.Set(racewayClockHeader, racewayClock)
 	if traceState != nil {
-		headers[tracestateHeader] = *traceState
+		headers.Set(tracestateHeader, *traceState)
+	}
+	if encoded := encodeBaggage(baggage); encoded != "" {
+		headers.Set(baggageHeader, encoded)
 	}
 
 	return PropagationResult{
-		Headers:     headers,
 		ClockVector: nextVector,
 		ChildSpanID: childSpanID,
 	}
 }
 
 func incrementClockVector(clockVector []CausalityEntry, serviceName, instanceID string) []CausalityEntry {
-	component := clockComponent(serviceName, instanceID)
+	return incrementComponent(clockVector, clockComponent(serviceName, instanceID, ""))
+}
+
+// incrementComponent returns a copy of clockVector with component advanced by one.
+func incrementComponent(clockVector []CausalityEntry, component string) []CausalityEntry {
 	next := make([]CausalityEntry, 0, len(clockVector)+1)
 	found := false
 	for _, entry := range clockVector {
 		if entry.Component() == component {
-			next = append(next, NewCausalityEntry(entry.Component(), entry.Value()+1))
+			// Reuses the boxed component, so only the value is allocated
+			next = append(next, CausalityEntry{entry[0], entry.Value() + 1})
 			found = true
 		} else {
 			next = append(next, entry)
@@ -165,20 +262,61 @@ func incrementClockVector(clockVector []CausalityEntry, serviceName, instanceID
 	return next
 }
 
+// MergeClockVectors returns the element-wise maximum of a and b: each
+// component appears once, with the larger of its values in a and b. The
+// result is sorted by component, so encoded headers are stable, and neither
+// input is modified.
+func MergeClockVectors(a, b []CausalityEntry) []CausalityEntry {
+	values := make(map[string]uint64, len(a)+len(b))
+	for _, entry := range a {
+		if v, ok := values[entry.Component()]; !ok || entry.Value() > v {
+			values[entry.Component()] = entry.Value()
+		}
+	}
+	for _, entry := range b {
+		if v, ok := values[entry.Component()]; !ok || entry.Value() > v {
+			values[entry.Component()] = entry.Value()
+		}
+	}
+
+	components := make([]string, 0, len(values))
+	for component := range values {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	merged := make([]CausalityEntry, 0, len(components))
+	for _, component := range components {
+		merged = append(merged, NewCausalityEntry(component, values[component]))
+	}
+	return merged
+}
+
 type parsedTraceparent struct {
 	traceID      string
 	parentSpanID *string
+	sampled      bool
 }
 
+// parseTraceparent parses a W3C traceparent header. Version "ff" and
+// all-zero trace or parent IDs are invalid. A later version than "00" may
+// append fields, which are ignored.
 func parseTraceparent(value string) (parsedTraceparent, bool) {
 	parts := strings.Split(strings.TrimSpace(value), "-")
-	if len(parts) != 4 {
+	if len(parts) < 4 {
+		return parsedTraceparent{}, false
+	}
+	version := parts[0]
+	if _, err := hex.DecodeString(version); err != nil || len(version) != 2 || version == "ff" {
+		return parsedTraceparent{}, false
+	}
+	if version == traceparentVersion && len(parts) != 4 {
 		return parsedTraceparent{}, false
 	}
 
 	traceIDHex := parts[1]
 	spanIDHex := parts[2]
-	if len(traceIDHex) != 32 || len(spanIDHex) != 16 {
+	if len(traceIDHex) != 32 || len(spanIDHex) != 16 || strings.Trim(spanIDHex, "0") == "" {
 		return parsedTraceparent{}, false
 	}
 
@@ -188,21 +326,36 @@ func parseTraceparent(value string) (parsedTraceparent, bool) {
 	if _, err := hex.DecodeString(spanIDHex); err != nil {
 		return parsedTraceparent{}, false
 	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return parsedTraceparent{}, false
+	}
 
-	traceID := traceparentToUUID(traceIDHex)
+	traceID, err := propagation.TraceIDToUUID(traceIDHex)
+	if err != nil {
+		return parsedTraceparent{}, false
+	}
 	parentSpanID := spanIDHex
 
 	return parsedTraceparent{
 		traceID:      traceID,
 		parentSpanID: &parentSpanID,
+		sampled:      flags[0]&1 == 1,
 	}, true
 }
 
 type parsedClock struct {
-	traceID      string
-	spanID       *string
-	parentSpanID *string
-	clock        []CausalityEntry
+	traceID       string
+	spanID        *string
+	parentSpanID  *string
+	clock         []CausalityEntry
+	scatterID     string
+	scatterIndex  *int
+	originTraceID string
+	fences        map[string]uint64
+	region        string
+	sampled       *bool
+	baggage       map[string]string
 }
 
 func parseRacewayClock(value string) (parsedClock, bool) {
@@ -220,6 +373,10 @@ func parseRacewayClock(value string) (parsedClock, bool) {
 	if err := json.Unmarshal(decoded, &payload); err != nil {
 		return parsedClock{}, false
 	}
+	// The nil UUID comes from broken upstreams and would merge their traces
+	if _, err := propagation.UUIDToTraceID(payload.TraceID); errors.Is(err, propagation.ErrZeroTraceID) {
+		return parsedClock{}, false
+	}
 
 	entries := make([]CausalityEntry, 0, len(payload.Clock))
 	for _, item := range payload.Clock {
@@ -258,31 +415,20 @@ func parseRacewayClock(value string) (parsedClock, bool) {
 	}
 
 	return parsedClock{
-		traceID:      payload.TraceID,
-		spanID:       spanID,
-		parentSpanID: parentSpanID,
-		clock:        entries,
+		traceID:       payload.TraceID,
+		spanID:        spanID,
+		parentSpanID:  parentSpanID,
+		clock:         entries,
+		scatterID:     payload.ScatterID,
+		scatterIndex:  payload.ScatterIndex,
+		originTraceID: payload.OriginTraceID,
+		fences:        payload.Fences,
+		region:        payload.Region,
+		sampled:       payload.Sampled,
+		baggage:       payload.Baggage,
 	}, true
 }
 
-func uuidToTraceparent(value string) string {
-	cleaned := strings.ReplaceAll(value, "-", "")
-	if len(cleaned) < 32 {
-		cleaned = cleaned + strings.Repeat("0", 32-len(cleaned))
-	}
-	return cleaned[:32]
-}
-
-func traceparentToUUID(value string) string {
-	return strings.Join([]string{
-		value[0:8],
-		value[8:12],
-		value[12:16],
-		value[16:20],
-		value[20:32],
-	}, "-")
-}
-
 func encodeClockVector(clockVector []CausalityEntry) [][]interface{} {
 	encoded := make([][]interface{}, 0, len(clockVector))
 	for _, entry := range clockVector {
@@ -1,6 +1,9 @@
+//go:build !raceway_disabled
+
 package raceway
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
@@ -322,7 +325,7 @@ func TestBuildPropagationHeaders(t *testing.T) {
 		}
 	})
 
-	t.Run("increment clock vector", func(t *testing.T) {
+	t.Run("carry clock vector unchanged", func(t *testing.T) {
 		result := BuildPropagationHeaders(
 			validTraceID,
 			"current-span",
@@ -335,8 +338,8 @@ func TestBuildPropagationHeaders(t *testing.T) {
 			"instance-1",
 		)
 
-		if !hasClockComponent(result.ClockVector, "test-service#instance-1", 11) {
-			t.Error("expected test-service#instance-1:11")
+		if !hasClockComponent(result.ClockVector, "test-service#instance-1", 10) {
+			t.Error("expected test-service#instance-1:10")
 		}
 		if !hasClockComponent(result.ClockVector, "other-service#other-1", 5) {
 			t.Error("expected other-service#other-1:5")
@@ -453,9 +456,9 @@ func TestEndToEndScenarios(t *testing.T) {
 			t.Error("expected distributed=true")
 		}
 
-		// Verify clock propagation
-		if !hasClockComponent(parsedB.ClockVector, "service-a#a1", 1) {
-			t.Error("expected service-a#a1:1")
+		// Verify clock propagation: A recorded no events, so nothing ticked
+		if !hasClockComponent(parsedB.ClockVector, "service-a#a1", 0) {
+			t.Error("expected service-a#a1:0")
 		}
 		if !hasClockComponent(parsedB.ClockVector, "service-b#b1", 0) {
 			t.Error("expected service-b#b1:0")
@@ -500,16 +503,59 @@ func TestEndToEndScenarios(t *testing.T) {
 		if parsedC.TraceID != validTraceID {
 			t.Error("trace ID should be preserved")
 		}
-		if !hasClockComponent(parsedC.ClockVector, "service-a#a1", 1) {
-			t.Error("expected service-a#a1:1")
+		if !hasClockComponent(parsedC.ClockVector, "service-a#a1", 0) {
+			t.Error("expected service-a#a1:0")
 		}
-		if !hasClockComponent(parsedC.ClockVector, "service-b#b1", 1) {
-			t.Error("expected service-b#b1:1")
+		if !hasClockComponent(parsedC.ClockVector, "service-b#b1", 0) {
+			t.Error("expected service-b#b1:0")
 		}
 		if !hasClockComponent(parsedC.ClockVector, "service-c#c1", 0) {
 			t.Error("expected service-c#c1:0")
 		}
 	})
+
+	t.Run("local events then one downstream call", func(t *testing.T) {
+		a := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "service-a", "a1" })
+		b := newBufferingClient(t, func(cfg *Config) { cfg.ServiceName, cfg.InstanceID = "service-b", "b1" })
+
+		const n = 3
+		ctx := a.newContext(context.Background(), validTraceID)
+		for i := 0; i < n; i++ {
+			a.TrackStateChange(ctx, "counter", i, i+1, "trace_context_test.go:1", "Write")
+		}
+		outgoing, err := a.PropagationHeaders(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers := http.Header{}
+		for k, v := range outgoing {
+			headers.Set(k, v)
+		}
+
+		// The downstream sees exactly the n local events, and propagating
+		// recorded nothing, so A's clock has not moved either
+		parsed := ParseIncomingHeaders(headers, "service-b", "b1")
+		if len(parsed.ClockVector) != 2 || !hasClockComponent(parsed.ClockVector, "service-a#a1", n) ||
+			!hasClockComponent(parsed.ClockVector, "service-b#b1", 0) {
+			t.Errorf("expected [service-a#a1:%d service-b#b1:0], got %v", n, parsed.ClockVector)
+		}
+		if !hasClockComponent(FromContext(ctx).ClockVector, "service-a#a1", n) {
+			t.Errorf("expected propagation to leave service-a#a1:%d, got %v", n, FromContext(ctx).ClockVector)
+		}
+
+		a.TrackStateChange(ctx, "counter", n, n+1, "trace_context_test.go:2", "Write")
+		events := bufferedEvents(a)
+		if got := events[len(events)-1].CausalityVector; len(got) != 1 || !hasClockComponent(got, "service-a#a1", n+1) {
+			t.Errorf("expected the next event at service-a#a1:%d, got %v", n+1, got)
+		}
+
+		bCtx := b.ContextFromParsed(context.Background(), parsed)
+		b.TrackStateChange(bCtx, "counter", n+1, n+2, "trace_context_test.go:3", "Write")
+		if got := bufferedEvents(b)[0].CausalityVector; !hasClockComponent(got, "service-a#a1", n) ||
+			!hasClockComponent(got, "service-b#b1", 1) {
+			t.Errorf("expected the downstream event at [service-a#a1:%d service-b#b1:1], got %v", n, got)
+		}
+	})
 }
 
 // Helper function
@@ -521,3 +567,172 @@ func hasClockComponent(vector []CausalityEntry, component string, value uint64)
 	}
 	return false
 }
+
+func TestBuildPropagationHeadersNormalizesCustomTraceIDs(t *testing.T) {
+	result := BuildPropagationHeaders("trace-123", "span-1", nil, nil, "test-service", "instance-1")
+
+	parsed, ok := parseTraceparent(result.Headers["traceparent"])
+	if !ok {
+		t.Fatalf("expected a spec-compliant traceparent, got %q", result.Headers["traceparent"])
+	}
+	if parsed.traceID == "00000000-0000-0000-0000-000000000000" {
+		t.Error("expected custom trace IDs not to be zero-padded")
+	}
+}
+
+func TestParseTraceparentSpecCases(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{name: "valid", value: validTraceparent, valid: true},
+		{name: "all-zero trace ID", value: "00-00000000000000000000000000000000-b7ad6b7169203331-01"},
+		{name: "all-zero parent ID", value: "00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01"},
+		{name: "version ff", value: "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		{name: "non-hex version", value: "0g-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		{name: "long version", value: "000-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		{name: "version 00 with extra field", value: validTraceparent + "-extra"},
+		{name: "future version", value: "01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", valid: true},
+		{name: "future version with extra fields", value: "cc-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-what-the-future-holds", valid: true},
+		{name: "future version with bad flags", value: "cc-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-0"},
+		{name: "too few fields", value: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			headers.Set("traceparent", tt.value)
+
+			result := ParseIncomingHeaders(headers, "test-service", "instance-1")
+
+			if result.Distributed != tt.valid {
+				t.Errorf("expected distributed=%v, got %v", tt.valid, result.Distributed)
+			}
+			if tt.valid && (result.TraceID != validTraceID || result.SpanID != validSpanID) {
+				t.Errorf("expected trace %s and span %s, got %s and %s", validTraceID, validSpanID, result.TraceID, result.SpanID)
+			}
+			if !tt.valid && (result.TraceID == validTraceID || result.TraceID == "00000000-0000-0000-0000-000000000000") {
+				t.Errorf("expected a fresh trace ID, got %s", result.TraceID)
+			}
+		})
+	}
+}
+
+func TestParseIncomingHeadersRejectsZeroClockTraceID(t *testing.T) {
+	for _, traceID := range []string{"00000000-0000-0000-0000-000000000000", "00000000000000000000000000000000"} {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"trace_id": traceID,
+			"span_id":  "upstream-span",
+			"clock":    [][]interface{}{{"upstream#1", float64(4)}},
+		})
+		headers := http.Header{}
+		headers.Set("raceway-clock", clockVersionPrefix+base64.RawURLEncoding.EncodeToString(payload))
+
+		result := ParseIncomingHeaders(headers, "test-service", "instance-1")
+
+		if result.Distributed || result.TraceID == traceID {
+			t.Errorf("%s: expected the header ignored and a fresh trace, got %s", traceID, result.TraceID)
+		}
+		if hasClockComponent(result.ClockVector, "upstream#1", 4) {
+			t.Errorf("%s: expected the upstream clock ignored, got %v", traceID, result.ClockVector)
+		}
+	}
+}
+
+func TestMergeClockVectors(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []CausalityEntry
+		want []CausalityEntry
+	}{
+		{
+			name: "overlapping",
+			a:    []CausalityEntry{NewCausalityEntry("svc-b#1", 2), NewCausalityEntry("svc-a#1", 5)},
+			b:    []CausalityEntry{NewCausalityEntry("svc-a#1", 3), NewCausalityEntry("svc-b#1", 4)},
+			want: []CausalityEntry{NewCausalityEntry("svc-a#1", 5), NewCausalityEntry("svc-b#1", 4)},
+		},
+		{
+			name: "disjoint",
+			a:    []CausalityEntry{NewCausalityEntry("svc-c#1", 1)},
+			b:    []CausalityEntry{NewCausalityEntry("svc-a#1", 7), NewCausalityEntry("svc-b#1", 2)},
+			want: []CausalityEntry{NewCausalityEntry("svc-a#1", 7), NewCausalityEntry("svc-b#1", 2), NewCausalityEntry("svc-c#1", 1)},
+		},
+		{
+			name: "empty",
+			a:    nil,
+			b:    []CausalityEntry{NewCausalityEntry("svc-b#1", 1), NewCausalityEntry("svc-a#1", 1)},
+			want: []CausalityEntry{NewCausalityEntry("svc-a#1", 1), NewCausalityEntry("svc-b#1", 1)},
+		},
+		{
+			name: "both empty",
+			a:    []CausalityEntry{},
+			b:    nil,
+			want: []CausalityEntry{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := append([]CausalityEntry(nil), tt.a...)
+			b := append([]CausalityEntry(nil), tt.b...)
+
+			got := MergeClockVectors(tt.a, tt.b)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i].Component() != tt.want[i].Component() || got[i].Value() != tt.want[i].Value() {
+					t.Errorf("expected %v, got %v", tt.want, got)
+					break
+				}
+			}
+			for i := range a {
+				if tt.a[i] != a[i] {
+					t.Errorf("first input was mutated: %v", tt.a)
+				}
+			}
+			for i := range b {
+				if tt.b[i] != b[i] {
+					t.Errorf("second input was mutated: %v", tt.b)
+				}
+			}
+		})
+	}
+}
+
+func TestIncomingClockMergedWithLocalTraceContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	traceID := "4bf92f35-77b3-4da6-a3ce-929d0e0e4736"
+	upstream := BuildPropagationHeaders(traceID, "span-1", nil, []CausalityEntry{
+		NewCausalityEntry("webhooks#1", 4),
+		NewCausalityEntry("test-service#test-instance", 2),
+	}, "webhooks", "1")
+	headers := http.Header{}
+	for k, v := range upstream.Headers {
+		headers.Set(k, v)
+	}
+
+	// This service already recorded further into the trace than the webhook saw
+	local := c.newContext(context.Background(), traceID)
+	FromContext(local).ClockVector = []CausalityEntry{
+		NewCausalityEntry("billing#1", 3),
+		NewCausalityEntry("test-service#test-instance", 9),
+	}
+
+	parsed := ParseIncomingHeaders(headers, "test-service", "test-instance")
+	merged := FromContext(c.contextFromParsed(local, parsed)).ClockVector
+
+	want := map[string]uint64{"billing#1": 3, "test-service#test-instance": 9, "webhooks#1": 4}
+	if len(merged) != len(want) {
+		t.Fatalf("expected %v, got %v", want, merged)
+	}
+	for component, value := range want {
+		if !hasClockComponent(merged, component, value) {
+			t.Errorf("expected %s:%d, got %v", component, value, merged)
+		}
+	}
+
+	other := c.newContext(context.Background(), "")
+	if got := FromContext(c.contextFromParsed(other, parsed)).ClockVector; len(got) != 2 {
+		t.Errorf("expected a context from another trace to be ignored, got %v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package raceway
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/mode7labs/raceway/sdks/go/propagation"
+)
+
+// originTraceIDTag holds the application's original request ID on traces keyed by a TraceIDAdapter.
+const originTraceIDTag = "origin_trace_id"
+
+// TraceIDAdapter lets the middleware key traces by an identifier the application
+// already assigns, such as an edge-generated request ID.
+type TraceIDAdapter interface {
+	// FromRequest returns the application's ID for r, or false to fall back to
+	// traceparent and generated IDs.
+	FromRequest(r *http.Request) (traceID string, ok bool)
+	// ToWire maps the application's ID to a 32-character hex W3C trace ID.
+	ToWire(traceID string) (traceparentHex string)
+}
+
+// DefaultOrgRequestIDHeader is the header read by ULIDAdapter when Header is empty.
+const DefaultOrgRequestIDHeader = "X-Org-Request-ID"
+
+// ULIDAdapter keys traces by a ULID request ID header. The 128 bits of the ULID
+// become the trace ID, so the mapping is deterministic; the original ULID is kept
+// in the origin_trace_id tag and propagated downstream in the raceway-clock header.
+type ULIDAdapter struct {
+	// Header is the request header carrying the ULID (default: X-Org-Request-ID)
+	Header string
+}
+
+// FromRequest returns the ULID from the configured header if it is well formed.
+func (a ULIDAdapter) FromRequest(r *http.Request) (string, bool) {
+	header := a.Header
+	if header == "" {
+		header = DefaultOrgRequestIDHeader
+	}
+	value := strings.TrimSpace(r.Header.Get(header))
+	if _, ok := decodeULID(value); !ok {
+		return "", false
+	}
+	return value, true
+}
+
+// ToWire returns the ULID's 16 bytes as hex. Values that are not ULIDs are hashed
+// like any other custom trace ID.
+func (a ULIDAdapter) ToWire(traceID string) string {
+	raw, ok := decodeULID(traceID)
+	if !ok {
+		return propagation.Normalize(traceID)
+	}
+	return propagation.Normalize(hex.EncodeToString(raw[:]))
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var crockfordValues = func() [256]byte {
+	var values [256]byte
+	for i := range values {
+		values[i] = 0xFF
+	}
+	for i := 0; i < len(crockfordAlphabet); i++ {
+		values[crockfordAlphabet[i]] = byte(i)
+		values[crockfordAlphabet[i]|0x20] = byte(i) // lowercase
+	}
+	return values
+}()
+
+// decodeULID decodes a 26-character Crockford base32 ULID into its 16 bytes.
+func decodeULID(value string) ([16]byte, bool) {
+	var out [16]byte
+	if len(value) != 26 || crockfordValues[value[0]] > 7 {
+		return out, false
+	}
+	// 26 characters carry 130 bits; the leading 2 bits are always zero.
+	var acc uint64
+	bits := -2
+	n := 0
+	for i := 0; i < len(value); i++ {
+		v := crockfordValues[value[i]]
+		if v == 0xFF {
+			return out, false
+		}
+		acc = acc<<5 | uint64(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			out[n] = byte(acc >> uint(bits))
+			n++
+		}
+	}
+	return out, n == 16
+}
+
+// applyTraceIDAdapter re-keys parsed by the configured adapter's ID for r.
+// Span and clock state from incoming headers are kept.
+func (c *Client) applyTraceIDAdapter(r *http.Request, parsed ParsedTraceContext) ParsedTraceContext {
+	if c.config.TraceIDAdapter == nil {
+		return parsed
+	}
+	origin, ok := c.config.TraceIDAdapter.FromRequest(r)
+	if !ok || origin == "" {
+		return parsed
+	}
+
+	wire := c.config.TraceIDAdapter.ToWire(origin)
+	traceID, err := propagation.TraceIDToUUID(strings.ToLower(wire))
+	if err != nil {
+		// Adapters must return spec-valid IDs; fall back to hashing the origin ID.
+		traceID, _ = propagation.TraceIDToUUID(propagation.Normalize(origin))
+	}
+	parsed.TraceID = traceID
+	parsed.OriginTraceID = origin
+	return parsed
+}
+
+// parseRequest extracts trace context from r, consulting the TraceIDAdapter first.
+func (c *Client) parseRequest(r *http.Request) ParsedTraceContext {
+	parsed := parseIncomingHeaders(HeaderCarrier(r.Header), c.config.ServiceName, c.instanceID, c.componentRegion())
+	parsed.path = r.URL.Path
+	return c.applyTraceIDAdapter(r, parsed)
+}
+
+// propagationExtra returns the additive raceway-clock fields carried by rctx.
+func (c *Client) propagationExtra(rctx *RacewayContext, extra map[string]interface{}) map[string]interface{} {
+	caps := c.Capabilities()
+	fields := make(map[string]interface{}, len(extra)+3)
+	for k, v := range extra {
+		fields[k] = v
+	}
+	if origin := rctx.tag(originTraceIDTag); origin != "" && caps.Has(CapabilityOriginTraceID) {
+		fields[originTraceIDTag] = origin
+	}
+	if fences := rctx.fenceSnapshot(); fences != nil && caps.Has(CapabilityFences) {
+		fields["fences"] = fences
+	}
+	if c.region != "" && caps.Has(CapabilityRegion) {
+		fields["region"] = c.region
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
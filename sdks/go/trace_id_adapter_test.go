@@ -0,0 +1,158 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mode7labs/raceway/sdks/go/propagation"
+)
+
+// encodeULID is the inverse of decodeULID.
+func encodeULID(raw [16]byte) string {
+	var out [26]byte
+	var acc uint64
+	bits := 2 // 130 bits of output for 128 bits of input
+	idx := 0
+	for _, b := range raw {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[idx] = crockfordAlphabet[(acc>>uint(bits))&0x1F]
+			idx++
+		}
+	}
+	return string(out[:])
+}
+
+func randomULID(t testing.TB) string {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		t.Fatal(err)
+	}
+	return encodeULID(raw)
+}
+
+func TestDecodeULIDRoundTrip(t *testing.T) {
+	const known = "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+	raw, ok := decodeULID(known)
+	if !ok {
+		t.Fatalf("failed to decode %s", known)
+	}
+	if got := encodeULID(raw); got != known {
+		t.Fatalf("round trip = %s, want %s", got, known)
+	}
+	if _, ok := decodeULID(strings.ToLower(known)); !ok {
+		t.Errorf("expected lowercase ULID to decode")
+	}
+	for _, bad := range []string{"", "8ZZZZZZZZZZZZZZZZZZZZZZZZZ", "01ARZ3NDEKTSV4RRFFQ69G5FAU!", "01ARZ3NDEKTSV4RRFFQ69G5FA"} {
+		if _, ok := decodeULID(bad); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestULIDAdapterRoundTripAcrossTwoHops(t *testing.T) {
+	ulid := randomULID(t)
+	adapter := ULIDAdapter{}
+
+	upstream := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServiceName = "edge"
+		cfg.TraceIDAdapter = adapter
+	})
+	downstream := newBufferingClient(t, func(cfg *Config) {
+		cfg.ServiceName = "orders"
+		cfg.TraceIDAdapter = adapter
+	})
+
+	var outbound map[string]string
+	upstream.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if outbound, err = upstream.PropagationHeaders(r.Context(), nil); err != nil {
+			t.Fatal(err)
+		}
+	})).ServeHTTP(httptest.NewRecorder(), func() *http.Request {
+		req := httptest.NewRequest("GET", "/checkout", nil)
+		req.Header.Set(DefaultOrgRequestIDHeader, ulid)
+		return req
+	}())
+
+	traceparent := outbound[traceparentHeader]
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[1] != adapter.ToWire(ulid) {
+		t.Fatalf("traceparent %q does not carry the ULID's trace ID %s", traceparent, adapter.ToWire(ulid))
+	}
+
+	// The second hop only receives propagation headers, not the org header.
+	req := httptest.NewRequest("GET", "/orders", nil)
+	for k, v := range outbound {
+		req.Header.Set(k, v)
+	}
+	var downstreamTags map[string]string
+	downstream.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamTags = FromContext(r.Context()).tags
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	upEvents, downEvents := bufferedEvents(upstream), bufferedEvents(downstream)
+	if upEvents[0].TraceID != downEvents[0].TraceID {
+		t.Errorf("trace IDs differ across hops: %s vs %s", upEvents[0].TraceID, downEvents[0].TraceID)
+	}
+	if wire, _ := propagation.UUIDToTraceID(upEvents[0].TraceID); wire != adapter.ToWire(ulid) {
+		t.Errorf("trace ID %s does not map to the ULID", upEvents[0].TraceID)
+	}
+	if upEvents[0].Metadata.Tags[originTraceIDTag] != ulid || downstreamTags[originTraceIDTag] != ulid {
+		t.Errorf("expected origin ULID tag on both hops, got %v and %v", upEvents[0].Metadata.Tags, downstreamTags)
+	}
+}
+
+func TestULIDAdapterMappingHasNoCollisions(t *testing.T) {
+	adapter := ULIDAdapter{}
+	// ULIDs minted in the same millisecond share their 48-bit timestamp prefix.
+	prefix := randomULID(t)[:10]
+	seen := make(map[string]string, 100000)
+	for i := 0; i < 100000; i++ {
+		ulid := prefix + randomULID(t)[10:]
+		wire := adapter.ToWire(ulid)
+		if len(wire) != 32 {
+			t.Fatalf("invalid wire ID %q", wire)
+		}
+		if previous, ok := seen[wire]; ok && previous != ulid {
+			t.Fatalf("collision between %s and %s", previous, ulid)
+		}
+		seen[wire] = ulid
+	}
+}
+
+func TestTraceIDAdapterTakesPrecedenceOverTraceparent(t *testing.T) {
+	ulid := randomULID(t)
+	c := newBufferingClient(t, func(cfg *Config) { cfg.TraceIDAdapter = ULIDAdapter{} })
+
+	req := httptest.NewRequest("GET", "/checkout", nil)
+	req.Header.Set(DefaultOrgRequestIDHeader, ulid)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	var rctx *RacewayContext
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx = FromContext(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if wire, _ := propagation.UUIDToTraceID(rctx.TraceID); wire != (ULIDAdapter{}).ToWire(ulid) {
+		t.Errorf("expected adapter trace ID to win, got %s", rctx.TraceID)
+	}
+	if rctx.SpanID != "00f067aa0ba902b7" || !rctx.Distributed {
+		t.Errorf("expected upstream span from traceparent to be kept, got %s", rctx.SpanID)
+	}
+
+	// Without the org header the traceparent is used as before.
+	plain := httptest.NewRequest("GET", "/checkout", nil)
+	plain.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	parsed := c.parseRequest(plain)
+	if parsed.TraceID != "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" || parsed.OriginTraceID != "" {
+		t.Errorf("unexpected fallback trace context %+v", parsed)
+	}
+}
@@ -0,0 +1,170 @@
+package raceway
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Phases of a Transaction event.
+const (
+	TransactionBegin    = "begin"
+	TransactionCommit   = "commit"
+	TransactionRollback = "rollback"
+)
+
+// txIDTag holds the ID of the innermost transaction on state changes made
+// within WithTransaction.
+const txIDTag = "tx_id"
+
+// transaction is a transaction opened with WithTransaction.
+type transaction struct {
+	id     string
+	parent *transaction
+
+	mu sync.Mutex
+	// resources are the variables accessed within the transaction
+	resources map[string]bool
+}
+
+// WithTransaction runs fn as the transaction name. It records a Transaction
+// event with phase "begin" and a new transaction ID, then runs fn with a
+// context whose state changes are tagged tx_id with that ID and are children
+// of the begin event. Once fn returns, a "commit" event is recorded, or a
+// "rollback" event if fn returned an error or panicked; either lists the
+// variables accessed within the transaction. fn's error is returned, and a
+// panic resumes after it is recorded.
+//
+// A WithTransaction inside fn opens a nested transaction with its own ID,
+// whose events record the enclosing one as parent_tx_id. State changes
+// within it are tagged with the nested ID and listed by both transactions.
+//
+// Example:
+//
+//	err := client.WithTransaction(ctx, "transfer", func(ctx context.Context) error {
+//	    tx, err := db.BeginTx(ctx, nil)
+//	    if err != nil {
+//	        return err
+//	    }
+//	    defer tx.Rollback()
+//	    if err := debit(ctx, tx, from, amount); err != nil {
+//	        return err
+//	    }
+//	    return tx.Commit()
+//	})
+func (c *Client) WithTransaction(ctx context.Context, name string, fn func(ctx context.Context) error) (err error) {
+	if BuildDisabled || c.disabled {
+		return fn(ctx)
+	}
+	parent := FromContext(ctx)
+	if parent == nil {
+		return fn(ctx)
+	}
+	location := c.captureLocation(2)
+	child := parent.enterSpan()
+	tx := &transaction{id: uuid.New().String(), parent: child.tx}
+	child.tx = tx
+	txCtx := context.WithValue(ctx, racewayContextKey, child)
+
+	beginID := c.trackTransactionPhase(txCtx, tx, name, TransactionBegin, location)
+	if beginID != "" {
+		child.mu.Lock()
+		child.spanParent = &beginID
+		child.mu.Unlock()
+	}
+	defer func() {
+		v := recover()
+		phase := TransactionCommit
+		if v != nil {
+			c.trackPanic(txCtx, v)
+			phase = TransactionRollback
+		} else if err != nil {
+			phase = TransactionRollback
+		}
+		c.trackTransactionPhase(txCtx, tx, name, phase, location)
+		parent.leaveSpan(child)
+		if v != nil {
+			panic(v)
+		}
+	}()
+	return fn(txCtx)
+}
+
+// trackTransactionPhase records a Transaction event for phase of tx and
+// returns its ID.
+func (c *Client) trackTransactionPhase(ctx context.Context, tx *transaction, name, phase, location string) string {
+	data := &TransactionData{
+		TxID:     tx.id,
+		Name:     name,
+		Phase:    phase,
+		Location: location,
+	}
+	if tx.parent != nil {
+		data.ParentTxID = &tx.parent.id
+	}
+	if phase != TransactionBegin {
+		data.Resources = tx.accessed()
+	}
+	return c.captureEventWith(ctx, EventKind{Transaction: data}, captureOptions{})
+}
+
+// trackTransaction adds the variable kind accesses, if any, to the
+// transactions r is within, and returns the innermost one's ID for the
+// event's tx_id tag.
+func (r *RacewayContext) trackTransaction(kind EventKind) string {
+	if r.tx == nil {
+		return ""
+	}
+	access, ok := kind.stateAccess()
+	if !ok {
+		return ""
+	}
+	for tx := r.tx; tx != nil; tx = tx.parent {
+		tx.mu.Lock()
+		if tx.resources == nil {
+			tx.resources = make(map[string]bool)
+		}
+		tx.resources[access.variable] = true
+		tx.mu.Unlock()
+	}
+	return r.tx.id
+}
+
+// accessed returns the variables accessed within t, sorted.
+func (t *transaction) accessed() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.resources) == 0 {
+		return nil
+	}
+	resources := make([]string, 0, len(t.resources))
+	for variable := range t.resources {
+		resources = append(resources, variable)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// downgradeTransaction re-encodes a Transaction event as a FunctionCall for
+// collectors that do not understand the Transaction kind.
+func downgradeTransaction(event Event) Event {
+	data := event.Kind.Transaction
+	args := map[string]interface{}{
+		"tx_id":     data.TxID,
+		"resources": data.Resources,
+	}
+	if data.ParentTxID != nil {
+		args["parent_tx_id"] = *data.ParentTxID
+	}
+	event.Kind = EventKind{
+		FunctionCall: &FunctionCallData{
+			FunctionName: "transaction:" + data.Phase + ":" + data.Name,
+			Module:       "raceway.transaction",
+			Args:         args,
+			File:         data.Location,
+		},
+	}
+	return event
+}
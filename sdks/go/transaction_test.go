@@ -0,0 +1,181 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTransactionCommits(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	err := c.WithTransaction(ctx, "transfer", func(ctx context.Context) error {
+		c.TrackStateChange(ctx, "accounts/alice/balance", 100, 50, "bank.go:1", "Write")
+		c.TrackStateChange(ctx, "accounts/bob/balance", 0, 50, "bank.go:2", "Write")
+		c.TrackCacheOp(ctx, "redis", "DEL", "balance:alice", nil, time.Millisecond)
+		c.TrackStateChange(ctx, "accounts/alice/balance", nil, 50, "bank.go:3", "Read")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.TrackStateChange(ctx, "accounts/alice/balance", nil, 50, "bank.go:4", "Read")
+
+	events := bufferedEvents(c)
+	if len(events) != 7 {
+		t.Fatalf("expected begin, four accesses, commit, and a read after, got %d events", len(events))
+	}
+	begin, commit := events[0].Kind.Transaction, events[5].Kind.Transaction
+	if begin == nil || begin.Phase != TransactionBegin || begin.Name != "transfer" || begin.TxID == "" || begin.Resources != nil {
+		t.Fatalf("expected a begin event, got %+v", events[0].Kind)
+	}
+	if !strings.HasPrefix(begin.Location, "transaction_test.go:") {
+		t.Errorf("expected the caller's location, got %q", begin.Location)
+	}
+	if commit == nil || commit.Phase != TransactionCommit || commit.TxID != begin.TxID || commit.ParentTxID != nil {
+		t.Fatalf("expected a commit of the same transaction, got %+v", events[5].Kind)
+	}
+	want := []string{"accounts/alice/balance", "accounts/bob/balance", "redis:balance:alice"}
+	if !reflect.DeepEqual(commit.Resources, want) {
+		t.Errorf("commit resources = %v, want %v", commit.Resources, want)
+	}
+	for _, e := range events[1:5] {
+		if e.Metadata.Tags[txIDTag] != begin.TxID {
+			t.Errorf("%s event not tagged with the transaction", e.Kind.Name())
+		}
+		if e.ParentID == nil || *e.ParentID != events[0].ID {
+			t.Errorf("%s event is not a child of the begin event", e.Kind.Name())
+		}
+	}
+	if _, ok := events[0].Metadata.Tags[txIDTag]; ok {
+		t.Error("expected only state changes tagged")
+	}
+	after := events[6]
+	if _, ok := after.Metadata.Tags[txIDTag]; ok {
+		t.Error("expected events after the transaction untagged")
+	}
+	if !dominates(after.CausalityVector, events[5].CausalityVector) {
+		t.Error("expected events after the transaction ordered after its commit")
+	}
+}
+
+func TestWithTransactionRollsBack(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	errInsufficient := errors.New("insufficient funds")
+	err := c.WithTransaction(ctx, "transfer", func(ctx context.Context) error {
+		c.TrackStateChange(ctx, "accounts/alice/balance", nil, 10, "bank.go:1", "Read")
+		return errInsufficient
+	})
+	if err != errInsufficient {
+		t.Fatalf("expected fn's error returned, got %v", err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic to resume, got %v", r)
+			}
+		}()
+		c.WithTransaction(ctx, "audit", func(ctx context.Context) error {
+			panic("boom")
+		})
+	}()
+
+	var phases []string
+	for _, e := range bufferedEvents(c) {
+		switch {
+		case e.Kind.Transaction != nil:
+			phases = append(phases, e.Kind.Transaction.Name+":"+e.Kind.Transaction.Phase)
+		case e.Kind.Error != nil:
+			phases = append(phases, "panic")
+		}
+	}
+	want := []string{"transfer:begin", "transfer:rollback", "audit:begin", "panic", "audit:rollback"}
+	if !reflect.DeepEqual(phases, want) {
+		t.Errorf("recorded %v, want %v", phases, want)
+	}
+}
+
+func TestNestedTransactionsReferenceTheirParent(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.WithTransaction(ctx, "settle", func(ctx context.Context) error {
+		c.TrackStateChange(ctx, "batch", nil, "settling", "settle.go:1", "Write")
+		return c.WithTransaction(ctx, "transfer", func(ctx context.Context) error {
+			c.TrackStateChange(ctx, "accounts/alice/balance", 100, 50, "settle.go:2", "Write")
+			return nil
+		})
+	})
+
+	var outer, inner []*TransactionData
+	var nestedWrite Event
+	for _, e := range bufferedEvents(c) {
+		switch {
+		case e.Kind.Transaction != nil && e.Kind.Transaction.Name == "settle":
+			outer = append(outer, e.Kind.Transaction)
+		case e.Kind.Transaction != nil:
+			inner = append(inner, e.Kind.Transaction)
+		case e.Kind.StateChange != nil && e.Kind.StateChange.Location == "settle.go:2":
+			nestedWrite = e
+		}
+	}
+	if len(outer) != 2 || len(inner) != 2 {
+		t.Fatalf("expected begin and commit for both transactions, got %d and %d", len(outer), len(inner))
+	}
+	if inner[0].TxID == outer[0].TxID {
+		t.Fatal("expected the nested transaction to have its own ID")
+	}
+	for _, data := range inner {
+		if data.ParentTxID == nil || *data.ParentTxID != outer[0].TxID {
+			t.Errorf("expected %s of the nested transaction to reference its parent", data.Phase)
+		}
+	}
+	if nestedWrite.Metadata.Tags[txIDTag] != inner[0].TxID {
+		t.Errorf("expected the nested write tagged with the nested transaction")
+	}
+	if want := []string{"accounts/alice/balance"}; !reflect.DeepEqual(inner[1].Resources, want) {
+		t.Errorf("nested resources = %v, want %v", inner[1].Resources, want)
+	}
+	if want := []string{"accounts/alice/balance", "batch"}; !reflect.DeepEqual(outer[1].Resources, want) {
+		t.Errorf("outer resources = %v, want %v", outer[1].Resources, want)
+	}
+}
+
+func TestWithTransactionWithoutContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	ran := false
+	c.WithTransaction(context.Background(), "transfer", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	if !ran {
+		t.Error("expected fn to run without a Raceway context")
+	}
+	if events := bufferedEvents(c); len(events) != 0 {
+		t.Errorf("expected no events without a Raceway context, got %d", len(events))
+	}
+}
+
+func TestTransactionDowngradedWithoutCapability(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.ForceCapabilities = nil })
+	ctx := c.newContext(context.Background(), "")
+	c.WithTransaction(ctx, "transfer", func(ctx context.Context) error { return nil })
+
+	events := bufferedEvents(c)
+	txID := events[0].Kind.Transaction.TxID
+	c.downgradeEvents(events)
+	call := events[1].Kind.FunctionCall
+	if call == nil || call.FunctionName != "transaction:commit:transfer" || call.Args.(map[string]interface{})["tx_id"] != txID {
+		t.Errorf("expected the commit downgraded to a FunctionCall, got %+v", events[1].Kind)
+	}
+}
@@ -0,0 +1,84 @@
+package raceway
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Transport returns an http.RoundTripper that propagates the Raceway context of
+// each request's context to the downstream service. For every outbound call it
+// records an HttpRequest event, injects traceparent, tracestate, and
+// raceway-clock headers carrying that event's clock, and records an HttpResponse
+// event with the status and duration. Requests without a Raceway context pass
+// through untouched. If base is nil, http.DefaultTransport is used.
+//
+// Example:
+//
+//	httpClient := &http.Client{Transport: client.Transport(nil)}
+//	req, _ := http.NewRequestWithContext(ctx, "GET", "http://inventory/items", nil)
+//	resp, err := httpClient.Do(req)
+func (c *Client) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if BuildDisabled || c.disabled {
+		return base
+	}
+	return &transport{client: c, base: base}
+}
+
+// WrapHTTPClient returns a copy of hc whose transport propagates Raceway context.
+func (c *Client) WrapHTTPClient(hc *http.Client) *http.Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	wrapped := *hc
+	wrapped.Transport = c.Transport(hc.Transport)
+	return &wrapped
+}
+
+type transport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	rctx := FromContext(ctx)
+	if rctx == nil {
+		return t.base.RoundTrip(req)
+	}
+	c := t.client
+
+	c.captureEvent(ctx, EventKind{
+		HTTPRequest: &HTTPRequestData{
+			Method:  req.Method,
+			URL:     req.URL.Redacted(),
+			Headers: make(map[string]string),
+		},
+	})
+
+	// The request event already advanced the clock for this call, so the
+	// headers carry its vector as-is.
+	// A RoundTripper must not modify the caller's request.
+	outbound := req.Clone(ctx)
+	c.contextHeaders(HeaderCarrier(outbound.Header), rctx, nil)
+	rctx.markDistributed()
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(outbound)
+	duration := time.Since(start)
+	if err != nil {
+		c.TrackError(ctx, "HttpTransportError", fmt.Sprintf("%s %s: %v", req.Method, req.URL.Redacted(), err), nil)
+		return resp, err
+	}
+
+	if raw := resp.Header.Get(racewayClockHeader); raw != "" {
+		if echoed, ok := parseRacewayClock(raw); ok && echoed.traceID == rctx.TraceID {
+			rctx.mergeClock(echoed.clock)
+		}
+	}
+	c.trackHTTPResponse(ctx, resp.StatusCode, nil, nil, duration, nil)
+	return resp, nil
+}
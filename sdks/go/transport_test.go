@@ -0,0 +1,108 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransportInjectsHeadersAndTracksCall(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "cart", nil, 1, "transport_test.go:1", "Write")
+	before := clockValue(FromContext(ctx).ClockVector, "test-service#test-instance")
+
+	httpClient := c.WrapHTTPClient(server.Client())
+	req, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/orders", nil)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if received.Get(traceparentHeader) == "" || received.Get(racewayClockHeader) == "" {
+		t.Fatalf("expected propagation headers on the wire, got %v", received)
+	}
+	if req.Header.Get(traceparentHeader) != "" {
+		t.Errorf("transport must not modify the caller's request")
+	}
+
+	parsed := ParseIncomingHeaders(received, "downstream", "instance")
+	if parsed.TraceID != FromContext(ctx).TraceID {
+		t.Errorf("propagated trace %s, want %s", parsed.TraceID, FromContext(ctx).TraceID)
+	}
+	if got := clockValue(parsed.ClockVector, "test-service#test-instance"); got != before+1 {
+		t.Errorf("propagated clock = %d, want exactly one increment from %d", got, before)
+	}
+
+	events := bufferedEvents(c)
+	request, response := events[1], events[2]
+	if request.Kind.HTTPRequest == nil || request.Kind.HTTPRequest.Method != "POST" {
+		t.Fatalf("expected outbound HttpRequest event, got %+v", request.Kind)
+	}
+	if clockValue(request.CausalityVector, "test-service#test-instance") != before+1 {
+		t.Errorf("request event clock should match the propagated clock")
+	}
+	if response.Kind.HTTPResponse == nil || response.Kind.HTTPResponse.Status != http.StatusAccepted {
+		t.Errorf("expected HttpResponse event with status, got %+v", response.Kind)
+	}
+	if response.Metadata.DurationNs == nil || *response.Metadata.DurationNs <= 0 {
+		t.Errorf("expected the round trip's duration in metadata, got %v", response.Metadata.DurationNs)
+	}
+}
+
+func TestTransportPassesThroughWithoutContext(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	resp, err := c.WrapHTTPClient(server.Client()).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if received.Get(traceparentHeader) != "" || received.Get(racewayClockHeader) != "" {
+		t.Errorf("expected no propagation headers without a context, got %v", received)
+	}
+	if len(bufferedEvents(c)) != 0 {
+		t.Errorf("expected no events without a context")
+	}
+}
+
+type failingRoundTripper struct{}
+
+func (failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTransportRecordsErrors(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://inventory.invalid/items", nil)
+	if _, err := c.Transport(failingRoundTripper{}).RoundTrip(req); err == nil {
+		t.Fatal("expected transport error")
+	}
+
+	events := bufferedEvents(c)
+	if len(events) != 2 || events[1].Kind.Error == nil || events[1].Kind.Error.ErrorType != "HttpTransportError" {
+		t.Errorf("expected request and error events, got %+v", events)
+	}
+}
@@ -12,6 +12,17 @@ type Metadata struct {
 	InstanceID         *string `json:"instance_id,omitempty"`
 	DistributedSpanID  *string `json:"distributed_span_id,omitempty"`
 	UpstreamSpanID     *string `json:"upstream_span_id,omitempty"`
+	Region             *string `json:"region,omitempty"`
+	// Links are the other traces this event relates to, added with AddLink
+	Links []Link `json:"links,omitempty"`
+}
+
+// Link relates an event to a span of another trace, such as the trace that
+// created an item a batch job processes.
+type Link struct {
+	TraceID      string `json:"trace_id"`
+	SpanID       string `json:"span_id,omitempty"`
+	Relationship string `json:"relationship"`
 }
 
 // CausalityEntry represents a single entry in the causality vector.
@@ -58,6 +69,21 @@ type Event struct {
 	Metadata        Metadata         `json:"metadata"`
 	CausalityVector []CausalityEntry `json:"causality_vector"`
 	LockSet         []string         `json:"lock_set"`
+	// Seq is the event's capture order within the Client, starting at 1. It
+	// orders events of one process even when timestamps tie or the wall
+	// clock steps backwards.
+	Seq uint64 `json:"seq"`
+
+	// encoded caches the event's JSON encoding during a flush
+	encoded []byte
+	// live holds tracked values retained in Debug mode for mutation detection
+	live []liveValue
+	// attempted is set once the server sink has posted the event, so a resend
+	// is flagged as a replay in the batch envelope
+	attempted bool
+	// pendingRoutes lists the routes a requeued event failed on, by index
+	// into the router's routes with the default route last
+	pendingRoutes []int
 }
 
 // EventKind represents the different types of events.
@@ -68,11 +94,65 @@ type EventKind struct {
 	FunctionReturn *FunctionReturnData `json:"FunctionReturn,omitempty"`
 	AsyncSpawn     *AsyncSpawnData     `json:"AsyncSpawn,omitempty"`
 	AsyncAwait     *AsyncAwaitData     `json:"AsyncAwait,omitempty"`
+	AsyncJoin      *AsyncJoinData      `json:"AsyncJoin,omitempty"`
 	LockAcquire    *LockAcquireData    `json:"LockAcquire,omitempty"`
 	LockRelease    *LockReleaseData    `json:"LockRelease,omitempty"`
+	LockContention *LockContentionData `json:"LockContention,omitempty"`
 	HTTPRequest    *HTTPRequestData    `json:"HttpRequest,omitempty"`
 	HTTPResponse   *HTTPResponseData   `json:"HttpResponse,omitempty"`
 	Error          *ErrorData          `json:"Error,omitempty"`
+	AntiPattern    *AntiPatternData    `json:"AntiPattern,omitempty"`
+	Fence          *FenceData          `json:"Fence,omitempty"`
+	Annotation     *AnnotationData     `json:"Annotation,omitempty"`
+	Custom         *CustomData         `json:"Custom,omitempty"`
+	CacheOp        *CacheOpData        `json:"CacheOp,omitempty"`
+	ExternalCall   *ExternalCallData   `json:"ExternalCall,omitempty"`
+	Transaction    *TransactionData    `json:"Transaction,omitempty"`
+}
+
+// Name returns the wire name of the populated variant, e.g. "StateChange" or "HttpRequest".
+func (k EventKind) Name() string {
+	switch {
+	case k.StateChange != nil:
+		return "StateChange"
+	case k.FunctionCall != nil:
+		return "FunctionCall"
+	case k.FunctionReturn != nil:
+		return "FunctionReturn"
+	case k.AsyncSpawn != nil:
+		return "AsyncSpawn"
+	case k.AsyncAwait != nil:
+		return "AsyncAwait"
+	case k.AsyncJoin != nil:
+		return "AsyncJoin"
+	case k.LockAcquire != nil:
+		return "LockAcquire"
+	case k.LockRelease != nil:
+		return "LockRelease"
+	case k.LockContention != nil:
+		return "LockContention"
+	case k.HTTPRequest != nil:
+		return "HttpRequest"
+	case k.HTTPResponse != nil:
+		return "HttpResponse"
+	case k.Error != nil:
+		return "Error"
+	case k.AntiPattern != nil:
+		return "AntiPattern"
+	case k.Fence != nil:
+		return "Fence"
+	case k.Annotation != nil:
+		return "Annotation"
+	case k.Custom != nil:
+		return "Custom"
+	case k.CacheOp != nil:
+		return "CacheOp"
+	case k.ExternalCall != nil:
+		return "ExternalCall"
+	case k.Transaction != nil:
+		return "Transaction"
+	}
+	return ""
 }
 
 // StateChangeData represents a read or write to a variable.
@@ -82,6 +162,16 @@ type StateChangeData struct {
 	NewValue   interface{} `json:"new_value"`
 	Location   string      `json:"location"`
 	AccessType string      `json:"access_type"`
+	// Container, Key, and Field describe a structured access recorded by
+	// TrackMapAccess or TrackFieldAccess; Variable holds the same access in
+	// its canonical "container[key].field" form.
+	Container string  `json:"container,omitempty"`
+	Key       *string `json:"key,omitempty"`
+	Field     string  `json:"field,omitempty"`
+	// ResourceType and ResourceID, with Field, describe an access recorded
+	// by TrackResourceChange; Variable holds its "type/id/field" name.
+	ResourceType string  `json:"resource_type,omitempty"`
+	ResourceID   *string `json:"resource_id,omitempty"`
 }
 
 // FunctionCallData represents a function entry.
@@ -114,11 +204,23 @@ type AsyncAwaitData struct {
 	AwaitedAt string `json:"awaited_at"`
 }
 
+// AsyncJoinData represents observing the completion of a goroutine, such as
+// returning from WaitGroup.Wait or receiving its result. ChildClock is the
+// goroutine's final clock vector.
+type AsyncJoinData struct {
+	TaskID     string           `json:"task_id"`
+	JoinedAt   string           `json:"joined_at"`
+	ChildClock []CausalityEntry `json:"child_clock"`
+}
+
 // LockAcquireData represents acquiring a lock.
 type LockAcquireData struct {
 	LockID   string `json:"lock_id"`
 	LockType string `json:"lock_type"`
 	Location string `json:"location"`
+	// WaitNs is how long the acquisition waited, when it was observed by
+	// WithLock, WithLockTimeout, or a TrackedMutex
+	WaitNs *int64 `json:"wait_ns,omitempty"`
 }
 
 // LockReleaseData represents releasing a lock.
@@ -126,6 +228,19 @@ type LockReleaseData struct {
 	LockID   string `json:"lock_id"`
 	LockType string `json:"lock_type"`
 	Location string `json:"location"`
+	// HeldNs is how long the lock was held, when its acquisition was
+	// recorded with the same context
+	HeldNs *int64 `json:"held_ns,omitempty"`
+}
+
+// LockContentionData records a lock acquisition that waited at least
+// Config.LockContentionThreshold, or that timed out.
+type LockContentionData struct {
+	LockID   string `json:"lock_id"`
+	LockType string `json:"lock_type"`
+	WaitNs   int64  `json:"wait_ns"`
+	TimedOut bool   `json:"timed_out"`
+	Location string `json:"location"`
 }
 
 // HTTPRequestData represents an HTTP request.
@@ -150,3 +265,84 @@ type ErrorData struct {
 	Message    string   `json:"message"`
 	StackTrace []string `json:"stack_trace"`
 }
+
+// AntiPatternData is a warning about a known race-prone access pattern.
+type AntiPatternData struct {
+	Pattern   string   `json:"pattern"`
+	Variable  string   `json:"variable"`
+	LockID    string   `json:"lock_id"`
+	Message   string   `json:"message"`
+	EventIDs  []string `json:"event_ids"`
+	Locations []string `json:"locations"`
+}
+
+// Fence directions reported in FenceData.Direction.
+const (
+	FenceFull    = "full"
+	FenceAcquire = "acquire"
+	FenceRelease = "release"
+)
+
+// FenceData records out-of-band synchronization the SDK cannot observe directly,
+// such as a consumer group rebalance barrier or a leader-election fence.
+type FenceData struct {
+	Name      string `json:"name"`
+	Epoch     uint64 `json:"epoch"`
+	Direction string `json:"direction"`
+	Location  string `json:"location"`
+}
+
+// AnnotationData is a note added by a human investigator or by code, such as
+// "feature flag flipped". Out-of-band annotations have no causal position.
+type AnnotationData struct {
+	Message   string            `json:"message"`
+	Attrs     map[string]string `json:"attrs"`
+	OutOfBand bool              `json:"out_of_band"`
+	Location  string            `json:"location"`
+}
+
+// CustomData is an application-defined event, such as "cache_invalidation",
+// recorded with TrackCustom.
+type CustomData struct {
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// CacheOpData records an operation on a cache such as Redis, recorded with
+// TrackCacheOp. It is an access to the variable System:Key, like a
+// StateChange with AccessType derived from Operation.
+type CacheOpData struct {
+	System     string `json:"system"`
+	Operation  string `json:"operation"`
+	Key        string `json:"key"`
+	AccessType string `json:"access_type"`
+	// Hit reports whether a read found the key, when known
+	Hit        *bool  `json:"hit,omitempty"`
+	DurationNs int64  `json:"duration_ns"`
+	Location   string `json:"location"`
+}
+
+// ExternalCallData records a call to a third-party API, recorded with
+// TrackExternalCall.
+type ExternalCallData struct {
+	System     string `json:"system"`
+	Endpoint   string `json:"endpoint"`
+	Operation  string `json:"operation"`
+	Status     string `json:"status"`
+	DurationNs int64  `json:"duration_ns"`
+	Location   string `json:"location"`
+}
+
+// TransactionData records the begin, commit, or rollback of a transaction
+// opened with WithTransaction. On commit and rollback, Resources lists the
+// variables accessed within the transaction, including within transactions
+// nested in it.
+type TransactionData struct {
+	TxID  string `json:"tx_id"`
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+	// ParentTxID is the enclosing transaction of a nested one
+	ParentTxID *string  `json:"parent_tx_id,omitempty"`
+	Resources  []string `json:"resources,omitempty"`
+	Location   string   `json:"location"`
+}
@@ -0,0 +1,91 @@
+package raceway
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// updatableFields are the Config fields UpdateConfig may change.
+var updatableFields = map[string]bool{
+	"SampleRate":    true,
+	"Debug":         true,
+	"FlushInterval": true,
+}
+
+// UpdateConfig changes the client's configuration while it runs. fn receives
+// a copy of the current configuration and may change SampleRate, Debug and
+// FlushInterval; the changes apply together once fn returns. If fn changes
+// any other field, such as ServiceName, InstanceID or Endpoint, nothing is
+// applied and an error is returned. Maps and slices in the copy are shared
+// with the client and must not be modified in place.
+//
+// A new SampleRate applies to traces whose sampling is decided afterwards;
+// traces already in progress keep their decision. A new FlushInterval
+// restarts the auto-flush timer.
+//
+// Example:
+//
+//	err := client.UpdateConfig(func(cfg *raceway.Config) {
+//	    cfg.SampleRate = 0.1
+//	    cfg.Debug = true
+//	})
+func (c *Client) UpdateConfig(fn func(cfg *Config)) error {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	current := c.live.Load()
+	next := *current
+	fn(&next)
+	if changed := fixedFieldsChanged(current, &next); len(changed) > 0 {
+		return fmt.Errorf("raceway: UpdateConfig cannot change %s", strings.Join(changed, ", "))
+	}
+	if next.FlushInterval <= 0 {
+		return errors.New("raceway: UpdateConfig requires a positive FlushInterval")
+	}
+
+	c.live.Store(&next)
+	c.debug.Store(next.Debug)
+	if next.FlushInterval != current.FlushInterval {
+		select {
+		case c.reconfigured <- struct{}{}:
+		default:
+		}
+	}
+	c.logger.Debugf("Configuration updated: sample rate %v, debug %v, flush interval %v",
+		next.SampleRate, next.Debug, next.FlushInterval)
+	return nil
+}
+
+// fixedFieldsChanged returns the names of the fields outside updatableFields
+// that differ between a and b. Functions are compared by identity.
+func fixedFieldsChanged(a, b *Config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	var changed []string
+	for i := 0; i < va.NumField(); i++ {
+		name := va.Type().Field(i).Name
+		if updatableFields[name] {
+			continue
+		}
+		if !sameValue(va.Field(i), vb.Field(i)) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// sameValue reports whether a and b are deeply equal, comparing functions,
+// including those held in interfaces, by identity.
+func sameValue(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface && !a.IsNil() && !b.IsNil() {
+		a, b = a.Elem(), b.Elem()
+		if a.Type() != b.Type() {
+			return false
+		}
+	}
+	if a.Kind() == reflect.Func {
+		return a.Pointer() == b.Pointer()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
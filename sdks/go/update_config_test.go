@@ -0,0 +1,143 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateConfigChangesSampleRate(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.SampleRate = 1e-12 })
+	before := FromContext(c.newContext(context.Background(), ""))
+	if c.sampled(before) {
+		t.Fatal("expected the trace to be sampled out")
+	}
+
+	if err := c.UpdateConfig(func(cfg *Config) { cfg.SampleRate = 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if after := FromContext(c.newContext(context.Background(), "")); !c.sampled(after) {
+		t.Error("expected traces started after the update to be sampled")
+	}
+	if c.sampled(before) {
+		t.Error("expected the earlier trace to keep its decision")
+	}
+}
+
+func TestUpdateConfigTogglesDebugLogging(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	if debugEnabled(c.logger) {
+		t.Fatal("expected debug logging off by default")
+	}
+	if err := c.UpdateConfig(func(cfg *Config) { cfg.Debug = true }); err != nil {
+		t.Fatal(err)
+	}
+	if !debugEnabled(c.logger) || !c.debug.Load() {
+		t.Error("expected debug logging on after the update")
+	}
+}
+
+func TestUpdateConfigResetsFlushTicker(t *testing.T) {
+	sink := &recordingSink{}
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Sink = sink })
+	ctx := c.newContext(context.Background(), "")
+	id := c.TrackCustom(ctx, "step", nil)
+
+	if err := c.UpdateConfig(func(cfg *Config) { cfg.FlushInterval = 10 * time.Millisecond }); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sink.mu.Lock()
+		flushed := len(sink.events) == 1 && sink.events[0].ID == id
+		sink.mu.Unlock()
+		if flushed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the event flushed at the new interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpdateConfigRejectsFixedFields(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	err := c.UpdateConfig(func(cfg *Config) {
+		cfg.SampleRate = 0.5
+		cfg.ServiceName = "other-service"
+		cfg.InstanceID = "other-instance"
+		cfg.Endpoint = "http://elsewhere:8080"
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, field := range []string{"ServiceName", "InstanceID", "Endpoint"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("expected %s named in %q", field, err)
+		}
+	}
+	if rate := c.live.Load().SampleRate; rate != 0 {
+		t.Errorf("expected nothing applied, got sample rate %v", rate)
+	}
+
+	if err := c.UpdateConfig(func(cfg *Config) { cfg.FlushInterval = 0 }); err == nil {
+		t.Error("expected a zero FlushInterval to be rejected")
+	}
+}
+
+func TestUpdateConfigAllowsUnchangedFunctions(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Sampler = func(string, string) bool { return true }
+		cfg.OnStats = func(ClientStats) {}
+		cfg.Logger = &capturingLogger{}
+		cfg.Tags = map[string]string{"team": "payments"}
+	})
+	if err := c.UpdateConfig(func(cfg *Config) { cfg.Debug = true }); err != nil {
+		t.Errorf("expected only Debug changed, got %v", err)
+	}
+	err := c.UpdateConfig(func(cfg *Config) { cfg.Sampler = func(string, string) bool { return false } })
+	if err == nil || !strings.Contains(err.Error(), "Sampler") {
+		t.Errorf("expected a replaced Sampler to be rejected, got %v", err)
+	}
+}
+
+// TestUpdateConfigRacesWithTracking is meant for -race: updates must not
+// race with capture, sampling, flushing or logging.
+func TestUpdateConfigRacesWithTracking(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Sink = &recordingSink{} })
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				ctx := c.newContext(context.Background(), "")
+				c.TrackStateChange(ctx, "balance", 1, 2, "update_config_test.go:1", "Write")
+				c.TrackCustom(ctx, "step", nil)
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		err := c.UpdateConfig(func(cfg *Config) {
+			cfg.SampleRate = float64(i%10) / 10
+			cfg.Debug = i%2 == 0
+			cfg.FlushInterval = time.Duration(1+i%5) * time.Millisecond
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,557 @@
+package raceway
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSummaryBytes bounds the serialized size of values summarized by helpers
+// that record whole objects, such as VersionedPointer.
+const maxSummaryBytes = 1024
+
+// summarizeValue returns a JSON-safe snapshot of v that is at most limit bytes
+// when serialized. Larger or unserializable values are replaced by a short
+// description of their type and size.
+func summarizeValue(v interface{}, limit int) interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("[unserializable %T]", v)
+	}
+	if len(data) > limit {
+		return fmt.Sprintf("[%T: %d bytes]", v, len(data))
+	}
+	return json.RawMessage(data)
+}
+
+// liveValue keeps a reference to a tracked value alongside its capture-time
+// snapshot. It is only retained in Debug mode, to detect callers that mutate
+// values after tracking them.
+type liveValue struct {
+	field    string
+	value    interface{}
+	snapshot json.RawMessage
+}
+
+// snapshotValue serializes v at capture time so that no user memory is
+// retained past the Track* call. Values that cannot be serialized are replaced
+// by a description of their type.
+func (c *Client) snapshotValue(field string, v interface{}, live *[]liveValue) interface{} {
+	return c.snapshotValueWithin(field, v, valueLimits{}, live)
+}
+
+// snapshotBoundedValue is snapshotValue for tracked arguments and variable
+// values, which are bounded by Config.MaxValueBytes and
+// Config.MaxCollectionItems.
+func (c *Client) snapshotBoundedValue(field string, v interface{}, live *[]liveValue) interface{} {
+	return c.snapshotValueWithin(field, v, valueLimits{
+		maxBytes: c.maxValueBytes(),
+		maxItems: c.maxCollectionItems(),
+	}, live)
+}
+
+func (c *Client) snapshotValueWithin(field string, v interface{}, limits valueLimits, live *[]liveValue) interface{} {
+	switch v.(type) {
+	case nil, json.RawMessage:
+		return v
+	}
+	if limits.bounded() {
+		nodes := 0
+		if !limits.within(reflect.ValueOf(v), 0, &nodes) {
+			return c.snapshotBoundedCopy(field, v, limits)
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		if c.config.Strict {
+			c.strictViolation(StrictSerialization, "%s of type %T cannot be serialized: %v", field, v, err)
+		}
+		return fmt.Sprintf("[unserializable %T]", v)
+	}
+	if c.debug.Load() {
+		*live = append(*live, liveValue{field: field, value: v, snapshot: data})
+	}
+	return json.RawMessage(data)
+}
+
+// snapshotKind replaces every user-supplied value in kind with its snapshot.
+func (c *Client) snapshotKind(kind EventKind) []liveValue {
+	var live []liveValue
+	switch {
+	case kind.StateChange != nil:
+		kind.StateChange.OldValue = c.snapshotBoundedValue(kind.StateChange.Variable+" old value", kind.StateChange.OldValue, &live)
+		kind.StateChange.NewValue = c.snapshotBoundedValue(kind.StateChange.Variable+" new value", kind.StateChange.NewValue, &live)
+	case kind.FunctionCall != nil:
+		kind.FunctionCall.Args = c.snapshotBoundedValue(kind.FunctionCall.FunctionName+" args", kind.FunctionCall.Args, &live)
+	case kind.FunctionReturn != nil:
+		kind.FunctionReturn.ReturnValue = c.snapshotBoundedValue(kind.FunctionReturn.FunctionName+" return value", kind.FunctionReturn.ReturnValue, &live)
+	case kind.HTTPRequest != nil:
+		kind.HTTPRequest.Headers = copyTags(kind.HTTPRequest.Headers)
+		kind.HTTPRequest.Body = c.snapshotValue("request body", kind.HTTPRequest.Body, &live)
+	case kind.HTTPResponse != nil:
+		kind.HTTPResponse.Headers = copyTags(kind.HTTPResponse.Headers)
+		kind.HTTPResponse.Body = c.snapshotValue("response body", kind.HTTPResponse.Body, &live)
+	}
+	return live
+}
+
+// checkLiveValues re-serializes values retained in Debug mode and returns a
+// warning for each one that changed after capture. The capture-time snapshot is
+// always the value sent.
+func checkLiveValues(events []Event) []string {
+	var warnings []string
+	for i := range events {
+		for _, lv := range events[i].live {
+			data, err := json.Marshal(lv.value)
+			if err != nil || !bytes.Equal(data, lv.snapshot) {
+				warnings = append(warnings, fmt.Sprintf("%s changed after it was tracked (event %s); "+
+					"the capture-time value was recorded", lv.field, events[i].ID[:8]))
+			}
+		}
+		events[i].live = nil
+	}
+	return warnings
+}
+
+const (
+	// maxValueDepth is how deeply nested a tracked value is recorded. Deeper
+	// values are replaced by depthLimitMarker.
+	maxValueDepth = 32
+	// maxValueNodes is how many parts of a tracked value are checked against
+	// the limits before it is recorded as a bounded copy instead, and how
+	// many parts the copy holds at most.
+	maxValueNodes = 10000
+	// maxValueCopyBytes bounds the strings held by a bounded copy in all.
+	maxValueCopyBytes = 256 * 1024
+
+	// truncatedItemsKey holds how many items were left out of a slice or map
+	// longer than Config.MaxCollectionItems: the key of a map, or of an object
+	// appended to a slice.
+	truncatedItemsKey = "__truncated"
+	// cycleMarker replaces a value that contains itself.
+	cycleMarker = "[cycle]"
+	// depthLimitMarker replaces values nested deeper than maxValueDepth.
+	depthLimitMarker = "[max depth]"
+	// sizeLimitMarker replaces struct fields left once a bounded copy holds
+	// maxValueNodes parts or maxValueCopyBytes of strings.
+	sizeLimitMarker = "[max size]"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// maxValueBytes returns Config.MaxValueBytes or its default.
+func (c *Client) maxValueBytes() int {
+	if c.config.MaxValueBytes <= 0 {
+		return DefaultMaxValueBytes
+	}
+	return c.config.MaxValueBytes
+}
+
+// maxCollectionItems returns Config.MaxCollectionItems or its default.
+func (c *Client) maxCollectionItems() int {
+	if c.config.MaxCollectionItems <= 0 {
+		return DefaultMaxCollectionItems
+	}
+	return c.config.MaxCollectionItems
+}
+
+// valueLimits bounds the snapshot of a tracked value. The zero valueLimits
+// records values whole.
+type valueLimits struct {
+	// maxBytes is the longest string recorded, in bytes
+	maxBytes int
+	// maxItems is the most elements of a slice or map recorded
+	maxItems int
+}
+
+func (l valueLimits) bounded() bool {
+	return l.maxBytes > 0 && l.maxItems > 0
+}
+
+// within reports whether v can be recorded whole: it has no string longer
+// than maxBytes or collection longer than maxItems, is nested no deeper than
+// maxValueDepth, and has at most maxValueNodes parts in all. Values that
+// marshal themselves are taken as they are. The walk stops at the first limit
+// exceeded, so it is cheap for any input.
+func (l valueLimits) within(v reflect.Value, depth int, nodes *int) bool {
+	*nodes++
+	if depth > maxValueDepth || *nodes > maxValueNodes {
+		return false
+	}
+	if !v.IsValid() || marshalsItself(v) {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil() || l.within(v.Elem(), depth+1, nodes)
+	case reflect.String:
+		return v.Len() <= l.maxBytes
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodedLen(v.Len()) <= l.maxBytes
+		}
+		fallthrough
+	case reflect.Array:
+		if v.Len() > l.maxItems {
+			return false
+		}
+		for i := 0; i < v.Len(); i++ {
+			if !l.within(v.Index(i), depth+1, nodes) {
+				return false
+			}
+		}
+	case reflect.Map:
+		if v.Len() > l.maxItems {
+			return false
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if !l.within(iter.Key(), depth+1, nodes) || !l.within(iter.Value(), depth+1, nodes) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() || f.Anonymous {
+				if !l.within(v.Field(i), depth+1, nodes) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// snapshotBoundedCopy records a value that exceeds limits as a copy of it
+// built within them. The copy is encoded as json.Marshal would encode v,
+// except that:
+//
+//   - strings longer than maxBytes are cut and suffixed with "...(truncated)"
+//   - slices longer than maxItems keep their first maxItems elements and end
+//     with {"__truncated": n}, where n is the number of elements left out
+//   - maps longer than maxItems keep maxItems entries and a "__truncated": n
+//     entry
+//   - a value that contains itself is recorded as "[cycle]" where it recurs
+//   - values nested deeper than maxValueDepth are recorded as "[max depth]"
+//   - once the copy is full, further elements and entries are counted in
+//     "__truncated" and further struct fields are recorded as "[max size]"
+//   - values that cannot be serialized are described by their type
+//
+// v itself is only read. Copies are not checked by Debug mode, since they
+// differ from v by design.
+func (c *Client) snapshotBoundedCopy(field string, v interface{}, limits valueLimits) interface{} {
+	b := valueBounder{valueLimits: limits, visiting: map[visit]bool{}}
+	data, err := json.Marshal(b.bound(reflect.ValueOf(v), 0))
+	if err == nil && b.unserializable == nil {
+		return json.RawMessage(data)
+	}
+	if c.config.Strict {
+		if err == nil {
+			err = fmt.Errorf("it contains a %s", b.unserializable)
+		}
+		c.strictViolation(StrictSerialization, "%s of type %T cannot be serialized: %v", field, v, err)
+	}
+	if err != nil {
+		return fmt.Sprintf("[unserializable %T]", v)
+	}
+	return json.RawMessage(data)
+}
+
+// visit identifies a pointer, map, or slice on the path to the value being
+// copied, to detect values that contain themselves.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// valueBounder builds the bounded copy of one value.
+type valueBounder struct {
+	valueLimits
+	visiting map[visit]bool
+	// nodes and size are the parts and string bytes copied so far
+	nodes, size int
+	// unserializable is the type of the first part of the value that could
+	// not be serialized
+	unserializable reflect.Type
+}
+
+// bound returns a copy of v within the limits that json.Marshal can encode.
+func (b *valueBounder) bound(v reflect.Value, depth int) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	if depth > maxValueDepth {
+		return depthLimitMarker
+	}
+	if b.full() {
+		return sizeLimitMarker
+	}
+	b.nodes++
+	if marshalsItself(v) {
+		return b.marshal(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		return b.bound(v.Elem(), depth)
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		key := visit{ptr: v.Pointer(), typ: v.Type()}
+		if b.visiting[key] {
+			return cycleMarker
+		}
+		b.visiting[key] = true
+		defer delete(b.visiting, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		return b.bound(v.Elem(), depth+1)
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+	case reflect.String:
+		return b.cut(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return b.bytes(v.Bytes())
+		}
+		return b.items(v, depth)
+	case reflect.Array:
+		return b.items(v, depth)
+	case reflect.Map:
+		return b.entries(v, depth)
+	case reflect.Struct:
+		out := make(map[string]interface{}, v.NumField())
+		b.fields(v, out, depth)
+		return out
+	}
+	return b.unserializableValue(v.Type())
+}
+
+// full reports whether the copy holds as much as it may.
+func (b *valueBounder) full() bool {
+	return b.nodes >= maxValueNodes || b.size >= maxValueCopyBytes
+}
+
+// cut returns s, or its first maxBytes bytes up to a character boundary and
+// truncatedBodySuffix if it is longer.
+func (b *valueBounder) cut(s string) string {
+	if len(s) <= b.maxBytes {
+		b.size += len(s)
+		return s
+	}
+	b.size += b.maxBytes
+	n := b.maxBytes
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + truncatedBodySuffix
+}
+
+// bytes returns the base64 encoding of p, as json.Marshal records a []byte,
+// cut to maxBytes.
+func (b *valueBounder) bytes(p []byte) string {
+	suffix := ""
+	if n := base64.StdEncoding.DecodedLen(b.maxBytes); len(p) > n {
+		p, suffix = p[:n], truncatedBodySuffix
+	}
+	b.size += base64.StdEncoding.EncodedLen(len(p))
+	return base64.StdEncoding.EncodeToString(p) + suffix
+}
+
+// items copies the first maxItems elements of a slice or array.
+func (b *valueBounder) items(v reflect.Value, depth int) []interface{} {
+	n := v.Len()
+	if n > b.maxItems {
+		n = b.maxItems
+	}
+	out := make([]interface{}, 0, n+1)
+	for i := 0; i < n && !b.full(); i++ {
+		out = append(out, b.bound(v.Index(i), depth+1))
+	}
+	if rest := v.Len() - len(out); rest > 0 {
+		out = append(out, map[string]int{truncatedItemsKey: rest})
+	}
+	return out
+}
+
+// entries copies maxItems entries of a map. Keys are recorded as
+// json.Marshal records them; entries with other keys are left out.
+func (b *valueBounder) entries(v reflect.Value, depth int) map[string]interface{} {
+	n := v.Len()
+	if n > b.maxItems {
+		n = b.maxItems
+	}
+	out := make(map[string]interface{}, n+1)
+	copied := 0
+	iter := v.MapRange()
+	for copied < n && !b.full() && iter.Next() {
+		key, ok := mapKey(iter.Key())
+		if !ok {
+			continue
+		}
+		out[b.cut(key)] = b.bound(iter.Value(), depth+1)
+		copied++
+	}
+	if rest := v.Len() - copied; rest > 0 {
+		out[truncatedItemsKey] = rest
+	}
+	return out
+}
+
+// mapKey returns the string json.Marshal uses for the map key k.
+func mapKey(k reflect.Value) (string, bool) {
+	if k.Kind() == reflect.String {
+		return k.String(), true
+	}
+	if k.Type().Implements(textMarshalerType) && k.CanInterface() {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", true
+		}
+		text, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err == nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), true
+	}
+	return "", false
+}
+
+// fields copies the fields of struct v that json.Marshal records into out,
+// under their JSON names. Fields of embedded structs are promoted unless a
+// shallower field has the same name.
+func (b *valueBounder) fields(v reflect.Value, out map[string]interface{}, depth int) {
+	t := v.Type()
+	var embedded []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if !fv.IsNil() {
+					embedded = append(embedded, reflect.Indirect(fv))
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && isEmptyValue(fv) {
+			continue
+		}
+		if _, ok := out[name]; !ok {
+			out[name] = b.bound(fv, depth+1)
+		}
+	}
+	for _, ev := range embedded {
+		promoted := make(map[string]interface{})
+		b.fields(ev, promoted, depth)
+		for name, value := range promoted {
+			if _, ok := out[name]; !ok {
+				out[name] = value
+			}
+		}
+	}
+}
+
+// isEmptyValue reports whether v is left out of a struct by omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Struct:
+		return false
+	}
+	return v.IsZero()
+}
+
+// marshalsItself reports whether json.Marshal encodes v with its MarshalJSON
+// or MarshalText method.
+func marshalsItself(v reflect.Value) bool {
+	if !v.CanInterface() {
+		return false
+	}
+	t := v.Type()
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return true
+	}
+	if v.CanAddr() {
+		pt := reflect.PointerTo(t)
+		return pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType)
+	}
+	return false
+}
+
+// marshal encodes v, which marshals itself. Encodings longer than maxBytes
+// are recorded as a cut string: of the encoded string if v encodes as one,
+// or else of the encoding.
+func (b *valueBounder) marshal(v reflect.Value) interface{} {
+	if v.Kind() != reflect.Pointer && v.CanAddr() {
+		v = v.Addr()
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return b.unserializableValue(v.Type())
+	}
+	if len(data) > b.maxBytes {
+		var text string
+		if data[0] == '"' && json.Unmarshal(data, &text) == nil {
+			return b.cut(text)
+		}
+		return b.cut(string(data))
+	}
+	b.size += len(data)
+	return json.RawMessage(data)
+}
+
+// unserializableValue describes a part of the value of type t that cannot be
+// serialized.
+func (b *valueBounder) unserializableValue(t reflect.Type) string {
+	if b.unserializable == nil {
+		b.unserializable = t
+	}
+	return fmt.Sprintf("[unserializable %s]", t)
+}
@@ -0,0 +1,309 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type trackedAccount struct {
+	Owner   string `json:"owner"`
+	Balance int    `json:"balance"`
+}
+
+func TestTrackedValuesReflectCaptureTimeState(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	account := &trackedAccount{Owner: "alice", Balance: 100}
+	before := *account
+	account.Balance = 50
+	c.TrackStateChange(ctx, "accounts[alice]", &before, account, "bank.go:10", "Write")
+	account.Balance = 0
+
+	change := bufferedEvents(c)[0].Kind.StateChange
+	var oldValue, newValue trackedAccount
+	json.Unmarshal(change.OldValue.(json.RawMessage), &oldValue)
+	json.Unmarshal(change.NewValue.(json.RawMessage), &newValue)
+	if oldValue.Balance != 100 || newValue.Balance != 50 {
+		t.Errorf("recorded %d -> %d, want 100 -> 50", oldValue.Balance, newValue.Balance)
+	}
+}
+
+func TestTrackedValuesDoNotRaceWithFlush(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	c.router.fallback.route.Sink = &recordingSink{}
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	var mu sync.Mutex
+	account := &trackedAccount{Owner: "alice"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			c.Flush()
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		mu.Lock()
+		account.Balance++
+		c.TrackStateChange(ctx, "accounts[alice]", nil, account, "bank.go:20", "Write")
+		mu.Unlock()
+	}
+	<-done
+	c.Flush()
+}
+
+func TestUnserializableValuesAreDescribed(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackFunctionCall(ctx, "consume", "queue", make(chan int), "queue.go", 1)
+
+	if got := bufferedEvents(c)[0].Kind.FunctionCall.Args; got != "[unserializable chan int]" {
+		t.Errorf("unexpected args %v", got)
+	}
+}
+
+func TestDebugDetectsValuesMutatedAfterCapture(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.Debug = true })
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	stable := &trackedAccount{Owner: "bob", Balance: 1}
+	mutated := &trackedAccount{Owner: "alice", Balance: 1}
+	c.TrackStateChange(ctx, "accounts[bob]", nil, stable, "bank.go:30", "Write")
+	c.TrackStateChange(ctx, "accounts[alice]", nil, mutated, "bank.go:31", "Write")
+	mutated.Balance = 2
+
+	warnings := checkLiveValues(bufferedEvents(c))
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "accounts[alice] new value") {
+		t.Errorf("expected one warning for accounts[alice], got %v", warnings)
+	}
+}
+
+func TestLiveValuesOnlyRetainedInDebug(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackStateChange(ctx, "counter", 0, 1, "counter.go:1", "Write")
+	if live := bufferedEvents(c)[0].live; live != nil {
+		t.Errorf("expected no retained values outside Debug, got %d", len(live))
+	}
+}
+
+// trackedArgs tracks a call with args and returns the recorded args, decoded.
+func trackedArgs(t *testing.T, c *Client, args interface{}) interface{} {
+	t.Helper()
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackFunctionCall(ctx, "import", "rows", args, "import.go", 1)
+	events := bufferedEvents(c)
+	raw, ok := events[len(events)-1].Kind.FunctionCall.Args.(json.RawMessage)
+	if !ok {
+		t.Fatalf("expected serialized args, got %v", events[len(events)-1].Kind.FunctionCall.Args)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("recorded args are not JSON: %v", err)
+	}
+	return decoded
+}
+
+type importedRow struct {
+	ID       int               `json:"id"`
+	Note     string            `json:"note,omitempty"`
+	Secret   string            `json:"-"`
+	Tags     []string          `json:"tags"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Imported time.Time         `json:"imported"`
+	internal int
+}
+
+type rowNode struct {
+	Name string   `json:"name"`
+	Next *rowNode `json:"next"`
+}
+
+func TestLargeValuesAreBounded(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.MaxValueBytes = 8
+		cfg.MaxCollectionItems = 3
+	})
+
+	tests := []struct {
+		name string
+		args interface{}
+		want string
+	}{
+		{"string", "abcdefghijk", `"abcdefgh...(truncated)"`},
+		{"multibyte string", "aéééé", `"aééé...(truncated)"`},
+		{"bytes", []byte("abcdefghijk"), `"YWJjZGVm...(truncated)"`},
+		{"slice", []int{1, 2, 3, 4, 5}, `[1,2,3,{"__truncated":2}]`},
+		{"array", [5]int{1, 2, 3, 4, 5}, `[1,2,3,{"__truncated":2}]`},
+		{"map", map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}, `{"__truncated":1}`},
+		{"nested", map[string]interface{}{"rows": []string{"short", "a long note"}}, `{"rows":["short","a long n...(truncated)"]}`},
+		{"struct", importedRow{ID: 1, Secret: "s", Tags: []string{"a", "b", "c", "d"}, Imported: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			`{"id":1,"imported":"2024-01-...(truncated)","tags":["a","b","c",{"__truncated":1}]}`},
+		{"unserializable", []interface{}{1, 2, 3, make(chan int)}, `[1,2,3,{"__truncated":1}]`},
+		{"unserializable part", []interface{}{"long string", make(chan int)}, `["long str...(truncated)","[unserializable chan int]"]`},
+	}
+	for _, tt := range tests {
+		got := trackedArgs(t, c, tt.args)
+		if tt.name == "map" {
+			// Which entries are kept is unspecified
+			entries, _ := got.(map[string]interface{})
+			if len(entries) != 4 || entries[truncatedItemsKey] != float64(1) {
+				t.Errorf("%s: recorded %v", tt.name, got)
+			}
+			continue
+		}
+		data, _ := json.Marshal(got)
+		if string(data) != tt.want {
+			t.Errorf("%s: recorded %s, want %s", tt.name, data, tt.want)
+		}
+	}
+}
+
+func TestSelfReferencingValuesAreRecordedOnce(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	ring := &rowNode{Name: "a", Next: &rowNode{Name: "b"}}
+	ring.Next.Next = ring
+	got := trackedArgs(t, c, ring)
+	want := map[string]interface{}{"name": "a", "next": map[string]interface{}{"name": "b", "next": cycleMarker}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+
+	// A value reached twice without recurring is recorded twice
+	shared := &rowNode{Name: "shared"}
+	pair := []interface{}{shared, shared, ring}
+	got = trackedArgs(t, c, pair)
+	if items := got.([]interface{}); !reflect.DeepEqual(items[0], items[1]) || items[0].(map[string]interface{})["name"] != "shared" {
+		t.Errorf("recorded %v", got)
+	}
+
+	self := map[string]interface{}{"name": "self"}
+	self["self"] = self
+	got = trackedArgs(t, c, self)
+	if want := map[string]interface{}{"name": "self", "self": cycleMarker}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+}
+
+func TestDeeplyNestedValuesAreCut(t *testing.T) {
+	c := newBufferingClient(t, nil)
+
+	var deep interface{} = "bottom"
+	for i := 0; i < 100; i++ {
+		deep = []interface{}{deep}
+	}
+	got := trackedArgs(t, c, deep)
+	depth := 0
+	for {
+		items, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		got = items[0]
+		depth++
+	}
+	if got != depthLimitMarker || depth != maxValueDepth+1 {
+		t.Errorf("recorded %v at depth %d, want %q at depth %d", got, depth, depthLimitMarker, maxValueDepth+1)
+	}
+}
+
+func TestBoundedValuesLeaveTheValueUnchanged(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) { cfg.MaxCollectionItems = 2 })
+
+	rows := []importedRow{
+		{ID: 1, Tags: []string{"a", "b", "c"}, Attrs: map[string]string{"x": "1", "y": "2", "z": "3"}},
+		{ID: 2, Note: strings.Repeat("n", DefaultMaxValueBytes+1)},
+		{ID: 3},
+	}
+	want := make([]importedRow, len(rows))
+	for i, row := range rows {
+		want[i] = row
+		want[i].Tags = append([]string(nil), row.Tags...)
+		want[i].Attrs = map[string]string{}
+		for k, v := range row.Attrs {
+			want[i].Attrs[k] = v
+		}
+		if row.Attrs == nil {
+			want[i].Attrs = nil
+		}
+		if row.Tags == nil {
+			want[i].Tags = nil
+		}
+	}
+	trackedArgs(t, c, rows)
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("tracking changed the value")
+	}
+}
+
+func TestSmallValuesAreRecordedWhole(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	row := importedRow{ID: 1, Note: strings.Repeat("n", DefaultMaxValueBytes), Tags: make([]string, DefaultMaxCollectionItems)}
+	c.TrackStateChange(ctx, "row", nil, row, "import.go:1", "Write")
+
+	want, _ := json.Marshal(row)
+	if got := bufferedEvents(c)[0].Kind.StateChange.NewValue; !reflect.DeepEqual(got, json.RawMessage(want)) {
+		t.Errorf("expected the value recorded as json.Marshal encodes it")
+	}
+}
+
+func TestStrictReportsUnserializablePartsOfLargeValues(t *testing.T) {
+	c := newBufferingClient(t, func(cfg *Config) {
+		cfg.Strict = true
+		cfg.MaxValueBytes = 4
+	})
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "chan int") {
+			t.Errorf("expected a strict violation naming the channel, got %v", r)
+		}
+	}()
+	c.TrackFunctionCall(ctx, "consume", "queue", []interface{}{"a long string", make(chan int)}, "queue.go", 1)
+}
+
+// BenchmarkSnapshotLargeValue tracks 50MB of nested maps and strings, which
+// is recorded as a bounded copy in time independent of its size.
+func BenchmarkSnapshotLargeValue(b *testing.B) {
+	config := DefaultConfig()
+	config.ServiceName = "bench-service"
+	config.Routes = []Route{{Name: "discard", Match: RouteMatch{Kinds: []string{"FunctionCall"}}, Sink: discardSink{}}}
+	c := New(config)
+	defer c.Shutdown()
+	ctx := NewContext(context.Background(), "", "bench-service", "bench-instance")
+
+	chunk := strings.Repeat("x", 50*1024)
+	level := func(child interface{}) map[string]interface{} {
+		m := make(map[string]interface{}, 32)
+		for i := 0; i < 31; i++ {
+			m[strconv.Itoa(i)] = chunk
+		}
+		m["child"] = child
+		return m
+	}
+	var value interface{} = chunk
+	for i := 0; i < 32; i++ {
+		value = level(value)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.TrackFunctionCall(ctx, "import", "rows", value, "import.go", 1)
+	}
+}
@@ -0,0 +1,126 @@
+package raceway
+
+import (
+	"context"
+	"strings"
+)
+
+// keyEscaper and fieldEscaper backslash-escape the characters that would make
+// a key or field name ambiguous in a canonical variable name.
+var (
+	keyEscaper   = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+	fieldEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `.`, `\.`)
+)
+
+// ElementName returns the canonical variable name of the element at key in
+// container, "container[key]". Backslashes and brackets in key are escaped
+// with a backslash; container is used as is, so it may itself be a name
+// returned by ElementName or FieldName:
+//
+//	raceway.ElementName("accounts", "alice")                           // accounts[alice]
+//	raceway.ElementName(raceway.ElementName("tenants", "acme"), "bob") // tenants[acme][bob]
+func ElementName(container, key string) string {
+	return container + "[" + keyEscaper.Replace(key) + "]"
+}
+
+// FieldName returns the canonical variable name of field of object,
+// "object.field". Backslashes, brackets, and dots in field are escaped with a
+// backslash; object is used as is:
+//
+//	raceway.FieldName(raceway.ElementName("accounts", "alice"), "balance") // accounts[alice].balance
+func FieldName(object, field string) string {
+	return object + "." + fieldEscaper.Replace(field)
+}
+
+// TrackMapAccess tracks a read or write to the element at key in container,
+// such as a map entry or slice index. The event's Variable is
+// ElementName(container, key), and its Container and Key are recorded
+// separately so accesses to the same element match regardless of how the
+// call site spells them. The caller's location is recorded.
+func (c *Client) TrackMapAccess(ctx context.Context, container, key string, oldValue, newValue interface{}, accessType string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	c.captureEvent(ctx, EventKind{
+		StateChange: &StateChangeData{
+			Variable:   ElementName(container, key),
+			OldValue:   oldValue,
+			NewValue:   newValue,
+			Location:   c.captureLocation(2),
+			AccessType: accessType,
+			Container:  container,
+			Key:        &key,
+		},
+	})
+}
+
+// TrackFieldAccess tracks a read or write to field of object. The event's
+// Variable is FieldName(object, field). When object is an element name such
+// as ElementName("accounts", "alice"), the element's container and key are
+// recorded along with the field; otherwise object is recorded as the
+// container. The caller's location is recorded.
+//
+// Example:
+//
+//	client.TrackFieldAccess(ctx, raceway.ElementName("accounts", from), "balance", old, updated, "Write")
+func (c *Client) TrackFieldAccess(ctx context.Context, object, field string, oldValue, newValue interface{}, accessType string) {
+	if BuildDisabled || c.disabled {
+		return
+	}
+	data := &StateChangeData{
+		Variable:   FieldName(object, field),
+		OldValue:   oldValue,
+		NewValue:   newValue,
+		Location:   c.captureLocation(2),
+		AccessType: accessType,
+		Container:  object,
+		Field:      field,
+	}
+	if container, key, ok := splitElementName(object); ok {
+		data.Container, data.Key = container, &key
+	}
+	c.captureEvent(ctx, EventKind{StateChange: data})
+}
+
+// splitElementName splits an element name built by ElementName into its
+// container and unescaped key.
+func splitElementName(name string) (container, key string, ok bool) {
+	if !strings.HasSuffix(name, "]") || escapedAt(name, len(name)-1) {
+		return "", "", false
+	}
+	for i := len(name) - 2; i >= 0; i-- {
+		if name[i] == '[' && !escapedAt(name, i) {
+			if i == 0 {
+				return "", "", false
+			}
+			return name[:i], unescapeName(name[i+1 : len(name)-1]), true
+		}
+	}
+	return "", "", false
+}
+
+// escapedAt reports whether the byte at i is preceded by an odd number of
+// backslashes.
+func escapedAt(s string, i int) bool {
+	n := 0
+	for i--; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescapeName removes the backslash escapes added by ElementName and FieldName.
+func unescapeName(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalVariableNames(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{ElementName("accounts", "alice"), "accounts[alice]"},
+		{FieldName(ElementName("accounts", "alice"), "balance"), "accounts[alice].balance"},
+		{ElementName(ElementName("tenants", "acme"), "bob"), "tenants[acme][bob]"},
+		{FieldName(FieldName("config", "limits"), "daily"), "config.limits.daily"},
+		{ElementName("accounts", "a]b"), `accounts[a\]b]`},
+		{ElementName("accounts", "a[b"), `accounts[a\[b]`},
+		{ElementName("paths", `C:\tmp\`), `paths[C:\\tmp\\]`},
+		{ElementName("hosts", "api.internal"), "hosts[api.internal]"},
+		{FieldName("headers", "x.y[0]"), `headers.x\.y\[0\]`},
+		{ElementName("accounts", ""), "accounts[]"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %s, want %s", tt.got, tt.want)
+		}
+	}
+}
+
+func TestSplitElementNameRoundTrips(t *testing.T) {
+	for _, key := range []string{"alice", "", "a]b", "a[b]", `trailing\`, `\]`, "x.y"} {
+		for _, container := range []string{"accounts", "tenants[acme]", `tenants[a\]b]`} {
+			gotContainer, gotKey, ok := splitElementName(ElementName(container, key))
+			if !ok || gotContainer != container || gotKey != key {
+				t.Errorf("split(%q, %q) = %q, %q, %v", container, key, gotContainer, gotKey, ok)
+			}
+		}
+	}
+	for _, name := range []string{"balance", "accounts.alice", `accounts\]`, "[alice]", "accounts[alice].balance"} {
+		if container, key, ok := splitElementName(name); ok {
+			t.Errorf("expected %q not to split, got %q, %q", name, container, key)
+		}
+	}
+}
+
+func TestTrackMapAndFieldAccess(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+
+	c.TrackMapAccess(ctx, "accounts", "alice", nil, 100, "Read")
+	c.TrackFieldAccess(ctx, ElementName("accounts", "a]b"), "balance", 100, 50, "Write")
+	c.TrackFieldAccess(ctx, "config", "limit", nil, 10, "Read")
+
+	events := bufferedEvents(c)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	read := events[0].Kind.StateChange
+	if read.Variable != "accounts[alice]" || read.Container != "accounts" || read.Key == nil || *read.Key != "alice" || read.Field != "" {
+		t.Errorf("unexpected map access %+v", read)
+	}
+	if !strings.HasPrefix(read.Location, "variables_test.go:") {
+		t.Errorf("expected the caller's location, got %s", read.Location)
+	}
+	write := events[1].Kind.StateChange
+	if write.Variable != `accounts[a\]b].balance` || write.Container != "accounts" || write.Key == nil || *write.Key != "a]b" || write.Field != "balance" {
+		t.Errorf("unexpected element field access %+v", write)
+	}
+	field := events[2].Kind.StateChange
+	if field.Variable != "config.limit" || field.Container != "config" || field.Key != nil || field.Field != "limit" {
+		t.Errorf("unexpected field access %+v", field)
+	}
+}
+
+func TestStructuredStateChangeReadableByOldSchema(t *testing.T) {
+	c := newBufferingClient(t, nil)
+	ctx := NewContext(context.Background(), "", "test-service", "test-instance")
+	c.TrackStateChange(ctx, "counter", 0, 1, "variables_test.go:1", "Write")
+	c.TrackFieldAccess(ctx, ElementName("accounts", "alice"), "balance", 100, 50, "Write")
+
+	events := bufferedEvents(c)
+	flat, err := json.Marshal(events[0].Kind.StateChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(flat), "container") || strings.Contains(string(flat), `"key"`) || strings.Contains(string(flat), "field") {
+		t.Errorf("expected unstructured changes to omit the new fields, got %s", flat)
+	}
+
+	// The schema known to servers before the structured fields were added
+	var old struct {
+		Variable   string      `json:"variable"`
+		OldValue   interface{} `json:"old_value"`
+		NewValue   interface{} `json:"new_value"`
+		Location   string      `json:"location"`
+		AccessType string      `json:"access_type"`
+	}
+	structured, err := json.Marshal(events[1].Kind.StateChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(structured, &old); err != nil {
+		t.Fatal(err)
+	}
+	if old.Variable != "accounts[alice].balance" || old.AccessType != "Write" {
+		t.Errorf("unexpected old-schema view %+v of %s", old, structured)
+	}
+}
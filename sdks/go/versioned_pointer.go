@@ -0,0 +1,122 @@
+package raceway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// versionTag is the tag carrying the version a VersionedPointer event observed.
+const versionTag = "version"
+
+// VersionedPointer wraps atomic.Pointer for hot-swapped values such as reloadable
+// configuration. Every Store bumps a version number, and Load/Store emit
+// StateChange events carrying that version.
+//
+// The first Load within a trace pins the observed version on the trace. Later
+// Loads in the same trace (including from derived contexts) that observe a
+// different version are tagged "torn_config_read", which is the signature of a
+// request making decisions from two configuration generations.
+//
+// Example:
+//
+//	cfg := raceway.NewVersionedPointer(client, "app.config", initial)
+//	current := cfg.Load(ctx)
+type VersionedPointer[T any] struct {
+	client   *Client
+	variable string
+	current  atomic.Pointer[versionedValue[T]]
+}
+
+type versionedValue[T any] struct {
+	value   *T
+	version uint64
+}
+
+// NewVersionedPointer creates a VersionedPointer tracked under variable, holding initial as version 1.
+func NewVersionedPointer[T any](client *Client, variable string, initial *T) *VersionedPointer[T] {
+	p := &VersionedPointer[T]{client: client, variable: variable}
+	p.current.Store(&versionedValue[T]{value: initial, version: 1})
+	return p
+}
+
+// Version returns the current version number.
+func (p *VersionedPointer[T]) Version() uint64 {
+	return p.current.Load().version
+}
+
+// Load returns the current value, pinning its version on the trace and tagging torn reads.
+func (p *VersionedPointer[T]) Load(ctx context.Context) *T {
+	return p.load(ctx, true)
+}
+
+// LoadUnpinned returns the current value without pinning or consistency checks.
+// Use it for reads that intentionally want the newest value mid-request.
+func (p *VersionedPointer[T]) LoadUnpinned(ctx context.Context) *T {
+	return p.load(ctx, false)
+}
+
+func (p *VersionedPointer[T]) load(ctx context.Context, pin bool) *T {
+	snapshot := p.current.Load()
+
+	rctx := FromContext(ctx)
+	if rctx == nil || p.client == nil {
+		return snapshot.value
+	}
+
+	// A map of tags is only built for reads that are torn or unpinned, so
+	// the usual read costs no more than recording it
+	opts := captureOptions{tagKey: versionTag, tagValue: strconv.FormatUint(snapshot.version, 10)}
+	if pin && rctx.shared != nil {
+		if pinned := rctx.shared.pinVersion(p.variable, snapshot.version); pinned != snapshot.version {
+			opts.tags = map[string]string{
+				"torn_config_read": fmt.Sprintf(`{"pinned":%d,"observed":%d}`, pinned, snapshot.version),
+			}
+		}
+	} else if !pin {
+		opts.tags = map[string]string{"unpinned": "true"}
+	}
+
+	p.client.captureEventWith(ctx, EventKind{
+		StateChange: &StateChangeData{
+			Variable:   p.variable,
+			OldValue:   nil,
+			NewValue:   summarizeValue(snapshot.value, maxSummaryBytes),
+			Location:   p.client.captureLocation(3),
+			AccessType: "Read",
+		},
+	}, opts)
+
+	return snapshot.value
+}
+
+// Store swaps in value as a new version and returns that version number.
+func (p *VersionedPointer[T]) Store(ctx context.Context, value *T) uint64 {
+	var previous *versionedValue[T]
+	var next *versionedValue[T]
+	for {
+		previous = p.current.Load()
+		next = &versionedValue[T]{value: value, version: previous.version + 1}
+		if p.current.CompareAndSwap(previous, next) {
+			break
+		}
+	}
+
+	if p.client != nil && FromContext(ctx) != nil {
+		p.client.captureEventWith(ctx, EventKind{
+			StateChange: &StateChangeData{
+				Variable:   p.variable,
+				OldValue:   summarizeValue(previous.value, maxSummaryBytes),
+				NewValue:   summarizeValue(value, maxSummaryBytes),
+				Location:   p.client.captureLocation(2),
+				AccessType: "Write",
+			},
+		}, captureOptions{tags: map[string]string{
+			versionTag:         strconv.FormatUint(next.version, 10),
+			"previous_version": strconv.FormatUint(previous.version, 10),
+		}})
+	}
+
+	return next.version
+}
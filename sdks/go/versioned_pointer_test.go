@@ -0,0 +1,164 @@
+//go:build !raceway_disabled
+
+package raceway
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type reloadableConfig struct {
+	Limit int `json:"limit"`
+}
+
+func TestVersionedPointerTornRead(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	cfg := NewVersionedPointer(client, "app.config", &reloadableConfig{Limit: 10})
+
+	request := NewContext(context.Background(), "trace-request", "test-service", "test-instance")
+	reloader := NewContext(context.Background(), "trace-reload", "test-service", "test-instance")
+
+	if got := cfg.Load(request).Limit; got != 10 {
+		t.Fatalf("expected initial limit 10, got %d", got)
+	}
+	if version := cfg.Store(reloader, &reloadableConfig{Limit: 20}); version != 2 {
+		t.Fatalf("expected version 2 after store, got %d", version)
+	}
+	if got := cfg.Load(request).Limit; got != 20 {
+		t.Fatalf("expected reloaded limit 20, got %d", got)
+	}
+
+	events := bufferedEvents(client)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+
+	first, store, second := events[0], events[1], events[2]
+	if _, torn := first.Metadata.Tags["torn_config_read"]; torn {
+		t.Error("expected first load not to be torn")
+	}
+	if store.TraceID != "trace-reload" || store.Kind.StateChange.AccessType != "Write" {
+		t.Errorf("expected write from reload trace, got %+v", store)
+	}
+	if store.Metadata.Tags["version"] != "2" || store.Metadata.Tags["previous_version"] != "1" {
+		t.Errorf("unexpected store version tags: %v", store.Metadata.Tags)
+	}
+	if got := second.Metadata.Tags["torn_config_read"]; got != `{"pinned":1,"observed":2}` {
+		t.Errorf("expected torn read tag, got %q", got)
+	}
+	if !strings.HasPrefix(second.Kind.StateChange.Location, "versioned_pointer_test.go:") {
+		t.Errorf("expected caller location, got %s", second.Kind.StateChange.Location)
+	}
+}
+
+func TestVersionedPointerPinSharedWithDerivedContexts(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	cfg := NewVersionedPointer(client, "app.config", &reloadableConfig{Limit: 1})
+
+	parent := NewContext(context.Background(), "trace-parent", "test-service", "test-instance")
+	cfg.Load(parent)
+
+	child := context.WithValue(parent, racewayContextKey, FromContext(parent).derive())
+	cfg.Store(NewContext(context.Background(), "", "test-service", "test-instance"), &reloadableConfig{Limit: 2})
+	cfg.Load(child)
+
+	other := NewContext(context.Background(), "trace-other", "test-service", "test-instance")
+	cfg.Load(other)
+
+	events := bufferedEvents(client)
+	childRead, otherRead := events[2], events[3]
+	if childRead.Metadata.ThreadID == events[0].Metadata.ThreadID {
+		t.Error("expected derived context to use its own thread")
+	}
+	if _, torn := childRead.Metadata.Tags["torn_config_read"]; !torn {
+		t.Error("expected derived context to inherit the parent's pin")
+	}
+	if _, torn := otherRead.Metadata.Tags["torn_config_read"]; torn {
+		t.Error("expected unrelated trace to pin independently")
+	}
+}
+
+func TestVersionedPointerLoadUnpinned(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	cfg := NewVersionedPointer(client, "app.config", &reloadableConfig{Limit: 1})
+
+	ctx := NewContext(context.Background(), "trace-1", "test-service", "test-instance")
+	cfg.Load(ctx)
+	cfg.Store(ctx, &reloadableConfig{Limit: 2})
+	cfg.LoadUnpinned(ctx)
+
+	events := bufferedEvents(client)
+	last := events[len(events)-1]
+	if _, torn := last.Metadata.Tags["torn_config_read"]; torn {
+		t.Error("expected unpinned load to skip consistency checks")
+	}
+	if last.Metadata.Tags["unpinned"] != "true" {
+		t.Errorf("expected unpinned tag, got %v", last.Metadata.Tags)
+	}
+}
+
+func TestVersionedPointerConcurrentStores(t *testing.T) {
+	cfg := NewVersionedPointer[reloadableConfig](nil, "app.config", &reloadableConfig{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cfg.Store(context.Background(), &reloadableConfig{Limit: i})
+		}(i)
+	}
+	wg.Wait()
+
+	if cfg.Version() != 51 {
+		t.Errorf("expected 51 versions after 50 stores, got %d", cfg.Version())
+	}
+}
+
+func TestVersionedPointerUntrackedLoadDoesNotAllocate(t *testing.T) {
+	cfg := NewVersionedPointer[reloadableConfig](nil, "app.config", &reloadableConfig{Limit: 1})
+	ctx := context.Background()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		cfg.Load(ctx)
+	})
+	if allocs != 0 {
+		t.Errorf("expected untracked load to be allocation free, got %.1f allocs", allocs)
+	}
+}
+
+func TestVersionedPointerTrackedLoadAllocations(t *testing.T) {
+	client := newBufferingClient(t, nil)
+	value := &reloadableConfig{Limit: 1}
+	cfg := NewVersionedPointer(client, "app.config", value)
+	ctx := NewContext(context.Background(), "trace-request", "test-service", "test-instance")
+	cfg.Load(ctx)
+
+	load := testing.AllocsPerRun(100, func() {
+		cfg.Load(ctx)
+	})
+	track := testing.AllocsPerRun(100, func() {
+		client.TrackStateChange(ctx, "app.config", nil, value, "", "Read")
+	})
+	if load > track {
+		t.Errorf("expected a tracked load without a swap to cost no more than tracking the read (%.1f allocs), got %.1f", track, load)
+	}
+}
+
+func BenchmarkVersionedPointerLoad(b *testing.B) {
+	config := DefaultConfig()
+	config.BatchSize = b.N + 1
+	client := New(config)
+	defer client.Shutdown()
+
+	cfg := NewVersionedPointer(client, "app.config", &reloadableConfig{Limit: 1})
+	ctx := NewContext(context.Background(), "bench-trace", "bench-service", "bench-instance")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg.Load(ctx)
+	}
+}